@@ -0,0 +1,22 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+	"os"
+)
+
+func newAbortCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "abort",
+		Short: "Delete the canary, leaving the stable deployment serving",
+		Args:  cobra.NoArgs,
+		Run: func(*cobra.Command, []string) {
+			s := prepare("abort")
+			defer s.connect()()
+			d, ctx := s.d, s.ctx
+			os.Exit(printDeleteResults(out, d, d.AbortCanary(ctx)))
+		},
+	}
+	addFlags(cmd.Flags(), objectFlags, []string{"dry-run"})
+	return cmd
+}
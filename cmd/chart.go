@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"github.com/raihankhan/ecommerceApi-client-go/pkg/deployer"
+	"github.com/spf13/cobra"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+func newGenerateChartCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "generate-chart",
+		Short: "Write a Helm chart of the built-in objects to -out",
+		Args:  cobra.NoArgs,
+		Run: func(*cobra.Command, []string) {
+			opts := prepare("generate-chart").opts
+			if *outFile == "" {
+				fail(&deployer.ValidationError{Field: "out", Err: errors.New("generate-chart needs the chart directory")})
+			}
+			files, err := deployer.Chart(opts)
+			if err != nil {
+				fail(err)
+			}
+			if err := writeChart(*outFile, files); err != nil {
+				fail(err)
+			}
+		},
+	}
+	addFlags(cmd.Flags(), objectFlags, []string{"out"})
+	return cmd
+}
+
+// writeChart writes the files of a chart, by path, under dir.
+func writeChart(dir string, files map[string][]byte) error {
+	paths := make([]string, 0, len(files))
+	for p := range files {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	for _, p := range paths {
+		path := filepath.Join(dir, filepath.FromSlash(p))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return fmt.Errorf("failed to create %s: %w", filepath.Dir(path), err)
+		}
+		if err := os.WriteFile(path, files[p], 0o644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", path, err)
+		}
+		fmt.Fprintf(os.Stderr, "%s written\n", path)
+	}
+	return nil
+}
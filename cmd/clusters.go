@@ -0,0 +1,309 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/raihankhan/ecommerceApi-client-go/pkg/deployer"
+	"io"
+	"os"
+	"sigs.k8s.io/yaml"
+	"text/tabwriter"
+)
+
+// clusterFlags choose the clusters deploy, status and delete run against.
+var clusterFlags = []string{"contexts", "parallel-clusters", "fail-fast"}
+
+// clusterOutput is the document -o json prints after running against
+// -contexts, and -o yaml too for status.
+type clusterOutput struct {
+	Clusters interface{} `json:"clusters"`
+}
+
+// clusterStatus is the status of the release on one context.
+type clusterStatus struct {
+	Cluster string `json:"cluster"`
+	// NotRun is set for the contexts -fail-fast stopped before or during
+	// their run.
+	NotRun bool             `json:"notRun,omitempty"`
+	Error  string           `json:"error,omitempty"`
+	Status *deployer.Status `json:"status,omitempty"`
+}
+
+// runClusters runs command, deploy, status or delete, against every context
+// of -contexts, each through a Deployer of its own, and returns the exit
+// code of the first that failed, or 0. The runs go one after the other, or
+// all at once with -parallel-clusters; a failure stops the others only with
+// -fail-fast. The outcome on each is printed, its lines prefixed with its
+// context, followed by a table of the contexts.
+func runClusters(s *session, command string) int {
+	clusters, release := s.connectClusters()
+	defer release()
+	opts := deployer.ClustersOptions{Parallel: *parallelClusters, FailFast: *failFast}
+
+	var (
+		errs []error
+		err  error
+	)
+	switch command {
+	case "status":
+		errs, err = statusClusters(s.ctx, clusters, opts)
+	case "delete":
+		errs = deleteClusters(s.ctx, clusters, opts)
+	default:
+		errs, err = deployClusters(s, clusters, opts)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		return exitFailure
+	}
+	for i, err := range errs {
+		if err != nil && !errors.Is(err, deployer.ErrNotRun) {
+			fmt.Fprintf(os.Stderr, "[%s] %s\n", clusters[i].Name, err)
+		}
+	}
+	if err := printClusterSummary(clusters, errs); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		return exitFailure
+	}
+	return clustersExitCode(errs)
+}
+
+// eachCluster calls fn for each of clusters as deployer.ForEachCluster
+// does, once the routing of its Deployer is resolved as connect does.
+func eachCluster(ctx context.Context, clusters []deployer.Cluster, opts deployer.ClustersOptions, fn func(ctx context.Context, i int) error) []error {
+	return deployer.ForEachCluster(ctx, clusters, opts, func(ctx context.Context, i int) error {
+		if err := clusters[i].Deployer.ResolveRouting(ctx); err != nil {
+			return err
+		}
+		return fn(ctx, i)
+	})
+}
+
+// deployClusters deploys to each of clusters as runDeploy does to one: the
+// checks of runPreflight unless -skip-preflight, then Deploy, and the smoke
+// tests and the wait for the certificate. It prints the result on each as
+// printResult does and returns the failures.
+func deployClusters(s *session, clusters []deployer.Cluster, opts deployer.ClustersOptions) ([]error, error) {
+	results := make([]deployer.ClusterResult, len(clusters))
+	errs := eachCluster(s.ctx, clusters, opts, func(ctx context.Context, i int) error {
+		d, name := clusters[i].Deployer, clusters[i].Name
+		log := s.log.WithValues("context", name)
+		log.Info("Running against context")
+		progress := func(phase string) { log.V(1).Info("Entering phase", "phase", phase) }
+		var compat *deployer.Compatibility
+		if !*skipPreflight {
+			var err error
+			if compat, err = checkCluster(ctx, log, d, progress); err != nil {
+				results[i].Result = &deployer.Result{Namespace: d.Namespace(), Compatibility: compat}
+				return err
+			}
+		}
+		result, err := d.Deploy(ctx, deployer.DeployOptions{
+			Prune:          *prune,
+			PruneResources: s.pruneResources,
+			Wait:           *waitDone,
+			Timeout:        *timeout,
+			Progress:       progress,
+		})
+		result.Compatibility = compat
+		results[i].Result = result
+		if err == nil && *waitDone && !s.opts.DryRun && len(s.smokeTests.Tests) > 0 {
+			progress("smoke testing")
+			err = d.SmokeTest(ctx, s.smokeTests)
+		}
+		if err == nil && *waitCertificate && !s.opts.DryRun {
+			progress("waiting for the certificate")
+			err = d.WaitForCertificate(ctx, *timeout)
+		}
+		return err
+	})
+	for i, c := range clusters {
+		r := &results[i]
+		r.Cluster, r.Err, r.NotRun = c.Name, errs[i], errors.Is(errs[i], deployer.ErrNotRun)
+		if r.Result == nil && !r.NotRun {
+			// Resolving the routing failed.
+			r.Result = &deployer.Result{Namespace: c.Deployer.Namespace(), DryRun: s.opts.DryRun}
+		}
+		if r.Err != nil && r.Result != nil {
+			r.Result.Error = r.Err.Error()
+		}
+	}
+	return errs, printClusterResults(results)
+}
+
+// printClusterResults prints the result on each cluster as printResult does
+// on one: with -o json one document lists them, and otherwise the objects of
+// each follow a comment naming its context.
+func printClusterResults(results []deployer.ClusterResult) error {
+	if *output == "json" {
+		redacted := make([]deployer.ClusterResult, len(results))
+		for i, r := range results {
+			if r.Result != nil {
+				r.Result = redactResult(r.Result)
+			}
+			redacted[i] = r
+		}
+		if err := printDocument(os.Stdout, "json", clusterOutput{Clusters: redacted}); err != nil {
+			return err
+		}
+	}
+	for _, r := range results {
+		if r.Result == nil {
+			continue
+		}
+		if *output == "yaml" || (*output == "table" && r.Result.DryRun) {
+			fmt.Fprintf(os.Stdout, "# context: %s\n", r.Cluster)
+		}
+		lines := &prefixWriter{w: out, prefix: "[" + r.Cluster + "] "}
+		err := printResultText(os.Stdout, lines, *output, r.Result)
+		lines.flush()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// statusClusters prints the status of the release on each of clusters: as
+// one document listing them with -o json or yaml, and otherwise as the
+// table of status, its lines prefixed with the context. It returns the
+// failures to read it.
+func statusClusters(ctx context.Context, clusters []deployer.Cluster, opts deployer.ClustersOptions) ([]error, error) {
+	statuses := make([]clusterStatus, len(clusters))
+	errs := eachCluster(ctx, clusters, opts, func(ctx context.Context, i int) (err error) {
+		statuses[i].Status, err = clusters[i].Deployer.Status(ctx)
+		return err
+	})
+	for i, c := range clusters {
+		statuses[i].Cluster = c.Name
+		statuses[i].NotRun = errors.Is(errs[i], deployer.ErrNotRun)
+		if errs[i] != nil {
+			statuses[i].Error = errs[i].Error()
+		}
+	}
+	if *output != "table" {
+		return errs, printDocument(os.Stdout, *output, clusterOutput{Clusters: statuses})
+	}
+	for _, st := range statuses {
+		if st.Status == nil {
+			continue
+		}
+		lines := &prefixWriter{w: os.Stdout, prefix: "[" + st.Cluster + "] "}
+		err := printStatus(lines, st.Status, *output)
+		lines.flush()
+		if err != nil {
+			return errs, err
+		}
+	}
+	return errs, nil
+}
+
+// deleteClusters deletes the objects of the release from each of clusters
+// as delete does from one, prints the outcome of each deletion, its lines
+// prefixed with the context, and returns the first failure on each.
+func deleteClusters(ctx context.Context, clusters []deployer.Cluster, opts deployer.ClustersOptions) []error {
+	delOpts := deleteOptions()
+	deleted := make([][]deployer.DeleteResult, len(clusters))
+	errs := eachCluster(ctx, clusters, opts, func(ctx context.Context, i int) error {
+		deleted[i] = clusters[i].Deployer.DeleteAll(ctx, delOpts)
+		for _, r := range deleted[i] {
+			if r.Outcome == deployer.Failed {
+				return r.Err
+			}
+		}
+		return nil
+	})
+	for i, c := range clusters {
+		lines := &prefixWriter{w: out, prefix: "[" + c.Name + "] "}
+		printDeleteResults(lines, c.Deployer, deleted[i])
+		lines.flush()
+	}
+	return errs
+}
+
+// printClusterSummary writes the outcome on each of clusters, as errs holds
+// them, as a table to out.
+func printClusterSummary(clusters []deployer.Cluster, errs []error) error {
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "CONTEXT\tRESULT")
+	for i, c := range clusters {
+		result := "succeeded"
+		switch {
+		case errors.Is(errs[i], deployer.ErrNotRun):
+			result = "not run (-fail-fast)"
+		case errs[i] != nil:
+			result = fmt.Sprintf("failed (exit code %d)", exitCode(errs[i]))
+		}
+		fmt.Fprintf(tw, "%s\t%s\n", c.Name, result)
+	}
+	return tw.Flush()
+}
+
+// clustersExitCode returns the exit code of the first of errs that failed,
+// exitFailure when -fail-fast only stopped runs, or 0.
+func clustersExitCode(errs []error) int {
+	code := 0
+	for _, err := range errs {
+		switch {
+		case err == nil:
+		case errors.Is(err, deployer.ErrNotRun):
+			if code == 0 {
+				code = exitFailure
+			}
+		default:
+			return exitCode(err)
+		}
+	}
+	return code
+}
+
+// printDocument writes v to w as indented JSON with format json, and as YAML
+// otherwise.
+func printDocument(w io.Writer, format string, v interface{}) error {
+	var (
+		data []byte
+		err  error
+	)
+	if format == "json" {
+		data, err = json.MarshalIndent(v, "", "  ")
+	} else {
+		data, err = yaml.Marshal(v)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", bytes.TrimRight(data, "\n"))
+	return err
+}
+
+// prefixWriter writes each complete line to w after prefix.
+type prefixWriter struct {
+	w      io.Writer
+	prefix string
+	buf    []byte
+}
+
+func (p *prefixWriter) Write(b []byte) (int, error) {
+	p.buf = append(p.buf, b...)
+	for {
+		i := bytes.IndexByte(p.buf, '\n')
+		if i < 0 {
+			return len(b), nil
+		}
+		_, err := fmt.Fprintf(p.w, "%s%s", p.prefix, p.buf[:i+1])
+		p.buf = p.buf[i+1:]
+		if err != nil {
+			return len(b), err
+		}
+	}
+}
+
+// flush writes what is left after the last newline.
+func (p *prefixWriter) flush() {
+	if len(p.buf) > 0 {
+		_, _ = p.Write([]byte("\n"))
+	}
+}
@@ -0,0 +1,107 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/raihankhan/ecommerceApi-client-go/pkg/version"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// inClusterContext is reported as the context name for in-cluster configs.
+const inClusterContext = "in-cluster"
+
+// clientTuning is how the clients built from a rest.Config talk to the
+// apiserver.
+type clientTuning struct {
+	// QPS and Burst rate limit the requests; zero keeps the client-go
+	// defaults of 5 and 10.
+	QPS   float32
+	Burst int
+	// Timeout bounds each request, including streams such as watches and
+	// followed logs; zero means none.
+	Timeout time.Duration
+}
+
+// tune applies t to config and identifies the tool in the apiserver audit
+// logs through the user agent.
+func (t clientTuning) tune(config *rest.Config) {
+	config.QPS = t.QPS
+	config.Burst = t.Burst
+	config.Timeout = t.Timeout
+	config.UserAgent = version.UserAgent()
+}
+
+// loadConfig returns the rest.Config to talk to the cluster with and the name
+// of the context it came from.
+//
+// Inside a pod (KUBERNETES_SERVICE_HOST is set) the in-cluster config is tried
+// first, otherwise the kubeconfig at path is; the other source is the
+// fallback. An explicit kubeContext always means the kubeconfig. When no
+// source works the error carries the failure of each.
+func loadConfig(path, kubeContext string) (*rest.Config, string, error) {
+	if kubeContext != "" {
+		return loadKubeconfig(path, kubeContext)
+	}
+
+	if os.Getenv("KUBERNETES_SERVICE_HOST") != "" {
+		config, inClusterErr := rest.InClusterConfig()
+		if inClusterErr == nil {
+			return config, inClusterContext, nil
+		}
+		config, name, err := loadKubeconfig(path, "")
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to load in-cluster config: %v; failed to load kubeconfig: %w", inClusterErr, err)
+		}
+		return config, name, nil
+	}
+
+	config, name, err := loadKubeconfig(path, "")
+	if err == nil {
+		return config, name, nil
+	}
+	config, inClusterErr := rest.InClusterConfig()
+	if inClusterErr != nil {
+		return nil, "", fmt.Errorf("failed to load kubeconfig: %w; failed to load in-cluster config: %v", err, inClusterErr)
+	}
+	return config, inClusterContext, nil
+}
+
+// loadKubeconfig builds a rest.Config from the kubeconfig at path using the
+// named context, or the kubeconfig's current-context when kubeContext is
+// empty. It also returns the name of the context that was used.
+func loadKubeconfig(path, kubeContext string) (*rest.Config, string, error) {
+	if path == "" {
+		return nil, "", fmt.Errorf("no kubeconfig path given")
+	}
+	clientConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		&clientcmd.ClientConfigLoadingRules{ExplicitPath: path},
+		&clientcmd.ConfigOverrides{CurrentContext: kubeContext},
+	)
+
+	raw, err := clientConfig.RawConfig()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read kubeconfig %s: %w", path, err)
+	}
+	if kubeContext != "" {
+		if _, ok := raw.Contexts[kubeContext]; !ok {
+			names := make([]string, 0, len(raw.Contexts))
+			for name := range raw.Contexts {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			return nil, "", fmt.Errorf("context %q not found in %s; available contexts: %s", kubeContext, path, strings.Join(names, ", "))
+		}
+	} else {
+		kubeContext = raw.CurrentContext
+	}
+
+	config, err := clientConfig.ClientConfig()
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid kubeconfig %s: %w", path, err)
+	}
+	return config, kubeContext, nil
+}
@@ -0,0 +1,125 @@
+package cmd
+
+import (
+	"errors"
+	"k8s.io/client-go/rest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testKubeconfig = `apiVersion: v1
+kind: Config
+current-context: dev
+clusters:
+- name: dev
+  cluster:
+    server: https://dev.example.com:6443
+- name: prod
+  cluster:
+    server: https://prod.example.com:6443
+contexts:
+- name: dev
+  context:
+    cluster: dev
+    user: admin
+- name: prod
+  context:
+    cluster: prod
+    user: admin
+users:
+- name: admin
+  user:
+    token: secret
+`
+
+func TestLoadConfig(t *testing.T) {
+	// The in-cluster config needs the service account token mounted into
+	// pods, which a test can't fake; without it the in-cluster attempt
+	// always fails.
+	if _, err := os.Stat("/var/run/secrets/kubernetes.io/serviceaccount/token"); err == nil {
+		t.Skip("running in a pod, the in-cluster config would load")
+	}
+	dir := t.TempDir()
+	valid := filepath.Join(dir, "config")
+	if err := os.WriteFile(valid, []byte(testKubeconfig), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	malformed := filepath.Join(dir, "malformed")
+	if err := os.WriteFile(malformed, []byte("clusters: [\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	missing := filepath.Join(dir, "missing")
+
+	tests := []struct {
+		name        string
+		inCluster   bool
+		path        string
+		context     string
+		wantHost    string
+		wantContext string
+		// wantErr are the parts the error must have, in order.
+		wantErr []string
+	}{
+		{name: "kubeconfig", path: valid, wantHost: "https://dev.example.com:6443", wantContext: "dev"},
+		{name: "named context", path: valid, context: "prod", wantHost: "https://prod.example.com:6443", wantContext: "prod"},
+		{name: "unknown context", path: valid, context: "staging", wantErr: []string{`context "staging" not found`, "available contexts: dev, prod"}},
+		{name: "missing kubeconfig", path: missing, wantErr: []string{"failed to load kubeconfig", missing, "failed to load in-cluster config"}},
+		{name: "malformed kubeconfig", path: malformed, wantErr: []string{"failed to load kubeconfig", "failed to read kubeconfig " + malformed, "failed to load in-cluster config"}},
+		{name: "no kubeconfig path", wantErr: []string{"no kubeconfig path given", "failed to load in-cluster config"}},
+		{name: "in cluster falls back to the kubeconfig", inCluster: true, path: valid, wantHost: "https://dev.example.com:6443", wantContext: "dev"},
+		{name: "in cluster with a missing kubeconfig", inCluster: true, path: missing, wantErr: []string{"failed to load in-cluster config", "failed to load kubeconfig", missing}},
+		{name: "in cluster with a malformed kubeconfig", inCluster: true, path: malformed, wantErr: []string{"failed to load in-cluster config", "failed to read kubeconfig " + malformed}},
+		{name: "in cluster with a named context", inCluster: true, path: valid, context: "prod", wantHost: "https://prod.example.com:6443", wantContext: "prod"},
+		{name: "a named context skips the in-cluster config", inCluster: true, path: missing, context: "prod", wantErr: []string{"failed to read kubeconfig " + missing}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.inCluster {
+				t.Setenv("KUBERNETES_SERVICE_HOST", "10.0.0.1")
+				t.Setenv("KUBERNETES_SERVICE_PORT", "443")
+			} else {
+				t.Setenv("KUBERNETES_SERVICE_HOST", "")
+				t.Setenv("KUBERNETES_SERVICE_PORT", "")
+			}
+
+			config, context, err := loadConfig(tt.path, tt.context)
+			if tt.wantErr != nil {
+				if err == nil {
+					t.Fatalf("loadConfig succeeded with %s from context %q", config.Host, context)
+				}
+				remaining := err.Error()
+				for _, part := range tt.wantErr {
+					i := strings.Index(remaining, part)
+					if i < 0 {
+						t.Fatalf("got error %q, want %q in order", err, tt.wantErr)
+					}
+					remaining = remaining[i+len(part):]
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("loadConfig: %v", err)
+			}
+			if config.Host != tt.wantHost || context != tt.wantContext {
+				t.Errorf("got %s from context %q, want %s from %q", config.Host, context, tt.wantHost, tt.wantContext)
+			}
+		})
+	}
+}
+
+func TestLoadConfigWrapsOnlyKubeconfigError(t *testing.T) {
+	t.Setenv("KUBERNETES_SERVICE_HOST", "")
+	t.Setenv("KUBERNETES_SERVICE_PORT", "")
+	_, _, err := loadConfig("", "")
+	if err == nil {
+		t.Fatal("loadConfig succeeded without a kubeconfig outside a cluster")
+	}
+	if errors.Is(err, rest.ErrNotInCluster) {
+		t.Errorf("got %v; only the kubeconfig failure is wrapped", err)
+	}
+	if !strings.Contains(err.Error(), rest.ErrNotInCluster.Error()) {
+		t.Errorf("got %v, want it to say why the in-cluster config failed", err)
+	}
+}
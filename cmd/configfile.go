@@ -0,0 +1,566 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/raihankhan/ecommerceApi-client-go/pkg/deployer"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+	"io"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"os"
+	"regexp"
+	k8syaml "sigs.k8s.io/yaml"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// defaultConfigFile is read from the working directory when -file isn't
+// given and it exists.
+const defaultConfigFile = "ecommerce.yaml"
+
+// fileSettings are the settings of a config file, at its top level or in a
+// profile. Those left out keep the flag defaults.
+type fileSettings struct {
+	Name        *string           `yaml:"name"`
+	Namespace   *string           `yaml:"namespace"`
+	Image       *string           `yaml:"image"`
+	Replicas    *int              `yaml:"replicas"`
+	Ports       []filePort        `yaml:"ports"`
+	Hosts       []string          `yaml:"hosts"`
+	Paths       []filePath        `yaml:"paths"`
+	ServiceType *string           `yaml:"serviceType"`
+	Resources   *fileResources    `yaml:"resources"`
+	Env         map[string]string `yaml:"env"`
+	Probes      *fileProbes       `yaml:"probes"`
+	// Affinity is the affinity of the API pods, passed through verbatim.
+	Affinity map[string]interface{} `yaml:"affinity"`
+	// InitContainers and Sidecars are extra containers of the API pods,
+	// in the schema of a pod's containers.
+	InitContainers []map[string]interface{} `yaml:"initContainers"`
+	Sidecars       []map[string]interface{} `yaml:"sidecars"`
+}
+
+// filePort is a port of the API, as -port is.
+type filePort struct {
+	Name          string `yaml:"name"`
+	ContainerPort int    `yaml:"containerPort"`
+	ServicePort   int    `yaml:"servicePort"`
+}
+
+// filePath is an ingress path, as -path is, with the name of the port it is
+// routed to.
+type filePath struct {
+	Path     string `yaml:"path"`
+	PathType string `yaml:"pathType"`
+	Port     string `yaml:"port"`
+}
+
+// fileResources are the requests and limits of the API container.
+type fileResources struct {
+	Requests fileQuantities `yaml:"requests"`
+	Limits   fileQuantities `yaml:"limits"`
+}
+
+type fileQuantities struct {
+	CPU    string `yaml:"cpu"`
+	Memory string `yaml:"memory"`
+}
+
+// fileProbes are the probes of the API container.
+type fileProbes struct {
+	Type                    string `yaml:"type"`
+	Path                    string `yaml:"path"`
+	StartupFailureThreshold *int   `yaml:"startupFailureThreshold"`
+}
+
+// configFile is an ecommerce.yaml file. Its top-level settings are the
+// defaults of the flags, and a profile's settings win over them.
+type configFile struct {
+	Settings fileSettings            `yaml:",inline"`
+	Profiles map[string]fileSettings `yaml:"profiles"`
+
+	path string
+	// root is the parsed document, for the lines of errors.
+	root *yaml.Node
+}
+
+// configFileError lists the problems of a config file, one per line as
+// path:line: problem.
+type configFileError struct {
+	Problems []string
+}
+
+func (e *configFileError) Error() string { return strings.Join(e.Problems, "\n") }
+
+// findConfigFile returns the config file to read: path when given, or
+// ecommerce.yaml in the working directory when it exists, or "" for none.
+func findConfigFile(path string) string {
+	if path != "" {
+		return path
+	}
+	if _, err := os.Stat(defaultConfigFile); err == nil {
+		return defaultConfigFile
+	}
+	return ""
+}
+
+// yamlLine matches the line yaml.v3 prefixes its errors with.
+var yamlLine = regexp.MustCompile(`^(?:yaml: )?line (\d+): (.*)$`)
+
+// unknownField matches the yaml.v3 error for a key the schema doesn't have.
+var unknownField = regexp.MustCompile(`^field (\S+) not found in type \S+$`)
+
+// loadConfigFile parses the config file at path, rejecting keys the settings
+// don't have and values of the wrong type, then checks the values of the
+// top level and of every profile. All the problems found are returned as a
+// *configFileError.
+func loadConfigFile(path string) (*configFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	cfg := &configFile{path: path, root: &yaml.Node{}}
+	if err := yaml.Unmarshal(data, cfg.root); err != nil {
+		return nil, &configFileError{Problems: []string{cfg.problem(err.Error())}}
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		var typeErr *yaml.TypeError
+		if !errors.As(err, &typeErr) {
+			return nil, &configFileError{Problems: []string{cfg.problem(err.Error())}}
+		}
+		problems := make([]string, 0, len(typeErr.Errors))
+		for _, msg := range typeErr.Errors {
+			problems = append(problems, cfg.problem(msg))
+		}
+		return nil, &configFileError{Problems: problems}
+	}
+
+	problems := cfg.Settings.check(cfg, nil)
+	names := make([]string, 0, len(cfg.Profiles))
+	for name := range cfg.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		problems = append(problems, cfg.Profiles[name].check(cfg, []string{"profiles", name})...)
+	}
+	if len(problems) > 0 {
+		return nil, &configFileError{Problems: problems}
+	}
+	return cfg, nil
+}
+
+// problem formats a yaml.v3 error as path:line: problem.
+func (c *configFile) problem(msg string) string {
+	if m := yamlLine.FindStringSubmatch(msg); m != nil {
+		msg = m[2]
+		if f := unknownField.FindStringSubmatch(msg); f != nil {
+			msg = fmt.Sprintf("unknown key %q", f[1])
+		}
+		return fmt.Sprintf("%s:%s: %s", c.path, m[1], msg)
+	}
+	return fmt.Sprintf("%s: %s", c.path, strings.TrimPrefix(msg, "yaml: "))
+}
+
+// errorAt formats a problem of the value at the key path, such as
+// profiles.prod.replicas, as path:line: key: problem.
+func (c *configFile) errorAt(path []string, format string, args ...interface{}) string {
+	return fmt.Sprintf("%s:%d: %s: %s", c.path, c.line(path), strings.Join(path, "."), fmt.Sprintf(format, args...))
+}
+
+// line returns the line of the value at the key path, list items given by
+// index, or of the closest parent found.
+func (c *configFile) line(path []string) int {
+	node := c.root
+	if node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+		node = node.Content[0]
+	}
+	line := node.Line
+	for _, key := range path {
+		var next *yaml.Node
+		switch node.Kind {
+		case yaml.MappingNode:
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				if node.Content[i].Value == key {
+					next = node.Content[i+1]
+				}
+			}
+		case yaml.SequenceNode:
+			if i, err := strconv.Atoi(key); err == nil && i < len(node.Content) {
+				next = node.Content[i]
+			}
+		}
+		if next == nil {
+			break
+		}
+		node, line = next, next.Line
+	}
+	return line
+}
+
+// check returns the problems of the values of s, found at the key path
+// prefix.
+func (s fileSettings) check(c *configFile, prefix []string) []string {
+	var problems []string
+	at := func(keys ...string) []string {
+		return append(append([]string{}, prefix...), keys...)
+	}
+	if s.Replicas != nil && *s.Replicas < 0 {
+		problems = append(problems, c.errorAt(at("replicas"), "must not be negative, got %d", *s.Replicas))
+	}
+	for i, p := range s.Ports {
+		if p.Name == "" {
+			problems = append(problems, c.errorAt(at("ports", strconv.Itoa(i), "name"), "must not be empty"))
+		}
+		if p.ContainerPort < 1 || p.ContainerPort > 65535 {
+			problems = append(problems, c.errorAt(at("ports", strconv.Itoa(i), "containerPort"), "must be between 1 and 65535, got %d", p.ContainerPort))
+		}
+		if p.ServicePort < 0 || p.ServicePort > 65535 {
+			problems = append(problems, c.errorAt(at("ports", strconv.Itoa(i), "servicePort"), "must be between 1 and 65535, got %d", p.ServicePort))
+		}
+	}
+	for i, h := range s.Hosts {
+		if h == "" {
+			problems = append(problems, c.errorAt(at("hosts", strconv.Itoa(i)), "must not be empty"))
+		}
+	}
+	for i, p := range s.Paths {
+		if !strings.HasPrefix(p.Path, "/") {
+			problems = append(problems, c.errorAt(at("paths", strconv.Itoa(i), "path"), "%q must start with /", p.Path))
+		}
+		switch p.PathType {
+		case "", "Prefix", "Exact", "ImplementationSpecific":
+		default:
+			problems = append(problems, c.errorAt(at("paths", strconv.Itoa(i), "pathType"), "must be Prefix, Exact or ImplementationSpecific, got %q", p.PathType))
+		}
+	}
+	if s.ServiceType != nil {
+		switch *s.ServiceType {
+		case "ClusterIP", "NodePort", "LoadBalancer":
+		default:
+			problems = append(problems, c.errorAt(at("serviceType"), "must be ClusterIP, NodePort or LoadBalancer, got %q", *s.ServiceType))
+		}
+	}
+	if r := s.Resources; r != nil {
+		quantities := map[string]string{
+			"requests.cpu": r.Requests.CPU, "requests.memory": r.Requests.Memory,
+			"limits.cpu": r.Limits.CPU, "limits.memory": r.Limits.Memory,
+		}
+		keys := make([]string, 0, len(quantities))
+		for k := range quantities {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if q := quantities[k]; q != "" {
+				if _, err := resource.ParseQuantity(q); err != nil {
+					problems = append(problems, c.errorAt(at(append([]string{"resources"}, strings.Split(k, ".")...)...), "invalid quantity %q", q))
+				}
+			}
+		}
+	}
+	for k := range s.Env {
+		if k == "" {
+			problems = append(problems, c.errorAt(at("env"), "variable names must not be empty"))
+		}
+	}
+	if p := s.Probes; p != nil {
+		if p.Type != "" && p.Type != deployer.ProbeHTTP && p.Type != deployer.ProbeTCP {
+			problems = append(problems, c.errorAt(at("probes", "type"), "must be %s or %s, got %q", deployer.ProbeHTTP, deployer.ProbeTCP, p.Type))
+		}
+		if p.StartupFailureThreshold != nil && *p.StartupFailureThreshold < 0 {
+			problems = append(problems, c.errorAt(at("probes", "startupFailureThreshold"), "must not be negative, got %d", *p.StartupFailureThreshold))
+		}
+	}
+	if s.Affinity != nil {
+		if err := decodeStrict(s.Affinity, &corev1.Affinity{}); err != nil {
+			problems = append(problems, c.errorAt(at("affinity"), "%v", err))
+		}
+	}
+	for i, container := range s.InitContainers {
+		if err := decodeStrict(container, &corev1.Container{}); err != nil {
+			problems = append(problems, c.errorAt(at("initContainers", strconv.Itoa(i)), "%v", err))
+		}
+	}
+	for i, container := range s.Sidecars {
+		if err := decodeStrict(container, &corev1.Container{}); err != nil {
+			problems = append(problems, c.errorAt(at("sidecars", strconv.Itoa(i)), "%v", err))
+		}
+	}
+	return problems
+}
+
+// decodeStrict decodes the block v of the file into the API type out,
+// rejecting fields it doesn't have.
+func decodeStrict(v interface{}, out interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if err := k8syaml.UnmarshalStrict(data, out); err != nil {
+		return errors.New(strings.TrimPrefix(err.Error(), "error unmarshaling JSON: while decoding JSON: json: "))
+	}
+	return nil
+}
+
+// settings returns the top-level settings with those of the named profile
+// over them; "" is none.
+func (c *configFile) settings(profile string) (fileSettings, error) {
+	s := c.Settings
+	if profile == "" {
+		return s, nil
+	}
+	p, ok := c.Profiles[profile]
+	if !ok {
+		names := make([]string, 0, len(c.Profiles))
+		for name := range c.Profiles {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return fileSettings{}, fmt.Errorf("no profile %q in %s; profiles: %s", profile, c.path, strings.Join(names, ", "))
+	}
+	if p.Name != nil {
+		s.Name = p.Name
+	}
+	if p.Namespace != nil {
+		s.Namespace = p.Namespace
+	}
+	if p.Image != nil {
+		s.Image = p.Image
+	}
+	if p.Replicas != nil {
+		s.Replicas = p.Replicas
+	}
+	if p.Ports != nil {
+		s.Ports = p.Ports
+	}
+	if p.Hosts != nil {
+		s.Hosts = p.Hosts
+	}
+	if p.Paths != nil {
+		s.Paths = p.Paths
+	}
+	if p.ServiceType != nil {
+		s.ServiceType = p.ServiceType
+	}
+	if p.Resources != nil {
+		r := fileResources{}
+		if s.Resources != nil {
+			r = *s.Resources
+		}
+		r.Requests = r.Requests.over(p.Resources.Requests)
+		r.Limits = r.Limits.over(p.Resources.Limits)
+		s.Resources = &r
+	}
+	if p.Env != nil {
+		env := map[string]string{}
+		for k, v := range s.Env {
+			env[k] = v
+		}
+		for k, v := range p.Env {
+			env[k] = v
+		}
+		s.Env = env
+	}
+	if p.Affinity != nil {
+		s.Affinity = p.Affinity
+	}
+	if p.InitContainers != nil {
+		s.InitContainers = p.InitContainers
+	}
+	if p.Sidecars != nil {
+		s.Sidecars = p.Sidecars
+	}
+	if p.Probes != nil {
+		probes := fileProbes{}
+		if s.Probes != nil {
+			probes = *s.Probes
+		}
+		if p.Probes.Type != "" {
+			probes.Type = p.Probes.Type
+		}
+		if p.Probes.Path != "" {
+			probes.Path = p.Probes.Path
+		}
+		if p.Probes.StartupFailureThreshold != nil {
+			probes.StartupFailureThreshold = p.Probes.StartupFailureThreshold
+		}
+		s.Probes = &probes
+	}
+	return s, nil
+}
+
+// over returns q with the quantities set in o replacing its own.
+func (q fileQuantities) over(o fileQuantities) fileQuantities {
+	if o.CPU != "" {
+		q.CPU = o.CPU
+	}
+	if o.Memory != "" {
+		q.Memory = o.Memory
+	}
+	return q
+}
+
+// flags returns the values s gives the flags, by flag name, several for the
+// repeatable ones.
+func (s fileSettings) flags() map[string][]string {
+	flags := map[string][]string{}
+	str := func(name string, v *string) {
+		if v != nil {
+			flags[name] = []string{*v}
+		}
+	}
+	num := func(name string, v *int) {
+		if v != nil {
+			flags[name] = []string{strconv.Itoa(*v)}
+		}
+	}
+	set := func(name, v string) {
+		if v != "" {
+			flags[name] = []string{v}
+		}
+	}
+	str("name", s.Name)
+	str("namespace", s.Namespace)
+	str("image", s.Image)
+	num("replicas", s.Replicas)
+	str("service-type", s.ServiceType)
+	if s.Ports != nil {
+		ports := make([]string, 0, len(s.Ports))
+		for _, p := range s.Ports {
+			port := fmt.Sprintf("name=%s,container=%d", p.Name, p.ContainerPort)
+			if p.ServicePort != 0 {
+				port += fmt.Sprintf(",service=%d", p.ServicePort)
+			}
+			ports = append(ports, port)
+		}
+		flags["port"] = ports
+	}
+	if s.Hosts != nil {
+		flags["host"] = s.Hosts
+	}
+	if s.Paths != nil {
+		paths := make([]string, 0, len(s.Paths))
+		for _, p := range s.Paths {
+			pathType := p.PathType
+			if pathType == "" {
+				pathType = "Prefix"
+			}
+			path := p.Path + ":" + pathType
+			if p.Port != "" {
+				path += ":" + p.Port
+			}
+			paths = append(paths, path)
+		}
+		flags["path"] = paths
+	}
+	if r := s.Resources; r != nil {
+		set("cpu-request", r.Requests.CPU)
+		set("memory-request", r.Requests.Memory)
+		set("cpu-limit", r.Limits.CPU)
+		set("memory-limit", r.Limits.Memory)
+	}
+	if len(s.Env) > 0 {
+		keys := make([]string, 0, len(s.Env))
+		for k := range s.Env {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			flags["env"] = append(flags["env"], k+"="+s.Env[k])
+		}
+	}
+	if p := s.Probes; p != nil {
+		set("probe-type", p.Type)
+		set("health-path", p.Path)
+		num("startup-probe-failure-threshold", p.StartupFailureThreshold)
+	}
+	if s.Affinity != nil {
+		// check has marshalled it already.
+		data, _ := json.Marshal(s.Affinity)
+		flags["affinity"] = []string{string(data)}
+	}
+	for name, containers := range map[string][]map[string]interface{}{"init-container": s.InitContainers, "sidecar": s.Sidecars} {
+		for _, c := range containers {
+			data, _ := json.Marshal(c)
+			flags[name] = append(flags[name], string(data))
+		}
+	}
+	return flags
+}
+
+// apply sets the flags not given on the command line or by the environment
+// to the settings of the named profile, "" for none, over those of the top
+// level, and returns the names of the flags set. The precedence is flags,
+// then the environment, the profile, the top level and the flag defaults.
+func (c *configFile) apply(profile string) ([]string, error) {
+	s, err := c.settings(profile)
+	if err != nil {
+		return nil, err
+	}
+	given := givenFlags()
+	values := s.flags()
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var set []string
+	for _, name := range names {
+		if given[name] {
+			continue
+		}
+		for _, v := range values[name] {
+			if err := flags.Set(name, v); err != nil {
+				return nil, fmt.Errorf("%s: %s: %w", c.path, name, err)
+			}
+		}
+		set = append(set, name)
+	}
+	return set, nil
+}
+
+// validateConfigFile checks the config file at path, and that it has the
+// named profile unless that is "", for the config validate subcommand.
+func validateConfigFile(path, profile string) error {
+	if path == "" {
+		return fmt.Errorf("no config file; pass -file or create ./%s", defaultConfigFile)
+	}
+	cfg, err := loadConfigFile(path)
+	if err != nil {
+		return err
+	}
+	if _, err := cfg.settings(profile); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "%s is valid\n", path)
+	return nil
+}
+
+func newConfigCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "Work with the ecommerce.yaml config file",
+	}
+	cmd.AddCommand(&cobra.Command{
+		Use:   "validate",
+		Short: "Check the config file, every profile included, without contacting the cluster",
+		Args:  cobra.NoArgs,
+		Run: func(*cobra.Command, []string) {
+			if _, err := applyEnv(); err != nil {
+				fail(err)
+			}
+			if err := validateConfigFile(findConfigFile(*file), *profile); err != nil {
+				fail(&deployer.ValidationError{Field: "file", Err: err})
+			}
+		},
+	})
+	return cmd
+}
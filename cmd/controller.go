@@ -0,0 +1,62 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"github.com/raihankhan/ecommerceApi-client-go/pkg/deployer"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+func newControllerCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "controller",
+		Short: "Run the EcommerceApp controller until interrupted",
+		Args:  cobra.NoArgs,
+		Run: func(*cobra.Command, []string) {
+			s := prepare("controller")
+			defer s.connect()()
+			d, sigCtx := s.d, s.sigCtx
+			// An explicit -namespace limits the controller to it; otherwise it
+			// serves EcommerceApps in every namespace.
+			watchNamespace := ""
+			if flagSet("namespace") {
+				watchNamespace = *namespace
+			}
+			var election *deployer.LeaderElection
+			if *leaderElect {
+				election = &deployer.LeaderElection{
+					LeaseName:     *leaseName,
+					LeaseDuration: *leaseDuration,
+					RenewDeadline: *renewDeadline,
+					RetryPeriod:   *retryPeriod,
+				}
+			}
+			os.Exit(runController(sigCtx, d, watchNamespace, *workers, election))
+		},
+	}
+	addFlags(cmd.Flags(), leaderElectionFlags, []string{"workers"})
+	return cmd
+}
+
+// runController runs the EcommerceApp controller until ctx is done, only
+// while holding the Lease when election is set, and returns the process exit
+// code. Each term of leadership starts a fresh controller, as a stopped one
+// can't be run again.
+func runController(ctx context.Context, d *deployer.Deployer, namespace string, workers int, election *deployer.LeaderElection) int {
+	var err error
+	if election != nil {
+		err = d.RunElected(ctx, *election, func(ctx context.Context) {
+			if err := deployer.NewController(d, namespace).Run(ctx, workers); err != nil {
+				fmt.Fprintf(os.Stderr, "%s\n", err)
+			}
+		})
+	} else {
+		err = deployer.NewController(d, namespace).Run(ctx, workers)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		return exitCode(err)
+	}
+	return 0
+}
@@ -0,0 +1,27 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+)
+
+func newInstallCRDCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "install-crd",
+		Short: "Install the EcommerceApp CustomResourceDefinition",
+		Args:  cobra.NoArgs,
+		Run: func(*cobra.Command, []string) {
+			s := prepare("install-crd")
+			defer s.connect()()
+			d, ctx := s.d, s.ctx
+			run.start("installing the CustomResourceDefinition")
+			crd, err := d.InstallCRD(ctx)
+			if err != nil {
+				fail(err)
+			}
+			fmt.Fprintf(out, "CustomResourceDefinition %s applied\n", crd.GetName())
+		},
+	}
+	addFlags(cmd.Flags(), applyFlags)
+	return cmd
+}
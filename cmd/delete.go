@@ -0,0 +1,98 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/raihankhan/ecommerceApi-client-go/pkg/deployer"
+	"github.com/spf13/cobra"
+	"io"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"os"
+	"time"
+)
+
+func newDeleteCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "delete",
+		Short: "Delete the objects of the release",
+		Args:  cobra.NoArgs,
+		Run:   func(*cobra.Command, []string) { runDeletion(prepare("delete")) },
+	}
+	addFlags(cmd.Flags(), objectFlags, clusterFlags, []string{"dry-run", "wait", "grace-period", "cascade"})
+	return cmd
+}
+
+// runDeletion deletes the objects, or the EcommerceApp with -cr-mode, and
+// exits with the code of the outcome.
+func runDeletion(s *session) {
+	if len(*contexts) > 0 {
+		os.Exit(runClusters(s, "delete"))
+	}
+	defer s.connect()()
+	opts, d, ctx := s.opts, s.d, s.ctx
+	delOpts := deleteOptions()
+	if *crMode {
+		if err := d.DeleteApp(ctx); err != nil {
+			fail(err)
+		}
+		fmt.Fprintf(out, "EcommerceApp %s deleted\n", objectRef(d.Namespace(), deployer.NewApp(opts).GetName()))
+		return
+	}
+	run.start("deleting the objects")
+	code := runDelete(ctx, d, delOpts)
+	if code != 0 {
+		run.failed(errors.New("deleting failed"))
+	} else {
+		run.report()
+	}
+	os.Exit(code)
+}
+
+// deleteOptions returns the options of -wait, -timeout, -grace-period and
+// -cascade.
+func deleteOptions() deployer.DeleteOptions {
+	opts := deployer.DeleteOptions{Wait: *waitDone, Timeout: *timeout}
+	if *gracePeriod >= 0 {
+		opts.GracePeriodSeconds = gracePeriod
+	}
+	if *cascade != "" {
+		policy := propagationPolicies[*cascade]
+		opts.PropagationPolicy = &policy
+	}
+	return opts
+}
+
+// propagationPolicies maps the values of -cascade to deletion propagation
+// policies.
+var propagationPolicies = map[string]metav1.DeletionPropagation{
+	"background": metav1.DeletePropagationBackground,
+	"foreground": metav1.DeletePropagationForeground,
+	"orphan":     metav1.DeletePropagationOrphan,
+}
+
+// runDelete tears down the resources and returns the process exit code.
+func runDelete(ctx context.Context, d *deployer.Deployer, opts deployer.DeleteOptions) int {
+	return printDeleteResults(out, d, d.DeleteAll(ctx, opts))
+}
+
+// printDeleteResults prints the outcome of each deletion to w and returns
+// the process exit code: that of the first failure, or 0.
+func printDeleteResults(w io.Writer, d *deployer.Deployer, results []deployer.DeleteResult) int {
+	code := 0
+	for _, r := range results {
+		ref := objectRef(d.Namespace(), r.Name)
+		switch r.Outcome {
+		case deployer.Failed:
+			fmt.Fprintf(w, "%s %s: %s: %v\n", r.Kind, ref, r.Outcome, r.Err)
+			if code == 0 {
+				code = exitCode(r.Err)
+			}
+		case deployer.Skipped:
+			fmt.Fprintf(w, "%s %s: %s (warning: %v)\n", r.Kind, ref, r.Outcome, r.Err)
+		default:
+			fmt.Fprintf(w, "%s %s: %s in %s\n", r.Kind, ref, r.Outcome, r.Duration.Round(time.Millisecond))
+		}
+	}
+	return code
+}
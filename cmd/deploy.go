@@ -0,0 +1,357 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/go-logr/logr"
+	"github.com/raihankhan/ecommerceApi-client-go/pkg/deployer"
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+// deployFlags are the flags of deploy, which the tool run without a command
+// takes too.
+var deployFlags = [][]string{
+	objectFlags, applyFlags, waitFlags, leaderElectionFlags, clusterFlags,
+	{
+		"wait-certificate", "keep-old-for", "canary", "canary-weight", "reconcile-interval", "once",
+		"watch", "prune", "prune-whitelist", "output", "delete", "grace-period", "cascade", "skip-preflight",
+		"min-kube-version", "migration-command", "migration-image", "migration-backoff-limit", "migration-history-limit",
+		"smoke-test", "smoke-test-timeout", "smoke-test-window", "diagnostics-dir",
+	},
+}
+
+func newDeployCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "deploy",
+		Short: "Apply the objects and wait for the rollout, the default command",
+		Args:  cobra.NoArgs,
+		Run:   func(*cobra.Command, []string) { runDeploy("deploy") },
+	}
+	addFlags(cmd.Flags(), deployFlags...)
+	return cmd
+}
+
+// runDeploy applies the objects as command, deploy or the tool run without
+// a command. -dry-run=client prints them as template does, and -delete
+// deletes them as delete does.
+func runDeploy(command string) {
+	s := prepare(command)
+	if *dryRun == "client" {
+		printTemplate(s.opts)
+		return
+	}
+	if *del {
+		runDeletion(s)
+		return
+	}
+	if len(*contexts) > 0 {
+		os.Exit(runClusters(s, "deploy"))
+	}
+	defer s.connect()()
+	log, opts, d, ctx, sigCtx := s.log, s.opts, s.d, s.ctx, s.sigCtx
+
+	if !*skipPreflight && !*crMode {
+		runPreflight(ctx, log, d)
+	}
+
+	if *reconcileInterval != 0 && !*once {
+		var election *deployer.LeaderElection
+		if *leaderElect {
+			election = &deployer.LeaderElection{
+				LeaseName:     *leaseName,
+				LeaseDuration: *leaseDuration,
+				RenewDeadline: *renewDeadline,
+				RetryPeriod:   *retryPeriod,
+			}
+		}
+		os.Exit(runReconciler(sigCtx, log, d, *reconcileInterval, election))
+	}
+
+	if *strategy == "blue-green" {
+		// An interrupt ends the wait of -keep-old-for with the new version
+		// serving.
+		run.start("deploying blue-green")
+		applied, err := d.DeployBlueGreen(ctx, deployer.BlueGreenOptions{Timeout: *timeout, KeepOldFor: *keepOldFor, SmokeTests: s.smokeTests})
+		run.record(applied)
+		if perr := printResult(*output, resultOf(d, false, applied, err)); perr != nil {
+			fail(perr)
+		}
+		if err != nil {
+			printRolloutError(err)
+			if *showEvents {
+				printEvents(d, err)
+			}
+			run.failed(err)
+			os.Exit(exitCode(err))
+		}
+		log.Info("Blue-green deploy complete", "namespace", d.Namespace())
+		return
+	}
+	if *canary {
+		os.Exit(runCanary(ctx, log, d, int32(*canaryWeight), *waitDone && !opts.DryRun, *timeout, *output))
+	}
+	if *crMode {
+		run.start("applying the EcommerceApp")
+		app, err := d.ApplyApp(ctx)
+		if err != nil {
+			fail(err)
+		}
+		if err := printResult(*output, resultOf(d, opts.DryRun, []*unstructured.Unstructured{app}, nil)); err != nil {
+			fail(err)
+		}
+		if opts.DryRun {
+			return
+		}
+		run.record([]*unstructured.Unstructured{app})
+		if *waitDone {
+			run.start("waiting for the EcommerceApp")
+			if err := d.WaitForApp(ctx, *timeout); err != nil {
+				fail(err)
+			}
+			log.Info("EcommerceApp ready", "namespace", d.Namespace(), "name", app.GetName())
+		}
+		return
+	}
+
+	// -watch follows the objects from the apply through the waits.
+	stopWatch := func() {}
+	if *watchChanges && *waitDone && !opts.DryRun {
+		stopWatch = startWatch(ctx, d)
+	}
+	result, err := d.Deploy(ctx, deployer.DeployOptions{
+		Prune:          *prune,
+		PruneResources: s.pruneResources,
+		Wait:           *waitDone,
+		Timeout:        *timeout,
+		Progress:       run.start,
+	})
+	stopWatch()
+	result.Compatibility = compatibility
+	if result.Rollout != nil && result.Rollout.RolledOut {
+		log.Info("Rollout complete", "namespace", d.Namespace(), "duration", result.Rollout.Duration.Duration)
+	}
+	if !opts.DryRun {
+		for _, r := range result.Applied {
+			run.record([]*unstructured.Unstructured{r.Object})
+		}
+	}
+	if err == nil && *waitDone && !opts.DryRun && len(s.smokeTests.Tests) > 0 {
+		run.start("smoke testing")
+		err = d.SmokeTest(ctx, s.smokeTests)
+	}
+	if err == nil && *waitCertificate && !opts.DryRun {
+		run.start("waiting for the certificate")
+		err = d.WaitForCertificate(ctx, *timeout)
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	if perr := printResult(*output, result); perr != nil {
+		fail(perr)
+	}
+	if err == nil {
+		return
+	}
+	if *showEvents {
+		printEvents(d, err)
+	}
+	if result.Rollout != nil && !result.Rollout.RolledOut {
+		printRolloutError(err)
+		run.failed(err)
+		os.Exit(exitCode(err))
+	}
+	fail(err)
+}
+
+// runPreflight checks that the cluster can run the deploy and that the user
+// may apply every object, exiting before anything is applied when not. The
+// findings go into the document -o json prints.
+func runPreflight(ctx context.Context, log logr.Logger, d *deployer.Deployer) {
+	compat, err := checkCluster(ctx, log, d, run.start)
+	compatibility = compat
+	if err != nil {
+		if *output == "json" {
+			if perr := printResult(*output, resultOf(d, false, nil, err)); perr != nil {
+				fail(perr)
+			}
+		}
+		fail(err)
+	}
+	log.V(1).Info("Cluster compatible", "version", compat.ServerVersion, "minimum", compat.MinVersion)
+}
+
+// checkCluster checks that the cluster of d can run the deploy, logging the
+// warnings about its APIs, then that the user may apply every object. It
+// returns what the compatibility checks found, calling progress as each
+// check starts.
+func checkCluster(ctx context.Context, log logr.Logger, d *deployer.Deployer, progress func(phase string)) (*deployer.Compatibility, error) {
+	progress("checking the cluster")
+	compat, err := d.CheckCompatibility(ctx)
+	if compat != nil {
+		for _, api := range compat.APIs {
+			if api.Warning != "" {
+				log.Info("Warning: "+api.Warning, "api", api.API)
+			}
+		}
+	}
+	if err == nil {
+		progress("checking permissions")
+		err = d.Preflight(ctx)
+	}
+	return compat, err
+}
+
+// waitForRollout waits for the API deployment to roll out and logs done, or
+// exits with the code of the rollout failure, after printing the recent
+// events of the deployment and its pods when showEvents is set.
+func waitForRollout(ctx context.Context, log logr.Logger, d *deployer.Deployer, timeout time.Duration, showEvents bool, done string) {
+	run.start("waiting for the rollout")
+	if err := d.WaitForRollout(ctx, timeout); err != nil {
+		rolloutFailed(d, err, showEvents)
+	}
+	log.Info(done, "namespace", d.Namespace())
+}
+
+// rolloutFailed exits with the code of the rollout failure err after
+// printing it and, with showEvents, the recent events of the objects
+// involved.
+func rolloutFailed(d *deployer.Deployer, err error, showEvents bool) {
+	printRolloutError(err)
+	if showEvents {
+		printEvents(d, err)
+	}
+	run.failed(err)
+	os.Exit(exitCode(err))
+}
+
+// startWatch prints the changes of the release's objects in the background
+// until the returned func is called or ctx is done.
+func startWatch(ctx context.Context, d *deployer.Deployer) (stop func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := d.Watch(ctx, out); err != nil {
+			fmt.Fprintf(os.Stderr, "watch unavailable: %s\n", err)
+		}
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+// eventsTimeout bounds listing the events of a failure.
+const eventsTimeout = 10 * time.Second
+
+// printEvents prints the recent events of the objects err is about to
+// stderr. Events only add context to err, so failing to list them is just
+// noted. They are listed within eventsTimeout of their own, as the failure
+// may be that the run's context expired.
+func printEvents(d *deployer.Deployer, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), eventsTimeout)
+	defer cancel()
+	events, lerr := d.RelatedEvents(ctx, err)
+	if lerr != nil {
+		fmt.Fprintf(os.Stderr, "events unavailable: %s\n", lerr)
+		return
+	}
+	if len(events) == 0 {
+		return
+	}
+	fmt.Fprintln(os.Stderr, "recent events:")
+	tw := tabwriter.NewWriter(os.Stderr, 0, 4, 2, ' ', 0)
+	for _, e := range events {
+		fmt.Fprintf(tw, "  %s\t%s\t%s\t%s\t%s\n", e.Time.Format(time.RFC3339), e.Type, e.Reason, e.Object, e.Message)
+	}
+	tw.Flush()
+}
+
+// printRolloutError prints a failed rollout, listing each stuck container
+// and the logs collected from crash looping ones.
+func printRolloutError(err error) {
+	var failed *deployer.RolloutFailedError
+	if !errors.As(err, &failed) {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "deployment %s rollout failed: %s\n", failed.Name, failed.Reason)
+	for _, p := range failed.Pods {
+		fmt.Fprintf(os.Stderr, "  pod %s container %s: %s: %s\n", p.Pod, p.Container, p.Reason, p.Message)
+		if p.Logs != "" {
+			fmt.Fprintf(os.Stderr, "  last logs of %s/%s:\n%s\n", p.Pod, p.Container, p.Logs)
+		}
+	}
+}
+
+// runReconciler reconciles until ctx is done and returns the process exit
+// code. Failed cycles are logged and retried. With election, it only
+// reconciles while leading.
+func runReconciler(ctx context.Context, log logr.Logger, d *deployer.Deployer, interval time.Duration, election *deployer.LeaderElection) int {
+	reconcile := func(ctx context.Context) error {
+		return d.RunReconciler(ctx, interval, func(result *deployer.ReconcileResult, err error) {
+			if err != nil {
+				log.Error(err, "Reconcile failed", "namespace", d.Namespace())
+				return
+			}
+			log.Info("Reconciled", "namespace", d.Namespace(), "changed", result.Changed, "inSync", len(result.InSync))
+		})
+	}
+	var err error
+	if election != nil {
+		err = d.RunElected(ctx, *election, func(ctx context.Context) {
+			if err := reconcile(ctx); err != nil {
+				log.Error(err, "Reconcile failed", "namespace", d.Namespace())
+			}
+		})
+	} else {
+		err = reconcile(ctx)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		return exitCode(err)
+	}
+	log.Info("Stopped reconciling", "namespace", d.Namespace())
+	return 0
+}
+
+// runCanary deploys the canary, printing it as format unless that is table,
+// waits for it to roll out when wait is set, and returns the process exit
+// code.
+func runCanary(ctx context.Context, log logr.Logger, d *deployer.Deployer, weight int32, wait bool, timeout time.Duration, format string) int {
+	run.start("deploying the canary")
+	result, err := d.DeployCanary(ctx, weight)
+	if result != nil {
+		run.record(result.Applied)
+		if perr := printResult(format, resultOf(d, false, result.Applied, err)); perr != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", perr)
+			return exitFailure
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		run.failed(err)
+		return exitCode(err)
+	}
+	if result.Ingress {
+		fmt.Fprintf(out, "Canary gets %d%% of the ingress traffic with %d replicas\n", weight, result.Replicas)
+	} else {
+		fmt.Fprintf(out, "Canary runs %d replicas next to %d stable ones behind service %s\n", result.Replicas, result.StableReplicas, objectRef(d.Namespace(), d.ServiceName()))
+	}
+	if !wait {
+		return 0
+	}
+	run.start("waiting for the canary")
+	if err := d.WaitForCanary(ctx, timeout); err != nil {
+		printRolloutError(err)
+		run.failed(err)
+		return exitCode(err)
+	}
+	log.Info("Canary rollout complete", "namespace", d.Namespace())
+	return 0
+}
@@ -0,0 +1,108 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/go-logr/logr"
+	"github.com/raihankhan/ecommerceApi-client-go/pkg/deployer"
+	"github.com/spf13/cobra"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultDiagnosticsDir is where diagnose writes its bundle without
+	// -diagnostics-dir.
+	defaultDiagnosticsDir = "diagnostics"
+	// diagnosticsTimeout bounds collecting a bundle, which starts after
+	// -timeout may already have run out.
+	diagnosticsTimeout = time.Minute
+	// fullVerbosity is the -v of the tool log in a bundle, above that of
+	// any message.
+	fullVerbosity = 10
+)
+
+// lockedBuffer is a buffer safe for the concurrent writes of a logger.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// Bytes returns a copy of what was written so far.
+func (b *lockedBuffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]byte(nil), b.buf.Bytes()...)
+}
+
+// toolLog is the log of this process at fullVerbosity, kept by prepare
+// when a bundle may be written.
+var toolLog *lockedBuffer
+
+// withToolLog returns log, also writing every entry at fullVerbosity to
+// toolLog.
+func withToolLog(log logr.Logger) logr.Logger {
+	toolLog = &lockedBuffer{}
+	return teeLogger{log, jsonLogger{out: toolLog, mu: &sync.Mutex{}, verbosity: fullVerbosity}}
+}
+
+func newDiagnoseCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "diagnose",
+		Short: "Write a bundle for debugging the release to -diagnostics-dir",
+		Long: "Write a bundle for debugging the release to -diagnostics-dir, ./" + defaultDiagnosticsDir + " by default: the desired\n" +
+			"and live objects, the pods with the last lines of their logs, the recent events of the\n" +
+			"namespace and the tool's log, with an index.json of them. Secret values are redacted.",
+		Args: cobra.NoArgs,
+		Run: func(*cobra.Command, []string) {
+			s := prepare("diagnose")
+			defer s.connect()()
+			dir := *diagnosticsDir
+			if dir == "" {
+				dir = defaultDiagnosticsDir
+			}
+			index, err := s.d.CollectDiagnostics(s.ctx, dir, deployer.DiagnosticsOptions{ToolLog: toolLog.Bytes()})
+			if err != nil {
+				fail(err)
+			}
+			printDiagnostics(dir, index)
+		},
+	}
+	addFlags(cmd.Flags(), objectFlags, clusterFlags, []string{"diagnostics-dir"})
+	return cmd
+}
+
+// collectDiagnostics writes the -diagnostics-dir bundle of a run failed
+// with err, once the cluster was connected to. Failing to write it is only
+// printed, so the run still exits with the code of err.
+func collectDiagnostics(err error) {
+	if *diagnosticsDir == "" || run.d == nil {
+		return
+	}
+	d := run.d
+	run.d = nil
+	ctx, cancel := context.WithTimeout(context.Background(), diagnosticsTimeout)
+	defer cancel()
+	index, cerr := d.CollectDiagnostics(ctx, *diagnosticsDir, deployer.DiagnosticsOptions{Err: err, ToolLog: toolLog.Bytes()})
+	if cerr != nil {
+		fmt.Fprintf(os.Stderr, "diagnostics unavailable: %v\n", cerr)
+		return
+	}
+	printDiagnostics(*diagnosticsDir, index)
+}
+
+// printDiagnostics prints where the bundle went and what it misses.
+func printDiagnostics(dir string, index *deployer.DiagnosticsIndex) {
+	fmt.Fprintf(os.Stderr, "diagnostics written to %s, %d files\n", dir, len(index.Files))
+	for _, f := range index.Failures {
+		fmt.Fprintf(os.Stderr, "  not collected: %s\n", f)
+	}
+}
@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"github.com/raihankhan/ecommerceApi-client-go/pkg/deployer"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+func newDiffCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "diff",
+		Short: "Print how the cluster differs from the objects, as kubectl diff does",
+		Args:  cobra.NoArgs,
+		Run: func(*cobra.Command, []string) {
+			s := prepare("diff")
+			defer s.connect()()
+			d, ctx := s.d, s.ctx
+			os.Exit(runDiff(ctx, d))
+		},
+	}
+	addFlags(cmd.Flags(), objectFlags, applyFlags)
+	return cmd
+}
+
+// runDiff prints how the cluster differs from the desired state and returns
+// the exit code kubectl diff would: 0 when in sync, 1 when there are
+// differences and 2 on errors.
+func runDiff(ctx context.Context, d *deployer.Deployer) int {
+	diffs, err := d.Diff(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		return 2
+	}
+	code := 0
+	for _, od := range diffs {
+		if od.Diff != "" {
+			fmt.Print(od.Diff)
+			code = 1
+		}
+	}
+	return code
+}
@@ -0,0 +1,105 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/go-logr/logr"
+	"github.com/raihankhan/ecommerceApi-client-go/pkg/deployer"
+	"github.com/spf13/pflag"
+	"os"
+	"strings"
+)
+
+// envPrefix prefixes the environment variables mirroring the flags.
+const envPrefix = "ECOMMERCE_"
+
+// Where the value of a flag came from, in the order they win.
+const (
+	sourceFlag    = "flag"
+	sourceEnv     = "env"
+	sourceFile    = "file"
+	sourceDefault = "default"
+)
+
+// secretFlags are the flags whose values are redacted in the logs: the
+// credentials, and the settings and variables of the API, which often are.
+var secretFlags = map[string]bool{
+	"registry-auth":     true,
+	"db-secret-literal": true,
+	"config":            true,
+	"env":               true,
+	"env-from-file":     true,
+}
+
+// envName returns the variable mirroring the named flag, such as
+// ECOMMERCE_MAX_RETRIES for -max-retries.
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// applyEnv sets every flag not given on the command line from its variable,
+// when that is set and not empty, and returns the names of the flags set. A
+// repeatable flag takes one value per line.
+func applyEnv() ([]string, error) {
+	given := givenFlags()
+	var (
+		set []string
+		err error
+	)
+	flags.VisitAll(func(f *pflag.Flag) {
+		v := os.Getenv(envName(f.Name))
+		if v == "" || given[f.Name] || err != nil {
+			return
+		}
+		values := []string{v}
+		if _, ok := f.Value.(*stringList); ok {
+			values = strings.Split(strings.TrimRight(v, "\n"), "\n")
+		}
+		for _, v := range values {
+			if e := flags.Set(f.Name, v); e != nil {
+				if !secretFlags[f.Name] {
+					e = fmt.Errorf("invalid value %q: %w", v, e)
+				}
+				err = &deployer.ValidationError{Field: f.Name, Err: fmt.Errorf("$%s: %w", envName(f.Name), e)}
+				return
+			}
+		}
+		set = append(set, f.Name)
+	})
+	return set, err
+}
+
+// logEffectiveConfig logs the value of every flag once the environment and
+// the config file are applied, and where each value that isn't the default
+// came from, as given by sources.
+func logEffectiveConfig(log logr.Logger, sources map[string]string) {
+	var values []interface{}
+	flags.VisitAll(func(f *pflag.Flag) {
+		v := f.Value.String()
+		if secretFlags[f.Name] && v != "" {
+			v = deployer.Redacted
+		}
+		source, ok := sources[f.Name]
+		if !ok {
+			source = sourceDefault
+		}
+		values = append(values, f.Name, v+" ("+source+")")
+	})
+	log.V(2).Info("Effective configuration", values...)
+}
+
+// flagSources returns the sources of the flags given on the command line
+// along with those of the flags set from the environment and the config
+// file.
+func flagSources(given map[string]bool, env, file []string) map[string]string {
+	sources := map[string]string{}
+	for name := range given {
+		sources[name] = sourceFlag
+	}
+	for _, name := range env {
+		sources[name] = sourceEnv
+	}
+	for _, name := range file {
+		sources[name] = sourceFile
+	}
+	return sources
+}
@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/raihankhan/ecommerceApi-client-go/pkg/deployer"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+	utilexec "k8s.io/client-go/util/exec"
+	"os"
+)
+
+func newExecCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "exec [--] command [args...]",
+		Short: "Run a command in an API pod",
+		Args:  cobra.MinimumNArgs(1),
+		Run: func(_ *cobra.Command, args []string) {
+			s := prepare("exec")
+			defer s.connect()()
+			d, sigCtx := s.d, s.sigCtx
+			os.Exit(runExec(sigCtx, d, deployer.ExecOptions{
+				Pod:       *pod,
+				Container: *container,
+				Command:   execCommand(args),
+				TTY:       *tty,
+			}, *stdin))
+		},
+	}
+	addFlags(cmd.Flags(), []string{"pod", "container", "stdin", "tty"})
+	return cmd
+}
+
+// execCommand returns the command given after exec, dropping the -- that
+// separates it from the tool's flags.
+func execCommand(args []string) []string {
+	if len(args) > 0 && args[0] == "--" {
+		return args[1:]
+	}
+	return args
+}
+
+// runExec runs a command in an API pod and returns the process exit code,
+// which is the command's own when it exits non-zero.
+func runExec(ctx context.Context, d *deployer.Deployer, opts deployer.ExecOptions, stdin bool) int {
+	opts.Stdout, opts.Stderr = os.Stdout, os.Stderr
+	if stdin {
+		opts.Stdin = os.Stdin
+	}
+	if opts.TTY {
+		fd := int(os.Stdin.Fd())
+		if !term.IsTerminal(fd) {
+			fmt.Fprintln(os.Stderr, "-t needs stdin to be a terminal")
+			return exitValidation
+		}
+		if w, h, err := term.GetSize(int(os.Stdout.Fd())); err == nil {
+			opts.Width, opts.Height = uint16(w), uint16(h)
+		}
+		state, err := term.MakeRaw(fd)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to put the terminal in raw mode: %s\n", err)
+			return exitFailure
+		}
+		defer term.Restore(fd, state)
+	}
+	err := d.Exec(ctx, opts)
+	var exit utilexec.ExitError
+	switch {
+	case err == nil:
+		return 0
+	case errors.As(err, &exit):
+		return exit.ExitStatus()
+	}
+	if opts.TTY {
+		// Raw mode needs a carriage return to start the message on a new
+		// line.
+		fmt.Fprint(os.Stderr, "\r")
+	}
+	fmt.Fprintf(os.Stderr, "%s\n", err)
+	return exitCode(err)
+}
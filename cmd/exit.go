@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/raihankhan/ecommerceApi-client-go/pkg/deployer"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"os"
+)
+
+// Exit codes, so scripts can tell failure classes apart.
+const (
+	exitFailure    = 1
+	exitConfig     = 2
+	exitConflict   = 3
+	exitTimeout    = 4
+	exitValidation = 5
+	exitSmokeTest  = 6
+)
+
+// configError marks failures to load or use the cluster configuration.
+type configError struct {
+	err error
+}
+
+func (e *configError) Error() string { return e.err.Error() }
+
+func (e *configError) Unwrap() error { return e.err }
+
+// exitCode maps err to the exit code of its failure class.
+func exitCode(err error) int {
+	var (
+		cfg        *configError
+		validation *deployer.ValidationError
+		conflict   *deployer.ConflictError
+		scaled     *deployer.ScaledByHPAError
+		timeout    *deployer.RolloutTimeoutError
+		denied     *deployer.PermissionError
+		compat     *deployer.CompatibilityError
+		smoke      *deployer.SmokeTestError
+	)
+	switch {
+	case errors.As(err, &smoke):
+		// Before the timeouts, as a request timing out is a failed test.
+		return exitSmokeTest
+	case errors.As(err, &cfg), errors.As(err, &denied), errors.As(err, &compat), apierrors.IsUnauthorized(err), apierrors.IsForbidden(err):
+		return exitConfig
+	case errors.As(err, &conflict), errors.As(err, &scaled), apierrors.IsConflict(err), apierrors.IsAlreadyExists(err):
+		return exitConflict
+	case errors.As(err, &timeout), errors.Is(err, wait.ErrWaitTimeout), errors.Is(err, context.DeadlineExceeded),
+		apierrors.IsTimeout(err), apierrors.IsServerTimeout(err):
+		return exitTimeout
+	case errors.As(err, &validation), apierrors.IsInvalid(err), apierrors.IsBadRequest(err):
+		return exitValidation
+	}
+	return exitFailure
+}
+
+// fail prints err and exits with the code of its failure class.
+func fail(err error) {
+	fmt.Fprintf(os.Stderr, "%s\n", err)
+	run.failed(err)
+	os.Exit(exitCode(err))
+}
@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/raihankhan/ecommerceApi-client-go/pkg/deployer"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"testing"
+)
+
+func TestExitCode(t *testing.T) {
+	deployments := deployer.DeploymentResource.GroupResource()
+	forbidden := &deployer.DeployError{
+		GVR:  deployer.DeploymentResource,
+		Name: "apiserver",
+		Op:   deployer.OpApply,
+		Err:  apierrors.NewForbidden(deployments, "apiserver", errors.New("RBAC denied")),
+	}
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"forbidden apply", forbidden, exitConfig},
+		{"forbidden apply within a step", &deployer.ApplyError{Errs: []error{forbidden}, NotApplied: []string{"Ingress/server-ingress"}}, exitConfig},
+		{"unauthorized", apierrors.NewUnauthorized("token expired"), exitConfig},
+		{"config", &configError{errors.New("no kubeconfig")}, exitConfig},
+		{"conflict", &deployer.ConflictError{Kind: "Deployment", Name: "apiserver", Err: apierrors.NewConflict(deployments, "apiserver", errors.New("conflict"))}, exitConflict},
+		{"already exists", apierrors.NewAlreadyExists(schema.GroupResource{Resource: "namespaces"}, "shop"), exitConflict},
+		{"rollout timeout", &deployer.RolloutTimeoutError{Name: "apiserver"}, exitTimeout},
+		{"deadline", fmt.Errorf("waiting: %w", context.DeadlineExceeded), exitTimeout},
+		{"validation", &deployer.ValidationError{Field: "replicas", Err: errors.New("too many")}, exitValidation},
+		{"invalid", apierrors.NewInvalid(schema.GroupKind{Group: "apps", Kind: "Deployment"}, "apiserver", nil), exitValidation},
+		{"smoke test", &deployer.SmokeTestError{URL: "http://localhost:8080/healthz", Err: errors.New("status 500")}, exitSmokeTest},
+		{"other", errors.New("boom"), exitFailure},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := exitCode(tt.err); got != tt.want {
+				t.Errorf("exitCode(%v) = %d, want %d", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClustersExitCode(t *testing.T) {
+	timeout := &deployer.RolloutTimeoutError{Name: "apiserver"}
+	tests := []struct {
+		name string
+		errs []error
+		want int
+	}{
+		{"all succeeded", []error{nil, nil}, 0},
+		{"first failure wins", []error{nil, timeout, errors.New("boom")}, exitTimeout},
+		{"failure after one stopped", []error{deployer.ErrNotRun, timeout}, exitTimeout},
+		{"only stopped", []error{nil, deployer.ErrNotRun}, exitFailure},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := clustersExitCode(tt.errs); got != tt.want {
+				t.Errorf("clustersExitCode(%v) = %d, want %d", tt.errs, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/raihankhan/ecommerceApi-client-go/pkg/deployer"
+	"github.com/spf13/cobra"
+	"io"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"os"
+	"path/filepath"
+	"sigs.k8s.io/yaml"
+	"strings"
+)
+
+// exportFileName is the file export writes obj to, <kind>-<name>.yaml in
+// lower case.
+func exportFileName(obj *unstructured.Unstructured) string {
+	return strings.ToLower(obj.GetKind()+"-"+obj.GetName()) + ".yaml"
+}
+
+// exportManifest encodes obj with sorted keys, unredacted, as -manifests
+// reads it back. Manifests are templates, so any "{{" in a value is escaped
+// to render as itself.
+func exportManifest(obj *unstructured.Unstructured) ([]byte, error) {
+	data, err := yaml.Marshal(obj.Object)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode %s %s: %w", obj.GetKind(), obj.GetName(), err)
+	}
+	return bytes.ReplaceAll(data, []byte("{{"), []byte(`{{"{{"}}`)), nil
+}
+
+// exportDir writes each object to its own file in dir, creating dir if
+// needed. Secrets are written readable only by their owner.
+func exportDir(dir string, objects []*unstructured.Unstructured) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+	for _, obj := range objects {
+		data, err := exportManifest(obj)
+		if err != nil {
+			return err
+		}
+		perm := os.FileMode(0o644)
+		if obj.GetKind() == "Secret" {
+			perm = 0o600
+		}
+		path := filepath.Join(dir, exportFileName(obj))
+		if err := os.WriteFile(path, data, perm); err != nil {
+			return fmt.Errorf("failed to write %s: %w", path, err)
+		}
+		fmt.Fprintf(os.Stderr, "%s %s exported to %s\n", obj.GetKind(), obj.GetName(), path)
+	}
+	return nil
+}
+
+// exportStream writes the objects to w as a multi-document YAML stream.
+func exportStream(w io.Writer, objects []*unstructured.Unstructured) error {
+	for _, obj := range objects {
+		data, err := exportManifest(obj)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "---\n%s", data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func newExportCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "export",
+		Short: "Write the objects to files for review or GitOps",
+		Args:  cobra.NoArgs,
+		Run:   func(*cobra.Command, []string) { runExport(prepare("export").opts) },
+	}
+	addFlags(cmd.Flags(), objectFlags, []string{"out-dir", "out"})
+	return cmd
+}
+
+// runExport writes the objects of opts to -out-dir or -out.
+func runExport(opts deployer.Options) {
+	if (*outDir == "") == (*outFile == "") {
+		fail(&deployer.ValidationError{Field: "out-dir", Err: errors.New("export needs exactly one of -out-dir and -out")})
+	}
+	objects, err := deployer.Render(opts)
+	if err != nil {
+		fail(err)
+	}
+	switch {
+	case *outDir != "":
+		err = exportDir(*outDir, objects)
+	case *outFile == "-":
+		err = exportStream(os.Stdout, objects)
+	default:
+		var f *os.File
+		if f, err = os.Create(*outFile); err != nil {
+			fail(err)
+		}
+		if err = exportStream(f, objects); err == nil {
+			err = f.Close()
+		}
+	}
+	if err != nil {
+		fail(err)
+	}
+}
@@ -0,0 +1,356 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/raihankhan/ecommerceApi-client-go/pkg/deployer"
+	"github.com/raihankhan/ecommerceApi-client-go/pkg/values"
+	"github.com/spf13/pflag"
+	"k8s.io/client-go/util/homedir"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// flags holds every flag of the tool. Each command adds those it takes, so
+// the environment variables and the config file see them all.
+var flags = pflag.NewFlagSet("ecommerceApi-client-go", pflag.ContinueOnError)
+
+var (
+	kubeconfig                 = flags.String("kubeconfig", defaultKubeconfig(), "absolute path to the kubeconfig file")
+	kubeContext                = flags.String("context", "", "kubeconfig context to use instead of the current-context")
+	namespace                  = flags.String("namespace", deployer.DefaultNamespace, "namespace to deploy the resources to")
+	historyLimit               = flags.Int("revision-history-limit", -1, "old replica sets the deployment keeps for rollback; -1 keeps the cluster default of 10")
+	toRevision                 = flags.Int64("to-revision", 0, "revision the rollback subcommand restores; 0 restores the previous one")
+	owner                      = flags.String("owner", "", `set ownerReferences: "deployment" owns the services and ingress, "release" a release record ConfigMap owns everything`)
+	release                    = flags.String("name", deployer.DefaultRelease, "release name prefixing the object names; "+deployer.DefaultRelease+" keeps the unprefixed names")
+	createNamespace            = flags.Bool("create-namespace", false, "create the namespace if it doesn't exist")
+	image                      = flags.String("image", deployer.DefaultImage, "container image of the API")
+	tag                        = flags.String("tag", "", "override the tag of -image")
+	pinDigest                  = flags.Bool("pin-digest", false, "resolve the tag of -image to its digest in the registry, logging in with -registry-auth or the docker config, and deploy image@sha256:..., recording the tag in the "+deployer.PinnedFromAnnotation+" annotation; fails when the tag doesn't exist")
+	verifySignature            = flags.Bool("verify-signature", false, "verify the cosign signature of -image before anything is applied, against -cosign-key or the keyless -certificate-identity, and deploy the verified digest, recording it and the signer in annotations of the deployment")
+	cosignKey                  = flags.String("cosign-key", "", "with -verify-signature, the cosign public key file the signature must verify against, e.g. cosign.pub")
+	certificateIdentity        = flags.String("certificate-identity", "", "with -verify-signature, the email or URI of the keyless signer the Fulcio certificate must name")
+	certificateOIDCIssuer      = flags.String("certificate-oidc-issuer", "", "with -certificate-identity, the OIDC issuer of the identity, e.g. https://token.actions.githubusercontent.com")
+	fulcioRoot                 = flags.String("fulcio-root", "", "with -certificate-identity, the PEM file of the Fulcio root certificates the certificate must chain to")
+	rekorURL                   = flags.String("rekor-url", "", "with -verify-signature, the transparency log, or a mirror of it, whose key checks the log entry of the signature; defaults to "+deployer.DefaultRekorURL)
+	insecureIgnoreTlog         = flags.Bool("insecure-ignore-tlog", false, "with -verify-signature, accept signatures without checking their transparency log entry, for air-gapped clusters without a -rekor-url mirror")
+	replicas                   = flags.Int("replicas", int(deployer.DefaultReplicas), "number of API replicas; ignored on update when an HPA scales the deployment")
+	maxReplicas                = flags.Int("max-replicas", int(deployer.DefaultMaxReplicas), "largest value accepted for -replicas")
+	autoscale                  = flags.String("autoscale", "", "create an HPA for the API as min=2,max=10,cpu=70[,memory=80]")
+	pdbMinAvailable            = flags.String("pdb-min-available", "", "create a PodDisruptionBudget keeping this many API pods, or a percentage, available")
+	pdbMaxUnavailable          = flags.String("pdb-max-unavailable", "", "create a PodDisruptionBudget allowing this many API pods, or a percentage, to be unavailable")
+	networkPolicy              = flags.Bool("network-policy", false, "create a NetworkPolicy letting only the namespace and -ingress-controller-namespace reach the API pods")
+	ingressControllerNamespace = flags.String("ingress-controller-namespace", "", "namespace of the ingress controller, allowed through -network-policy")
+	rbac                       = flags.Bool("rbac", false, "bind a Role to the server-sa service account of the API pods and mount its token")
+	rbacRules                  = listFlag("rbac-rule", `rule of the -rbac Role as verbs:resources, e.g. "get,list:configmaps,deployments.apps"; repeatable, defaults to reading configmaps`)
+	cpuRequest                 = flags.String("cpu-request", "", "CPU request of the API container; defaults to "+deployer.DefaultCPURequest+" unless -cpu-limit is set")
+	cpuLimit                   = flags.String("cpu-limit", "", "CPU limit of the API container")
+	memoryRequest              = flags.String("memory-request", "", "memory request of the API container; defaults to "+deployer.DefaultMemoryRequest+" unless -memory-limit is set")
+	memoryLimit                = flags.String("memory-limit", "", "memory limit of the API container")
+	noResources                = flags.Bool("no-resources", false, "set no requests or limits on the API container")
+	nodeSelectors              = listFlag("node-selector", "node label the API, database and cache pods are placed by, as key=value; repeatable")
+	tolerations                = listFlag("toleration", "taint the API, database and cache pods tolerate, as key=value:NoSchedule, key:NoSchedule for any value, or key or key:Exists for every effect; repeatable")
+	podAntiAffinity            = flags.String("pod-anti-affinity", "", "spread the API pods over the nodes: soft prefers one per node, hard requires it")
+	topologySpread             = listFlag("topology-spread", "spread the API pods over zone, hostname or another node label, as zone=1 with the largest difference in pods allowed, and :DoNotSchedule to enforce it rather than ScheduleAnyway; repeatable")
+	affinity                   = flags.String("affinity", "", "affinity of the API pods as YAML or JSON, passed through verbatim; set by the affinity block of the config file")
+	podSecurity                = flags.String("pod-security", "", "set the fields below not given to values passing the "+deployer.PodSecurityRestricted+" Pod Security Standard; "+deployer.PodSecurityRestricted+" is the only preset")
+	runAsNonRoot               = flags.Bool("run-as-non-root", false, "refuse to start the containers as root; left to the image unless given")
+	runAsUser                  = flags.Int64("run-as-user", 0, "uid the API container runs as; left to the image unless given")
+	runAsGroup                 = flags.Int64("run-as-group", 0, "gid the API container runs as; left to the image unless given")
+	fsGroup                    = flags.Int64("fs-group", 0, "group owning the volumes of the API pods")
+	readOnlyRootFS             = flags.Bool("read-only-root-filesystem", false, "mount the API image read-only, with an emptyDir at /tmp; off unless given")
+	allowPrivilegeEscalation   = flags.Bool("allow-privilege-escalation", false, "whether processes may gain privileges, e.g. through setuid binaries; left to the runtime unless given")
+	dropCapabilities           = listFlag("drop-capability", "capability dropped from the containers, e.g. ALL; repeatable")
+	seccompProfile             = flags.String("seccomp-profile", "", "seccomp profile of the pods: "+deployer.SeccompRuntimeDefault+" or "+deployer.SeccompUnconfined)
+	healthPath                 = flags.String("health-path", deployer.DefaultHealthPath, "path the HTTP readiness and liveness probes request")
+	probeType                  = flags.String("probe-type", deployer.ProbeHTTP, "probe the API with http GETs of -health-path or tcp connects")
+	startupThreshold           = flags.Int("startup-probe-failure-threshold", 0, "add a startup probe allowing this many failures, 10s apart, for slow boots")
+	serviceType                = flags.String("service-type", deployer.DefaultServiceType, "type of the server-svc service: ClusterIP, NodePort or LoadBalancer")
+	exposeNodePort             = flags.Bool("expose-nodeport", false, "also create the nodeport-svc NodePort service")
+	lbPreset                   = flags.String("lb-preset", "", "with -service-type=LoadBalancer, the cloud load balancer to ask for: aws-nlb, aws-internal, gke-internal or azure-internal")
+	lbAnnotationList           = listFlag("lb-annotation", "annotation of the LoadBalancer service as key=value, over those of -lb-preset; repeatable, the last of a key wins")
+	sessionAffinity            = flags.String("session-affinity", "", "ClientIP to send each client to the same API pod, or None")
+	sessionAffinityTimeout     = flags.Int("session-affinity-timeout", 0, "with -session-affinity=ClientIP, how many seconds a client sticks to its pod; defaults to 10800")
+	externalTrafficPolicy      = flags.String("external-traffic-policy", "", "Local to keep the client source IP on the NodePort and LoadBalancer services, or Cluster")
+	headlessService            = flags.Bool("headless-service", false, "also create the <name>-headless service, with clusterIP None, resolving to the API pod addresses")
+	externalNameServices       = listFlag("external-name-service", "service of the release resolving to an outside host, as name=legacy-db,host=db.example.com; repeatable")
+	nodePort                   = flags.Int("node-port", 0, "fixed node port of the NodePort service; 0 lets the cluster allocate one")
+	hosts                      = listFlag("host", "host the ingress routes to the API; repeatable, defaults to "+deployer.DefaultHost)
+	ports                      = listFlag("port", "API port as name=grpc,container=9000[,service=90], exposed by the services under its name; repeatable, defaults to name=http,container=8080")
+	paths                      = listFlag("path", `ingress path as path:pathType[:port], e.g. "/api/v2:Exact" or "/grpc:Prefix:grpc", routed to the named -port or the first; repeatable, defaults to "/:Prefix"`)
+	routing                    = flags.String("routing", "", "how the API is reached: ingress, gateway for a Gateway API HTTPRoute, or route for OpenShift Routes; defaults to what the release was deployed with, or route on OpenShift and ingress elsewhere")
+	gatewayName                = flags.String("gateway-name", "", "with -routing=gateway, the Gateway the HTTPRoute attaches to")
+	gatewayNamespace           = flags.String("gateway-namespace", "", "with -routing=gateway, the namespace of the Gateway; defaults to -namespace")
+	routeEdgeTLS               = flags.Bool("route-edge-tls", false, "with -routing=route, terminate TLS at the router with its default certificate; implied by -tls-cert, whose certificate the Routes embed")
+	routeInsecurePolicy        = flags.String("route-insecure-policy", "", "with TLS Routes, what the router does with plain HTTP requests: Redirect, Allow or None")
+	ingressClass               = flags.String("ingress-class", "", "spec.ingressClassName of the ingress; defaults to the cluster's default IngressClass")
+	ingressAnnotationList      = listFlag("ingress-annotation", "annotation of the ingress as key=value, over those the tool sets itself; repeatable, the last of a key wins")
+	proxyBodySize              = flags.String("proxy-body-size", "", "with an ingress-nginx ingress class, the largest request body accepted, e.g. 8m; 0 lifts the limit")
+	sslRedirect                = flags.Bool("ssl-redirect", false, "with an ingress-nginx ingress class, whether plain HTTP requests are redirected to HTTPS; left to the controller unless given")
+	rewriteTarget              = flags.String("rewrite-target", "", "with an ingress-nginx ingress class, the path requests are sent to the API with, e.g. /$2")
+	metricsPort                = flags.Int("metrics-port", 0, "container port the API serves metrics on, added to server-svc and scraped by a ServiceMonitor with the prometheus-operator")
+	metricsPath                = flags.String("metrics-path", "", "path of the metrics the ServiceMonitor scrapes; defaults to "+deployer.DefaultMetricsPath)
+	serviceMonitorLabels       = listFlag("servicemonitor-label", "label of the ServiceMonitor as key=value, matching the serviceMonitorSelector of the Prometheus; repeatable")
+	tlsSecret                  = flags.String("tls-secret", "", "secret holding the ingress TLS certificate; with -tls-cert it is created, otherwise it must exist")
+	tlsCert                    = flags.String("tls-cert", "", "PEM certificate file for the ingress, stored in a secret the tool manages")
+	tlsKey                     = flags.String("tls-key", "", "PEM private key file matching -tls-cert")
+	certManagerIssuer          = flags.String("cert-manager-issuer", "", "cert-manager ClusterIssuer that issues the ingress certificate")
+	waitCertificate            = flags.Bool("wait-certificate", false, "with -cert-manager-issuer, wait up to -timeout for the certificate to be ready")
+	appConfigFile              = flags.String("config-file", "", "properties file of key=value application settings stored in the server-config ConfigMap")
+	configEntries              = listFlag("config", "application setting as key=value; repeatable, wins over -config-file")
+	dbSecretFile               = flags.String("db-secret-file", "", "env file of KEY=value database credentials stored in the server-db-credentials secret")
+	dbSecretLiterals           = listFlag("db-secret-literal", "database credential as KEY=value; repeatable, wins over -db-secret-file")
+	configMountPath            = flags.String("config-mount-path", "", "mount the config as files in this directory instead of environment variables")
+	envFile                    = flags.String("env-from-file", "", "env file of KEY=value variables set on the API container")
+	env                        = listFlag("env", "variable set on the API container as KEY=value; repeatable, wins over -env-from-file")
+	envFromSecret              = listFlag("env-from-secret", "variable read from a secret key as KEY=secret/key; repeatable")
+	envFromConfigMap           = listFlag("env-from-configmap", "variable read from a config map key as KEY=configmap/key; repeatable")
+	labels                     = listFlag("label", "label added to every object and pod template as key=value; repeatable")
+	annotations                = listFlag("annotation", "annotation added to every object and pod template as key=value; repeatable")
+	pullSecrets                = listFlag("image-pull-secret", "existing secret the API pods pull their image with; repeatable")
+	registryAuth               = flags.String("registry-auth", "", "user:password@registry login stored in the server-registry-auth pull secret")
+	pvcs                       = listFlag("pvc", "persistent volume claim mounted into the API container, as name=data,size=5Gi,mountPath=/var/lib/ecommerce[,storageClass=standard]; repeatable")
+	initContainers             = listFlag("init-container", "container run before the API container, as name=wait-db,image=busybox:1.36[,mount=volume:/path[:ro]],command=until nc -z postgres 5432; do sleep 1; done, or as JSON; repeatable")
+	sidecars                   = listFlag("sidecar", "container run next to the API container, in the -init-container format; repeatable")
+	cronJobs                   = listFlag("cronjob", `cron job run in the API container, as name=cleanup,schedule="0 3 * * *",command=./ecommerce carts expire[,concurrencyPolicy=Forbid,successfulJobsHistoryLimit=3,failedJobsHistoryLimit=1]; the command, unless quoted, goes last; repeatable`)
+	withPostgres               = flags.Bool("with-postgres", false, "also deploy a PostgreSQL StatefulSet and point the API at it")
+	postgresTag                = flags.String("postgres-tag", deployer.DefaultPostgresTag, "tag of the postgres image")
+	postgresStorage            = flags.String("postgres-storage", deployer.DefaultPostgresStorage, "size of the database volume")
+	postgresStorageClass       = flags.String("postgres-storage-class", "", "storage class of the database volume; defaults to the cluster default")
+	withRedis                  = flags.Bool("with-redis", false, "also deploy a redis cache and point the API at it")
+	redisTag                   = flags.String("redis-tag", deployer.DefaultRedisTag, "tag of the redis image")
+	redisMaxMemory             = flags.String("redis-maxmemory", deployer.DefaultRedisMaxMemory, "memory redis caches in before evicting the least recently used keys, e.g. 1gb")
+	redisAuth                  = flags.Bool("redis-auth", false, "with -with-redis, protect the cache with a generated password stored in a secret")
+	manifests                  = flags.String("manifests", "", "directory of *.yaml/*.yml manifests to apply instead of the built-in resources")
+	extraManifests             = flags.String("extra-manifests", "", "directory of *.yaml/*.yml manifests to apply after the built-in resources")
+	overlays                   = listFlag("overlay", "directory of strategic merge and JSON6902 patches applied to the objects; repeatable, later ones win")
+	typed                      = flags.Bool("typed", false, "build the built-in resources from typed structs and apply them with the typed clientset")
+	valuesFile                 = flags.String("values", "", "YAML file of values the -manifests templates are rendered with")
+	overrides                  = listFlag("set", "set a template value as key=value, with dots for nested keys; repeatable, later ones win")
+	fieldManager               = flags.String("field-manager", deployer.DefaultFieldManager, "field manager name used for server-side apply")
+	forceConflicts             = flags.Bool("force-conflicts", false, "take ownership of fields owned by other field managers")
+	follow                     = flags.Bool("follow", false, "with the logs subcommand, keep streaming, including the pods replacing terminated ones")
+	tail                       = flags.Int64("tail", -1, "with the logs subcommand, start from this many lines before the end of each pod's logs; -1 shows all")
+	since                      = flags.Duration("since", 0, "with the logs subcommand, only show lines newer than this, e.g. 10m")
+	container                  = flags.String("container", "", "with the logs and exec subcommands, the container to use; defaults to the API container")
+	pod                        = flags.String("pod", "", "with the exec subcommand, the pod to run in; defaults to a running API pod")
+	stdin                      = flags.BoolP("stdin", "i", false, "with the exec subcommand, pass stdin to the command")
+	tty                        = flags.BoolP("tty", "t", false, "with the exec subcommand, allocate a TTY; stdin must be a terminal; -it passes both")
+	localPort                  = flags.Int("local-port", 8080, "with the port-forward subcommand, the localhost port to listen on; 0 picks a free one")
+	podPort                    = flags.Int("pod-port", 0, "with the port-forward subcommand, the pod port to forward to; defaults to the targetPort of the service")
+	reconcileInterval          = flags.Duration("reconcile-interval", 0, "keep running, re-applying the objects whenever they drift from the desired state, checked this often, e.g. 5m")
+	leaderElect                = flags.Bool("leader-elect", false, "with -reconcile-interval, only reconcile while holding a Lease, so replicas of the agent take turns")
+	leaseName                  = flags.String("leader-elect-lease", "", "name of the -leader-elect Lease in -namespace; defaults to server-reconciler, or <name>-reconciler")
+	leaseDuration              = flags.Duration("leader-elect-lease-duration", deployer.DefaultLeaseDuration, "how long followers wait after the leader's last renewal before taking over")
+	renewDeadline              = flags.Duration("leader-elect-renew-deadline", deployer.DefaultRenewDeadline, "how long the leader retries renewing the Lease before giving it up")
+	retryPeriod                = flags.Duration("leader-elect-retry-period", deployer.DefaultRetryPeriod, "how often the Lease is tried")
+	strategy                   = flags.String("strategy", "RollingUpdate", `how a new version replaces the running one: RollingUpdate, Recreate stopping every old pod first, or "blue-green" switching the services over once it is fully ready`)
+	maxSurge                   = flags.String("max-surge", "", "pods, or a percentage of the replicas, a rolling update may add above the replica count; defaults to 25%")
+	maxUnavailable             = flags.String("max-unavailable", "", "pods, or a percentage of the replicas, a rolling update may take down at once; defaults to 25%")
+	keepOldFor                 = flags.Duration("keep-old-for", deployer.DefaultKeepOldFor, "with -strategy blue-green, how long the old version keeps running after the switch, for rollback to switch back to")
+	canary                     = flags.Bool("canary", false, "deploy -image as a canary next to the stable deployment, which is left untouched; see the promote and abort subcommands")
+	canaryWeight               = flags.Int("canary-weight", 10, "with -canary, the percentage of the traffic the canary gets")
+	crMode                     = flags.Bool("cr-mode", false, "apply an EcommerceApp custom resource for the controller to deploy instead of the objects themselves")
+	workers                    = flags.Int("workers", 2, "with the controller subcommand, how many EcommerceApps are reconciled at once")
+	once                       = flags.Bool("once", false, "deploy once and exit even when -reconcile-interval is set")
+	watchChanges               = flags.Bool("watch", false, "while waiting for the rollout, print a line whenever the deployment, services, ingress or API pods change")
+	showEvents                 = flags.Bool("show-events", true, "when applying or a rollout fails, print the recent events of the objects involved")
+	force                      = flags.Bool("force", false, "with the scale subcommand, scale even though an HPA scales the deployment")
+	prune                      = flags.Bool("prune", false, "after applying, delete the objects of the release that are no longer desired, such as nodeport-svc without -expose-nodeport")
+	pruneWhitelist             = listFlag("prune-whitelist", "resource -prune looks through, as resource[.group], e.g. deployments.apps; repeatable, defaults to those of the built-in objects but persistentvolumeclaims")
+	del                        = flags.Bool("delete", false, "delete the resources created by this tool instead of creating them")
+	gracePeriod                = flags.Int64("grace-period", -1, "seconds given to each resource to terminate gracefully when deleting; negative uses the resource default")
+	cascade                    = flags.String("cascade", "", "with -delete, what happens to the dependents, such as the pods of the deployment: background, foreground deletes them first, orphan keeps them; defaults to the resource default")
+	smokeTests                 = listFlag("smoke-test", "after the rollout, GET a path of the API through the ingress host or a port forward and expect a status, as path=/healthz[,expect=200]; repeatable, a failure exits non-zero and, with -strategy blue-green, keeps blue serving")
+	smokeTestTimeout           = flags.Duration("smoke-test-timeout", deployer.DefaultSmokeTestTimeout, "time limit of each -smoke-test request")
+	smokeTestWindow            = flags.Duration("smoke-test-window", deployer.DefaultSmokeTestWindow, "how long failing -smoke-test requests are retried")
+	diagnosticsDir             = flags.String("diagnostics-dir", "", "when the run fails, write a bundle for debugging to this directory: the desired and live objects, the pods with the last 200 lines of their logs, the recent events of the namespace and the tool's log at full verbosity, secret values redacted; see also the diagnose subcommand")
+	waitDone                   = flags.Bool("wait", true, "wait for the deployment rollout to finish, or with -delete until the resources are gone")
+	timeout                    = flags.Duration("timeout", 5*time.Minute, "deadline of the whole run, requests and waits included; with -strategy blue-green, -keep-old-for is added. The controller, -reconcile-interval, port-forward, exec and logs -follow run until interrupted")
+	dryRun                     = flags.String("dry-run", "none", `"client" prints the objects without contacting the cluster, "server" sends them with the dry-run directive; "none" applies them`)
+	skipPreflight              = flags.Bool("skip-preflight", false, "deploy without first checking the cluster version, the APIs served and, through SelfSubjectAccessReviews, that every object may be applied, for clusters restricting the review API")
+	migrationCommand           = flags.String("migration-command", "", "shell command run in a Job, with the API container's environment, to completion before the API deployment is updated, e.g. a schema migration; a failure aborts the deploy")
+	migrationImage             = flags.String("migration-image", "", "image of the migration Job; defaults to the API image, running its entrypoint without -migration-command")
+	migrationBackoffLimit      = flags.Int("migration-backoff-limit", 0, "how many times a failed migration pod is retried before the deploy fails")
+	migrationHistoryLimit      = flags.Int("migration-history-limit", deployer.DefaultMigrationHistoryLimit, "how many finished migration Jobs are kept")
+	minKubeVersion             = flags.String("min-kube-version", deployer.DefaultMinKubeVersion, "oldest Kubernetes release deploy runs against, checked up front with the APIs the objects need unless -skip-preflight")
+	contexts                   = flags.StringSlice("contexts", nil, "kubeconfig contexts to run deploy, status or delete against one after the other, comma separated or repeated, in place of -context")
+	parallelClusters           = flags.Bool("parallel-clusters", false, "with -contexts, run against every context at once")
+	failFast                   = flags.Bool("fail-fast", false, "with -contexts, stop the other runs once one fails")
+	kubeQPS                    = flags.Float64("kube-qps", 50, "requests per second the tool may send to the apiserver; 0 keeps the client-go default of 5")
+	kubeBurst                  = flags.Int("kube-burst", 100, "requests the tool may send at once above -kube-qps; 0 keeps the client-go default of 10")
+	requestTimeout             = flags.Duration("request-timeout", 0, "time limit of each request to the apiserver, streams such as logs -follow included; 0 means none")
+	maxConcurrency             = flags.Int("max-concurrency", deployer.DefaultMaxConcurrency, "how many objects that don't depend on each other are applied at once; 1 applies them one by one, for debugging")
+	maxRetries                 = flags.Int("max-retries", 5, "how many times a request is retried after throttling, an apiserver timeout or outage, or a broken connection; 0 disables retries")
+	retryBackoff               = flags.Duration("retry-backoff", deployer.DefaultRetryBackoff, "delay before the first retry, doubling with each one; a longer Retry-After of the apiserver wins")
+	verbosity                  = flags.Int("v", 0, "log verbosity; 1 adds retries, 2 per-request details")
+	logFormat                  = flags.String("log-format", "text", "log output format: json or text")
+	outDir                     = flags.String("out-dir", "", "with the export subcommand, the directory each object is written to, as <kind>-<name>.yaml")
+	outFile                    = flags.String("out", "", `with the export subcommand, the file the objects are written to as one YAML stream, "-" for stdout; with generate-chart, the chart directory`)
+	output                     = flags.StringP("output", "o", "table", "output format of the status subcommand and of the applied objects: table for people, or json or yaml for scripts, with the progress lines on stderr")
+	file                       = flags.String("file", "", "ecommerce.yaml config file whose settings are the defaults of the flags; defaults to ./"+defaultConfigFile+" when it exists")
+	profile                    = flags.String("profile", "", "profile of the -file config file whose settings win over its top level, e.g. prod")
+	clientOnly                 = flags.Bool("client", true, "with the version subcommand, print only the version of the tool; false adds the version of the cluster")
+)
+
+// defaultKubeconfig returns ~/.kube/config, or "" without a home directory.
+func defaultKubeconfig() string {
+	if home := homedir.HomeDir(); home != "" {
+		return filepath.Join(home, ".kube", "config")
+	}
+	return ""
+}
+
+// listFlag defines a flag that can be given multiple times.
+func listFlag(name, usage string) *stringList {
+	l := new(stringList)
+	flags.Var(l, name, usage)
+	return l
+}
+
+// The flags of each kind, added to the commands taking them.
+var (
+	// globalFlags are taken by every command.
+	globalFlags = []string{
+		"kubeconfig", "context", "namespace", "name", "file", "profile", "v", "log-format",
+		"timeout", "kube-qps", "kube-burst", "request-timeout", "max-retries", "retry-backoff",
+	}
+	// objectFlags define the objects of the release.
+	objectFlags = []string{
+		"image", "tag", "pin-digest", "verify-signature", "cosign-key", "certificate-identity", "certificate-oidc-issuer",
+		"fulcio-root", "rekor-url", "insecure-ignore-tlog", "replicas", "max-replicas", "owner", "create-namespace", "revision-history-limit",
+		"strategy", "max-surge", "max-unavailable", "autoscale", "pdb-min-available", "pdb-max-unavailable",
+		"network-policy", "ingress-controller-namespace", "rbac", "rbac-rule",
+		"cpu-request", "cpu-limit", "memory-request", "memory-limit", "no-resources",
+		"node-selector", "toleration", "pod-anti-affinity", "topology-spread", "affinity",
+		"pod-security", "run-as-non-root", "run-as-user", "run-as-group", "fs-group",
+		"read-only-root-filesystem", "allow-privilege-escalation", "drop-capability", "seccomp-profile",
+		"health-path", "probe-type", "startup-probe-failure-threshold",
+		"service-type", "lb-preset", "lb-annotation", "expose-nodeport", "session-affinity", "session-affinity-timeout", "external-traffic-policy", "headless-service", "external-name-service", "node-port", "port", "routing", "gateway-name", "gateway-namespace", "route-edge-tls", "route-insecure-policy", "host", "path", "ingress-class",
+		"ingress-annotation", "proxy-body-size", "ssl-redirect", "rewrite-target",
+		"metrics-port", "metrics-path", "servicemonitor-label",
+		"tls-secret", "tls-cert", "tls-key", "cert-manager-issuer",
+		"config-file", "config", "db-secret-file", "db-secret-literal", "config-mount-path",
+		"env-from-file", "env", "env-from-secret", "env-from-configmap", "label", "annotation",
+		"image-pull-secret", "registry-auth", "pvc", "init-container", "sidecar", "cronjob",
+		"with-postgres", "postgres-tag", "postgres-storage", "postgres-storage-class",
+		"with-redis", "redis-tag", "redis-maxmemory", "redis-auth",
+		"manifests", "extra-manifests", "overlay", "values", "set", "typed", "cr-mode",
+	}
+	// applyFlags are taken by the commands writing objects.
+	applyFlags = []string{"field-manager", "force-conflicts", "max-concurrency", "dry-run"}
+	// waitFlags are taken by the commands waiting for a rollout.
+	waitFlags = []string{"wait", "show-events"}
+	// leaderElectionFlags are taken by the commands electing a leader.
+	leaderElectionFlags = []string{
+		"leader-elect", "leader-elect-lease", "leader-elect-lease-duration",
+		"leader-elect-renew-deadline", "leader-elect-retry-period",
+	}
+)
+
+// addFlags adds the named flags of each list to fs.
+func addFlags(fs *pflag.FlagSet, lists ...[]string) {
+	for _, names := range lists {
+		for _, name := range names {
+			fs.AddFlag(flags.Lookup(name))
+		}
+	}
+}
+
+// loadValues reads the values file, if any, and applies the -set overrides
+// on top in order.
+func loadValues(file string, overrides []string) (map[string]interface{}, error) {
+	vals := map[string]interface{}{}
+	if file != "" {
+		var err error
+		if vals, err = values.ReadFile(file); err != nil {
+			return nil, &deployer.ValidationError{Field: "values", Err: err}
+		}
+	}
+	for _, o := range overrides {
+		if err := values.Set(vals, o); err != nil {
+			return nil, &deployer.ValidationError{Field: "set", Err: err}
+		}
+	}
+	return vals, nil
+}
+
+// loadKeyValues reads a key=value file, if any, and applies the key=value
+// entries on top. It returns nil when neither is given. fileFlag and
+// entryFlag name the flags in errors.
+func loadKeyValues(file, fileFlag string, entries []string, entryFlag string) (map[string]string, error) {
+	if file == "" && len(entries) == 0 {
+		return nil, nil
+	}
+	values := map[string]string{}
+	if file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, &deployer.ValidationError{Field: fileFlag, Err: err}
+		}
+		if values, err = deployer.ParseProperties(data); err != nil {
+			return nil, &deployer.ValidationError{Field: fileFlag, Err: fmt.Errorf("%s: %w", file, err)}
+		}
+	}
+	for i, e := range entries {
+		eq := strings.Index(e, "=")
+		if eq <= 0 {
+			// The entry may be a credential, so it isn't quoted.
+			return nil, &deployer.ValidationError{Field: entryFlag, Err: fmt.Errorf("entry %d must be in key=value form", i+1)}
+		}
+		values[e[:eq]] = e[eq+1:]
+	}
+	return values, nil
+}
+
+// ingressPaths parses the -path flags, keeping nil for none so the default
+// applies.
+func ingressPaths(flags []string) []deployer.IngressPath {
+	if flags == nil {
+		return nil
+	}
+	paths := make([]deployer.IngressPath, 0, len(flags))
+	for _, f := range flags {
+		paths = append(paths, deployer.ParseIngressPath(f))
+	}
+	return paths
+}
+
+// readOptionalFile returns the contents of path, or nil when path is empty.
+func readOptionalFile(path string) ([]byte, error) {
+	if path == "" {
+		return nil, nil
+	}
+	return os.ReadFile(path)
+}
+
+// stringList is a flag that can be given multiple times.
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ",") }
+
+func (l *stringList) Type() string { return "strings" }
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+// givenFlags returns the names of the flags set so far, on the command line
+// or by flags.Set.
+func givenFlags() map[string]bool {
+	given := map[string]bool{}
+	flags.VisitAll(func(f *pflag.Flag) {
+		if f.Changed {
+			given[f.Name] = true
+		}
+	})
+	return given
+}
+
+// flagSet reports whether the named flag was given, on the command line, by
+// its environment variable or by the config file.
+func flagSet(name string) bool {
+	return flags.Lookup(name).Changed
+}
@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/raihankhan/ecommerceApi-client-go/pkg/deployer"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"os"
+)
+
+// progress records what a run is doing and what it has applied, so that a
+// run cut short by an interrupt or -timeout can tell the user what may need
+// cleaning up.
+type progress struct {
+	ctx     context.Context
+	phase   string
+	applied []*unstructured.Unstructured
+	// d is the Deployer of the run once connect built it, which the
+	// -diagnostics-dir bundle is collected through.
+	d *deployer.Deployer
+}
+
+// run is the progress of this process, reported by fail.
+var run = progress{ctx: context.Background()}
+
+// start enters phase, e.g. "waiting for the rollout".
+func (p *progress) start(phase string) { p.phase = phase }
+
+// record notes objects that now exist in the cluster.
+func (p *progress) record(objects []*unstructured.Unstructured) {
+	p.applied = append(p.applied, objects...)
+}
+
+// report prints, once the run's context is done, which phase was cut short
+// and the objects applied before it.
+func (p *progress) report() {
+	if p.phase == "" || p.ctx.Err() == nil {
+		return
+	}
+	why := "interrupted"
+	if errors.Is(p.ctx.Err(), context.DeadlineExceeded) {
+		why = "-timeout reached"
+	}
+	fmt.Fprintf(os.Stderr, "%s while %s\n", why, p.phase)
+	if len(p.applied) == 0 {
+		return
+	}
+	fmt.Fprintln(os.Stderr, "already applied, remove with -delete if unwanted:")
+	for _, obj := range p.applied {
+		fmt.Fprintf(os.Stderr, "  %s %s\n", obj.GetKind(), objectRef(obj.GetNamespace(), obj.GetName()))
+	}
+}
+
+// failed reports a run that failed with err as report does, then writes
+// the -diagnostics-dir bundle.
+func (p *progress) failed(err error) {
+	p.report()
+	collectDiagnostics(err)
+}
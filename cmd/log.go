@@ -0,0 +1,142 @@
+package cmd
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/go-logr/logr"
+	"io"
+	"k8s.io/klog/v2"
+	"k8s.io/klog/v2/klogr"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// newLogger returns the logger for the given -log-format and -v settings.
+// Logs go to stderr so stdout only carries the command's results.
+func newLogger(format string, verbosity int, out io.Writer) (logr.Logger, error) {
+	switch format {
+	case "text":
+		fs := flag.NewFlagSet("klog", flag.ContinueOnError)
+		klog.InitFlags(fs)
+		if err := fs.Set("v", strconv.Itoa(verbosity)); err != nil {
+			return nil, err
+		}
+		klog.SetOutput(out)
+		return klogr.New(), nil
+	case "json":
+		return jsonLogger{out: out, mu: &sync.Mutex{}, verbosity: verbosity}, nil
+	}
+	return nil, fmt.Errorf("unknown log format %q, want json or text", format)
+}
+
+// jsonLogger is a logr.Logger writing one JSON object per line.
+type jsonLogger struct {
+	out       io.Writer
+	mu        *sync.Mutex
+	name      string
+	level     int
+	verbosity int
+	values    []interface{}
+}
+
+func (l jsonLogger) Enabled() bool {
+	return l.level <= l.verbosity
+}
+
+func (l jsonLogger) Info(msg string, keysAndValues ...interface{}) {
+	if l.Enabled() {
+		l.write("info", msg, nil, keysAndValues)
+	}
+}
+
+func (l jsonLogger) Error(err error, msg string, keysAndValues ...interface{}) {
+	l.write("error", msg, err, keysAndValues)
+}
+
+func (l jsonLogger) V(level int) logr.Logger {
+	l.level += level
+	return l
+}
+
+func (l jsonLogger) WithValues(keysAndValues ...interface{}) logr.Logger {
+	l.values = append(l.values[:len(l.values):len(l.values)], keysAndValues...)
+	return l
+}
+
+func (l jsonLogger) WithName(name string) logr.Logger {
+	if l.name != "" {
+		name = l.name + "." + name
+	}
+	l.name = name
+	return l
+}
+
+func (l jsonLogger) write(level, msg string, err error, keysAndValues []interface{}) {
+	entry := map[string]interface{}{
+		"ts":    time.Now().UTC().Format(time.RFC3339Nano),
+		"level": level,
+		"v":     l.level,
+		"msg":   msg,
+	}
+	if l.name != "" {
+		entry["logger"] = l.name
+	}
+	if err != nil {
+		entry["error"] = err.Error()
+	}
+	kvs := append(l.values[:len(l.values):len(l.values)], keysAndValues...)
+	for i := 0; i < len(kvs); i += 2 {
+		key := fmt.Sprint(kvs[i])
+		if i+1 < len(kvs) {
+			entry[key] = kvs[i+1]
+		} else {
+			entry[key] = nil
+		}
+	}
+
+	line, merr := json.Marshal(entry)
+	if merr != nil {
+		// Fall back to stringified values for anything json can't encode.
+		for k, v := range entry {
+			entry[k] = fmt.Sprintf("%+v", v)
+		}
+		line, _ = json.Marshal(entry)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	fmt.Fprintf(l.out, "%s\n", line)
+}
+
+// teeLogger writes each entry to two loggers, each at its own verbosity.
+type teeLogger struct {
+	a, b logr.Logger
+}
+
+func (l teeLogger) Enabled() bool {
+	return l.a.Enabled() || l.b.Enabled()
+}
+
+func (l teeLogger) Info(msg string, keysAndValues ...interface{}) {
+	l.a.Info(msg, keysAndValues...)
+	l.b.Info(msg, keysAndValues...)
+}
+
+func (l teeLogger) Error(err error, msg string, keysAndValues ...interface{}) {
+	l.a.Error(err, msg, keysAndValues...)
+	l.b.Error(err, msg, keysAndValues...)
+}
+
+func (l teeLogger) V(level int) logr.Logger {
+	return teeLogger{l.a.V(level), l.b.V(level)}
+}
+
+func (l teeLogger) WithValues(keysAndValues ...interface{}) logr.Logger {
+	return teeLogger{l.a.WithValues(keysAndValues...), l.b.WithValues(keysAndValues...)}
+}
+
+func (l teeLogger) WithName(name string) logr.Logger {
+	return teeLogger{l.a.WithName(name), l.b.WithName(name)}
+}
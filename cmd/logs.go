@@ -0,0 +1,33 @@
+package cmd
+
+import (
+	"github.com/raihankhan/ecommerceApi-client-go/pkg/deployer"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+func newLogsCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "logs",
+		Short: "Print the logs of the API pods",
+		Args:  cobra.NoArgs,
+		Run: func(*cobra.Command, []string) {
+			s := prepare("logs")
+			defer s.connect()()
+			d, ctx, sigCtx := s.d, s.ctx, s.sigCtx
+			logOpts := deployer.LogOptions{Container: *container, Follow: *follow, Since: *since}
+			if *tail >= 0 {
+				logOpts.TailLines = tail
+			}
+			logsCtx := ctx
+			if *follow {
+				logsCtx = sigCtx
+			}
+			if err := d.Logs(logsCtx, logOpts, os.Stdout); err != nil {
+				fail(err)
+			}
+		},
+	}
+	addFlags(cmd.Flags(), []string{"follow", "tail", "since", "container"})
+	return cmd
+}
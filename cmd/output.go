@@ -0,0 +1,150 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/raihankhan/ecommerceApi-client-go/pkg/deployer"
+	"io"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"os"
+)
+
+// out receives the lines written for people, such as "Deployment x applied".
+// With -o json or yaml it is stderr, so stdout only carries the document
+// scripts parse.
+var out io.Writer = os.Stdout
+
+// compatibility is what the checks of the cluster found, for the summary.
+var compatibility *deployer.Compatibility
+
+// printResult prints result: as one JSON document on stdout with -o json,
+// and otherwise, on dry runs or with -o yaml, the applied objects as a YAML
+// stream, followed on real runs by the lines for people on out. Secret
+// values are redacted.
+func printResult(format string, result *deployer.Result) error {
+	if format == "json" {
+		if err := printResultJSON(os.Stdout, result); err != nil {
+			return err
+		}
+	}
+	return printResultText(os.Stdout, out, format, result)
+}
+
+// printResultText prints result as printResult does, but for the JSON
+// document: the objects to w and the lines for people to lines.
+func printResultText(w, lines io.Writer, format string, result *deployer.Result) error {
+	if format != "json" && (result.DryRun || format != "table") {
+		objects := make([]*unstructured.Unstructured, 0, len(result.Applied))
+		for _, r := range result.Applied {
+			objects = append(objects, r.Object)
+		}
+		if err := printYAML(w, objects); err != nil {
+			return err
+		}
+	}
+	if result.DryRun {
+		for _, p := range result.Pruned {
+			if p.Error == "" {
+				fmt.Fprintf(lines, "# %s %s would be pruned\n", p.Kind, objectRef(p.Namespace, p.Name))
+			}
+		}
+		return nil
+	}
+	printResultLines(lines, result)
+	return nil
+}
+
+// printResultJSON writes result to w as the indented JSON document of -o
+// json, with Secret values redacted.
+func printResultJSON(w io.Writer, result *deployer.Result) error {
+	data, err := json.MarshalIndent(redactResult(result), "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", data)
+	return err
+}
+
+// redactResult returns a copy of result with the values of its Secrets
+// redacted.
+func redactResult(result *deployer.Result) *deployer.Result {
+	redacted := *result
+	redacted.Applied = make([]deployer.AppliedResource, len(result.Applied))
+	for i, r := range result.Applied {
+		r.Object = deployer.Redact(r.Object)
+		redacted.Applied[i] = r
+	}
+	return &redacted
+}
+
+// printResultLines prints what result did for people to w, e.g.
+// "Deployment x created".
+func printResultLines(w io.Writer, result *deployer.Result) {
+	for _, r := range result.Applied {
+		ref := objectRef(r.Namespace, r.Name)
+		action := r.Action
+		if action == "" {
+			action = "applied"
+		}
+		if class, _, _ := unstructured.NestedString(r.Object.Object, "spec", "ingressClassName"); class != "" && r.Kind == "Ingress" {
+			fmt.Fprintf(w, "%s %s %s (ingress class %s)\n", r.Kind, ref, action, class)
+		} else {
+			fmt.Fprintf(w, "%s %s %s\n", r.Kind, ref, action)
+		}
+		for _, warning := range r.Warnings {
+			fmt.Fprintf(w, "%s %s warning: %s\n", r.Kind, ref, warning)
+		}
+	}
+	for _, a := range result.Access.NodePorts {
+		ref := objectRef(result.Namespace, a.Service)
+		if a.Error != "" {
+			fmt.Fprintf(w, "Service %s node ports: %v (nodes unavailable: %s)\n", ref, a.Ports, a.Error)
+			continue
+		}
+		for _, u := range a.URLs {
+			fmt.Fprintf(w, "Service %s reachable at %s\n", ref, u)
+		}
+	}
+	for _, s := range result.Skipped {
+		fmt.Fprintf(w, "%s %s skipped (warning: %s)\n", s.Kind, objectRef(result.Namespace, s.Name), s.Reason)
+	}
+	for _, p := range result.Pruned {
+		if p.Error != "" {
+			fmt.Fprintf(os.Stderr, "prune failed: %s\n", p.Error)
+			continue
+		}
+		fmt.Fprintf(w, "%s %s pruned\n", p.Kind, objectRef(p.Namespace, p.Name))
+	}
+	if lb := result.Access.LoadBalancer; lb != nil {
+		for _, a := range lb.Addresses {
+			fmt.Fprintf(w, "Service %s external address: %s\n", objectRef(result.Namespace, lb.Service), a)
+		}
+	}
+	for _, e := range result.Access.Ingress {
+		fmt.Fprintf(w, "API reachable at %s\n", e)
+	}
+}
+
+// resultOf returns the Result of objects applied other than by Deploy,
+// which failed with err unless it is nil.
+func resultOf(d *deployer.Deployer, dryRun bool, objects []*unstructured.Unstructured, err error) *deployer.Result {
+	result := &deployer.Result{
+		Namespace:     d.Namespace(),
+		DryRun:        dryRun,
+		Applied:       deployer.AppliedResources(objects),
+		Compatibility: compatibility,
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// objectRef formats an object as namespace/name, or just name for
+// cluster-scoped objects.
+func objectRef(namespace, name string) string {
+	if namespace == "" {
+		return name
+	}
+	return namespace + "/" + name
+}
@@ -0,0 +1,137 @@
+package cmd
+
+import (
+	"bytes"
+	"flag"
+	"github.com/raihankhan/ecommerceApi-client-go/pkg/deployer"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// update rewrites the golden files with what the tests produce:
+//
+//	go test ./cmd -update
+var update = flag.Bool("update", false, "rewrite the golden files under testdata")
+
+// checkGolden compares got with testdata/name, or writes it there with
+// -update.
+func checkGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", filepath.FromSlash(name))
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v; run go test -update to create it", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s differs from the golden file; run go test -update and review the diff:\n%s", path, got)
+	}
+}
+
+// appliedObject returns obj, as the apiserver would return it, applied
+// with action.
+func appliedObject(obj map[string]interface{}, action string) deployer.AppliedResource {
+	u := &unstructured.Unstructured{Object: obj}
+	return deployer.AppliedResource{
+		APIVersion: u.GetAPIVersion(),
+		Kind:       u.GetKind(),
+		Namespace:  u.GetNamespace(),
+		Name:       u.GetName(),
+		UID:        u.GetUID(),
+		Action:     action,
+		Object:     u,
+	}
+}
+
+func TestPrintResultJSON(t *testing.T) {
+	secret := func() deployer.AppliedResource {
+		return appliedObject(map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "Secret",
+			"metadata":   map[string]interface{}{"name": "server-db", "namespace": "shop", "uid": "uid-secret"},
+			"data":       map[string]interface{}{"password": "aHVudGVyMg=="},
+			"stringData": map[string]interface{}{"username": "shop"},
+		}, deployer.ActionCreated)
+	}
+	deployment := appliedObject(map[string]interface{}{
+		"apiVersion": "apps/v1",
+		"kind":       "Deployment",
+		"metadata":   map[string]interface{}{"name": "apiserver", "namespace": "shop", "uid": "uid-deployment"},
+		"spec":       map[string]interface{}{"replicas": int64(2)},
+	}, deployer.ActionUpdated)
+	service := appliedObject(map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Service",
+		"metadata":   map[string]interface{}{"name": "nodeport-svc", "namespace": "shop", "uid": "uid-service"},
+		"spec": map[string]interface{}{
+			"type":  "NodePort",
+			"ports": []interface{}{map[string]interface{}{"name": "http", "port": int64(8080), "nodePort": int64(30080)}},
+		},
+	}, deployer.ActionUnchanged)
+	service.Warnings = []string{"the service is exposed on every node"}
+
+	tests := []struct {
+		name   string
+		result *deployer.Result
+	}{
+		{"success", &deployer.Result{
+			Namespace: "shop",
+			Applied:   []deployer.AppliedResource{secret(), deployment, service},
+			Rollout:   &deployer.RolloutOutcome{Deployment: "apiserver", RolledOut: true, Duration: metav1.Duration{Duration: 12 * time.Second}},
+			Access: deployer.Access{
+				Ingress:   []deployer.Endpoint{{URL: "https://shop.example.com/", Addresses: []string{"203.0.113.10"}}},
+				NodePorts: []deployer.NodePortAccess{{Service: "nodeport-svc", Ports: []int64{30080}, URLs: []string{"http://192.0.2.1:30080"}}},
+			},
+			Skipped: []deployer.SkippedFeature{{Kind: "ServiceMonitor", Name: "server-monitor", Reason: "monitoring.coreos.com/v1 is not served"}},
+		}},
+		{"dry-run", &deployer.Result{
+			Namespace: "shop",
+			DryRun:    true,
+			Applied:   []deployer.AppliedResource{secret(), deployment},
+			Pruned:    []deployer.PrunedResource{{Kind: "ConfigMap", Namespace: "shop", Name: "server-config-old"}},
+		}},
+		{"partial-failure", &deployer.Result{
+			Namespace:  "shop",
+			Applied:    []deployer.AppliedResource{secret()},
+			Error:      `failed to apply apps/v1, Resource=deployments shop/apiserver: deployments.apps "apiserver" is forbidden: RBAC denied; not applied: [Service/server-svc Ingress/server-ingress]`,
+			NotApplied: []string{"Service/server-svc", "Ingress/server-ingress"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := printResultJSON(&buf, tt.result); err != nil {
+				t.Fatalf("printResultJSON: %v", err)
+			}
+			got := buf.Bytes()
+			if bytes.Contains(got, []byte("aHVudGVyMg==")) || bytes.Contains(got, []byte(`"username": "shop"`)) {
+				t.Errorf("a Secret value is printed:\n%s", got)
+			}
+			if !bytes.Contains(got, []byte(`"password": "`+deployer.Redacted+`"`)) {
+				t.Errorf("the Secret keys aren't printed redacted:\n%s", got)
+			}
+			for _, r := range tt.result.Applied {
+				if data, _, _ := unstructured.NestedStringMap(r.Object.Object, "data"); r.Kind == "Secret" && data["password"] != "aHVudGVyMg==" {
+					t.Errorf("the Secret of the result was redacted in place: %v", data)
+				}
+			}
+			checkGolden(t, "result/"+tt.name+".golden", got)
+			if !strings.HasSuffix(string(got), "}\n") {
+				t.Error("the document doesn't end with a newline")
+			}
+		})
+	}
+}
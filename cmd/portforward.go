@@ -0,0 +1,33 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/raihankhan/ecommerceApi-client-go/pkg/deployer"
+	"github.com/spf13/cobra"
+)
+
+func newPortForwardCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "port-forward",
+		Short: "Forward a localhost port to an API pod until interrupted",
+		Args:  cobra.NoArgs,
+		Run: func(*cobra.Command, []string) {
+			s := prepare("port-forward")
+			defer s.connect()()
+			d, sigCtx := s.d, s.sigCtx
+			// Forward until interrupted, then close the listener cleanly.
+			err := d.PortForward(sigCtx, deployer.PortForwardOptions{
+				LocalPort: *localPort,
+				PodPort:   *podPort,
+				Ready: func(url, pod string) {
+					fmt.Fprintf(out, "Forwarding %s to pod %s\n", url, objectRef(d.Namespace(), pod))
+				},
+			})
+			if err != nil {
+				fail(err)
+			}
+		},
+	}
+	addFlags(cmd.Flags(), []string{"local-port", "pod-port"})
+	return cmd
+}
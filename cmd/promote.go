@@ -0,0 +1,37 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+func newPromoteCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "promote",
+		Short: "Roll the canary image out to the stable deployment and delete the canary",
+		Args:  cobra.NoArgs,
+		Run: func(*cobra.Command, []string) {
+			s := prepare("promote")
+			defer s.connect()()
+			log, opts, d, ctx := s.log, s.opts, s.d, s.ctx
+			// The canary keeps serving until the stable track runs its image.
+			run.start("promoting the canary")
+			_, image, err := d.Promote(ctx)
+			if err != nil {
+				if *showEvents {
+					printEvents(d, err)
+				}
+				fail(err)
+			}
+			fmt.Fprintf(out, "Deployment %s promoted to %s\n", objectRef(d.Namespace(), d.DeploymentName()), image)
+			if *waitDone && !opts.DryRun {
+				waitForRollout(ctx, log, d, *timeout, *showEvents, "Promotion complete")
+			}
+			run.start("deleting the canary")
+			os.Exit(printDeleteResults(out, d, d.AbortCanary(ctx)))
+		},
+	}
+	addFlags(cmd.Flags(), objectFlags, waitFlags, []string{"dry-run"})
+	return cmd
+}
@@ -0,0 +1,29 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+)
+
+func newRestartCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "restart",
+		Short: "Restart the API pods with a rolling update",
+		Args:  cobra.NoArgs,
+		Run: func(*cobra.Command, []string) {
+			s := prepare("restart")
+			defer s.connect()()
+			log, opts, d, ctx := s.log, s.opts, s.d, s.ctx
+			run.start("restarting the deployment")
+			if err := d.Restart(ctx); err != nil {
+				fail(err)
+			}
+			fmt.Fprintf(out, "Deployment %s restarted\n", objectRef(d.Namespace(), d.DeploymentName()))
+			if *waitDone && !opts.DryRun {
+				waitForRollout(ctx, log, d, *timeout, *showEvents, "Restart complete")
+			}
+		},
+	}
+	addFlags(cmd.Flags(), waitFlags, []string{"dry-run"})
+	return cmd
+}
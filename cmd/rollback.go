@@ -0,0 +1,39 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+)
+
+func newRollbackCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "rollback",
+		Short: "Roll the deployment back to -to-revision, or switch a blue-green deploy back",
+		Args:  cobra.NoArgs,
+		Run: func(*cobra.Command, []string) {
+			s := prepare("rollback")
+			defer s.connect()()
+			log, opts, d, ctx := s.log, s.opts, s.d, s.ctx
+			// Within a blue-green deploy, switch back to the old version at once.
+			run.start("rolling back")
+			switched, err := d.RollbackBlueGreen(ctx)
+			if err != nil {
+				fail(err)
+			}
+			if switched {
+				fmt.Fprintf(out, "Service %s switched back to deployment %s\n", objectRef(d.Namespace(), d.ServiceName()), objectRef(d.Namespace(), d.DeploymentName()))
+				return
+			}
+			revision, err := d.Rollback(ctx, *toRevision)
+			if err != nil {
+				fail(err)
+			}
+			fmt.Fprintf(out, "Deployment %s rolled back to revision %d\n", objectRef(d.Namespace(), d.DeploymentName()), revision)
+			if *waitDone && !opts.DryRun {
+				waitForRollout(ctx, log, d, *timeout, *showEvents, "Rollback complete")
+			}
+		},
+	}
+	addFlags(cmd.Flags(), objectFlags, waitFlags, []string{"to-revision", "dry-run"})
+	return cmd
+}
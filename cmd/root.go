@@ -0,0 +1,97 @@
+// Package cmd is the command line of the tool. Each command lives in its own
+// file and leaves the work to the deployer package.
+package cmd
+
+import (
+	"github.com/raihankhan/ecommerceApi-client-go/pkg/version"
+	"github.com/spf13/cobra"
+	"os"
+	"strings"
+)
+
+// Execute runs the command named by the arguments, deploy when none is, and
+// exits with the code of its outcome.
+func Execute() {
+	root := newRootCommand()
+	root.SetArgs(normalizeArgs(os.Args[1:]))
+	if err := root.Execute(); err != nil {
+		os.Exit(exitValidation)
+	}
+}
+
+func newRootCommand() *cobra.Command {
+	root := &cobra.Command{
+		Use:   "ecommerceApi-client-go",
+		Short: "Deploy the ecommerce API to a Kubernetes cluster",
+		Long: "Deploy the ecommerce API to a Kubernetes cluster. Run without a command it deploys,\n" +
+			"as the deploy command does.",
+		Args:              cobra.NoArgs,
+		SilenceUsage:      true,
+		Run:               func(*cobra.Command, []string) { runDeploy("") },
+		CompletionOptions: cobra.CompletionOptions{DisableDefaultCmd: true},
+	}
+	addFlags(root.PersistentFlags(), globalFlags)
+	addFlags(root.Flags(), deployFlags...)
+	// Declared ahead of cobra's own so -v stays the log verbosity.
+	root.Flags().Bool("version", false, "print the version of the tool and exit")
+	root.Version = version.Version
+	root.SetVersionTemplate(versionText(versions{Client: version.Get()}))
+	root.AddCommand(
+		newDeployCommand(),
+		newDeleteCommand(),
+		newStatusCommand(),
+		newDiffCommand(),
+		newTemplateCommand(),
+		newExportCommand(),
+		newGenerateChartCommand(),
+		newLogsCommand(),
+		newDiagnoseCommand(),
+		newPortForwardCommand(),
+		newExecCommand(),
+		newScaleCommand(),
+		newRestartCommand(),
+		newPromoteCommand(),
+		newAbortCommand(),
+		newRollbackCommand(),
+		newInstallCRDCommand(),
+		newControllerCommand(),
+		newConfigCommand(),
+		newVersionCommand(),
+	)
+	return root
+}
+
+// normalizeArgs rewrites the single-dash flags of earlier releases, such as
+// -namespace or -v=2, and -version to the double-dash form cobra parses. The arguments
+// after -- and the command exec runs are left as they are.
+func normalizeArgs(args []string) []string {
+	normalized := make([]string, 0, len(args))
+	exec := false
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" || exec && !strings.HasPrefix(arg, "-") {
+			return append(normalized, args[i:]...)
+		}
+		if !strings.HasPrefix(arg, "-") || arg == "-" {
+			exec = arg == "exec"
+			normalized = append(normalized, arg)
+			continue
+		}
+		name := strings.TrimLeft(arg, "-")
+		hasValue := strings.Contains(name, "=")
+		if hasValue {
+			name = name[:strings.Index(name, "=")]
+		}
+		f := flags.Lookup(name)
+		if (f != nil || name == "version") && !strings.HasPrefix(arg, "--") {
+			arg = "-" + arg
+		}
+		normalized = append(normalized, arg)
+		if f != nil && !hasValue && f.Value.Type() != "bool" && i+1 < len(args) {
+			// The value, which may look like a command.
+			i++
+			normalized = append(normalized, args[i])
+		}
+	}
+	return normalized
+}
@@ -0,0 +1,36 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"github.com/raihankhan/ecommerceApi-client-go/pkg/deployer"
+	"github.com/spf13/cobra"
+)
+
+func newScaleCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "scale",
+		Short: "Scale the deployment to -replicas",
+		Args:  cobra.NoArgs,
+		Run: func(*cobra.Command, []string) {
+			s := prepare("scale")
+			// -replicas defaults to the replicas of a deploy, which scale
+			// mustn't fall back to.
+			if !flagSet("replicas") {
+				fail(&deployer.ValidationError{Field: "replicas", Err: errors.New("scale needs -replicas")})
+			}
+			defer s.connect()()
+			log, opts, d, ctx := s.log, s.opts, s.d, s.ctx
+			run.start("scaling the deployment")
+			if err := d.Scale(ctx, *opts.Replicas, *force); err != nil {
+				fail(err)
+			}
+			fmt.Fprintf(out, "Deployment %s scaled to %d replicas\n", objectRef(d.Namespace(), d.DeploymentName()), *opts.Replicas)
+			if *waitDone && !opts.DryRun {
+				waitForRollout(ctx, log, d, *timeout, *showEvents, "Scaling complete")
+			}
+		},
+	}
+	addFlags(cmd.Flags(), waitFlags, []string{"replicas", "force", "dry-run"})
+	return cmd
+}
@@ -0,0 +1,558 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/go-logr/logr"
+	"github.com/raihankhan/ecommerceApi-client-go/pkg/deployer"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"os"
+	"os/signal"
+	"sigs.k8s.io/yaml"
+	"syscall"
+)
+
+// session is what a command works with once its flags are checked.
+type session struct {
+	log  logr.Logger
+	opts deployer.Options
+	// pruneResources are the resources of -prune-whitelist.
+	pruneResources []schema.GroupResource
+	// smokeTests are the -smoke-test requests sent after the rollout.
+	smokeTests deployer.SmokeTestOptions
+
+	// d, ctx and sigCtx are set by connect, and ctx and sigCtx by
+	// connectClusters. ctx ends at -timeout or on an interrupt, sigCtx
+	// only on an interrupt.
+	d      *deployer.Deployer
+	ctx    context.Context
+	sigCtx context.Context
+}
+
+// prepare applies the environment variables and the config file to the
+// flags not given, checks the flags and builds the options of command,
+// exiting on the first problem.
+func prepare(command string) *session {
+	given := givenFlags()
+	fromEnv, err := applyEnv()
+	if err != nil {
+		fail(err)
+	}
+	log, err := newLogger(*logFormat, *verbosity, os.Stderr)
+	if err != nil {
+		fail(&deployer.ValidationError{Field: "log-format", Err: err})
+	}
+	if *diagnosticsDir != "" || command == "diagnose" {
+		log = withToolLog(log)
+	}
+
+	var fromFile []string
+	if path := findConfigFile(*file); path != "" {
+		cfg, err := loadConfigFile(path)
+		if err != nil {
+			fail(&deployer.ValidationError{Field: "file", Err: err})
+		}
+		if fromFile, err = cfg.apply(*profile); err != nil {
+			fail(&deployer.ValidationError{Field: "profile", Err: err})
+		}
+	} else if *profile != "" {
+		fail(&deployer.ValidationError{Field: "profile", Err: fmt.Errorf("needs a config file; pass -file or create ./%s", defaultConfigFile)})
+	}
+	logEffectiveConfig(log, flagSources(given, fromEnv, fromFile))
+
+	if *timeout <= 0 {
+		fail(&deployer.ValidationError{Field: "timeout", Err: fmt.Errorf("must be positive, got %s", *timeout)})
+	}
+	if *kubeQPS < 0 {
+		fail(&deployer.ValidationError{Field: "kube-qps", Err: fmt.Errorf("must not be negative, got %g", *kubeQPS)})
+	}
+	if *kubeBurst < 0 {
+		fail(&deployer.ValidationError{Field: "kube-burst", Err: fmt.Errorf("must not be negative, got %d", *kubeBurst)})
+	}
+	if *requestTimeout < 0 {
+		fail(&deployer.ValidationError{Field: "request-timeout", Err: fmt.Errorf("must not be negative, got %s", *requestTimeout)})
+	}
+	if flagSet("tag") && *tag == "" {
+		fail(&deployer.ValidationError{Field: "tag", Err: errors.New("must not be empty")})
+	}
+	imageRef, err := deployer.WithTag(*image, *tag)
+	if err != nil {
+		fail(&deployer.ValidationError{Field: "tag", Err: err})
+	}
+
+	if *dryRun != "none" && *dryRun != "client" && *dryRun != "server" {
+		fail(&deployer.ValidationError{Field: "dry-run", Err: fmt.Errorf("must be none, client or server, got %q", *dryRun)})
+	}
+	if *reconcileInterval < 0 {
+		fail(&deployer.ValidationError{Field: "reconcile-interval", Err: fmt.Errorf("must not be negative, got %s", *reconcileInterval)})
+	}
+	if *leaderElect && *reconcileInterval == 0 && command != "controller" {
+		fail(&deployer.ValidationError{Field: "leader-elect", Err: errors.New("only applies with -reconcile-interval or the controller subcommand")})
+	}
+	if _, ok := propagationPolicies[*cascade]; *cascade != "" && !ok {
+		fail(&deployer.ValidationError{Field: "cascade", Err: fmt.Errorf("must be background, foreground or orphan, got %q", *cascade)})
+	}
+	if *prune && *dryRun == "client" {
+		fail(&deployer.ValidationError{Field: "prune", Err: errors.New("needs the cluster to list the objects; use -dry-run=server")})
+	}
+	if len(*pruneWhitelist) > 0 && !*prune {
+		fail(&deployer.ValidationError{Field: "prune-whitelist", Err: errors.New("only applies with -prune")})
+	}
+	var pruneResources []schema.GroupResource
+	for _, r := range *pruneWhitelist {
+		gr, err := deployer.ParsePruneResource(r)
+		if err != nil {
+			fail(&deployer.ValidationError{Field: "prune-whitelist", Err: err})
+		}
+		pruneResources = append(pruneResources, gr)
+	}
+	smoke := deployer.SmokeTestOptions{Timeout: *smokeTestTimeout, Window: *smokeTestWindow}
+	for _, t := range *smokeTests {
+		test, err := deployer.ParseSmokeTest(t)
+		if err != nil {
+			fail(&deployer.ValidationError{Field: "smoke-test", Err: err})
+		}
+		smoke.Tests = append(smoke.Tests, test)
+	}
+	if err := smoke.Validate(); err != nil {
+		fail(err)
+	}
+	if (flagSet("smoke-test-timeout") || flagSet("smoke-test-window")) && len(smoke.Tests) == 0 {
+		fail(&deployer.ValidationError{Field: "smoke-test", Err: errors.New("-smoke-test-timeout and -smoke-test-window only apply with -smoke-test")})
+	}
+	if len(smoke.Tests) > 0 && (!*waitDone || *dryRun != "none" || *canary || *crMode || *del || *reconcileInterval != 0) {
+		fail(&deployer.ValidationError{Field: "smoke-test", Err: errors.New("needs -wait, and can't be combined with -dry-run, -canary, -cr-mode, -delete or -reconcile-interval")})
+	}
+	if command == "controller" && *workers < 1 {
+		fail(&deployer.ValidationError{Field: "workers", Err: fmt.Errorf("must be at least 1, got %d", *workers)})
+	}
+	if *strategy != deployer.StrategyRollingUpdate && *strategy != deployer.StrategyRecreate && *strategy != "blue-green" {
+		fail(&deployer.ValidationError{Field: "strategy", Err: fmt.Errorf("must be RollingUpdate, Recreate or blue-green, got %q", *strategy)})
+	}
+	if flagSet("keep-old-for") && *strategy != "blue-green" {
+		fail(&deployer.ValidationError{Field: "keep-old-for", Err: errors.New("only applies with -strategy blue-green")})
+	}
+	if *strategy == "blue-green" && (*canary || *crMode || *del || *reconcileInterval != 0 || *dryRun != "none") {
+		fail(&deployer.ValidationError{Field: "strategy", Err: errors.New("blue-green can't be combined with -canary, -cr-mode, -delete, -reconcile-interval or -dry-run")})
+	}
+	if flagSet("canary-weight") && !*canary {
+		fail(&deployer.ValidationError{Field: "canary-weight", Err: errors.New("only applies with -canary")})
+	}
+	if *canary && (*crMode || *del || *prune || *reconcileInterval != 0) {
+		fail(&deployer.ValidationError{Field: "canary", Err: errors.New("can't be combined with -cr-mode, -delete, -prune or -reconcile-interval")})
+	}
+	if *crMode && *reconcileInterval != 0 {
+		fail(&deployer.ValidationError{Field: "cr-mode", Err: errors.New("the controller reconciles EcommerceApps; drop -reconcile-interval")})
+	}
+	if *output != "table" && *output != "json" && *output != "yaml" {
+		fail(&deployer.ValidationError{Field: "output", Err: fmt.Errorf("must be table, json or yaml, got %q", *output)})
+	}
+	if *output != "table" {
+		out = os.Stderr
+	}
+	if len(*contexts) > 0 {
+		if command != "" && command != "deploy" && command != "status" && command != "delete" {
+			fail(&deployer.ValidationError{Field: "contexts", Err: errors.New("only applies to deploy, status and delete")})
+		}
+		if flagSet("context") {
+			fail(&deployer.ValidationError{Field: "contexts", Err: errors.New("can't be combined with -context")})
+		}
+		if *reconcileInterval != 0 || *strategy == "blue-green" || *canary || *crMode || *watchChanges || *dryRun == "client" || *diagnosticsDir != "" {
+			fail(&deployer.ValidationError{Field: "contexts", Err: errors.New("can't be combined with -reconcile-interval, -strategy blue-green, -canary, -cr-mode, -watch, -dry-run=client or -diagnostics-dir")})
+		}
+	}
+	if (*parallelClusters || *failFast) && len(*contexts) == 0 {
+		fail(&deployer.ValidationError{Field: "contexts", Err: errors.New("-parallel-clusters and -fail-fast only apply with -contexts")})
+	}
+
+	replicaCount := int32(*replicas)
+	opts := deployer.Options{
+		Namespace:                    *namespace,
+		Release:                      *release,
+		Owner:                        *owner,
+		CreateNamespace:              *createNamespace,
+		Image:                        imageRef,
+		Replicas:                     &replicaCount,
+		MaxReplicas:                  int32(*maxReplicas),
+		FieldManager:                 *fieldManager,
+		ForceConflicts:               *forceConflicts,
+		Typed:                        *typed,
+		ServiceType:                  *serviceType,
+		ExposeNodePort:               *exposeNodePort,
+		LBPreset:                     *lbPreset,
+		Routing:                      *routing,
+		GatewayName:                  *gatewayName,
+		GatewayNamespace:             *gatewayNamespace,
+		RouteEdgeTLS:                 *routeEdgeTLS,
+		RouteInsecurePolicy:          *routeInsecurePolicy,
+		SessionAffinity:              *sessionAffinity,
+		SessionAffinityTimeout:       int32(*sessionAffinityTimeout),
+		ExternalTrafficPolicy:        *externalTrafficPolicy,
+		HeadlessService:              *headlessService,
+		NodePort:                     int32(*nodePort),
+		Hosts:                        *hosts,
+		Paths:                        ingressPaths(*paths),
+		IngressClass:                 *ingressClass,
+		TLSSecret:                    *tlsSecret,
+		ConfigMountPath:              *configMountPath,
+		CPURequest:                   *cpuRequest,
+		CPULimit:                     *cpuLimit,
+		MemoryRequest:                *memoryRequest,
+		MemoryLimit:                  *memoryLimit,
+		NoResources:                  *noResources,
+		HealthPath:                   *healthPath,
+		ProbeType:                    *probeType,
+		StartupProbeFailureThreshold: int32(*startupThreshold),
+		RBAC:                         *rbac,
+		NetworkPolicy:                *networkPolicy,
+		IngressControllerNamespace:   *ingressControllerNamespace,
+		Postgres:                     *withPostgres,
+		PostgresTag:                  *postgresTag,
+		PostgresStorage:              *postgresStorage,
+		PostgresStorageClass:         *postgresStorageClass,
+		Redis:                        *withRedis,
+		RedisTag:                     *redisTag,
+		RedisMaxMemory:               *redisMaxMemory,
+		RedisAuth:                    *redisAuth,
+		CertManagerIssuer:            *certManagerIssuer,
+		MetricsPort:                  int32(*metricsPort),
+		MetricsPath:                  *metricsPath,
+		DryRun:                       *dryRun == "server",
+		MaxConcurrency:               *maxConcurrency,
+		MaxRetries:                   *maxRetries,
+		RetryBackoff:                 *retryBackoff,
+		MinKubeVersion:               *minKubeVersion,
+		Logger:                       log,
+	}
+	if flagSet("strategy") && *strategy != "blue-green" {
+		opts.Strategy = *strategy
+	}
+	if *maxSurge != "" {
+		if opts.MaxSurge, err = deployer.ParseIntOrPercent(*maxSurge); err != nil {
+			fail(&deployer.ValidationError{Field: "max-surge", Err: err})
+		}
+	}
+	if *maxUnavailable != "" {
+		if opts.MaxUnavailable, err = deployer.ParseIntOrPercent(*maxUnavailable); err != nil {
+			fail(&deployer.ValidationError{Field: "max-unavailable", Err: err})
+		}
+	}
+	if opts.TLSCert, err = readOptionalFile(*tlsCert); err != nil {
+		fail(&deployer.ValidationError{Field: "tls-cert", Err: err})
+	}
+	if opts.TLSKey, err = readOptionalFile(*tlsKey); err != nil {
+		fail(&deployer.ValidationError{Field: "tls-key", Err: err})
+	}
+	if *autoscale != "" {
+		if opts.Autoscale, err = deployer.ParseAutoscale(*autoscale); err != nil {
+			fail(&deployer.ValidationError{Field: "autoscale", Err: err})
+		}
+	}
+	if *pdbMinAvailable != "" {
+		if opts.PDBMinAvailable, err = deployer.ParseDisruptionBudget(*pdbMinAvailable); err != nil {
+			fail(&deployer.ValidationError{Field: "pdb-min-available", Err: err})
+		}
+	}
+	if *pdbMaxUnavailable != "" {
+		if opts.PDBMaxUnavailable, err = deployer.ParseDisruptionBudget(*pdbMaxUnavailable); err != nil {
+			fail(&deployer.ValidationError{Field: "pdb-max-unavailable", Err: err})
+		}
+	}
+	for _, r := range *rbacRules {
+		rules, err := deployer.ParseRBACRule(r)
+		if err != nil {
+			fail(&deployer.ValidationError{Field: "rbac-rule", Err: err})
+		}
+		opts.RBACRules = append(opts.RBACRules, rules...)
+	}
+	opts.ImagePullSecrets = *pullSecrets
+	if *registryAuth != "" {
+		auth, err := deployer.ParseRegistryAuth(*registryAuth)
+		if err != nil {
+			fail(&deployer.ValidationError{Field: "registry-auth", Err: err})
+		}
+		opts.RegistryAuth = &auth
+	}
+	if *historyLimit >= 0 {
+		limit := int32(*historyLimit)
+		opts.RevisionHistoryLimit = &limit
+	}
+	for _, p := range *pvcs {
+		pvc, err := deployer.ParsePVC(p)
+		if err != nil {
+			fail(&deployer.ValidationError{Field: "pvc", Err: err})
+		}
+		opts.PVCs = append(opts.PVCs, pvc)
+	}
+	for _, group := range []struct {
+		field      string
+		specs      []string
+		containers *[]corev1.Container
+	}{{"init-container", *initContainers, &opts.InitContainers}, {"sidecar", *sidecars, &opts.Sidecars}} {
+		for _, s := range group.specs {
+			c, err := deployer.ParseContainer(s)
+			if err != nil {
+				fail(&deployer.ValidationError{Field: group.field, Err: err})
+			}
+			*group.containers = append(*group.containers, c)
+		}
+	}
+	for _, c := range *cronJobs {
+		cronJob, err := deployer.ParseCronJob(c)
+		if err != nil {
+			fail(&deployer.ValidationError{Field: "cronjob", Err: err})
+		}
+		opts.CronJobs = append(opts.CronJobs, cronJob)
+	}
+	if opts.Config, err = loadKeyValues(*appConfigFile, "config-file", *configEntries, "config"); err != nil {
+		fail(err)
+	}
+	if opts.Labels, err = loadKeyValues("", "", *labels, "label"); err != nil {
+		fail(err)
+	}
+	if opts.Annotations, err = loadKeyValues("", "", *annotations, "annotation"); err != nil {
+		fail(err)
+	}
+	if opts.LBAnnotations, err = loadKeyValues("", "", *lbAnnotationList, "lb-annotation"); err != nil {
+		fail(err)
+	}
+	if opts.IngressAnnotations, err = loadKeyValues("", "", *ingressAnnotationList, "ingress-annotation"); err != nil {
+		fail(err)
+	}
+	if *migrationCommand != "" || *migrationImage != "" {
+		opts.Migration = &deployer.Migration{
+			Command:      *migrationCommand,
+			Image:        *migrationImage,
+			BackoffLimit: int32(*migrationBackoffLimit),
+			HistoryLimit: *migrationHistoryLimit,
+			Logs:         os.Stderr,
+		}
+	}
+	if opts.NodeSelector, err = loadKeyValues("", "", *nodeSelectors, "node-selector"); err != nil {
+		fail(err)
+	}
+	for _, s := range *externalNameServices {
+		svc, err := deployer.ParseExternalNameService(s)
+		if err != nil {
+			fail(&deployer.ValidationError{Field: "external-name-service", Err: err})
+		}
+		opts.ExternalNameServices = append(opts.ExternalNameServices, svc)
+	}
+	for _, p := range *ports {
+		port, err := deployer.ParsePort(p)
+		if err != nil {
+			fail(&deployer.ValidationError{Field: "port", Err: err})
+		}
+		opts.Ports = append(opts.Ports, port)
+	}
+	for _, t := range *tolerations {
+		toleration, err := deployer.ParseToleration(t)
+		if err != nil {
+			fail(&deployer.ValidationError{Field: "toleration", Err: err})
+		}
+		opts.Tolerations = append(opts.Tolerations, toleration)
+	}
+	opts.PodAntiAffinity = *podAntiAffinity
+	for _, t := range *topologySpread {
+		constraint, err := deployer.ParseTopologySpread(t)
+		if err != nil {
+			fail(&deployer.ValidationError{Field: "topology-spread", Err: err})
+		}
+		opts.TopologySpread = append(opts.TopologySpread, constraint)
+	}
+	if *affinity != "" {
+		opts.Affinity = &corev1.Affinity{}
+		if err := yaml.UnmarshalStrict([]byte(*affinity), opts.Affinity); err != nil {
+			fail(&deployer.ValidationError{Field: "affinity", Err: err})
+		}
+	}
+	opts.Security = deployer.Security{Preset: *podSecurity, DropCapabilities: *dropCapabilities, SeccompProfile: *seccompProfile}
+	if flagSet("run-as-non-root") {
+		opts.Security.RunAsNonRoot = runAsNonRoot
+	}
+	if flagSet("read-only-root-filesystem") {
+		opts.Security.ReadOnlyRootFilesystem = readOnlyRootFS
+	}
+	if flagSet("allow-privilege-escalation") {
+		opts.Security.AllowPrivilegeEscalation = allowPrivilegeEscalation
+	}
+	for name, id := range map[string]**int64{"run-as-user": &opts.Security.RunAsUser, "run-as-group": &opts.Security.RunAsGroup, "fs-group": &opts.Security.FSGroup} {
+		if flagSet(name) {
+			value, _ := flags.GetInt64(name)
+			*id = &value
+		}
+	}
+	opts.Nginx = deployer.NginxAnnotations{ProxyBodySize: *proxyBodySize, RewriteTarget: *rewriteTarget}
+	if flagSet("ssl-redirect") {
+		opts.Nginx.SSLRedirect = sslRedirect
+	}
+	if opts.ServiceMonitorLabels, err = loadKeyValues("", "", *serviceMonitorLabels, "servicemonitor-label"); err != nil {
+		fail(err)
+	}
+	if opts.Env, err = loadKeyValues(*envFile, "env-from-file", *env, "env"); err != nil {
+		fail(err)
+	}
+	if opts.EnvFromSecret, err = loadKeyValues("", "", *envFromSecret, "env-from-secret"); err != nil {
+		fail(err)
+	}
+	if opts.EnvFromConfigMap, err = loadKeyValues("", "", *envFromConfigMap, "env-from-configmap"); err != nil {
+		fail(err)
+	}
+	if opts.DBCredentials, err = loadKeyValues(*dbSecretFile, "db-secret-file", *dbSecretLiterals, "db-secret-literal"); err != nil {
+		fail(err)
+	}
+	if *manifests != "" {
+		vals, err := loadValues(*valuesFile, *overrides)
+		if err != nil {
+			fail(err)
+		}
+		if opts.Manifests, err = deployer.LoadManifests(*manifests, vals); err != nil {
+			fail(&deployer.ValidationError{Field: "manifests", Err: err})
+		}
+	} else if *valuesFile != "" || len(*overrides) > 0 {
+		fail(&deployer.ValidationError{Field: "values", Err: errors.New("-values and -set only apply to -manifests")})
+	}
+	if *extraManifests != "" {
+		if opts.ExtraManifests, err = deployer.LoadExtraManifests(*extraManifests); err != nil {
+			fail(&deployer.ValidationError{Field: "extra-manifests", Err: err})
+		}
+	}
+	for _, dir := range *overlays {
+		patches, err := deployer.LoadOverlay(dir)
+		if err != nil {
+			fail(&deployer.ValidationError{Field: "overlay", Err: err})
+		}
+		opts.Patches = append(opts.Patches, patches...)
+	}
+	switch command {
+	case "template", "export", "generate-chart":
+		// Rendered without a cluster, an empty -routing can't be settled
+		// by Deployer.ResolveRouting and means the ingress.
+		if opts.Routing == "" {
+			opts.Routing = deployer.RoutingIngress
+		}
+	}
+	if err := opts.Validate(); err != nil {
+		fail(err)
+	}
+	verification := signatureVerification()
+	if (*pinDigest || verification != nil) && !*del {
+		switch command {
+		case "", "deploy", "diff", "template", "export", "generate-chart":
+			ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+			if verification != nil {
+				opts, err = deployer.VerifyImageSignature(ctx, opts, *verification)
+			} else {
+				opts, err = deployer.PinImageDigest(ctx, opts)
+			}
+			cancel()
+			if err != nil {
+				fail(err)
+			}
+		}
+	}
+	return &session{log: log, opts: opts, pruneResources: pruneResources, smokeTests: smoke}
+}
+
+// signatureVerification returns the settings of -verify-signature, or nil
+// without it.
+func signatureVerification() *deployer.SignatureVerification {
+	if !*verifySignature {
+		for _, name := range []string{"cosign-key", "certificate-identity", "certificate-oidc-issuer", "fulcio-root", "rekor-url", "insecure-ignore-tlog"} {
+			if flagSet(name) {
+				fail(&deployer.ValidationError{Field: name, Err: errors.New("only applies with -verify-signature")})
+			}
+		}
+		return nil
+	}
+	v := &deployer.SignatureVerification{
+		CertificateIdentity:   *certificateIdentity,
+		CertificateOIDCIssuer: *certificateOIDCIssuer,
+		RekorURL:              *rekorURL,
+		IgnoreTlog:            *insecureIgnoreTlog,
+	}
+	var err error
+	if *cosignKey != "" {
+		if v.PublicKey, err = os.ReadFile(*cosignKey); err != nil {
+			fail(&deployer.ValidationError{Field: "cosign-key", Err: err})
+		}
+	}
+	if *fulcioRoot != "" {
+		if v.FulcioRoots, err = os.ReadFile(*fulcioRoot); err != nil {
+			fail(&deployer.ValidationError{Field: "fulcio-root", Err: err})
+		}
+	}
+	return v
+}
+
+// connect builds the Deployer talking to the cluster, and the contexts of
+// the run. The returned func releases them.
+func (s *session) connect() (release func()) {
+	config, contextName, err := loadConfig(*kubeconfig, *kubeContext)
+	if err != nil {
+		fail(&configError{err})
+	}
+	s.log.Info("Using kubeconfig context", "context", contextName, "server", config.Host)
+	clientTuning{QPS: float32(*kubeQPS), Burst: *kubeBurst, Timeout: *requestTimeout}.tune(config)
+
+	d, err := deployer.NewForConfig(config, s.opts)
+	if err != nil {
+		fail(&configError{err})
+	}
+	release = s.start()
+	if err := d.ResolveRouting(s.ctx); err != nil {
+		fail(err)
+	}
+	s.d = d
+	run.d = d
+	return release
+}
+
+// connectClusters builds a Deployer per context of -contexts, each from the
+// rest.Config of its context and logging with it, and the contexts of the
+// run as connect does. The returned func releases them.
+func (s *session) connectClusters() (clusters []deployer.Cluster, release func()) {
+	for _, name := range *contexts {
+		config, _, err := loadConfig(*kubeconfig, name)
+		if err != nil {
+			fail(&configError{fmt.Errorf("context %s: %w", name, err)})
+		}
+		s.log.Info("Using kubeconfig context", "context", name, "server", config.Host)
+		clientTuning{QPS: float32(*kubeQPS), Burst: *kubeBurst, Timeout: *requestTimeout}.tune(config)
+
+		opts := s.opts
+		opts.Logger = s.log.WithValues("context", name)
+		d, err := deployer.NewForConfig(config, opts)
+		if err != nil {
+			fail(&configError{fmt.Errorf("context %s: %w", name, err)})
+		}
+		clusters = append(clusters, deployer.Cluster{Name: name, Deployer: d})
+	}
+	return clusters, s.start()
+}
+
+// start sets the contexts of the run, ctx and sigCtx. The returned func
+// releases them.
+func (s *session) start() (release func()) {
+	// An interrupt or SIGTERM cancels the requests and waits in flight; a
+	// second one kills the process. Runs that end on their own must also
+	// finish within -timeout.
+	sigCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigCtx.Done()
+		stop()
+	}()
+	deadline := *timeout
+	if *strategy == "blue-green" {
+		deadline += *keepOldFor
+	}
+	ctx, cancel := context.WithTimeout(sigCtx, deadline)
+	run.ctx = ctx
+	s.ctx, s.sigCtx = ctx, sigCtx
+	return func() {
+		cancel()
+		stop()
+	}
+}
@@ -0,0 +1,143 @@
+package cmd
+
+import (
+	"github.com/raihankhan/ecommerceApi-client-go/pkg/deployer"
+	"github.com/spf13/pflag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// resetFlags sets every flag back to its default, as not given.
+func resetFlags() {
+	flags.VisitAll(func(f *pflag.Flag) {
+		switch v := f.Value.(type) {
+		case *stringList:
+			*v = nil
+		case pflag.SliceValue:
+			_ = v.Replace(nil)
+		default:
+			_ = v.Set(f.DefValue)
+		}
+		f.Changed = false
+	})
+}
+
+func TestPreparePrecedence(t *testing.T) {
+	const file = `
+namespace: from-file
+replicas: 3
+image: ghcr.io/acme/ecommerce-api:file
+profiles:
+  prod:
+    namespace: from-profile
+`
+	tests := []struct {
+		name         string
+		args         []string
+		env          map[string]string
+		file         string
+		wantNS       string
+		wantReplicas int32
+		wantImage    string
+	}{
+		{
+			name:         "defaults",
+			wantNS:       deployer.DefaultNamespace,
+			wantReplicas: deployer.DefaultReplicas,
+			wantImage:    deployer.DefaultImage,
+		},
+		{
+			name:         "file over defaults",
+			file:         file,
+			wantNS:       "from-file",
+			wantReplicas: 3,
+			wantImage:    "ghcr.io/acme/ecommerce-api:file",
+		},
+		{
+			name:         "profile over the top level",
+			args:         []string{"--profile=prod"},
+			file:         file,
+			wantNS:       "from-profile",
+			wantReplicas: 3,
+			wantImage:    "ghcr.io/acme/ecommerce-api:file",
+		},
+		{
+			name:         "env over defaults",
+			env:          map[string]string{"ECOMMERCE_NAMESPACE": "from-env", "ECOMMERCE_REPLICAS": "4"},
+			wantNS:       "from-env",
+			wantReplicas: 4,
+			wantImage:    deployer.DefaultImage,
+		},
+		{
+			name:         "env over file",
+			args:         []string{"--profile=prod"},
+			env:          map[string]string{"ECOMMERCE_NAMESPACE": "from-env"},
+			file:         file,
+			wantNS:       "from-env",
+			wantReplicas: 3,
+			wantImage:    "ghcr.io/acme/ecommerce-api:file",
+		},
+		{
+			name:         "flag over env and file",
+			args:         []string{"--namespace=from-flag", "--image=ghcr.io/acme/ecommerce-api:flag"},
+			env:          map[string]string{"ECOMMERCE_NAMESPACE": "from-env", "ECOMMERCE_REPLICAS": "4"},
+			file:         file,
+			wantNS:       "from-flag",
+			wantReplicas: 4,
+			wantImage:    "ghcr.io/acme/ecommerce-api:flag",
+		},
+		{
+			name:         "empty env ignored",
+			env:          map[string]string{"ECOMMERCE_NAMESPACE": ""},
+			file:         file,
+			wantNS:       "from-file",
+			wantReplicas: 3,
+			wantImage:    "ghcr.io/acme/ecommerce-api:file",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetFlags()
+			t.Cleanup(resetFlags)
+			args := tt.args
+			if tt.file != "" {
+				path := filepath.Join(t.TempDir(), defaultConfigFile)
+				if err := os.WriteFile(path, []byte(tt.file), 0o644); err != nil {
+					t.Fatal(err)
+				}
+				args = append([]string{"--file=" + path}, args...)
+			}
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			if err := flags.Parse(args); err != nil {
+				t.Fatal(err)
+			}
+
+			s := prepare("template")
+			if s.opts.Namespace != tt.wantNS {
+				t.Errorf("got namespace %q, want %q", s.opts.Namespace, tt.wantNS)
+			}
+			if *s.opts.Replicas != tt.wantReplicas {
+				t.Errorf("got %d replicas, want %d", *s.opts.Replicas, tt.wantReplicas)
+			}
+			if s.opts.Image != tt.wantImage {
+				t.Errorf("got image %q, want %q", s.opts.Image, tt.wantImage)
+			}
+		})
+	}
+}
+
+func TestFlagSources(t *testing.T) {
+	got := flagSources(map[string]bool{"namespace": true}, []string{"replicas"}, []string{"image"})
+	want := map[string]string{"namespace": sourceFlag, "replicas": sourceEnv, "image": sourceFile}
+	for name, source := range want {
+		if got[name] != source {
+			t.Errorf("%s: got source %q, want %q", name, got[name], source)
+		}
+	}
+	if len(got) != len(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,213 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/raihankhan/ecommerceApi-client-go/pkg/deployer"
+	"github.com/spf13/cobra"
+	"io"
+	"os"
+	"sigs.k8s.io/yaml"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// absent is printed in place of the details of a missing object.
+const absent = "absent"
+
+// printStatus writes status to w as a table, or as JSON or YAML for scripts.
+func printStatus(w io.Writer, status *deployer.Status, format string) error {
+	switch format {
+	case "json":
+		data, err := json.MarshalIndent(status, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "%s\n", data)
+		return err
+	case "yaml":
+		data, err := yaml.Marshal(status)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "DEPLOYMENT\tREADY\tUP-TO-DATE\tAVAILABLE\tCONDITIONS")
+	deployments := []deployer.DeploymentStatus{status.Deployment}
+	if status.Canary != nil {
+		deployments = append(deployments, *status.Canary)
+	}
+	if status.Cache != nil {
+		deployments = append(deployments, *status.Cache)
+	}
+	for _, dep := range deployments {
+		switch {
+		case dep.Name == "":
+		case !dep.Present:
+			fmt.Fprintf(tw, "%s\t%s\t\t\t\n", objectRef(status.Namespace, dep.Name), absent)
+		default:
+			var conditions []string
+			for _, c := range dep.Conditions {
+				conditions = append(conditions, c.Type+"="+c.Status)
+			}
+			fmt.Fprintf(tw, "%s\t%d/%d\t%d\t%d\t%s\n", objectRef(status.Namespace, dep.Name),
+				dep.Ready, dep.Desired, dep.Updated, dep.Available, orNone(conditions))
+		}
+	}
+
+	fmt.Fprintln(tw, "\nSERVICE\tTYPE\tCLUSTER-IP\tNODE-PORTS\tLOAD-BALANCER")
+	for _, s := range status.Services {
+		if !s.Present {
+			fmt.Fprintf(tw, "%s\t%s\t\t\t\n", objectRef(status.Namespace, s.Name), absent)
+			continue
+		}
+		var ports []string
+		for _, p := range s.NodePorts {
+			ports = append(ports, fmt.Sprint(p))
+		}
+		external := lbOrPending(s.LoadBalancer, s.Type == "LoadBalancer")
+		if s.ExternalName != "" {
+			external = s.ExternalName
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", objectRef(status.Namespace, s.Name),
+			s.Type, s.ClusterIP, orNone(ports), external)
+	}
+
+	if len(status.Ingresses) > 0 {
+		fmt.Fprintln(tw, "\nINGRESS\tHOSTS\tLOAD-BALANCER")
+		for _, i := range status.Ingresses {
+			if !i.Present {
+				fmt.Fprintf(tw, "%s\t%s\t\n", objectRef(status.Namespace, i.Name), absent)
+				continue
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", objectRef(status.Namespace, i.Name),
+				orNone(i.Hosts), lbOrPending(i.LoadBalancer, true))
+		}
+	}
+
+	if len(status.Routes) > 0 {
+		fmt.Fprintln(tw, "\nHTTPROUTE\tHOSTNAMES\tGATEWAY\tACCEPTED\tRESOLVED-REFS\tMESSAGE")
+		for _, r := range status.Routes {
+			if !r.Present {
+				fmt.Fprintf(tw, "%s\t%s\t\t\t\t\n", objectRef(status.Namespace, r.Name), absent)
+				continue
+			}
+			if len(r.Parents) == 0 {
+				fmt.Fprintf(tw, "%s\t%s\t<pending>\t\t\t\n", objectRef(status.Namespace, r.Name), orNone(r.Hostnames))
+			}
+			for _, p := range r.Parents {
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", objectRef(status.Namespace, r.Name), orNone(r.Hostnames),
+					p.Gateway, orUnknown(p.Accepted), orUnknown(p.ResolvedRefs), p.Message)
+			}
+		}
+	}
+
+	if len(status.OpenShiftRoutes) > 0 {
+		fmt.Fprintln(tw, "\nROUTE\tHOST\tPATH\tADMITTED-BY\tMESSAGE")
+		for _, r := range status.OpenShiftRoutes {
+			if !r.Present {
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t\n", objectRef(status.Namespace, r.Name), r.Host, r.Path, absent)
+				continue
+			}
+			admitted := orNone(r.Routers)
+			if len(r.Routers) == 0 && r.Message == "" {
+				admitted = "<pending>"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", objectRef(status.Namespace, r.Name), r.Host, r.Path, admitted, r.Message)
+		}
+	}
+
+	if len(status.CronJobs) > 0 {
+		fmt.Fprintln(tw, "\nCRONJOB\tSCHEDULE\tSUSPEND\tACTIVE\tLAST-SCHEDULE")
+		for _, c := range status.CronJobs {
+			if !c.Present {
+				fmt.Fprintf(tw, "%s\t%s\t%s\t\t\n", objectRef(status.Namespace, c.Name), c.Schedule, absent)
+				continue
+			}
+			last := "<none>"
+			if c.LastScheduleTime != nil {
+				last = c.LastScheduleTime.UTC().Format(time.RFC3339)
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%t\t%d\t%s\n", objectRef(status.Namespace, c.Name), c.Schedule, c.Suspend, c.Active, last)
+		}
+	}
+
+	if len(status.Extras) > 0 {
+		fmt.Fprintln(tw, "\nEXTRA\tKIND\tSTATE")
+		for _, e := range status.Extras {
+			state := "present"
+			if !e.Present {
+				state = absent
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", objectRef(e.Namespace, e.Name), e.Kind, state)
+		}
+	}
+
+	if status.Canary != nil {
+		fmt.Fprintln(tw, "\nPOD\tTRACK\tPHASE\tREADY\tRESTARTS")
+		for _, p := range status.Pods {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%t\t%d\n", objectRef(status.Namespace, p.Name), p.Track, p.Phase, p.Ready, p.Restarts)
+		}
+		return tw.Flush()
+	}
+	fmt.Fprintln(tw, "\nPOD\tPHASE\tREADY\tRESTARTS")
+	for _, p := range status.Pods {
+		fmt.Fprintf(tw, "%s\t%s\t%t\t%d\n", objectRef(status.Namespace, p.Name), p.Phase, p.Ready, p.Restarts)
+	}
+	return tw.Flush()
+}
+
+// orNone joins values with commas, or returns <none> when there are none.
+func orNone(values []string) string {
+	if len(values) == 0 {
+		return "<none>"
+	}
+	return strings.Join(values, ",")
+}
+
+// orUnknown returns the status of a condition, Unknown when it isn't
+// reported yet.
+func orUnknown(status string) string {
+	if status == "" {
+		return "Unknown"
+	}
+	return status
+}
+
+// lbOrPending joins load balancer addresses, reporting <pending> when one is
+// expected but status.loadBalancer is still empty.
+func lbOrPending(addresses []string, expected bool) string {
+	if len(addresses) == 0 && expected {
+		return "<pending>"
+	}
+	return orNone(addresses)
+}
+
+func newStatusCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "status",
+		Short: "Print the state of the release's objects",
+		Args:  cobra.NoArgs,
+		Run: func(*cobra.Command, []string) {
+			s := prepare("status")
+			if len(*contexts) > 0 {
+				os.Exit(runClusters(s, "status"))
+			}
+			defer s.connect()()
+			d, ctx := s.d, s.ctx
+			status, err := d.Status(ctx)
+			if err != nil {
+				fail(err)
+			}
+			if err := printStatus(os.Stdout, status, *output); err != nil {
+				fail(err)
+			}
+		},
+	}
+	addFlags(cmd.Flags(), objectFlags, clusterFlags, []string{"output"})
+	return cmd
+}
@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/raihankhan/ecommerceApi-client-go/pkg/deployer"
+	"github.com/spf13/cobra"
+	"io"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"os"
+	"sigs.k8s.io/yaml"
+)
+
+func newTemplateCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "template",
+		Short: "Print the objects as YAML without contacting the cluster",
+		Args:  cobra.NoArgs,
+		Run:   func(*cobra.Command, []string) { printTemplate(prepare("template").opts) },
+	}
+	addFlags(cmd.Flags(), objectFlags)
+	return cmd
+}
+
+// printTemplate prints the objects of opts, or the EcommerceApp with
+// -cr-mode, with the secret values redacted.
+func printTemplate(opts deployer.Options) {
+	if *crMode {
+		if err := printYAML(os.Stdout, []*unstructured.Unstructured{deployer.NewApp(opts)}); err != nil {
+			fail(err)
+		}
+		return
+	}
+	objects, err := deployer.Render(opts)
+	if err != nil {
+		fail(err)
+	}
+	if err := printYAML(os.Stdout, objects); err != nil {
+		fail(err)
+	}
+}
+
+// printYAML writes objects to w as a multi-document YAML stream.
+func printYAML(w io.Writer, objects []*unstructured.Unstructured) error {
+	for _, obj := range objects {
+		data, err := yaml.Marshal(deployer.Redact(obj).Object)
+		if err != nil {
+			return fmt.Errorf("failed to encode %s %s: %w", obj.GetKind(), obj.GetName(), err)
+		}
+		if _, err := fmt.Fprintf(w, "---\n%s", data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/raihankhan/ecommerceApi-client-go/pkg/deployer"
+	"github.com/raihankhan/ecommerceApi-client-go/pkg/version"
+	"github.com/spf13/cobra"
+	"io"
+	apiversion "k8s.io/apimachinery/pkg/version"
+	"k8s.io/client-go/discovery"
+	"sigs.k8s.io/yaml"
+	"strings"
+)
+
+// versions is what the version subcommand prints.
+type versions struct {
+	Client version.Info     `json:"client"`
+	Server *apiversion.Info `json:"server,omitempty"`
+}
+
+func newVersionCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "version",
+		Short: "Print the version of the tool and, with --client=false, of the cluster",
+		Args:  cobra.NoArgs,
+		Run:   func(*cobra.Command, []string) { runVersion() },
+	}
+	addFlags(cmd.Flags(), []string{"client", "output"})
+	return cmd
+}
+
+// runVersion prints the versions, asking the cluster for its own unless
+// -client.
+func runVersion() {
+	if _, err := applyEnv(); err != nil {
+		fail(err)
+	}
+	if *output != "table" && *output != "json" && *output != "yaml" {
+		fail(&deployer.ValidationError{Field: "output", Err: fmt.Errorf("must be table, json or yaml, got %q", *output)})
+	}
+	v := versions{Client: version.Get()}
+	if !*clientOnly {
+		config, _, err := loadConfig(*kubeconfig, *kubeContext)
+		if err != nil {
+			fail(&configError{err})
+		}
+		clientTuning{QPS: float32(*kubeQPS), Burst: *kubeBurst, Timeout: *requestTimeout}.tune(config)
+		client, err := discovery.NewDiscoveryClientForConfig(config)
+		if err != nil {
+			fail(&configError{err})
+		}
+		if v.Server, err = client.ServerVersion(); err != nil {
+			fail(&configError{fmt.Errorf("failed to get the server version: %w", err)})
+		}
+	}
+	if err := printVersions(out, v, *output); err != nil {
+		fail(err)
+	}
+}
+
+// printVersions writes v to w as lines for people, or as JSON or YAML for
+// scripts.
+func printVersions(w io.Writer, v versions, format string) error {
+	switch format {
+	case "json":
+		data, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "%s\n", data)
+		return err
+	case "yaml":
+		data, err := yaml.Marshal(v)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+	}
+	_, err := io.WriteString(w, versionText(v))
+	return err
+}
+
+// versionText is v as lines for people, which --version prints too.
+func versionText(v versions) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Version:    %s\n", v.Client.Version)
+	if v.Client.Commit != "" {
+		fmt.Fprintf(&b, "Commit:     %s\n", v.Client.Commit)
+	}
+	if v.Client.Date != "" {
+		fmt.Fprintf(&b, "Built:      %s\n", v.Client.Date)
+	}
+	fmt.Fprintf(&b, "Go version: %s\n", v.Client.GoVersion)
+	fmt.Fprintf(&b, "Platform:   %s\n", v.Client.Platform)
+	if v.Server != nil {
+		fmt.Fprintf(&b, "Server:     %s\n", v.Server.GitVersion)
+	}
+	return b.String()
+}
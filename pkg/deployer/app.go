@@ -0,0 +1,338 @@
+package deployer
+
+import (
+	"context"
+	"fmt"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"time"
+)
+
+// The API group, version and kind of the EcommerceApp custom resource.
+const (
+	AppGroup   = "apps.raihankhan.dev"
+	AppVersion = "v1alpha1"
+	AppKind    = "EcommerceApp"
+)
+
+// crdEstablishTimeout bounds how long a CustomResourceDefinition, that of
+// InstallCRD or one among the manifests, is waited for until the apiserver
+// serves the new kind.
+const crdEstablishTimeout = time.Minute
+
+// appPollInterval is how often the EcommerceApp conditions are checked
+// while waiting.
+const appPollInterval = 2 * time.Second
+
+// GroupVersionResources of EcommerceApps and of their definition.
+var (
+	AppResource = schema.GroupVersionResource{Group: AppGroup, Version: AppVersion, Resource: "ecommerceapps"}
+	CRDResource = schema.GroupVersionResource{Group: "apiextensions.k8s.io", Version: "v1", Resource: "customresourcedefinitions"}
+)
+
+// AppSpec is the spec of an EcommerceApp: the subset of Options the
+// controller takes from each app. The rest come from the controller's own
+// flags.
+type AppSpec struct {
+	Image       string       `json:"image,omitempty"`
+	Replicas    *int32       `json:"replicas,omitempty"`
+	Hosts       []string     `json:"hosts,omitempty"`
+	Paths       []AppPath    `json:"paths,omitempty"`
+	ServiceType string       `json:"serviceType,omitempty"`
+	Database    *AppDatabase `json:"database,omitempty"`
+}
+
+// AppPath is an ingress path of an EcommerceApp.
+type AppPath struct {
+	Path     string `json:"path"`
+	PathType string `json:"pathType,omitempty"`
+}
+
+// AppDatabase selects the database of an EcommerceApp.
+type AppDatabase struct {
+	// Postgres deploys the PostgreSQL StatefulSet, as Options.Postgres.
+	Postgres     bool   `json:"postgres,omitempty"`
+	PostgresTag  string `json:"postgresTag,omitempty"`
+	Storage      string `json:"storage,omitempty"`
+	StorageClass string `json:"storageClass,omitempty"`
+}
+
+// AppStatus is the status the controller reports on an EcommerceApp.
+type AppStatus struct {
+	ObservedGeneration int64              `json:"observedGeneration,omitempty"`
+	Replicas           int64              `json:"replicas"`
+	ReadyReplicas      int64              `json:"readyReplicas"`
+	Conditions         []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// AppReady is the condition reporting whether an EcommerceApp is deployed
+// and fully rolled out.
+const AppReady = "Ready"
+
+// newAppCRD returns the CustomResourceDefinition of EcommerceApps.
+func newAppCRD() *unstructured.Unstructured {
+	str := map[string]interface{}{"type": "string"}
+	spec := map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"image":    str,
+			"replicas": map[string]interface{}{"type": "integer", "format": "int32", "minimum": int64(0)},
+			"hosts":    map[string]interface{}{"type": "array", "items": str},
+			"paths": map[string]interface{}{
+				"type": "array",
+				"items": map[string]interface{}{
+					"type":     "object",
+					"required": []interface{}{"path"},
+					"properties": map[string]interface{}{
+						"path":     str,
+						"pathType": map[string]interface{}{"type": "string", "enum": []interface{}{"Prefix", "Exact", "ImplementationSpecific"}},
+					},
+				},
+			},
+			"serviceType": map[string]interface{}{"type": "string", "enum": []interface{}{"ClusterIP", "NodePort", "LoadBalancer"}},
+			"database": map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"postgres":     map[string]interface{}{"type": "boolean"},
+					"postgresTag":  str,
+					"storage":      str,
+					"storageClass": str,
+				},
+			},
+		},
+	}
+	status := map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"observedGeneration": map[string]interface{}{"type": "integer", "format": "int64"},
+			"replicas":           map[string]interface{}{"type": "integer", "format": "int64"},
+			"readyReplicas":      map[string]interface{}{"type": "integer", "format": "int64"},
+			"conditions": map[string]interface{}{
+				"type": "array",
+				"items": map[string]interface{}{
+					"type":     "object",
+					"required": []interface{}{"type", "status", "lastTransitionTime", "reason", "message"},
+					"properties": map[string]interface{}{
+						"type":               str,
+						"status":             map[string]interface{}{"type": "string", "enum": []interface{}{"True", "False", "Unknown"}},
+						"observedGeneration": map[string]interface{}{"type": "integer", "format": "int64"},
+						"lastTransitionTime": map[string]interface{}{"type": "string", "format": "date-time"},
+						"reason":             str,
+						"message":            str,
+					},
+				},
+			},
+		},
+	}
+	return &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "apiextensions.k8s.io/v1",
+			"kind":       "CustomResourceDefinition",
+			"metadata":   map[string]interface{}{"name": AppResource.Resource + "." + AppGroup},
+			"spec": map[string]interface{}{
+				"group": AppGroup,
+				"scope": "Namespaced",
+				"names": map[string]interface{}{
+					"kind":       AppKind,
+					"listKind":   AppKind + "List",
+					"plural":     AppResource.Resource,
+					"singular":   "ecommerceapp",
+					"shortNames": []interface{}{"eapp"},
+				},
+				"versions": []interface{}{
+					map[string]interface{}{
+						"name":    AppVersion,
+						"served":  true,
+						"storage": true,
+						"schema": map[string]interface{}{
+							"openAPIV3Schema": map[string]interface{}{
+								"type":       "object",
+								"properties": map[string]interface{}{"spec": spec, "status": status},
+							},
+						},
+						"subresources": map[string]interface{}{"status": map[string]interface{}{}},
+						"additionalPrinterColumns": []interface{}{
+							map[string]interface{}{"name": "Image", "type": "string", "jsonPath": ".spec.image"},
+							map[string]interface{}{"name": "Ready", "type": "integer", "jsonPath": ".status.readyReplicas"},
+							map[string]interface{}{"name": "Status", "type": "string", "jsonPath": `.status.conditions[?(@.type=="Ready")].status`},
+							map[string]interface{}{"name": "Age", "type": "date", "jsonPath": ".metadata.creationTimestamp"},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// InstallCRD applies the EcommerceApp CustomResourceDefinition and waits for
+// the apiserver to serve it.
+func (d *Deployer) InstallCRD(ctx context.Context) (*unstructured.Unstructured, error) {
+	crd, err := d.apply(ctx, CRDResource, newAppCRD())
+	if err != nil || d.opts.DryRun {
+		return crd, err
+	}
+	return d.waitEstablished(ctx, crd)
+}
+
+// NewApp returns the EcommerceApp carrying the AppSpec fields of opts,
+// named after the release.
+func NewApp(opts Options) *unstructured.Unstructured {
+	opts = opts.withDefaults()
+	spec := AppSpec{
+		Image:       opts.Image,
+		Replicas:    opts.Replicas,
+		Hosts:       opts.Hosts,
+		ServiceType: opts.ServiceType,
+	}
+	for _, p := range opts.Paths {
+		spec.Paths = append(spec.Paths, AppPath{Path: p.Path, PathType: p.PathType})
+	}
+	if opts.Postgres {
+		spec.Database = &AppDatabase{
+			Postgres:     true,
+			PostgresTag:  opts.PostgresTag,
+			Storage:      opts.PostgresStorage,
+			StorageClass: opts.PostgresStorageClass,
+		}
+	}
+	content, _ := runtime.DefaultUnstructuredConverter.ToUnstructured(&spec)
+	return &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": AppGroup + "/" + AppVersion,
+			"kind":       AppKind,
+			"metadata": map[string]interface{}{
+				"name":      opts.release(),
+				"namespace": opts.Namespace,
+			},
+			"spec": content,
+		},
+	}
+}
+
+// ApplyApp applies the EcommerceApp of Options, for the controller to deploy,
+// creating Namespace first with Options.CreateNamespace.
+func (d *Deployer) ApplyApp(ctx context.Context) (*unstructured.Unstructured, error) {
+	if err := d.opts.Validate(); err != nil {
+		return nil, err
+	}
+	if _, err := d.EnsureNamespace(ctx); err != nil {
+		return nil, err
+	}
+	return d.apply(ctx, AppResource, NewApp(d.opts))
+}
+
+// DeleteApp deletes the EcommerceApp of Options; garbage collection then
+// deletes the objects the controller made for it, but the persistent volume
+// claims.
+func (d *Deployer) DeleteApp(ctx context.Context) error {
+	name := d.opts.release()
+	err := d.resource(AppResource).Delete(ctx, name, metav1.DeleteOptions{DryRun: d.dryRun()})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return d.opError(OpDelete, AppResource, name, err)
+	}
+	return nil
+}
+
+// WaitForApp waits until the controller reports the EcommerceApp of Options
+// Ready for its latest generation.
+func (d *Deployer) WaitForApp(ctx context.Context, timeout time.Duration) error {
+	name := d.opts.release()
+	waitCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	var app *unstructured.Unstructured
+	err := wait.PollImmediateUntilWithContext(waitCtx, appPollInterval, func(ctx context.Context) (bool, error) {
+		live, err := d.resource(AppResource).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return false, d.opError(OpGet, AppResource, name, err)
+		}
+		app = live
+		observed, _, _ := unstructured.NestedInt64(live.Object, "status", "observedGeneration")
+		return observed >= live.GetGeneration() && conditionTrue(live, AppReady), nil
+	})
+	if err != nil {
+		reason := "no status yet; is the controller running?"
+		if app != nil {
+			if c := findCondition(app, AppReady); c != nil {
+				reason = fmt.Sprintf("%v: %v", c["reason"], c["message"])
+			}
+		}
+		return d.opError(OpWait, AppResource, name, fmt.Errorf("%w: %s", err, reason))
+	}
+	return nil
+}
+
+// OptionsFromApp returns base with the spec of app applied, deploying to the
+// namespace of app as the release named after it, which owns every object
+// but the persistent volume claims.
+func OptionsFromApp(base Options, app *unstructured.Unstructured) (Options, error) {
+	var spec AppSpec
+	content, _, _ := unstructured.NestedMap(app.Object, "spec")
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(content, &spec); err != nil {
+		return base, &ValidationError{Field: "spec", Err: err}
+	}
+	opts := base
+	opts.Namespace = app.GetNamespace()
+	opts.Release = app.GetName()
+	opts.CreateNamespace = false
+	opts.Manifests = nil
+	opts.ExtraManifests = nil
+	opts.Owner = ""
+	if spec.Image != "" {
+		opts.Image = spec.Image
+	}
+	if spec.Replicas != nil {
+		opts.Replicas = spec.Replicas
+	}
+	if spec.Hosts != nil {
+		opts.Hosts = spec.Hosts
+	}
+	if spec.Paths != nil {
+		opts.Paths = nil
+		for _, p := range spec.Paths {
+			pathType := p.PathType
+			if pathType == "" {
+				pathType = "Prefix"
+			}
+			opts.Paths = append(opts.Paths, IngressPath{Path: p.Path, PathType: pathType})
+		}
+	}
+	if spec.ServiceType != "" {
+		opts.ServiceType = spec.ServiceType
+	}
+	if db := spec.Database; db != nil {
+		opts.Postgres = db.Postgres
+		opts.PostgresTag = db.PostgresTag
+		opts.PostgresStorage = db.Storage
+		opts.PostgresStorageClass = db.StorageClass
+	}
+	controller := true
+	opts.appOwner = &metav1.OwnerReference{
+		APIVersion: app.GetAPIVersion(),
+		Kind:       app.GetKind(),
+		Name:       app.GetName(),
+		UID:        app.GetUID(),
+		Controller: &controller,
+	}
+	return opts, opts.Validate()
+}
+
+// findCondition returns the condition of type t in the status of obj, or nil.
+func findCondition(obj *unstructured.Unstructured, t string) map[string]interface{} {
+	conditions, _, _ := unstructured.NestedSlice(obj.Object, "status", "conditions")
+	for _, c := range conditions {
+		if m, ok := c.(map[string]interface{}); ok && m["type"] == t {
+			return m
+		}
+	}
+	return nil
+}
+
+// conditionTrue reports whether obj has the condition t with status True.
+func conditionTrue(obj *unstructured.Unstructured, t string) bool {
+	c := findCondition(obj, t)
+	return c != nil && c["status"] == "True"
+}
@@ -0,0 +1,185 @@
+package deployer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"strings"
+	"time"
+)
+
+// recreateTimeout bounds how long deleteForRecreate waits for the old object
+// to go away.
+const recreateTimeout = time.Minute
+
+// FieldConflict is a single field that another field manager owns.
+type FieldConflict struct {
+	Field   string
+	Manager string
+}
+
+// ConflictError is returned when a server-side apply fails because other
+// field managers own some of the applied fields.
+type ConflictError struct {
+	Kind      string
+	Name      string
+	Conflicts []FieldConflict
+	Err       error
+}
+
+func (e *ConflictError) Error() string {
+	fields := make([]string, 0, len(e.Conflicts))
+	for _, c := range e.Conflicts {
+		fields = append(fields, fmt.Sprintf("%s (owned by %q)", c.Field, c.Manager))
+	}
+	return fmt.Sprintf("conflicts with other field managers: %s; rerun with -force-conflicts to take ownership",
+		strings.Join(fields, ", "))
+}
+
+func (e *ConflictError) Unwrap() error { return e.Err }
+
+// apply server-side applies obj and returns the object as persisted.
+func (d *Deployer) apply(ctx context.Context, gvr schema.GroupVersionResource, obj *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	client := d.resourceFor(gvr, obj)
+
+	// Deploy records whether the object was created, updated or left as
+	// it was, which needs it as it was.
+	log := applyLogFrom(ctx)
+	var live *unstructured.Unstructured
+	if obj.GetKind() == "Service" || obj.GetKind() == "PodDisruptionBudget" || log != nil {
+		got, err := client.Get(ctx, obj.GetName(), metav1.GetOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return nil, d.opError(OpGet, gvr, obj.GetName(), err)
+		}
+		if err == nil {
+			live = got
+		}
+	}
+	if live != nil && (obj.GetKind() == "Service" || obj.GetKind() == "PodDisruptionBudget") {
+		reason := recreateReason(live, obj)
+		switch {
+		case reason == "":
+		case d.opts.DryRun:
+			d.log.Info("Immutable field changed, it would be recreated", "kind", obj.GetKind(), "namespace", d.opts.Namespace,
+				"name", obj.GetName(), "change", reason)
+		default:
+			if err := d.deleteForRecreate(ctx, gvr, obj); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	d.log.Info("Applying", "kind", obj.GetKind(), "namespace", d.opts.Namespace, "name", obj.GetName())
+	data, err := obj.MarshalJSON()
+	if err != nil {
+		return nil, d.opError(OpApply, gvr, obj.GetName(), err)
+	}
+	patchOpts := metav1.PatchOptions{
+		FieldManager: d.opts.FieldManager,
+		Force:        &d.opts.ForceConflicts,
+		DryRun:       d.dryRun(),
+	}
+	var (
+		applied *unstructured.Unstructured
+		handled bool
+	)
+	patchCtx, warnings := withWarnings(ctx)
+	if d.opts.Typed && len(d.opts.Manifests) == 0 {
+		applied, handled, err = d.typedPatch(patchCtx, gvr, obj.GetName(), data, patchOpts)
+	}
+	if !handled {
+		applied, err = client.Patch(patchCtx, obj.GetName(), types.ApplyPatchType, data, patchOpts)
+	}
+	if err != nil {
+		if conflicts := fieldConflicts(err); len(conflicts) > 0 {
+			err = &ConflictError{Kind: obj.GetKind(), Name: obj.GetName(), Conflicts: conflicts, Err: err}
+		}
+		err = immutablePVCError(obj, err)
+		return nil, d.opError(OpApply, gvr, obj.GetName(), err)
+	}
+	d.log.V(2).Info("Applied", "gvr", gvr.String(), "namespace", d.opts.Namespace, "name", applied.GetName(),
+		"uid", applied.GetUID(), "resourceVersion", applied.GetResourceVersion())
+	log.add(applied, applyAction(live, applied), warnings.list())
+	return applied, nil
+}
+
+// recreateReason describes the change from live to obj that can't be made in
+// place, or returns "" when there is none.
+func recreateReason(live, obj *unstructured.Unstructured) string {
+	switch obj.GetKind() {
+	case "Service":
+		// The apiserver changes the type in place, allocating or releasing
+		// the cluster IP and node ports, except to or from ExternalName,
+		// which has neither.
+		from, to := serviceType(live), serviceType(obj)
+		if from != to && (from == string(corev1.ServiceTypeExternalName) || to == string(corev1.ServiceTypeExternalName)) {
+			return fmt.Sprintf("type %s to %s", from, to)
+		}
+		// spec.clusterIP is immutable once allocated, e.g. between None for
+		// headless services and an address.
+		liveIP, _, _ := unstructured.NestedString(live.Object, "spec", "clusterIP")
+		ip, _, _ := unstructured.NestedString(obj.Object, "spec", "clusterIP")
+		if ip != "" && liveIP != "" && ip != liveIP {
+			return fmt.Sprintf("clusterIP %s to %s", liveIP, ip)
+		}
+	case "PodDisruptionBudget":
+		if pdbSelectorChanged(live, obj) {
+			return "selector"
+		}
+	}
+	return ""
+}
+
+// deleteForRecreate removes the live object ahead of applying obj, for
+// changes the apiserver refuses to make in place.
+func (d *Deployer) deleteForRecreate(ctx context.Context, gvr schema.GroupVersionResource, obj *unstructured.Unstructured) error {
+	d.log.Info("Recreating to change an immutable field", "kind", obj.GetKind(), "namespace", d.opts.Namespace, "name", obj.GetName())
+	err := d.resource(gvr).Delete(ctx, obj.GetName(), metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return d.opError(OpDelete, gvr, obj.GetName(), err)
+	}
+
+	// Load balancer services carry a cleanup finalizer, so the old object may
+	// linger for a while after the delete call returns.
+	waitCtx, cancel := context.WithTimeout(ctx, recreateTimeout)
+	defer cancel()
+	return d.waitForDeletion(waitCtx, d.resource(gvr), gvr, obj.GetName())
+}
+
+// fieldConflicts extracts the conflicting fields and their owners from an
+// apply conflict error.
+func fieldConflicts(err error) []FieldConflict {
+	var status apierrors.APIStatus
+	if !apierrors.IsConflict(err) || !errors.As(err, &status) || status.Status().Details == nil {
+		return nil
+	}
+
+	var conflicts []FieldConflict
+	for _, cause := range status.Status().Details.Causes {
+		if cause.Type != metav1.CauseTypeFieldManagerConflict {
+			continue
+		}
+		conflicts = append(conflicts, FieldConflict{Field: cause.Field, Manager: conflictManager(cause.Message)})
+	}
+	return conflicts
+}
+
+// conflictManager pulls the manager name out of a cause message such as
+// `conflict with "kubectl-edit" using apps/v1`.
+func conflictManager(message string) string {
+	start := strings.Index(message, `"`)
+	if start < 0 {
+		return message
+	}
+	end := strings.Index(message[start+1:], `"`)
+	if end < 0 {
+		return message
+	}
+	return message[start+1 : start+1+end]
+}
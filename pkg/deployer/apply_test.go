@@ -0,0 +1,63 @@
+package deployer
+
+import (
+	"context"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"testing"
+)
+
+func TestRecreateReason(t *testing.T) {
+	service := func(typ, clusterIP string) *unstructured.Unstructured {
+		svc := unstructuredObject("v1", "Service", "shop", "server-svc")
+		if typ != "" {
+			_ = unstructured.SetNestedField(svc.Object, typ, "spec", "type")
+		}
+		if clusterIP != "" {
+			_ = unstructured.SetNestedField(svc.Object, clusterIP, "spec", "clusterIP")
+		}
+		return svc
+	}
+	for _, tt := range []struct {
+		name      string
+		live, obj *unstructured.Unstructured
+		want      string
+	}{
+		{"same type", service("NodePort", "10.0.0.1"), service("NodePort", ""), ""},
+		{"default type", service("ClusterIP", "10.0.0.1"), service("", ""), ""},
+		{"ClusterIP to NodePort", service("ClusterIP", "10.0.0.1"), service("NodePort", ""), ""},
+		{"NodePort to LoadBalancer", service("NodePort", "10.0.0.1"), service("LoadBalancer", ""), ""},
+		{"LoadBalancer to ClusterIP", service("LoadBalancer", "10.0.0.1"), service("ClusterIP", ""), ""},
+		{"to ExternalName", service("ClusterIP", "10.0.0.1"), service("ExternalName", ""), "type ClusterIP to ExternalName"},
+		{"from ExternalName", service("ExternalName", ""), service("NodePort", ""), "type ExternalName to NodePort"},
+		{"same clusterIP", service("ClusterIP", "None"), service("ClusterIP", "None"), ""},
+		{"to headless", service("ClusterIP", "10.0.0.1"), service("ClusterIP", "None"), "clusterIP 10.0.0.1 to None"},
+		{"from headless", service("ClusterIP", "None"), service("ClusterIP", "10.0.0.2"), "clusterIP None to 10.0.0.2"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := recreateReason(tt.live, tt.obj); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDeployAllChangesServiceTypeInPlace(t *testing.T) {
+	live := unstructuredObject("v1", "Service", "shop", "server-svc")
+	live.SetUID("uid-live")
+	_ = unstructured.SetNestedField(live.Object, "NodePort", "spec", "type")
+	_ = unstructured.SetNestedField(live.Object, "10.0.0.1", "spec", "clusterIP")
+	opts := testOptions()
+	opts.ServiceType = "LoadBalancer"
+	d, client := newFakeDeployer(opts, shopNamespace(), live)
+
+	if _, err := d.DeployAll(context.Background()); err != nil {
+		t.Fatalf("DeployAll: %v", err)
+	}
+	if hasPrefix(writes(client), "delete services") {
+		t.Errorf("server-svc was recreated: %q", writes(client))
+	}
+	svc := liveObject(t, client, ServiceResource, "shop", "server-svc")
+	if svc.GetUID() != "uid-live" || serviceType(svc) != "LoadBalancer" {
+		t.Errorf("got uid %s, type %s; want the live service updated to LoadBalancer", svc.GetUID(), serviceType(svc))
+	}
+}
@@ -0,0 +1,216 @@
+package deployer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"time"
+)
+
+const (
+	// TrackGreen is the TrackLabel of the green deployment of a blue-green
+	// deploy.
+	TrackGreen = "green"
+	// componentGreen is the ComponentLabel of the green pods, which keeps
+	// them out of the stable selector until the cutover.
+	componentGreen = "api-green"
+
+	// DefaultKeepOldFor is BlueGreenOptions.KeepOldFor when it is zero.
+	DefaultKeepOldFor = 5 * time.Minute
+)
+
+// errBlueGreenRolledBack is returned by DeployBlueGreen when
+// RollbackBlueGreen flipped the services back while it waited.
+var errBlueGreenRolledBack = errors.New("the blue-green deploy was rolled back while keeping the old version")
+
+// greenName is the deployment running the new version during a blue-green
+// deploy.
+func (o Options) greenName() string {
+	return o.objectName("green", "apiserver-green")
+}
+
+// greenSelector returns the labels of the green pods.
+func (o Options) greenSelector() map[string]string {
+	return map[string]string{NameLabel: appName, InstanceLabel: o.release(), ComponentLabel: componentGreen, TrackLabel: TrackGreen}
+}
+
+// BlueGreenOptions configures DeployBlueGreen.
+type BlueGreenOptions struct {
+	// Timeout bounds each wait for a rollout, of green and then of blue.
+	Timeout time.Duration
+	// KeepOldFor is how long blue keeps the previous version after the
+	// cutover, during which RollbackBlueGreen switches back to it at once.
+	// Zero means DefaultKeepOldFor; a negative value doesn't wait.
+	KeepOldFor time.Duration
+	// SmokeTests are sent to green through a port forward once it is
+	// ready. A failure keeps the services on blue, as a failed rollout
+	// does.
+	SmokeTests SmokeTestOptions
+}
+
+// isAPIDeployment reports whether o is the built-in API deployment, blue.
+func (d *Deployer) isAPIDeployment(o object) bool {
+	return o.obj.GetKind() == "Deployment" && o.obj.GetName() == d.opts.deploymentName()
+}
+
+// isAPIService reports whether o is a built-in service selecting the API
+// pods.
+func (d *Deployer) isAPIService(o object) bool {
+	name := o.obj.GetName()
+	return o.obj.GetKind() == "Service" && (name == d.opts.serviceName() || name == d.opts.nodePortName() || name == d.opts.headlessName())
+}
+
+// DeployBlueGreen deploys the release without ever serving a mix of
+// versions. The API deployment, blue, keeps serving while the new version
+// rolls out as the green deployment; once green is fully ready, the services
+// are switched to its pods at once. Blue keeps the previous version for
+// KeepOldFor, then rolls to the new one out of traffic, the services switch
+// back to it and green is deleted, leaving the release as DeployAll would.
+//
+// If green doesn't become ready within Timeout, it is deleted and blue
+// serves on; the rollout error is returned, noting any failure to delete
+// green. Without a live API deployment there is nothing to keep serving,
+// and the release is deployed with DeployAll.
+func (d *Deployer) DeployBlueGreen(ctx context.Context, bg BlueGreenOptions) ([]*unstructured.Unstructured, error) {
+	if len(d.opts.Manifests) > 0 {
+		return nil, &ValidationError{Field: "strategy", Err: errors.New("blue-green is only supported for the built-in objects, not -manifests")}
+	}
+	if bg.KeepOldFor == 0 {
+		bg.KeepOldFor = DefaultKeepOldFor
+	}
+	blueName := d.opts.deploymentName()
+	blue, err := d.resource(DeploymentResource).Get(ctx, blueName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		d.log.Info("No deployment serves yet, deploying directly", "namespace", d.opts.Namespace, "deployment", blueName)
+		return d.deployDirectly(ctx, bg)
+	}
+	if err != nil {
+		return nil, d.opError(OpGet, DeploymentResource, blueName, err)
+	}
+
+	// Everything but blue and the services selecting it can change now:
+	// green needs the new config.
+	if _, err := d.deploy(ctx, func(o object) bool { return !d.isAPIDeployment(o) && !d.isAPIService(o) }, nil); err != nil {
+		return nil, err
+	}
+	replicas, found, _ := unstructured.NestedInt64(blue.Object, "spec", "replicas")
+	if !found {
+		replicas = 1
+	}
+	green := newTrackDeployment(d.opts, d.opts.greenName(), int32(replicas), d.opts.greenSelector())
+	green.SetLabels(merge(green.GetLabels(), map[string]string{TrackLabel: TrackGreen}))
+	if _, err := d.apply(ctx, DeploymentResource, green); err != nil {
+		return nil, err
+	}
+	if err := d.waitForDeployment(ctx, d.opts.greenName(), bg.Timeout); err != nil {
+		return nil, d.deleteGreen(ctx, "Green didn't become ready, deleting it; blue keeps serving", err)
+	}
+	if len(bg.SmokeTests.Tests) > 0 {
+		if err := bg.SmokeTests.Validate(); err != nil {
+			return nil, err
+		}
+		err := d.smokeTestPods(ctx, bg.SmokeTests.withDefaults(), func(ctx context.Context) ([]corev1.Pod, error) {
+			return d.selectedPods(ctx, green)
+		})
+		if err != nil {
+			return nil, d.deleteGreen(ctx, "Green failed the smoke tests, deleting it; blue keeps serving", err)
+		}
+	}
+
+	if _, err := d.deploy(ctx, d.isAPIService, func(svc *unstructured.Unstructured) {
+		_ = unstructured.SetNestedStringMap(svc.Object, d.opts.greenSelector(), "spec", "selector")
+	}); err != nil {
+		return nil, err
+	}
+	d.log.Info("Cut over to green", "namespace", d.opts.Namespace, "deployment", d.opts.greenName(), "keepOldFor", bg.KeepOldFor.String())
+	if bg.KeepOldFor > 0 {
+		sleepCtx(ctx, bg.KeepOldFor)
+		if err := ctx.Err(); err != nil {
+			return nil, fmt.Errorf("interrupted with the services on %s; deploy again to finish, or roll back: %w", d.opts.greenName(), err)
+		}
+	}
+	onGreen, err := d.servesGreen(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !onGreen {
+		return nil, errBlueGreenRolledBack
+	}
+
+	if _, err := d.deploy(ctx, d.isAPIDeployment, nil); err != nil {
+		return nil, err
+	}
+	if err := d.WaitForRollout(ctx, bg.Timeout); err != nil {
+		return nil, err
+	}
+	applied, err := d.DeployAll(ctx)
+	if err != nil {
+		return applied, err
+	}
+	if _, err := d.delete(ctx, d.resource(DeploymentResource), DeploymentResource, d.opts.greenName(), DeleteOptions{}); err != nil {
+		return applied, err
+	}
+	return applied, nil
+}
+
+// deployDirectly deploys a release that has no API deployment yet, and
+// smoke tests it once rolled out when there are tests.
+func (d *Deployer) deployDirectly(ctx context.Context, bg BlueGreenOptions) ([]*unstructured.Unstructured, error) {
+	applied, err := d.DeployAll(ctx)
+	if err != nil || len(bg.SmokeTests.Tests) == 0 {
+		return applied, err
+	}
+	if err := d.WaitForRollout(ctx, bg.Timeout); err != nil {
+		return applied, err
+	}
+	return applied, d.SmokeTest(ctx, bg.SmokeTests)
+}
+
+// deleteGreen deletes the green deployment after logging why, and returns
+// cause, the failure of green. Blue serves on either way; a failure to
+// delete green is added to cause, which it still unwraps to, so the
+// leftover deployment isn't missed.
+func (d *Deployer) deleteGreen(ctx context.Context, why string, cause error) error {
+	d.log.Info(why, "namespace", d.opts.Namespace, "deployment", d.opts.greenName())
+	if _, err := d.delete(ctx, d.resource(DeploymentResource), DeploymentResource, d.opts.greenName(), DeleteOptions{}); err != nil {
+		return fmt.Errorf("%w; blue keeps serving, but %s is left behind: %v", cause, d.opts.greenName(), err)
+	}
+	return cause
+}
+
+// servesGreen reports whether the service in front of the API selects the
+// green pods.
+func (d *Deployer) servesGreen(ctx context.Context) (bool, error) {
+	name := d.opts.serviceName()
+	svc, err := d.resource(ServiceResource).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return false, d.opError(OpGet, ServiceResource, name, err)
+	}
+	selector, _, _ := unstructured.NestedStringMap(svc.Object, "spec", "selector")
+	return selector[TrackLabel] == TrackGreen, nil
+}
+
+// RollbackBlueGreen ends a blue-green deploy before it finished: the
+// services switch back to blue and green is deleted. It reports whether a
+// green deployment existed; without one there is nothing to roll back.
+func (d *Deployer) RollbackBlueGreen(ctx context.Context) (bool, error) {
+	name := d.opts.greenName()
+	_, err := d.resource(DeploymentResource).Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, d.opError(OpGet, DeploymentResource, name, err)
+	}
+	if _, err := d.deploy(ctx, d.isAPIService, nil); err != nil {
+		return true, err
+	}
+	if _, err := d.delete(ctx, d.resource(DeploymentResource), DeploymentResource, name, DeleteOptions{}); err != nil {
+		return true, err
+	}
+	return true, nil
+}
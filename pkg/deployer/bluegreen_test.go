@@ -0,0 +1,90 @@
+package deployer
+
+import (
+	"context"
+	"errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	clienttesting "k8s.io/client-go/testing"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDeployBlueGreenGreenNeverReady(t *testing.T) {
+	tests := []struct {
+		name        string
+		deleteErr   error
+		wantDeleted bool
+	}{
+		{name: "green deleted", wantDeleted: true},
+		{name: "green left behind", deleteErr: apierrors.NewForbidden(DeploymentResource.GroupResource(), "apiserver-green", errors.New("RBAC denied"))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, client := newFakeDeployer(testOptions(), shopNamespace())
+			if _, err := d.DeployAll(context.Background()); err != nil {
+				t.Fatalf("DeployAll: %v", err)
+			}
+			blue := liveObject(t, client, DeploymentResource, "shop", "apiserver")
+			selector := serviceSelector(t, client)
+			client.ClearActions()
+			if tt.deleteErr != nil {
+				client.PrependReactor("delete", "deployments", func(clienttesting.Action) (bool, runtime.Object, error) {
+					return true, nil, tt.deleteErr
+				})
+			}
+
+			// The fake deployment never reports ready replicas.
+			_, err := d.DeployBlueGreen(context.Background(), BlueGreenOptions{Timeout: 10 * time.Millisecond, KeepOldFor: -1})
+			var timeout *RolloutTimeoutError
+			if !errors.As(err, &timeout) {
+				t.Fatalf("got %T %v, want a *RolloutTimeoutError", err, err)
+			}
+			if timeout.Name != "apiserver-green" {
+				t.Errorf("got a timeout for %s, want apiserver-green", timeout.Name)
+			}
+
+			got := writes(client)
+			if !hasPrefix(got, "patch deployments shop/apiserver-green") {
+				t.Errorf("green wasn't applied: %q", got)
+			}
+			if !hasPrefix(got, "delete deployments shop/apiserver-green") {
+				t.Errorf("green wasn't deleted: %q", got)
+			}
+			if hasPrefix(got, "patch deployments shop/apiserver ") || hasPrefix(got, "patch services") {
+				t.Errorf("blue or its services changed: %q", got)
+			}
+			if rv := liveObject(t, client, DeploymentResource, "shop", "apiserver").GetResourceVersion(); rv != blue.GetResourceVersion() {
+				t.Errorf("blue went from resourceVersion %s to %s", blue.GetResourceVersion(), rv)
+			}
+			if got := serviceSelector(t, client); got[TrackLabel] == TrackGreen || len(got) != len(selector) {
+				t.Errorf("the service selects %v, want blue's %v", got, selector)
+			}
+
+			_, getErr := client.Tracker().Get(DeploymentResource, "shop", "apiserver-green")
+			if tt.wantDeleted {
+				if !apierrors.IsNotFound(getErr) {
+					t.Errorf("green still exists: %v", getErr)
+				}
+				return
+			}
+			if getErr != nil {
+				t.Errorf("get green: %v", getErr)
+			}
+			if !strings.Contains(err.Error(), "apiserver-green is left behind") || !strings.Contains(err.Error(), "RBAC denied") {
+				t.Errorf("got %v, want the failure to delete green", err)
+			}
+		})
+	}
+}
+
+// serviceSelector returns the selector of the live API service.
+func serviceSelector(t *testing.T, client *dynamicfake.FakeDynamicClient) map[string]string {
+	t.Helper()
+	svc := liveObject(t, client, ServiceResource, "shop", "server-svc")
+	selector, _, _ := unstructured.NestedStringMap(svc.Object, "spec", "selector")
+	return selector
+}
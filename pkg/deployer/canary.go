@@ -0,0 +1,308 @@
+package deployer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	appsv1 "k8s.io/api/apps/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"math"
+	"time"
+)
+
+const (
+	// TrackLabel tells the pods of the canary from the stable ones.
+	TrackLabel = "track"
+	// TrackCanary and TrackStable are the values of TrackLabel.
+	TrackCanary = "canary"
+	TrackStable = "stable"
+
+	// componentCanary is the ComponentLabel of canary pods behind their own
+	// service, which it keeps out of the stable selector.
+	componentCanary = "api-canary"
+
+	// The annotations making ingress-nginx send a share of the traffic of
+	// an ingress for the same hosts to the canary ingress.
+	nginxCanaryAnnotation       = "nginx.ingress.kubernetes.io/canary"
+	nginxCanaryWeightAnnotation = "nginx.ingress.kubernetes.io/canary-weight"
+	// nginxController is the spec.controller of ingress-nginx IngressClasses.
+	nginxController = "k8s.io/ingress-nginx"
+)
+
+// errCanaryManifests is returned for canaries of Options.Manifests, whose
+// deployment and ingress the deployer can't derive a canary from.
+var errCanaryManifests = errors.New("canaries are only supported for the built-in objects, not -manifests")
+
+// The names of the canary objects.
+func (o Options) canaryName() string {
+	return o.objectName("canary", "apiserver-canary")
+}
+
+func (o Options) canaryServiceName() string {
+	return o.objectName("canary-svc", "server-canary-svc")
+}
+
+func (o Options) canaryIngressName() string {
+	return o.objectName("canary-ingress", "server-canary-ingress")
+}
+
+// CanaryResult describes a canary deployed by DeployCanary.
+type CanaryResult struct {
+	// Ingress reports whether ingress-nginx splits the traffic by weight,
+	// through a canary service and ingress. Otherwise the shared service
+	// spreads it over the stable and canary pods by their replica ratio.
+	Ingress bool
+	// Replicas and StableReplicas are the replica counts of both tracks.
+	Replicas       int32
+	StableReplicas int32
+	// Applied are the canary objects.
+	Applied []*unstructured.Unstructured
+}
+
+// DeployCanary deploys Options.Image as a canary next to the live API
+// deployment, which is left untouched, sending it about weight percent of
+// the traffic. When the ingress is served by ingress-nginx, the canary gets
+// its own service and an ingress with the nginx canary annotations;
+// otherwise its pods join those behind the shared service, and the replica
+// count sets the split. Redeploying adjusts the canary in place; switching
+// between the two modes needs AbortCanary first, as the selector of a
+// deployment can't change.
+func (d *Deployer) DeployCanary(ctx context.Context, weight int32) (*CanaryResult, error) {
+	if weight < 1 || weight > 99 {
+		return nil, &ValidationError{Field: "canary-weight", Err: fmt.Errorf("must be between 1 and 99, got %d", weight)}
+	}
+	if len(d.opts.Manifests) > 0 {
+		return nil, &ValidationError{Field: "canary", Err: errCanaryManifests}
+	}
+	stable, err := d.liveAPIDeployment(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("the stable track must be deployed before a canary: %w", err)
+	}
+	stableReplicas, found, _ := unstructured.NestedInt64(stable.Object, "spec", "replicas")
+	if !found {
+		stableReplicas = 1
+	}
+	ingress, err := d.canaryIngress(ctx, weight)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &CanaryResult{Ingress: ingress != nil, StableReplicas: int32(stableReplicas)}
+	labels := d.opts.canarySelector(result.Ingress)
+	var objects []object
+	if result.Ingress {
+		// ingress-nginx picks pods by weight, so the canary only needs its
+		// share of the capacity.
+		result.Replicas = int32(math.Ceil(float64(stableReplicas) * float64(weight) / 100))
+	} else {
+		result.Replicas = int32(math.Round(float64(stableReplicas) * float64(weight) / float64(100-weight)))
+	}
+	if result.Replicas < 1 {
+		result.Replicas = 1
+	}
+	objects = append(objects, object{gvr: DeploymentResource, obj: newTrackDeployment(d.opts, d.opts.canaryName(), result.Replicas, labels)})
+	if result.Ingress {
+		objects = append(objects, object{gvr: ServiceResource, obj: newCanaryService(d.opts, labels)}, object{gvr: IngressResource, obj: ingress})
+	}
+	for _, o := range objects {
+		o.obj.SetLabels(merge(o.obj.GetLabels(), map[string]string{TrackLabel: TrackCanary}))
+		obj, err := d.apply(ctx, o.gvr, o.obj)
+		if err != nil {
+			return result, err
+		}
+		result.Applied = append(result.Applied, obj)
+	}
+	return result, nil
+}
+
+// canarySelector returns the labels of the canary pods. Behind the shared
+// service they carry the stable selector, so it sends them their share; with
+// a service of their own they must not, or it would too.
+func (o Options) canarySelector(ownService bool) map[string]string {
+	if ownService {
+		return map[string]string{NameLabel: appName, InstanceLabel: o.release(), ComponentLabel: componentCanary, TrackLabel: TrackCanary}
+	}
+	return merge(o.selector(componentAPI), map[string]string{TrackLabel: TrackCanary})
+}
+
+// newTrackDeployment returns the API deployment under name, with replicas
+// pods of labels, for the canary and green tracks.
+func newTrackDeployment(opts Options, name string, replicas int32, labels map[string]string) *unstructured.Unstructured {
+	var dep appsv1.Deployment
+	decodeDefaultManifest("deployment.yaml", &dep)
+	customizeDeployment(&dep, opts)
+	dep.Name = name
+	dep.Spec.Replicas = &replicas
+	dep.Spec.Selector = &metav1.LabelSelector{MatchLabels: labels}
+	dep.Spec.Template.Labels = labels
+	return labelObjects([]*unstructured.Unstructured{mustToUnstructured(&dep)}, opts)[0]
+}
+
+// newCanaryService returns a ClusterIP service in front of the canary pods.
+func newCanaryService(opts Options, labels map[string]string) *unstructured.Unstructured {
+	obj := defaultManifest("service.yaml")
+	obj.SetName(opts.canaryServiceName())
+	obj.SetNamespace(opts.Namespace)
+	_ = unstructured.SetNestedStringMap(obj.Object, labels, "spec", "selector")
+	_ = unstructured.SetNestedField(obj.Object, "ClusterIP", "spec", "type")
+	setServicePorts(obj, opts)
+	setTrafficPolicy(obj, opts)
+	return labelObjects([]*unstructured.Unstructured{obj}, opts)[0]
+}
+
+// canaryIngress returns the canary ingress sending weight percent of the
+// traffic to the canary service, or nil unless the release routes through
+// an ingress, the cluster serves networking.k8s.io/v1 Ingress and the
+// ingress class of the release belongs to ingress-nginx.
+func (d *Deployer) canaryIngress(ctx context.Context, weight int32) (*unstructured.Unstructured, error) {
+	if d.opts.routing() != RoutingIngress {
+		return nil, nil
+	}
+	if version, err := d.servedIngressVersion(); err != nil || version != "v1" {
+		return nil, err
+	}
+	obj, err := d.desiredIngress(ctx, labelObjects([]*unstructured.Unstructured{newIngress(d.opts)}, d.opts)[0])
+	if err != nil {
+		return nil, err
+	}
+	class, _, _ := unstructured.NestedString(obj.Object, "spec", "ingressClassName")
+	if class == "" {
+		return nil, nil
+	}
+	gvr := schema.GroupVersionResource{Group: ingressGroupKind.Group, Version: "v1", Resource: "ingressclasses"}
+	ic, err := d.client.Resource(gvr).Get(ctx, class, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) || apierrors.IsForbidden(err) {
+		d.log.Info("Can't tell the controller of the IngressClass, splitting the traffic by replicas", "class", class, "reason", err.Error())
+		return nil, nil
+	}
+	if err != nil {
+		return nil, d.opError(OpGet, gvr, class, err)
+	}
+	if controller, _, _ := unstructured.NestedString(ic.Object, "spec", "controller"); controller != nginxController {
+		return nil, nil
+	}
+
+	obj = obj.DeepCopy()
+	obj.SetName(d.opts.canaryIngressName())
+	// The stable ingress has cert-manager fill the shared TLS secret.
+	annotations := obj.GetAnnotations()
+	delete(annotations, clusterIssuerAnnotation)
+	obj.SetAnnotations(merge(annotations, map[string]string{
+		nginxCanaryAnnotation:       "true",
+		nginxCanaryWeightAnnotation: fmt.Sprint(weight),
+	}))
+	rules, _, _ := unstructured.NestedSlice(obj.Object, "spec", "rules")
+	for _, r := range rules {
+		paths, _, _ := unstructured.NestedSlice(r.(map[string]interface{}), "http", "paths")
+		for _, p := range paths {
+			_ = unstructured.SetNestedField(p.(map[string]interface{}), d.opts.canaryServiceName(), "backend", "service", "name")
+		}
+		_ = unstructured.SetNestedSlice(r.(map[string]interface{}), paths, "http", "paths")
+	}
+	_ = unstructured.SetNestedSlice(obj.Object, rules, "spec", "rules")
+	return obj, nil
+}
+
+// liveCanary returns the canary deployment, or nil when there is none.
+func (d *Deployer) liveCanary(ctx context.Context) (*unstructured.Unstructured, error) {
+	name := d.opts.canaryName()
+	dep, err := d.resource(DeploymentResource).Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, d.opError(OpGet, DeploymentResource, name, err)
+	}
+	return dep, nil
+}
+
+// WaitForCanary polls the canary deployment until it is rolled out, or
+// returns a *RolloutTimeoutError after timeout, or a *RolloutFailedError
+// once it exceeds its progress deadline.
+func (d *Deployer) WaitForCanary(ctx context.Context, timeout time.Duration) error {
+	return d.waitForDeployment(ctx, d.opts.canaryName(), timeout)
+}
+
+// waitForDeployment polls the deployment called name as WaitForCanary does.
+func (d *Deployer) waitForDeployment(ctx context.Context, name string, timeout time.Duration) error {
+	d.log.Info("Waiting for rollout", "namespace", d.opts.Namespace, "deployment", name, "timeout", timeout.String())
+	var status RolloutStatus
+	err := wait.PollImmediateWithContext(ctx, rolloutPollInterval, timeout, func(ctx context.Context) (bool, error) {
+		dep, err := d.resource(DeploymentResource).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return false, d.opError(OpGet, DeploymentResource, name, err)
+		}
+		var done bool
+		status, done = rolloutProgress(dep)
+		if status.progressDeadlineExceeded() {
+			return false, errRolloutStalled
+		}
+		return done, nil
+	})
+	switch {
+	case errors.Is(err, errRolloutStalled):
+		return &RolloutFailedError{Name: name, Reason: "ProgressDeadlineExceeded"}
+	case errors.Is(err, wait.ErrWaitTimeout):
+		return &RolloutTimeoutError{Name: name, Status: status}
+	}
+	return err
+}
+
+// errNoCanary is returned by Promote when no canary is deployed.
+var errNoCanary = errors.New("no canary is deployed")
+
+// Promote rolls the stable track to the image of the canary, applying every
+// object with Options.Image replaced by it, and returns the applied objects
+// and the image. The canary keeps serving until AbortCanary removes it, so
+// callers wait for the stable rollout in between.
+func (d *Deployer) Promote(ctx context.Context) ([]*unstructured.Unstructured, string, error) {
+	canary, err := d.liveCanary(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	if canary == nil {
+		return nil, "", d.opError(OpGet, DeploymentResource, d.opts.canaryName(), errNoCanary)
+	}
+	var dep appsv1.Deployment
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(canary.Object, &dep); err != nil {
+		return nil, "", fmt.Errorf("failed to decode deployment %s: %w", canary.GetName(), err)
+	}
+	c := findAPIContainer(&dep.Spec.Template.Spec)
+	if c == nil {
+		return nil, "", fmt.Errorf("deployment %s has no %s container to promote the image of", canary.GetName(), apiContainerName)
+	}
+	image := c.Image
+	d.log.Info("Promoting the canary", "namespace", d.opts.Namespace, "image", image)
+	stable := *d
+	stable.opts.Image = image
+	applied, err := stable.DeployAll(ctx)
+	return applied, image, err
+}
+
+// AbortCanary deletes the canary ingress, service and deployment, reporting
+// them as DeleteAll does; those that don't exist are NotFound.
+func (d *Deployer) AbortCanary(ctx context.Context) []DeleteResult {
+	canary := []struct {
+		gvr  schema.GroupVersionResource
+		kind string
+		name string
+	}{
+		{IngressResource, "Ingress", d.opts.canaryIngressName()},
+		{ServiceResource, "Service", d.opts.canaryServiceName()},
+		{DeploymentResource, "Deployment", d.opts.canaryName()},
+	}
+	var results []DeleteResult
+	for _, c := range canary {
+		result := DeleteResult{Resource: c.gvr, Kind: c.kind, Name: c.name}
+		start := time.Now()
+		result.Outcome, result.Err = d.delete(ctx, d.resource(c.gvr), c.gvr, c.name, DeleteOptions{})
+		result.Duration = time.Since(start)
+		results = append(results, result)
+	}
+	return results
+}
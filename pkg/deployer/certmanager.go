@@ -0,0 +1,92 @@
+package deployer
+
+import (
+	"context"
+	"fmt"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"time"
+)
+
+// clusterIssuerAnnotation asks cert-manager's ingress-shim to issue a
+// certificate for the ingress's TLS hosts from the named ClusterIssuer.
+const clusterIssuerAnnotation = "cert-manager.io/cluster-issuer"
+
+// certificateGroupKind is the Certificate kind cert-manager creates for
+// annotated ingresses.
+var certificateGroupKind = schema.GroupKind{Group: "cert-manager.io", Kind: "Certificate"}
+
+// checkCertManager fails when Options.CertManagerIssuer is set but the cluster
+// doesn't serve the cert-manager.io API, since the annotation would then be
+// silently ignored.
+func (d *Deployer) checkCertManager() error {
+	if d.opts.CertManagerIssuer == "" {
+		return nil
+	}
+	_, err := d.mapper.RESTMapping(certificateGroupKind)
+	if meta.IsNoMatchError(err) {
+		return &ValidationError{Field: "cert-manager-issuer", Err: fmt.Errorf(
+			"the cluster doesn't serve the cert-manager.io API; install cert-manager (https://cert-manager.io/docs/installation/) or pass -tls-cert and -tls-key instead")}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to discover the cert-manager.io API: %w", err)
+	}
+	return nil
+}
+
+// WaitForCertificate polls the Certificate cert-manager creates for the
+// ingress until its Ready condition is True. It returns nil right away when
+// Options.CertManagerIssuer is empty.
+func (d *Deployer) WaitForCertificate(ctx context.Context, timeout time.Duration) error {
+	if d.opts.CertManagerIssuer == "" {
+		return nil
+	}
+	mapping, err := d.mapper.RESTMapping(certificateGroupKind)
+	if err != nil {
+		return fmt.Errorf("failed to discover the cert-manager.io API: %w", err)
+	}
+	// ingress-shim names the Certificate after the secret it fills.
+	name := d.opts.tlsSecretName()
+	d.log.Info("Waiting for certificate", "namespace", d.opts.Namespace, "certificate", name, "timeout", timeout.String())
+
+	waitCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	var reason string
+	err = wait.PollImmediateUntilWithContext(waitCtx, rolloutPollInterval, func(ctx context.Context) (bool, error) {
+		cert, err := d.resource(mapping.Resource).Get(ctx, name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			reason = "certificate not created yet"
+			return false, nil
+		}
+		if err != nil {
+			return false, d.opError(OpGet, mapping.Resource, name, err)
+		}
+		var ready bool
+		ready, reason = certificateReady(cert)
+		d.log.V(1).Info("Certificate progress", "namespace", d.opts.Namespace, "certificate", name, "ready", ready, "reason", reason)
+		return ready, nil
+	})
+	if err != nil {
+		return d.opError(OpWait, mapping.Resource, name, fmt.Errorf("ready condition (%s): %w", reason, err))
+	}
+	return nil
+}
+
+// certificateReady reports whether cert's Ready condition is True, along with
+// the condition's message.
+func certificateReady(cert *unstructured.Unstructured) (bool, string) {
+	conditions, _, _ := unstructured.NestedSlice(cert.Object, "status", "conditions")
+	for _, c := range conditions {
+		m, ok := c.(map[string]interface{})
+		if !ok || m["type"] != "Ready" {
+			continue
+		}
+		message, _, _ := unstructured.NestedString(m, "message")
+		return m["status"] == "True", message
+	}
+	return false, "no Ready condition yet"
+}
@@ -0,0 +1,196 @@
+package deployer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"reflect"
+	"sigs.k8s.io/yaml"
+	"strings"
+)
+
+// Placeholders Chart marshals in place of the values it makes configurable,
+// then replaces with template actions.
+const (
+	chartNamespace   = "__CHART_NAMESPACE__"
+	chartImage       = "__CHART_IMAGE__"
+	chartReplicas    = "__CHART_REPLICAS__"
+	chartServiceType = "__CHART_SERVICE_TYPE__"
+	chartRules       = "__CHART_RULES__"
+	chartTLSHosts    = "__CHART_TLS_HOSTS__"
+)
+
+// Chart returns the files of a Helm chart, by path in the chart directory,
+// that renders the built-in objects. values.yaml exposes the image, the
+// replicas, the service type and the ingress hosts and paths, set to opts,
+// so rendering with the default values yields the objects Render returns,
+// encoded byte for byte as export encodes them; the objects go to the
+// release namespace. Everything else, such as the optional config map,
+// secrets and HPA, is fixed by opts as it is for Render.
+func Chart(opts Options) (map[string][]byte, error) {
+	if len(opts.Manifests) > 0 {
+		return nil, &ValidationError{Field: "manifests", Err: errors.New("a chart is only generated from the built-in objects")}
+	}
+	if len(opts.ExtraManifests) > 0 {
+		return nil, &ValidationError{Field: "extra-manifests", Err: errors.New("a chart is only generated from the built-in objects; add the extra manifests to its templates")}
+	}
+	if len(opts.Patches) > 0 {
+		// values.yaml would hold the values from before the patches.
+		return nil, &ValidationError{Field: "overlay", Err: errors.New("a chart is generated without overlays; patch the chart instead")}
+	}
+	objects, err := Render(opts)
+	if err != nil {
+		return nil, err
+	}
+	opts = opts.withDefaults()
+
+	files := map[string][]byte{}
+	for _, obj := range objects {
+		if obj.GetKind() == "Namespace" {
+			// helm install --create-namespace does this.
+			continue
+		}
+		data, err := chartTemplate(opts, obj)
+		if err != nil {
+			return nil, err
+		}
+		files["templates/"+strings.ToLower(obj.GetKind()+"-"+obj.GetName())+".yaml"] = data
+	}
+
+	paths := make([]map[string]string, 0, len(opts.Paths))
+	for _, p := range opts.Paths {
+		paths = append(paths, map[string]string{"path": p.Path, "pathType": p.PathType})
+	}
+	values, err := yaml.Marshal(map[string]interface{}{
+		"image":    opts.Image,
+		"replicas": *opts.Replicas,
+		"service":  map[string]interface{}{"type": opts.ServiceType},
+		"ingress":  map[string]interface{}{"hosts": opts.Hosts, "paths": paths},
+	})
+	if err != nil {
+		return nil, err
+	}
+	files["values.yaml"] = values
+
+	appVersion := ""
+	if ref, err := ParseImageReference(opts.Image); err == nil {
+		appVersion = ref.Tag
+	}
+	chart, err := yaml.Marshal(map[string]interface{}{
+		"apiVersion":  "v2",
+		"name":        opts.release(),
+		"description": "The ecommerce API, generated by " + ManagedBy + ".",
+		"type":        "application",
+		"version":     "0.1.0",
+		"appVersion":  appVersion,
+	})
+	if err != nil {
+		return nil, err
+	}
+	files["Chart.yaml"] = chart
+	return files, nil
+}
+
+// chartTemplate returns the template rendering obj.
+func chartTemplate(opts Options, obj *unstructured.Unstructured) ([]byte, error) {
+	obj = obj.DeepCopy()
+	if obj.GetNamespace() != "" {
+		obj.SetNamespace(chartNamespace)
+	}
+	switch {
+	case obj.GetKind() == "Deployment" && obj.GetName() == opts.deploymentName():
+		if _, found, _ := unstructured.NestedFieldNoCopy(obj.Object, "spec", "replicas"); found {
+			_ = unstructured.SetNestedField(obj.Object, chartReplicas, "spec", "replicas")
+		}
+		containers, _, _ := unstructured.NestedSlice(obj.Object, "spec", "template", "spec", "containers")
+		for _, c := range containers {
+			if c := c.(map[string]interface{}); c["name"] == apiContainerName {
+				c["image"] = chartImage
+			}
+		}
+		_ = unstructured.SetNestedSlice(obj.Object, containers, "spec", "template", "spec", "containers")
+	case obj.GetKind() == "Service" && obj.GetName() == opts.serviceName():
+		_ = unstructured.SetNestedField(obj.Object, chartServiceType, "spec", "type")
+	}
+
+	var backend []byte
+	if obj.GetKind() == "Ingress" {
+		rules, _, _ := unstructured.NestedSlice(obj.Object, "spec", "rules")
+		if len(rules) > 0 {
+			paths, _, _ := unstructured.NestedSlice(rules[0].(map[string]interface{}), "http", "paths")
+			if len(paths) > 0 {
+				var err error
+				if backend, err = yaml.Marshal(paths[0].(map[string]interface{})["backend"]); err != nil {
+					return nil, err
+				}
+				_ = unstructured.SetNestedField(obj.Object, chartRules, "spec", "rules")
+			}
+		}
+		tls, _, _ := unstructured.NestedSlice(obj.Object, "spec", "tls")
+		for _, t := range tls {
+			t := t.(map[string]interface{})
+			if hosts, _, _ := unstructured.NestedStringSlice(t, "hosts"); reflect.DeepEqual(hosts, opts.Hosts) {
+				t["hosts"] = chartTLSHosts
+			}
+		}
+		if len(tls) > 0 {
+			_ = unstructured.SetNestedSlice(obj.Object, tls, "spec", "tls")
+		}
+	}
+
+	data, err := yaml.Marshal(obj.Object)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode %s %s: %w", obj.GetKind(), obj.GetName(), err)
+	}
+	// Anything that reads as a template action is part of a value.
+	data = bytes.ReplaceAll(data, []byte("{{"), []byte(`{{"{{"}}`))
+
+	var b bytes.Buffer
+	for _, line := range strings.SplitAfter(string(data), "\n") {
+		// Items of a list under the key go to the column of the key.
+		key := strings.TrimLeft(line, " ")
+		col := len(line) - len(key)
+		if strings.HasPrefix(key, "- ") {
+			key, col = key[2:], col+2
+		}
+		prefix, indent := line[:col], strings.Repeat(" ", col)
+		switch strings.TrimSpace(key) {
+		case "rules: " + chartRules:
+			writeChartRules(&b, prefix, indent, backend)
+		case "hosts: " + chartTLSHosts:
+			b.WriteString(prefix + "hosts:\n")
+			b.WriteString(indent + "{{- range .Values.ingress.hosts }}\n")
+			b.WriteString(indent + "- {{ . | toYaml }}\n")
+			b.WriteString(indent + "{{- end }}\n")
+		default:
+			b.WriteString(line)
+		}
+	}
+	out := strings.NewReplacer(
+		chartNamespace, "{{ .Release.Namespace }}",
+		chartImage, "{{ .Values.image | toYaml }}",
+		chartReplicas, "{{ .Values.replicas }}",
+		chartServiceType, "{{ .Values.service.type | toYaml }}",
+	).Replace(b.String())
+	return []byte(out), nil
+}
+
+// writeChartRules writes the ingress rules as a range over the hosts and
+// paths of the values, each path with backend.
+func writeChartRules(b *bytes.Buffer, prefix, indent string, backend []byte) {
+	b.WriteString(prefix + "rules:\n")
+	b.WriteString(indent + "{{- range .Values.ingress.hosts }}\n")
+	b.WriteString(indent + "- host: {{ . | toYaml }}\n")
+	b.WriteString(indent + "  http:\n")
+	b.WriteString(indent + "    paths:\n")
+	b.WriteString(indent + "    {{- range $.Values.ingress.paths }}\n")
+	b.WriteString(indent + "    - backend:\n")
+	for _, line := range strings.SplitAfter(strings.TrimRight(string(backend), "\n"), "\n") {
+		b.WriteString(indent + "        " + strings.TrimRight(line, "\n") + "\n")
+	}
+	b.WriteString(indent + "      path: {{ .path | toYaml }}\n")
+	b.WriteString(indent + "      pathType: {{ .pathType | toYaml }}\n")
+	b.WriteString(indent + "    {{- end }}\n")
+	b.WriteString(indent + "{{- end }}\n")
+}
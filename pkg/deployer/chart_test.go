@@ -0,0 +1,128 @@
+package deployer
+
+import (
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/engine"
+	"sigs.k8s.io/yaml"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// renderChart renders the files of Chart with the Helm engine, installed to
+// namespace with values over the defaults, and returns the templates by
+// their path in the chart.
+func renderChart(t *testing.T, files map[string][]byte, namespace string, values map[string]interface{}) map[string]string {
+	t.Helper()
+	var buffered []*loader.BufferedFile
+	for name, data := range files {
+		buffered = append(buffered, &loader.BufferedFile{Name: name, Data: data})
+	}
+	chart, err := loader.LoadFiles(buffered)
+	if err != nil {
+		t.Fatalf("load the chart: %v", err)
+	}
+	renderValues, err := chartutil.ToRenderValues(chart, values, chartutil.ReleaseOptions{Name: chart.Name(), Namespace: namespace, IsInstall: true}, nil)
+	if err != nil {
+		t.Fatalf("values: %v", err)
+	}
+	rendered, err := engine.Render(chart, renderValues)
+	if err != nil {
+		t.Fatalf("render the chart: %v", err)
+	}
+	templates := make(map[string]string, len(rendered))
+	for name, data := range rendered {
+		templates[strings.TrimPrefix(name, chart.Name()+"/")] = data
+	}
+	return templates
+}
+
+func TestChartRendersObjects(t *testing.T) {
+	withHosts := func(o Options, hosts ...string) Options {
+		o.Hosts = hosts
+		return o
+	}
+	withReplicas := func(o Options, n int32) Options {
+		o.Replicas = &n
+		return o
+	}
+	full := Options{
+		Namespace:     "shop",
+		Release:       "shop",
+		Image:         "ghcr.io/acme/ecommerce-api:v2.0",
+		Hosts:         []string{"shop.example.com", "*.shop.example.com"},
+		Paths:         []IngressPath{{Path: "/api", PathType: "Prefix"}, {Path: "/healthz", PathType: "Exact"}},
+		IngressClass:  "nginx",
+		TLSSecret:     "shop-tls",
+		Config:        map[string]string{"LOG_LEVEL": "debug", "GREETING": "{{ hello }}"},
+		DBCredentials: map[string]string{"DB_PASSWORD": "hunter2"},
+		Autoscale:     &Autoscale{Min: 2, Max: 5, CPU: 80},
+	}
+	tests := []struct {
+		name   string
+		opts   Options
+		values map[string]interface{}
+		// want are the options Render is compared with; opts when nil.
+		want *Options
+	}{
+		{name: "default", opts: Options{}},
+		{name: "nodeport", opts: Options{ServiceType: "NodePort", ExposeNodePort: true}},
+		{name: "hosts, paths, TLS, config, secret and HPA", opts: full},
+		{
+			name:   "values over the defaults",
+			opts:   withReplicas(full, 3),
+			values: map[string]interface{}{"replicas": 5, "ingress": map[string]interface{}{"hosts": []interface{}{"api.example.com", "www.example.com"}}},
+			want:   optionsPtr(withHosts(withReplicas(full, 5), "api.example.com", "www.example.com")),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files, err := Chart(tt.opts)
+			if err != nil {
+				t.Fatalf("Chart: %v", err)
+			}
+			want := tt.opts
+			if tt.want != nil {
+				want = *tt.want
+			}
+			objects, err := Render(want)
+			if err != nil {
+				t.Fatalf("Render: %v", err)
+			}
+			rendered := renderChart(t, files, want.withDefaults().Namespace, tt.values)
+
+			var names []string
+			for _, obj := range objects {
+				if obj.GetKind() == "Namespace" {
+					continue
+				}
+				name := "templates/" + strings.ToLower(obj.GetKind()+"-"+obj.GetName()) + ".yaml"
+				names = append(names, name)
+				wantYAML, err := yaml.Marshal(obj.Object)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got, ok := rendered[name]
+				if !ok {
+					t.Errorf("the chart has no %s", name)
+					continue
+				}
+				if got != string(wantYAML) {
+					t.Errorf("%s renders\n%s\nwant\n%s", name, got, wantYAML)
+				}
+			}
+			var templates []string
+			for name := range rendered {
+				templates = append(templates, name)
+			}
+			sort.Strings(names)
+			sort.Strings(templates)
+			if strings.Join(names, " ") != strings.Join(templates, " ") {
+				t.Errorf("got templates %q, want one per object %q", templates, names)
+			}
+		})
+	}
+}
+
+func optionsPtr(o Options) *Options { return &o }
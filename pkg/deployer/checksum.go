@@ -0,0 +1,156 @@
+package deployer
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sort"
+)
+
+// secretChecksumAnnotation on the pod template changes with the database
+// credentials and every secret the pods read, so editing them rolls the
+// deployment, as configChecksumAnnotation does for the config maps.
+const secretChecksumAnnotation = "checksum/secret"
+
+// wireSecretChecksum stamps the checksum of Options.DBCredentials on the pod
+// template.
+func wireSecretChecksum(dep *appsv1.Deployment, opts Options) {
+	if len(opts.DBCredentials) == 0 {
+		return
+	}
+	tmpl := &dep.Spec.Template
+	if tmpl.Annotations == nil {
+		tmpl.Annotations = map[string]string{}
+	}
+	tmpl.Annotations[secretChecksumAnnotation] = dataChecksum(opts.DBCredentials)
+}
+
+// podReferences returns the names of the config maps and of the secrets the
+// pod template of the deployment obj reads, each sorted: those of its
+// volumes, projected ones included, of the env and envFrom of its
+// containers and init containers, and its imagePullSecrets.
+func podReferences(obj *unstructured.Unstructured) (configMaps, secrets []string) {
+	m, _, _ := unstructured.NestedMap(obj.Object, "spec", "template")
+	var tmpl corev1.PodTemplateSpec
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(m, &tmpl); err != nil {
+		return nil, nil
+	}
+	cms, secs := map[string]bool{}, map[string]bool{}
+	spec := tmpl.Spec
+	for _, v := range spec.Volumes {
+		if v.ConfigMap != nil {
+			cms[v.ConfigMap.Name] = true
+		}
+		if v.Secret != nil {
+			secs[v.Secret.SecretName] = true
+		}
+		if v.Projected == nil {
+			continue
+		}
+		for _, src := range v.Projected.Sources {
+			if src.ConfigMap != nil {
+				cms[src.ConfigMap.Name] = true
+			}
+			if src.Secret != nil {
+				secs[src.Secret.Name] = true
+			}
+		}
+	}
+	for _, c := range append(append([]corev1.Container{}, spec.InitContainers...), spec.Containers...) {
+		for _, from := range c.EnvFrom {
+			if from.ConfigMapRef != nil {
+				cms[from.ConfigMapRef.Name] = true
+			}
+			if from.SecretRef != nil {
+				secs[from.SecretRef.Name] = true
+			}
+		}
+		for _, e := range c.Env {
+			switch {
+			case e.ValueFrom == nil:
+			case e.ValueFrom.ConfigMapKeyRef != nil:
+				cms[e.ValueFrom.ConfigMapKeyRef.Name] = true
+			case e.ValueFrom.SecretKeyRef != nil:
+				secs[e.ValueFrom.SecretKeyRef.Name] = true
+			}
+		}
+	}
+	for _, ref := range spec.ImagePullSecrets {
+		secs[ref.Name] = true
+	}
+	return setNames(cms), setNames(secs)
+}
+
+// setNames returns the non-empty names of set, sorted.
+func setNames(set map[string]bool) []string {
+	var names []string
+	for name := range set {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// stampReferencedChecksums folds the live data of every config map and
+// secret the pod template of the deployment obj reads, as podReferences
+// finds them, into its checksum annotations, so rotating the database,
+// cache or registry credentials, or editing objects the deployer doesn't
+// manage, rolls the pods on the next deploy too. They are applied before
+// the deployment, so the data is that being deployed. Objects that are
+// missing count as empty; those that can't be read are left out.
+func (d *Deployer) stampReferencedChecksums(ctx context.Context, obj *unstructured.Unstructured) error {
+	path := []string{"spec", "template", "metadata", "annotations"}
+	annotations, _, _ := unstructured.NestedStringMap(obj.Object, path...)
+	configMaps, secrets := podReferences(obj)
+	changed := false
+	for _, ref := range []struct {
+		gvr        schema.GroupVersionResource
+		annotation string
+		names      []string
+	}{
+		{ConfigMapResource, configChecksumAnnotation, configMaps},
+		{SecretResource, secretChecksumAnnotation, secrets},
+	} {
+		names := ref.names
+		if len(names) == 0 {
+			continue
+		}
+		// Start from the checksum of the managed object, if any.
+		h := sha256.New()
+		fmt.Fprintf(h, "%s\n", annotations[ref.annotation])
+		for _, name := range names {
+			live, err := d.resource(ref.gvr).Get(ctx, name, metav1.GetOptions{})
+			switch {
+			case apierrors.IsNotFound(err):
+				fmt.Fprintf(h, "%s absent\n", name)
+				continue
+			case apierrors.IsForbidden(err):
+				d.log.V(1).Info("Can't read a referenced object, leaving it out of the checksum", "resource", ref.gvr.String(), "name", name)
+				continue
+			case err != nil:
+				return d.opError(OpGet, ref.gvr, name, err)
+			}
+			data, _, _ := unstructured.NestedStringMap(live.Object, "data")
+			fmt.Fprintf(h, "%s %s\n", name, dataChecksum(data))
+		}
+		if annotations == nil {
+			annotations = map[string]string{}
+		}
+		annotations[ref.annotation] = hex.EncodeToString(h.Sum(nil))
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	return unstructured.SetNestedStringMap(obj.Object, annotations, path...)
+}
@@ -0,0 +1,90 @@
+package deployer
+
+import (
+	"context"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"reflect"
+	"testing"
+)
+
+func TestPodReferences(t *testing.T) {
+	local := func(name string) corev1.LocalObjectReference { return corev1.LocalObjectReference{Name: name} }
+	dep := &appsv1.Deployment{}
+	dep.Spec.Template.Spec = corev1.PodSpec{
+		Volumes: []corev1.Volume{
+			{Name: "config", VolumeSource: corev1.VolumeSource{ConfigMap: &corev1.ConfigMapVolumeSource{LocalObjectReference: local("app-config")}}},
+			{Name: "tls", VolumeSource: corev1.VolumeSource{Secret: &corev1.SecretVolumeSource{SecretName: "tls"}}},
+			{Name: "bundle", VolumeSource: corev1.VolumeSource{Projected: &corev1.ProjectedVolumeSource{Sources: []corev1.VolumeProjection{
+				{ConfigMap: &corev1.ConfigMapProjection{LocalObjectReference: local("ca")}},
+				{Secret: &corev1.SecretProjection{LocalObjectReference: local("client-cert")}},
+			}}}},
+			{Name: "scratch", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}},
+		},
+		InitContainers: []corev1.Container{{
+			Name:    "migrate",
+			EnvFrom: []corev1.EnvFromSource{{SecretRef: &corev1.SecretEnvSource{LocalObjectReference: local("db-credentials")}}},
+		}},
+		Containers: []corev1.Container{{
+			Name: "api",
+			EnvFrom: []corev1.EnvFromSource{
+				{ConfigMapRef: &corev1.ConfigMapEnvSource{LocalObjectReference: local("app-config")}},
+				{SecretRef: &corev1.SecretEnvSource{LocalObjectReference: local("db-credentials")}},
+			},
+			Env: []corev1.EnvVar{
+				{Name: "DB_HOST", Value: "postgres"},
+				{Name: "DB_PASSWORD", ValueFrom: &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{LocalObjectReference: local("postgres"), Key: "POSTGRES_PASSWORD"}}},
+				{Name: "REGION", ValueFrom: &corev1.EnvVarSource{ConfigMapKeyRef: &corev1.ConfigMapKeySelector{LocalObjectReference: local("cluster-info"), Key: "region"}}},
+				{Name: "POD_NAME", ValueFrom: &corev1.EnvVarSource{FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.name"}}},
+			},
+		}},
+		ImagePullSecrets: []corev1.LocalObjectReference{local("regcred")},
+	}
+	m, err := runtime.DefaultUnstructuredConverter.ToUnstructured(dep)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	configMaps, secrets := podReferences(&unstructured.Unstructured{Object: m})
+	if want := []string{"app-config", "ca", "cluster-info"}; !reflect.DeepEqual(configMaps, want) {
+		t.Errorf("config maps: got %q, want %q", configMaps, want)
+	}
+	if want := []string{"client-cert", "db-credentials", "postgres", "regcred", "tls"}; !reflect.DeepEqual(secrets, want) {
+		t.Errorf("secrets: got %q, want %q", secrets, want)
+	}
+}
+
+func TestDeployAllRollsOnPullSecretChange(t *testing.T) {
+	regcred := unstructuredObject("v1", "Secret", "shop", "regcred")
+	_ = unstructured.SetNestedStringMap(regcred.Object, map[string]string{".dockerconfigjson": "e30="}, "data")
+	opts := testOptions()
+	opts.ImagePullSecrets = []string{"regcred"}
+	d, client := newFakeDeployer(opts, shopNamespace(), regcred)
+
+	checksum := func() string {
+		t.Helper()
+		if _, err := d.DeployAll(context.Background()); err != nil {
+			t.Fatalf("DeployAll: %v", err)
+		}
+		dep := liveObject(t, client, DeploymentResource, "shop", "apiserver")
+		sum, _, _ := unstructured.NestedString(dep.Object, "spec", "template", "metadata", "annotations", secretChecksumAnnotation)
+		return sum
+	}
+	first := checksum()
+	if first == "" {
+		t.Fatal("no secret checksum on the pod template")
+	}
+	if again := checksum(); again != first {
+		t.Errorf("the checksum changed from %s to %s with the secret unchanged", first, again)
+	}
+
+	_ = unstructured.SetNestedStringMap(regcred.Object, map[string]string{".dockerconfigjson": "eyJhdXRocyI6e319"}, "data")
+	if err := client.Tracker().Update(SecretResource, regcred, "shop"); err != nil {
+		t.Fatal(err)
+	}
+	if rotated := checksum(); rotated == first {
+		t.Error("rotating the pull secret left the checksum as it was")
+	}
+}
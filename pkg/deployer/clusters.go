@@ -0,0 +1,110 @@
+package deployer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrNotRun is the error of the clusters ClustersOptions.FailFast stopped,
+// before or during their run, once another failed.
+var ErrNotRun = errors.New("not run: another cluster failed")
+
+// Cluster is one of the clusters ForEachCluster and DeployClusters run
+// against, each through its own Deployer, e.g. of NewForConfig with the
+// rest.Config of a kubeconfig context.
+type Cluster struct {
+	// Name tells the cluster apart in the results, e.g. its context.
+	Name     string
+	Deployer *Deployer
+}
+
+// ClustersOptions configures ForEachCluster and DeployClusters.
+type ClustersOptions struct {
+	// Parallel runs against every cluster at once, rather than one after
+	// the other.
+	Parallel bool
+	// FailFast stops the run once a cluster fails: the clusters not
+	// started yet aren't, and the requests of those running are canceled.
+	FailFast bool
+}
+
+// ClusterResult is the outcome of DeployClusters on one cluster.
+type ClusterResult struct {
+	Cluster string `json:"cluster"`
+	// NotRun is set for the clusters ClustersOptions.FailFast stopped.
+	NotRun bool `json:"notRun,omitempty"`
+	// Result is what Deploy returned, nil for the clusters not started.
+	Result *Result `json:"result,omitempty"`
+	// Err is the failure on the cluster, nil when it succeeded.
+	Err error `json:"-"`
+}
+
+// ForEachCluster calls fn with the index of each of clusters, under opts,
+// and returns the error of each call, in the order of clusters. The calls
+// of the clusters FailFast stopped return an error wrapping ErrNotRun.
+func ForEachCluster(ctx context.Context, clusters []Cluster, opts ClustersOptions, fn func(ctx context.Context, i int) error) []error {
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	limit := make(chan struct{}, 1)
+	if opts.Parallel && len(clusters) > 1 {
+		limit = make(chan struct{}, len(clusters))
+	}
+	var (
+		mu     sync.Mutex
+		failed bool
+		wg     sync.WaitGroup
+	)
+	errs := make([]error, len(clusters))
+	for i := range clusters {
+		limit <- struct{}{}
+		mu.Lock()
+		stop := failed && opts.FailFast
+		mu.Unlock()
+		if stop {
+			errs[i] = ErrNotRun
+			<-limit
+			continue
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer func() { <-limit; wg.Done() }()
+			err := fn(runCtx, i)
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == nil:
+			case failed && opts.FailFast && runCtx.Err() != nil && ctx.Err() == nil:
+				// Canceled as another cluster failed first.
+				err = fmt.Errorf("%w: %v", ErrNotRun, err)
+			default:
+				failed = true
+				if opts.FailFast {
+					cancel()
+				}
+			}
+			errs[i] = err
+		}(i)
+	}
+	wg.Wait()
+	return errs
+}
+
+// DeployClusters deploys to each of clusters with Deploy and deploy, under
+// opts, and returns the outcome on each, in the order of clusters.
+// deploy.Progress, when set, is called from the goroutine of each cluster.
+func DeployClusters(ctx context.Context, clusters []Cluster, opts ClustersOptions, deploy DeployOptions) []ClusterResult {
+	results := make([]ClusterResult, len(clusters))
+	errs := ForEachCluster(ctx, clusters, opts, func(ctx context.Context, i int) error {
+		var err error
+		results[i].Result, err = clusters[i].Deployer.Deploy(ctx, deploy)
+		return err
+	})
+	for i, c := range clusters {
+		results[i].Cluster = c.Name
+		results[i].Err = errs[i]
+		results[i].NotRun = errors.Is(errs[i], ErrNotRun)
+	}
+	return results
+}
@@ -0,0 +1,78 @@
+package deployer
+
+import (
+	"context"
+	"errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	clienttesting "k8s.io/client-go/testing"
+	"testing"
+)
+
+func TestDeployClusters(t *testing.T) {
+	tests := []struct {
+		name       string
+		opts       ClustersOptions
+		wantFailed []bool
+		wantNotRun []bool
+	}{
+		{name: "one after the other", wantFailed: []bool{true, false, false}, wantNotRun: []bool{false, false, false}},
+		{name: "fail fast", opts: ClustersOptions{FailFast: true}, wantFailed: []bool{true, true, true}, wantNotRun: []bool{false, true, true}},
+		{name: "parallel", opts: ClustersOptions{Parallel: true}, wantFailed: []bool{true, false, false}, wantNotRun: []bool{false, false, false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				clusters []Cluster
+				clients  []*dynamicfake.FakeDynamicClient
+			)
+			for _, name := range []string{"east", "west", "central"} {
+				d, client := newFakeDeployer(testOptions(), shopNamespace())
+				clusters = append(clusters, Cluster{Name: name, Deployer: d})
+				clients = append(clients, client)
+			}
+			clients[0].PrependReactor("patch", "deployments", func(clienttesting.Action) (bool, runtime.Object, error) {
+				return true, nil, apierrors.NewForbidden(DeploymentResource.GroupResource(), "apiserver", errors.New("RBAC denied"))
+			})
+
+			results := DeployClusters(context.Background(), clusters, tt.opts, DeployOptions{})
+			for i, r := range results {
+				if r.Cluster != clusters[i].Name {
+					t.Errorf("result %d is of %s, want %s", i, r.Cluster, clusters[i].Name)
+				}
+				if failed := r.Err != nil; failed != tt.wantFailed[i] {
+					t.Errorf("%s: got error %v, want failed %t", r.Cluster, r.Err, tt.wantFailed[i])
+				}
+				if r.NotRun != tt.wantNotRun[i] {
+					t.Errorf("%s: got notRun %t, want %t", r.Cluster, r.NotRun, tt.wantNotRun[i])
+				}
+				switch {
+				case r.NotRun:
+					if r.Result != nil || len(writes(clients[i])) > 0 {
+						t.Errorf("%s was deployed to after -fail-fast stopped the run: %q", r.Cluster, writes(clients[i]))
+					}
+				case r.Result == nil:
+					t.Errorf("%s has no result", r.Cluster)
+				case !tt.wantFailed[i] && len(r.Result.Applied) == 0:
+					t.Errorf("%s: nothing applied", r.Cluster)
+				}
+			}
+		})
+	}
+}
+
+func TestForEachClusterFailFastCancels(t *testing.T) {
+	clusters := []Cluster{{Name: "east"}, {Name: "west"}}
+	failure := errors.New("apply failed")
+	errs := ForEachCluster(context.Background(), clusters, ClustersOptions{Parallel: true, FailFast: true}, func(ctx context.Context, i int) error {
+		if i == 1 {
+			return failure
+		}
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	if !errors.Is(errs[0], ErrNotRun) || !errors.Is(errs[1], failure) {
+		t.Errorf("got %v, want east not run and west failed", errs)
+	}
+}
@@ -0,0 +1,177 @@
+package deployer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilversion "k8s.io/apimachinery/pkg/util/version"
+	"k8s.io/apimachinery/pkg/version"
+	"strings"
+)
+
+// DefaultMinKubeVersion is the oldest Kubernetes release CheckCompatibility
+// accepts when Options.MinKubeVersion is empty, the first serving
+// networking.k8s.io/v1 Ingress.
+const DefaultMinKubeVersion = "1.19"
+
+// versionResource names the /version endpoint in the log of its retries.
+var versionResource = schema.GroupVersionResource{Resource: "version"}
+
+// errHPANotServed is the reason the built-in HPA is skipped on clusters
+// that don't serve autoscaling/v2.
+var errHPANotServed = errors.New("autoscaling/v2 is not served by the cluster, which needs Kubernetes 1.23 or later; the deployment keeps its replicas")
+
+// Compatibility is what CheckCompatibility found out about the cluster.
+type Compatibility struct {
+	// ServerVersion is the git version of the apiserver, e.g. v1.22.2.
+	ServerVersion string `json:"serverVersion"`
+	// MinVersion is the oldest release accepted.
+	MinVersion string `json:"minVersion"`
+	// APIs are the APIs of the objects, in the order they are applied.
+	APIs []APIFinding `json:"apis"`
+}
+
+// APIFinding says whether the cluster serves the API of one kind of object.
+type APIFinding struct {
+	// API is the group, version and resource, e.g. apps/v1 deployments.
+	API string `json:"api"`
+	// Required APIs abort the deploy when they aren't served; the objects
+	// of optional ones are downgraded or skipped, as Warning says.
+	Required bool   `json:"required"`
+	Served   bool   `json:"served"`
+	Warning  string `json:"warning,omitempty"`
+}
+
+// CompatibilityError is returned by CheckCompatibility when the cluster is
+// older than the minimum or doesn't serve a required API.
+type CompatibilityError struct {
+	Problems []string
+}
+
+func (e *CompatibilityError) Error() string {
+	return "the cluster can't run the deploy: " + strings.Join(e.Problems, "; ")
+}
+
+// CheckCompatibility fetches the server version through discovery and
+// checks it against Options.MinKubeVersion, and that the cluster serves the
+// API of every object DeployAll applies. The Ingress, the HPA and the
+// ServiceMonitor are optional: an Ingress served only as
+// networking.k8s.io/v1beta1 is converted, and one served as neither is
+// skipped, like an HPA without autoscaling/v2 and a ServiceMonitor without
+// the prometheus-operator. The HTTPRoute of Options.Routing gateway and the
+// Routes of Options.Routing route are required, as nothing else routes to
+// the API then. The findings are returned along with a
+// *CompatibilityError when the deploy can't go ahead. It needs a clientset;
+// see NewWithClientset.
+func (d *Deployer) CheckCompatibility(ctx context.Context) (*Compatibility, error) {
+	if d.kube == nil {
+		return nil, errors.New("the compatibility checks need a clientset")
+	}
+	if err := d.opts.Validate(); err != nil {
+		return nil, err
+	}
+	var info *version.Info
+	err := d.retry.do(ctx, OpGet, versionResource, "", func() (err error) {
+		info, err = d.kube.Discovery().ServerVersion()
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the server version: %w", err)
+	}
+	compat := &Compatibility{ServerVersion: info.GitVersion, MinVersion: d.opts.minKubeVersion()}
+
+	var problems []string
+	server, err := utilversion.ParseGeneric(info.GitVersion)
+	if err != nil {
+		return compat, fmt.Errorf("failed to parse the server version: %w", err)
+	}
+	if !server.AtLeast(utilversion.MustParseGeneric(compat.MinVersion)) {
+		problems = append(problems, fmt.Sprintf("Kubernetes %s is older than the minimum of %s", info.GitVersion, compat.MinVersion))
+	}
+
+	seen := map[schema.GroupVersionKind]bool{}
+	for _, obj := range sourceObjects(d.opts) {
+		gvk := obj.GroupVersionKind()
+		if seen[gvk] {
+			continue
+		}
+		seen[gvk] = true
+		finding, err := d.checkAPI(gvk)
+		if err != nil {
+			return compat, err
+		}
+		if finding.Required && !finding.Served {
+			problems = append(problems, finding.API+" is not served")
+		}
+		compat.APIs = append(compat.APIs, finding)
+	}
+	if len(problems) > 0 {
+		return compat, &CompatibilityError{Problems: problems}
+	}
+	return compat, nil
+}
+
+// checkAPI reports whether the cluster serves gvk.
+func (d *Deployer) checkAPI(gvk schema.GroupVersionKind) (APIFinding, error) {
+	finding := APIFinding{API: gvk.GroupVersion().String() + " " + gvk.Kind, Required: true}
+	mapping, err := d.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	switch {
+	case err == nil:
+		finding.API = gvk.GroupVersion().String() + " " + mapping.Resource.Resource
+		finding.Served = true
+	case !meta.IsNoMatchError(err):
+		return finding, fmt.Errorf("failed to discover %s: %w", finding.API, err)
+	}
+	if len(d.opts.Manifests) > 0 {
+		return finding, nil
+	}
+
+	switch gvk.GroupKind() {
+	case ingressGroupKind:
+		finding.Required = false
+		if finding.Served {
+			break
+		}
+		version, err := d.servedIngressVersion()
+		if err != nil {
+			return finding, err
+		}
+		if version == "v1beta1" {
+			finding.Warning = "the ingress is applied as networking.k8s.io/v1beta1"
+		} else {
+			finding.Warning = "the ingress is skipped: " + errIngressNotServed.Error()
+		}
+	case serviceMonitorGroupKind:
+		finding.Required = false
+		if !finding.Served {
+			finding.Warning = "the ServiceMonitor is skipped: " + errServiceMonitorNotServed.Error()
+		}
+	case schema.GroupKind{Group: HPAResource.Group, Kind: "HorizontalPodAutoscaler"}:
+		finding.Required = false
+		if !finding.Served {
+			finding.Warning = "the HPA is skipped: " + errHPANotServed.Error()
+		}
+	}
+	return finding, nil
+}
+
+// minKubeVersion returns Options.MinKubeVersion or its default.
+func (o Options) minKubeVersion() string {
+	if o.MinKubeVersion == "" {
+		return DefaultMinKubeVersion
+	}
+	return o.MinKubeVersion
+}
+
+// validateMinKubeVersion reports a minimum that isn't a version.
+func (o Options) validateMinKubeVersion() error {
+	if o.MinKubeVersion == "" {
+		return nil
+	}
+	if _, err := utilversion.ParseGeneric(o.MinKubeVersion); err != nil {
+		return &ValidationError{Field: "min-kube-version", Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package deployer
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"strings"
+)
+
+const (
+	// configVolumeName is the pod volume the ConfigMap is mounted from.
+	configVolumeName = "config"
+	// configChecksumAnnotation on the pod template changes with the config,
+	// so editing it rolls the deployment.
+	configChecksumAnnotation = "checksum/config"
+)
+
+// ConfigMapResource is the GroupVersionResource of config maps.
+var ConfigMapResource = schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+
+// ParseProperties parses key=value lines, as in a .properties or env file.
+// Blank lines and lines starting with # or ! are skipped, and whitespace
+// around keys and values is trimmed.
+func ParseProperties(data []byte) (map[string]string, error) {
+	props := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "!") {
+			continue
+		}
+		eq := strings.Index(line, "=")
+		if eq <= 0 {
+			return nil, fmt.Errorf("line %d: expected key=value", n)
+		}
+		props[strings.TrimSpace(line[:eq])] = strings.TrimSpace(line[eq+1:])
+	}
+	return props, scanner.Err()
+}
+
+// validateConfig checks the config keys are valid ConfigMap keys and, when
+// they become environment variables, valid variable names.
+func (o Options) validateConfig() error {
+	for k := range o.Config {
+		errs := validation.IsConfigMapKey(k)
+		if o.ConfigMountPath == "" {
+			errs = append(errs, validation.IsEnvVarName(k)...)
+		}
+		if len(errs) > 0 {
+			return &ValidationError{Field: "config", Err: fmt.Errorf("key %q: %s", k, strings.Join(errs, "; "))}
+		}
+	}
+	if o.ConfigMountPath != "" && !strings.HasPrefix(o.ConfigMountPath, "/") {
+		return &ValidationError{Field: "config-mount-path", Err: fmt.Errorf("%q must be an absolute path", o.ConfigMountPath)}
+	}
+	return nil
+}
+
+// newConfigMap returns the ConfigMap holding Options.Config, or nil when it is
+// empty.
+func newConfigMap(opts Options) *unstructured.Unstructured {
+	if len(opts.Config) == 0 {
+		return nil
+	}
+	data := make(map[string]interface{}, len(opts.Config))
+	for k, v := range opts.Config {
+		data[k] = v
+	}
+	return &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "ConfigMap",
+			"metadata": map[string]interface{}{
+				"name":      opts.configMapName(),
+				"namespace": opts.Namespace,
+			},
+			"data": data,
+		},
+	}
+}
+
+// BuildConfigMap returns the ConfigMap holding Options.Config, or nil when it
+// is empty.
+func BuildConfigMap(opts Options) *corev1.ConfigMap {
+	opts = opts.withDefaults()
+	if len(opts.Config) == 0 {
+		return nil
+	}
+	return &corev1.ConfigMap{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"},
+		ObjectMeta: metav1.ObjectMeta{Name: opts.configMapName(), Namespace: opts.Namespace},
+		Data:       opts.Config,
+	}
+}
+
+// dataChecksum hashes the data of a ConfigMap or Secret in key order, so
+// it only changes with the content.
+func dataChecksum(data map[string]string) string {
+	h := sha256.New()
+	for _, k := range sortedKeys(data) {
+		fmt.Fprintf(h, "%s=%s\n", k, data[k])
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// wireConfig makes the API container of the deployment read the
+// ConfigMap, through envFrom or a volume at Options.ConfigMountPath, and
+// stamps the config checksum on the pod template.
+func wireConfig(dep *appsv1.Deployment, opts Options) {
+	if len(opts.Config) == 0 {
+		return
+	}
+	tmpl := &dep.Spec.Template
+	if tmpl.Annotations == nil {
+		tmpl.Annotations = map[string]string{}
+	}
+	tmpl.Annotations[configChecksumAnnotation] = dataChecksum(opts.Config)
+
+	ref := corev1.LocalObjectReference{Name: opts.configMapName()}
+	c := apiContainer(&tmpl.Spec)
+	if opts.ConfigMountPath == "" {
+		c.EnvFrom = append(c.EnvFrom, corev1.EnvFromSource{ConfigMapRef: &corev1.ConfigMapEnvSource{LocalObjectReference: ref}})
+		return
+	}
+	tmpl.Spec.Volumes = append(tmpl.Spec.Volumes, corev1.Volume{
+		Name:         configVolumeName,
+		VolumeSource: corev1.VolumeSource{ConfigMap: &corev1.ConfigMapVolumeSource{LocalObjectReference: ref}},
+	})
+	c.VolumeMounts = append(c.VolumeMounts, corev1.VolumeMount{Name: configVolumeName, MountPath: opts.ConfigMountPath, ReadOnly: true})
+}
@@ -0,0 +1,113 @@
+package deployer
+
+import (
+	"fmt"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/yaml"
+	"strings"
+)
+
+// ParseContainer parses the -init-container and -sidecar formats: a
+// container as YAML or JSON, as the config file passes them, or the
+// shorthand `name=fluent-bit,image=fluent/fluent-bit:2.1,mount=data:/data,command=...`.
+// A mount is volume:path with an optional :ro, and may be repeated; the
+// command is run with sh -c and, unless quoted, takes the rest of s.
+func ParseContainer(s string) (corev1.Container, error) {
+	var c corev1.Container
+	if strings.HasPrefix(strings.TrimSpace(s), "{") {
+		if err := yaml.UnmarshalStrict([]byte(s), &c); err != nil {
+			return corev1.Container{}, err
+		}
+		return c, nil
+	}
+	fields, err := splitFields(s)
+	if err != nil {
+		return corev1.Container{}, err
+	}
+	for _, f := range fields {
+		switch f.key {
+		case "name":
+			c.Name = f.value
+		case "image":
+			c.Image = f.value
+		case "command":
+			c.Command = []string{"sh", "-c", f.value}
+		case "mount":
+			parts := strings.Split(f.value, ":")
+			if len(parts) < 2 || len(parts) > 3 || len(parts) == 3 && parts[2] != "ro" {
+				return corev1.Container{}, fmt.Errorf("%q: mount %q must be volume:path or volume:path:ro", s, f.value)
+			}
+			c.VolumeMounts = append(c.VolumeMounts, corev1.VolumeMount{Name: parts[0], MountPath: parts[1], ReadOnly: len(parts) == 3})
+		default:
+			return corev1.Container{}, fmt.Errorf("%q: unknown key %q, expected name, image, mount or command", s, f.key)
+		}
+	}
+	return c, nil
+}
+
+// podVolumes returns the names of the volumes the API pods have, which the
+// extra containers may mount.
+func (o Options) podVolumes() map[string]bool {
+	volumes := map[string]bool{}
+	if o.ConfigMountPath != "" {
+		volumes[configVolumeName] = true
+	}
+	for _, p := range o.PVCs {
+		volumes[p.Name] = true
+	}
+	if o.readOnlyRoot() {
+		volumes[tmpVolumeName] = true
+	}
+	return volumes
+}
+
+// validateContainers checks the init containers and sidecars have valid
+// names unique within the pod, an image, and mounts of the pod's volumes.
+func (o Options) validateContainers() error {
+	names := map[string]bool{apiContainerName: true}
+	volumes := o.podVolumes()
+	groups := []struct {
+		field      string
+		containers []corev1.Container
+	}{{"init-container", o.InitContainers}, {"sidecar", o.Sidecars}}
+	for _, g := range groups {
+		for _, c := range g.containers {
+			if errs := validation.IsDNS1123Label(c.Name); len(errs) > 0 {
+				return &ValidationError{Field: g.field, Err: fmt.Errorf("name %q: %s", c.Name, strings.Join(errs, "; "))}
+			}
+			if names[c.Name] {
+				return &ValidationError{Field: g.field, Err: fmt.Errorf("the pod already has a container called %s", c.Name)}
+			}
+			names[c.Name] = true
+			if c.Image == "" {
+				return &ValidationError{Field: g.field, Err: fmt.Errorf("%s: an image is needed", c.Name)}
+			}
+			if _, err := ParseImageReference(c.Image); err != nil {
+				return &ValidationError{Field: g.field, Err: fmt.Errorf("%s: %w", c.Name, err)}
+			}
+			for _, m := range c.VolumeMounts {
+				if !volumes[m.Name] {
+					return &ValidationError{Field: g.field, Err: fmt.Errorf("%s: the pod has no volume %q to mount", c.Name, m.Name)}
+				}
+				if !strings.HasPrefix(m.MountPath, "/") {
+					return &ValidationError{Field: g.field, Err: fmt.Errorf("%s: mount path %q must be absolute", c.Name, m.MountPath)}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// wireContainers adds the init containers before the API container starts
+// and the sidecars next to it.
+func wireContainers(dep *appsv1.Deployment, opts Options) {
+	spec := &dep.Spec.Template.Spec
+	for _, c := range opts.InitContainers {
+		spec.InitContainers = append(spec.InitContainers, *c.DeepCopy())
+	}
+	for _, c := range opts.Sidecars {
+		spec.Containers = append(spec.Containers, *c.DeepCopy())
+	}
+}
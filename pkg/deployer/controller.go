@@ -0,0 +1,175 @@
+package deployer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"time"
+)
+
+const (
+	// appResync is how often every EcommerceApp is reconciled even without
+	// changes, which corrects drift of the objects made for it.
+	appResync = 10 * time.Minute
+	// appRequeue is how soon an EcommerceApp that isn't rolled out yet is
+	// looked at again to update its status.
+	appRequeue = 10 * time.Second
+)
+
+// Controller deploys every EcommerceApp with the objects a Deployer builds,
+// and reports their rollout in its status.
+type Controller struct {
+	d         *Deployer
+	namespace string
+	queue     workqueue.RateLimitingInterface
+	informer  cache.SharedIndexInformer
+}
+
+// NewController returns a Controller for the EcommerceApps in namespace, or
+// in every namespace when it is empty. The Options of d supply what the
+// spec of an app doesn't carry, such as probes and resources.
+func NewController(d *Deployer, namespace string) *Controller {
+	factory := dynamicinformer.NewFilteredDynamicSharedInformerFactory(d.client, appResync, namespace, nil)
+	c := &Controller{
+		d:         d,
+		namespace: namespace,
+		queue:     workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), AppResource.Resource),
+		informer:  factory.ForResource(AppResource).Informer(),
+	}
+	enqueue := func(obj interface{}) {
+		if key, err := cache.MetaNamespaceKeyFunc(obj); err == nil {
+			c.queue.Add(key)
+		}
+	}
+	c.informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: enqueue,
+		UpdateFunc: func(old, obj interface{}) {
+			// Skip the controller's own status updates; spec changes bump
+			// the generation, and resyncs repeat the resourceVersion.
+			o, n := old.(*unstructured.Unstructured), obj.(*unstructured.Unstructured)
+			if o.GetGeneration() != n.GetGeneration() || o.GetResourceVersion() == n.GetResourceVersion() {
+				enqueue(obj)
+			}
+		},
+	})
+	return c
+}
+
+// Run reconciles EcommerceApps with workers goroutines until ctx is done.
+// Deleted apps need no work: their objects are garbage collected.
+func (c *Controller) Run(ctx context.Context, workers int) error {
+	defer c.queue.ShutDown()
+	if workers < 1 {
+		return &ValidationError{Field: "workers", Err: fmt.Errorf("must be at least 1, got %d", workers)}
+	}
+	c.d.log.Info("Starting controller", "resource", AppResource.String(), "namespace", c.namespace, "workers", workers)
+	go c.informer.Run(ctx.Done())
+	if !cache.WaitForCacheSync(ctx.Done(), c.informer.HasSynced) {
+		if ctx.Err() != nil {
+			return nil
+		}
+		return errors.New("failed to sync the EcommerceApp cache")
+	}
+	for i := 0; i < workers; i++ {
+		go wait.UntilWithContext(ctx, c.work, time.Second)
+	}
+	<-ctx.Done()
+	c.d.log.Info("Stopping controller")
+	return nil
+}
+
+// work processes queued apps until the queue shuts down.
+func (c *Controller) work(ctx context.Context) {
+	for {
+		item, shutdown := c.queue.Get()
+		if shutdown {
+			return
+		}
+		key := item.(string)
+		requeue, err := c.reconcile(ctx, key)
+		switch {
+		case err != nil:
+			c.d.log.Error(err, "Reconcile failed", "app", key)
+			c.queue.AddRateLimited(key)
+		case requeue:
+			c.queue.Forget(key)
+			c.queue.AddAfter(key, appRequeue)
+		default:
+			c.queue.Forget(key)
+		}
+		c.queue.Done(item)
+	}
+}
+
+// reconcile deploys the app at key and updates its status. It reports
+// whether the app should be looked at again because it isn't rolled out.
+func (c *Controller) reconcile(ctx context.Context, key string) (bool, error) {
+	item, exists, err := c.informer.GetIndexer().GetByKey(key)
+	if err != nil || !exists {
+		return false, err
+	}
+	app := item.(*unstructured.Unstructured).DeepCopy()
+
+	opts, err := OptionsFromApp(c.d.opts, app)
+	if err != nil {
+		// A bad spec can't succeed until it is edited, which requeues it.
+		return false, c.updateStatus(ctx, app, AppStatus{}, metav1.ConditionFalse, "InvalidSpec", err.Error())
+	}
+	sub := *c.d
+	sub.opts = opts.withDefaults()
+	if _, err := sub.DeployAll(ctx); err != nil {
+		if serr := c.updateStatus(ctx, app, AppStatus{}, metav1.ConditionFalse, "DeployFailed", err.Error()); serr != nil {
+			c.d.log.Error(serr, "Failed to update the status", "app", key)
+		}
+		return false, err
+	}
+
+	live, err := sub.liveAPIDeployment(ctx)
+	if err != nil {
+		return false, err
+	}
+	progress, done := rolloutProgress(live)
+	status := AppStatus{Replicas: progress.Desired, ReadyReplicas: progress.Ready}
+	if !done {
+		return true, c.updateStatus(ctx, app, status, metav1.ConditionFalse, "RollingOut", progress.String())
+	}
+	return false, c.updateStatus(ctx, app, status, metav1.ConditionTrue, "RolledOut", progress.String())
+}
+
+// updateStatus patches the status of app with status and its Ready
+// condition, keeping the transition time while the condition holds.
+func (c *Controller) updateStatus(ctx context.Context, app *unstructured.Unstructured, status AppStatus, ready metav1.ConditionStatus, reason, message string) error {
+	status.ObservedGeneration = app.GetGeneration()
+	condition := metav1.Condition{
+		Type:               AppReady,
+		Status:             ready,
+		ObservedGeneration: app.GetGeneration(),
+		LastTransitionTime: metav1.Now(),
+		Reason:             reason,
+		Message:            message,
+	}
+	if old := findCondition(app, AppReady); old != nil && old["status"] == string(ready) {
+		if t, err := time.Parse(time.RFC3339, fmt.Sprint(old["lastTransitionTime"])); err == nil {
+			condition.LastTransitionTime = metav1.NewTime(t)
+		}
+	}
+	status.Conditions = []metav1.Condition{condition}
+	patch, err := json.Marshal(map[string]interface{}{"status": status})
+	if err != nil {
+		return err
+	}
+	_, err = c.d.client.Resource(AppResource).Namespace(app.GetNamespace()).
+		Patch(ctx, app.GetName(), types.MergePatchType, patch, metav1.PatchOptions{}, "status")
+	if err != nil {
+		return c.d.opError(OpPatch, AppResource, app.GetName(), err)
+	}
+	return nil
+}
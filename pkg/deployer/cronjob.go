@@ -0,0 +1,224 @@
+package deployer
+
+import (
+	"fmt"
+	"github.com/robfig/cron/v3"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"strconv"
+	"strings"
+)
+
+// CronJobResource is the GroupVersionResource of cron jobs.
+var CronJobResource = schema.GroupVersionResource{Group: "batch", Version: "v1", Resource: "cronjobs"}
+
+// componentCronJob is the ComponentLabel of the cron jobs and their pods,
+// which keeps the pods out of the API deployment's selector.
+const componentCronJob = "cronjob"
+
+// maxCronJobName is the longest name of a cron job, whose controller
+// appends an 11 character suffix to name its jobs.
+const maxCronJobName = 52
+
+// CronJob is a task run on a schedule with the API container's image,
+// environment, volumes and service account, such as expiring stale carts.
+type CronJob struct {
+	// Name names the cron job server-<Name>, or <release>-<Name>.
+	Name string
+	// Schedule is a standard five-field cron schedule, or a descriptor such
+	// as @daily.
+	Schedule string
+	// Command is run with sh -c.
+	Command string
+	// ConcurrencyPolicy is Allow, Forbid or Replace, and says what happens
+	// when a run is due while the last is still going. Empty means Forbid.
+	ConcurrencyPolicy string
+	// SuccessfulJobsHistoryLimit and FailedJobsHistoryLimit are how many
+	// finished jobs are kept; nil keeps the Kubernetes defaults of 3 and 1.
+	SuccessfulJobsHistoryLimit *int32
+	FailedJobsHistoryLimit     *int32
+}
+
+// ParseCronJob parses the -cronjob flag format,
+// `name=cleanup,schedule="0 3 * * *",command=./ecommerce carts expire`.
+// Values may be double-quoted to hold commas; an unquoted command takes the
+// rest of s. The fields are checked by Options.Validate.
+func ParseCronJob(s string) (CronJob, error) {
+	fields, err := splitFields(s)
+	if err != nil {
+		return CronJob{}, err
+	}
+	var c CronJob
+	for _, f := range fields {
+		switch f.key {
+		case "name":
+			c.Name = f.value
+		case "schedule":
+			c.Schedule = f.value
+		case "command":
+			c.Command = f.value
+		case "concurrencyPolicy":
+			c.ConcurrencyPolicy = f.value
+		case "successfulJobsHistoryLimit", "failedJobsHistoryLimit":
+			n, err := strconv.ParseInt(f.value, 10, 32)
+			if err != nil {
+				return CronJob{}, fmt.Errorf("%q: %s must be a number, got %q", s, f.key, f.value)
+			}
+			limit := int32(n)
+			if f.key == "successfulJobsHistoryLimit" {
+				c.SuccessfulJobsHistoryLimit = &limit
+			} else {
+				c.FailedJobsHistoryLimit = &limit
+			}
+		default:
+			return CronJob{}, fmt.Errorf("%q: unknown key %q, expected name, schedule, command, concurrencyPolicy, successfulJobsHistoryLimit or failedJobsHistoryLimit", s, f.key)
+		}
+	}
+	return c, nil
+}
+
+// field is a key=value pair of a flag such as -cronjob.
+type field struct {
+	key, value string
+}
+
+// splitFields splits the comma separated key=value pairs of s, in order.
+// Values may be double-quoted to hold commas, and an unquoted command
+// takes the rest of s.
+func splitFields(s string) ([]field, error) {
+	var fields []field
+	for rest := s; rest != ""; {
+		eq := strings.Index(rest, "=")
+		if eq < 0 {
+			return nil, fmt.Errorf("%q: %q must be in key=value form", s, rest)
+		}
+		key, value := rest[:eq], rest[eq+1:]
+		switch {
+		case strings.HasPrefix(value, `"`):
+			end := strings.Index(value[1:], `"`)
+			if end < 0 {
+				return nil, fmt.Errorf("%q: the value of %s lacks its closing quote", s, key)
+			}
+			value, rest = value[1:end+1], value[end+2:]
+			if rest != "" && !strings.HasPrefix(rest, ",") {
+				return nil, fmt.Errorf("%q: expected a comma after the value of %s", s, key)
+			}
+			rest = strings.TrimPrefix(rest, ",")
+		case key == "command":
+			rest = ""
+		default:
+			rest = ""
+			if comma := strings.Index(value, ","); comma >= 0 {
+				value, rest = value[:comma], value[comma+1:]
+			}
+		}
+		fields = append(fields, field{key, value})
+	}
+	return fields, nil
+}
+
+// validateCronJobs checks each cron job has a unique valid name, a schedule
+// the cron job controller accepts, a command and valid limits.
+func (o Options) validateCronJobs() error {
+	names := map[string]bool{}
+	for _, c := range o.CronJobs {
+		if errs := validation.IsDNS1123Label(c.Name); len(errs) > 0 {
+			return &ValidationError{Field: "cronjob", Err: fmt.Errorf("name %q: %s", c.Name, strings.Join(errs, "; "))}
+		}
+		if names[c.Name] {
+			return &ValidationError{Field: "cronjob", Err: fmt.Errorf("%q is defined twice", c.Name)}
+		}
+		names[c.Name] = true
+		if name := o.cronJobName(c); len(name) > maxCronJobName {
+			return &ValidationError{Field: "cronjob", Err: fmt.Errorf("%s: the name %q is longer than %d characters", c.Name, name, maxCronJobName)}
+		}
+		if _, err := cron.ParseStandard(c.Schedule); err != nil {
+			return &ValidationError{Field: "cronjob", Err: fmt.Errorf("%s: schedule %q: %w", c.Name, c.Schedule, err)}
+		}
+		if c.Command == "" {
+			return &ValidationError{Field: "cronjob", Err: fmt.Errorf("%s: a command is needed", c.Name)}
+		}
+		switch batchv1.ConcurrencyPolicy(c.ConcurrencyPolicy) {
+		case "", batchv1.AllowConcurrent, batchv1.ForbidConcurrent, batchv1.ReplaceConcurrent:
+		default:
+			return &ValidationError{Field: "cronjob", Err: fmt.Errorf("%s: concurrencyPolicy must be Allow, Forbid or Replace, got %q", c.Name, c.ConcurrencyPolicy)}
+		}
+		for _, limit := range []*int32{c.SuccessfulJobsHistoryLimit, c.FailedJobsHistoryLimit} {
+			if limit != nil && *limit < 0 {
+				return &ValidationError{Field: "cronjob", Err: fmt.Errorf("%s: history limits must not be negative, got %d", c.Name, *limit)}
+			}
+		}
+	}
+	return nil
+}
+
+func (o Options) cronJobName(c CronJob) string {
+	return o.objectName(c.Name, "server-"+c.Name)
+}
+
+// newCronJob returns the cron job c, running in the pod spec of the API
+// deployment rendered from the embedded manifest.
+func newCronJob(opts Options, c CronJob) *unstructured.Unstructured {
+	var dep appsv1.Deployment
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(newDeployment(opts).Object, &dep); err != nil {
+		panic(fmt.Sprintf("the API deployment doesn't convert: %v", err))
+	}
+	return mustToUnstructured(cronJobFor(opts, c, &dep.Spec.Template.Spec))
+}
+
+// BuildCronJob returns the cron job c, running in the pod spec of the API
+// deployment.
+func BuildCronJob(opts Options, c CronJob) *batchv1.CronJob {
+	opts = opts.withDefaults()
+	return cronJobFor(opts, c, &BuildDeployment(opts).Spec.Template.Spec)
+}
+
+// cronJobFor returns the cron job c running its command in the API container
+// of podSpec, without the container's probes and ports or the sidecars.
+func cronJobFor(opts Options, c CronJob, podSpec *corev1.PodSpec) *batchv1.CronJob {
+	spec := podSpec.DeepCopy()
+	// A sidecar would keep the jobs from ever completing.
+	spec.Containers = []corev1.Container{*apiContainer(spec)}
+	api := &spec.Containers[0]
+	api.Command = []string{"sh", "-c", c.Command}
+	api.Args = nil
+	api.Ports = nil
+	api.LivenessProbe, api.ReadinessProbe, api.StartupProbe = nil, nil, nil
+	api.Lifecycle = nil
+	spec.RestartPolicy = corev1.RestartPolicyOnFailure
+	// The anti-affinity and spread constraints are about the API pods,
+	// which the jobs shouldn't avoid.
+	spec.Affinity = opts.Affinity.DeepCopy()
+	spec.TopologySpreadConstraints = nil
+
+	policy := batchv1.ConcurrencyPolicy(c.ConcurrencyPolicy)
+	if policy == "" {
+		policy = batchv1.ForbidConcurrent
+	}
+	podLabels := opts.commonLabels(componentCronJob)
+	return &batchv1.CronJob{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "batch/v1", Kind: "CronJob"},
+		ObjectMeta: metav1.ObjectMeta{Name: opts.cronJobName(c), Namespace: opts.Namespace},
+		Spec: batchv1.CronJobSpec{
+			Schedule:                   c.Schedule,
+			ConcurrencyPolicy:          policy,
+			SuccessfulJobsHistoryLimit: c.SuccessfulJobsHistoryLimit,
+			FailedJobsHistoryLimit:     c.FailedJobsHistoryLimit,
+			JobTemplate: batchv1.JobTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: podLabels},
+				Spec: batchv1.JobSpec{
+					Template: corev1.PodTemplateSpec{
+						ObjectMeta: metav1.ObjectMeta{Labels: podLabels, Annotations: opts.Annotations},
+						Spec:       *spec,
+					},
+				},
+			},
+		},
+	}
+}
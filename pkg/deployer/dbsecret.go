@@ -0,0 +1,146 @@
+package deployer
+
+import (
+	"encoding/base64"
+	"fmt"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"strings"
+)
+
+const (
+	// ManagedByLabel marks the objects this tool created. Secrets, which a
+	// user may have created under the same name, are only deleted when they
+	// carry it.
+	ManagedByLabel = "app.kubernetes.io/managed-by"
+	// ManagedBy is the value of ManagedByLabel.
+	ManagedBy = "ecommerceApi-client-go"
+	// Redacted replaces secret values in printed objects.
+	Redacted = "***"
+)
+
+// validateDBCredentials checks the credential keys are valid secret keys and
+// environment variable names.
+func (o Options) validateDBCredentials() error {
+	for k := range o.DBCredentials {
+		errs := append(validation.IsConfigMapKey(k), validation.IsEnvVarName(k)...)
+		if len(errs) > 0 {
+			return &ValidationError{Field: "db-secret", Err: fmt.Errorf("key %q: %s", k, strings.Join(errs, "; "))}
+		}
+	}
+	return nil
+}
+
+// newDBSecret returns the secret holding Options.DBCredentials, or nil when
+// there are none.
+func newDBSecret(opts Options) *unstructured.Unstructured {
+	if len(opts.DBCredentials) == 0 {
+		return nil
+	}
+	data := make(map[string]interface{}, len(opts.DBCredentials))
+	for k, v := range opts.DBCredentials {
+		data[k] = base64.StdEncoding.EncodeToString([]byte(v))
+	}
+	return &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "Secret",
+			"metadata": map[string]interface{}{
+				"name":      opts.dbSecretName(),
+				"namespace": opts.Namespace,
+				"labels":    map[string]interface{}{ManagedByLabel: ManagedBy},
+			},
+			"type": string(corev1.SecretTypeOpaque),
+			"data": data,
+		},
+	}
+}
+
+// BuildDBSecret returns the secret holding Options.DBCredentials, or nil when
+// there are none.
+func BuildDBSecret(opts Options) *corev1.Secret {
+	opts = opts.withDefaults()
+	if len(opts.DBCredentials) == 0 {
+		return nil
+	}
+	data := make(map[string][]byte, len(opts.DBCredentials))
+	for k, v := range opts.DBCredentials {
+		data[k] = []byte(v)
+	}
+	return &corev1.Secret{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      opts.dbSecretName(),
+			Namespace: opts.Namespace,
+			Labels:    map[string]string{ManagedByLabel: ManagedBy},
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: data,
+	}
+}
+
+// wireDBSecret hands the credentials secret to the API container as
+// environment variables.
+func wireDBSecret(dep *appsv1.Deployment, opts Options) {
+	if len(opts.DBCredentials) == 0 {
+		return
+	}
+	c := apiContainer(&dep.Spec.Template.Spec)
+	c.EnvFrom = append(c.EnvFrom, corev1.EnvFromSource{
+		SecretRef: &corev1.SecretEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: opts.dbSecretName()}},
+	})
+}
+
+// Redact returns obj with the values of a Secret's data and stringData
+// replaced by Redacted, leaving the keys visible. Other kinds are returned
+// unchanged.
+func Redact(obj *unstructured.Unstructured) *unstructured.Unstructured {
+	if obj.GetKind() != "Secret" || obj.GroupVersionKind().Group != "" {
+		return obj
+	}
+	obj = obj.DeepCopy()
+	for _, field := range []string{"data", "stringData"} {
+		values, ok := obj.Object[field].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for k := range values {
+			values[k] = Redacted
+		}
+	}
+	return obj
+}
+
+// redactPair redacts the Secret values of live, which may be nil, and
+// desired for a diff, marking the values that differ so the diff still shows
+// a change.
+func redactPair(live, desired *unstructured.Unstructured) (*unstructured.Unstructured, *unstructured.Unstructured) {
+	if desired.GetKind() != "Secret" || desired.GroupVersionKind().Group != "" {
+		return live, desired
+	}
+	desired = desired.DeepCopy()
+	live = live.DeepCopy()
+	for _, field := range []string{"data", "stringData"} {
+		desiredValues, _ := desired.Object[field].(map[string]interface{})
+		var liveValues map[string]interface{}
+		if live != nil {
+			liveValues, _ = live.Object[field].(map[string]interface{})
+		}
+		for k, v := range liveValues {
+			if dv, ok := desiredValues[k]; ok && dv != v {
+				liveValues[k], desiredValues[k] = Redacted+" (before)", Redacted+" (after)"
+				continue
+			}
+			liveValues[k] = Redacted
+		}
+		for k, v := range desiredValues {
+			if v != Redacted+" (after)" {
+				desiredValues[k] = Redacted
+			}
+		}
+	}
+	return live, desired
+}
@@ -0,0 +1,219 @@
+package deployer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"time"
+)
+
+// DeleteOutcome describes what happened to a single resource during DeleteAll.
+type DeleteOutcome string
+
+const (
+	Deleted  DeleteOutcome = "deleted"
+	NotFound DeleteOutcome = "not found"
+	Skipped  DeleteOutcome = "skipped"
+	Failed   DeleteOutcome = "failed"
+)
+
+// DeleteOptions controls how DeleteAll removes the resources.
+type DeleteOptions struct {
+	// GracePeriodSeconds is passed through to the apiserver. Nil keeps the
+	// per-resource default.
+	GracePeriodSeconds *int64
+	// PropagationPolicy decides what happens to the dependents of each
+	// object, such as the ReplicaSets and pods of the deployment: deleted
+	// in the background, deleted before the object with foreground, or left
+	// running with orphan. Nil keeps the per-resource default.
+	PropagationPolicy *metav1.DeletionPropagation
+	// Wait makes DeleteAll poll until each object is gone before moving on.
+	// With foreground propagation an object is only gone once its
+	// dependents are.
+	Wait bool
+	// Timeout bounds the total time spent waiting when Wait is set.
+	Timeout time.Duration
+}
+
+// DeleteResult is the outcome of deleting one resource.
+type DeleteResult struct {
+	Resource schema.GroupVersionResource
+	Kind     string
+	Name     string
+	Outcome  DeleteOutcome
+	// Err is the failure for Failed results and the reason for Skipped ones.
+	Err error
+	// Duration is how long the deletion took, including the wait for the
+	// object to be gone.
+	Duration time.Duration
+}
+
+// DeleteAll deletes everything DeployAll creates in reverse creation order.
+// Objects that are already gone are reported as NotFound, and resources whose
+// API is not served by the cluster, or whose kind can't be resolved, are
+// Skipped, so a failure on one object never stops the rest of the cleanup.
+func (d *Deployer) DeleteAll(ctx context.Context, opts DeleteOptions) []DeleteResult {
+	waitCtx := ctx
+	if opts.Wait && opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		waitCtx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+
+	objects, skipped, err := d.servedObjects()
+	if err != nil {
+		return []DeleteResult{{Outcome: Failed, Err: err}}
+	}
+	results := make([]DeleteResult, 0, len(objects)+len(skipped))
+	for _, s := range skipped {
+		results = append(results, DeleteResult{Kind: s.Kind, Name: s.Name, Outcome: Skipped, Err: s.Reason})
+	}
+	results = append(results, d.deleteMigrations(ctx, waitCtx, opts)...)
+	for i := len(objects) - 1; i >= 0; i-- {
+		result := DeleteResult{Kind: objects[i].GetKind(), Name: objects[i].GetName()}
+		o, err := d.mapObject(objects[i])
+		if isNoMatch(err) {
+			result.Outcome, result.Err = Skipped, err
+			results = append(results, result)
+			continue
+		}
+		if err != nil {
+			result.Outcome, result.Err = Failed, err
+			results = append(results, result)
+			continue
+		}
+
+		result.Resource = o.gvr
+		client := d.resourceFor(o.gvr, o.obj)
+		if err := d.checkManaged(ctx, client, o.gvr, o.obj); err != nil {
+			result.Outcome, result.Err = Skipped, err
+			if !errors.Is(err, errNotManaged) {
+				result.Outcome = Failed
+			}
+			results = append(results, result)
+			continue
+		}
+		start := time.Now()
+		result.Outcome, result.Err = d.delete(ctx, client, o.gvr, o.obj.GetName(), opts)
+		if result.Outcome == Deleted && opts.Wait && !d.opts.DryRun {
+			if err := d.waitForDeletion(waitCtx, client, o.gvr, o.obj.GetName()); err != nil {
+				result.Outcome, result.Err = Failed, err
+			}
+		}
+		result.Duration = time.Since(start)
+		results = append(results, result)
+	}
+	return results
+}
+
+func (d *Deployer) delete(ctx context.Context, client dynamic.ResourceInterface, gvr schema.GroupVersionResource, name string, opts DeleteOptions) (DeleteOutcome, error) {
+	d.log.Info("Deleting", "resource", gvr.String(), "namespace", d.opts.Namespace, "name", name)
+	err := client.Delete(ctx, name, metav1.DeleteOptions{
+		GracePeriodSeconds: opts.GracePeriodSeconds,
+		PropagationPolicy:  opts.PropagationPolicy,
+		DryRun:             d.dryRun(),
+	})
+	switch {
+	case err == nil:
+		return Deleted, nil
+	case apierrors.IsNotFound(err):
+		// A missing object and an API the cluster doesn't serve both come
+		// back as 404, so tell them apart with a list on the resource.
+		served, serr := served(ctx, client)
+		if serr != nil {
+			return Failed, d.opError(OpList, gvr, "", serr)
+		}
+		if !served {
+			return Skipped, fmt.Errorf("%s is not served by the cluster", gvr)
+		}
+		return NotFound, nil
+	default:
+		return Failed, d.opError(OpDelete, gvr, name, err)
+	}
+}
+
+// errNotManaged is returned by checkManaged for objects this tool didn't
+// create.
+var errNotManaged = errors.New("not labelled " + ManagedByLabel + "=" + ManagedBy + ", leaving it alone")
+
+// checkManaged returns errNotManaged when obj is a Secret carrying
+// ManagedByLabel and the live secret doesn't. An object that is already gone
+// passes, so delete reports it as NotFound.
+func (d *Deployer) checkManaged(ctx context.Context, client dynamic.ResourceInterface, gvr schema.GroupVersionResource, obj *unstructured.Unstructured) error {
+	if _, ok := obj.GetLabels()[ManagedByLabel]; !ok || obj.GetKind() != "Secret" {
+		return nil
+	}
+	live, err := client.Get(ctx, obj.GetName(), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return d.opError(OpGet, gvr, obj.GetName(), err)
+	}
+	if live.GetLabels()[ManagedByLabel] != ManagedBy {
+		return errNotManaged
+	}
+	return nil
+}
+
+func served(ctx context.Context, client dynamic.ResourceInterface) (bool, error) {
+	_, err := client.List(ctx, metav1.ListOptions{Limit: 1})
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (d *Deployer) waitForDeletion(ctx context.Context, client dynamic.ResourceInterface, gvr schema.GroupVersionResource, name string) error {
+	err := wait.PollImmediateUntilWithContext(ctx, time.Second, func(ctx context.Context) (bool, error) {
+		_, err := client.Get(ctx, name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return true, nil
+		}
+		return false, err
+	})
+	if err != nil {
+		return d.opError(OpWait, gvr, name, fmt.Errorf("deletion: %w", err))
+	}
+	return nil
+}
+
+// deleteMigrations deletes the migration Jobs of the release and their pods,
+// which opts.PropagationPolicy orphans only when it says so.
+func (d *Deployer) deleteMigrations(ctx, waitCtx context.Context, opts DeleteOptions) []DeleteResult {
+	client := d.resource(JobResource)
+	list, err := client.List(ctx, metav1.ListOptions{LabelSelector: d.opts.migrationSelector()})
+	switch {
+	case apierrors.IsNotFound(err):
+		return nil
+	case apierrors.IsForbidden(err):
+		return []DeleteResult{{Resource: JobResource, Kind: "Job", Outcome: Skipped, Err: d.opError(OpList, JobResource, "", err)}}
+	case err != nil:
+		return []DeleteResult{{Resource: JobResource, Kind: "Job", Outcome: Failed, Err: d.opError(OpList, JobResource, "", err)}}
+	}
+	if opts.PropagationPolicy == nil {
+		// Jobs orphan their pods by default.
+		background := metav1.DeletePropagationBackground
+		opts.PropagationPolicy = &background
+	}
+	var results []DeleteResult
+	for _, job := range list.Items {
+		result := DeleteResult{Resource: JobResource, Kind: "Job", Name: job.GetName()}
+		start := time.Now()
+		result.Outcome, result.Err = d.delete(ctx, client, JobResource, job.GetName(), opts)
+		if result.Outcome == Deleted && opts.Wait && !d.opts.DryRun {
+			if err := d.waitForDeletion(waitCtx, client, JobResource, job.GetName()); err != nil {
+				result.Outcome, result.Err = Failed, err
+			}
+		}
+		result.Duration = time.Since(start)
+		results = append(results, result)
+	}
+	return results
+}
@@ -0,0 +1,789 @@
+// Package deployer creates the ecommerce API workload (deployment, services
+// and ingress) in a cluster through the dynamic client.
+package deployer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"net/http"
+	"time"
+)
+
+const (
+	// DefaultNamespace is the namespace used when Options.Namespace is empty.
+	DefaultNamespace = "default"
+
+	// DefaultReplicas is the replica count used when Options.Replicas is nil.
+	DefaultReplicas int32 = 2
+	// DefaultMaxReplicas is the upper bound used when Options.MaxReplicas is zero.
+	DefaultMaxReplicas int32 = 50
+
+	// DefaultFieldManager is the server-side apply field manager used when
+	// Options.FieldManager is empty.
+	DefaultFieldManager = "ecommerce-deployer"
+)
+
+// GroupVersionResources of the objects managed by the Deployer.
+var (
+	DeploymentResource = schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}
+	ServiceResource    = schema.GroupVersionResource{Version: "v1", Resource: "services"}
+	IngressResource    = schema.GroupVersionResource{Group: "networking.k8s.io", Version: "v1", Resource: "ingresses"}
+	NamespaceResource  = schema.GroupVersionResource{Version: "v1", Resource: "namespaces"}
+	HPAResource        = schema.GroupVersionResource{Group: "autoscaling", Version: "v2", Resource: "horizontalpodautoscalers"}
+)
+
+// Options configures a Deployer.
+type Options struct {
+	// Namespace the resources are deployed to.
+	Namespace string
+	// RevisionHistoryLimit is how many old replica sets the API deployment
+	// keeps for Rollback. Nil leaves the cluster default of 10.
+	RevisionHistoryLimit *int32
+	// Owner sets ownerReferences so garbage collection deletes objects along
+	// with their owner: OwnerDeployment makes the API deployment own the
+	// services and ingress, and OwnerRelease makes a release record
+	// ConfigMap own the whole release. Empty sets none.
+	Owner string
+	// Release prefixes the object names, <release>-api, <release>-svc and
+	// so on, and is the value of InstanceLabel, so several releases can
+	// share a namespace. DefaultRelease, when empty, keeps the names
+	// documented on the fields below.
+	Release string
+	// CreateNamespace creates Namespace before deploying if it doesn't exist.
+	CreateNamespace bool
+	// Image is the container image reference of the API container.
+	Image string
+	// PinnedFrom is the tagged image PinImageDigest resolved Image from,
+	// recorded in PinnedFromAnnotation. Its tag stays the VersionLabel.
+	PinnedFrom string
+	// VerifiedSignature is the image signature VerifyImageSignature
+	// verified, recorded in SignatureDigestAnnotation and
+	// SignatureSignerAnnotation on the API deployment.
+	VerifiedSignature *VerifiedSignature
+	// Replicas of the API deployment. It is left untouched on an existing
+	// deployment that an HPA scales.
+	Replicas *int32
+	// Strategy is how the API deployment replaces its pods:
+	// StrategyRollingUpdate, the default, or StrategyRecreate, which stops
+	// every old pod before starting new ones, for versions that can't run
+	// side by side.
+	Strategy string
+	// MaxSurge and MaxUnavailable tune a rolling update, as a pod count or a
+	// percentage of the replicas. Nil keeps the default of 25%.
+	MaxSurge       *intstr.IntOrString
+	MaxUnavailable *intstr.IntOrString
+	// Autoscale creates an autoscaling/v2 HPA for the API deployment. On
+	// updates the deployment's replicas are then left to the HPA.
+	Autoscale *Autoscale
+	// PDBMinAvailable and PDBMaxUnavailable create a policy/v1
+	// PodDisruptionBudget for the API pods. At most one may be set.
+	PDBMinAvailable   *intstr.IntOrString
+	PDBMaxUnavailable *intstr.IntOrString
+	// NetworkPolicy restricts traffic to the API pods to their Ports from pods in
+	// Namespace and in IngressControllerNamespace. With Postgres their
+	// egress is limited to the database and cluster DNS too.
+	NetworkPolicy              bool
+	IngressControllerNamespace string
+	// RBAC binds a Role with RBACRules, DefaultRBACRules when empty, to the
+	// server-sa service account the API pods run as. Without it their
+	// service account token isn't mounted.
+	RBAC      bool
+	RBACRules []rbacv1.PolicyRule
+	// CPURequest, CPULimit, MemoryRequest and MemoryLimit are the resources
+	// of the API container, as quantities such as 250m or 512Mi. An empty
+	// request defaults to DefaultCPURequest or DefaultMemoryRequest unless
+	// the matching limit is set.
+	CPURequest    string
+	CPULimit      string
+	MemoryRequest string
+	MemoryLimit   string
+	// NoResources leaves the API container without requests or limits.
+	NoResources bool
+	// ProbeType selects HTTP GET probes of HealthPath, the default, or TCP
+	// probes of the API port, for apps without a health endpoint.
+	ProbeType  string
+	HealthPath string
+	// StartupProbeFailureThreshold adds a startup probe allowing that many
+	// failed checks, 10 seconds apart, before the liveness probe starts.
+	StartupProbeFailureThreshold int32
+	// NodeSelector and Tolerations place the API, database and cache pods
+	// on a node pool, e.g. a tainted one.
+	NodeSelector map[string]string
+	Tolerations  []corev1.Toleration
+	// PodAntiAffinity is AntiAffinitySoft or AntiAffinityHard to spread the
+	// API pods over the nodes, preferring or requiring one per node.
+	PodAntiAffinity string
+	// Affinity is the affinity of the API pods, as given. With
+	// PodAntiAffinity it must leave podAntiAffinity out.
+	Affinity *corev1.Affinity
+	// TopologySpread are the topologySpreadConstraints of the API pods,
+	// e.g. over zones. A nil LabelSelector selects the release's API pods.
+	TopologySpread []corev1.TopologySpreadConstraint
+	// InitContainers run to completion before the API container starts,
+	// e.g. to wait for a dependency, and Sidecars run next to it. Their
+	// mounts may name the pod's volumes: config, the PVCs and tmp.
+	InitContainers []corev1.Container
+	Sidecars       []corev1.Container
+	// Security is the securityContext of the pods, e.g. the restricted
+	// preset for namespaces enforcing that Pod Security Standard.
+	Security Security
+	// ServiceType is the type of the server-svc service: ClusterIP, NodePort
+	// or LoadBalancer. Empty means DefaultServiceType.
+	ServiceType string
+	// ExposeNodePort also creates the nodeport-svc NodePort service.
+	ExposeNodePort bool
+	// LBPreset is one of the LBPreset constants, the annotations asking the
+	// cloud provider for a kind of load balancer when ServiceType is
+	// LoadBalancer. LBAnnotations are set on server-svc over them.
+	LBPreset      string
+	LBAnnotations map[string]string
+	// SessionAffinity ClientIP sends each client to the same API pod, for
+	// SessionAffinityTimeout seconds, zero meaning
+	// DefaultSessionAffinityTimeout. It applies to every service but the
+	// headless one.
+	SessionAffinity        string
+	SessionAffinityTimeout int32
+	// ExternalTrafficPolicy Local keeps the client source IP on the NodePort
+	// and LoadBalancer services by only routing to API pods on the node the
+	// traffic came in on.
+	ExternalTrafficPolicy string
+	// HeadlessService also creates the server-headless service, with
+	// clusterIP None, for clients such as gRPC ones balancing over the API
+	// pods themselves.
+	HeadlessService bool
+	// ExternalNameServices are services of the release resolving to hosts
+	// outside the cluster.
+	ExternalNameServices []ExternalNameService
+	// NodePort fixes the node port of server-svc when ServiceType is
+	// NodePort, and of nodeport-svc otherwise. Zero lets the apiserver
+	// allocate one.
+	NodePort int32
+	// Ports are the ports of the API container, exposed by the services and
+	// routed to by the ingress paths. The first is the one probed and the
+	// default backend of the paths. Nil means DefaultPorts.
+	Ports []Port
+	// Routing is RoutingIngress to reach the API through the ingress,
+	// RoutingGateway to create an HTTPRoute attached to the GatewayName
+	// Gateway of GatewayNamespace, Namespace by default, instead, or
+	// RoutingRoute for OpenShift Routes. The HTTPRoute needs the Gateway
+	// API CRDs. Empty is left to Deployer.ResolveRouting, and means the
+	// ingress until then.
+	Routing          string
+	GatewayName      string
+	GatewayNamespace string
+	// RouteEdgeTLS terminates TLS of the Routes at the router, with its
+	// default certificate unless TLSCert is set, which implies it.
+	// RouteInsecurePolicy is what the router does with plain HTTP requests
+	// to them: RouteInsecureRedirect, RouteInsecureAllow or
+	// RouteInsecureNone, the router's default when empty.
+	RouteEdgeTLS        bool
+	RouteInsecurePolicy string
+	// Hosts the ingress routes to the API, one rule each. Nil means
+	// DefaultHost.
+	Hosts []string
+	// Paths routed to the API on every host, in order. Nil means
+	// DefaultPaths.
+	Paths []IngressPath
+	// IngressClass is the spec.ingressClassName of the ingress. When empty,
+	// the cluster's default IngressClass is used.
+	IngressClass string
+	// IngressAnnotations are set on the ingress, over the annotations the
+	// deployer sets itself, such as the cert-manager issuer.
+	IngressAnnotations map[string]string
+	// Nginx are ingress-nginx settings, set as annotations when the
+	// ingress class belongs to ingress-nginx. IngressAnnotations win over
+	// them.
+	Nginx NginxAnnotations
+	// TLSSecret names the kubernetes.io/tls secret the ingress terminates
+	// TLS with. It must already exist unless TLSCert and TLSKey are set.
+	TLSSecret string
+	// TLSCert and TLSKey are a PEM certificate and key stored in the
+	// TLSSecret secret, DefaultTLSSecret by default, which is applied before
+	// the ingress and deleted along with it.
+	TLSCert []byte
+	TLSKey  []byte
+	// CertManagerIssuer is a cert-manager ClusterIssuer that issues the
+	// ingress certificate into the DefaultTLSSecret secret, or TLSSecret.
+	CertManagerIssuer string
+	// MetricsPort adds a metrics port to the API container and server-svc,
+	// and a ServiceMonitor scraping MetricsPath on it, DefaultMetricsPath
+	// when empty, on clusters running the prometheus-operator. Zero adds
+	// none.
+	MetricsPort int32
+	MetricsPath string
+	// ServiceMonitorLabels are added to the labels of the ServiceMonitor,
+	// to match the serviceMonitorSelector of the Prometheus scraping it.
+	ServiceMonitorLabels map[string]string
+	// Config is stored in the server-config ConfigMap and handed to the API
+	// container as environment variables, or as files in ConfigMountPath.
+	// Changing it rolls the deployment.
+	Config map[string]string
+	// ConfigMountPath mounts Config as one file per key instead of
+	// environment variables.
+	ConfigMountPath string
+	// DBCredentials are stored in the server-db-credentials Opaque secret and
+	// handed to the API container as environment variables. The secret is
+	// only deleted while it carries ManagedByLabel.
+	DBCredentials map[string]string
+	// Labels and Annotations are added to the metadata of every built-in
+	// object and pod template, next to the recommended app.kubernetes.io
+	// labels, which Labels can't override.
+	Labels      map[string]string
+	Annotations map[string]string
+	// ImagePullSecrets are existing secrets the API pods pull their image
+	// with.
+	ImagePullSecrets []string
+	// RegistryAuth is stored in the server-registry-auth pull secret, which
+	// the API pods pull with too. Like the credentials secret, it is only
+	// deleted while it carries ManagedByLabel.
+	RegistryAuth *RegistryAuth
+	// Env sets environment variables of the API container, replacing any of
+	// the same name the deployer sets itself.
+	Env map[string]string
+	// EnvFromSecret and EnvFromConfigMap set environment variables from a
+	// key of a secret or config map, given as "name/key".
+	EnvFromSecret    map[string]string
+	EnvFromConfigMap map[string]string
+	// PVCs are persistent volume claims created alongside the deployment and
+	// mounted into the API container.
+	PVCs []PVC
+	// Postgres also deploys a single-replica PostgreSQL StatefulSet with a
+	// headless service and generated credentials, and passes DB_HOST,
+	// DB_USER, DB_PASSWORD and DB_NAME to the API container.
+	Postgres bool
+	// PostgresTag is the tag of the postgres image, DefaultPostgresTag when
+	// empty.
+	PostgresTag string
+	// PostgresStorage is the size of the database volume,
+	// DefaultPostgresStorage when empty.
+	PostgresStorage string
+	// PostgresStorageClass is the storage class of the database volume; empty
+	// leaves it to the cluster default.
+	PostgresStorageClass string
+	// postgresPassword is generated by withDefaults.
+	postgresPassword string
+	// Redis also deploys a single-replica redis Deployment with a service,
+	// and passes REDIS_HOST and REDIS_PORT to the API container.
+	Redis bool
+	// RedisTag is the tag of the redis image, DefaultRedisTag when empty.
+	RedisTag string
+	// RedisMaxMemory is the memory redis caches in before evicting the
+	// least recently used keys, DefaultRedisMaxMemory when empty.
+	RedisMaxMemory string
+	// RedisAuth protects the cache with a generated password, stored in a
+	// secret and passed to the API container as REDIS_PASSWORD.
+	RedisAuth bool
+	// redisPassword is generated by withDefaults.
+	redisPassword string
+	// CronJobs are tasks run on a schedule in the API container's pod spec.
+	CronJobs []CronJob
+	// Migration, when set, runs a Job to completion before the API
+	// deployment is updated, and fails the deploy if the Job fails.
+	Migration *Migration
+	// appOwner is the EcommerceApp the controller deploys these options
+	// for, set by OptionsFromApp. It owns every object but the persistent
+	// volume claims.
+	appOwner *metav1.OwnerReference
+	// Manifests replace the built-in objects when set, and are applied one
+	// by one ordered by kind; see ApplyWeightAnnotation. Image and Replicas
+	// don't apply to them. Namespaced objects without a namespace go to
+	// Namespace; see LoadManifests.
+	Manifests []*unstructured.Unstructured
+	// ExtraManifests are applied after the built-in objects, one by one
+	// ordered as Manifests are, for the few objects a team needs beside
+	// them, such as a PriorityClass. They get the release's labels, are pruned, deleted and
+	// reported in Status with it, and are applied as given otherwise;
+	// cluster-scoped ones get no namespace. See LoadExtraManifests.
+	ExtraManifests []*unstructured.Unstructured
+	// Patches change the built-in objects or Manifests before they are
+	// applied, in order, so later ones win; see LoadOverlay. Built-in
+	// objects like nodeport-svc only exist, and can only be patched, when
+	// the options create them.
+	Patches []Patch
+	// MinKubeVersion is the oldest Kubernetes release CheckCompatibility
+	// accepts, e.g. 1.21. Empty means DefaultMinKubeVersion.
+	MinKubeVersion string
+	// MaxReplicas is the largest Replicas value Validate accepts.
+	MaxReplicas int32
+	// FieldManager is the field manager name used for server-side apply.
+	FieldManager string
+	// ForceConflicts takes ownership of fields that another manager owns
+	// instead of failing the apply.
+	ForceConflicts bool
+	// Typed builds the built-in objects from the Build* structs and applies
+	// them through the typed clientset instead of the dynamic client.
+	// Options.Manifests always go through the dynamic client.
+	Typed bool
+	// DryRun sends every write with the All dry-run directive, so the
+	// apiserver validates and defaults the objects without persisting them.
+	DryRun bool
+	// MaxConcurrency is how many built-in objects are applied at once
+	// within a step of DeployAll. Zero means DefaultMaxConcurrency; 1
+	// applies them one by one in order, which eases debugging.
+	MaxConcurrency int
+	// MaxRetries is how many times a request, through the dynamic client
+	// or the clientset, is retried after a transient failure: throttling,
+	// an apiserver timeout or outage, or a broken connection. Zero retries
+	// none.
+	MaxRetries int
+	// RetryBackoff is the delay before the first retry, doubling with each
+	// one; a longer Retry-After of the apiserver wins. Zero means
+	// DefaultRetryBackoff.
+	RetryBackoff time.Duration
+	// Logger receives progress messages at V(0), retries at V(1),
+	// per-request details at V(2) and failed requests as errors. Nil
+	// discards everything.
+	Logger logr.Logger
+}
+
+// Deployer deploys the ecommerce API resources using a dynamic client.
+type Deployer struct {
+	client dynamic.Interface
+	// kube is used for the few calls the dynamic client can't make, such as
+	// reading pod logs. It may be nil.
+	kube kubernetes.Interface
+	// retry retries the requests of kube, as the dynamic client retries
+	// its own.
+	retry *retrier
+	// config dials pods for port forwarding. It is only set by
+	// NewForConfig.
+	config *rest.Config
+	// mapper resolves the resource of each object from its apiVersion and
+	// kind.
+	mapper meta.RESTMapper
+	opts   Options
+	log    logr.Logger
+}
+
+// New returns a Deployer that talks to the cluster through client. Features
+// that need a typed clientset, like collecting pod logs, are unavailable; use
+// NewWithClientset or NewForConfig for those. Without discovery only the
+// kinds of the built-in objects can be resolved.
+func New(client dynamic.Interface, opts Options) *Deployer {
+	opts = opts.withDefaults()
+	retry := newRetrier(opts)
+	return &Deployer{client: withRetries(client, retry), retry: retry, mapper: staticRESTMapper(), opts: opts, log: opts.Logger}
+}
+
+// withDefaults returns o with every empty field set to its default.
+func (o Options) withDefaults() Options {
+	opts := o
+	if opts.Namespace == "" {
+		opts.Namespace = DefaultNamespace
+	}
+	if opts.FieldManager == "" {
+		opts.FieldManager = DefaultFieldManager
+	}
+	if opts.Image == "" {
+		opts.Image = DefaultImage
+	}
+	if opts.Replicas == nil {
+		replicas := DefaultReplicas
+		opts.Replicas = &replicas
+	}
+	if opts.ServiceType == "" {
+		opts.ServiceType = DefaultServiceType
+	}
+	if opts.Hosts == nil {
+		opts.Hosts = []string{DefaultHost}
+	}
+	if opts.Paths == nil {
+		opts.Paths = DefaultPaths
+	}
+	if opts.MaxReplicas == 0 {
+		opts.MaxReplicas = DefaultMaxReplicas
+	}
+	if opts.PostgresTag == "" {
+		opts.PostgresTag = DefaultPostgresTag
+	}
+	if opts.PostgresStorage == "" {
+		opts.PostgresStorage = DefaultPostgresStorage
+	}
+	if opts.Postgres && opts.postgresPassword == "" {
+		opts.postgresPassword = generatePassword()
+	}
+	if opts.RedisTag == "" {
+		opts.RedisTag = DefaultRedisTag
+	}
+	if opts.RedisMaxMemory == "" {
+		opts.RedisMaxMemory = DefaultRedisMaxMemory
+	}
+	if opts.RedisAuth && opts.redisPassword == "" {
+		opts.redisPassword = generatePassword()
+	}
+	if opts.ProbeType == "" {
+		opts.ProbeType = ProbeHTTP
+	}
+	if opts.HealthPath == "" {
+		opts.HealthPath = DefaultHealthPath
+	}
+	if opts.Logger == nil {
+		opts.Logger = logr.Discard()
+	}
+	return opts
+}
+
+// NewWithClientset returns a Deployer using both a dynamic client and a typed
+// clientset. Resources are resolved through the clientset's discovery client,
+// cached and rediscovered for kinds the cache doesn't know.
+func NewWithClientset(client dynamic.Interface, kube kubernetes.Interface, opts Options) *Deployer {
+	d := New(client, opts)
+	d.kube = kube
+	d.mapper = newDiscoveryMapper(kube.Discovery())
+	return d
+}
+
+// NewForConfig builds the clients from config and returns a Deployer using them.
+func NewForConfig(config *rest.Config, opts Options) (*Deployer, error) {
+	// The clients hand the warnings of each apply to Deploy.
+	clientConfig := rest.CopyConfig(config)
+	clientConfig.Wrap(func(rt http.RoundTripper) http.RoundTripper { return warningTransport{rt} })
+	client, err := dynamic.NewForConfig(clientConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build dynamic client: %w", err)
+	}
+	kube, err := kubernetes.NewForConfig(clientConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build clientset: %w", err)
+	}
+	d := NewWithClientset(client, kube, opts)
+	d.config = config
+	return d, nil
+}
+
+// Validate reports the first invalid setting in o. Empty fields are valid
+// and fall back to their defaults.
+func (o Options) Validate() error {
+	if o.Image != "" {
+		if _, err := ParseImageReference(o.Image); err != nil {
+			return &ValidationError{Field: "image", Err: err}
+		}
+	}
+	if o.Replicas != nil {
+		max := o.MaxReplicas
+		if max == 0 {
+			max = DefaultMaxReplicas
+		}
+		if *o.Replicas < 0 || *o.Replicas > max {
+			return &ValidationError{Field: "replicas", Err: fmt.Errorf("must be between 0 and %d, got %d", max, *o.Replicas)}
+		}
+	}
+	if err := o.validateRevisionHistoryLimit(); err != nil {
+		return err
+	}
+	if err := o.validateStrategy(); err != nil {
+		return err
+	}
+	if o.MaxConcurrency < 0 {
+		return &ValidationError{Field: "max-concurrency", Err: fmt.Errorf("must not be negative, got %d", o.MaxConcurrency)}
+	}
+	if o.MaxRetries < 0 {
+		return &ValidationError{Field: "max-retries", Err: fmt.Errorf("must not be negative, got %d", o.MaxRetries)}
+	}
+	if o.RetryBackoff < 0 {
+		return &ValidationError{Field: "retry-backoff", Err: fmt.Errorf("must not be negative, got %s", o.RetryBackoff)}
+	}
+	if err := o.validateMinKubeVersion(); err != nil {
+		return err
+	}
+	if err := o.validateOwner(); err != nil {
+		return err
+	}
+	if err := o.validateRelease(); err != nil {
+		return err
+	}
+	if err := validateServiceType(o.ServiceType); err != nil {
+		return err
+	}
+	if err := o.validateNodePort(); err != nil {
+		return err
+	}
+	if err := o.validateTrafficPolicy(); err != nil {
+		return err
+	}
+	if err := o.validateLoadBalancer(); err != nil {
+		return err
+	}
+	if o.Hosts != nil && len(o.Hosts) == 0 {
+		return &ValidationError{Field: "host", Err: errors.New("at least one host is needed")}
+	}
+	if err := validateHosts(o.Hosts); err != nil {
+		return err
+	}
+	if err := o.validatePorts(); err != nil {
+		return err
+	}
+	if err := o.validateExternalNameServices(); err != nil {
+		return err
+	}
+	if o.Paths != nil && len(o.Paths) == 0 {
+		return &ValidationError{Field: "path", Err: errors.New("at least one path is needed")}
+	}
+	if err := o.validatePaths(); err != nil {
+		return err
+	}
+	if err := o.validateIngressAnnotations(); err != nil {
+		return err
+	}
+	if err := o.withDefaults().validateRouting(); err != nil {
+		return err
+	}
+	if err := o.validateMetrics(); err != nil {
+		return err
+	}
+	if err := o.validateConfig(); err != nil {
+		return err
+	}
+	if err := o.withDefaults().validateAutoscale(); err != nil {
+		return err
+	}
+	if err := o.withDefaults().validatePDB(); err != nil {
+		return err
+	}
+	if err := o.validateNetworkPolicy(); err != nil {
+		return err
+	}
+	if err := o.withDefaults().validateProbes(); err != nil {
+		return err
+	}
+	if err := o.validateResources(); err != nil {
+		return err
+	}
+	if err := o.validateSecurity(); err != nil {
+		return err
+	}
+	if err := o.validateScheduling(); err != nil {
+		return err
+	}
+	if err := o.validateTopologySpread(); err != nil {
+		return err
+	}
+	if err := o.validateContainers(); err != nil {
+		return err
+	}
+	if err := o.validateRBAC(); err != nil {
+		return err
+	}
+	if err := o.validatePVCs(); err != nil {
+		return err
+	}
+	if err := o.withDefaults().validatePostgres(); err != nil {
+		return err
+	}
+	if err := o.withDefaults().validateRedis(); err != nil {
+		return err
+	}
+	if err := o.validateMigration(); err != nil {
+		return err
+	}
+	if err := o.withDefaults().validateCronJobs(); err != nil {
+		return err
+	}
+	if err := o.validateLabels(); err != nil {
+		return err
+	}
+	if err := o.validateImagePullSecrets(); err != nil {
+		return err
+	}
+	if err := o.validateEnv(); err != nil {
+		return err
+	}
+	if err := o.validateDBCredentials(); err != nil {
+		return err
+	}
+	if err := o.validateTLS(); err != nil {
+		return err
+	}
+	if err := validateManifests("manifests", o.Manifests, o.withDefaults().Namespace); err != nil {
+		return err
+	}
+	if len(o.ExtraManifests) > 0 && len(o.Manifests) > 0 {
+		return &ValidationError{Field: "extra-manifests", Err: errors.New("extra manifests go beside the built-in objects; add them to -manifests instead")}
+	}
+	if err := validateManifests("extra-manifests", o.ExtraManifests, o.withDefaults().Namespace); err != nil {
+		return err
+	}
+	// The objects are only built once every option is valid.
+	if len(o.Patches) > 0 {
+		if _, err := applyPatches(baseObjects(o.withDefaults()), o.Patches); err != nil {
+			return &ValidationError{Field: "overlay", Err: err}
+		}
+	}
+	return nil
+}
+
+// SkippedObject is a built-in object that is left out because the cluster
+// doesn't serve its API.
+type SkippedObject struct {
+	Kind   string
+	Name   string
+	Reason error
+}
+
+// Skipped returns the built-in objects DeployAll leaves out, such as the
+// ingress on clusters that serve no Ingress API.
+func (d *Deployer) Skipped() ([]SkippedObject, error) {
+	_, skipped, err := d.servedObjects()
+	return skipped, err
+}
+
+// Namespace returns the namespace the Deployer manages resources in.
+func (d *Deployer) Namespace() string {
+	return d.opts.Namespace
+}
+
+// DeploymentName returns the name of the API deployment.
+func (d *Deployer) DeploymentName() string {
+	if dep := apiDeployment(d.opts); dep != nil {
+		return dep.GetName()
+	}
+	return d.opts.deploymentName()
+}
+
+// ServiceName returns the name of the service in front of the API pods.
+func (d *Deployer) ServiceName() string {
+	return d.opts.serviceName()
+}
+
+// DeployAll applies the deployment, the server-svc service, the nodeport-svc
+// service with Options.ExposeNodePort, and the ingress, or Options.Manifests
+// ordered by kind when set. The built-in objects are applied in steps,
+// config before the workloads and services before the ingress, each step's
+// objects concurrently, and Options.ExtraManifests one by one after them.
+// It stops at the first failing step and returns the objects applied so far
+// along with an *ApplyError.
+// Built-in objects the cluster can't serve are left out; see Skipped. The
+// ingress is applied as networking.k8s.io/v1beta1 when that is the only
+// version served. With Options.CreateNamespace the namespace is created
+// first and included in the result. With Options.Owner the owner is applied
+// before the objects it owns, and the returned dependents carry its
+// ownerReference.
+func (d *Deployer) DeployAll(ctx context.Context) ([]*unstructured.Unstructured, error) {
+	return d.deploy(ctx, nil, nil)
+}
+
+// deploy applies the objects DeployAll does for which include returns true,
+// all of them when it is nil, after passing each to adjust, if set. The
+// owners of the objects left out are looked up live, so those applied keep
+// their ownerReferences.
+func (d *Deployer) deploy(ctx context.Context, include func(object) bool, adjust func(*unstructured.Unstructured)) ([]*unstructured.Unstructured, error) {
+	if err := d.opts.Validate(); err != nil {
+		return nil, err
+	}
+	objects, err := d.objects()
+	if err != nil {
+		return nil, err
+	}
+	if err := d.checkCertManager(); err != nil {
+		return nil, err
+	}
+	if err := d.checkAutoscaling(); err != nil {
+		return nil, err
+	}
+	if err := d.checkNodePort(ctx, objects); err != nil {
+		return nil, err
+	}
+	d.warnUncoveredHosts()
+	d.warnBlockingPDB()
+	d.warnTopologySpread(ctx)
+
+	var applied []*unstructured.Unstructured
+	ns, err := d.EnsureNamespace(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if ns != nil {
+		applied = append(applied, ns)
+	}
+
+	done, err := d.applySteps(ctx, objects, include, adjust)
+	return append(applied, done...), err
+}
+
+func (d *Deployer) resource(gvr schema.GroupVersionResource) dynamic.ResourceInterface {
+	return d.client.Resource(gvr).Namespace(d.opts.Namespace)
+}
+
+// desired returns the object to apply for o, adjusted to the live state of
+// the cluster: an HPA's replica count is kept and the built-in ingress gets
+// the default IngressClass. Options.ExtraManifests are applied as given.
+func (d *Deployer) desired(ctx context.Context, o object) (*unstructured.Unstructured, error) {
+	builtin := len(d.opts.Manifests) == 0
+	switch {
+	case o.extra:
+		return o.obj, nil
+	case o.gvr.GroupResource() == DeploymentResource.GroupResource() && o.obj.GetName() == d.opts.redisName() && d.opts.Redis && builtin:
+		return desiredRedisDeployment(o.obj), nil
+	case o.gvr.GroupResource() == DeploymentResource.GroupResource():
+		return d.desiredDeployment(ctx, o.obj)
+	case o.obj.GetKind() == "Ingress" && builtin:
+		return d.desiredIngress(ctx, o.obj)
+	case o.gvr == SecretResource && o.obj.GetName() == d.opts.postgresSecretName() && builtin:
+		return d.keepLivePassword(ctx, o.obj, "POSTGRES_PASSWORD")
+	case o.gvr == SecretResource && o.obj.GetName() == d.opts.redisSecretName() && builtin:
+		return d.keepLivePassword(ctx, o.obj, redisPasswordKey)
+	}
+	return o.obj, nil
+}
+
+// resourceFor returns the client for obj's resource, which is cluster-scoped
+// when obj has no namespace.
+func (d *Deployer) resourceFor(gvr schema.GroupVersionResource, obj *unstructured.Unstructured) dynamic.ResourceInterface {
+	if obj.GetNamespace() == "" {
+		return d.client.Resource(gvr)
+	}
+	return d.resource(gvr)
+}
+
+// object pairs a desired object with the resource it is served under.
+type object struct {
+	gvr schema.GroupVersionResource
+	obj *unstructured.Unstructured
+	// extra marks the objects of Options.ExtraManifests.
+	extra bool
+	// definedBy is the CustomResourceDefinition among the objects that gvr
+	// was resolved through, when the cluster didn't serve it yet.
+	definedBy string
+}
+
+// Render returns the objects a Deployer built from opts would apply, without
+// contacting a cluster. Kinds outside the built-in set are returned with the
+// namespace they were given, since their scope can't be looked up offline,
+// unless a CustomResourceDefinition among the objects defines them.
+func Render(opts Options) ([]*unstructured.Unstructured, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	opts = opts.withDefaults()
+	mapper := staticRESTMapper()
+	objects := sourceObjects(opts)
+	for _, obj := range objects {
+		gvk := obj.GroupVersionKind()
+		if mapping, err := mapper.RESTMapping(gvk.GroupKind(), gvk.Version); err == nil {
+			scope(obj, mapping, opts.Namespace)
+		} else if mapping, _ := definedMapping(objects, obj); mapping != nil {
+			scope(obj, mapping, opts.Namespace)
+		}
+	}
+	return objects, nil
+}
+
+// dryRun returns the dry-run directives to send with writes.
+func (d *Deployer) dryRun() []string {
+	if d.opts.DryRun {
+		return []string{metav1.DryRunAll}
+	}
+	return nil
+}
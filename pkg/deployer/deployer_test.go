@@ -0,0 +1,166 @@
+package deployer
+
+import (
+	"context"
+	"errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	clienttesting "k8s.io/client-go/testing"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// testOptions are the options of the fake client tests: the built-in
+// objects in the shop namespace, applied one by one so the order of the
+// requests is stable.
+func testOptions() Options {
+	return Options{Namespace: "shop", IngressClass: "nginx", MaxConcurrency: 1}
+}
+
+func shopNamespace() runtime.Object {
+	return unstructuredObject("v1", "Namespace", "", "shop")
+}
+
+func TestDeployAllCreates(t *testing.T) {
+	d, client := newFakeDeployer(testOptions(), shopNamespace())
+	applied, err := d.DeployAll(context.Background())
+	if err != nil {
+		t.Fatalf("DeployAll: %v", err)
+	}
+
+	want := []string{
+		"patch deployments shop/apiserver",
+		"patch ingresses shop/server-ingress",
+		"patch serviceaccounts shop/server-sa",
+		"patch services shop/server-svc",
+	}
+	got := writes(client)
+	sort.Strings(got)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("writes:\ngot  %q\nwant %q", got, want)
+	}
+	for _, w := range []struct {
+		obj  *unstructured.Unstructured
+		kind string
+	}{
+		{liveObject(t, client, DeploymentResource, "shop", "apiserver"), "Deployment"},
+		{liveObject(t, client, ServiceResource, "shop", "server-svc"), "Service"},
+		{liveObject(t, client, IngressResource, "shop", "server-ingress"), "Ingress"},
+		{liveObject(t, client, ServiceAccountResource, "shop", "server-sa"), "ServiceAccount"},
+	} {
+		if w.obj.GetKind() != w.kind || w.obj.GetNamespace() != "shop" {
+			t.Errorf("%s: got kind %s in namespace %q", w.obj.GetName(), w.obj.GetKind(), w.obj.GetNamespace())
+		}
+	}
+	if len(applied) != len(want) {
+		t.Errorf("got %d applied objects, want %d", len(applied), len(want))
+	}
+}
+
+func TestDeployAllUpdates(t *testing.T) {
+	live := unstructuredObject("apps/v1", "Deployment", "shop", "apiserver")
+	live.SetUID("uid-live")
+	live.SetResourceVersion("7")
+	_ = unstructured.SetNestedField(live.Object, int64(5), "spec", "replicas")
+	d, client := newFakeDeployer(testOptions(), shopNamespace(), live)
+
+	if _, err := d.DeployAll(context.Background()); err != nil {
+		t.Fatalf("DeployAll: %v", err)
+	}
+	if hasPrefix(writes(client), "create deployments") {
+		t.Error("the existing deployment was created again")
+	}
+	dep := liveObject(t, client, DeploymentResource, "shop", "apiserver")
+	if dep.GetUID() != "uid-live" || dep.GetResourceVersion() != "8" {
+		t.Errorf("got uid %s, resourceVersion %s; want the live object updated", dep.GetUID(), dep.GetResourceVersion())
+	}
+	replicas, _, _ := unstructured.NestedInt64(dep.Object, "spec", "replicas")
+	if replicas != int64(DefaultReplicas) {
+		t.Errorf("got %d replicas, want %d", replicas, DefaultReplicas)
+	}
+}
+
+func TestDeployAllForbidden(t *testing.T) {
+	d, client := newFakeDeployer(testOptions(), shopNamespace())
+	client.PrependReactor("patch", "deployments", func(clienttesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewForbidden(DeploymentResource.GroupResource(), "apiserver", errors.New("RBAC denied"))
+	})
+
+	applied, err := d.DeployAll(context.Background())
+	if err == nil {
+		t.Fatal("DeployAll succeeded")
+	}
+	var deployErr *DeployError
+	if !errors.As(err, &deployErr) {
+		t.Fatalf("got %T %v, want a *DeployError", err, err)
+	}
+	if deployErr.GVR != DeploymentResource || deployErr.Name != "apiserver" || deployErr.Op != OpApply || deployErr.Namespace != "shop" {
+		t.Errorf("got %+v", deployErr)
+	}
+	if !apierrors.IsForbidden(err) {
+		t.Errorf("apierrors.IsForbidden(%v) is false", err)
+	}
+	var applyErr *ApplyError
+	if !errors.As(err, &applyErr) || !reflect.DeepEqual(applyErr.NotApplied, []string{"Service/server-svc", "Ingress/server-ingress"}) {
+		t.Errorf("got %v, want the service and ingress left unapplied", err)
+	}
+	if hasPrefix(writes(client), "patch ingresses") {
+		t.Error("the ingress was applied after the failure")
+	}
+	for _, obj := range applied {
+		if obj.GetKind() == "Deployment" {
+			t.Error("the deployment is reported applied")
+		}
+	}
+}
+
+func TestDeployNotApplied(t *testing.T) {
+	d, client := newFakeDeployer(testOptions(), shopNamespace())
+	client.PrependReactor("patch", "deployments", func(clienttesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewForbidden(DeploymentResource.GroupResource(), "apiserver", errors.New("RBAC denied"))
+	})
+
+	result, err := d.Deploy(context.Background(), DeployOptions{})
+	if err == nil {
+		t.Fatal("Deploy succeeded")
+	}
+	if result.Error != err.Error() {
+		t.Errorf("got error %q in the result, want %q", result.Error, err)
+	}
+	if want := []string{"Service/server-svc", "Ingress/server-ingress"}; !reflect.DeepEqual(result.NotApplied, want) {
+		t.Errorf("got not applied %q, want %q", result.NotApplied, want)
+	}
+	if len(result.Applied) != 1 || result.Applied[0].Kind != "ServiceAccount" {
+		t.Errorf("got applied %+v, want only the service account", result.Applied)
+	}
+}
+
+func TestDeleteAllReverseOrder(t *testing.T) {
+	d, client := newFakeDeployer(testOptions(), shopNamespace())
+	if _, err := d.DeployAll(context.Background()); err != nil {
+		t.Fatalf("DeployAll: %v", err)
+	}
+	var applied []string
+	for _, w := range writes(client) {
+		applied = append(applied, w[len("patch "):])
+	}
+	client.ClearActions()
+
+	for _, r := range d.DeleteAll(context.Background(), DeleteOptions{}) {
+		if r.Outcome != Deleted {
+			t.Errorf("%s %s: %s %v", r.Kind, r.Name, r.Outcome, r.Err)
+		}
+	}
+	var deleted []string
+	for _, w := range writes(client) {
+		deleted = append(deleted, w[len("delete "):])
+	}
+	for i, j := 0, len(applied)-1; i < j; i, j = i+1, j-1 {
+		applied[i], applied[j] = applied[j], applied[i]
+	}
+	if !reflect.DeepEqual(deleted, applied) {
+		t.Errorf("deleted in order:\n%q\nwant the reverse of the apply order:\n%q", deleted, applied)
+	}
+}
@@ -0,0 +1,171 @@
+package deployer
+
+import (
+	"context"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// CreateDeployment creates the apiserver deployment running the ecommerce API,
+// or updates it to the desired spec if it already exists. When an HPA scales
+// the existing deployment, spec.replicas is left to the autoscaler.
+func (d *Deployer) CreateDeployment(ctx context.Context) (*unstructured.Unstructured, error) {
+	obj, err := d.desiredDeployment(ctx, newDeployment(d.opts))
+	if err != nil {
+		return nil, err
+	}
+	return d.apply(ctx, DeploymentResource, obj)
+}
+
+// desiredDeployment returns the deployment obj to apply, taking the live
+// state into account: replicas are omitted when an HPA scales the deployment.
+// The checksums of the config maps and secrets its environment reads are
+// stamped on the pod template, and with StrategyRecreate the rollingUpdate
+// settings are cleared.
+func (d *Deployer) desiredDeployment(ctx context.Context, obj *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	hpa, err := d.scalingHPA(ctx, obj.GetName())
+	if err != nil {
+		return nil, err
+	}
+	if hpa != "" {
+		d.log.Info("Scaling is delegated to the HorizontalPodAutoscaler, leaving replicas untouched",
+			"namespace", d.opts.Namespace, "deployment", obj.GetName(), "hpa", hpa)
+		unstructured.RemoveNestedField(obj.Object, "spec", "replicas")
+	}
+	if len(d.opts.Manifests) == 0 {
+		if err := d.stampReferencedChecksums(ctx, obj); err != nil {
+			return nil, err
+		}
+	}
+	if d.opts.Strategy == StrategyRecreate && len(d.opts.Manifests) == 0 {
+		// Clear the rollingUpdate the apiserver defaulted for the previous
+		// strategy, which Recreate doesn't allow.
+		_ = unstructured.SetNestedField(obj.Object, nil, "spec", "strategy", "rollingUpdate")
+	}
+	return obj, nil
+}
+
+// scalingHPA returns the name of the HPA scaling the existing deployment
+// called name, or "" if the deployment doesn't exist yet or no HPA targets it.
+func (d *Deployer) scalingHPA(ctx context.Context, name string) (string, error) {
+	_, err := d.resource(DeploymentResource).Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", d.opError(OpGet, DeploymentResource, name, err)
+	}
+	if hpa := newHPA(d.opts); hpa != nil && len(d.opts.Manifests) == 0 {
+		// The HPA is applied after the deployment, so it may not exist yet,
+		// unless it is skipped.
+		if served, err := d.servesHPA(); err != nil || !served {
+			return "", err
+		}
+		return hpa.GetName(), nil
+	}
+
+	hpas, err := d.resource(HPAResource).List(ctx, metav1.ListOptions{})
+	if apierrors.IsNotFound(err) {
+		// autoscaling/v2 isn't served, so nothing can be scaling it through v2.
+		return "", nil
+	}
+	if err != nil {
+		return "", d.opError(OpList, HPAResource, "", err)
+	}
+	for _, hpa := range hpas.Items {
+		ref, _, _ := unstructured.NestedStringMap(hpa.Object, "spec", "scaleTargetRef")
+		if ref["kind"] == "Deployment" && ref["name"] == name {
+			return hpa.GetName(), nil
+		}
+	}
+	return "", nil
+}
+
+// apiDeployment returns the deployment running the API: the built-in one, or
+// the first Deployment in Options.Manifests. It is nil if the manifests hold
+// none.
+func apiDeployment(opts Options) *unstructured.Unstructured {
+	builtin := len(opts.Manifests) == 0
+	for _, obj := range sourceObjects(opts) {
+		if obj.GetKind() == "Deployment" && (!builtin || obj.GetName() == opts.deploymentName()) {
+			return obj
+		}
+	}
+	return nil
+}
+
+// apiContainerName is the container running the API in the deployment.
+const apiContainerName = "ecommerce"
+
+// newDeployment renders the embedded deployment. The settings taken from
+// Options are applied to the typed object by customizeDeployment, which
+// BuildDeployment shares, so both builders stay in step.
+func newDeployment(opts Options) *unstructured.Unstructured {
+	var dep appsv1.Deployment
+	decodeDefaultManifest("deployment.yaml", &dep)
+	customizeDeployment(&dep, opts)
+	return mustToUnstructured(&dep)
+}
+
+// customizeDeployment applies opts to the API deployment.
+func customizeDeployment(dep *appsv1.Deployment, opts Options) {
+	dep.Name = opts.deploymentName()
+	dep.Namespace = opts.Namespace
+	dep.Annotations = merge(dep.Annotations, map[string]string{RoutingAnnotation: opts.routing()})
+	if s := opts.VerifiedSignature; s != nil {
+		dep.Annotations = merge(dep.Annotations, map[string]string{SignatureDigestAnnotation: s.Digest, SignatureSignerAnnotation: s.Signer})
+	}
+	dep.Spec.Selector = &metav1.LabelSelector{MatchLabels: opts.selector(componentAPI)}
+	dep.Spec.Template.Labels = opts.selector(componentAPI)
+	replicas := *opts.Replicas
+	dep.Spec.Replicas = &replicas
+	dep.Spec.RevisionHistoryLimit = opts.RevisionHistoryLimit
+	wireStrategy(dep, opts)
+	c := apiContainer(&dep.Spec.Template.Spec)
+	c.Image = opts.Image
+	if opts.PinnedFrom != "" {
+		dep.Spec.Template.Annotations = merge(dep.Spec.Template.Annotations, map[string]string{PinnedFromAnnotation: opts.PinnedFrom})
+	}
+	wireConfig(dep, opts)
+	wireDBSecret(dep, opts)
+	wireSecretChecksum(dep, opts)
+	wirePostgres(dep, opts)
+	wireRedis(dep, opts)
+	wirePVCs(dep, opts)
+	wireServiceAccount(dep, opts)
+	wireImagePullSecrets(dep, opts)
+	wireResources(dep, opts)
+	wirePorts(dep, opts)
+	wireProbes(dep, opts)
+	wireEnv(dep, opts)
+	wireMetrics(dep, opts)
+	wireContainers(dep, opts)
+	wireScheduling(dep, opts)
+	wireTopologySpread(dep, opts)
+	wireSecurity(dep, opts)
+}
+
+// apiContainer returns the API container of a pod spec the deployer built,
+// which always has one. Use findAPIContainer for specs read from the
+// cluster.
+func apiContainer(spec *corev1.PodSpec) *corev1.Container {
+	c := findAPIContainer(spec)
+	if c == nil {
+		panic("the deployment has no " + apiContainerName + " container")
+	}
+	return c
+}
+
+// findAPIContainer returns the API container of a pod spec, or nil when it
+// has none, as when someone renamed it.
+func findAPIContainer(spec *corev1.PodSpec) *corev1.Container {
+	for i := range spec.Containers {
+		if spec.Containers[i].Name == apiContainerName {
+			return &spec.Containers[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,289 @@
+package deployer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"os"
+	"path/filepath"
+	"sigs.k8s.io/yaml"
+	"sort"
+	"time"
+)
+
+const (
+	// diagnosticsLogLines is how many log lines of each container go into
+	// a diagnostics bundle.
+	diagnosticsLogLines int64 = 200
+	// maxDiagnosticsEvents is how many of the most recent namespace events
+	// go into a diagnostics bundle.
+	maxDiagnosticsEvents = 500
+)
+
+// The kinds of the files of a diagnostics bundle.
+const (
+	DiagnosticsDesired = "desired"
+	DiagnosticsLive    = "live"
+	DiagnosticsPod     = "pod"
+	DiagnosticsLogs    = "logs"
+	DiagnosticsEvents  = "events"
+	DiagnosticsToolLog = "tool-log"
+)
+
+// DiagnosticsOptions configures CollectDiagnostics.
+type DiagnosticsOptions struct {
+	// Err is the failure the bundle is collected for, recorded in the
+	// index; nil for a bundle taken on demand.
+	Err error
+	// ToolLog is the log of the tool itself, written as tool.log when set.
+	ToolLog []byte
+}
+
+// DiagnosticsIndex is the index.json of a diagnostics bundle.
+type DiagnosticsIndex struct {
+	CollectedAt time.Time         `json:"collectedAt"`
+	Namespace   string            `json:"namespace"`
+	Release     string            `json:"release"`
+	Error       string            `json:"error,omitempty"`
+	Files       []DiagnosticsFile `json:"files"`
+	// Failures are the parts of the bundle that couldn't be collected, such
+	// as the logs of a container that never started.
+	Failures []string `json:"failures,omitempty"`
+}
+
+// DiagnosticsFile is a file of a diagnostics bundle.
+type DiagnosticsFile struct {
+	// Path is relative to the bundle directory.
+	Path string `json:"path"`
+	// Kind is one of DiagnosticsDesired, DiagnosticsLive, DiagnosticsPod,
+	// DiagnosticsLogs, DiagnosticsEvents or DiagnosticsToolLog.
+	Kind string `json:"kind"`
+	// Object is the kind and name of the object the file is about.
+	Object string `json:"object,omitempty"`
+}
+
+// diagnosticsBundle writes the files of a bundle and records them.
+type diagnosticsBundle struct {
+	dir   string
+	index DiagnosticsIndex
+}
+
+// write writes data to path under the bundle directory and records it.
+func (b *diagnosticsBundle) write(path, kind, object string, data []byte) error {
+	full := filepath.Join(b.dir, filepath.FromSlash(path))
+	if err := os.MkdirAll(filepath.Dir(full), 0o755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(full, data, 0o644); err != nil {
+		return err
+	}
+	b.index.Files = append(b.index.Files, DiagnosticsFile{Path: path, Kind: kind, Object: object})
+	return nil
+}
+
+// writeObject writes obj as YAML, redacted and without its managed fields.
+func (b *diagnosticsBundle) writeObject(path, kind string, obj *unstructured.Unstructured) error {
+	obj = redactDiagnostics(obj)
+	unstructured.RemoveNestedField(obj.Object, "metadata", "managedFields")
+	data, err := yaml.Marshal(obj.Object)
+	if err != nil {
+		return err
+	}
+	return b.write(path, kind, obj.GetKind()+"/"+obj.GetName(), data)
+}
+
+// fail records a part that couldn't be collected.
+func (b *diagnosticsBundle) fail(format string, args ...interface{}) {
+	b.index.Failures = append(b.index.Failures, fmt.Sprintf(format, args...))
+}
+
+// CollectDiagnostics writes a bundle for debugging a failed deploy to dir:
+// the desired objects under desired/, the objects as the cluster has them
+// under live/, each pod of the release and the last lines of its
+// containers' logs under pods/, the recent events of the namespace as
+// events.txt, DiagnosticsOptions.ToolLog as tool.log, and an index.json of
+// them all. Secret values, and the keys of Routes, are redacted.
+//
+// Collecting is best effort: what can't be read is recorded in the
+// index's Failures and the rest is still written. Only failing to write
+// the bundle is returned.
+func (d *Deployer) CollectDiagnostics(ctx context.Context, dir string, opts DiagnosticsOptions) (*DiagnosticsIndex, error) {
+	b := &diagnosticsBundle{dir: dir, index: DiagnosticsIndex{
+		CollectedAt: time.Now().UTC(),
+		Namespace:   d.opts.Namespace,
+		Release:     d.opts.release(),
+	}}
+	if opts.Err != nil {
+		b.index.Error = opts.Err.Error()
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create the diagnostics directory: %w", err)
+	}
+	d.log.Info("Collecting diagnostics", "namespace", d.opts.Namespace, "dir", dir)
+
+	steps := []func(context.Context, *diagnosticsBundle) error{d.diagnoseObjects, d.diagnosePods, d.diagnoseEvents}
+	for _, step := range steps {
+		if err := step(ctx, b); err != nil {
+			return nil, err
+		}
+	}
+	if len(opts.ToolLog) > 0 {
+		if err := b.write("tool.log", DiagnosticsToolLog, "", opts.ToolLog); err != nil {
+			return nil, err
+		}
+	}
+
+	data, err := json.MarshalIndent(b.index, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(dir, "index.json"), append(data, '\n'), 0o644); err != nil {
+		return nil, err
+	}
+	return &b.index, nil
+}
+
+// diagnoseObjects writes every managed object as desired and, when it
+// exists, as live.
+func (d *Deployer) diagnoseObjects(ctx context.Context, b *diagnosticsBundle) error {
+	objects, err := d.objects()
+	if err != nil {
+		b.fail("desired objects: %v", err)
+		return nil
+	}
+	for _, o := range objects {
+		name := o.gvr.GroupResource().String() + "." + o.obj.GetName() + ".yaml"
+		if err := b.writeObject("desired/"+name, DiagnosticsDesired, o.obj); err != nil {
+			return err
+		}
+		live, err := d.resourceFor(o.gvr, o.obj).Get(ctx, o.obj.GetName(), metav1.GetOptions{})
+		switch {
+		case apierrors.IsNotFound(err):
+			continue
+		case err != nil:
+			b.fail("live %s %s: %v", o.obj.GetKind(), o.obj.GetName(), err)
+			continue
+		}
+		if err := b.writeObject("live/"+name, DiagnosticsLive, live); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// diagnosePods writes each pod of the release, status included, with the
+// last lines of its containers' logs, and of their previous run when they
+// restarted.
+func (d *Deployer) diagnosePods(ctx context.Context, b *diagnosticsBundle) error {
+	list, err := d.resource(PodResource).List(ctx, metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(map[string]string{InstanceLabel: d.opts.release(), ManagedByLabel: ManagedBy}).String(),
+	})
+	if err != nil {
+		b.fail("pods: %v", d.opError(OpList, PodResource, "", err))
+		return nil
+	}
+	for i := range list.Items {
+		item := &list.Items[i]
+		dir := "pods/" + item.GetName() + "/"
+		if err := b.writeObject(dir+"pod.yaml", DiagnosticsPod, item); err != nil {
+			return err
+		}
+		var pod corev1.Pod
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(item.Object, &pod); err != nil {
+			b.fail("pod %s: %v", item.GetName(), err)
+			continue
+		}
+		if d.kube == nil {
+			continue
+		}
+		statuses := append(pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses...)
+		for _, cs := range statuses {
+			if cs.State.Waiting != nil && cs.LastTerminationState.Terminated == nil {
+				continue
+			}
+			if err := d.diagnoseLogs(ctx, b, pod.Name, cs.Name, false); err != nil {
+				return err
+			}
+			if cs.RestartCount > 0 {
+				if err := d.diagnoseLogs(ctx, b, pod.Name, cs.Name, true); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if d.kube == nil && len(list.Items) > 0 {
+		b.fail("logs: reading logs needs a clientset")
+	}
+	return nil
+}
+
+// diagnoseLogs writes the last lines of the logs of a container, or of its
+// previous run.
+func (d *Deployer) diagnoseLogs(ctx context.Context, b *diagnosticsBundle, pod, container string, previous bool) error {
+	tail := diagnosticsLogLines
+	var data []byte
+	err := d.retry.do(ctx, OpGet, PodResource, pod, func() (err error) {
+		data, err = d.kube.CoreV1().Pods(d.opts.Namespace).GetLogs(pod, &corev1.PodLogOptions{
+			Container: container,
+			Previous:  previous,
+			TailLines: &tail,
+		}).DoRaw(ctx)
+		return err
+	})
+	name := container
+	if previous {
+		name += ".previous"
+	}
+	if err != nil {
+		b.fail("logs of %s/%s: %v", pod, name, err)
+		return nil
+	}
+	return b.write("pods/"+pod+"/"+name+".log", DiagnosticsLogs, "Pod/"+pod, data)
+}
+
+// diagnoseEvents writes the most recent events of the namespace, oldest
+// first.
+func (d *Deployer) diagnoseEvents(ctx context.Context, b *diagnosticsBundle) error {
+	list, err := d.resource(EventResource).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		b.fail("events: %v", d.opError(OpList, EventResource, "", err))
+		return nil
+	}
+	events := make([]corev1.Event, 0, len(list.Items))
+	for _, item := range list.Items {
+		var ev corev1.Event
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(item.Object, &ev); err != nil {
+			b.fail("event %s: %v", item.GetName(), err)
+			continue
+		}
+		events = append(events, ev)
+	}
+	sort.SliceStable(events, func(i, j int) bool { return eventTime(events[i]).Before(eventTime(events[j])) })
+	if len(events) > maxDiagnosticsEvents {
+		events = events[len(events)-maxDiagnosticsEvents:]
+	}
+	var data []byte
+	for _, ev := range events {
+		data = append(data, fmt.Sprintf("%s %s %s/%s %s (x%d): %s\n", eventTime(ev).UTC().Format(time.RFC3339),
+			ev.Type, ev.InvolvedObject.Kind, ev.InvolvedObject.Name, ev.Reason, ev.Count, ev.Message)...)
+	}
+	return b.write("events.txt", DiagnosticsEvents, "", data)
+}
+
+// redactDiagnostics returns obj with its secret values redacted as Redact
+// does, and with the private key a Route embeds redacted too.
+func redactDiagnostics(obj *unstructured.Unstructured) *unstructured.Unstructured {
+	obj = Redact(obj).DeepCopy()
+	if obj.GroupVersionKind().GroupKind() == openShiftRouteGroupKind {
+		if _, ok, _ := unstructured.NestedString(obj.Object, "spec", "tls", "key"); ok {
+			_ = unstructured.SetNestedField(obj.Object, Redacted, "spec", "tls", "key")
+		}
+	}
+	return obj
+}
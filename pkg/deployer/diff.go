@@ -0,0 +1,124 @@
+package deployer
+
+import (
+	"context"
+	"fmt"
+	"github.com/raihankhan/ecommerceApi-client-go/pkg/diff"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+)
+
+// serverManagedFields are dropped from both sides of a diff since the
+// apiserver and controllers own them.
+var serverManagedFields = [][]string{
+	{"status"},
+	{"metadata", "managedFields"},
+	{"metadata", "resourceVersion"},
+	{"metadata", "uid"},
+	{"metadata", "generation"},
+	{"metadata", "creationTimestamp"},
+	{"metadata", "selfLink"},
+	{"metadata", "annotations", "deployment.kubernetes.io/revision"},
+	{"spec", "clusterIP"},
+	{"spec", "clusterIPs"},
+}
+
+// ObjectDiff is the difference between a live object and its desired state.
+type ObjectDiff struct {
+	Kind string
+	Name string
+	// Exists is false when the object isn't in the cluster yet, in which case
+	// Diff shows the whole object as an addition.
+	Exists bool
+	// Diff is a unified diff from the live to the desired object, or "" when
+	// they match.
+	Diff string
+}
+
+// Diff compares every managed object in the cluster with its desired state.
+//
+// The desired state is what a server-side dry-run apply returns, so fields
+// the apiserver defaults don't show up as changes. Fields owned by the
+// apiserver and controllers, such as status and resourceVersion, are ignored.
+func (d *Deployer) Diff(ctx context.Context) ([]ObjectDiff, error) {
+	if err := d.opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	dry := *d
+	dry.opts.DryRun = true
+
+	objects, err := d.objects()
+	if err != nil {
+		return nil, err
+	}
+
+	var diffs []ObjectDiff
+	for _, o := range objects {
+		desired, err := d.desired(ctx, o)
+		if err != nil {
+			return nil, err
+		}
+
+		live, err := d.resourceFor(o.gvr, desired).Get(ctx, desired.GetName(), metav1.GetOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return nil, d.opError(OpGet, o.gvr, desired.GetName(), err)
+		}
+		exists := err == nil
+
+		merged, err := dry.apply(ctx, o.gvr, desired)
+		switch {
+		case err == nil:
+			desired = merged
+		case apierrors.IsInvalid(err) && exists, apierrors.IsNotFound(err) && !exists:
+			// Changes that need a recreate, like a service type change, are
+			// rejected by the dry run, as is anything in a namespace that
+			// doesn't exist yet; compare against the rendered object.
+			d.log.V(1).Info("Dry-run apply rejected, diffing the rendered object", "kind", desired.GetKind(), "name", desired.GetName())
+		default:
+			return nil, err
+		}
+
+		if !exists {
+			live = nil
+		}
+		live, desired = redactPair(live, desired)
+		liveYAML := ""
+		if exists {
+			if liveYAML, err = diffYAML(live); err != nil {
+				return nil, err
+			}
+		}
+		desiredYAML, err := diffYAML(desired)
+		if err != nil {
+			return nil, err
+		}
+
+		ref := fmt.Sprintf("%s.%s", o.gvr.GroupResource(), desired.GetName())
+		diffs = append(diffs, ObjectDiff{
+			Kind:   desired.GetKind(),
+			Name:   desired.GetName(),
+			Exists: exists,
+			Diff:   diff.Unified("live/"+ref, "desired/"+ref, liveYAML, desiredYAML),
+		})
+	}
+	return diffs, nil
+}
+
+// diffYAML renders obj as YAML without its server-managed fields.
+func diffYAML(obj *unstructured.Unstructured) (string, error) {
+	obj = obj.DeepCopy()
+	for _, field := range serverManagedFields {
+		unstructured.RemoveNestedField(obj.Object, field...)
+	}
+	if len(obj.GetAnnotations()) == 0 {
+		unstructured.RemoveNestedField(obj.Object, "metadata", "annotations")
+	}
+	data, err := yaml.Marshal(obj.Object)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode %s %s: %w", obj.GetKind(), obj.GetName(), err)
+	}
+	return string(data), nil
+}
@@ -0,0 +1,425 @@
+package deployer
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// PinnedFromAnnotation records on the API pod template the tagged image
+// Options.Image was pinned from by PinImageDigest.
+const PinnedFromAnnotation = "ecommerce-deployer/pinned-from"
+
+// dockerHub is the registry of image names without one, and
+// dockerHubRegistry the host its registry API is served at.
+const (
+	dockerHub         = "docker.io"
+	dockerHubRegistry = "registry-1.docker.io"
+)
+
+// manifestMediaTypes are the manifests a tag is resolved to, image indexes
+// first, so the digest is that of the multi-platform image the kubelet
+// resolves the tag to itself.
+var manifestMediaTypes = []string{
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+}
+
+// registryCredentials are the username and password the registry of an
+// image is logged in with.
+type registryCredentials struct {
+	username, password string
+}
+
+// PinImageDigest returns opts with Options.Image resolved to the digest its
+// tag points to in the registry, name@sha256:..., and
+// Options.PinnedFrom set to the tagged image. Images already pinned by
+// digest are returned as they are. The registry is logged in with
+// Options.RegistryAuth when it is for the image's registry, or else with the
+// docker config, its credential helpers included, as docker pull would.
+//
+// A tag the registry doesn't have is returned as a *ValidationError, so
+// pinning doubles as a check that the image exists before anything is
+// applied.
+func PinImageDigest(ctx context.Context, opts Options) (Options, error) {
+	image := opts.Image
+	if image == "" {
+		image = DefaultImage
+	}
+	ref, err := ParseImageReference(image)
+	if err != nil {
+		return opts, &ValidationError{Field: "image", Err: err}
+	}
+	if ref.Digest != "" {
+		return opts, nil
+	}
+	c, err := newRegistryClient(opts, ref.Name)
+	if err != nil {
+		return opts, err
+	}
+	digest, err := c.resolveDigest(ctx, ref.Tag)
+	if err != nil {
+		return opts, err
+	}
+	opts.withDefaults().Logger.Info("Pinned the image to its digest", "image", image, "digest", digest)
+	opts.PinnedFrom = image
+	opts.Image = ImageReference{Name: ref.Name, Digest: digest}.String()
+	return opts, nil
+}
+
+// splitImageName returns the registry of an image name and the repository
+// in it, as docker resolves them: a first component without a dot, a
+// colon or localhost is a Docker Hub repository, under library/ when it is
+// the only one.
+func splitImageName(name string) (registry, repository string) {
+	i := strings.Index(name, "/")
+	if i < 0 || !strings.ContainsAny(name[:i], ".:") && name[:i] != "localhost" {
+		if i < 0 {
+			name = "library/" + name
+		}
+		return dockerHub, name
+	}
+	return normalizeRegistry(name[:i]), name[i+1:]
+}
+
+// normalizeRegistry returns the registry host of server, a registry as
+// -registry-auth or the docker config name it, with Docker Hub's aliases
+// folded into dockerHub.
+func normalizeRegistry(server string) string {
+	server = strings.TrimPrefix(strings.TrimPrefix(server, "https://"), "http://")
+	if i := strings.Index(server, "/"); i >= 0 {
+		server = server[:i]
+	}
+	switch server {
+	case "index.docker.io", dockerHubRegistry:
+		return dockerHub
+	}
+	return server
+}
+
+// registryURL returns the base URL of the registry API of registry, plain
+// HTTP for one on localhost.
+func registryURL(registry string) string {
+	switch host := strings.Split(registry, ":")[0]; {
+	case registry == dockerHub:
+		return "https://" + dockerHubRegistry
+	case host == "localhost", host == "127.0.0.1":
+		return "http://" + registry
+	}
+	return "https://" + registry
+}
+
+// registryClient sends requests to a repository of a registry, answering
+// its authentication challenge on the first refusal.
+type registryClient struct {
+	registry, repository string
+	creds                *registryCredentials
+	// authorization is the Authorization header of the requests once the
+	// challenge is answered.
+	authorization string
+}
+
+// newRegistryClient returns the client of the repository of the image name,
+// logged in with Options.RegistryAuth when it is for the image's registry,
+// or else with the docker config.
+func newRegistryClient(opts Options, name string) (*registryClient, error) {
+	registry, repository := splitImageName(name)
+	c := &registryClient{registry: registry, repository: repository}
+	if a := opts.RegistryAuth; a != nil && normalizeRegistry(a.Server) == registry {
+		c.creds = &registryCredentials{username: a.Username, password: a.Password}
+		return c, nil
+	}
+	creds, err := dockerConfigCredentials(registry)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the docker config: %w", err)
+	}
+	c.creds = creds
+	return c, nil
+}
+
+// do sends a request for path under the repository, /manifests/v1.1 for
+// instance, and returns the response and its body. A 401 is answered by
+// logging in and sending the request again.
+func (c *registryClient) do(ctx context.Context, method, path string, accept []string) (*http.Response, []byte, error) {
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, method, registryURL(c.registry)+"/v2/"+c.repository+path, nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(accept) > 0 {
+			req.Header.Set("Accept", strings.Join(accept, ", "))
+		}
+		if c.authorization != "" {
+			req.Header.Set("Authorization", c.authorization)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return nil, nil, err
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, nil, err
+		}
+		if resp.StatusCode != http.StatusUnauthorized || attempt > 0 {
+			return resp, body, nil
+		}
+		if c.authorization, err = authorize(ctx, resp.Header.Get("WWW-Authenticate"), c.repository, c.creds); err != nil {
+			return nil, nil, fmt.Errorf("failed to log in to %s: %w", c.registry, err)
+		}
+	}
+}
+
+// refused returns the error of a response other than 200 to a request for
+// what, e.g. "the digest of ghcr.io/acme/api:v1".
+func (c *registryClient) refused(what string, resp *http.Response) error {
+	switch resp.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		how := "anonymously; pass -registry-auth or docker login"
+		if c.creds != nil {
+			how = "with the credentials given"
+		}
+		return fmt.Errorf("failed to get %s: the registry refused access %s", what, how)
+	}
+	return fmt.Errorf("failed to get %s: the registry answered %s", what, resp.Status)
+}
+
+// resolveDigest returns the digest of the manifest tag points to, from the
+// Docker-Content-Digest header of a HEAD or, for registries that leave it
+// out, by hashing the manifest.
+func (c *registryClient) resolveDigest(ctx context.Context, tag string) (string, error) {
+	image := c.registry + "/" + c.repository + ":" + tag
+	what := "the digest of " + image
+	for _, method := range []string{http.MethodHead, http.MethodGet} {
+		resp, body, err := c.do(ctx, method, "/manifests/"+tag, manifestMediaTypes)
+		switch {
+		case err != nil:
+			return "", fmt.Errorf("failed to get %s: %w", what, err)
+		case resp.StatusCode == http.StatusNotFound:
+			return "", &ValidationError{Field: "image", Err: fmt.Errorf("%s: the registry has no such tag", image)}
+		case resp.StatusCode != http.StatusOK:
+			return "", c.refused(what, resp)
+		}
+		if digest := resp.Header.Get("Docker-Content-Digest"); digest != "" {
+			if !imageDigestRegexp.MatchString(digest) {
+				return "", fmt.Errorf("failed to get %s: the registry answered a malformed digest %q", what, digest)
+			}
+			return digest, nil
+		}
+		if method == http.MethodGet {
+			sum := sha256.Sum256(body)
+			return "sha256:" + hex.EncodeToString(sum[:]), nil
+		}
+	}
+	return "", fmt.Errorf("failed to get %s: the registry answered no manifest", what)
+}
+
+// authorize answers the WWW-Authenticate challenge of a registry and
+// returns the Authorization header to retry with: the credentials for Basic,
+// or for Bearer, as Docker Hub, GHCR and most others ask, a pull token for
+// repository from the token service the challenge names.
+func authorize(ctx context.Context, challenge, repository string, creds *registryCredentials) (string, error) {
+	scheme, params := parseChallenge(challenge)
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if creds == nil {
+			return "", errors.New("the registry needs a login; pass -registry-auth or docker login")
+		}
+		return "Basic " + basicAuth(creds), nil
+	case "bearer":
+	default:
+		return "", fmt.Errorf("unsupported authentication challenge %q", challenge)
+	}
+	realm, err := url.Parse(params["realm"])
+	if err != nil || params["realm"] == "" {
+		return "", fmt.Errorf("malformed authentication challenge %q", challenge)
+	}
+	query := realm.Query()
+	if service := params["service"]; service != "" {
+		query.Set("service", service)
+	}
+	scope := params["scope"]
+	if scope == "" {
+		scope = "repository:" + repository + ":pull"
+	}
+	query.Set("scope", scope)
+	realm.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	if creds != nil {
+		req.Header.Set("Authorization", "Basic "+basicAuth(creds))
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("the token service %s answered %s", realm.Host, resp.Status)
+	}
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("failed to decode the token of %s: %w", realm.Host, err)
+	}
+	if token.Token == "" {
+		token.Token = token.AccessToken
+	}
+	if token.Token == "" {
+		return "", fmt.Errorf("the token service %s answered no token", realm.Host)
+	}
+	return "Bearer " + token.Token, nil
+}
+
+// parseChallenge splits a WWW-Authenticate header into its scheme and its
+// parameters, e.g. Bearer realm="https://auth.docker.io/token",service="registry.docker.io".
+func parseChallenge(challenge string) (string, map[string]string) {
+	challenge = strings.TrimSpace(challenge)
+	scheme, rest := challenge, ""
+	if i := strings.Index(challenge, " "); i >= 0 {
+		scheme, rest = challenge[:i], challenge[i+1:]
+	}
+	params := map[string]string{}
+	for rest != "" {
+		rest = strings.TrimLeft(rest, " ,")
+		eq := strings.Index(rest, "=")
+		if eq < 0 {
+			break
+		}
+		key := strings.ToLower(strings.TrimSpace(rest[:eq]))
+		rest = rest[eq+1:]
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.Index(rest[1:], `"`)
+			if end < 0 {
+				value, rest = rest[1:], ""
+			} else {
+				value, rest = rest[1:end+1], rest[end+2:]
+			}
+		} else if end := strings.Index(rest, ","); end >= 0 {
+			value, rest = rest[:end], rest[end+1:]
+		} else {
+			value, rest = rest, ""
+		}
+		params[key] = value
+	}
+	return scheme, params
+}
+
+// basicAuth returns the credentials of a Basic Authorization header.
+func basicAuth(creds *registryCredentials) string {
+	return base64.StdEncoding.EncodeToString([]byte(creds.username + ":" + creds.password))
+}
+
+// dockerConfig is the part of ~/.docker/config.json naming the logins.
+type dockerConfig struct {
+	Auths map[string]struct {
+		Auth     string `json:"auth"`
+		Username string `json:"username"`
+		Password string `json:"password"`
+	} `json:"auths"`
+	CredsStore  string            `json:"credsStore"`
+	CredHelpers map[string]string `json:"credHelpers"`
+}
+
+// dockerConfigCredentials returns the login to registry in the docker
+// config, $DOCKER_CONFIG/config.json or ~/.docker/config.json, asking its
+// credential helper when one is configured, as for ECR. It returns nil
+// when there is no config or no login, and the registry is read
+// anonymously.
+func dockerConfigCredentials(registry string) (*registryCredentials, error) {
+	dir := os.Getenv("DOCKER_CONFIG")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, nil
+		}
+		dir = filepath.Join(home, ".docker")
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "config.json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var config dockerConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("%s: %w", filepath.Join(dir, "config.json"), err)
+	}
+
+	for server, helper := range config.CredHelpers {
+		if normalizeRegistry(server) == registry {
+			return credentialHelper(helper, server)
+		}
+	}
+	for server, auth := range config.Auths {
+		if normalizeRegistry(server) != registry {
+			continue
+		}
+		if auth.Username != "" {
+			return &registryCredentials{username: auth.Username, password: auth.Password}, nil
+		}
+		decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+		if err != nil {
+			return nil, fmt.Errorf("malformed auth of %s", server)
+		}
+		if i := strings.Index(string(decoded), ":"); i >= 0 {
+			return &registryCredentials{username: string(decoded[:i]), password: string(decoded[i+1:])}, nil
+		}
+		if config.CredsStore == "" {
+			return nil, fmt.Errorf("malformed auth of %s", server)
+		}
+	}
+	if config.CredsStore == "" {
+		return nil, nil
+	}
+	server := registry
+	if registry == dockerHub {
+		server = "https://index.docker.io/v1/"
+	}
+	return credentialHelper(config.CredsStore, server)
+}
+
+// credentialHelper asks docker-credential-helper for the login to server.
+// A helper without one answers nil.
+func credentialHelper(helper, server string) (*registryCredentials, error) {
+	cmd := exec.Command("docker-credential-"+helper, "get")
+	cmd.Stdin = strings.NewReader(server)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if strings.Contains(string(out)+stderr.String(), "credentials not found") {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("docker-credential-%s get %s: %w", helper, server, err)
+	}
+	var creds struct {
+		Username string `json:"Username"`
+		Secret   string `json:"Secret"`
+	}
+	if err := json.Unmarshal(out, &creds); err != nil {
+		return nil, fmt.Errorf("docker-credential-%s get %s: %w", helper, server, err)
+	}
+	return &registryCredentials{username: creds.Username, password: creds.Secret}, nil
+}
@@ -0,0 +1,105 @@
+package deployer
+
+import (
+	"context"
+	"fmt"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"strings"
+	"time"
+)
+
+// Endpoint is a URL the API is reached at.
+type Endpoint struct {
+	URL string `json:"url"`
+	// Addresses are the IPs and hostnames of the ingress controller's load
+	// balancer, which the host of URL has to resolve to. URLs of node
+	// ports name the node themselves and have none.
+	Addresses []string `json:"addresses,omitempty"`
+}
+
+// String formats e as the URL and the addresses it must resolve to, e.g.
+// "https://raka.com/login -> 203.0.113.7".
+func (e Endpoint) String() string {
+	if len(e.Addresses) == 0 {
+		return e.URL
+	}
+	return e.URL + " -> " + strings.Join(e.Addresses, ", ")
+}
+
+// WaitForIngress polls the ingress until the ingress controller has
+// published its address in status.loadBalancer and returns the IPs and
+// hostnames there. It returns nil right away when the release has no
+// built-in ingress, or the cluster serves no Ingress API.
+func (d *Deployer) WaitForIngress(ctx context.Context, timeout time.Duration) ([]string, error) {
+	if d.opts.routing() != RoutingIngress || len(d.opts.Manifests) > 0 {
+		return nil, nil
+	}
+	obj, err := d.servedIngress(newIngress(d.opts))
+	if err != nil || obj == nil {
+		return nil, err
+	}
+	o, err := d.mapObject(obj)
+	if err != nil {
+		return nil, err
+	}
+	name := obj.GetName()
+	d.log.Info("Waiting for the ingress address", "namespace", d.opts.Namespace, "ingress", name, "timeout", timeout.String())
+
+	waitCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	var addresses []string
+	err = wait.PollImmediateUntilWithContext(waitCtx, loadBalancerPollInterval, func(ctx context.Context) (bool, error) {
+		live, err := d.resource(o.gvr).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return false, d.opError(OpGet, o.gvr, name, err)
+		}
+		addresses = loadBalancerAddresses(live)
+		return len(addresses) > 0, nil
+	})
+	if err != nil {
+		return nil, d.opError(OpWait, o.gvr, name, fmt.Errorf("ingress address: %w", err))
+	}
+	return addresses, nil
+}
+
+// IngressEndpoints returns the URL of each path on each host of the
+// ingress, https when it terminates TLS, served at addresses. Wildcard
+// hosts name no URL and are left out.
+func (d *Deployer) IngressEndpoints(addresses []string) []Endpoint {
+	scheme := "http"
+	if d.opts.tlsSecretName() != "" {
+		scheme = "https"
+	}
+	var endpoints []Endpoint
+	for _, h := range d.opts.Hosts {
+		if strings.HasPrefix(h, "*.") {
+			continue
+		}
+		for _, p := range d.opts.Paths {
+			endpoints = append(endpoints, Endpoint{URL: scheme + "://" + h + p.Path, Addresses: addresses})
+		}
+	}
+	return endpoints
+}
+
+// NodePortEndpoints returns the URLs of NodePortURLs for every NodePort
+// service among applied. The node ports of LoadBalancer services are left
+// out, as their load balancer is the way in.
+func (d *Deployer) NodePortEndpoints(ctx context.Context, applied []*unstructured.Unstructured) ([]Endpoint, error) {
+	var endpoints []Endpoint
+	for _, obj := range applied {
+		if obj.GetKind() != "Service" || serviceType(obj) != "NodePort" {
+			continue
+		}
+		urls, err := d.NodePortURLs(ctx, obj)
+		if err != nil {
+			return nil, err
+		}
+		for _, u := range urls {
+			endpoints = append(endpoints, Endpoint{URL: u})
+		}
+	}
+	return endpoints, nil
+}
@@ -0,0 +1,104 @@
+package deployer
+
+import (
+	"fmt"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sort"
+	"strings"
+)
+
+// envRef splits a -env-from-secret or -env-from-configmap reference,
+// "name/key", into the object name and key.
+func envRef(ref string) (name, key string, ok bool) {
+	slash := strings.Index(ref, "/")
+	if slash <= 0 || slash == len(ref)-1 {
+		return "", "", false
+	}
+	return ref[:slash], ref[slash+1:], true
+}
+
+// validateEnv checks the variable names are C identifiers, each is set only
+// once, and the references name a valid object and key.
+func (o Options) validateEnv() error {
+	seen := map[string]string{}
+	sources := []struct {
+		field  string
+		values map[string]string
+		ref    bool
+	}{
+		{"env", o.Env, false},
+		{"env-from-secret", o.EnvFromSecret, true},
+		{"env-from-configmap", o.EnvFromConfigMap, true},
+	}
+	for _, s := range sources {
+		for _, name := range sortedKeys(s.values) {
+			if errs := validation.IsCIdentifier(name); len(errs) > 0 {
+				return &ValidationError{Field: s.field, Err: fmt.Errorf("%q: %s", name, strings.Join(errs, "; "))}
+			}
+			if other, ok := seen[name]; ok {
+				return &ValidationError{Field: s.field, Err: fmt.Errorf("%s is also set by -%s", name, other)}
+			}
+			seen[name] = s.field
+			if !s.ref {
+				continue
+			}
+			ref := s.values[name]
+			object, key, ok := envRef(ref)
+			if !ok {
+				return &ValidationError{Field: s.field, Err: fmt.Errorf("%s: %q must be in name/key form", name, ref)}
+			}
+			errs := append(validation.IsDNS1123Subdomain(object), validation.IsConfigMapKey(key)...)
+			if len(errs) > 0 {
+				return &ValidationError{Field: s.field, Err: fmt.Errorf("%s: %q: %s", name, ref, strings.Join(errs, "; "))}
+			}
+		}
+	}
+	return nil
+}
+
+// wireEnv sets Options.Env, EnvFromSecret and EnvFromConfigMap on the API
+// container, replacing variables of the same name set by the other wire
+// functions, and sorts the env list so repeated renders diff cleanly.
+func wireEnv(dep *appsv1.Deployment, opts Options) {
+	c := apiContainer(&dep.Spec.Template.Spec)
+	vars := map[string]corev1.EnvVar{}
+	for _, e := range c.Env {
+		vars[e.Name] = e
+	}
+	for name, value := range opts.Env {
+		vars[name] = corev1.EnvVar{Name: name, Value: value}
+	}
+	for name, ref := range opts.EnvFromSecret {
+		object, key, _ := envRef(ref)
+		vars[name] = corev1.EnvVar{Name: name, ValueFrom: &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{
+			LocalObjectReference: corev1.LocalObjectReference{Name: object},
+			Key:                  key,
+		}}}
+	}
+	for name, ref := range opts.EnvFromConfigMap {
+		object, key, _ := envRef(ref)
+		vars[name] = corev1.EnvVar{Name: name, ValueFrom: &corev1.EnvVarSource{ConfigMapKeyRef: &corev1.ConfigMapKeySelector{
+			LocalObjectReference: corev1.LocalObjectReference{Name: object},
+			Key:                  key,
+		}}}
+	}
+	if len(vars) == 0 {
+		return
+	}
+	c.Env = make([]corev1.EnvVar, 0, len(vars))
+	for _, e := range vars {
+		c.Env = append(c.Env, e)
+	}
+	sort.Slice(c.Env, func(i, j int) bool { return c.Env[i].Name < c.Env[j].Name })
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,64 @@
+package deployer
+
+import (
+	"fmt"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// Op names the kind of request a DeployError came from.
+type Op string
+
+const (
+	OpGet    Op = "get"
+	OpList   Op = "list"
+	OpCreate Op = "create"
+	OpApply  Op = "apply"
+	OpDelete Op = "delete"
+	OpPatch  Op = "patch"
+	OpWait   Op = "wait for"
+)
+
+// DeployError is returned when a request for one of the managed resources
+// fails. It wraps the underlying error, so the apierrors helpers such as
+// apierrors.IsForbidden keep working on it.
+type DeployError struct {
+	GVR       schema.GroupVersionResource
+	Namespace string
+	Name      string
+	Op        Op
+	Err       error
+}
+
+func (e *DeployError) Error() string {
+	target := e.GVR.Resource
+	switch {
+	case e.Name != "" && e.Namespace != "":
+		target += " " + e.Namespace + "/" + e.Name
+	case e.Name != "":
+		target += " " + e.Name
+	case e.Namespace != "":
+		target += " in namespace " + e.Namespace
+	}
+	return fmt.Sprintf("failed to %s %s: %v", e.Op, target, e.Err)
+}
+
+func (e *DeployError) Unwrap() error { return e.Err }
+
+// ValidationError is returned when an option is rejected before anything is
+// sent to the cluster.
+type ValidationError struct {
+	Field string
+	Err   error
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Field, e.Err)
+}
+
+func (e *ValidationError) Unwrap() error { return e.Err }
+
+// opError logs a failed request and returns it as a *DeployError.
+func (d *Deployer) opError(op Op, gvr schema.GroupVersionResource, name string, err error) error {
+	d.log.Error(err, "Request failed", "op", string(op), "resource", gvr.String(), "namespace", d.opts.Namespace, "name", name)
+	return &DeployError{GVR: gvr, Namespace: d.opts.Namespace, Name: name, Op: op, Err: err}
+}
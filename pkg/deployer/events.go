@@ -0,0 +1,122 @@
+package deployer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sort"
+	"time"
+)
+
+// maxEvents is how many of the most recent events RelatedEvents returns.
+const maxEvents = 10
+
+// EventResource is the GroupVersionResource of core events.
+var EventResource = schema.GroupVersionResource{Version: "v1", Resource: "events"}
+
+// Event is a Kubernetes event about one of the objects of a failure.
+type Event struct {
+	Time time.Time
+	// Type is Normal or Warning.
+	Type   string
+	Reason string
+	// Object is the kind and name of the object the event is about.
+	Object  string
+	Message string
+	Count   int32
+}
+
+func (e Event) String() string {
+	return fmt.Sprintf("%s %s %s: %s", e.Type, e.Object, e.Reason, e.Message)
+}
+
+// RelatedEvents returns the most recent events, oldest first, about the
+// objects err is about: the object of a *DeployError, or the API
+// deployment, its replica sets and its pods for a failed or timed out
+// rollout. Errors about nothing in the namespace return no events.
+func (d *Deployer) RelatedEvents(ctx context.Context, err error) ([]Event, error) {
+	var (
+		deployErr  *DeployError
+		rolloutErr *RolloutFailedError
+		timeoutErr *RolloutTimeoutError
+		names      []string
+	)
+	switch {
+	case errors.As(err, &rolloutErr), errors.As(err, &timeoutErr):
+		names = d.rolloutObjects(ctx)
+	case errors.As(err, &deployErr):
+		if deployErr.Name == "" || deployErr.Namespace != d.opts.Namespace {
+			return nil, nil
+		}
+		names = []string{deployErr.Name}
+	default:
+		return nil, nil
+	}
+
+	var events []Event
+	for _, name := range names {
+		list, err := d.resource(EventResource).List(ctx, metav1.ListOptions{
+			FieldSelector: fields.OneTermEqualSelector("involvedObject.name", name).String(),
+		})
+		if err != nil {
+			return nil, d.opError(OpList, EventResource, "", err)
+		}
+		for _, item := range list.Items {
+			var ev corev1.Event
+			if err := runtime.DefaultUnstructuredConverter.FromUnstructured(item.Object, &ev); err != nil {
+				return nil, fmt.Errorf("failed to decode event %s: %w", item.GetName(), err)
+			}
+			events = append(events, Event{
+				Time:    eventTime(ev),
+				Type:    ev.Type,
+				Reason:  ev.Reason,
+				Object:  ev.InvolvedObject.Kind + "/" + ev.InvolvedObject.Name,
+				Message: ev.Message,
+				Count:   ev.Count,
+			})
+		}
+	}
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Time.Before(events[j].Time) })
+	if len(events) > maxEvents {
+		events = events[len(events)-maxEvents:]
+	}
+	return events, nil
+}
+
+// rolloutObjects returns the names of the API deployment and of the replica
+// sets and pods it owns, as far as they can be read.
+func (d *Deployer) rolloutObjects(ctx context.Context) []string {
+	dep := apiDeployment(d.opts)
+	if dep == nil {
+		return nil
+	}
+	names := []string{dep.GetName()}
+	if live, err := d.liveAPIDeployment(ctx); err == nil {
+		revisions, _ := d.history(ctx, live)
+		for _, r := range revisions {
+			names = append(names, r.ReplicaSet)
+		}
+	}
+	pods, _ := d.apiPods(ctx)
+	for _, pod := range pods {
+		names = append(names, pod.Name)
+	}
+	return names
+}
+
+// eventTime returns when ev last happened. Events recorded through the
+// events.k8s.io API only set eventTime.
+func eventTime(ev corev1.Event) time.Time {
+	switch {
+	case !ev.LastTimestamp.IsZero():
+		return ev.LastTimestamp.Time
+	case !ev.EventTime.IsZero():
+		return ev.EventTime.Time
+	}
+	return ev.CreationTimestamp.Time
+}
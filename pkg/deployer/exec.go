@@ -0,0 +1,123 @@
+package deployer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/remotecommand"
+	utilexec "k8s.io/client-go/util/exec"
+	"net/http"
+)
+
+// errExecNoConfig is returned by Exec on a Deployer not built by
+// NewForConfig, which has no rest config to dial pods with.
+var errExecNoConfig = errors.New("exec needs a rest config; build the Deployer with NewForConfig")
+
+// ExecOptions configures Exec.
+type ExecOptions struct {
+	// Pod is the pod the command runs in. It defaults to a running API
+	// pod, preferring ready ones.
+	Pod string
+	// Container is the container the command runs in; it defaults to the
+	// API container.
+	Container string
+	Command   []string
+	// Stdin, when set, is copied to the command's standard input.
+	Stdin  io.Reader
+	Stdout io.Writer
+	// Stderr receives the command's standard error. A TTY merges it into
+	// Stdout.
+	Stderr io.Writer
+	// TTY allocates a terminal of Width columns and Height rows for the
+	// command; Stdin should then be a terminal in raw mode.
+	TTY           bool
+	Width, Height uint16
+}
+
+// Exec runs a command in an API pod, like kubectl exec. When the command
+// exits non-zero the error is a k8s.io/client-go/util/exec.ExitError
+// carrying its exit status.
+func (d *Deployer) Exec(ctx context.Context, opts ExecOptions) error {
+	if d.config == nil || d.kube == nil {
+		return errExecNoConfig
+	}
+	if len(opts.Command) == 0 {
+		return &ValidationError{Field: "command", Err: errors.New("no command given; pass it after exec --")}
+	}
+	if opts.Container == "" {
+		opts.Container = apiContainerName
+	}
+	if opts.Pod == "" {
+		pods, err := d.apiPods(ctx)
+		if err != nil {
+			return err
+		}
+		pod := readyPod(pods)
+		if pod == nil {
+			pod = runningPod(pods)
+		}
+		if pod == nil {
+			return d.opError(OpList, PodResource, "", errors.New("no running API pod; is the release deployed?"))
+		}
+		opts.Pod = pod.Name
+	}
+
+	req := d.kube.CoreV1().RESTClient().Post().
+		Resource("pods").Namespace(d.opts.Namespace).Name(opts.Pod).SubResource("exec").
+		VersionedParams(&corev1.PodExecOptions{
+			Container: opts.Container,
+			Command:   opts.Command,
+			Stdin:     opts.Stdin != nil,
+			Stdout:    opts.Stdout != nil,
+			Stderr:    opts.Stderr != nil && !opts.TTY,
+			TTY:       opts.TTY,
+		}, scheme.ParameterCodec)
+	executor, err := remotecommand.NewSPDYExecutor(d.config, http.MethodPost, req.URL())
+	if err != nil {
+		return fmt.Errorf("failed to build the exec transport: %w", err)
+	}
+	stream := remotecommand.StreamOptions{
+		Stdin:  opts.Stdin,
+		Stdout: opts.Stdout,
+		Tty:    opts.TTY,
+	}
+	if !opts.TTY {
+		stream.Stderr = opts.Stderr
+	} else if opts.Width > 0 && opts.Height > 0 {
+		stream.TerminalSizeQueue = &fixedSize{size: &remotecommand.TerminalSize{Width: opts.Width, Height: opts.Height}}
+	}
+	d.log.V(2).Info("Executing", "pod", opts.Pod, "container", opts.Container, "command", opts.Command)
+	if err := executor.Stream(stream); err != nil {
+		var exit utilexec.ExitError
+		if errors.As(err, &exit) {
+			return err
+		}
+		return d.opError(OpGet, PodResource, opts.Pod, fmt.Errorf("exec failed: %w", err))
+	}
+	return nil
+}
+
+// runningPod returns a running pod of pods that isn't being deleted, or nil.
+func runningPod(pods []corev1.Pod) *corev1.Pod {
+	for i := range pods {
+		if pods[i].DeletionTimestamp == nil && pods[i].Status.Phase == corev1.PodRunning {
+			return &pods[i]
+		}
+	}
+	return nil
+}
+
+// fixedSize reports one terminal size, so the remote terminal starts with
+// the size of the local one.
+type fixedSize struct {
+	size *remotecommand.TerminalSize
+}
+
+func (s *fixedSize) Next() *remotecommand.TerminalSize {
+	size := s.size
+	s.size = nil
+	return size
+}
@@ -0,0 +1,100 @@
+package deployer
+
+import (
+	"fmt"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"net"
+	"strings"
+)
+
+// ExternalNameService is a service of the release resolving to a host
+// outside the cluster, such as a database the API reaches as legacy-db.
+type ExternalNameService struct {
+	// Name is the service's name, as is: it is what the pods look up.
+	Name string
+	// Host is the DNS name the service is a CNAME of.
+	Host string
+}
+
+// ParseExternalNameService parses the -external-name-service format,
+// name=legacy-db,host=db.example.com. An ExternalName service has no pods
+// behind it, so selectors and ports are rejected.
+func ParseExternalNameService(s string) (ExternalNameService, error) {
+	fields, err := splitFields(s)
+	if err != nil {
+		return ExternalNameService{}, err
+	}
+	var svc ExternalNameService
+	for _, f := range fields {
+		switch f.key {
+		case "name":
+			svc.Name = f.value
+		case "host":
+			svc.Host = f.value
+		case "selector", "port", "ports", "targetPort":
+			return ExternalNameService{}, fmt.Errorf("%q: an ExternalName service has no pods, so no %s", s, f.key)
+		default:
+			return ExternalNameService{}, fmt.Errorf("%q: unknown key %q, expected name or host", s, f.key)
+		}
+	}
+	return svc, nil
+}
+
+// validateExternalNameServices checks each service has a unique name no
+// built-in service has, and a DNS name, not an IP, as its host.
+func (o Options) validateExternalNameServices() error {
+	names := map[string]bool{}
+	for _, name := range []string{o.serviceName(), o.nodePortName(), o.headlessName(), o.canaryServiceName(), o.postgresName(), o.redisName()} {
+		names[name] = true
+	}
+	for _, s := range o.ExternalNameServices {
+		if errs := validation.IsDNS1035Label(s.Name); len(errs) > 0 {
+			return &ValidationError{Field: "external-name-service", Err: fmt.Errorf("name %q: %s", s.Name, strings.Join(errs, "; "))}
+		}
+		if names[s.Name] {
+			return &ValidationError{Field: "external-name-service", Err: fmt.Errorf("the release already has a service called %s", s.Name)}
+		}
+		names[s.Name] = true
+		if net.ParseIP(s.Host) != nil {
+			return &ValidationError{Field: "external-name-service", Err: fmt.Errorf("%s: host %s is an IP; an ExternalName needs a DNS name", s.Name, s.Host)}
+		}
+		if errs := validation.IsDNS1123Subdomain(s.Host); len(errs) > 0 {
+			return &ValidationError{Field: "external-name-service", Err: fmt.Errorf("%s: host %q: %s", s.Name, s.Host, strings.Join(errs, "; "))}
+		}
+	}
+	return nil
+}
+
+// newExternalNameService returns the ExternalName service s.
+func newExternalNameService(opts Options, s ExternalNameService) *unstructured.Unstructured {
+	return &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "Service",
+			"metadata": map[string]interface{}{
+				"name":      s.Name,
+				"namespace": opts.Namespace,
+			},
+			"spec": map[string]interface{}{
+				"type":         string(corev1.ServiceTypeExternalName),
+				"externalName": s.Host,
+			},
+		},
+	}
+}
+
+// BuildExternalNameService returns the ExternalName service s.
+func BuildExternalNameService(opts Options, s ExternalNameService) *corev1.Service {
+	opts = opts.withDefaults()
+	return &corev1.Service{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Service"},
+		ObjectMeta: metav1.ObjectMeta{Name: s.Name, Namespace: opts.Namespace},
+		Spec: corev1.ServiceSpec{
+			Type:         corev1.ServiceTypeExternalName,
+			ExternalName: s.Host,
+		},
+	}
+}
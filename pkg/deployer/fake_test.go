@@ -0,0 +1,153 @@
+package deployer
+
+import (
+	"fmt"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	clienttesting "k8s.io/client-go/testing"
+	"strings"
+	"testing"
+)
+
+// fakeListKinds lets the fake dynamic client list every resource the
+// deployer reads.
+var fakeListKinds = func() map[schema.GroupVersionResource]string {
+	kinds := map[schema.GroupVersionResource]string{}
+	for kind, gvr := range map[string]schema.GroupVersionResource{
+		"Deployment":               DeploymentResource,
+		"Service":                  ServiceResource,
+		"Ingress":                  IngressResource,
+		"Namespace":                NamespaceResource,
+		"HorizontalPodAutoscaler":  HPAResource,
+		"Pod":                      PodResource,
+		"Event":                    EventResource,
+		"Node":                     NodeResource,
+		"Secret":                   SecretResource,
+		"ConfigMap":                ConfigMapResource,
+		"StatefulSet":              StatefulSetResource,
+		"ReplicaSet":               ReplicaSetResource,
+		"PersistentVolumeClaim":    PVCResource,
+		"PodDisruptionBudget":      PDBResource,
+		"NetworkPolicy":            NetworkPolicyResource,
+		"ServiceAccount":           ServiceAccountResource,
+		"Role":                     RoleResource,
+		"RoleBinding":              RoleBindingResource,
+		"CronJob":                  CronJobResource,
+		"Job":                      JobResource,
+		"HTTPRoute":                HTTPRouteResource,
+		"Route":                    OpenShiftRouteResource,
+		"ServiceMonitor":           ServiceMonitorResource,
+		"CustomResourceDefinition": CRDResource,
+		"EcommerceApp":             AppResource,
+	} {
+		kinds[gvr] = kind + "List"
+	}
+	kinds[IngressResource.GroupVersion().WithResource("ingressclasses")] = "IngressClassList"
+	return kinds
+}()
+
+// newFakeClient returns a fake dynamic client holding objects. The fake
+// tracker doesn't implement server-side apply, so apply patches create the
+// object or replace it whole, keeping its UID.
+func newFakeClient(objects ...runtime.Object) *dynamicfake.FakeDynamicClient {
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), fakeListKinds, objects...)
+	client.PrependReactor("patch", "*", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		patch := action.(clienttesting.PatchAction)
+		if patch.GetPatchType() != types.ApplyPatchType {
+			return false, nil, nil
+		}
+		obj := &unstructured.Unstructured{}
+		if err := obj.UnmarshalJSON(patch.GetPatch()); err != nil {
+			return true, nil, apierrors.NewBadRequest(err.Error())
+		}
+		gvr, ns, name := patch.GetResource(), patch.GetNamespace(), patch.GetName()
+		obj.SetNamespace(ns)
+		live, err := client.Tracker().Get(gvr, ns, name)
+		switch {
+		case apierrors.IsNotFound(err):
+			obj.SetUID(types.UID("uid-" + name))
+			obj.SetResourceVersion("1")
+			err = client.Tracker().Create(gvr, obj, ns)
+		case err == nil:
+			liveObj := live.(*unstructured.Unstructured)
+			obj.SetUID(liveObj.GetUID())
+			obj.SetResourceVersion(nextVersion(liveObj.GetResourceVersion()))
+			err = client.Tracker().Update(gvr, obj, ns)
+		}
+		if err != nil {
+			return true, nil, err
+		}
+		return true, obj, nil
+	})
+	return client
+}
+
+func nextVersion(rv string) string {
+	var n int
+	fmt.Sscan(rv, &n)
+	return fmt.Sprint(n + 1)
+}
+
+// newFakeDeployer returns a Deployer for opts on a fake client holding
+// objects.
+func newFakeDeployer(opts Options, objects ...runtime.Object) (*Deployer, *dynamicfake.FakeDynamicClient) {
+	client := newFakeClient(objects...)
+	return New(client, opts), client
+}
+
+// writes returns the create, patch and delete actions of client as
+// "verb resource namespace/name", in order.
+func writes(client *dynamicfake.FakeDynamicClient) []string {
+	var out []string
+	for _, a := range client.Actions() {
+		var name string
+		switch a := a.(type) {
+		case clienttesting.CreateAction:
+			name = a.GetObject().(*unstructured.Unstructured).GetName()
+		case clienttesting.PatchAction:
+			name = a.GetName()
+		case clienttesting.DeleteActionImpl:
+			name = a.GetName()
+		default:
+			continue
+		}
+		out = append(out, fmt.Sprintf("%s %s %s/%s", a.GetVerb(), a.GetResource().Resource, a.GetNamespace(), name))
+	}
+	return out
+}
+
+// liveObject returns the object the fake client holds, failing t when it
+// has none.
+func liveObject(t *testing.T, client *dynamicfake.FakeDynamicClient, gvr schema.GroupVersionResource, ns, name string) *unstructured.Unstructured {
+	t.Helper()
+	obj, err := client.Tracker().Get(gvr, ns, name)
+	if err != nil {
+		t.Fatalf("get %s %s/%s: %v", gvr.Resource, ns, name, err)
+	}
+	return obj.(*unstructured.Unstructured)
+}
+
+// unstructuredObject builds an object from a YAML-like literal of its
+// apiVersion, kind, namespace and name.
+func unstructuredObject(apiVersion, kind, ns, name string) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion(apiVersion)
+	obj.SetKind(kind)
+	obj.SetNamespace(ns)
+	obj.SetName(name)
+	return obj
+}
+
+// hasPrefix reports whether any of list starts with prefix.
+func hasPrefix(list []string, prefix string) bool {
+	for _, s := range list {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,227 @@
+package deployer
+
+import (
+	"errors"
+	"fmt"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"strings"
+)
+
+// RoutingIngress and RoutingGateway are the values of Options.Routing: the
+// API is reached through an Ingress, the default, or through a Gateway API
+// HTTPRoute.
+const (
+	RoutingIngress = "ingress"
+	RoutingGateway = "gateway"
+)
+
+// HTTPRouteResource is the Gateway API resource of the HTTPRoute created
+// with Options.Routing gateway.
+var HTTPRouteResource = schema.GroupVersionResource{Group: "gateway.networking.k8s.io", Version: "v1", Resource: "httproutes"}
+
+// httpRouteGroupKind is the kind the Gateway API CRDs serve.
+var httpRouteGroupKind = schema.GroupKind{Group: HTTPRouteResource.Group, Kind: "HTTPRoute"}
+
+// errHTTPRouteNotServed is returned when Options.Routing is gateway on a
+// cluster without the Gateway API CRDs.
+var errHTTPRouteNotServed = errors.New("gateway.networking.k8s.io/v1 HTTPRoute is not served by the cluster; install the Gateway API CRDs")
+
+// gatewayPathTypes maps the ingress pathTypes to HTTPRoute path match types.
+var gatewayPathTypes = map[string]string{"Prefix": "PathPrefix", "Exact": "Exact"}
+
+// validateRouting checks the routing mode, that gateway routing has a parent
+// Gateway and paths an HTTPRoute can match, that route routing has hosts
+// and paths a Route can, and that the ingress-only settings aren't given
+// with either. An empty mode allows the settings of every mode, since
+// Deployer.ResolveRouting picks it later.
+func (o Options) validateRouting() error {
+	switch o.Routing {
+	case "", RoutingIngress, RoutingGateway, RoutingRoute:
+	default:
+		return &ValidationError{Field: "routing", Err: fmt.Errorf("must be %s, %s or %s, got %q", RoutingIngress, RoutingGateway, RoutingRoute, o.Routing)}
+	}
+	if o.Routing != "" && o.Routing != RoutingGateway && (o.GatewayName != "" || o.GatewayNamespace != "") {
+		return &ValidationError{Field: "gateway-name", Err: fmt.Errorf("only applies with -routing=%s", RoutingGateway)}
+	}
+	if o.Routing != "" && o.Routing != RoutingRoute && (o.RouteEdgeTLS || o.RouteInsecurePolicy != "") {
+		field := "route-edge-tls"
+		if !o.RouteEdgeTLS {
+			field = "route-insecure-policy"
+		}
+		return &ValidationError{Field: field, Err: fmt.Errorf("only applies with -routing=%s", RoutingRoute)}
+	}
+	switch o.Routing {
+	case RoutingGateway:
+		if err := o.validateGateway(); err != nil {
+			return err
+		}
+	case RoutingRoute:
+		if err := o.validateOpenShiftRoute(); err != nil {
+			return err
+		}
+	default:
+		return nil
+	}
+	switch {
+	case o.IngressClass != "":
+		return &ValidationError{Field: "ingress-class", Err: fmt.Errorf("doesn't apply with -routing=%s", o.Routing)}
+	case len(o.IngressAnnotations) > 0:
+		return &ValidationError{Field: "ingress-annotation", Err: fmt.Errorf("doesn't apply with -routing=%s", o.Routing)}
+	case o.Nginx.annotations() != nil:
+		return &ValidationError{Field: "routing", Err: errors.New("the ingress-nginx settings need an ingress")}
+	case o.CertManagerIssuer != "" && o.Routing == RoutingGateway:
+		return &ValidationError{Field: "cert-manager-issuer", Err: fmt.Errorf("annotates the ingress; with -routing=%s the Gateway's listeners terminate TLS", RoutingGateway)}
+	case o.CertManagerIssuer != "":
+		return &ValidationError{Field: "cert-manager-issuer", Err: fmt.Errorf("annotates the ingress; with -routing=%s pass -tls-cert and -tls-key, or -route-edge-tls for the router's certificate", RoutingRoute)}
+	}
+	return nil
+}
+
+// validateGateway checks gateway routing has a parent Gateway and paths an
+// HTTPRoute can match.
+func (o Options) validateGateway() error {
+	if o.GatewayName == "" {
+		return &ValidationError{Field: "gateway-name", Err: fmt.Errorf("is needed with -routing=%s", RoutingGateway)}
+	}
+	if errs := validation.IsDNS1123Subdomain(o.GatewayName); len(errs) > 0 {
+		return &ValidationError{Field: "gateway-name", Err: fmt.Errorf("%q: %s", o.GatewayName, strings.Join(errs, "; "))}
+	}
+	if o.GatewayNamespace != "" {
+		if errs := validation.IsDNS1123Label(o.GatewayNamespace); len(errs) > 0 {
+			return &ValidationError{Field: "gateway-namespace", Err: fmt.Errorf("%q: %s", o.GatewayNamespace, strings.Join(errs, "; "))}
+		}
+	}
+	for _, p := range o.Paths {
+		if _, ok := gatewayPathTypes[p.PathType]; !ok {
+			return &ValidationError{Field: "path", Err: fmt.Errorf("%s: an HTTPRoute matches Prefix or Exact paths, not %s", p.Path, p.PathType)}
+		}
+	}
+	return nil
+}
+
+// routeName returns the name of the HTTPRoute, or of the OpenShift Route
+// when there is a single one.
+func (o Options) routeName() string {
+	return o.objectName("route", "server-route")
+}
+
+// newHTTPRoute returns the HTTPRoute attaching Options.Hosts to the parent
+// Gateway and routing each of Options.Paths to the service port it names,
+// or nil unless Options.Routing is gateway. Both builders use it, as there
+// is no typed struct of the kind.
+func newHTTPRoute(opts Options) *unstructured.Unstructured {
+	if opts.Routing != RoutingGateway {
+		return nil
+	}
+	parent := map[string]interface{}{"name": opts.GatewayName}
+	if opts.GatewayNamespace != "" {
+		parent["namespace"] = opts.GatewayNamespace
+	}
+	servicePorts := map[string]int64{}
+	for _, p := range opts.ports() {
+		servicePorts[p.Name] = int64(p.ServicePort)
+	}
+	rules := make([]interface{}, 0, len(opts.Paths))
+	for _, p := range opts.Paths {
+		rules = append(rules, map[string]interface{}{
+			"matches": []interface{}{map[string]interface{}{
+				"path": map[string]interface{}{"type": gatewayPathTypes[p.PathType], "value": p.Path},
+			}},
+			"backendRefs": []interface{}{map[string]interface{}{
+				"name": opts.serviceName(),
+				"port": servicePorts[opts.pathPort(p)],
+			}},
+		})
+	}
+	hostnames := make([]interface{}, 0, len(opts.Hosts))
+	for _, h := range opts.Hosts {
+		hostnames = append(hostnames, h)
+	}
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": HTTPRouteResource.GroupVersion().String(),
+		"kind":       httpRouteGroupKind.Kind,
+		"spec": map[string]interface{}{
+			"parentRefs": []interface{}{parent},
+			"hostnames":  hostnames,
+			"rules":      rules,
+		},
+	}}
+	obj.SetName(opts.routeName())
+	obj.SetNamespace(opts.Namespace)
+	return obj
+}
+
+// servesHTTPRoute reports whether the cluster serves the Gateway API's
+// HTTPRoute.
+func (d *Deployer) servesHTTPRoute() (bool, error) {
+	_, err := d.mapper.RESTMapping(httpRouteGroupKind, HTTPRouteResource.Version)
+	if meta.IsNoMatchError(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to discover the gateway.networking.k8s.io API: %w", err)
+	}
+	return true, nil
+}
+
+// RouteStatus is the state of the HTTPRoute as each parent Gateway reports
+// it.
+type RouteStatus struct {
+	Name      string              `json:"name"`
+	Present   bool                `json:"present"`
+	Hostnames []string            `json:"hostnames,omitempty"`
+	Parents   []RouteParentStatus `json:"parents,omitempty"`
+}
+
+// RouteParentStatus is what a Gateway reports about a route attached to it.
+// Accepted and ResolvedRefs are the statuses of the conditions of the same
+// names, True, False or Unknown, empty until the Gateway reports them.
+type RouteParentStatus struct {
+	Gateway      string `json:"gateway"`
+	Accepted     string `json:"accepted,omitempty"`
+	ResolvedRefs string `json:"resolvedRefs,omitempty"`
+	// Message is the message of the first condition that isn't True.
+	Message string `json:"message,omitempty"`
+}
+
+// routeParents returns the status.parents of the live HTTPRoute.
+func routeParents(live *unstructured.Unstructured, namespace string) []RouteParentStatus {
+	parents, _, _ := unstructured.NestedSlice(live.Object, "status", "parents")
+	var statuses []RouteParentStatus
+	for _, p := range parents {
+		p, ok := p.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		ref, _, _ := unstructured.NestedStringMap(p, "parentRef")
+		gatewayNamespace := ref["namespace"]
+		if gatewayNamespace == "" {
+			gatewayNamespace = namespace
+		}
+		s := RouteParentStatus{Gateway: gatewayNamespace + "/" + ref["name"]}
+		conditions, _, _ := unstructured.NestedSlice(p, "conditions")
+		for _, c := range conditions {
+			c, ok := c.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			status, _ := c["status"].(string)
+			switch c["type"] {
+			case "Accepted":
+				s.Accepted = status
+			case "ResolvedRefs":
+				s.ResolvedRefs = status
+			default:
+				continue
+			}
+			if message, _ := c["message"].(string); status != "True" && s.Message == "" {
+				s.Message = message
+			}
+		}
+		statuses = append(statuses, s)
+	}
+	return statuses
+}
@@ -0,0 +1,83 @@
+package deployer
+
+import (
+	"bytes"
+	"flag"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"os"
+	"path/filepath"
+	"sigs.k8s.io/yaml"
+	"testing"
+)
+
+// update rewrites the golden files with what the tests produce:
+//
+//	go test ./pkg/deployer -update
+var update = flag.Bool("update", false, "rewrite the golden files under testdata")
+
+// checkGolden compares got with testdata/name, or writes it there with
+// -update.
+func checkGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", filepath.FromSlash(name))
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v; run go test -update to create it", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s differs from the golden file; run go test -update and review the diff:\n%s", path, got)
+	}
+}
+
+// yamlStream returns objects as a multi-document YAML stream.
+func yamlStream(t *testing.T, objects []*unstructured.Unstructured) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	for _, obj := range objects {
+		data, err := yaml.Marshal(obj.Object)
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf.WriteString("---\n")
+		buf.Write(data)
+	}
+	return buf.Bytes()
+}
+
+func TestRenderGolden(t *testing.T) {
+	nodePort := Options{ServiceType: "NodePort", NodePort: 30080, ExposeNodePort: true}
+	tests := []struct {
+		name string
+		opts Options
+	}{
+		{"default", Options{}},
+		{"nodeport", nodePort},
+		{"hosts-tls", Options{
+			Namespace:    "shop",
+			Release:      "shop",
+			Image:        "ghcr.io/acme/ecommerce-api:v2.0",
+			Hosts:        []string{"shop.example.com", "*.shop.example.com"},
+			Paths:        []IngressPath{{Path: "/api", PathType: "Prefix"}},
+			IngressClass: "nginx",
+			TLSSecret:    "shop-tls",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			objects, err := Render(tt.opts)
+			if err != nil {
+				t.Fatalf("Render: %v", err)
+			}
+			checkGolden(t, "render/"+tt.name+".golden", yamlStream(t, objects))
+		})
+	}
+}
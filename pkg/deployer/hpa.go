@@ -0,0 +1,207 @@
+package deployer
+
+import (
+	"errors"
+	"fmt"
+	autoscalingv2beta2 "k8s.io/api/autoscaling/v2beta2"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"strconv"
+	"strings"
+)
+
+// podMetricsGroupKind is served by metrics-server, which HPAs read CPU and
+// memory usage from.
+var podMetricsGroupKind = schema.GroupKind{Group: "metrics.k8s.io", Kind: "PodMetrics"}
+
+// Autoscale configures the HorizontalPodAutoscaler of the API deployment.
+type Autoscale struct {
+	// Min and Max bound the replica count.
+	Min, Max int32
+	// CPU and Memory are target average utilizations in percent of the
+	// containers' requests; zero leaves the resource out.
+	CPU, Memory int32
+}
+
+// ParseAutoscale parses the -autoscale flag format,
+// "min=2,max=10,cpu=70[,memory=80]". Min defaults to 1.
+func ParseAutoscale(s string) (*Autoscale, error) {
+	a := &Autoscale{Min: 1}
+	for _, field := range strings.Split(s, ",") {
+		eq := strings.Index(field, "=")
+		if eq < 0 {
+			return nil, fmt.Errorf("%q: %q must be in key=value form", s, field)
+		}
+		n, err := strconv.ParseInt(field[eq+1:], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("%q: %s must be a number", s, field[:eq])
+		}
+		switch key := field[:eq]; key {
+		case "min":
+			a.Min = int32(n)
+		case "max":
+			a.Max = int32(n)
+		case "cpu":
+			a.CPU = int32(n)
+		case "memory":
+			a.Memory = int32(n)
+		default:
+			return nil, fmt.Errorf("%q: unknown key %q, expected min, max, cpu or memory", s, key)
+		}
+	}
+	return a, nil
+}
+
+// validateAutoscale checks the replica bounds and that at least one positive
+// utilization target is set.
+func (o Options) validateAutoscale() error {
+	a := o.Autoscale
+	if a == nil {
+		return nil
+	}
+	switch {
+	case a.Min < 1:
+		return &ValidationError{Field: "autoscale", Err: fmt.Errorf("min must be at least 1, got %d", a.Min)}
+	case a.Max < a.Min:
+		return &ValidationError{Field: "autoscale", Err: fmt.Errorf("max (%d) must not be below min (%d)", a.Max, a.Min)}
+	case a.Max > o.MaxReplicas:
+		return &ValidationError{Field: "autoscale", Err: fmt.Errorf("max must be at most %d, got %d; raise -max-replicas to allow more", o.MaxReplicas, a.Max)}
+	case a.CPU < 0 || a.Memory < 0:
+		return &ValidationError{Field: "autoscale", Err: errors.New("utilization targets must be positive")}
+	case a.CPU == 0 && a.Memory == 0:
+		return &ValidationError{Field: "autoscale", Err: errors.New("set a cpu or memory utilization target")}
+	}
+	return nil
+}
+
+// newHPA returns the HPA scaling the API deployment, or nil when
+// Options.Autoscale is unset.
+func newHPA(opts Options) *unstructured.Unstructured {
+	a := opts.Autoscale
+	if a == nil {
+		return nil
+	}
+	var metrics []interface{}
+	for _, m := range a.metrics() {
+		metrics = append(metrics, map[string]interface{}{
+			"type": "Resource",
+			"resource": map[string]interface{}{
+				"name": string(m.name),
+				"target": map[string]interface{}{
+					"type":               "Utilization",
+					"averageUtilization": int64(m.utilization),
+				},
+			},
+		})
+	}
+	return &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "autoscaling/v2",
+			"kind":       "HorizontalPodAutoscaler",
+			"metadata": map[string]interface{}{
+				"name":      opts.deploymentName(),
+				"namespace": opts.Namespace,
+			},
+			"spec": map[string]interface{}{
+				"scaleTargetRef": map[string]interface{}{
+					"apiVersion": "apps/v1",
+					"kind":       "Deployment",
+					"name":       opts.deploymentName(),
+				},
+				"minReplicas": int64(a.Min),
+				"maxReplicas": int64(a.Max),
+				"metrics":     metrics,
+			},
+		},
+	}
+}
+
+// BuildHPA returns the HPA scaling the API deployment, or nil when
+// Options.Autoscale is unset. k8s.io/api has no autoscaling/v2 types yet, so
+// it is built from the v2beta2 ones, which share the schema, under the v2
+// apiVersion.
+func BuildHPA(opts Options) *autoscalingv2beta2.HorizontalPodAutoscaler {
+	opts = opts.withDefaults()
+	a := opts.Autoscale
+	if a == nil {
+		return nil
+	}
+	var metrics []autoscalingv2beta2.MetricSpec
+	for _, m := range a.metrics() {
+		utilization := m.utilization
+		metrics = append(metrics, autoscalingv2beta2.MetricSpec{
+			Type: autoscalingv2beta2.ResourceMetricSourceType,
+			Resource: &autoscalingv2beta2.ResourceMetricSource{
+				Name: m.name,
+				Target: autoscalingv2beta2.MetricTarget{
+					Type:               autoscalingv2beta2.UtilizationMetricType,
+					AverageUtilization: &utilization,
+				},
+			},
+		})
+	}
+	minReplicas := a.Min
+	return &autoscalingv2beta2.HorizontalPodAutoscaler{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "autoscaling/v2", Kind: "HorizontalPodAutoscaler"},
+		ObjectMeta: metav1.ObjectMeta{Name: opts.deploymentName(), Namespace: opts.Namespace},
+		Spec: autoscalingv2beta2.HorizontalPodAutoscalerSpec{
+			ScaleTargetRef: autoscalingv2beta2.CrossVersionObjectReference{APIVersion: "apps/v1", Kind: "Deployment", Name: opts.deploymentName()},
+			MinReplicas:    &minReplicas,
+			MaxReplicas:    a.Max,
+			Metrics:        metrics,
+		},
+	}
+}
+
+type resourceTarget struct {
+	name        corev1.ResourceName
+	utilization int32
+}
+
+func (a *Autoscale) metrics() []resourceTarget {
+	var targets []resourceTarget
+	if a.CPU > 0 {
+		targets = append(targets, resourceTarget{corev1.ResourceCPU, a.CPU})
+	}
+	if a.Memory > 0 {
+		targets = append(targets, resourceTarget{corev1.ResourceMemory, a.Memory})
+	}
+	return targets
+}
+
+// checkAutoscaling warns when Options.Autoscale is set but metrics.k8s.io
+// is missing, since the HPA then never scales. Without autoscaling/v2 the
+// HPA is skipped instead; see Skipped.
+func (d *Deployer) checkAutoscaling() error {
+	if d.opts.Autoscale == nil || len(d.opts.Manifests) > 0 {
+		return nil
+	}
+	if served, err := d.servesHPA(); err != nil || !served {
+		return err
+	}
+
+	_, err := d.mapper.RESTMapping(podMetricsGroupKind)
+	switch {
+	case meta.IsNoMatchError(err):
+		d.log.Info("Warning: the cluster doesn't serve metrics.k8s.io, so metrics-server appears to be missing and the HPA will never scale; " +
+			"install it from https://github.com/kubernetes-sigs/metrics-server")
+	case err != nil:
+		d.log.Error(err, "Couldn't check for metrics.k8s.io")
+	}
+	return nil
+}
+
+// servesHPA reports whether the cluster serves autoscaling/v2.
+func (d *Deployer) servesHPA() (bool, error) {
+	_, err := d.mapper.RESTMapping(schema.GroupKind{Group: HPAResource.Group, Kind: "HorizontalPodAutoscaler"}, HPAResource.Version)
+	if meta.IsNoMatchError(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to discover the autoscaling API: %w", err)
+	}
+	return true, nil
+}
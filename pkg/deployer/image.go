@@ -0,0 +1,86 @@
+package deployer
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// DefaultImage is the container image deployed when Options.Image is empty.
+const DefaultImage = "raihankhanraka/ecommerce-api:v1.1"
+
+// The patterns follow the reference grammar of the distribution project.
+var (
+	imageNameRegexp   = regexp.MustCompile(`^(?:(?:[a-zA-Z0-9]|[a-zA-Z0-9][a-zA-Z0-9-]*[a-zA-Z0-9])(?:\.(?:[a-zA-Z0-9]|[a-zA-Z0-9][a-zA-Z0-9-]*[a-zA-Z0-9]))*(?::[0-9]+)?/)?[a-z0-9]+(?:(?:[._]|__|[-]*)[a-z0-9]+)*(?:/[a-z0-9]+(?:(?:[._]|__|[-]*)[a-z0-9]+)*)*$`)
+	imageTagRegexp    = regexp.MustCompile(`^[\w][\w.-]{0,127}$`)
+	imageDigestRegexp = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9]*(?:[-_+.][A-Za-z][A-Za-z0-9]*)*:[0-9a-fA-F]{32,}$`)
+)
+
+// ImageReference is a parsed container image reference.
+type ImageReference struct {
+	Name   string
+	Tag    string
+	Digest string
+}
+
+func (r ImageReference) String() string {
+	s := r.Name
+	if r.Tag != "" {
+		s += ":" + r.Tag
+	}
+	if r.Digest != "" {
+		s += "@" + r.Digest
+	}
+	return s
+}
+
+// ParseImageReference parses and validates ref. A reference must carry a tag
+// or a digest; relying on an implicit latest tag is rejected.
+func ParseImageReference(ref string) (ImageReference, error) {
+	var r ImageReference
+	name := ref
+	if i := strings.Index(name, "@"); i >= 0 {
+		name, r.Digest = name[:i], name[i+1:]
+		if !imageDigestRegexp.MatchString(r.Digest) {
+			return ImageReference{}, fmt.Errorf("invalid image reference %q: malformed digest", ref)
+		}
+	}
+	// A colon after the last slash separates the tag; earlier ones belong to
+	// a registry port.
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name, r.Tag = name[:i], name[i+1:]
+		if r.Tag == "" {
+			return ImageReference{}, fmt.Errorf("invalid image reference %q: empty tag", ref)
+		}
+		if !imageTagRegexp.MatchString(r.Tag) {
+			return ImageReference{}, fmt.Errorf("invalid image reference %q: malformed tag %q", ref, r.Tag)
+		}
+	}
+	if len(name) > 255 || !imageNameRegexp.MatchString(name) {
+		return ImageReference{}, fmt.Errorf("invalid image reference %q: malformed repository name", ref)
+	}
+	if r.Tag == "" && r.Digest == "" {
+		return ImageReference{}, fmt.Errorf("invalid image reference %q: a tag or digest is required", ref)
+	}
+	r.Name = name
+	return r, nil
+}
+
+// WithTag returns image with its tag replaced by tag. An empty tag leaves
+// image unchanged.
+func WithTag(image, tag string) (string, error) {
+	if tag == "" {
+		return image, nil
+	}
+	if !imageTagRegexp.MatchString(tag) {
+		return "", fmt.Errorf("invalid image tag %q", tag)
+	}
+	name := image
+	if i := strings.Index(name, "@"); i >= 0 {
+		name = name[:i]
+	}
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name = name[:i]
+	}
+	return name + ":" + tag, nil
+}
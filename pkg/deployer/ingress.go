@@ -0,0 +1,298 @@
+package deployer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sort"
+	"strings"
+)
+
+// CreateIngress creates or updates the server-ingress routing Options.Hosts to
+// server-svc, as a v1beta1 ingress on clusters that don't serve v1.
+func (d *Deployer) CreateIngress(ctx context.Context) (*unstructured.Unstructured, error) {
+	obj, err := d.servedIngress(newIngress(d.opts))
+	if err != nil {
+		return nil, err
+	}
+	if obj == nil {
+		return nil, errIngressNotServed
+	}
+	if obj, err = d.desiredIngress(ctx, obj); err != nil {
+		return nil, err
+	}
+	o, err := d.mapObject(obj)
+	if err != nil {
+		return nil, err
+	}
+	return d.apply(ctx, o.gvr, o.obj)
+}
+
+// DefaultHost is the ingress host used when Options.Hosts is empty.
+const DefaultHost = "raka.com"
+
+// IngressPath is a path the ingress routes to the API service.
+type IngressPath struct {
+	Path string
+	// PathType is Prefix, Exact or ImplementationSpecific.
+	PathType string
+	// Port is the name of the Options.Ports port the path is routed to;
+	// empty means the first.
+	Port string
+}
+
+// DefaultPaths route everything under / to the API when Options.Paths is nil.
+var DefaultPaths = []IngressPath{{Path: "/", PathType: "Prefix"}}
+
+// ParseIngressPath parses "path:pathType" or "path:pathType:port", e.g.
+// "/api/v2:Exact" or "/grpc:Prefix:grpc". Without a pathType the path is a
+// Prefix. It doesn't validate the result.
+func ParseIngressPath(s string) IngressPath {
+	parts := strings.Split(s, ":")
+	p := IngressPath{Path: s, PathType: "Prefix"}
+	switch n := len(parts); {
+	case n == 2:
+		p.Path, p.PathType = parts[0], parts[1]
+	case n > 2:
+		p.Path, p.PathType, p.Port = strings.Join(parts[:n-2], ":"), parts[n-2], parts[n-1]
+	}
+	if p.PathType == "" {
+		p.PathType = "Prefix"
+	}
+	return p
+}
+
+// pathPort returns the name of the port p is routed to.
+func (o Options) pathPort(p IngressPath) string {
+	if p.Port == "" {
+		return o.ports()[0].Name
+	}
+	return p.Port
+}
+
+// validatePaths checks that every path is absolute with a known pathType and
+// a declared port, and that none repeats.
+func (o Options) validatePaths() error {
+	seen := map[IngressPath]bool{}
+	for _, p := range o.Paths {
+		if !strings.HasPrefix(p.Path, "/") {
+			return &ValidationError{Field: "path", Err: fmt.Errorf("%q must start with /", p.Path)}
+		}
+		switch p.PathType {
+		case "Prefix", "Exact", "ImplementationSpecific":
+		default:
+			return &ValidationError{Field: "path", Err: fmt.Errorf("%s: pathType must be Prefix, Exact or ImplementationSpecific, got %q", p.Path, p.PathType)}
+		}
+		if p.Port != "" && !o.hasPort(p.Port) {
+			return &ValidationError{Field: "path", Err: fmt.Errorf("%s: no -port is named %q", p.Path, p.Port)}
+		}
+		key := IngressPath{Path: p.Path, PathType: p.PathType}
+		if seen[key] {
+			return &ValidationError{Field: "path", Err: fmt.Errorf("%s:%s is given more than once", p.Path, p.PathType)}
+		}
+		seen[key] = true
+	}
+	return nil
+}
+
+// validateHosts checks that every host is a DNS name, optionally with a
+// single leading wildcard label, and that none repeats.
+func validateHosts(hosts []string) error {
+	seen := map[string]bool{}
+	for _, h := range hosts {
+		var errs []string
+		if strings.HasPrefix(h, "*.") {
+			errs = validation.IsWildcardDNS1123Subdomain(h)
+		} else {
+			errs = validation.IsDNS1123Subdomain(h)
+		}
+		if len(errs) > 0 {
+			return &ValidationError{Field: "host", Err: fmt.Errorf("%q: %s", h, strings.Join(errs, "; "))}
+		}
+		if seen[h] {
+			return &ValidationError{Field: "host", Err: fmt.Errorf("%q is given more than once", h)}
+		}
+		seen[h] = true
+	}
+	return nil
+}
+
+// newIngress renders the embedded ingress, repeating its rule for each of
+// Options.Hosts and its path for each of Options.Paths, in the given order.
+func newIngress(opts Options) *unstructured.Unstructured {
+	obj := defaultManifest("ingress.yaml")
+	obj.SetName(opts.ingressName())
+	obj.SetNamespace(opts.Namespace)
+	rules, _, _ := unstructured.NestedSlice(obj.Object, "spec", "rules")
+	templatePaths, _, _ := unstructured.NestedSlice(rules[0].(map[string]interface{}), "http", "paths")
+	paths := make([]interface{}, 0, len(opts.Paths))
+	for _, p := range opts.Paths {
+		path := runtime.DeepCopyJSONValue(templatePaths[0]).(map[string]interface{})
+		path["path"] = p.Path
+		path["pathType"] = p.PathType
+		_ = unstructured.SetNestedField(path, opts.serviceName(), "backend", "service", "name")
+		_ = unstructured.SetNestedField(path, map[string]interface{}{"name": opts.pathPort(p)}, "backend", "service", "port")
+		paths = append(paths, path)
+	}
+	hostRules := make([]interface{}, 0, len(opts.Hosts))
+	for _, host := range opts.Hosts {
+		rule := runtime.DeepCopyJSONValue(rules[0]).(map[string]interface{})
+		rule["host"] = host
+		_ = unstructured.SetNestedSlice(rule, runtime.DeepCopyJSONValue(paths).([]interface{}), "http", "paths")
+		hostRules = append(hostRules, rule)
+	}
+	_ = unstructured.SetNestedSlice(obj.Object, hostRules, "spec", "rules")
+	if opts.IngressClass != "" {
+		_ = unstructured.SetNestedField(obj.Object, opts.IngressClass, "spec", "ingressClassName")
+	}
+	if annotations := ingressAnnotations(opts); annotations != nil {
+		obj.SetAnnotations(annotations)
+	}
+	if tls := ingressTLS(opts, ingressHosts(obj)); tls != nil {
+		_ = unstructured.SetNestedSlice(obj.Object, tls, "spec", "tls")
+	}
+	return obj
+}
+
+// defaultIngressClassAnnotation marks the IngressClass used for ingresses
+// that don't name one.
+const defaultIngressClassAnnotation = "ingressclass.kubernetes.io/is-default-class"
+
+// desiredIngress returns the ingress obj to apply. Without a class set, the
+// cluster's default IngressClass is looked up and written into it, so the
+// ingress is admitted on clusters running several controllers. The
+// ingress-nginx annotations are added when the class belongs to it.
+func (d *Deployer) desiredIngress(ctx context.Context, obj *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	if class, _, _ := unstructured.NestedString(obj.Object, "spec", "ingressClassName"); class != "" {
+		d.log.Info("Using IngressClass", "class", class)
+		return d.withNginxAnnotations(ctx, obj, class)
+	}
+
+	gvr := schema.GroupVersionResource{Group: ingressGroupKind.Group, Version: obj.GroupVersionKind().Version, Resource: "ingressclasses"}
+	classes, err := d.client.Resource(gvr).List(ctx, metav1.ListOptions{})
+	switch {
+	case apierrors.IsNotFound(err):
+		// Clusters older than IngressClass pick the controller by annotation.
+		return obj, nil
+	case apierrors.IsForbidden(err):
+		return nil, d.opError(OpList, gvr, "", fmt.Errorf("%w; pass -ingress-class to name the class instead", err))
+	case err != nil:
+		return nil, d.opError(OpList, gvr, "", err)
+	}
+
+	var names []string
+	for _, c := range classes.Items {
+		if c.GetAnnotations()[defaultIngressClassAnnotation] == "true" {
+			d.log.Info("Using the default IngressClass", "class", c.GetName())
+			obj = obj.DeepCopy()
+			_ = unstructured.SetNestedField(obj.Object, c.GetName(), "spec", "ingressClassName")
+			return d.withNginxAnnotations(ctx, obj, c.GetName())
+		}
+		names = append(names, c.GetName())
+	}
+	sort.Strings(names)
+	if len(names) == 0 {
+		return nil, &ValidationError{Field: "ingress-class", Err: errors.New("the cluster has no IngressClass; install an ingress controller or pass -ingress-class")}
+	}
+	return nil, &ValidationError{Field: "ingress-class", Err: fmt.Errorf("the cluster has no default IngressClass; pass one of %s", strings.Join(names, ", "))}
+}
+
+// ingressGroupKind is the Ingress kind in networking.k8s.io, which is served
+// as v1 from Kubernetes 1.19 and only as v1beta1 before that.
+var ingressGroupKind = schema.GroupKind{Group: "networking.k8s.io", Kind: "Ingress"}
+
+// errIngressNotServed is the reason the built-in ingress is skipped on
+// clusters that serve neither Ingress version.
+var errIngressNotServed = errors.New("neither networking.k8s.io/v1 nor v1beta1 Ingress is served by the cluster")
+
+// servedIngressVersion returns the networking.k8s.io version Ingress is
+// served under, preferring v1, or "" when the cluster serves neither.
+func (d *Deployer) servedIngressVersion() (string, error) {
+	mappings, err := d.mapper.RESTMappings(ingressGroupKind, "v1", "v1beta1")
+	if meta.IsNoMatchError(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to discover the served Ingress version: %w", err)
+	}
+	version := ""
+	for _, m := range mappings {
+		switch m.GroupVersionKind.Version {
+		case "v1":
+			return "v1", nil
+		case "v1beta1":
+			version = "v1beta1"
+		}
+	}
+	return version, nil
+}
+
+// servedIngress adapts the built-in v1 ingress to the version the cluster
+// serves. It returns nil when the cluster serves no Ingress API.
+func (d *Deployer) servedIngress(obj *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	version, err := d.servedIngressVersion()
+	switch {
+	case err != nil:
+		return nil, err
+	case version == "":
+		return nil, nil
+	case version == "v1beta1":
+		d.log.V(1).Info("Only networking.k8s.io/v1beta1 Ingress is served, converting", "name", obj.GetName())
+		return toV1beta1Ingress(obj), nil
+	}
+	return obj, nil
+}
+
+// toV1beta1Ingress converts a networking.k8s.io/v1 ingress to v1beta1, whose
+// backends name the service and port directly instead of nesting them.
+func toV1beta1Ingress(obj *unstructured.Unstructured) *unstructured.Unstructured {
+	obj = obj.DeepCopy()
+	obj.SetAPIVersion("networking.k8s.io/v1beta1")
+
+	if backend, found, _ := unstructured.NestedMap(obj.Object, "spec", "defaultBackend"); found {
+		unstructured.RemoveNestedField(obj.Object, "spec", "defaultBackend")
+		_ = unstructured.SetNestedMap(obj.Object, v1beta1Backend(backend), "spec", "backend")
+	}
+	rules, _, _ := unstructured.NestedSlice(obj.Object, "spec", "rules")
+	for _, r := range rules {
+		paths, _, _ := unstructured.NestedSlice(r.(map[string]interface{}), "http", "paths")
+		for _, p := range paths {
+			path := p.(map[string]interface{})
+			if backend, ok := path["backend"].(map[string]interface{}); ok {
+				path["backend"] = v1beta1Backend(backend)
+			}
+		}
+		if len(paths) > 0 {
+			_ = unstructured.SetNestedSlice(r.(map[string]interface{}), paths, "http", "paths")
+		}
+	}
+	if len(rules) > 0 {
+		_ = unstructured.SetNestedSlice(obj.Object, rules, "spec", "rules")
+	}
+	return obj
+}
+
+// v1beta1Backend turns {service: {name, port: {number|name}}} into
+// {serviceName, servicePort}. Resource backends are the same in both.
+func v1beta1Backend(backend map[string]interface{}) map[string]interface{} {
+	service, ok := backend["service"].(map[string]interface{})
+	if !ok {
+		return backend
+	}
+	out := map[string]interface{}{"serviceName": service["name"]}
+	if port, ok := service["port"].(map[string]interface{}); ok {
+		if number, ok := port["number"]; ok {
+			out["servicePort"] = number
+		} else {
+			out["servicePort"] = port["name"]
+		}
+	}
+	return out
+}
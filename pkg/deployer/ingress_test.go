@@ -0,0 +1,86 @@
+package deployer
+
+import (
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"reflect"
+	"testing"
+)
+
+func TestIngressHosts(t *testing.T) {
+	tests := []struct {
+		name      string
+		hosts     []string
+		tlsSecret string
+		wantHosts []string
+		wantErr   bool
+	}{
+		{name: "zero hosts", hosts: []string{}, wantErr: true},
+		{name: "default host", wantHosts: []string{DefaultHost}},
+		{name: "one host", hosts: []string{"shop.example.com"}, wantHosts: []string{"shop.example.com"}},
+		{name: "several hosts", hosts: []string{"www.shop.example.com", "shop.example.com"}, wantHosts: []string{"www.shop.example.com", "shop.example.com"}},
+		{name: "wildcard host", hosts: []string{"*.shop.example.com"}, wantHosts: []string{"*.shop.example.com"}},
+		{name: "wildcard host with TLS", hosts: []string{"shop.example.com", "*.shop.example.com"}, tlsSecret: "shop-tls", wantHosts: []string{"shop.example.com", "*.shop.example.com"}},
+		{name: "duplicate host", hosts: []string{"shop.example.com", "shop.example.com"}, wantErr: true},
+		{name: "duplicate wildcard host", hosts: []string{"*.example.com", "*.example.com"}, wantErr: true},
+		{name: "bare wildcard", hosts: []string{"*"}, wantErr: true},
+		{name: "wildcard without a dot", hosts: []string{"*example.com"}, wantErr: true},
+		{name: "two wildcard labels", hosts: []string{"*.*.example.com"}, wantErr: true},
+		{name: "inner wildcard", hosts: []string{"shop.*.example.com"}, wantErr: true},
+		{name: "partial wildcard label", hosts: []string{"shop*.example.com"}, wantErr: true},
+		{name: "upper case", hosts: []string{"Shop.example.com"}, wantErr: true},
+		{name: "port", hosts: []string{"shop.example.com:443"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := Options{Hosts: tt.hosts, TLSSecret: tt.tlsSecret}
+			err := opts.Validate()
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("Validate accepted the hosts")
+				}
+				if verr, ok := err.(*ValidationError); !ok || verr.Field != "host" {
+					t.Errorf("got %T %v, want a ValidationError of host", err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Validate: %v", err)
+			}
+
+			ingress := newIngress(opts.withDefaults())
+			rules, _, _ := unstructured.NestedSlice(ingress.Object, "spec", "rules")
+			var hosts []string
+			for _, r := range rules {
+				rule := r.(map[string]interface{})
+				hosts = append(hosts, rule["host"].(string))
+				paths, _, _ := unstructured.NestedSlice(rule, "http", "paths")
+				if len(paths) != 1 {
+					t.Fatalf("rule of %s has %d paths, want 1", rule["host"], len(paths))
+				}
+				path := paths[0].(map[string]interface{})
+				service, _, _ := unstructured.NestedString(path, "backend", "service", "name")
+				if path["path"] != "/" || path["pathType"] != "Prefix" || service != "server-svc" {
+					t.Errorf("rule of %s routes %v", rule["host"], path)
+				}
+			}
+			if !reflect.DeepEqual(hosts, tt.wantHosts) {
+				t.Errorf("got rules for %q, want %q", hosts, tt.wantHosts)
+			}
+
+			tls, found, _ := unstructured.NestedSlice(ingress.Object, "spec", "tls")
+			if tt.tlsSecret == "" {
+				if found {
+					t.Errorf("got TLS %v without a secret", tls)
+				}
+				return
+			}
+			var tlsHosts []string
+			for _, h := range tls[0].(map[string]interface{})["hosts"].([]interface{}) {
+				tlsHosts = append(tlsHosts, h.(string))
+			}
+			if len(tls) != 1 || tls[0].(map[string]interface{})["secretName"] != tt.tlsSecret || !reflect.DeepEqual(tlsHosts, tt.wantHosts) {
+				t.Errorf("got TLS %v, want %s for %q", tls, tt.tlsSecret, tt.wantHosts)
+			}
+		})
+	}
+}
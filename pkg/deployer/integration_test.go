@@ -0,0 +1,153 @@
+//go:build integration
+// +build integration
+
+package deployer
+
+import (
+	"context"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/rest"
+	"os"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+	"testing"
+	"time"
+)
+
+// The integration tests run the deployer against a real apiserver, backed
+// by etcd, that envtest starts from the binaries in KUBEBUILDER_ASSETS:
+//
+//	KUBEBUILDER_ASSETS=$(setup-envtest use -p path) go test -tags integration ./pkg/deployer
+//
+// There is no controller manager, so deployments never roll out, but the
+// apiserver defaults, allocates and deletes as a cluster's does.
+
+// startAPIServer starts etcd and kube-apiserver for the test, stopped when
+// it ends, and returns the config of a cluster admin.
+func startAPIServer(t *testing.T) *rest.Config {
+	t.Helper()
+	if os.Getenv("KUBEBUILDER_ASSETS") == "" {
+		t.Skip("KUBEBUILDER_ASSETS is not set")
+	}
+	env := &envtest.Environment{}
+	config, err := env.Start()
+	if err != nil {
+		t.Fatalf("start the control plane: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := env.Stop(); err != nil {
+			t.Errorf("stop the control plane: %v", err)
+		}
+	})
+	return config
+}
+
+func TestIntegrationDeployAndDelete(t *testing.T) {
+	config := startAPIServer(t)
+	ctx := context.Background()
+	d, err := NewForConfig(config, Options{
+		Namespace:       "shop",
+		CreateNamespace: true,
+		IngressClass:    "nginx",
+		ExposeNodePort:  true,
+	})
+	if err != nil {
+		t.Fatalf("NewForConfig: %v", err)
+	}
+
+	result, err := d.Deploy(ctx, DeployOptions{})
+	if err != nil {
+		t.Fatalf("Deploy: %v", err)
+	}
+	if len(result.Applied) == 0 {
+		t.Fatal("nothing was applied")
+	}
+	for _, r := range result.Applied {
+		if r.Action != ActionCreated {
+			t.Errorf("%s %s: got action %q, want %q", r.Kind, r.Name, r.Action, ActionCreated)
+		}
+	}
+
+	t.Run("deployment defaults", func(t *testing.T) {
+		dep, err := d.resource(DeploymentResource).Get(ctx, d.DeploymentName(), metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, field := range [][]string{
+			{"spec", "revisionHistoryLimit"},
+			{"spec", "progressDeadlineSeconds"},
+			{"spec", "strategy", "type"},
+			{"spec", "template", "spec", "restartPolicy"},
+			{"spec", "template", "spec", "dnsPolicy"},
+			{"spec", "template", "spec", "schedulerName"},
+		} {
+			if _, found, _ := unstructured.NestedFieldNoCopy(dep.Object, field...); !found {
+				t.Errorf("%v wasn't defaulted", field)
+			}
+		}
+		containers, _, _ := unstructured.NestedSlice(dep.Object, "spec", "template", "spec", "containers")
+		for _, c := range containers {
+			c := c.(map[string]interface{})
+			if c["terminationMessagePolicy"] != "File" || c["imagePullPolicy"] == nil {
+				t.Errorf("container %v wasn't defaulted: %v", c["name"], c)
+			}
+		}
+	})
+
+	t.Run("node port allocated", func(t *testing.T) {
+		svc, err := d.resource(ServiceResource).Get(ctx, d.opts.nodePortService(), metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ports := NodePorts(svc)
+		if len(ports) == 0 {
+			t.Fatalf("%s has no node port", svc.GetName())
+		}
+		for _, p := range ports {
+			if p < 30000 || p > 32767 {
+				t.Errorf("node port %d is outside the default range", p)
+			}
+		}
+	})
+
+	t.Run("reapply is a no-op", func(t *testing.T) {
+		again, err := d.Deploy(ctx, DeployOptions{})
+		if err != nil {
+			t.Fatalf("Deploy: %v", err)
+		}
+		versions := map[string]string{}
+		for _, r := range result.Applied {
+			versions[r.Kind+"/"+r.Name] = r.Object.GetResourceVersion()
+		}
+		for _, r := range again.Applied {
+			if r.Action != ActionUnchanged {
+				t.Errorf("%s %s: got action %q, want %q", r.Kind, r.Name, r.Action, ActionUnchanged)
+			}
+			if rv := r.Object.GetResourceVersion(); rv != versions[r.Kind+"/"+r.Name] {
+				t.Errorf("%s %s: resourceVersion went from %s to %s", r.Kind, r.Name, versions[r.Kind+"/"+r.Name], rv)
+			}
+		}
+	})
+
+	t.Run("delete with wait", func(t *testing.T) {
+		for _, r := range d.DeleteAll(ctx, DeleteOptions{Wait: true, Timeout: time.Minute}) {
+			if r.Outcome != Deleted {
+				t.Errorf("%s %s: %s %v", r.Kind, r.Name, r.Outcome, r.Err)
+			}
+		}
+		for _, r := range result.Applied {
+			if r.Kind == "Namespace" {
+				continue
+			}
+			o, err := d.mapObject(r.Object)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = d.resourceFor(o.gvr, o.obj).Get(ctx, r.Name, metav1.GetOptions{})
+			if !apierrors.IsNotFound(err) {
+				t.Errorf("%s %s is left behind: %v", r.Kind, r.Name, err)
+			}
+		}
+	})
+}
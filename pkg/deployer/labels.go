@@ -0,0 +1,137 @@
+package deployer
+
+import (
+	"fmt"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"strings"
+)
+
+// The recommended labels set on every built-in object, next to
+// ManagedByLabel. Releases other than DefaultRelease select their pods by
+// name, instance and component, which never change; VersionLabel, which
+// changes with each image tag, is never part of a selector.
+const (
+	NameLabel      = "app.kubernetes.io/name"
+	InstanceLabel  = "app.kubernetes.io/instance"
+	VersionLabel   = "app.kubernetes.io/version"
+	ComponentLabel = "app.kubernetes.io/component"
+
+	appName = "ecommerce-api"
+	// The values of ComponentLabel: the database objects, the cache
+	// objects, and everything else; the cron jobs have their own.
+	componentAPI      = "api"
+	componentDatabase = "database"
+	componentCache    = "cache"
+)
+
+// imageVersion returns the tag of image, "latest" when it has none, or ""
+// when it is pinned by digest alone or the tag isn't a valid label value.
+func imageVersion(image string) string {
+	if at := strings.Index(image, "@"); at >= 0 {
+		if !strings.Contains(image[strings.LastIndex(image[:at], "/")+1:at], ":") {
+			return ""
+		}
+		image = image[:at]
+	}
+	tag := "latest"
+	if colon := strings.LastIndex(image, ":"); colon > strings.LastIndex(image, "/") {
+		tag = image[colon+1:]
+	}
+	if len(validation.IsValidLabelValue(tag)) > 0 {
+		return ""
+	}
+	return tag
+}
+
+// commonLabels returns Options.Labels and the recommended labels of an
+// object of component.
+func (o Options) commonLabels(component string) map[string]string {
+	labels := make(map[string]string, len(o.Labels)+5)
+	for k, v := range o.Labels {
+		labels[k] = v
+	}
+	labels[NameLabel] = appName
+	labels[InstanceLabel] = o.release()
+	labels[ComponentLabel] = component
+	labels[ManagedByLabel] = ManagedBy
+	image := o.Image
+	if o.PinnedFrom != "" {
+		image = o.PinnedFrom
+	}
+	if v := imageVersion(image); v != "" {
+		labels[VersionLabel] = v
+	}
+	return labels
+}
+
+// validateLabels checks Options.Labels and Options.Annotations are valid and
+// leave the recommended labels alone.
+func (o Options) validateLabels() error {
+	for _, k := range sortedKeys(o.Labels) {
+		switch k {
+		case NameLabel, InstanceLabel, VersionLabel, ComponentLabel, ManagedByLabel:
+			return &ValidationError{Field: "label", Err: fmt.Errorf("%s is set by the deployer", k)}
+		}
+		errs := append(validation.IsQualifiedName(k), validation.IsValidLabelValue(o.Labels[k])...)
+		if len(errs) > 0 {
+			return &ValidationError{Field: "label", Err: fmt.Errorf("%s=%s: %s", k, o.Labels[k], strings.Join(errs, "; "))}
+		}
+	}
+	for _, k := range sortedKeys(o.Annotations) {
+		if errs := validation.IsQualifiedName(strings.ToLower(k)); len(errs) > 0 {
+			return &ValidationError{Field: "annotation", Err: fmt.Errorf("%s: %s", k, strings.Join(errs, "; "))}
+		}
+	}
+	return nil
+}
+
+// labelObjects sets the common labels and Options.Annotations on the
+// built-in objects and on the pod templates of their workloads. Both
+// builders go through it, so their objects carry the same metadata. Labels
+// and annotations an object already has take precedence.
+func labelObjects(objects []*unstructured.Unstructured, opts Options) []*unstructured.Unstructured {
+	for _, obj := range objects {
+		component := componentAPI
+		switch name := obj.GetName(); {
+		case name == opts.postgresName() || name == opts.postgresSecretName():
+			component = componentDatabase
+		case opts.Redis && (name == opts.redisName() || name == opts.redisSecretName()):
+			component = componentCache
+		case obj.GetKind() == "CronJob":
+			component = componentCronJob
+		}
+		labels := opts.commonLabels(component)
+		obj.SetLabels(merge(labels, obj.GetLabels()))
+		obj.SetAnnotations(merge(opts.Annotations, obj.GetAnnotations()))
+		if _, ok, _ := unstructured.NestedMap(obj.Object, "spec", "template"); !ok {
+			continue
+		}
+		for field, values := range map[string]map[string]string{"labels": labels, "annotations": opts.Annotations} {
+			path := []string{"spec", "template", "metadata", field}
+			existing, _, _ := unstructured.NestedStringMap(obj.Object, path...)
+			if merged := merge(values, existing); len(merged) > 0 {
+				if err := unstructured.SetNestedStringMap(obj.Object, merged, path...); err != nil {
+					panic(err)
+				}
+			}
+		}
+	}
+	return objects
+}
+
+// merge returns the entries of base overridden by those of over, or nil
+// when both are empty.
+func merge(base, over map[string]string) map[string]string {
+	if len(base)+len(over) == 0 {
+		return nil
+	}
+	merged := make(map[string]string, len(base)+len(over))
+	for k, v := range base {
+		merged[k] = v
+	}
+	for k, v := range over {
+		merged[k] = v
+	}
+	return merged
+}
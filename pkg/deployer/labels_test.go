@@ -0,0 +1,159 @@
+package deployer
+
+import (
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"reflect"
+	"testing"
+)
+
+// labelledOptions returns options turning on every built-in object with
+// labels of its own, a pod template or a selector.
+func labelledOptions(release, image string) Options {
+	minAvailable := intstr.FromInt(1)
+	return Options{
+		Release:        release,
+		Image:          image,
+		Labels:         map[string]string{"team": "payments"},
+		Annotations:    map[string]string{"example.com/owner": "payments@example.com"},
+		ExposeNodePort: true,
+		Autoscale:      &Autoscale{Min: 2, Max: 5, CPU: 80},
+		NetworkPolicy:  true,
+		RBAC:           true,
+		Config:         map[string]string{"LOG_LEVEL": "debug"},
+		DBCredentials:  map[string]string{"DB_PASSWORD": "hunter2"},
+		PVCs:           []PVC{{Name: "uploads", Size: "1Gi", MountPath: "/uploads"}},
+		Postgres:       true,
+		Redis:          true,
+		CronJobs:       []CronJob{{Name: "cleanup", Schedule: "0 * * * *", Command: "cleanup"}},
+		// The disruption budget has a selector but no pod template.
+		PDBMinAvailable: &minAvailable,
+	}
+}
+
+func TestLabels(t *testing.T) {
+	tests := []struct {
+		name     string
+		release  string
+		instance string
+	}{
+		{"default release", "", DefaultRelease},
+		{"release", "shop", "shop"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			objects, err := Render(labelledOptions(tt.release, "ghcr.io/acme/ecommerce-api:v2.0"))
+			if err != nil {
+				t.Fatalf("Render: %v", err)
+			}
+			components := map[string]bool{}
+			for _, obj := range objects {
+				ref := obj.GetKind() + "/" + obj.GetName()
+				labels := obj.GetLabels()
+				checkLabelSet(t, ref, labels, tt.instance)
+				components[labels[ComponentLabel]] = true
+				if obj.GetAnnotations()["example.com/owner"] != "payments@example.com" {
+					t.Errorf("%s: got annotations %v", ref, obj.GetAnnotations())
+				}
+
+				for _, path := range [][]string{{"spec", "template"}, {"spec", "jobTemplate", "spec", "template"}} {
+					if _, ok, _ := unstructured.NestedMap(obj.Object, path...); !ok {
+						continue
+					}
+					podLabels, _, _ := unstructured.NestedStringMap(obj.Object, append(path, "metadata", "labels")...)
+					checkLabelSet(t, ref+" pod template", podLabels, tt.instance)
+					if podLabels[ComponentLabel] != labels[ComponentLabel] {
+						t.Errorf("%s: the pods are of component %s, the object of %s", ref, podLabels[ComponentLabel], labels[ComponentLabel])
+					}
+					if selector, ok, _ := unstructured.NestedStringMap(obj.Object, "spec", "selector", "matchLabels"); ok {
+						checkSelector(t, ref, selector, podLabels)
+					}
+				}
+				if selector, ok, _ := unstructured.NestedStringMap(obj.Object, "spec", "selector", "matchLabels"); ok {
+					checkSelector(t, ref, selector, nil)
+				}
+				if obj.GetKind() == "Service" {
+					if selector, ok, _ := unstructured.NestedStringMap(obj.Object, "spec", "selector"); ok {
+						checkSelector(t, ref, selector, nil)
+					}
+				}
+			}
+			for _, c := range []string{componentAPI, componentDatabase, componentCache, componentCronJob} {
+				if !components[c] {
+					t.Errorf("no object of component %s", c)
+				}
+			}
+		})
+	}
+}
+
+// TestSelectorsStable checks the version label follows the image while the
+// selectors, which are immutable, stay the same.
+func TestSelectorsStable(t *testing.T) {
+	selectors := func(image string) (map[string]map[string]string, map[string]string) {
+		objects, err := Render(labelledOptions("shop", image))
+		if err != nil {
+			t.Fatalf("Render: %v", err)
+		}
+		selectors, versions := map[string]map[string]string{}, map[string]string{}
+		for _, obj := range objects {
+			ref := obj.GetKind() + "/" + obj.GetName()
+			versions[ref] = obj.GetLabels()[VersionLabel]
+			if selector, ok, _ := unstructured.NestedStringMap(obj.Object, "spec", "selector", "matchLabels"); ok {
+				selectors[ref] = selector
+			} else if selector, ok, _ := unstructured.NestedStringMap(obj.Object, "spec", "selector"); ok {
+				selectors[ref] = selector
+			}
+		}
+		return selectors, versions
+	}
+	oldSelectors, oldVersions := selectors("ghcr.io/acme/ecommerce-api:v1.0")
+	newSelectors, newVersions := selectors("ghcr.io/acme/ecommerce-api:v2.0")
+	if len(oldSelectors) == 0 || !reflect.DeepEqual(oldSelectors, newSelectors) {
+		t.Errorf("the selectors changed with the image:\n%v\n%v", oldSelectors, newSelectors)
+	}
+	for ref, v := range newVersions {
+		if v != "v2.0" || oldVersions[ref] != "v1.0" {
+			t.Errorf("%s: version went from %q to %q, want v1.0 to v2.0", ref, oldVersions[ref], v)
+		}
+	}
+}
+
+// checkLabelSet checks labels hold the recommended labels of the release
+// instance and the user's.
+func checkLabelSet(t *testing.T, ref string, labels map[string]string, instance string) {
+	t.Helper()
+	want := map[string]string{
+		NameLabel:      appName,
+		InstanceLabel:  instance,
+		VersionLabel:   "v2.0",
+		ManagedByLabel: ManagedBy,
+		"team":         "payments",
+	}
+	for k, v := range want {
+		if labels[k] != v {
+			t.Errorf("%s: label %s is %q, want %q", ref, k, labels[k], v)
+		}
+	}
+	if labels[ComponentLabel] == "" {
+		t.Errorf("%s: no %s label", ref, ComponentLabel)
+	}
+}
+
+// checkSelector checks selector never uses the version label, and selects
+// podLabels when given.
+func checkSelector(t *testing.T, ref string, selector, podLabels map[string]string) {
+	t.Helper()
+	if len(selector) == 0 {
+		t.Errorf("%s: empty selector", ref)
+	}
+	if _, ok := selector[VersionLabel]; ok {
+		t.Errorf("%s: the selector %v has the version label", ref, selector)
+	}
+	for k, v := range selector {
+		if podLabels != nil && podLabels[k] != v {
+			t.Errorf("%s: the selector %v doesn't select the pod labels %v", ref, selector, podLabels)
+			return
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package deployer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"os"
+	"sync"
+	"time"
+)
+
+// Defaults of LeaderElection, those of the Kubernetes controllers.
+const (
+	DefaultLeaseDuration = 15 * time.Second
+	DefaultRenewDeadline = 10 * time.Second
+	DefaultRetryPeriod   = 2 * time.Second
+)
+
+// errElectionNoClientset is returned by RunElected on a Deployer built
+// without a clientset, which the Lease is managed through.
+var errElectionNoClientset = errors.New("leader election needs a clientset; build the Deployer with NewWithClientset or NewForConfig")
+
+// LeaderElection configures RunElected. Zero fields take their defaults.
+type LeaderElection struct {
+	// LeaseName is the Lease in Options.Namespace the instances compete
+	// for. It defaults to server-reconciler, or <release>-reconciler for
+	// other releases.
+	LeaseName string
+	// Identity names this instance in the Lease; it defaults to the
+	// hostname, which is the pod name in a cluster.
+	Identity string
+	// LeaseDuration is how long followers wait after the last renewal
+	// before taking over.
+	LeaseDuration time.Duration
+	// RenewDeadline is how long the leader keeps retrying to renew before
+	// giving up leadership.
+	RenewDeadline time.Duration
+	// RetryPeriod is how often the Lease is tried.
+	RetryPeriod time.Duration
+}
+
+// leaseName is the default Lease of LeaderElection.
+func (o Options) leaseName() string {
+	return o.objectName("reconciler", "server-reconciler")
+}
+
+// RunElected runs run only while this instance holds the Lease, until ctx is
+// done. The context passed to run is cancelled as soon as leadership is
+// lost, and run is waited for before competing again, so two instances
+// never run it at once. On shutdown the Lease is released, so a follower
+// takes over without waiting for it to expire.
+func (d *Deployer) RunElected(ctx context.Context, le LeaderElection, run func(ctx context.Context)) error {
+	if d.kube == nil {
+		return errElectionNoClientset
+	}
+	if le.LeaseName == "" {
+		le.LeaseName = d.opts.leaseName()
+	}
+	if le.Identity == "" {
+		host, err := os.Hostname()
+		if err != nil {
+			return fmt.Errorf("failed to get the hostname for the leader election identity: %w", err)
+		}
+		le.Identity = host
+	}
+	if le.LeaseDuration == 0 {
+		le.LeaseDuration = DefaultLeaseDuration
+	}
+	if le.RenewDeadline == 0 {
+		le.RenewDeadline = DefaultRenewDeadline
+	}
+	if le.RetryPeriod == 0 {
+		le.RetryPeriod = DefaultRetryPeriod
+	}
+
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta:  metav1.ObjectMeta{Name: le.LeaseName, Namespace: d.opts.Namespace},
+		Client:     d.kube.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{Identity: le.Identity},
+	}
+	for ctx.Err() == nil {
+		var (
+			mu        sync.Mutex
+			running   bool
+			abandoned bool
+			done      = make(chan struct{})
+		)
+		elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+			Lock:            lock,
+			LeaseDuration:   le.LeaseDuration,
+			RenewDeadline:   le.RenewDeadline,
+			RetryPeriod:     le.RetryPeriod,
+			ReleaseOnCancel: true,
+			Name:            le.LeaseName,
+			Callbacks: leaderelection.LeaderCallbacks{
+				OnStartedLeading: func(ctx context.Context) {
+					// The elector starts this in a goroutine, which may only
+					// get scheduled after leadership is already lost.
+					mu.Lock()
+					if abandoned {
+						mu.Unlock()
+						return
+					}
+					running = true
+					mu.Unlock()
+					defer close(done)
+					d.log.Info("Leading", "lease", le.LeaseName, "identity", le.Identity)
+					run(ctx)
+				},
+				OnStoppedLeading: func() {
+					mu.Lock()
+					led := running
+					mu.Unlock()
+					if led {
+						d.log.Info("Stopped leading", "lease", le.LeaseName, "identity", le.Identity)
+					}
+				},
+				OnNewLeader: func(identity string) {
+					if identity != le.Identity {
+						d.log.Info("Standing by, another instance leads", "lease", le.LeaseName, "leader", identity)
+					}
+				},
+			},
+		})
+		if err != nil {
+			return &ValidationError{Field: "leader-elect", Err: err}
+		}
+		elector.Run(ctx)
+
+		mu.Lock()
+		abandoned = !running
+		wait := running
+		mu.Unlock()
+		if wait {
+			<-done
+		}
+	}
+	return nil
+}
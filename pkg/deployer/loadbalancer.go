@@ -0,0 +1,72 @@
+package deployer
+
+import (
+	"errors"
+	"fmt"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"strings"
+)
+
+// The values of Options.LBPreset, the cloud load balancers it asks for.
+const (
+	// LBPresetAWSNLB is an internet-facing AWS Network Load Balancer.
+	LBPresetAWSNLB = "aws-nlb"
+	// LBPresetAWSInternal is an AWS Network Load Balancer reachable only
+	// from within the VPC.
+	LBPresetAWSInternal = "aws-internal"
+	// LBPresetGKEInternal is a GCP internal passthrough load balancer.
+	LBPresetGKEInternal = "gke-internal"
+	// LBPresetAzureInternal is an Azure internal load balancer.
+	LBPresetAzureInternal = "azure-internal"
+)
+
+// lbPresets are the annotations each Options.LBPreset expands to.
+var lbPresets = map[string]map[string]string{
+	LBPresetAWSNLB: {
+		"service.beta.kubernetes.io/aws-load-balancer-type": "nlb",
+	},
+	LBPresetAWSInternal: {
+		"service.beta.kubernetes.io/aws-load-balancer-type":     "nlb",
+		"service.beta.kubernetes.io/aws-load-balancer-internal": "true",
+	},
+	LBPresetGKEInternal: {
+		"networking.gke.io/load-balancer-type": "Internal",
+	},
+	LBPresetAzureInternal: {
+		"service.beta.kubernetes.io/azure-load-balancer-internal": "true",
+	},
+}
+
+// validateLoadBalancer checks the preset and annotation keys, and that
+// there is a LoadBalancer service to set them on.
+func (o Options) validateLoadBalancer() error {
+	if o.LBPreset != "" {
+		if _, ok := lbPresets[o.LBPreset]; !ok {
+			return &ValidationError{Field: "lb-preset", Err: fmt.Errorf("must be %s, %s, %s or %s, got %q",
+				LBPresetAWSNLB, LBPresetAWSInternal, LBPresetGKEInternal, LBPresetAzureInternal, o.LBPreset)}
+		}
+	}
+	for _, k := range sortedKeys(o.LBAnnotations) {
+		if errs := validation.IsQualifiedName(strings.ToLower(k)); len(errs) > 0 {
+			return &ValidationError{Field: "lb-annotation", Err: fmt.Errorf("%s: %s", k, strings.Join(errs, "; "))}
+		}
+	}
+	if (o.LBPreset != "" || len(o.LBAnnotations) > 0) && o.ServiceType != "LoadBalancer" {
+		field := "lb-annotation"
+		if o.LBPreset != "" {
+			field = "lb-preset"
+		}
+		return &ValidationError{Field: field, Err: errors.New("needs -service-type=LoadBalancer")}
+	}
+	return nil
+}
+
+// loadBalancerAnnotations returns the annotations of server-svc: those of
+// Options.LBPreset with Options.LBAnnotations over them, or nil unless it
+// is a LoadBalancer service.
+func loadBalancerAnnotations(opts Options) map[string]string {
+	if opts.ServiceType != "LoadBalancer" {
+		return nil
+	}
+	return merge(lbPresets[opts.LBPreset], opts.LBAnnotations)
+}
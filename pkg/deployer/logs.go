@@ -0,0 +1,184 @@
+package deployer
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	corev1 "k8s.io/api/core/v1"
+	"sync"
+	"time"
+)
+
+// logsPollInterval is how often a followed Logs call looks for pods
+// replacing the ones it streams.
+const logsPollInterval = 2 * time.Second
+
+// maxLogLine is the longest log line Logs passes through; longer ones end
+// the pod's stream.
+const maxLogLine = 1 << 20
+
+// errLogsNoClientset is returned by Logs on a Deployer built without a
+// clientset, which the dynamic client can't stream logs through.
+var errLogsNoClientset = errors.New("streaming logs needs a clientset; build the Deployer with NewWithClientset or NewForConfig")
+
+// LogOptions selects the logs Logs streams.
+type LogOptions struct {
+	// Container is the container whose logs are streamed; it defaults to
+	// the API container.
+	Container string
+	// Follow keeps streaming until ctx is done, picking up pods that
+	// replace terminated ones.
+	Follow bool
+	// TailLines, when set, starts each pod's logs this many lines from the
+	// end.
+	TailLines *int64
+	// Since, when positive, starts each pod's logs this long ago.
+	Since time.Duration
+}
+
+// Logs streams the logs of the API pods to out, each line prefixed with
+// [pod]. Pods whose container hasn't started are skipped. With
+// LogOptions.Follow, pods started later, such as the replacements of a
+// rollout, are streamed from their first line, and failed streams are logged
+// rather than returned.
+func (d *Deployer) Logs(ctx context.Context, opts LogOptions, out io.Writer) error {
+	if d.kube == nil {
+		return errLogsNoClientset
+	}
+	if opts.TailLines != nil && *opts.TailLines < 0 {
+		return &ValidationError{Field: "tail", Err: fmt.Errorf("must not be negative, got %d", *opts.TailLines)}
+	}
+	if opts.Since < 0 {
+		return &ValidationError{Field: "since", Err: fmt.Errorf("must not be negative, got %s", opts.Since)}
+	}
+	if opts.Container == "" {
+		opts.Container = apiContainerName
+	}
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+		streamed = map[string]bool{}
+		w        = &lineWriter{out: out}
+	)
+	// stream starts streaming the pods not streamed yet. The first call
+	// honors TailLines and Since; later ones only find new pods.
+	stream := func(backlog bool) error {
+		pods, err := d.apiPods(ctx)
+		if err != nil {
+			return err
+		}
+		for _, pod := range pods {
+			if streamed[pod.Name] {
+				continue
+			}
+			if !containerStarted(pod, opts.Container) {
+				if !opts.Follow {
+					d.log.Info("Skipping pod, its container hasn't started", "pod", pod.Name, "container", opts.Container)
+				}
+				continue
+			}
+			streamed[pod.Name] = true
+			logOpts := &corev1.PodLogOptions{Container: opts.Container, Follow: opts.Follow}
+			if backlog {
+				logOpts.TailLines = opts.TailLines
+				if opts.Since > 0 {
+					seconds := int64(opts.Since.Seconds())
+					logOpts.SinceSeconds = &seconds
+				}
+			}
+			wg.Add(1)
+			go func(name string) {
+				defer wg.Done()
+				err := d.streamLogs(ctx, name, logOpts, w)
+				if err == nil || ctx.Err() != nil {
+					return
+				}
+				if opts.Follow {
+					d.log.Error(err, "Log stream failed", "pod", name)
+					return
+				}
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				mu.Unlock()
+			}(pod.Name)
+		}
+		return nil
+	}
+
+	if err := stream(true); err != nil {
+		return err
+	}
+	if opts.Follow {
+		ticker := time.NewTicker(logsPollInterval)
+	follow:
+		for {
+			select {
+			case <-ctx.Done():
+				break follow
+			case <-ticker.C:
+				if err := stream(false); err != nil && ctx.Err() == nil {
+					d.log.Error(err, "Failed to list the API pods")
+				}
+			}
+		}
+		ticker.Stop()
+	}
+	wg.Wait()
+	return firstErr
+}
+
+// streamLogs copies the logs of a pod to w line by line until the stream
+// ends, which it does when the pod terminates.
+func (d *Deployer) streamLogs(ctx context.Context, pod string, opts *corev1.PodLogOptions, w *lineWriter) error {
+	var rc io.ReadCloser
+	err := d.retry.do(ctx, OpGet, PodResource, pod, func() (err error) {
+		rc, err = d.kube.CoreV1().Pods(d.opts.Namespace).GetLogs(pod, opts).Stream(ctx)
+		return err
+	})
+	if err != nil {
+		return d.opError(OpGet, PodResource, pod, fmt.Errorf("failed to stream logs: %w", err))
+	}
+	defer rc.Close()
+	scanner := bufio.NewScanner(rc)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLogLine)
+	for scanner.Scan() {
+		if err := w.writeLine(pod, scanner.Text()); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil && ctx.Err() == nil {
+		return d.opError(OpGet, PodResource, pod, fmt.Errorf("failed to read logs: %w", err))
+	}
+	return nil
+}
+
+// containerStarted reports whether the container of pod is running or has
+// run, so it has logs to stream.
+func containerStarted(pod corev1.Pod, container string) bool {
+	for _, cs := range pod.Status.ContainerStatuses {
+		if cs.Name == container {
+			return cs.State.Running != nil || cs.State.Terminated != nil || cs.RestartCount > 0
+		}
+	}
+	return false
+}
+
+// lineWriter serializes the lines of concurrent log streams so they
+// interleave whole.
+type lineWriter struct {
+	mu  sync.Mutex
+	out io.Writer
+}
+
+func (w *lineWriter) writeLine(pod, line string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	_, err := fmt.Fprintf(w.out, "[%s] %s\n", pod, line)
+	return err
+}
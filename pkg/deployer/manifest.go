@@ -0,0 +1,419 @@
+package deployer
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	"io"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// defaultManifests holds the built-in resources. The image, replica count and
+// namespace in them are overridden from Options.
+//
+//go:embed manifests/*.yaml
+var defaultManifests embed.FS
+
+// defaultManifest decodes one of the embedded manifests. They are compiled
+// into the binary, so a decoding failure is a bug rather than a runtime error.
+func defaultManifest(file string) *unstructured.Unstructured {
+	data, err := defaultManifests.ReadFile("manifests/" + file)
+	if err != nil {
+		panic(err)
+	}
+	objects, err := Decode(bytes.NewReader(data))
+	if err != nil || len(objects) != 1 {
+		panic(fmt.Sprintf("embedded manifest %s must hold exactly one object: %v", file, err))
+	}
+	return objects[0]
+}
+
+// decodeDefaultManifest decodes one of the embedded manifests into a typed
+// object, for builders that customize it through the typed fields.
+func decodeDefaultManifest(file string, into interface{}) {
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(defaultManifest(file).Object, into); err != nil {
+		panic(fmt.Sprintf("embedded manifest %s: %v", file, err))
+	}
+}
+
+// LoadManifests reads every *.yaml and *.yml file in dir, in lexical order, and
+// returns the objects they contain in the order they appear. Each file is a
+// text/template rendered with values available as .Values before it is
+// decoded; referencing a value that isn't set is an error.
+func LoadManifests(dir string, values map[string]interface{}) ([]*unstructured.Unstructured, error) {
+	return loadManifestDir(dir, func(file string, data []byte) ([]byte, error) {
+		return renderTemplate(file, data, values)
+	})
+}
+
+// LoadExtraManifests reads every *.yaml and *.yml file in dir as
+// LoadManifests does, but without rendering them, for
+// Options.ExtraManifests. A document that doesn't decode, or whose object
+// lacks an apiVersion, kind or metadata.name, is reported with its file and
+// line.
+func LoadExtraManifests(dir string) ([]*unstructured.Unstructured, error) {
+	return loadManifestDir(dir, nil)
+}
+
+// loadManifestDir decodes the manifest files of dir, each passed through
+// render first when it is set.
+func loadManifestDir(dir string, render func(file string, data []byte) ([]byte, error)) ([]*unstructured.Unstructured, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifests: %w", err)
+	}
+	var files []string
+	for _, e := range entries {
+		ext := strings.ToLower(filepath.Ext(e.Name()))
+		if !e.IsDir() && (ext == ".yaml" || ext == ".yml") {
+			files = append(files, filepath.Join(dir, e.Name()))
+		}
+	}
+	sort.Strings(files)
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no *.yaml or *.yml files in %s", dir)
+	}
+
+	var objects []*unstructured.Unstructured
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read manifests: %w", err)
+		}
+		if render != nil {
+			if data, err = render(file, data); err != nil {
+				return nil, err
+			}
+		}
+		decoded, err := decodeFile(file, data)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, decoded...)
+	}
+	return objects, nil
+}
+
+// decodeFile decodes the documents of a manifest file, reporting a document
+// that doesn't decode, or an object that doesn't name itself, as
+// file:line, the line its document starts on.
+func decodeFile(file string, data []byte) ([]*unstructured.Unstructured, error) {
+	var objects []*unstructured.Unstructured
+	for _, doc := range splitDocuments(data) {
+		decoded, err := Decode(bytes.NewReader(doc.data))
+		if err != nil {
+			// The line already tells the document apart from the others.
+			if inner := errors.Unwrap(err); inner != nil {
+				err = inner
+			}
+			return nil, fmt.Errorf("%s:%d: %w", file, doc.line, err)
+		}
+		for _, obj := range decoded {
+			if obj.GetAPIVersion() == "" || obj.GetKind() == "" || obj.GetName() == "" {
+				return nil, fmt.Errorf("%s:%d: the object needs apiVersion, kind and metadata.name", file, doc.line)
+			}
+		}
+		objects = append(objects, decoded...)
+	}
+	return objects, nil
+}
+
+// document is a YAML document of a file and the line it starts on.
+type document struct {
+	line int
+	data []byte
+}
+
+// splitDocuments splits data at its "---" separator lines.
+func splitDocuments(data []byte) []document {
+	var (
+		docs    []document
+		current = document{line: 1}
+	)
+	lines := bytes.SplitAfter(data, []byte("\n"))
+	for i, line := range lines {
+		trimmed := bytes.TrimRight(line, " \t\r\n")
+		if bytes.Equal(trimmed, []byte("---")) || bytes.HasPrefix(trimmed, []byte("--- ")) {
+			docs = append(docs, current)
+			current = document{line: i + 2}
+			continue
+		}
+		current.data = append(current.data, line...)
+	}
+	return append(docs, current)
+}
+
+// renderTemplate executes data as a template named after its file, so errors
+// read like "template: deploy/app.yaml:12:20: executing ...".
+func renderTemplate(file string, data []byte, values map[string]interface{}) ([]byte, error) {
+	if values == nil {
+		values = map[string]interface{}{}
+	}
+	tmpl, err := template.New(file).Option("missingkey=error").Parse(string(data))
+	if err != nil {
+		return nil, err
+	}
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, map[string]interface{}{"Values": values}); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// Decode reads a stream of YAML or JSON documents. Empty documents are
+// skipped and List objects are expanded into their items.
+func Decode(r io.Reader) ([]*unstructured.Unstructured, error) {
+	dec := utilyaml.NewYAMLOrJSONDecoder(r, 4096)
+	var objects []*unstructured.Unstructured
+	for i := 1; ; i++ {
+		var raw runtime.RawExtension
+		if err := dec.Decode(&raw); errors.Is(err, io.EOF) {
+			return objects, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("document %d: %w", i, err)
+		}
+		data := bytes.TrimSpace(raw.Raw)
+		if len(data) == 0 || bytes.Equal(data, []byte("null")) {
+			continue
+		}
+
+		obj := &unstructured.Unstructured{}
+		if err := obj.UnmarshalJSON(data); err != nil {
+			return nil, fmt.Errorf("document %d: %w", i, err)
+		}
+		if !obj.IsList() {
+			objects = append(objects, obj)
+			continue
+		}
+		err := obj.EachListItem(func(item runtime.Object) error {
+			objects = append(objects, item.(*unstructured.Unstructured))
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("document %d: %w", i, err)
+		}
+	}
+}
+
+// validateManifests checks that every object names itself and doesn't try to
+// escape the Deployer's namespace, and that its ApplyWeightAnnotation
+// parses, reporting problems against field.
+func validateManifests(field string, objects []*unstructured.Unstructured, namespace string) error {
+	for i, obj := range objects {
+		if obj.GetAPIVersion() == "" || obj.GetKind() == "" || obj.GetName() == "" {
+			return &ValidationError{Field: field, Err: fmt.Errorf("object %d needs apiVersion, kind and metadata.name", i+1)}
+		}
+		if ns := obj.GetNamespace(); ns != "" && ns != namespace {
+			return &ValidationError{Field: field, Err: fmt.Errorf("%s %s is in namespace %q, not %q", obj.GetKind(), obj.GetName(), ns, namespace)}
+		}
+		if _, err := applyWeight(obj); err != nil {
+			return &ValidationError{Field: field, Err: err}
+		}
+	}
+	return nil
+}
+
+// staticRESTMapper maps the kinds the built-in manifests use, and
+// CustomResourceDefinitions. It serves
+// Deployers that have no discovery client, and client-side rendering.
+func staticRESTMapper() meta.RESTMapper {
+	mapper := meta.NewDefaultRESTMapper(nil)
+	for kind, gvr := range map[string]schema.GroupVersionResource{
+		"Deployment":              DeploymentResource,
+		"Service":                 ServiceResource,
+		"Ingress":                 IngressResource,
+		"HorizontalPodAutoscaler": HPAResource,
+		"Pod":                     PodResource,
+		"Secret":                  SecretResource,
+		"ConfigMap":               ConfigMapResource,
+		"StatefulSet":             StatefulSetResource,
+		"PersistentVolumeClaim":   PVCResource,
+		"PodDisruptionBudget":     PDBResource,
+		"NetworkPolicy":           NetworkPolicyResource,
+		"ServiceAccount":          ServiceAccountResource,
+		"Role":                    RoleResource,
+		"RoleBinding":             RoleBindingResource,
+		"CronJob":                 CronJobResource,
+		"HTTPRoute":               HTTPRouteResource,
+		"Route":                   OpenShiftRouteResource,
+	} {
+		gvk := gvr.GroupVersion().WithKind(kind)
+		mapper.AddSpecific(gvk, gvr, gvr.GroupVersion().WithResource(strings.ToLower(kind)), meta.RESTScopeNamespace)
+	}
+	mapper.AddSpecific(NamespaceResource.GroupVersion().WithKind("Namespace"), NamespaceResource,
+		NamespaceResource.GroupVersion().WithResource("namespace"), meta.RESTScopeRoot)
+	mapper.AddSpecific(CRDResource.GroupVersion().WithKind(crdGroupKind.Kind), CRDResource,
+		CRDResource.GroupVersion().WithResource("customresourcedefinition"), meta.RESTScopeRoot)
+	return mapper
+}
+
+// sourceObjects returns copies of the objects the Deployer manages, either
+// Options.Manifests or the built-in ones followed by Options.ExtraManifests,
+// with Options.Patches applied.
+// Validate reports patches that fail; they are left out here.
+func sourceObjects(opts Options) []*unstructured.Unstructured {
+	objects := baseObjects(opts)
+	if patched, err := applyPatches(objects, opts.Patches); err == nil {
+		return patched
+	}
+	return objects
+}
+
+// baseObjects returns sourceObjects before Options.Patches.
+func baseObjects(opts Options) []*unstructured.Unstructured {
+	if len(opts.Manifests) == 0 {
+		return releaseObjects(labelObjects(builderFor(opts).build(opts), opts), opts.ExtraManifests, opts)
+	}
+	return releaseObjects(nil, opts.Manifests, opts)
+}
+
+// extras returns how many of the managed objects, the last ones, come from
+// Options.ExtraManifests; Options.Manifests leave them out.
+func (o Options) extras() int {
+	if len(o.Manifests) > 0 {
+		return 0
+	}
+	return len(o.ExtraManifests)
+}
+
+// releaseObjects appends copies of manifests to objects, marked as the
+// release's so Prune can tell them apart; labels a manifest sets itself win.
+func releaseObjects(objects, manifests []*unstructured.Unstructured, opts Options) []*unstructured.Unstructured {
+	for _, obj := range manifests {
+		obj = obj.DeepCopy()
+		obj.SetLabels(merge(map[string]string{ManagedByLabel: ManagedBy, InstanceLabel: opts.release()}, obj.GetLabels()))
+		objects = append(objects, obj)
+	}
+	return objects
+}
+
+// scope sets obj's namespace according to mapping: namespaced objects go to
+// namespace and cluster-scoped ones have theirs cleared.
+func scope(obj *unstructured.Unstructured, mapping *meta.RESTMapping, namespace string) {
+	if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+		obj.SetNamespace(namespace)
+	} else {
+		obj.SetNamespace("")
+	}
+}
+
+// servedObjects returns the objects the Deployer manages adapted to the APIs
+// the cluster serves. Built-in objects the cluster has no API for are left
+// out and returned as skipped. Options.ExtraManifests come last, as they
+// were given. Those and Options.Manifests are ordered by applyWeight.
+func (d *Deployer) servedObjects() ([]*unstructured.Unstructured, []SkippedObject, error) {
+	source := sourceObjects(d.opts)
+	if len(d.opts.Manifests) > 0 {
+		return orderObjects(source), nil, nil
+	}
+	builtin, extra := source[:len(source)-d.opts.extras()], orderObjects(source[len(source)-d.opts.extras():])
+	var (
+		served  []*unstructured.Unstructured
+		skipped []SkippedObject
+	)
+	for _, obj := range builtin {
+		if obj.GetKind() == "Ingress" {
+			ing, err := d.servedIngress(obj)
+			if err != nil {
+				return nil, nil, err
+			}
+			if ing == nil {
+				skipped = append(skipped, SkippedObject{Kind: obj.GetKind(), Name: obj.GetName(), Reason: errIngressNotServed})
+				continue
+			}
+			obj = ing
+		}
+		if obj.GetKind() == serviceMonitorGroupKind.Kind {
+			served, err := d.servesServiceMonitor()
+			if err != nil {
+				return nil, nil, err
+			}
+			if !served {
+				skipped = append(skipped, SkippedObject{Kind: obj.GetKind(), Name: obj.GetName(), Reason: errServiceMonitorNotServed})
+				continue
+			}
+		}
+		if obj.GetKind() == httpRouteGroupKind.Kind {
+			served, err := d.servesHTTPRoute()
+			if err != nil {
+				return nil, nil, err
+			}
+			if !served {
+				return nil, nil, &ValidationError{Field: "routing", Err: errHTTPRouteNotServed}
+			}
+		}
+		if obj.GroupVersionKind().GroupKind() == openShiftRouteGroupKind {
+			served, err := d.servesOpenShiftRoute()
+			if err != nil {
+				return nil, nil, err
+			}
+			if !served {
+				return nil, nil, &ValidationError{Field: "routing", Err: errOpenShiftRouteNotServed}
+			}
+		}
+		if obj.GetKind() == "HorizontalPodAutoscaler" {
+			served, err := d.servesHPA()
+			if err != nil {
+				return nil, nil, err
+			}
+			if !served {
+				skipped = append(skipped, SkippedObject{Kind: obj.GetKind(), Name: obj.GetName(), Reason: errHPANotServed})
+				continue
+			}
+		}
+		served = append(served, obj)
+	}
+	return append(served, extra...), skipped, nil
+}
+
+// objects resolves the resource of every managed object through the
+// RESTMapper, in creation order, leaving out skipped built-in objects. Those
+// of Options.ExtraManifests, the last ones, are marked extra. A custom
+// resource the cluster doesn't serve yet is resolved through the
+// CustomResourceDefinition among the objects that defines it.
+func (d *Deployer) objects() ([]object, error) {
+	source, skipped, err := d.servedObjects()
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range skipped {
+		d.log.Info("Skipping, the cluster doesn't serve its API", "kind", s.Kind, "name", s.Name, "reason", s.Reason.Error())
+	}
+	objects := make([]object, 0, len(source))
+	for i, obj := range source {
+		o, err := d.mapObject(obj)
+		if isNoMatch(err) {
+			if mapping, crd := definedMapping(source, obj); mapping != nil {
+				scope(obj, mapping, d.opts.Namespace)
+				o, err = object{gvr: mapping.Resource, obj: obj, definedBy: crd}, nil
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		o.extra = i >= len(source)-d.opts.extras()
+		objects = append(objects, o)
+	}
+	return objects, nil
+}
+
+// mapObject resolves the resource obj is served under and scopes it.
+func (d *Deployer) mapObject(obj *unstructured.Unstructured) (object, error) {
+	gvk := obj.GroupVersionKind()
+	mapping, err := d.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return object{}, fmt.Errorf("failed to resolve the resource of %s %s: %w", obj.GetKind(), obj.GetName(), err)
+	}
+	scope(obj, mapping, d.opts.Namespace)
+	return object{gvr: mapping.Resource, obj: obj}, nil
+}
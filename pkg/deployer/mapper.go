@@ -0,0 +1,87 @@
+package deployer
+
+import (
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/restmapper"
+	"sync"
+)
+
+// discoveryMapper resolves resources through cached discovery. The cache
+// only fills once, so a kind it doesn't know, such as that of a CRD
+// installed since, invalidates it and is looked up again. That happens once
+// per kind until the next Reset, so probing for a kind the cluster doesn't
+// serve costs a single rediscovery.
+type discoveryMapper struct {
+	*restmapper.DeferredDiscoveryRESTMapper
+	mu sync.Mutex
+	// retried are the kinds and resources looked up again since the last
+	// Reset.
+	retried map[string]bool
+}
+
+func newDiscoveryMapper(client discovery.DiscoveryInterface) *discoveryMapper {
+	return &discoveryMapper{
+		DeferredDiscoveryRESTMapper: restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(client)),
+		retried:                     map[string]bool{},
+	}
+}
+
+// Reset invalidates the discovery cache.
+func (m *discoveryMapper) Reset() {
+	m.mu.Lock()
+	m.retried = map[string]bool{}
+	m.mu.Unlock()
+	m.DeferredDiscoveryRESTMapper.Reset()
+}
+
+// retry reports whether a lookup of key that found no match should be made
+// again, invalidating the cache when so.
+func (m *discoveryMapper) retry(key string, err error) bool {
+	if !meta.IsNoMatchError(err) {
+		return false
+	}
+	m.mu.Lock()
+	retried := m.retried[key]
+	m.retried[key] = true
+	m.mu.Unlock()
+	if retried {
+		return false
+	}
+	m.DeferredDiscoveryRESTMapper.Reset()
+	return true
+}
+
+func (m *discoveryMapper) RESTMapping(gk schema.GroupKind, versions ...string) (*meta.RESTMapping, error) {
+	mapping, err := m.DeferredDiscoveryRESTMapper.RESTMapping(gk, versions...)
+	if m.retry("kind "+gk.String(), err) {
+		mapping, err = m.DeferredDiscoveryRESTMapper.RESTMapping(gk, versions...)
+	}
+	return mapping, err
+}
+
+func (m *discoveryMapper) RESTMappings(gk schema.GroupKind, versions ...string) ([]*meta.RESTMapping, error) {
+	mappings, err := m.DeferredDiscoveryRESTMapper.RESTMappings(gk, versions...)
+	if m.retry("kind "+gk.String(), err) {
+		mappings, err = m.DeferredDiscoveryRESTMapper.RESTMappings(gk, versions...)
+	}
+	return mappings, err
+}
+
+func (m *discoveryMapper) KindFor(resource schema.GroupVersionResource) (schema.GroupVersionKind, error) {
+	gvk, err := m.DeferredDiscoveryRESTMapper.KindFor(resource)
+	if m.retry("resource "+resource.String(), err) {
+		gvk, err = m.DeferredDiscoveryRESTMapper.KindFor(resource)
+	}
+	return gvk, err
+}
+
+func (m *discoveryMapper) ResourceFor(input schema.GroupVersionResource) (schema.GroupVersionResource, error) {
+	gvr, err := m.DeferredDiscoveryRESTMapper.ResourceFor(input)
+	if m.retry("resource "+input.String(), err) {
+		gvr, err = m.DeferredDiscoveryRESTMapper.ResourceFor(input)
+	}
+	return gvr, err
+}
@@ -0,0 +1,152 @@
+package deployer
+
+import (
+	"errors"
+	"fmt"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"strings"
+)
+
+const (
+	// metricsPortName names the metrics port of the API container and of
+	// server-svc, which the ServiceMonitor scrapes.
+	metricsPortName = "metrics"
+	// DefaultMetricsPath is what the ServiceMonitor scrapes when
+	// Options.MetricsPath is empty.
+	DefaultMetricsPath = "/metrics"
+)
+
+// ServiceMonitorResource is the prometheus-operator resource of the
+// ServiceMonitor created with Options.MetricsPort.
+var ServiceMonitorResource = schema.GroupVersionResource{Group: "monitoring.coreos.com", Version: "v1", Resource: "servicemonitors"}
+
+// serviceMonitorGroupKind is the kind the prometheus-operator CRD serves.
+var serviceMonitorGroupKind = schema.GroupKind{Group: ServiceMonitorResource.Group, Kind: "ServiceMonitor"}
+
+// errServiceMonitorNotServed is the reason the ServiceMonitor is skipped on
+// clusters without the prometheus-operator.
+var errServiceMonitorNotServed = errors.New("monitoring.coreos.com/v1 ServiceMonitor is not served by the cluster; install the prometheus-operator")
+
+// validateMetrics checks the metrics port, path and ServiceMonitor labels.
+func (o Options) validateMetrics() error {
+	if o.MetricsPort == 0 {
+		if o.MetricsPath != "" || len(o.ServiceMonitorLabels) > 0 {
+			return &ValidationError{Field: "metrics-port", Err: errors.New("is needed for -metrics-path and -servicemonitor-label")}
+		}
+		return nil
+	}
+	if o.MetricsPort < 1 || o.MetricsPort > 65535 {
+		return &ValidationError{Field: "metrics-port", Err: fmt.Errorf("must be between 1 and 65535, got %d", o.MetricsPort)}
+	}
+	for _, p := range o.ports() {
+		if o.MetricsPort == p.ContainerPort || o.MetricsPort == p.ServicePort {
+			return &ValidationError{Field: "metrics-port", Err: fmt.Errorf("%d is taken by the %s port", o.MetricsPort, p.Name)}
+		}
+	}
+	if o.MetricsPath != "" && !strings.HasPrefix(o.MetricsPath, "/") {
+		return &ValidationError{Field: "metrics-path", Err: fmt.Errorf("%q must start with /", o.MetricsPath)}
+	}
+	for _, k := range sortedKeys(o.ServiceMonitorLabels) {
+		switch k {
+		case NameLabel, InstanceLabel, VersionLabel, ComponentLabel, ManagedByLabel:
+			return &ValidationError{Field: "servicemonitor-label", Err: fmt.Errorf("%s is set by the deployer", k)}
+		}
+		errs := append(validation.IsQualifiedName(k), validation.IsValidLabelValue(o.ServiceMonitorLabels[k])...)
+		if len(errs) > 0 {
+			return &ValidationError{Field: "servicemonitor-label", Err: fmt.Errorf("%s=%s: %s", k, o.ServiceMonitorLabels[k], strings.Join(errs, "; "))}
+		}
+	}
+	return nil
+}
+
+// wireMetrics adds the metrics port to the API container when
+// Options.MetricsPort is set.
+func wireMetrics(dep *appsv1.Deployment, opts Options) {
+	if opts.MetricsPort == 0 {
+		return
+	}
+	c := apiContainer(&dep.Spec.Template.Spec)
+	c.Ports = append(c.Ports, corev1.ContainerPort{Name: metricsPortName, Protocol: corev1.ProtocolTCP, ContainerPort: opts.MetricsPort})
+}
+
+// metricsServicePorts returns the ports of server-svc: the API ports, and
+// with Options.MetricsPort the metrics port.
+func metricsServicePorts(opts Options, api []corev1.ServicePort) []corev1.ServicePort {
+	if opts.MetricsPort == 0 {
+		return api
+	}
+	return append(api, corev1.ServicePort{
+		Name:       metricsPortName,
+		Protocol:   corev1.ProtocolTCP,
+		Port:       opts.MetricsPort,
+		TargetPort: intstr.FromString(metricsPortName),
+	})
+}
+
+// setMetricsPort adds the metrics port to the server-svc service obj.
+func setMetricsPort(obj *unstructured.Unstructured, opts Options) {
+	if opts.MetricsPort == 0 {
+		return
+	}
+	ports, _, _ := unstructured.NestedSlice(obj.Object, "spec", "ports")
+	ports = append(ports, map[string]interface{}{
+		"name":       metricsPortName,
+		"protocol":   string(corev1.ProtocolTCP),
+		"port":       int64(opts.MetricsPort),
+		"targetPort": metricsPortName,
+	})
+	_ = unstructured.SetNestedSlice(obj.Object, ports, "spec", "ports")
+}
+
+// newServiceMonitor returns the ServiceMonitor scraping the metrics port of
+// server-svc, or nil without Options.MetricsPort. Both builders use it, as
+// there is no typed struct of the kind.
+func newServiceMonitor(opts Options) *unstructured.Unstructured {
+	if opts.MetricsPort == 0 {
+		return nil
+	}
+	path := opts.MetricsPath
+	if path == "" {
+		path = DefaultMetricsPath
+	}
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": ServiceMonitorResource.GroupVersion().String(),
+		"kind":       serviceMonitorGroupKind.Kind,
+		"spec": map[string]interface{}{
+			"selector": map[string]interface{}{
+				"matchLabels": map[string]interface{}{
+					NameLabel:      appName,
+					InstanceLabel:  opts.release(),
+					ComponentLabel: componentAPI,
+				},
+			},
+			"namespaceSelector": map[string]interface{}{"matchNames": []interface{}{opts.Namespace}},
+			"endpoints":         []interface{}{map[string]interface{}{"port": metricsPortName, "path": path}},
+		},
+	}}
+	obj.SetName(opts.serviceName())
+	obj.SetNamespace(opts.Namespace)
+	if len(opts.ServiceMonitorLabels) > 0 {
+		obj.SetLabels(opts.ServiceMonitorLabels)
+	}
+	return obj
+}
+
+// servesServiceMonitor reports whether the cluster serves the
+// prometheus-operator's ServiceMonitor.
+func (d *Deployer) servesServiceMonitor() (bool, error) {
+	_, err := d.mapper.RESTMapping(serviceMonitorGroupKind, ServiceMonitorResource.Version)
+	if meta.IsNoMatchError(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to discover the monitoring.coreos.com API: %w", err)
+	}
+	return true, nil
+}
@@ -0,0 +1,366 @@
+package deployer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// JobResource is the GroupVersionResource of jobs.
+var JobResource = schema.GroupVersionResource{Group: "batch", Version: "v1", Resource: "jobs"}
+
+const (
+	// DefaultMigrationHistoryLimit is how many finished migration Jobs are
+	// kept when Migration.HistoryLimit is zero.
+	DefaultMigrationHistoryLimit = 3
+
+	// RevisionLabel is set on migration Jobs and their pods to the revision
+	// of the API deployment they ran before.
+	RevisionLabel = "revision"
+
+	// componentMigration is the ComponentLabel of migration Jobs, which
+	// keeps their pods out of the API deployment's selector.
+	componentMigration = "migration"
+	// migrationContainer names the container of a migration Job.
+	migrationContainer = "migrate"
+)
+
+// Migration is a Job run to completion before the API deployment is updated,
+// such as a schema migration. Its pod has the API container's environment,
+// volumes and service account.
+type Migration struct {
+	// Command is run with sh -c; when empty the image's entrypoint runs.
+	Command string
+	// Image runs the command, the API image when empty.
+	Image string
+	// BackoffLimit is how many times a failed pod is retried before the
+	// Job, and the deploy, fail.
+	BackoffLimit int32
+	// HistoryLimit is how many finished migration Jobs of the release are
+	// kept, DefaultMigrationHistoryLimit when zero.
+	HistoryLimit int
+	// Logs receives the log lines of the Job's pods, each prefixed with
+	// [pod]; nil discards them. Streaming needs a clientset.
+	Logs io.Writer
+}
+
+// MigrationError is returned by DeployAll when the migration Job failed.
+// The API deployment is left as it was.
+type MigrationError struct {
+	Job    string
+	Reason string
+}
+
+func (e *MigrationError) Error() string {
+	return fmt.Sprintf("migration job %s failed: %s; the API deployment was left as it was", e.Job, e.Reason)
+}
+
+// validateMigration checks the migration image and limits.
+func (o Options) validateMigration() error {
+	m := o.Migration
+	if m == nil {
+		return nil
+	}
+	if m.Command == "" && m.Image == "" {
+		return &ValidationError{Field: "migration-command", Err: errors.New("a migration needs a command or an image")}
+	}
+	if m.Image != "" {
+		if _, err := ParseImageReference(m.Image); err != nil {
+			return &ValidationError{Field: "migration-image", Err: err}
+		}
+	}
+	if m.BackoffLimit < 0 {
+		return &ValidationError{Field: "migration-backoff-limit", Err: fmt.Errorf("must not be negative, got %d", m.BackoffLimit)}
+	}
+	if m.HistoryLimit < 0 {
+		return &ValidationError{Field: "migration-history-limit", Err: fmt.Errorf("must not be negative, got %d", m.HistoryLimit)}
+	}
+	return nil
+}
+
+// isMigrated reports whether o is the API deployment a migration Job runs
+// before.
+func (d *Deployer) isMigrated(o object) bool {
+	if d.opts.Migration == nil || o.obj.GetKind() != "Deployment" {
+		return false
+	}
+	dep := apiDeployment(d.opts)
+	return dep != nil && dep.GetName() == o.obj.GetName()
+}
+
+// migrate runs the migration Job before dep, the API deployment, is
+// applied, when the deployment doesn't exist yet or its image changes;
+// re-applying the same version runs nothing. It returns once the Job has
+// completed, and a *MigrationError when it failed.
+func (d *Deployer) migrate(ctx context.Context, dep *unstructured.Unstructured) error {
+	if d.opts.DryRun {
+		d.log.Info("Skipping the migration Job in a dry run")
+		return nil
+	}
+	var desired appsv1.Deployment
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(dep.Object, &desired); err != nil {
+		return fmt.Errorf("failed to convert deployment %s: %w", dep.GetName(), err)
+	}
+	revision := int64(1)
+	live, err := d.resource(DeploymentResource).Get(ctx, dep.GetName(), metav1.GetOptions{})
+	switch {
+	case apierrors.IsNotFound(err):
+	case err != nil:
+		return d.opError(OpGet, DeploymentResource, dep.GetName(), err)
+	default:
+		var current appsv1.Deployment
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(live.Object, &current); err != nil {
+			return fmt.Errorf("failed to convert deployment %s: %w", dep.GetName(), err)
+		}
+		if migratedImage(&current.Spec.Template.Spec) == migratedImage(&desired.Spec.Template.Spec) {
+			d.log.V(1).Info("The API image is unchanged, skipping the migration Job", "deployment", dep.GetName())
+			return nil
+		}
+		if n, err := strconv.ParseInt(live.GetAnnotations()[revisionAnnotation], 10, 64); err == nil {
+			revision = n + 1
+		}
+	}
+	if err := d.waitForPostgres(ctx); err != nil {
+		return err
+	}
+	if err := d.waitForRedis(ctx); err != nil {
+		return err
+	}
+
+	job := d.migrationJob(&desired, revision)
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(job)
+	if err != nil {
+		return fmt.Errorf("failed to convert the migration job: %w", err)
+	}
+	created, err := d.resource(JobResource).Create(ctx, &unstructured.Unstructured{Object: obj}, metav1.CreateOptions{FieldManager: d.opts.FieldManager})
+	if err != nil {
+		return d.opError(OpCreate, JobResource, job.GenerateName, err)
+	}
+	name := created.GetName()
+	d.log.Info("Running the migration", "namespace", d.opts.Namespace, "job", name, "revision", revision)
+	err = d.waitForMigration(ctx, name)
+	d.pruneMigrations(ctx)
+	return err
+}
+
+// migratedImage returns the image of the API container of spec, or of its
+// first container when none is named after the API.
+func migratedImage(spec *corev1.PodSpec) string {
+	c := migratedContainer(spec)
+	if c == nil {
+		return ""
+	}
+	return c.Image
+}
+
+func migratedContainer(spec *corev1.PodSpec) *corev1.Container {
+	for i := range spec.Containers {
+		if spec.Containers[i].Name == apiContainerName {
+			return &spec.Containers[i]
+		}
+	}
+	if len(spec.Containers) == 0 {
+		return nil
+	}
+	return &spec.Containers[0]
+}
+
+// migrationJob returns the Job running the migration with the pod spec of
+// dep's API container, without its probes and ports.
+func (d *Deployer) migrationJob(dep *appsv1.Deployment, revision int64) *batchv1.Job {
+	m := d.opts.Migration
+	spec := dep.Spec.Template.Spec.DeepCopy()
+	c := corev1.Container{Name: migrationContainer, Image: m.Image}
+	if api := migratedContainer(spec); api != nil {
+		c.Env, c.EnvFrom, c.VolumeMounts, c.Resources = api.Env, api.EnvFrom, api.VolumeMounts, api.Resources
+		c.ImagePullPolicy, c.SecurityContext = api.ImagePullPolicy, api.SecurityContext
+		if c.Image == "" {
+			c.Image = api.Image
+		}
+	}
+	if m.Command != "" {
+		c.Command = []string{"sh", "-c", m.Command}
+	}
+	spec.Containers = []corev1.Container{c}
+	spec.InitContainers = nil
+	spec.RestartPolicy = corev1.RestartPolicyNever
+	// The anti-affinity and spread constraints are about the API pods,
+	// which the Job shouldn't avoid.
+	spec.Affinity = d.opts.Affinity.DeepCopy()
+	spec.TopologySpreadConstraints = nil
+
+	jobLabels := d.opts.commonLabels(componentMigration)
+	jobLabels[RevisionLabel] = strconv.FormatInt(revision, 10)
+	backoff := m.BackoffLimit
+	return &batchv1.Job{
+		TypeMeta: metav1.TypeMeta{APIVersion: "batch/v1", Kind: "Job"},
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: dep.Name + "-migrate-",
+			Namespace:    d.opts.Namespace,
+			Labels:       jobLabels,
+			Annotations:  d.opts.Annotations,
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: &backoff,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: jobLabels, Annotations: d.opts.Annotations},
+				Spec:       *spec,
+			},
+		},
+	}
+}
+
+// waitForMigration polls the Job called name until it completes or fails,
+// streaming the logs of its pods to Migration.Logs meanwhile.
+func (d *Deployer) waitForMigration(ctx context.Context, name string) error {
+	var (
+		wg       sync.WaitGroup
+		streamed = map[string]bool{}
+		w        *lineWriter
+	)
+	if out := d.opts.Migration.Logs; out != nil {
+		if d.kube == nil {
+			d.log.Info("Warning: not streaming the migration logs, which needs a clientset")
+		} else {
+			w = &lineWriter{out: out}
+		}
+	}
+	// stream starts streaming the pods of the Job not streamed yet.
+	stream := func() {
+		var pods *corev1.PodList
+		err := d.retry.do(ctx, OpList, PodResource, "", func() (err error) {
+			pods, err = d.kube.CoreV1().Pods(d.opts.Namespace).List(ctx, metav1.ListOptions{
+				LabelSelector: labels.SelectorFromSet(map[string]string{"job-name": name}).String(),
+			})
+			return err
+		})
+		if err != nil {
+			d.log.Error(err, "Failed to list the migration pods", "job", name)
+			return
+		}
+		for _, pod := range pods.Items {
+			if streamed[pod.Name] || !containerStarted(pod, migrationContainer) {
+				continue
+			}
+			streamed[pod.Name] = true
+			wg.Add(1)
+			go func(pod string) {
+				defer wg.Done()
+				opts := &corev1.PodLogOptions{Container: migrationContainer, Follow: true}
+				if err := d.streamLogs(ctx, pod, opts, w); err != nil && ctx.Err() == nil {
+					d.log.Error(err, "Migration log stream failed", "pod", pod)
+				}
+			}(pod.Name)
+		}
+	}
+
+	var failure string
+	reason := "not started yet"
+	err := wait.PollImmediateUntilWithContext(ctx, rolloutPollInterval, func(ctx context.Context) (bool, error) {
+		job, err := d.resource(JobResource).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return false, d.opError(OpGet, JobResource, name, err)
+		}
+		if w != nil {
+			stream()
+		}
+		var done bool
+		done, failure, reason = jobFinished(job)
+		return done, nil
+	})
+	if err == nil && w != nil {
+		// Pick up the pod that finished between two polls.
+		stream()
+	}
+	wg.Wait()
+	switch {
+	case err != nil:
+		return d.opError(OpWait, JobResource, name, fmt.Errorf("completion (%s): %w", reason, err))
+	case failure != "":
+		return &MigrationError{Job: name, Reason: failure}
+	}
+	d.log.Info("Migration completed", "namespace", d.opts.Namespace, "job", name)
+	return nil
+}
+
+// jobFinished reports whether job has completed or failed, the failure
+// message when it failed, and otherwise what it is waiting for.
+func jobFinished(job *unstructured.Unstructured) (bool, string, string) {
+	conditions, _, _ := unstructured.NestedSlice(job.Object, "status", "conditions")
+	for _, c := range conditions {
+		c, _ := c.(map[string]interface{})
+		if c["status"] != string(corev1.ConditionTrue) {
+			continue
+		}
+		switch c["type"] {
+		case string(batchv1.JobComplete):
+			return true, "", ""
+		case string(batchv1.JobFailed):
+			message, _ := c["message"].(string)
+			if message == "" {
+				message, _ = c["reason"].(string)
+			}
+			return true, message, ""
+		}
+	}
+	failed, _, _ := unstructured.NestedInt64(job.Object, "status", "failed")
+	active, _, _ := unstructured.NestedInt64(job.Object, "status", "active")
+	return false, "", fmt.Sprintf("%d pods running, %d failed", active, failed)
+}
+
+// migrationSelector selects the migration Jobs of the release.
+func (o Options) migrationSelector() string {
+	return labels.SelectorFromSet(map[string]string{
+		InstanceLabel:  o.release(),
+		ComponentLabel: componentMigration,
+		ManagedByLabel: ManagedBy,
+	}).String()
+}
+
+// pruneMigrations deletes the finished migration Jobs of the release beyond
+// Migration.HistoryLimit, oldest first, along with their pods. Failures are
+// logged, as the migration itself is done.
+func (d *Deployer) pruneMigrations(ctx context.Context) {
+	limit := d.opts.Migration.HistoryLimit
+	if limit == 0 {
+		limit = DefaultMigrationHistoryLimit
+	}
+	list, err := d.resource(JobResource).List(ctx, metav1.ListOptions{LabelSelector: d.opts.migrationSelector()})
+	if err != nil {
+		d.log.Error(err, "Failed to list the migration jobs")
+		return
+	}
+	jobs := list.Items
+	sort.Slice(jobs, func(i, j int) bool {
+		ti, tj := jobs[i].GetCreationTimestamp(), jobs[j].GetCreationTimestamp()
+		return tj.Before(&ti)
+	})
+	if len(jobs) <= limit {
+		return
+	}
+	background := metav1.DeletePropagationBackground
+	for _, job := range jobs[limit:] {
+		if done, _, _ := jobFinished(&job); !done {
+			continue
+		}
+		d.log.V(1).Info("Deleting old migration job", "namespace", d.opts.Namespace, "job", job.GetName())
+		err := d.resource(JobResource).Delete(ctx, job.GetName(), metav1.DeleteOptions{PropagationPolicy: &background})
+		if err != nil && !apierrors.IsNotFound(err) {
+			d.log.Error(err, "Failed to delete old migration job", "job", job.GetName())
+		}
+	}
+}
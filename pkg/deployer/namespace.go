@@ -0,0 +1,61 @@
+package deployer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// errNamespaceTerminating is wrapped in the error EnsureNamespace returns for
+// a namespace that is being deleted.
+var errNamespaceTerminating = errors.New("namespace is terminating; wait for it to be deleted or pick another namespace")
+
+// EnsureNamespace checks that the target namespace can accept new objects.
+// A terminating namespace is an error, since creates into it would hang or be
+// rejected. A missing namespace is created when Options.CreateNamespace is set
+// and reported as an error otherwise. The created namespace is returned; nil
+// means nothing was created.
+//
+// Users without permission to read namespaces get the benefit of the doubt and
+// the check is skipped.
+func (d *Deployer) EnsureNamespace(ctx context.Context) (*unstructured.Unstructured, error) {
+	client := d.client.Resource(NamespaceResource)
+	live, err := client.Get(ctx, d.opts.Namespace, metav1.GetOptions{})
+	switch {
+	case err == nil:
+		phase, _, _ := unstructured.NestedString(live.Object, "status", "phase")
+		if phase == "Terminating" || live.GetDeletionTimestamp() != nil {
+			return nil, &DeployError{GVR: NamespaceResource, Name: d.opts.Namespace, Op: OpGet, Err: errNamespaceTerminating}
+		}
+		return nil, nil
+	case apierrors.IsForbidden(err):
+		return nil, nil
+	case !apierrors.IsNotFound(err):
+		return nil, &DeployError{GVR: NamespaceResource, Name: d.opts.Namespace, Op: OpGet, Err: err}
+	case !d.opts.CreateNamespace:
+		return nil, &DeployError{GVR: NamespaceResource, Name: d.opts.Namespace, Op: OpGet, Err: fmt.Errorf("%w; pass -create-namespace to create it", err)}
+	}
+
+	d.log.Info("Creating namespace", "namespace", d.opts.Namespace)
+	created, err := client.Create(ctx, newNamespace(d.opts.Namespace), metav1.CreateOptions{DryRun: d.dryRun()})
+	if err != nil {
+		return nil, &DeployError{GVR: NamespaceResource, Name: d.opts.Namespace, Op: OpCreate, Err: err}
+	}
+	applyLogFrom(ctx).add(created, ActionCreated, nil)
+	return created, nil
+}
+
+func newNamespace(name string) *unstructured.Unstructured {
+	return &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "Namespace",
+			"metadata": map[string]interface{}{
+				"name": name,
+			},
+		},
+	}
+}
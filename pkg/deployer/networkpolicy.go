@@ -0,0 +1,114 @@
+package deployer
+
+import (
+	"errors"
+	"fmt"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"strings"
+)
+
+// namespaceNameLabel is set on every namespace by the apiserver, so policies
+// can select a namespace by name.
+const namespaceNameLabel = "kubernetes.io/metadata.name"
+
+// NetworkPolicyResource is the GroupVersionResource of network policies.
+var NetworkPolicyResource = schema.GroupVersionResource{Group: "networking.k8s.io", Version: "v1", Resource: "networkpolicies"}
+
+// validateNetworkPolicy checks the ingress controller namespace is a valid
+// namespace name and only given along with the policy.
+func (o Options) validateNetworkPolicy() error {
+	if o.IngressControllerNamespace == "" {
+		return nil
+	}
+	if !o.NetworkPolicy {
+		return &ValidationError{Field: "ingress-controller-namespace", Err: errors.New("only applies with -network-policy")}
+	}
+	if errs := validation.IsDNS1123Label(o.IngressControllerNamespace); len(errs) > 0 {
+		return &ValidationError{Field: "ingress-controller-namespace", Err: fmt.Errorf("%q: %s", o.IngressControllerNamespace, strings.Join(errs, "; "))}
+	}
+	return nil
+}
+
+// newNetworkPolicy renders the embedded network policy, or returns nil when
+// Options.NetworkPolicy is off.
+func newNetworkPolicy(opts Options) *unstructured.Unstructured {
+	if !opts.NetworkPolicy {
+		return nil
+	}
+	var policy networkingv1.NetworkPolicy
+	decodeDefaultManifest("networkpolicy.yaml", &policy)
+	customizeNetworkPolicy(&policy, opts)
+	return mustToUnstructured(&policy)
+}
+
+// BuildNetworkPolicy returns the policy letting only pods of the same
+// namespace, and of Options.IngressControllerNamespace, reach the API ports,
+// or nil when Options.NetworkPolicy is off.
+func BuildNetworkPolicy(opts Options) *networkingv1.NetworkPolicy {
+	opts = opts.withDefaults()
+	if !opts.NetworkPolicy {
+		return nil
+	}
+	policy := &networkingv1.NetworkPolicy{
+		TypeMeta: metav1.TypeMeta{APIVersion: "networking.k8s.io/v1", Kind: "NetworkPolicy"},
+		Spec: networkingv1.NetworkPolicySpec{
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+			Ingress: []networkingv1.NetworkPolicyIngressRule{{
+				From: []networkingv1.NetworkPolicyPeer{{PodSelector: &metav1.LabelSelector{}}},
+			}},
+		},
+	}
+	customizeNetworkPolicy(policy, opts)
+	return policy
+}
+
+// customizeNetworkPolicy applies opts to the network policy: the API ports
+// are open, the ingress controller namespace may reach them too, and with
+// the database or the cache deployed egress is limited to them and to
+// cluster DNS.
+func customizeNetworkPolicy(policy *networkingv1.NetworkPolicy, opts Options) {
+	policy.Name = opts.deploymentName()
+	policy.Namespace = opts.Namespace
+	policy.Spec.PodSelector = metav1.LabelSelector{MatchLabels: opts.selector(componentAPI)}
+	policy.Spec.Ingress[0].Ports = apiPolicyPorts(opts)
+	if opts.IngressControllerNamespace != "" {
+		rule := &policy.Spec.Ingress[0]
+		rule.From = append(rule.From, networkingv1.NetworkPolicyPeer{
+			NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{namespaceNameLabel: opts.IngressControllerNamespace}},
+		})
+	}
+	if !opts.Postgres && !opts.Redis {
+		return
+	}
+	policy.Spec.PolicyTypes = append(policy.Spec.PolicyTypes, networkingv1.PolicyTypeEgress)
+	if opts.Postgres {
+		policy.Spec.Egress = append(policy.Spec.Egress, networkingv1.NetworkPolicyEgressRule{
+			To:    []networkingv1.NetworkPolicyPeer{{PodSelector: &metav1.LabelSelector{MatchLabels: opts.selector(componentDatabase)}}},
+			Ports: []networkingv1.NetworkPolicyPort{policyPort(corev1.ProtocolTCP, 5432)},
+		})
+	}
+	if opts.Redis {
+		policy.Spec.Egress = append(policy.Spec.Egress, networkingv1.NetworkPolicyEgressRule{
+			To:    []networkingv1.NetworkPolicyPeer{{PodSelector: &metav1.LabelSelector{MatchLabels: opts.selector(componentCache)}}},
+			Ports: []networkingv1.NetworkPolicyPort{policyPort(corev1.ProtocolTCP, redisPort)},
+		})
+	}
+	policy.Spec.Egress = append(policy.Spec.Egress, networkingv1.NetworkPolicyEgressRule{
+		To: []networkingv1.NetworkPolicyPeer{{
+			NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{namespaceNameLabel: "kube-system"}},
+			PodSelector:       &metav1.LabelSelector{MatchLabels: map[string]string{"k8s-app": "kube-dns"}},
+		}},
+		Ports: []networkingv1.NetworkPolicyPort{policyPort(corev1.ProtocolUDP, 53), policyPort(corev1.ProtocolTCP, 53)},
+	})
+}
+
+func policyPort(protocol corev1.Protocol, port int) networkingv1.NetworkPolicyPort {
+	p := intstr.FromInt(port)
+	return networkingv1.NetworkPolicyPort{Protocol: &protocol, Port: &p}
+}
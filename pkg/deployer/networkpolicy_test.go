@@ -0,0 +1,215 @@
+package deployer
+
+import (
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"reflect"
+	"sigs.k8s.io/yaml"
+	"testing"
+)
+
+// dnsEgress is the egress rule to cluster DNS of every policy with egress.
+const dnsEgress = `
+- to:
+  - namespaceSelector:
+      matchLabels:
+        kubernetes.io/metadata.name: kube-system
+    podSelector:
+      matchLabels:
+        k8s-app: kube-dns
+  ports:
+  - {protocol: UDP, port: 53}
+  - {protocol: TCP, port: 53}
+`
+
+func TestNetworkPolicySpec(t *testing.T) {
+	tests := []struct {
+		name string
+		opts Options
+		want string
+	}{
+		{"same namespace", Options{}, `
+podSelector:
+  matchLabels: {app: server}
+policyTypes: [Ingress]
+ingress:
+- from:
+  - podSelector: {}
+  ports:
+  - {protocol: TCP, port: 8080}
+`},
+		{"ports", Options{Ports: []Port{{Name: "http", ContainerPort: 8080}, {Name: "grpc", ContainerPort: 9090}}}, `
+podSelector:
+  matchLabels: {app: server}
+policyTypes: [Ingress]
+ingress:
+- from:
+  - podSelector: {}
+  ports:
+  - {protocol: TCP, port: 8080}
+  - {protocol: TCP, port: 9090}
+`},
+		{"ingress controller namespace", Options{IngressControllerNamespace: "ingress-nginx"}, `
+podSelector:
+  matchLabels: {app: server}
+policyTypes: [Ingress]
+ingress:
+- from:
+  - podSelector: {}
+  - namespaceSelector:
+      matchLabels:
+        kubernetes.io/metadata.name: ingress-nginx
+  ports:
+  - {protocol: TCP, port: 8080}
+`},
+		{"postgres egress", Options{Postgres: true}, `
+podSelector:
+  matchLabels: {app: server}
+policyTypes: [Ingress, Egress]
+ingress:
+- from:
+  - podSelector: {}
+  ports:
+  - {protocol: TCP, port: 8080}
+egress:
+- to:
+  - podSelector:
+      matchLabels: {app: postgres}
+  ports:
+  - {protocol: TCP, port: 5432}
+` + dnsEgress},
+		{"redis egress", Options{Redis: true}, `
+podSelector:
+  matchLabels: {app: server}
+policyTypes: [Ingress, Egress]
+ingress:
+- from:
+  - podSelector: {}
+  ports:
+  - {protocol: TCP, port: 8080}
+egress:
+- to:
+  - podSelector:
+      matchLabels: {app: redis}
+  ports:
+  - {protocol: TCP, port: 6379}
+` + dnsEgress},
+		{"postgres and redis egress", Options{Postgres: true, Redis: true}, `
+podSelector:
+  matchLabels: {app: server}
+policyTypes: [Ingress, Egress]
+ingress:
+- from:
+  - podSelector: {}
+  ports:
+  - {protocol: TCP, port: 8080}
+egress:
+- to:
+  - podSelector:
+      matchLabels: {app: postgres}
+  ports:
+  - {protocol: TCP, port: 5432}
+- to:
+  - podSelector:
+      matchLabels: {app: redis}
+  ports:
+  - {protocol: TCP, port: 6379}
+` + dnsEgress},
+		{"release", Options{Release: "shop", Postgres: true}, `
+podSelector:
+  matchLabels:
+    app.kubernetes.io/name: ecommerce-api
+    app.kubernetes.io/instance: shop
+    app.kubernetes.io/component: api
+policyTypes: [Ingress, Egress]
+ingress:
+- from:
+  - podSelector: {}
+  ports:
+  - {protocol: TCP, port: 8080}
+egress:
+- to:
+  - podSelector:
+      matchLabels:
+        app.kubernetes.io/name: ecommerce-api
+        app.kubernetes.io/instance: shop
+        app.kubernetes.io/component: database
+  ports:
+  - {protocol: TCP, port: 5432}
+` + dnsEgress},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var want networkingv1.NetworkPolicySpec
+			if err := yaml.UnmarshalStrict([]byte(tt.want), &want); err != nil {
+				t.Fatal(err)
+			}
+			tt.opts.NetworkPolicy = true
+
+			built := BuildNetworkPolicy(tt.opts)
+			if !reflect.DeepEqual(built.Spec, want) {
+				t.Errorf("BuildNetworkPolicy:\ngot  %s\nwant %s", specYAML(t, built.Spec), specYAML(t, want))
+			}
+			objects, err := Render(tt.opts)
+			if err != nil {
+				t.Fatalf("Render: %v", err)
+			}
+			var rendered *networkingv1.NetworkPolicy
+			for _, obj := range objects {
+				if obj.GetKind() == "NetworkPolicy" {
+					rendered = &networkingv1.NetworkPolicy{}
+					if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, rendered); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+			if rendered == nil {
+				t.Fatal("Render returned no NetworkPolicy")
+			}
+			if !reflect.DeepEqual(rendered.Spec, want) {
+				t.Errorf("Render:\ngot  %s\nwant %s", specYAML(t, rendered.Spec), specYAML(t, want))
+			}
+		})
+	}
+}
+
+func TestNetworkPolicyOff(t *testing.T) {
+	if policy := BuildNetworkPolicy(Options{Postgres: true}); policy != nil {
+		t.Errorf("got a policy without NetworkPolicy: %+v", policy)
+	}
+}
+
+func TestValidateNetworkPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    Options
+		wantErr bool
+	}{
+		{"no ingress controller namespace", Options{}, false},
+		{"ingress controller namespace", Options{NetworkPolicy: true, IngressControllerNamespace: "ingress-nginx"}, false},
+		{"without the policy", Options{IngressControllerNamespace: "ingress-nginx"}, true},
+		{"invalid namespace", Options{NetworkPolicy: true, IngressControllerNamespace: "Ingress_NGINX"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.opts.validateNetworkPolicy()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %t", err, tt.wantErr)
+			}
+			if err != nil {
+				if field := err.(*ValidationError).Field; field != "ingress-controller-namespace" {
+					t.Errorf("got field %q", field)
+				}
+			}
+		})
+	}
+}
+
+func specYAML(t *testing.T, spec networkingv1.NetworkPolicySpec) []byte {
+	t.Helper()
+	data, err := yaml.Marshal(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
@@ -0,0 +1,119 @@
+package deployer
+
+import (
+	"context"
+	"fmt"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// The ingress-nginx annotations NginxAnnotations set.
+const (
+	nginxProxyBodySizeAnnotation = "nginx.ingress.kubernetes.io/proxy-body-size"
+	nginxSSLRedirectAnnotation   = "nginx.ingress.kubernetes.io/ssl-redirect"
+	nginxRewriteTargetAnnotation = "nginx.ingress.kubernetes.io/rewrite-target"
+	// nginxClassName is the IngressClass of a default ingress-nginx
+	// install, whose ingresses get the annotations without asking the
+	// cluster.
+	nginxClassName = "nginx"
+)
+
+// NginxAnnotations are settings of ingress-nginx, set on the ingress as
+// annotations when its IngressClass belongs to ingress-nginx; other
+// controllers don't read them.
+type NginxAnnotations struct {
+	// ProxyBodySize is the largest request body accepted, e.g. 8m; "0"
+	// lifts the limit.
+	ProxyBodySize string
+	// SSLRedirect, when set, is whether plain HTTP requests are redirected
+	// to HTTPS.
+	SSLRedirect *bool
+	// RewriteTarget is the path requests are sent to the API with, e.g.
+	// /$2 with a path capturing groups.
+	RewriteTarget string
+}
+
+// annotations returns n as ingress-nginx annotations, nil when n sets
+// nothing.
+func (n NginxAnnotations) annotations() map[string]string {
+	annotations := map[string]string{}
+	if n.ProxyBodySize != "" {
+		annotations[nginxProxyBodySizeAnnotation] = n.ProxyBodySize
+	}
+	if n.SSLRedirect != nil {
+		annotations[nginxSSLRedirectAnnotation] = strconv.FormatBool(*n.SSLRedirect)
+	}
+	if n.RewriteTarget != "" {
+		annotations[nginxRewriteTargetAnnotation] = n.RewriteTarget
+	}
+	if len(annotations) == 0 {
+		return nil
+	}
+	return annotations
+}
+
+// nginxSize matches the sizes of the nginx configuration, such as 512k or 8m.
+var nginxSize = regexp.MustCompile(`^[0-9]+[kKmMgG]?$`)
+
+// validateIngressAnnotations checks Options.IngressAnnotations and
+// Options.Nginx.
+func (o Options) validateIngressAnnotations() error {
+	for _, k := range sortedKeys(o.IngressAnnotations) {
+		if errs := validation.IsQualifiedName(strings.ToLower(k)); len(errs) > 0 {
+			return &ValidationError{Field: "ingress-annotation", Err: fmt.Errorf("%s: %s", k, strings.Join(errs, "; "))}
+		}
+	}
+	if s := o.Nginx.ProxyBodySize; s != "" && !nginxSize.MatchString(s) {
+		return &ValidationError{Field: "proxy-body-size", Err: fmt.Errorf("must be a size such as 512k, 8m or 1g, got %q", s)}
+	}
+	if t := o.Nginx.RewriteTarget; t != "" && !strings.HasPrefix(t, "/") {
+		return &ValidationError{Field: "rewrite-target", Err: fmt.Errorf("must start with /, got %q", t)}
+	}
+	return nil
+}
+
+// ingressAnnotations returns the annotations of the built-in ingress: the
+// cert-manager issuer, the ingress-nginx ones when Options.IngressClass is
+// nginx, and Options.IngressAnnotations over both.
+func ingressAnnotations(opts Options) map[string]string {
+	var annotations map[string]string
+	if opts.CertManagerIssuer != "" {
+		annotations = map[string]string{clusterIssuerAnnotation: opts.CertManagerIssuer}
+	}
+	if opts.IngressClass == nginxClassName {
+		annotations = merge(annotations, opts.Nginx.annotations())
+	}
+	return merge(annotations, opts.IngressAnnotations)
+}
+
+// withNginxAnnotations adds the ingress-nginx annotations of Options.Nginx
+// to obj when class belongs to ingress-nginx, without replacing those obj
+// has. Other classes leave obj as it is, with a warning.
+func (d *Deployer) withNginxAnnotations(ctx context.Context, obj *unstructured.Unstructured, class string) (*unstructured.Unstructured, error) {
+	nginx := d.opts.Nginx.annotations()
+	if nginx == nil || class == nginxClassName {
+		return obj, nil
+	}
+	gvr := schema.GroupVersionResource{Group: ingressGroupKind.Group, Version: obj.GroupVersionKind().Version, Resource: "ingressclasses"}
+	ic, err := d.client.Resource(gvr).Get(ctx, class, metav1.GetOptions{})
+	switch {
+	case apierrors.IsNotFound(err) || apierrors.IsForbidden(err):
+		d.log.Info("Warning: can't tell the controller of the IngressClass, leaving out the nginx annotations", "class", class, "reason", err.Error())
+		return obj, nil
+	case err != nil:
+		return nil, d.opError(OpGet, gvr, class, err)
+	}
+	if controller, _, _ := unstructured.NestedString(ic.Object, "spec", "controller"); controller != nginxController {
+		d.log.Info("Warning: the IngressClass doesn't belong to ingress-nginx, leaving out the nginx annotations", "class", class, "controller", controller)
+		return obj, nil
+	}
+	obj = obj.DeepCopy()
+	obj.SetAnnotations(merge(nginx, obj.GetAnnotations()))
+	return obj, nil
+}
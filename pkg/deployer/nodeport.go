@@ -0,0 +1,144 @@
+package deployer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"net"
+	"strconv"
+)
+
+// The default --service-node-port-range of the apiserver. Ports outside it
+// are checked against the cluster with a dry run.
+const (
+	minDefaultNodePort = 30000
+	maxDefaultNodePort = 32767
+)
+
+// NodeResource is the GroupVersionResource of nodes.
+var NodeResource = schema.GroupVersionResource{Version: "v1", Resource: "nodes"}
+
+// nodePortService returns the name of the service Options.NodePort is set on:
+// server-svc when it is a NodePort service itself, nodeport-svc otherwise.
+func (o Options) nodePortService() string {
+	if o.ServiceType == "NodePort" {
+		return o.serviceName()
+	}
+	return o.nodePortName()
+}
+
+// setNodePort fixes the node port of svc's first port when svc is the service
+// Options.NodePort applies to. Otherwise the apiserver allocates one.
+func setNodePort(svc *unstructured.Unstructured, opts Options) {
+	if opts.NodePort == 0 || svc.GetName() != opts.nodePortService() {
+		return
+	}
+	ports, _, _ := unstructured.NestedSlice(svc.Object, "spec", "ports")
+	if len(ports) == 0 {
+		return
+	}
+	ports[0].(map[string]interface{})["nodePort"] = int64(opts.NodePort)
+	_ = unstructured.SetNestedSlice(svc.Object, ports, "spec", "ports")
+}
+
+// validateNodePort checks the node port is a valid port number. Whether the
+// cluster accepts it is only known to the apiserver; see checkNodePort.
+func (o Options) validateNodePort() error {
+	switch {
+	case o.NodePort == 0:
+		return nil
+	case o.NodePort < 0 || o.NodePort > 65535:
+		return &ValidationError{Field: "node-port", Err: fmt.Errorf("must be between 1 and 65535, got %d", o.NodePort)}
+	case o.ServiceType != "NodePort" && !o.ExposeNodePort:
+		return &ValidationError{Field: "node-port", Err: errors.New("needs a NodePort service; pass -service-type=NodePort or -expose-nodeport")}
+	}
+	return nil
+}
+
+// checkNodePort dry-run applies the node port service when Options.NodePort
+// is outside the default node port range, so a port the cluster's range
+// doesn't allow is reported before anything is changed.
+func (d *Deployer) checkNodePort(ctx context.Context, objects []object) error {
+	port := d.opts.NodePort
+	if port == 0 || (port >= minDefaultNodePort && port <= maxDefaultNodePort) {
+		return nil
+	}
+	dry := *d
+	dry.opts.DryRun = true
+	for _, o := range objects {
+		if o.obj.GetKind() != "Service" || o.obj.GetName() != d.opts.nodePortService() {
+			continue
+		}
+		_, err := dry.apply(ctx, o.gvr, o.obj)
+		if apierrors.IsInvalid(err) {
+			return &ValidationError{Field: "node-port", Err: err}
+		}
+		return err
+	}
+	return nil
+}
+
+// NodePorts returns the node ports of svc, as allocated by the apiserver.
+func NodePorts(svc *unstructured.Unstructured) []int64 {
+	ports, _, _ := unstructured.NestedSlice(svc.Object, "spec", "ports")
+	var nodePorts []int64
+	for _, p := range ports {
+		if m, ok := p.(map[string]interface{}); ok {
+			if n, _, _ := unstructured.NestedInt64(m, "nodePort"); n != 0 {
+				nodePorts = append(nodePorts, n)
+			}
+		}
+	}
+	return nodePorts
+}
+
+// NodePortURLs returns an http://address:port URL for every node and node
+// port of svc. Each node contributes its external IP, or its internal IP when
+// it has none.
+func (d *Deployer) NodePortURLs(ctx context.Context, svc *unstructured.Unstructured) ([]string, error) {
+	ports := NodePorts(svc)
+	if len(ports) == 0 {
+		return nil, nil
+	}
+	nodes, err := d.client.Resource(NodeResource).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, d.opError(OpList, NodeResource, "", err)
+	}
+	var urls []string
+	for _, node := range nodes.Items {
+		addr := nodeAddress(&node)
+		if addr == "" {
+			continue
+		}
+		for _, port := range ports {
+			urls = append(urls, "http://"+net.JoinHostPort(addr, strconv.FormatInt(port, 10)))
+		}
+	}
+	return urls, nil
+}
+
+// nodeAddress returns the external IP of node, falling back to its internal
+// IP.
+func nodeAddress(node *unstructured.Unstructured) string {
+	addresses, _, _ := unstructured.NestedSlice(node.Object, "status", "addresses")
+	byType := map[string]string{}
+	for _, a := range addresses {
+		m, ok := a.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		t, _, _ := unstructured.NestedString(m, "type")
+		addr, _, _ := unstructured.NestedString(m, "address")
+		if _, seen := byType[t]; !seen {
+			byType[t] = addr
+		}
+	}
+	if addr := byType["ExternalIP"]; addr != "" {
+		return addr
+	}
+	return byType["InternalIP"]
+}
@@ -0,0 +1,244 @@
+package deployer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"strconv"
+	"strings"
+)
+
+// RoutingRoute is the Options.Routing reaching the API through OpenShift
+// Routes, one per host and path, as the OpenShift router serves them.
+const RoutingRoute = "route"
+
+// RoutingAnnotation records on the API deployment the routing the release
+// was deployed with, so a later run without Options.Routing manages the
+// same objects.
+const RoutingAnnotation = "ecommerce-deployer/routing"
+
+// The values of Options.RouteInsecurePolicy, what the router does with
+// plain HTTP requests to a TLS route.
+const (
+	RouteInsecureRedirect = "Redirect"
+	RouteInsecureAllow    = "Allow"
+	RouteInsecureNone     = "None"
+)
+
+// OpenShiftRouteResource is the resource of the Routes created with
+// Options.Routing route.
+var OpenShiftRouteResource = schema.GroupVersionResource{Group: "route.openshift.io", Version: "v1", Resource: "routes"}
+
+// openShiftRouteGroupKind is the kind OpenShift serves.
+var openShiftRouteGroupKind = schema.GroupKind{Group: OpenShiftRouteResource.Group, Kind: "Route"}
+
+// errOpenShiftRouteNotServed is returned when Options.Routing is route on a
+// cluster that isn't OpenShift.
+var errOpenShiftRouteNotServed = errors.New("route.openshift.io/v1 Route is not served by the cluster; it needs OpenShift")
+
+// routing returns Options.Routing, RoutingIngress when it is empty.
+func (o Options) routing() string {
+	if o.Routing == "" {
+		return RoutingIngress
+	}
+	return o.Routing
+}
+
+// routeTLS reports whether the Routes terminate TLS at the router.
+func (o Options) routeTLS() bool {
+	return o.RouteEdgeTLS || len(o.TLSCert) > 0
+}
+
+// validateOpenShiftRoute checks the hosts and paths are ones a Route can
+// match, and the TLS settings ones it can hold. A Route embeds its
+// certificate rather than naming a secret.
+func (o Options) validateOpenShiftRoute() error {
+	for _, h := range o.Hosts {
+		if strings.HasPrefix(h, "*.") {
+			return &ValidationError{Field: "host", Err: fmt.Errorf("%s: wildcard hosts aren't supported with -routing=%s", h, RoutingRoute)}
+		}
+	}
+	for _, p := range o.Paths {
+		if p.PathType != "Prefix" {
+			return &ValidationError{Field: "path", Err: fmt.Errorf("%s: a Route matches path prefixes, not %s", p.Path, p.PathType)}
+		}
+	}
+	if o.TLSSecret != "" && len(o.TLSCert) == 0 {
+		return &ValidationError{Field: "tls-secret", Err: errors.New("a Route embeds its certificate rather than naming a secret; pass -tls-cert and -tls-key")}
+	}
+	switch o.RouteInsecurePolicy {
+	case "":
+	case RouteInsecureRedirect, RouteInsecureAllow, RouteInsecureNone:
+		if !o.routeTLS() {
+			return &ValidationError{Field: "route-insecure-policy", Err: errors.New("only applies to TLS routes; add -route-edge-tls or -tls-cert")}
+		}
+	default:
+		return &ValidationError{Field: "route-insecure-policy", Err: fmt.Errorf("must be %s, %s or %s, got %q",
+			RouteInsecureRedirect, RouteInsecureAllow, RouteInsecureNone, o.RouteInsecurePolicy)}
+	}
+	return nil
+}
+
+// openShiftRouteNames returns the names of the Routes, routeName for a
+// single host and path, numbered suffixes of it otherwise.
+func (o Options) openShiftRouteNames() []string {
+	n := len(o.Hosts) * len(o.Paths)
+	if n == 1 {
+		return []string{o.routeName()}
+	}
+	names := make([]string, 0, n)
+	for i := 1; i <= n; i++ {
+		names = append(names, o.routeName()+"-"+strconv.Itoa(i))
+	}
+	return names
+}
+
+// newOpenShiftRoutes returns a Route to server-svc for each host and path,
+// in that order, or nil unless Options.Routing is route. Both builders use
+// it, as there is no typed struct of the kind.
+func newOpenShiftRoutes(opts Options) []*unstructured.Unstructured {
+	if opts.Routing != RoutingRoute {
+		return nil
+	}
+	names := opts.openShiftRouteNames()
+	routes := make([]*unstructured.Unstructured, 0, len(names))
+	for _, h := range opts.Hosts {
+		for _, p := range opts.Paths {
+			spec := map[string]interface{}{
+				"host": h,
+				"path": p.Path,
+				"to": map[string]interface{}{
+					"kind":   "Service",
+					"name":   opts.serviceName(),
+					"weight": int64(100),
+				},
+				"port":           map[string]interface{}{"targetPort": opts.pathPort(p)},
+				"wildcardPolicy": "None",
+			}
+			if opts.routeTLS() {
+				tls := map[string]interface{}{"termination": "edge"}
+				if opts.RouteInsecurePolicy != "" {
+					tls["insecureEdgeTerminationPolicy"] = opts.RouteInsecurePolicy
+				}
+				if len(opts.TLSCert) > 0 {
+					tls["certificate"] = string(opts.TLSCert)
+					tls["key"] = string(opts.TLSKey)
+				}
+				spec["tls"] = tls
+			}
+			obj := &unstructured.Unstructured{Object: map[string]interface{}{
+				"apiVersion": OpenShiftRouteResource.GroupVersion().String(),
+				"kind":       openShiftRouteGroupKind.Kind,
+				"spec":       spec,
+			}}
+			obj.SetName(names[len(routes)])
+			obj.SetNamespace(opts.Namespace)
+			routes = append(routes, obj)
+		}
+	}
+	return routes
+}
+
+// servesOpenShiftRoute reports whether the cluster serves OpenShift's Route.
+func (d *Deployer) servesOpenShiftRoute() (bool, error) {
+	_, err := d.mapper.RESTMapping(openShiftRouteGroupKind, OpenShiftRouteResource.Version)
+	if meta.IsNoMatchError(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to discover the route.openshift.io API: %w", err)
+	}
+	return true, nil
+}
+
+// ResolveRouting settles an empty Options.Routing before the cluster is
+// touched. A release already deployed keeps the routing its API deployment
+// records in RoutingAnnotation, or the ingress when it predates the record,
+// so delete, status and diff find the objects it was installed with. A new
+// release gets Routes on clusters whose discovery serves
+// route.openshift.io/v1, and an ingress elsewhere.
+func (d *Deployer) ResolveRouting(ctx context.Context) error {
+	if d.opts.Routing != "" || len(d.opts.Manifests) > 0 {
+		return nil
+	}
+	name := d.opts.deploymentName()
+	live, err := d.resource(DeploymentResource).Get(ctx, name, metav1.GetOptions{})
+	switch {
+	case err == nil:
+		routing := live.GetAnnotations()[RoutingAnnotation]
+		switch routing {
+		case RoutingIngress, RoutingGateway, RoutingRoute:
+		case "":
+			routing = RoutingIngress
+		default:
+			return fmt.Errorf("deployment %s records an unknown routing %q in %s; pass -routing", name, routing, RoutingAnnotation)
+		}
+		d.log.V(1).Info("Routing as the release was deployed", "routing", routing)
+		d.opts.Routing = routing
+		return nil
+	case !apierrors.IsNotFound(err):
+		return d.opError(OpGet, DeploymentResource, name, err)
+	}
+	// The static mapper of a Deployer without a clientset maps every
+	// built-in kind, so only discovery can tell OpenShift apart.
+	d.opts.Routing = RoutingIngress
+	if d.kube == nil {
+		return nil
+	}
+	served, err := d.servesOpenShiftRoute()
+	if err != nil {
+		return err
+	}
+	if served {
+		d.log.Info("The cluster serves OpenShift Routes, routing through them instead of an ingress")
+		d.opts.Routing = RoutingRoute
+	}
+	return nil
+}
+
+// OpenShiftRouteStatus is the state of a Route as the routers report it.
+type OpenShiftRouteStatus struct {
+	Name    string `json:"name"`
+	Present bool   `json:"present"`
+	Host    string `json:"host"`
+	Path    string `json:"path,omitempty"`
+	// Routers are the routers that admitted the route.
+	Routers []string `json:"routers,omitempty"`
+	// Message is why a router didn't admit it.
+	Message string `json:"message,omitempty"`
+}
+
+// routeRouters returns the routers that admitted the live Route, and the
+// message of the first that didn't.
+func routeRouters(live *unstructured.Unstructured) ([]string, string) {
+	ingresses, _, _ := unstructured.NestedSlice(live.Object, "status", "ingress")
+	var (
+		routers []string
+		message string
+	)
+	for _, i := range ingresses {
+		i, ok := i.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		router, _ := i["routerName"].(string)
+		conditions, _, _ := unstructured.NestedSlice(i, "conditions")
+		for _, c := range conditions {
+			c, ok := c.(map[string]interface{})
+			if !ok || c["type"] != "Admitted" {
+				continue
+			}
+			if c["status"] == "True" {
+				routers = append(routers, router)
+			} else if m, _ := c["message"].(string); message == "" {
+				message = router + ": " + m
+			}
+		}
+	}
+	return routers, message
+}
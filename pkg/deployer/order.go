@@ -0,0 +1,207 @@
+package deployer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	"sort"
+	"strconv"
+)
+
+// ApplyWeightAnnotation overrides the weight an object of Options.Manifests
+// or Options.ExtraManifests is applied by: lower weights go first, and
+// objects of equal weight keep their order. The defaults are
+// weightNamespace through weightOther.
+const ApplyWeightAnnotation = "ecommerce-deployer/apply-weight"
+
+// The default weights of the kinds of manifests, so what an object depends
+// on is applied before it.
+const (
+	weightNamespace = 0
+	weightCRD       = 10
+	// weightConfig holds RBAC and what the workloads read or run under.
+	weightConfig   = 20
+	weightWorkload = 30
+	weightNetwork  = 40
+	// weightOther holds the custom resources and every kind not listed.
+	weightOther = 50
+)
+
+var crdGroupKind = schema.GroupKind{Group: CRDResource.Group, Kind: "CustomResourceDefinition"}
+
+// kindWeights are the default weights of the kinds that aren't weightOther.
+var kindWeights = map[schema.GroupKind]int{
+	{Kind: "Namespace"}: weightNamespace,
+	crdGroupKind:        weightCRD,
+
+	{Kind: "ServiceAccount"}:                                         weightConfig,
+	{Kind: "Secret"}:                                                 weightConfig,
+	{Kind: "ConfigMap"}:                                              weightConfig,
+	{Kind: "PersistentVolume"}:                                       weightConfig,
+	{Kind: "PersistentVolumeClaim"}:                                  weightConfig,
+	{Kind: "ResourceQuota"}:                                          weightConfig,
+	{Kind: "LimitRange"}:                                             weightConfig,
+	{Group: "rbac.authorization.k8s.io", Kind: "Role"}:               weightConfig,
+	{Group: "rbac.authorization.k8s.io", Kind: "ClusterRole"}:        weightConfig,
+	{Group: "rbac.authorization.k8s.io", Kind: "RoleBinding"}:        weightConfig,
+	{Group: "rbac.authorization.k8s.io", Kind: "ClusterRoleBinding"}: weightConfig,
+	{Group: "scheduling.k8s.io", Kind: "PriorityClass"}:              weightConfig,
+	{Group: "storage.k8s.io", Kind: "StorageClass"}:                  weightConfig,
+
+	{Kind: "Pod"}:                                           weightWorkload,
+	{Group: "apps", Kind: "Deployment"}:                     weightWorkload,
+	{Group: "apps", Kind: "StatefulSet"}:                    weightWorkload,
+	{Group: "apps", Kind: "DaemonSet"}:                      weightWorkload,
+	{Group: "apps", Kind: "ReplicaSet"}:                     weightWorkload,
+	{Group: "batch", Kind: "Job"}:                           weightWorkload,
+	{Group: "batch", Kind: "CronJob"}:                       weightWorkload,
+	{Group: "autoscaling", Kind: "HorizontalPodAutoscaler"}: weightWorkload,
+	{Group: "policy", Kind: "PodDisruptionBudget"}:          weightWorkload,
+
+	{Kind: "Service"}: weightNetwork,
+	ingressGroupKind:  weightNetwork,
+	{Group: "networking.k8s.io", Kind: "IngressClass"}:  weightNetwork,
+	{Group: "networking.k8s.io", Kind: "NetworkPolicy"}: weightNetwork,
+	httpRouteGroupKind:      weightNetwork,
+	openShiftRouteGroupKind: weightNetwork,
+}
+
+// applyWeight returns the weight obj is applied by.
+func applyWeight(obj *unstructured.Unstructured) (int, error) {
+	if s, ok := obj.GetAnnotations()[ApplyWeightAnnotation]; ok {
+		weight, err := strconv.Atoi(s)
+		if err != nil {
+			return 0, fmt.Errorf("%s %s: %s must be an integer, got %q", obj.GetKind(), obj.GetName(), ApplyWeightAnnotation, s)
+		}
+		return weight, nil
+	}
+	if weight, ok := kindWeights[obj.GroupVersionKind().GroupKind()]; ok {
+		return weight, nil
+	}
+	return weightOther, nil
+}
+
+// orderObjects sorts manifests by the weight they are applied by, keeping
+// the order of those of equal weight. Validate reports weights that don't
+// parse; they count as weightOther here.
+func orderObjects(objects []*unstructured.Unstructured) []*unstructured.Unstructured {
+	weights := make(map[*unstructured.Unstructured]int, len(objects))
+	for _, obj := range objects {
+		weight, err := applyWeight(obj)
+		if err != nil {
+			weight = weightOther
+		}
+		weights[obj] = weight
+	}
+	ordered := append([]*unstructured.Unstructured(nil), objects...)
+	sort.SliceStable(ordered, func(i, j int) bool { return weights[ordered[i]] < weights[ordered[j]] })
+	return ordered
+}
+
+// definedMapping returns the mapping of obj as a CustomResourceDefinition
+// among objects defines it, or nil when none does. It resolves the custom
+// resources applied along with their definition, before discovery serves
+// them.
+func definedMapping(objects []*unstructured.Unstructured, obj *unstructured.Unstructured) (*meta.RESTMapping, string) {
+	gvk := obj.GroupVersionKind()
+	for _, crd := range objects {
+		if crd.GroupVersionKind().GroupKind() != crdGroupKind {
+			continue
+		}
+		group, _, _ := unstructured.NestedString(crd.Object, "spec", "group")
+		kind, _, _ := unstructured.NestedString(crd.Object, "spec", "names", "kind")
+		plural, _, _ := unstructured.NestedString(crd.Object, "spec", "names", "plural")
+		if group != gvk.Group || kind != gvk.Kind || plural == "" {
+			continue
+		}
+		versions, _, _ := unstructured.NestedSlice(crd.Object, "spec", "versions")
+		for _, v := range versions {
+			version, _ := v.(map[string]interface{})
+			if version["name"] != gvk.Version || version["served"] == false {
+				continue
+			}
+			mapping := &meta.RESTMapping{
+				Resource:         gvk.GroupVersion().WithResource(plural),
+				GroupVersionKind: gvk,
+				Scope:            meta.RESTScopeNamespace,
+			}
+			if scope, _, _ := unstructured.NestedString(crd.Object, "spec", "scope"); scope == "Cluster" {
+				mapping.Scope = meta.RESTScopeRoot
+			}
+			return mapping, crd.GetName()
+		}
+	}
+	return nil, ""
+}
+
+// isNoMatch reports whether err, possibly wrapped, is a RESTMapper's
+// no-match error.
+func isNoMatch(err error) bool {
+	var kind *meta.NoKindMatchError
+	var resource *meta.NoResourceMatchError
+	return errors.As(err, &kind) || errors.As(err, &resource)
+}
+
+// waitEstablished watches the CustomResourceDefinition crd until the
+// apiserver serves it, for up to crdEstablishTimeout, and then invalidates
+// the discovery cache so the kinds it defines resolve.
+func (d *Deployer) waitEstablished(ctx context.Context, crd *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	ctx, cancel := context.WithTimeout(ctx, crdEstablishTimeout)
+	defer cancel()
+	client := d.client.Resource(CRDResource)
+	resourceVersion := crd.GetResourceVersion()
+	for !conditionTrue(crd, "Established") {
+		w, err := client.Watch(ctx, metav1.ListOptions{
+			FieldSelector:   fields.OneTermEqualSelector("metadata.name", crd.GetName()).String(),
+			ResourceVersion: resourceVersion,
+		})
+		if err == nil {
+			crd, resourceVersion = d.nextEstablished(w, crd)
+			w.Stop()
+		}
+		if ctx.Err() != nil && !conditionTrue(crd, "Established") {
+			err = ctx.Err()
+		}
+		if err != nil {
+			return crd, d.opError(OpWait, CRDResource, crd.GetName(), fmt.Errorf("established condition: %w", err))
+		}
+	}
+	d.log.V(1).Info("CustomResourceDefinition established", "name", crd.GetName())
+	d.resetMapper()
+	return crd, nil
+}
+
+// nextEstablished follows w until crd is established or the watch ends,
+// returning the last state seen and the resourceVersion to resume from,
+// empty when it expired.
+func (d *Deployer) nextEstablished(w watch.Interface, crd *unstructured.Unstructured) (*unstructured.Unstructured, string) {
+	resourceVersion := crd.GetResourceVersion()
+	for ev := range w.ResultChan() {
+		if ev.Type == watch.Error {
+			return crd, ""
+		}
+		obj, ok := ev.Object.(*unstructured.Unstructured)
+		if !ok || ev.Type == watch.Bookmark {
+			continue
+		}
+		crd, resourceVersion = obj, obj.GetResourceVersion()
+		if conditionTrue(crd, "Established") {
+			break
+		}
+	}
+	return crd, resourceVersion
+}
+
+// resetMapper invalidates the discovery cache of the RESTMapper, when it has
+// one.
+func (d *Deployer) resetMapper() {
+	if m, ok := d.mapper.(interface{ Reset() }); ok {
+		m.Reset()
+	}
+}
@@ -0,0 +1,226 @@
+package deployer
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	jsonpatch "github.com/evanphx/json-patch"
+	"io"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/kubernetes/scheme"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Patch changes one of the objects before it is applied, for differences
+// between environments such as replicas or hosts.
+type Patch struct {
+	// Target is the object patched.
+	Target PatchTarget
+	// Type is types.StrategicMergePatchType or types.JSONPatchType. Kinds
+	// without a strategic merge schema, such as custom resources, take a
+	// strategic merge patch as a JSON merge patch.
+	Type types.PatchType
+	// Data is the patch as JSON.
+	Data []byte
+	// Source says where the patch came from, e.g. "prod/replicas.yaml
+	// document 1", in errors.
+	Source string
+}
+
+// PatchTarget matches an object by the group of its apiVersion, "" for the
+// core group, its kind and its name.
+type PatchTarget struct {
+	Group string
+	Kind  string
+	Name  string
+}
+
+func (t PatchTarget) String() string {
+	gk := schema.GroupKind{Group: t.Group, Kind: t.Kind}
+	return gk.String() + " " + t.Name
+}
+
+// matches reports whether obj is the target.
+func (t PatchTarget) matches(obj *unstructured.Unstructured) bool {
+	gvk := obj.GroupVersionKind()
+	return gvk.Group == t.Group && gvk.Kind == t.Kind && obj.GetName() == t.Name
+}
+
+// jsonPatchDocument is an overlay document holding a JSON6902 patch.
+type jsonPatchDocument struct {
+	Target struct {
+		Group string `json:"group"`
+		Kind  string `json:"kind"`
+		Name  string `json:"name"`
+	} `json:"target"`
+	Patch []map[string]interface{} `json:"patch"`
+}
+
+// LoadOverlay reads the patches of every *.yaml and *.yml file in dir, in
+// lexical order, and the documents of each file in order. A document is
+// either a strategic merge patch, a partial object naming its apiVersion,
+// kind and metadata.name, or a JSON6902 patch:
+//
+//	target: {group: apps, kind: Deployment, name: apiserver}
+//	patch:
+//	- {op: replace, path: /spec/replicas, value: 5}
+func LoadOverlay(dir string) ([]Patch, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read overlay: %w", err)
+	}
+	var files []string
+	for _, e := range entries {
+		ext := strings.ToLower(filepath.Ext(e.Name()))
+		if !e.IsDir() && (ext == ".yaml" || ext == ".yml") {
+			files = append(files, filepath.Join(dir, e.Name()))
+		}
+	}
+	sort.Strings(files)
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no *.yaml or *.yml files in overlay %s", dir)
+	}
+
+	var patches []Patch
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read overlay: %w", err)
+		}
+		dec := utilyaml.NewYAMLOrJSONDecoder(bytes.NewReader(data), 4096)
+		for i := 1; ; i++ {
+			var raw runtime.RawExtension
+			if err := dec.Decode(&raw); errors.Is(err, io.EOF) {
+				break
+			} else if err != nil {
+				return nil, fmt.Errorf("%s: document %d: %w", file, i, err)
+			}
+			doc := bytes.TrimSpace(raw.Raw)
+			if len(doc) == 0 || bytes.Equal(doc, []byte("null")) {
+				continue
+			}
+			p, err := decodePatch(doc)
+			if err != nil {
+				return nil, fmt.Errorf("%s: document %d: %w", file, i, err)
+			}
+			p.Source = fmt.Sprintf("%s document %d", file, i)
+			patches = append(patches, p)
+		}
+	}
+	return patches, nil
+}
+
+// decodePatch decodes an overlay document given as JSON.
+func decodePatch(doc []byte) (Patch, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(doc, &fields); err != nil {
+		return Patch{}, err
+	}
+	if _, ok := fields["patch"]; ok {
+		var jp jsonPatchDocument
+		if err := json.Unmarshal(doc, &jp); err != nil {
+			return Patch{}, err
+		}
+		if jp.Target.Kind == "" || jp.Target.Name == "" {
+			return Patch{}, errors.New("a JSON6902 patch needs target.kind and target.name")
+		}
+		data, err := json.Marshal(jp.Patch)
+		if err != nil {
+			return Patch{}, err
+		}
+		if _, err := jsonpatch.DecodePatch(data); err != nil {
+			return Patch{}, err
+		}
+		return Patch{
+			Target: PatchTarget{Group: jp.Target.Group, Kind: jp.Target.Kind, Name: jp.Target.Name},
+			Type:   types.JSONPatchType,
+			Data:   data,
+		}, nil
+	}
+
+	obj := &unstructured.Unstructured{}
+	if err := obj.UnmarshalJSON(doc); err != nil {
+		return Patch{}, err
+	}
+	if obj.GetName() == "" {
+		return Patch{}, errors.New("a strategic merge patch needs apiVersion, kind and metadata.name")
+	}
+	gvk := obj.GroupVersionKind()
+	return Patch{
+		Target: PatchTarget{Group: gvk.Group, Kind: gvk.Kind, Name: obj.GetName()},
+		Type:   types.StrategicMergePatchType,
+		Data:   doc,
+	}, nil
+}
+
+// applyPatches returns objects with patches applied in order, so later ones
+// win. A patch whose target isn't among objects is an error.
+func applyPatches(objects []*unstructured.Unstructured, patches []Patch) ([]*unstructured.Unstructured, error) {
+	if len(patches) == 0 {
+		return objects, nil
+	}
+	patched := make([]*unstructured.Unstructured, len(objects))
+	copy(patched, objects)
+	for _, p := range patches {
+		found := false
+		for i, obj := range patched {
+			if !p.Target.matches(obj) {
+				continue
+			}
+			found = true
+			out, err := applyPatch(obj, p)
+			if err != nil {
+				return nil, fmt.Errorf("%s: failed to patch %s: %w", p.Source, p.Target, err)
+			}
+			patched[i] = out
+		}
+		if !found {
+			return nil, fmt.Errorf("%s: no %s among the objects to patch", p.Source, p.Target)
+		}
+	}
+	return patched, nil
+}
+
+// applyPatch returns obj with p applied.
+func applyPatch(obj *unstructured.Unstructured, p Patch) (*unstructured.Unstructured, error) {
+	original, err := obj.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+	var data []byte
+	switch p.Type {
+	case types.JSONPatchType:
+		patch, err := jsonpatch.DecodePatch(p.Data)
+		if err != nil {
+			return nil, err
+		}
+		data, err = patch.Apply(original)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		typed, err := scheme.Scheme.New(obj.GroupVersionKind())
+		if err != nil {
+			data, err = jsonpatch.MergePatch(original, p.Data)
+		} else {
+			data, err = strategicpatch.StrategicMergePatch(original, p.Data, typed)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	out := &unstructured.Unstructured{}
+	if err := out.UnmarshalJSON(data); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
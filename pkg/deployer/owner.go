@@ -0,0 +1,121 @@
+package deployer
+
+import (
+	"errors"
+	"fmt"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// The values of Options.Owner.
+const (
+	// OwnerDeployment makes the API deployment own the services and the
+	// ingress or routes.
+	OwnerDeployment = "deployment"
+	// OwnerRelease makes a release record ConfigMap own every other object
+	// of the release but the persistent volume claims, which outlive it like
+	// those of a StatefulSet.
+	OwnerRelease = "release"
+)
+
+// validateOwner checks Options.Owner is a known owner. The release record is
+// a built-in object, so it can't own Options.Manifests.
+func (o Options) validateOwner() error {
+	switch o.Owner {
+	case "", OwnerDeployment:
+		return nil
+	case OwnerRelease:
+		if len(o.Manifests) > 0 {
+			return &ValidationError{Field: "owner", Err: errors.New("the release record only owns the built-in objects, not -f manifests")}
+		}
+		return nil
+	}
+	return &ValidationError{Field: "owner", Err: fmt.Errorf("must be %s or %s, got %q", OwnerDeployment, OwnerRelease, o.Owner)}
+}
+
+// releaseRecordName is the ConfigMap owning the release with OwnerRelease.
+func (o Options) releaseRecordName() string {
+	return o.objectName("release", "server-release")
+}
+
+// newReleaseRecord returns the release record ConfigMap, or nil unless
+// Options.Owner is OwnerRelease.
+func newReleaseRecord(opts Options) *unstructured.Unstructured {
+	if opts.Owner != OwnerRelease {
+		return nil
+	}
+	return &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "ConfigMap",
+			"metadata": map[string]interface{}{
+				"name":      opts.releaseRecordName(),
+				"namespace": opts.Namespace,
+			},
+			"data": map[string]interface{}{
+				"release": opts.release(),
+				"image":   opts.Image,
+			},
+		},
+	}
+}
+
+// BuildReleaseRecord returns the release record ConfigMap, or nil unless
+// Options.Owner is OwnerRelease. Deleting it deletes the release.
+func BuildReleaseRecord(opts Options) *corev1.ConfigMap {
+	opts = opts.withDefaults()
+	if opts.Owner != OwnerRelease {
+		return nil
+	}
+	return &corev1.ConfigMap{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"},
+		ObjectMeta: metav1.ObjectMeta{Name: opts.releaseRecordName(), Namespace: opts.Namespace},
+		Data:       map[string]string{"release": opts.release(), "image": opts.Image},
+	}
+}
+
+// ownerRef returns a reference to applied when it is the owner selected by
+// Options.Owner, or nil. Its UID is only known once it has been applied.
+func (d *Deployer) ownerRef(applied *unstructured.Unstructured) *metav1.OwnerReference {
+	switch {
+	case applied.GetUID() == "":
+		return nil
+	case d.opts.Owner == OwnerDeployment && applied.GetKind() == "Deployment":
+		if dep := apiDeployment(d.opts); dep == nil || dep.GetName() != applied.GetName() {
+			return nil
+		}
+	case d.opts.Owner == OwnerRelease && applied.GetKind() == "ConfigMap" && applied.GetName() == d.opts.releaseRecordName():
+	default:
+		return nil
+	}
+	return &metav1.OwnerReference{
+		APIVersion: applied.GetAPIVersion(),
+		Kind:       applied.GetKind(),
+		Name:       applied.GetName(),
+		UID:        applied.GetUID(),
+	}
+}
+
+// setOwner makes owner, which may be nil, own obj when Options.Owner selects
+// obj. Owners can't be in another namespace than their dependents, so
+// cluster-scoped objects and those of other namespaces are left alone.
+func (d *Deployer) setOwner(obj *unstructured.Unstructured, owner *metav1.OwnerReference) {
+	if owner == nil || obj.GetNamespace() != d.opts.Namespace || obj.GetName() == owner.Name && obj.GetKind() == owner.Kind {
+		return
+	}
+	switch {
+	case d.opts.appOwner != nil, d.opts.Owner == OwnerRelease:
+		if obj.GetKind() == "PersistentVolumeClaim" {
+			return
+		}
+	case d.opts.Owner == OwnerDeployment:
+		switch obj.GroupVersionKind().GroupKind() {
+		case schema.GroupKind{Kind: "Service"}, ingressGroupKind, httpRouteGroupKind, openShiftRouteGroupKind:
+		default:
+			return
+		}
+	}
+	obj.SetOwnerReferences([]metav1.OwnerReference{*owner})
+}
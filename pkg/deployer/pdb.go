@@ -0,0 +1,128 @@
+package deployer
+
+import (
+	"errors"
+	"fmt"
+	policyv1 "k8s.io/api/policy/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"reflect"
+)
+
+// PDBResource is the GroupVersionResource of pod disruption budgets.
+var PDBResource = schema.GroupVersionResource{Group: "policy", Version: "v1", Resource: "poddisruptionbudgets"}
+
+// ParseDisruptionBudget parses a -pdb-min-available or -pdb-max-unavailable
+// value, a pod count such as 1 or a percentage such as 50%.
+func ParseDisruptionBudget(s string) (*intstr.IntOrString, error) {
+	return ParseIntOrPercent(s)
+}
+
+// pdbReplicas is the replica count a disruption budget is checked against:
+// the autoscaler's minimum when there is one, since the deployment may run
+// that few pods.
+func (o Options) pdbReplicas() int32 {
+	if o.Autoscale != nil {
+		return o.Autoscale.Min
+	}
+	return *o.Replicas
+}
+
+// validatePDB checks that at most one budget is set and that minAvailable
+// doesn't exceed the replica count.
+func (o Options) validatePDB() error {
+	if o.PDBMinAvailable != nil && o.PDBMaxUnavailable != nil {
+		return &ValidationError{Field: "pdb-min-available", Err: errors.New("set either -pdb-min-available or -pdb-max-unavailable, not both")}
+	}
+	if m := o.PDBMinAvailable; m != nil && m.Type == intstr.Int && m.IntVal > o.pdbReplicas() {
+		return &ValidationError{Field: "pdb-min-available", Err: fmt.Errorf("%d is more than the %d replicas the deployment may run", m.IntVal, o.pdbReplicas())}
+	}
+	return nil
+}
+
+// blocksEvictions reports whether the budget never allows a pod to be
+// evicted, which stalls every node drain.
+func (o Options) blocksEvictions() bool {
+	switch {
+	case o.PDBMinAvailable != nil:
+		m := o.PDBMinAvailable
+		return m.Type == intstr.Int && m.IntVal >= o.pdbReplicas() || m.Type == intstr.String && m.StrVal == "100%"
+	case o.PDBMaxUnavailable != nil:
+		m := o.PDBMaxUnavailable
+		return m.Type == intstr.Int && m.IntVal == 0 || m.Type == intstr.String && m.StrVal == "0%"
+	}
+	return false
+}
+
+// warnBlockingPDB logs when the disruption budget blocks all evictions.
+func (d *Deployer) warnBlockingPDB() {
+	if len(d.opts.Manifests) == 0 && d.opts.blocksEvictions() {
+		d.log.Info("Warning: the PodDisruptionBudget allows no pod to be evicted, so node drains will hang until it is relaxed",
+			"replicas", d.opts.pdbReplicas())
+	}
+}
+
+// newPDB returns the disruption budget of the API pods, or nil when neither
+// budget is set.
+func newPDB(opts Options) *unstructured.Unstructured {
+	spec := map[string]interface{}{
+		"selector": map[string]interface{}{
+			"matchLabels": stringMap(opts.selector(componentAPI)),
+		},
+	}
+	switch {
+	case opts.PDBMinAvailable != nil:
+		spec["minAvailable"] = intOrStringValue(*opts.PDBMinAvailable)
+	case opts.PDBMaxUnavailable != nil:
+		spec["maxUnavailable"] = intOrStringValue(*opts.PDBMaxUnavailable)
+	default:
+		return nil
+	}
+	return &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "policy/v1",
+			"kind":       "PodDisruptionBudget",
+			"metadata": map[string]interface{}{
+				"name":      opts.deploymentName(),
+				"namespace": opts.Namespace,
+			},
+			"spec": spec,
+		},
+	}
+}
+
+// BuildPDB returns the disruption budget of the API pods, or nil when
+// neither budget is set.
+func BuildPDB(opts Options) *policyv1.PodDisruptionBudget {
+	opts = opts.withDefaults()
+	if opts.PDBMinAvailable == nil && opts.PDBMaxUnavailable == nil {
+		return nil
+	}
+	return &policyv1.PodDisruptionBudget{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "policy/v1", Kind: "PodDisruptionBudget"},
+		ObjectMeta: metav1.ObjectMeta{Name: opts.deploymentName(), Namespace: opts.Namespace},
+		Spec: policyv1.PodDisruptionBudgetSpec{
+			Selector:       &metav1.LabelSelector{MatchLabels: opts.selector(componentAPI)},
+			MinAvailable:   opts.PDBMinAvailable,
+			MaxUnavailable: opts.PDBMaxUnavailable,
+		},
+	}
+}
+
+func intOrStringValue(v intstr.IntOrString) interface{} {
+	if v.Type == intstr.String {
+		return v.StrVal
+	}
+	return int64(v.IntVal)
+}
+
+// pdbSelectorChanged reports whether the desired budget selects other pods
+// than the live one. Clusters that keep the selector immutable reject such
+// a change, so the budget is recreated instead.
+func pdbSelectorChanged(live, desired *unstructured.Unstructured) bool {
+	liveSelector, _, _ := unstructured.NestedMap(live.Object, "spec", "selector")
+	desiredSelector, _, _ := unstructured.NestedMap(desired.Object, "spec", "selector")
+	return !reflect.DeepEqual(liveSelector, desiredSelector)
+}
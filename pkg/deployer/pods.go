@@ -0,0 +1,166 @@
+package deployer
+
+import (
+	"context"
+	"fmt"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"strings"
+)
+
+// podLogTailLines is how many log lines are collected from crash looping containers.
+const podLogTailLines int64 = 20
+
+// PodResource is the GroupVersionResource of pods.
+var PodResource = schema.GroupVersionResource{Version: "v1", Resource: "pods"}
+
+// failingWaitReasons are the container waiting reasons that mean a rollout
+// won't make progress without intervention.
+var failingWaitReasons = map[string]bool{
+	"ImagePullBackOff":           true,
+	"ErrImagePull":               true,
+	"CrashLoopBackOff":           true,
+	"CreateContainerConfigError": true,
+}
+
+// PodFailure describes a container that is stuck in a failing state.
+type PodFailure struct {
+	Pod       string
+	Container string
+	Reason    string
+	Message   string
+	// Logs holds the last lines of the container's previous run for
+	// CrashLoopBackOff containers.
+	Logs string
+}
+
+// RolloutFailedError is returned when a rollout is known not to complete, for
+// example because its pods can't pull their image or keep crashing.
+type RolloutFailedError struct {
+	Name   string
+	Reason string
+	Pods   []PodFailure
+}
+
+func (e *RolloutFailedError) Error() string {
+	pods := make([]string, 0, len(e.Pods))
+	for _, p := range e.Pods {
+		pods = append(pods, fmt.Sprintf("pod %s container %s: %s: %s", p.Pod, p.Container, p.Reason, p.Message))
+	}
+	msg := fmt.Sprintf("deployment %s rollout failed: %s", e.Name, e.Reason)
+	if len(pods) > 0 {
+		msg += "; " + strings.Join(pods, "; ")
+	}
+	return msg
+}
+
+// podFailures lists the API pods and returns every container waiting with
+// one of failingWaitReasons.
+func (d *Deployer) podFailures(ctx context.Context) ([]PodFailure, error) {
+	pods, err := d.apiPods(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var failures []PodFailure
+	for _, pod := range pods {
+		statuses := append(pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses...)
+		for _, cs := range statuses {
+			if cs.State.Waiting == nil || !failingWaitReasons[cs.State.Waiting.Reason] {
+				continue
+			}
+			failure := PodFailure{
+				Pod:       pod.Name,
+				Container: cs.Name,
+				Reason:    cs.State.Waiting.Reason,
+				Message:   cs.State.Waiting.Message,
+			}
+			if failure.Reason == "CrashLoopBackOff" {
+				failure.Logs = d.previousLogs(ctx, pod.Name, cs.Name)
+			}
+			failures = append(failures, failure)
+		}
+	}
+	return failures, nil
+}
+
+// notReadyContainers returns pod/container for each container of the API
+// pods that isn't ready, or for an init container that hasn't completed.
+// The pods are listed on a best-effort basis, for the timeout error.
+func (d *Deployer) notReadyContainers(ctx context.Context) []string {
+	pods, err := d.apiPods(ctx)
+	if err != nil {
+		return nil
+	}
+	var notReady []string
+	for _, pod := range pods {
+		for _, cs := range pod.Status.InitContainerStatuses {
+			if cs.State.Terminated == nil || cs.State.Terminated.ExitCode != 0 {
+				notReady = append(notReady, pod.Name+"/"+cs.Name)
+			}
+		}
+		for _, cs := range pod.Status.ContainerStatuses {
+			if !cs.Ready {
+				notReady = append(notReady, pod.Name+"/"+cs.Name)
+			}
+		}
+	}
+	return notReady
+}
+
+// apiPods lists the pods selected by the API deployment, or none when the
+// manifests hold no Deployment.
+func (d *Deployer) apiPods(ctx context.Context) ([]corev1.Pod, error) {
+	dep := apiDeployment(d.opts)
+	if dep == nil {
+		return nil, nil
+	}
+	return d.selectedPods(ctx, dep)
+}
+
+// selectedPods lists the pods matched by the selector of deployment dep.
+func (d *Deployer) selectedPods(ctx context.Context, dep *unstructured.Unstructured) ([]corev1.Pod, error) {
+	selector, _, _ := unstructured.NestedStringMap(dep.Object, "spec", "selector", "matchLabels")
+	list, err := d.resource(PodResource).List(ctx, metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(selector).String(),
+	})
+	if err != nil {
+		return nil, d.opError(OpList, PodResource, "", err)
+	}
+	pods := make([]corev1.Pod, 0, len(list.Items))
+	for _, item := range list.Items {
+		var pod corev1.Pod
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(item.Object, &pod); err != nil {
+			return nil, fmt.Errorf("failed to decode pod %s: %w", item.GetName(), err)
+		}
+		pods = append(pods, pod)
+	}
+	return pods, nil
+}
+
+// previousLogs returns the tail of the last terminated run of a container.
+// Logs are best effort: without a clientset, or if the request fails, the
+// failure is still reported, just without them.
+func (d *Deployer) previousLogs(ctx context.Context, pod, container string) string {
+	if d.kube == nil {
+		return ""
+	}
+	tail := podLogTailLines
+	var data []byte
+	err := d.retry.do(ctx, OpGet, PodResource, pod, func() (err error) {
+		data, err = d.kube.CoreV1().Pods(d.opts.Namespace).GetLogs(pod, &corev1.PodLogOptions{
+			Container: container,
+			Previous:  true,
+			TailLines: &tail,
+		}).DoRaw(ctx)
+		return err
+	})
+	if err != nil {
+		return fmt.Sprintf("(logs unavailable: %v)", err)
+	}
+	return string(data)
+}
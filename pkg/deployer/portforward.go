@@ -0,0 +1,212 @@
+package deployer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/portforward"
+	"k8s.io/client-go/transport/spdy"
+	"net/http"
+	"time"
+)
+
+// portForwardPollInterval is how often PortForward checks the forwarded pod
+// is still ready, and looks for a ready pod when there is none.
+const portForwardPollInterval = 2 * time.Second
+
+// errPortForwardNoConfig is returned by PortForward on a Deployer not built
+// by NewForConfig, which has no rest config to dial pods with.
+var errPortForwardNoConfig = errors.New("port forwarding needs a rest config; build the Deployer with NewForConfig")
+
+// PortForwardOptions configures PortForward.
+type PortForwardOptions struct {
+	// LocalPort is the port listened on at localhost.
+	LocalPort int
+	// PodPort is the pod port forwarded to. It defaults to the targetPort
+	// of the API service.
+	PodPort int
+	// Ready, when set, is called with the local URL and the pod each time
+	// forwarding to a pod starts.
+	Ready func(url, pod string)
+}
+
+// PortForward forwards localhost:LocalPort to a ready API pod until ctx is
+// done, like kubectl port-forward. When the pod goes away or stops being
+// ready, forwarding moves to another ready pod.
+func (d *Deployer) PortForward(ctx context.Context, opts PortForwardOptions) error {
+	return d.portForward(ctx, opts, d.apiPods)
+}
+
+// portForward forwards as PortForward does to a ready pod of those list
+// returns.
+func (d *Deployer) portForward(ctx context.Context, opts PortForwardOptions, list func(context.Context) ([]corev1.Pod, error)) error {
+	if d.config == nil || d.kube == nil {
+		return errPortForwardNoConfig
+	}
+	if opts.LocalPort < 0 || opts.LocalPort > 65535 {
+		return &ValidationError{Field: "local-port", Err: fmt.Errorf("must be between 0 and 65535, got %d", opts.LocalPort)}
+	}
+	if opts.PodPort < 0 || opts.PodPort > 65535 {
+		return &ValidationError{Field: "pod-port", Err: fmt.Errorf("must be between 0 and 65535, got %d", opts.PodPort)}
+	}
+	transport, upgrader, err := spdy.RoundTripperFor(d.config)
+	if err != nil {
+		return fmt.Errorf("failed to build the port-forward transport: %w", err)
+	}
+
+	for ctx.Err() == nil {
+		var pod *corev1.Pod
+		err := wait.PollImmediateUntilWithContext(ctx, portForwardPollInterval, func(ctx context.Context) (bool, error) {
+			pods, err := list(ctx)
+			if err != nil {
+				return false, err
+			}
+			pod = readyPod(pods)
+			return pod != nil, nil
+		})
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		port := opts.PodPort
+		if port == 0 {
+			if port, err = d.servicePodPort(pod); err != nil {
+				return err
+			}
+		}
+		if err := d.forwardTo(ctx, transport, upgrader, pod, opts, port); err != nil {
+			return err
+		}
+		if ctx.Err() == nil {
+			d.log.Info("Forwarded pod went away, moving to another one", "pod", pod.Name)
+		}
+	}
+	return nil
+}
+
+// forwardTo forwards to port of pod until ctx is done or the pod is no
+// longer ready.
+func (d *Deployer) forwardTo(ctx context.Context, transport http.RoundTripper, upgrader spdy.Upgrader, pod *corev1.Pod, opts PortForwardOptions, port int) error {
+	req := d.kube.CoreV1().RESTClient().Post().
+		Resource("pods").Namespace(pod.Namespace).Name(pod.Name).SubResource("portforward")
+	dialer := spdy.NewDialer(upgrader, &http.Client{Transport: transport}, http.MethodPost, req.URL())
+
+	stop := make(chan struct{})
+	ready := make(chan struct{})
+	fw, err := portforward.NewOnAddresses(dialer, []string{"localhost"},
+		[]string{fmt.Sprintf("%d:%d", opts.LocalPort, port)}, stop, ready, io.Discard, io.Discard)
+	if err != nil {
+		return fmt.Errorf("failed to forward to pod %s: %w", pod.Name, err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- fw.ForwardPorts() }()
+
+	select {
+	case <-ready:
+	case err := <-done:
+		if err == nil {
+			return nil
+		}
+		return d.opError(OpGet, PodResource, pod.Name, fmt.Errorf("failed to forward port %d: %w", port, err))
+	case <-ctx.Done():
+		close(stop)
+		return <-done
+	}
+	if opts.Ready != nil {
+		ports, err := fw.GetPorts()
+		if err == nil && len(ports) > 0 {
+			opts.Ready(fmt.Sprintf("http://localhost:%d", ports[0].Local), pod.Name)
+		}
+	}
+
+	ticker := time.NewTicker(portForwardPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			close(stop)
+			<-done
+			return nil
+		case <-done:
+			// The connection to the pod was lost.
+			return nil
+		case <-ticker.C:
+			var live *corev1.Pod
+			err := d.retry.do(ctx, OpGet, PodResource, pod.Name, func() (err error) {
+				live, err = d.kube.CoreV1().Pods(pod.Namespace).Get(ctx, pod.Name, metav1.GetOptions{})
+				return err
+			})
+			if err == nil && live.DeletionTimestamp == nil && podReady(*live) {
+				continue
+			}
+			close(stop)
+			<-done
+			return nil
+		}
+	}
+}
+
+// servicePodPort returns the pod port the API service targets, resolving a
+// named targetPort against the containers of pod.
+func (d *Deployer) servicePodPort(pod *corev1.Pod) (int, error) {
+	objects, err := d.objects()
+	if err != nil {
+		return 0, err
+	}
+	for _, o := range objects {
+		if o.obj.GetKind() != "Service" || o.obj.GetName() != d.opts.serviceName() {
+			continue
+		}
+		ports, _, _ := unstructured.NestedSlice(o.obj.Object, "spec", "ports")
+		if len(ports) == 0 {
+			break
+		}
+		port, _ := ports[0].(map[string]interface{})
+		switch target := port["targetPort"].(type) {
+		case int64:
+			return int(target), nil
+		case float64:
+			return int(target), nil
+		case string:
+			for _, c := range pod.Spec.Containers {
+				for _, cp := range c.Ports {
+					if cp.Name == target {
+						return int(cp.ContainerPort), nil
+					}
+				}
+			}
+			return 0, fmt.Errorf("pod %s has no port named %s", pod.Name, target)
+		}
+		if p, ok := port["port"].(int64); ok {
+			return int(p), nil
+		}
+	}
+	return 0, &ValidationError{Field: "pod-port", Err: fmt.Errorf("service %s isn't among the objects; pass -pod-port", d.opts.serviceName())}
+}
+
+// readyPod returns a ready pod of pods that isn't being deleted, or nil.
+func readyPod(pods []corev1.Pod) *corev1.Pod {
+	for i := range pods {
+		if pods[i].DeletionTimestamp == nil && podReady(pods[i]) {
+			return &pods[i]
+		}
+	}
+	return nil
+}
+
+// podReady reports whether pod has the Ready condition.
+func podReady(pod corev1.Pod) bool {
+	for _, c := range pod.Status.Conditions {
+		if c.Type == corev1.PodReady {
+			return c.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
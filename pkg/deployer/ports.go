@@ -0,0 +1,171 @@
+package deployer
+
+import (
+	"errors"
+	"fmt"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"strconv"
+	"strings"
+)
+
+// Port is a TCP port of the API container and the port the services expose
+// it on. The services target it, and the ingress paths reference it, by
+// name.
+type Port struct {
+	Name          string
+	ContainerPort int32
+	// ServicePort is the port of the services; zero means ContainerPort.
+	ServicePort int32
+}
+
+// DefaultPorts is the API port when Options.Ports is nil.
+var DefaultPorts = []Port{{Name: "http", ContainerPort: 8080, ServicePort: 8080}}
+
+// ParsePort parses the -port format, name=http,container=8080,service=80.
+// The service port defaults to the container port.
+func ParsePort(s string) (Port, error) {
+	fields, err := splitFields(s)
+	if err != nil {
+		return Port{}, err
+	}
+	var p Port
+	for _, f := range fields {
+		switch f.key {
+		case "name":
+			p.Name = f.value
+		case "container", "service":
+			n, err := strconv.ParseInt(f.value, 10, 32)
+			if err != nil {
+				return Port{}, fmt.Errorf("%q: %s must be a number, got %q", s, f.key, f.value)
+			}
+			if f.key == "container" {
+				p.ContainerPort = int32(n)
+			} else {
+				p.ServicePort = int32(n)
+			}
+		default:
+			return Port{}, fmt.Errorf("%q: unknown key %q, expected name, container or service", s, f.key)
+		}
+	}
+	return p, nil
+}
+
+// ports returns Options.Ports, or DefaultPorts when nil, with the service
+// ports filled in.
+func (o Options) ports() []Port {
+	if o.Ports == nil {
+		return DefaultPorts
+	}
+	ports := make([]Port, 0, len(o.Ports))
+	for _, p := range o.Ports {
+		if p.ServicePort == 0 {
+			p.ServicePort = p.ContainerPort
+		}
+		ports = append(ports, p)
+	}
+	return ports
+}
+
+// hasPort reports whether a port is called name.
+func (o Options) hasPort(name string) bool {
+	for _, p := range o.ports() {
+		if p.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// validatePorts checks each port has a unique valid name and unique valid
+// container and service port numbers.
+func (o Options) validatePorts() error {
+	if o.Ports != nil && len(o.Ports) == 0 {
+		return &ValidationError{Field: "port", Err: errors.New("at least one port is needed")}
+	}
+	names := map[string]bool{}
+	containerPorts, servicePorts := map[int32]bool{}, map[int32]bool{}
+	for _, p := range o.ports() {
+		if errs := validation.IsValidPortName(p.Name); len(errs) > 0 {
+			return &ValidationError{Field: "port", Err: fmt.Errorf("name %q: %s", p.Name, strings.Join(errs, "; "))}
+		}
+		if p.Name == metricsPortName {
+			return &ValidationError{Field: "port", Err: fmt.Errorf("%s is reserved for the -metrics-port", metricsPortName)}
+		}
+		if names[p.Name] {
+			return &ValidationError{Field: "port", Err: fmt.Errorf("%s is declared twice", p.Name)}
+		}
+		names[p.Name] = true
+		for _, n := range []int32{p.ContainerPort, p.ServicePort} {
+			if n < 1 || n > 65535 {
+				return &ValidationError{Field: "port", Err: fmt.Errorf("%s: ports must be between 1 and 65535, got %d", p.Name, n)}
+			}
+		}
+		if containerPorts[p.ContainerPort] {
+			return &ValidationError{Field: "port", Err: fmt.Errorf("%s: container port %d is taken", p.Name, p.ContainerPort)}
+		}
+		if servicePorts[p.ServicePort] {
+			return &ValidationError{Field: "port", Err: fmt.Errorf("%s: service port %d is taken", p.Name, p.ServicePort)}
+		}
+		containerPorts[p.ContainerPort], servicePorts[p.ServicePort] = true, true
+	}
+	return nil
+}
+
+// wirePorts declares the ports of the API container.
+func wirePorts(dep *appsv1.Deployment, opts Options) {
+	c := apiContainer(&dep.Spec.Template.Spec)
+	c.Ports = nil
+	for _, p := range opts.ports() {
+		c.Ports = append(c.Ports, corev1.ContainerPort{Name: p.Name, Protocol: corev1.ProtocolTCP, ContainerPort: p.ContainerPort})
+	}
+}
+
+// servicePorts returns the ports of a service in front of the API pods,
+// each targeting the container port of its name. The first gets nodePort.
+func servicePorts(opts Options, nodePort int32) []corev1.ServicePort {
+	var ports []corev1.ServicePort
+	for i, p := range opts.ports() {
+		port := corev1.ServicePort{
+			Name:       p.Name,
+			Protocol:   corev1.ProtocolTCP,
+			TargetPort: intstr.FromString(p.Name),
+			Port:       p.ServicePort,
+		}
+		if i == 0 {
+			port.NodePort = nodePort
+		}
+		ports = append(ports, port)
+	}
+	return ports
+}
+
+// setServicePorts repeats the first port of the service obj rendered from
+// an embedded manifest for each of the ports.
+func setServicePorts(obj *unstructured.Unstructured, opts Options) {
+	template, _, _ := unstructured.NestedSlice(obj.Object, "spec", "ports")
+	ports := make([]interface{}, 0, len(opts.ports()))
+	for _, p := range opts.ports() {
+		port := runtime.DeepCopyJSONValue(template[0]).(map[string]interface{})
+		port["name"] = p.Name
+		port["targetPort"] = p.Name
+		port["port"] = int64(p.ServicePort)
+		ports = append(ports, port)
+	}
+	_ = unstructured.SetNestedSlice(obj.Object, ports, "spec", "ports")
+}
+
+// apiPolicyPorts returns the container ports of the API as the ports of
+// the network policy's ingress rule.
+func apiPolicyPorts(opts Options) []networkingv1.NetworkPolicyPort {
+	ports := make([]networkingv1.NetworkPolicyPort, 0, len(opts.ports()))
+	for _, p := range opts.ports() {
+		ports = append(ports, policyPort(corev1.ProtocolTCP, int(p.ContainerPort)))
+	}
+	return ports
+}
@@ -0,0 +1,307 @@
+package deployer
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+const (
+	// DefaultPostgresTag is the postgres image tag used when
+	// Options.PostgresTag is empty.
+	DefaultPostgresTag = "14"
+	// DefaultPostgresStorage is the volume size used when
+	// Options.PostgresStorage is empty.
+	DefaultPostgresStorage = "1Gi"
+
+	// postgresContainer names the database container and its port.
+	postgresContainer = "postgres"
+	// postgresDatabase is both the database and the user the API connects as.
+	postgresDatabase = "ecommerce"
+)
+
+// StatefulSetResource is the GroupVersionResource of stateful sets.
+var StatefulSetResource = schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "statefulsets"}
+
+// postgresEnv maps the variables the API reads to the keys of the postgres
+// secret holding them.
+var postgresEnv = []struct{ name, key string }{
+	{"DB_USER", "POSTGRES_USER"},
+	{"DB_PASSWORD", "POSTGRES_PASSWORD"},
+	{"DB_NAME", "POSTGRES_DB"},
+}
+
+// validatePostgres checks the postgres image tag and storage size.
+func (o Options) validatePostgres() error {
+	if !o.Postgres {
+		return nil
+	}
+	if _, err := ParseImageReference(o.postgresImage()); err != nil {
+		return &ValidationError{Field: "postgres-tag", Err: err}
+	}
+	if _, err := resource.ParseQuantity(o.PostgresStorage); err != nil {
+		return &ValidationError{Field: "postgres-storage", Err: fmt.Errorf("%q: %w", o.PostgresStorage, err)}
+	}
+	return nil
+}
+
+func (o Options) postgresImage() string {
+	return "postgres:" + o.PostgresTag
+}
+
+// generatePassword returns a random password for the database.
+func generatePassword() string {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("failed to generate a database password: %v", err))
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func (o Options) postgresCredentials() map[string]string {
+	return map[string]string{
+		"POSTGRES_USER":     postgresDatabase,
+		"POSTGRES_PASSWORD": o.postgresPassword,
+		"POSTGRES_DB":       postgresDatabase,
+	}
+}
+
+// newPostgresSecret returns the secret holding the database credentials.
+func newPostgresSecret(opts Options) *unstructured.Unstructured {
+	data := map[string]interface{}{}
+	for k, v := range opts.postgresCredentials() {
+		data[k] = base64.StdEncoding.EncodeToString([]byte(v))
+	}
+	return &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "Secret",
+			"metadata": map[string]interface{}{
+				"name":      opts.postgresSecretName(),
+				"namespace": opts.Namespace,
+				"labels":    map[string]interface{}{ManagedByLabel: ManagedBy},
+			},
+			"type": string(corev1.SecretTypeOpaque),
+			"data": data,
+		},
+	}
+}
+
+// BuildPostgresSecret returns the secret holding the database credentials.
+// The password is generated once per Deployer and kept from the live secret
+// on later runs.
+func BuildPostgresSecret(opts Options) *corev1.Secret {
+	opts = opts.withDefaults()
+	data := map[string][]byte{}
+	for k, v := range opts.postgresCredentials() {
+		data[k] = []byte(v)
+	}
+	return &corev1.Secret{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      opts.postgresSecretName(),
+			Namespace: opts.Namespace,
+			Labels:    map[string]string{ManagedByLabel: ManagedBy},
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: data,
+	}
+}
+
+// newPostgresService returns the headless service giving the database pod a
+// stable DNS name.
+func newPostgresService(opts Options) *unstructured.Unstructured {
+	obj := defaultManifest("postgres-service.yaml")
+	obj.SetName(opts.postgresName())
+	obj.SetNamespace(opts.Namespace)
+	_ = unstructured.SetNestedStringMap(obj.Object, opts.selector(componentDatabase), "spec", "selector")
+	return obj
+}
+
+// BuildPostgresService returns the headless service giving the database pod
+// a stable DNS name.
+func BuildPostgresService(opts Options) *corev1.Service {
+	opts = opts.withDefaults()
+	return &corev1.Service{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Service"},
+		ObjectMeta: metav1.ObjectMeta{Name: opts.postgresName(), Namespace: opts.Namespace},
+		Spec: corev1.ServiceSpec{
+			ClusterIP: corev1.ClusterIPNone,
+			Selector:  opts.selector(componentDatabase),
+			Ports: []corev1.ServicePort{{
+				Name:       postgresContainer,
+				Protocol:   corev1.ProtocolTCP,
+				Port:       5432,
+				TargetPort: intstr.FromInt(5432),
+			}},
+		},
+	}
+}
+
+// newPostgresStatefulSet renders the embedded postgres StatefulSet.
+func newPostgresStatefulSet(opts Options) *unstructured.Unstructured {
+	var sts appsv1.StatefulSet
+	decodeDefaultManifest("postgres-statefulset.yaml", &sts)
+	customizePostgres(&sts, opts)
+	return mustToUnstructured(&sts)
+}
+
+// BuildPostgresStatefulSet returns the single-replica postgres StatefulSet
+// storing its data on a volume claimed per pod.
+func BuildPostgresStatefulSet(opts Options) *appsv1.StatefulSet {
+	opts = opts.withDefaults()
+	replicas := int32(1)
+	sts := &appsv1.StatefulSet{
+		TypeMeta: metav1.TypeMeta{APIVersion: "apps/v1", Kind: "StatefulSet"},
+		Spec: appsv1.StatefulSetSpec{
+			Replicas: &replicas,
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{
+						Name: postgresContainer,
+						Ports: []corev1.ContainerPort{{
+							Name:          postgresContainer,
+							Protocol:      corev1.ProtocolTCP,
+							ContainerPort: 5432,
+						}},
+						Env: []corev1.EnvVar{{Name: "PGDATA", Value: "/var/lib/postgresql/data/pgdata"}},
+						ReadinessProbe: &corev1.Probe{
+							Handler: corev1.Handler{
+								Exec: &corev1.ExecAction{Command: []string{"sh", "-c", `pg_isready -U "$POSTGRES_USER" -d "$POSTGRES_DB"`}},
+							},
+							PeriodSeconds: 5,
+						},
+						VolumeMounts: []corev1.VolumeMount{{Name: "data", MountPath: "/var/lib/postgresql/data"}},
+					}},
+				},
+			},
+			VolumeClaimTemplates: []corev1.PersistentVolumeClaim{{
+				ObjectMeta: metav1.ObjectMeta{Name: "data"},
+				Spec: corev1.PersistentVolumeClaimSpec{
+					AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+				},
+			}},
+		},
+	}
+	customizePostgres(sts, opts)
+	return sts
+}
+
+// customizePostgres applies opts to the postgres StatefulSet.
+func customizePostgres(sts *appsv1.StatefulSet, opts Options) {
+	sts.Name = opts.postgresName()
+	sts.Namespace = opts.Namespace
+	sts.Spec.ServiceName = opts.postgresName()
+	sts.Spec.Selector = &metav1.LabelSelector{MatchLabels: opts.selector(componentDatabase)}
+	sts.Spec.Template.Labels = opts.selector(componentDatabase)
+	hardenDataPod(&sts.Spec.Template.Spec, opts)
+	placePod(&sts.Spec.Template.Spec, opts)
+	c := &sts.Spec.Template.Spec.Containers[0]
+	c.Image = opts.postgresImage()
+	c.EnvFrom = []corev1.EnvFromSource{{
+		SecretRef: &corev1.SecretEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: opts.postgresSecretName()}},
+	}}
+
+	claim := &sts.Spec.VolumeClaimTemplates[0].Spec
+	claim.Resources.Requests = corev1.ResourceList{corev1.ResourceStorage: resource.MustParse(opts.PostgresStorage)}
+	if opts.PostgresStorageClass != "" {
+		claim.StorageClassName = &opts.PostgresStorageClass
+	}
+}
+
+// wirePostgres points the API container at the database.
+func wirePostgres(dep *appsv1.Deployment, opts Options) {
+	if !opts.Postgres {
+		return
+	}
+	c := apiContainer(&dep.Spec.Template.Spec)
+	c.Env = append(c.Env, corev1.EnvVar{Name: "DB_HOST", Value: opts.postgresName()})
+	for _, e := range postgresEnv {
+		c.Env = append(c.Env, corev1.EnvVar{
+			Name: e.name,
+			ValueFrom: &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{
+				LocalObjectReference: corev1.LocalObjectReference{Name: opts.postgresSecretName()},
+				Key:                  e.key,
+			}},
+		})
+	}
+}
+
+// keepLivePassword keeps the password under key of the existing secret obj:
+// the database only takes its credentials when it is first initialized, and
+// pods already running hold the cache password they started with.
+func (d *Deployer) keepLivePassword(ctx context.Context, obj *unstructured.Unstructured, key string) (*unstructured.Unstructured, error) {
+	live, err := d.resource(SecretResource).Get(ctx, obj.GetName(), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return obj, nil
+	}
+	if err != nil {
+		return nil, d.opError(OpGet, SecretResource, obj.GetName(), err)
+	}
+	data, _, _ := unstructured.NestedStringMap(live.Object, "data")
+	if data[key] == "" {
+		return obj, nil
+	}
+	obj = obj.DeepCopy()
+	_ = unstructured.SetNestedField(obj.Object, data[key], "data", key)
+	return obj, nil
+}
+
+// waitForPostgres polls the postgres StatefulSet until its pod is updated and
+// Ready. It returns nil right away when Options.Postgres is off.
+func (d *Deployer) waitForPostgres(ctx context.Context) error {
+	if !d.opts.Postgres || len(d.opts.Manifests) > 0 {
+		return nil
+	}
+	d.log.Info("Waiting for the database", "namespace", d.opts.Namespace, "statefulset", d.opts.postgresName())
+	reason := "not created yet"
+	err := wait.PollImmediateUntilWithContext(ctx, rolloutPollInterval, func(ctx context.Context) (bool, error) {
+		sts, err := d.resource(StatefulSetResource).Get(ctx, d.opts.postgresName(), metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return false, nil
+		}
+		if err != nil {
+			return false, d.opError(OpGet, StatefulSetResource, d.opts.postgresName(), err)
+		}
+		var ready bool
+		ready, reason = statefulSetReady(sts)
+		d.log.V(1).Info("Database progress", "namespace", d.opts.Namespace, "statefulset", d.opts.postgresName(), "ready", ready, "reason", reason)
+		return ready, nil
+	})
+	if err != nil {
+		return d.opError(OpWait, StatefulSetResource, d.opts.postgresName(), fmt.Errorf("readiness (%s): %w", reason, err))
+	}
+	return nil
+}
+
+// statefulSetReady reports whether every replica of sts runs the latest
+// revision and is ready, along with what it is still waiting for.
+func statefulSetReady(sts *unstructured.Unstructured) (bool, string) {
+	desired, found, _ := unstructured.NestedInt64(sts.Object, "spec", "replicas")
+	if !found {
+		desired = 1
+	}
+	observed, _, _ := unstructured.NestedInt64(sts.Object, "status", "observedGeneration")
+	ready, _, _ := unstructured.NestedInt64(sts.Object, "status", "readyReplicas")
+	current, _, _ := unstructured.NestedString(sts.Object, "status", "currentRevision")
+	update, _, _ := unstructured.NestedString(sts.Object, "status", "updateRevision")
+	switch {
+	case sts.GetGeneration() > observed:
+		return false, "spec update not observed yet"
+	case ready < desired:
+		return false, fmt.Sprintf("%d/%d replicas ready", ready, desired)
+	case current != update:
+		return false, "revision " + update + " still rolling out"
+	}
+	return true, ""
+}
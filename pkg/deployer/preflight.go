@@ -0,0 +1,124 @@
+package deployer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sort"
+	"strings"
+)
+
+// SelfSubjectAccessReviewResource is the resource Preflight asks the
+// apiserver about permissions through.
+var SelfSubjectAccessReviewResource = schema.GroupVersionResource{Group: "authorization.k8s.io", Version: "v1", Resource: "selfsubjectaccessreviews"}
+
+// preflightVerbs are the verbs a deploy sends for each object: get to read
+// the live state, create and patch to apply it server-side, and delete to
+// recreate objects whose immutable fields changed.
+var preflightVerbs = []string{"get", "create", "patch", "delete"}
+
+// Permission is a verb on a resource, in Namespace or cluster-wide when
+// that is empty.
+type Permission struct {
+	Verb      string
+	Resource  schema.GroupResource
+	Namespace string
+}
+
+func (p Permission) String() string {
+	if p.Namespace == "" {
+		return p.Verb + " " + p.Resource.String()
+	}
+	return p.Verb + " " + p.Resource.String() + " in namespace " + p.Namespace
+}
+
+// PermissionError is returned by Preflight when the user lacks permissions
+// the deploy needs.
+type PermissionError struct {
+	Missing []Permission
+}
+
+func (e *PermissionError) Error() string {
+	missing := make([]string, len(e.Missing))
+	for i, p := range e.Missing {
+		missing[i] = p.String()
+	}
+	return "missing permissions: " + strings.Join(missing, ", ")
+}
+
+// Preflight checks with a SelfSubjectAccessReview per verb and resource that
+// the user may send every request DeployAll does, so a missing permission
+// fails the deploy before anything is applied rather than halfway. Every
+// missing permission is listed in a *PermissionError. It needs a clientset;
+// see NewWithClientset.
+func (d *Deployer) Preflight(ctx context.Context) error {
+	if d.kube == nil {
+		return errors.New("the preflight checks need a clientset")
+	}
+	if err := d.opts.Validate(); err != nil {
+		return err
+	}
+	objects, err := d.objects()
+	if err != nil {
+		return err
+	}
+
+	var needed []Permission
+	seen := map[Permission]bool{}
+	add := func(p Permission) {
+		if !seen[p] {
+			seen[p] = true
+			needed = append(needed, p)
+		}
+	}
+	if d.opts.CreateNamespace {
+		add(Permission{Verb: "create", Resource: NamespaceResource.GroupResource()})
+	}
+	for _, o := range objects {
+		for _, verb := range preflightVerbs {
+			add(Permission{Verb: verb, Resource: o.gvr.GroupResource(), Namespace: o.obj.GetNamespace()})
+		}
+	}
+	if d.opts.Migration != nil {
+		// The migration Job is created, watched and pruned by listing.
+		for _, verb := range []string{"create", "get", "list", "delete"} {
+			add(Permission{Verb: verb, Resource: JobResource.GroupResource(), Namespace: d.opts.Namespace})
+		}
+	}
+
+	var missing []Permission
+	for _, p := range needed {
+		review := &authorizationv1.SelfSubjectAccessReview{
+			Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+				ResourceAttributes: &authorizationv1.ResourceAttributes{
+					Namespace: p.Namespace,
+					Verb:      p.Verb,
+					Group:     p.Resource.Group,
+					Resource:  p.Resource.Resource,
+				},
+			},
+		}
+		err := d.retry.do(ctx, OpCreate, SelfSubjectAccessReviewResource, "", func() (err error) {
+			review, err = d.kube.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, review, metav1.CreateOptions{})
+			return err
+		})
+		if err != nil {
+			// Some clusters restrict the review API itself.
+			return &DeployError{GVR: SelfSubjectAccessReviewResource, Op: OpCreate, Err: fmt.Errorf("%w; pass -skip-preflight to deploy without the checks", err)}
+		}
+		if !review.Status.Allowed {
+			d.log.V(1).Info("Permission denied", "permission", p.String(), "reason", review.Status.Reason)
+			missing = append(missing, p)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	sort.SliceStable(missing, func(i, j int) bool {
+		return missing[i].Resource.String() < missing[j].Resource.String()
+	})
+	return &PermissionError{Missing: missing}
+}
@@ -0,0 +1,60 @@
+package deployer
+
+import (
+	"errors"
+	"fmt"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"strings"
+)
+
+const (
+	// DefaultHealthPath is the path the HTTP probes request when
+	// Options.HealthPath is empty.
+	DefaultHealthPath = "/healthz"
+	// ProbeHTTP and ProbeTCP are the values of Options.ProbeType.
+	ProbeHTTP = "http"
+	ProbeTCP  = "tcp"
+)
+
+// validateProbes checks the probe type, the health path and the startup
+// failure threshold.
+func (o Options) validateProbes() error {
+	switch o.ProbeType {
+	case ProbeHTTP:
+		if !strings.HasPrefix(o.HealthPath, "/") {
+			return &ValidationError{Field: "health-path", Err: fmt.Errorf("%q must start with /", o.HealthPath)}
+		}
+	case ProbeTCP:
+	default:
+		return &ValidationError{Field: "probe-type", Err: fmt.Errorf("must be %s or %s, got %q", ProbeHTTP, ProbeTCP, o.ProbeType)}
+	}
+	if o.StartupProbeFailureThreshold < 0 {
+		return &ValidationError{Field: "startup-probe-failure-threshold", Err: errors.New("must not be negative")}
+	}
+	return nil
+}
+
+// probeHandler checks the first API port, with an HTTP GET of the health
+// path or a TCP connect.
+func (o Options) probeHandler() corev1.Handler {
+	port := intstr.FromString(o.ports()[0].Name)
+	if o.ProbeType == ProbeTCP {
+		return corev1.Handler{TCPSocket: &corev1.TCPSocketAction{Port: port}}
+	}
+	return corev1.Handler{HTTPGet: &corev1.HTTPGetAction{Path: o.HealthPath, Port: port}}
+}
+
+// wireProbes adds readiness and liveness probes to the API container, and a
+// startup probe holding off the liveness probe for slow boots when
+// Options.StartupProbeFailureThreshold is set. The readiness probe is what
+// makes WaitForRollout wait for the application rather than the process.
+func wireProbes(dep *appsv1.Deployment, opts Options) {
+	c := apiContainer(&dep.Spec.Template.Spec)
+	c.ReadinessProbe = &corev1.Probe{Handler: opts.probeHandler(), PeriodSeconds: 5}
+	c.LivenessProbe = &corev1.Probe{Handler: opts.probeHandler(), PeriodSeconds: 10, FailureThreshold: 3}
+	if opts.StartupProbeFailureThreshold > 0 {
+		c.StartupProbe = &corev1.Probe{Handler: opts.probeHandler(), PeriodSeconds: 10, FailureThreshold: opts.StartupProbeFailureThreshold}
+	}
+}
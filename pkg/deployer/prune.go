@@ -0,0 +1,152 @@
+package deployer
+
+import (
+	"context"
+	"fmt"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"strings"
+)
+
+// DefaultPruneResources are the resources Prune looks through by default, in
+// creation order: those of the built-in objects but persistent volume
+// claims, whose data outlives the flags that created them, and namespaces.
+var DefaultPruneResources = []schema.GroupResource{
+	{Resource: "configmaps"},
+	{Resource: "secrets"},
+	{Resource: "serviceaccounts"},
+	{Group: "rbac.authorization.k8s.io", Resource: "roles"},
+	{Group: "rbac.authorization.k8s.io", Resource: "rolebindings"},
+	{Group: "apps", Resource: "statefulsets"},
+	{Group: "apps", Resource: "deployments"},
+	{Group: "batch", Resource: "cronjobs"},
+	{Resource: "services"},
+	{Group: "networking.k8s.io", Resource: "ingresses"},
+	{Group: HTTPRouteResource.Group, Resource: HTTPRouteResource.Resource},
+	{Group: OpenShiftRouteResource.Group, Resource: OpenShiftRouteResource.Resource},
+	{Group: "networking.k8s.io", Resource: "networkpolicies"},
+	{Group: "autoscaling", Resource: "horizontalpodautoscalers"},
+	{Group: "policy", Resource: "poddisruptionbudgets"},
+}
+
+// ParsePruneResource parses a resource as resource[.group], e.g. "services"
+// or "deployments.apps".
+func ParsePruneResource(s string) (schema.GroupResource, error) {
+	if s == "" || strings.ContainsAny(s, "/ ") {
+		return schema.GroupResource{}, fmt.Errorf("%q is not a resource[.group], e.g. deployments.apps", s)
+	}
+	return schema.ParseGroupResource(strings.ToLower(s)), nil
+}
+
+// Prune deletes the objects of the release that are no longer desired, such
+// as nodeport-svc once ExposeNodePort is turned off, and reports them as
+// DeleteAll does. It lists resources, DefaultPruneResources when empty, for
+// objects labelled with ManagedByLabel and the InstanceLabel of the release,
+// so objects lacking either are never pruned, nor those a controller other
+// than the EcommerceApp owns, such as ReplicaSets. With Options.DryRun the
+// deletions are only sent as dry runs, reporting what would be pruned.
+// Resources the cluster doesn't serve are skipped silently. The default
+// resources are followed by those of Options.ExtraManifests, cluster-scoped
+// ones included.
+func (d *Deployer) Prune(ctx context.Context, resources []schema.GroupResource) []DeleteResult {
+	objects, err := d.objects()
+	if err != nil {
+		return []DeleteResult{{Outcome: Failed, Err: err}}
+	}
+	if len(resources) == 0 {
+		resources = extraPruneResources(objects)
+	}
+	desired := make(map[string]bool, len(objects))
+	for _, o := range objects {
+		desired[pruneKey(o.gvr.GroupResource(), o.obj.GetNamespace(), o.obj.GetName())] = true
+	}
+	selector := labels.SelectorFromSet(labels.Set{ManagedByLabel: ManagedBy, InstanceLabel: d.opts.release()}).String()
+
+	var results []DeleteResult
+	for i := len(resources) - 1; i >= 0; i-- {
+		gr := resources[i]
+		gvr, err := d.mapper.ResourceFor(gr.WithVersion(""))
+		if meta.IsNoMatchError(err) {
+			continue
+		}
+		if err != nil {
+			results = append(results, DeleteResult{Resource: gr.WithVersion(""), Outcome: Failed, Err: fmt.Errorf("failed to resolve %s: %w", gr, err)})
+			continue
+		}
+		client := d.resource(gvr)
+		if mapping, err := d.mapper.RESTMapping(schema.GroupKind{Group: gvr.Group, Kind: d.kindFor(gvr)}, gvr.Version); err == nil && mapping.Scope.Name() != meta.RESTScopeNameNamespace {
+			client = d.client.Resource(gvr)
+		}
+		list, err := client.List(ctx, metav1.ListOptions{LabelSelector: selector})
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			results = append(results, DeleteResult{Resource: gvr, Outcome: Failed, Err: d.opError(OpList, gvr, "", err)})
+			continue
+		}
+		for i := range list.Items {
+			obj := &list.Items[i]
+			if desired[pruneKey(gr, obj.GetNamespace(), obj.GetName())] || obj.GetDeletionTimestamp() != nil || !d.prunable(obj) {
+				continue
+			}
+			d.log.Info("Pruning", "resource", gvr.String(), "namespace", obj.GetNamespace(), "name", obj.GetName())
+			result := DeleteResult{Resource: gvr, Kind: obj.GetKind(), Name: obj.GetName()}
+			result.Outcome, result.Err = d.delete(ctx, client, gvr, obj.GetName(), DeleteOptions{})
+			results = append(results, result)
+		}
+	}
+	return results
+}
+
+// extraPruneResources returns DefaultPruneResources followed by the other
+// resources of the extra objects.
+func extraPruneResources(objects []object) []schema.GroupResource {
+	resources := append([]schema.GroupResource(nil), DefaultPruneResources...)
+	seen := make(map[schema.GroupResource]bool, len(resources))
+	for _, gr := range resources {
+		seen[gr] = true
+	}
+	for _, o := range objects {
+		if gr := o.gvr.GroupResource(); o.extra && !seen[gr] {
+			seen[gr] = true
+			resources = append(resources, gr)
+		}
+	}
+	return resources
+}
+
+// prunable reports whether obj, which carries the labels of the release, is
+// the release's own: objects a controller owns are left to it, unless it is
+// the EcommerceApp the release was deployed for. The canary and green
+// deployments, which carry TrackLabel, are left alone too.
+func (d *Deployer) prunable(obj *unstructured.Unstructured) bool {
+	if obj.GetLabels()[InstanceLabel] != d.opts.release() || obj.GetLabels()[ManagedByLabel] != ManagedBy {
+		return false
+	}
+	if obj.GetLabels()[TrackLabel] != "" {
+		// A canary is only removed by promoting or aborting it, and the
+		// green deployment by finishing or rolling back the blue-green
+		// deploy.
+		return false
+	}
+	ref := metav1.GetControllerOf(obj)
+	return ref == nil || d.opts.appOwner != nil && ref.UID == d.opts.appOwner.UID
+}
+
+// kindFor returns the kind served under gvr, or "" when it is unknown.
+func (d *Deployer) kindFor(gvr schema.GroupVersionResource) string {
+	gvk, err := d.mapper.KindFor(gvr)
+	if err != nil {
+		return ""
+	}
+	return gvk.Kind
+}
+
+func pruneKey(gr schema.GroupResource, namespace, name string) string {
+	return gr.String() + "/" + namespace + "/" + name
+}
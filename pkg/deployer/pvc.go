@@ -0,0 +1,168 @@
+package deployer
+
+import (
+	"errors"
+	"fmt"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"strings"
+)
+
+// PVCResource is the GroupVersionResource of persistent volume claims.
+var PVCResource = schema.GroupVersionResource{Version: "v1", Resource: "persistentvolumeclaims"}
+
+// PVC is a persistent volume claim mounted into the API container.
+type PVC struct {
+	// Name names the pod volume; the claim is called server-<Name>.
+	Name string
+	// Size is the requested storage, as a resource quantity such as 5Gi.
+	Size string
+	// MountPath is where the volume is mounted in the API container.
+	MountPath string
+	// StorageClass is the claim's storage class; empty leaves it to the
+	// cluster default.
+	StorageClass string
+}
+
+// ParsePVC parses the -pvc flag format,
+// "name=data,size=5Gi,mountPath=/var/lib/ecommerce,storageClass=standard".
+// The fields are checked by Options.Validate.
+func ParsePVC(s string) (PVC, error) {
+	var p PVC
+	for _, field := range strings.Split(s, ",") {
+		eq := strings.Index(field, "=")
+		if eq < 0 {
+			return PVC{}, fmt.Errorf("%q: %q must be in key=value form", s, field)
+		}
+		value := field[eq+1:]
+		switch key := field[:eq]; key {
+		case "name":
+			p.Name = value
+		case "size":
+			p.Size = value
+		case "mountPath":
+			p.MountPath = value
+		case "storageClass":
+			p.StorageClass = value
+		default:
+			return PVC{}, fmt.Errorf("%q: unknown key %q, expected name, size, mountPath or storageClass", s, key)
+		}
+	}
+	return p, nil
+}
+
+// validatePVCs checks each claim has a valid name and size and an absolute
+// mount path used by no other volume.
+func (o Options) validatePVCs() error {
+	names := map[string]bool{configVolumeName: o.ConfigMountPath != "", tmpVolumeName: o.readOnlyRoot()}
+	mountPaths := map[string]bool{o.ConfigMountPath: o.ConfigMountPath != "", tmpMountPath: o.readOnlyRoot()}
+	for _, p := range o.PVCs {
+		if errs := validation.IsDNS1123Label(p.Name); len(errs) > 0 {
+			return &ValidationError{Field: "pvc", Err: fmt.Errorf("name %q: %s", p.Name, strings.Join(errs, "; "))}
+		}
+		if names[p.Name] {
+			return &ValidationError{Field: "pvc", Err: fmt.Errorf("volume %q is defined twice", p.Name)}
+		}
+		names[p.Name] = true
+		if _, err := resource.ParseQuantity(p.Size); err != nil {
+			return &ValidationError{Field: "pvc", Err: fmt.Errorf("%s: size %q: %w", p.Name, p.Size, err)}
+		}
+		if !strings.HasPrefix(p.MountPath, "/") {
+			return &ValidationError{Field: "pvc", Err: fmt.Errorf("%s: mountPath %q must be an absolute path", p.Name, p.MountPath)}
+		}
+		if mountPaths[p.MountPath] {
+			return &ValidationError{Field: "pvc", Err: fmt.Errorf("%s: mountPath %s is already in use", p.Name, p.MountPath)}
+		}
+		mountPaths[p.MountPath] = true
+	}
+	return nil
+}
+
+// newPVC returns the persistent volume claim for p.
+func newPVC(opts Options, p PVC) *unstructured.Unstructured {
+	spec := map[string]interface{}{
+		"accessModes": []interface{}{string(corev1.ReadWriteOnce)},
+		"resources": map[string]interface{}{
+			"requests": map[string]interface{}{"storage": p.Size},
+		},
+	}
+	if p.StorageClass != "" {
+		spec["storageClassName"] = p.StorageClass
+	}
+	return &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "PersistentVolumeClaim",
+			"metadata": map[string]interface{}{
+				"name":      opts.claimName(p),
+				"namespace": opts.Namespace,
+			},
+			"spec": spec,
+		},
+	}
+}
+
+// BuildPVC returns the persistent volume claim for p.
+func BuildPVC(opts Options, p PVC) *corev1.PersistentVolumeClaim {
+	opts = opts.withDefaults()
+	var storageClass *string
+	if p.StorageClass != "" {
+		storageClass = &p.StorageClass
+	}
+	return &corev1.PersistentVolumeClaim{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "PersistentVolumeClaim"},
+		ObjectMeta: metav1.ObjectMeta{Name: opts.claimName(p), Namespace: opts.Namespace},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes:      []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+			Resources:        corev1.ResourceRequirements{Requests: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse(p.Size)}},
+			StorageClassName: storageClass,
+		},
+	}
+}
+
+// wirePVCs mounts each claim into the API container.
+func wirePVCs(dep *appsv1.Deployment, opts Options) {
+	spec := &dep.Spec.Template.Spec
+	for _, p := range opts.PVCs {
+		spec.Volumes = append(spec.Volumes, corev1.Volume{
+			Name:         p.Name,
+			VolumeSource: corev1.VolumeSource{PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: opts.claimName(p)}},
+		})
+		c := apiContainer(spec)
+		c.VolumeMounts = append(c.VolumeMounts, corev1.VolumeMount{Name: p.Name, MountPath: p.MountPath})
+	}
+}
+
+// ImmutableFieldError is returned when an apply changes a field the
+// apiserver won't change in place, such as the storage class or access
+// modes of a bound persistent volume claim.
+type ImmutableFieldError struct {
+	Kind string
+	Name string
+	Err  error
+}
+
+func (e *ImmutableFieldError) Error() string {
+	return fmt.Sprintf("%s %s can't be changed in place: only spec.resources.requests.storage may grow once it exists; "+
+		"keep its previous settings, or delete it, losing its data, and rerun", e.Kind, e.Name)
+}
+
+func (e *ImmutableFieldError) Unwrap() error { return e.Err }
+
+// immutablePVCError wraps the apiserver's rejection of a claim spec change.
+func immutablePVCError(obj *unstructured.Unstructured, err error) error {
+	var status apierrors.APIStatus
+	if obj.GetKind() != "PersistentVolumeClaim" || !apierrors.IsInvalid(err) || !errors.As(err, &status) {
+		return err
+	}
+	if !strings.Contains(status.Status().Message, "is immutable") {
+		return err
+	}
+	return &ImmutableFieldError{Kind: obj.GetKind(), Name: obj.GetName(), Err: err}
+}
@@ -0,0 +1,70 @@
+package deployer
+
+import (
+	"context"
+	"fmt"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"time"
+)
+
+// reconcileJitter spreads reconcile cycles by up to this fraction of the
+// interval, so agents started together don't hit the apiserver together.
+const reconcileJitter = 0.1
+
+// ReconcileResult is what a reconcile cycle found.
+type ReconcileResult struct {
+	// Changed lists the objects that drifted from their desired state, or
+	// were missing, as kind/name; they have been applied again.
+	Changed []string
+	// InSync lists the objects that already matched, as kind/name.
+	InSync []string
+}
+
+// Reconcile corrects drift from the desired state: it diffs every object
+// against the cluster and, when any differs or is missing, applies them all
+// again. Fields taken over by other field managers, for example by
+// kubectl scale, are forced back, since undoing manual changes is the point.
+func (d *Deployer) Reconcile(ctx context.Context) (*ReconcileResult, error) {
+	rec := *d
+	rec.opts.ForceConflicts = true
+
+	diffs, err := rec.Diff(ctx)
+	if err != nil {
+		return nil, err
+	}
+	result := &ReconcileResult{}
+	for _, od := range diffs {
+		ref := od.Kind + "/" + od.Name
+		if od.Diff == "" {
+			result.InSync = append(result.InSync, ref)
+			continue
+		}
+		result.Changed = append(result.Changed, ref)
+		d.log.V(2).Info("Drift detected", "kind", od.Kind, "namespace", d.opts.Namespace, "name", od.Name, "exists", od.Exists)
+	}
+	if len(result.Changed) == 0 {
+		return result, nil
+	}
+	if _, err := rec.DeployAll(ctx); err != nil {
+		return result, err
+	}
+	return result, nil
+}
+
+// RunReconciler calls Reconcile every interval, jittered, until ctx is done,
+// passing each cycle's outcome to report. Failed cycles are retried at the
+// next interval.
+func (d *Deployer) RunReconciler(ctx context.Context, interval time.Duration, report func(*ReconcileResult, error)) error {
+	if interval <= 0 {
+		return &ValidationError{Field: "reconcile-interval", Err: fmt.Errorf("must be positive, got %s", interval)}
+	}
+	d.log.Info("Reconciling", "namespace", d.opts.Namespace, "interval", interval.String())
+	wait.JitterUntilWithContext(ctx, func(ctx context.Context) {
+		result, err := d.Reconcile(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		report(result, err)
+	}, interval, reconcileJitter, true)
+	return nil
+}
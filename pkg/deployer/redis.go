@@ -0,0 +1,249 @@
+package deployer
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"regexp"
+	"strconv"
+)
+
+const (
+	// DefaultRedisTag is the redis image tag used when Options.RedisTag is
+	// empty.
+	DefaultRedisTag = "7"
+	// DefaultRedisMaxMemory is the memory limit of the cache used when
+	// Options.RedisMaxMemory is empty.
+	DefaultRedisMaxMemory = "256mb"
+
+	// redisContainer names the cache container and its port.
+	redisContainer = "redis"
+	redisPort      = 6379
+	// redisPasswordKey is the key of the password in the redis secret.
+	redisPasswordKey = "REDIS_PASSWORD"
+)
+
+// redisMemory matches the memory sizes redis accepts, such as 100mb or 1gb.
+var redisMemory = regexp.MustCompile(`^[0-9]+([kKmMgG][bB]?)?$`)
+
+// validateRedis checks the redis image tag and memory limit.
+func (o Options) validateRedis() error {
+	if !o.Redis {
+		if o.RedisAuth {
+			return &ValidationError{Field: "redis-auth", Err: fmt.Errorf("needs -with-redis")}
+		}
+		return nil
+	}
+	if _, err := ParseImageReference(o.redisImage()); err != nil {
+		return &ValidationError{Field: "redis-tag", Err: err}
+	}
+	if !redisMemory.MatchString(o.RedisMaxMemory) {
+		return &ValidationError{Field: "redis-maxmemory", Err: fmt.Errorf("must be a size such as 100mb or 1gb, got %q", o.RedisMaxMemory)}
+	}
+	return nil
+}
+
+func (o Options) redisImage() string {
+	return "redis:" + o.RedisTag
+}
+
+// newRedisSecret returns the secret holding the cache password, or nil
+// without Options.RedisAuth.
+func newRedisSecret(opts Options) *unstructured.Unstructured {
+	if !opts.RedisAuth {
+		return nil
+	}
+	return &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "Secret",
+			"metadata": map[string]interface{}{
+				"name":      opts.redisSecretName(),
+				"namespace": opts.Namespace,
+				"labels":    map[string]interface{}{ManagedByLabel: ManagedBy},
+			},
+			"type": string(corev1.SecretTypeOpaque),
+			"data": map[string]interface{}{
+				redisPasswordKey: base64.StdEncoding.EncodeToString([]byte(opts.redisPassword)),
+			},
+		},
+	}
+}
+
+// BuildRedisSecret returns the secret holding the cache password, or nil
+// without Options.RedisAuth. The password is generated once per Deployer
+// and kept from the live secret on later runs.
+func BuildRedisSecret(opts Options) *corev1.Secret {
+	opts = opts.withDefaults()
+	if !opts.RedisAuth {
+		return nil
+	}
+	return &corev1.Secret{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      opts.redisSecretName(),
+			Namespace: opts.Namespace,
+			Labels:    map[string]string{ManagedByLabel: ManagedBy},
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: map[string][]byte{redisPasswordKey: []byte(opts.redisPassword)},
+	}
+}
+
+// newRedisService returns the service in front of the cache pod.
+func newRedisService(opts Options) *unstructured.Unstructured {
+	obj := defaultManifest("redis-service.yaml")
+	obj.SetName(opts.redisName())
+	obj.SetNamespace(opts.Namespace)
+	_ = unstructured.SetNestedStringMap(obj.Object, opts.selector(componentCache), "spec", "selector")
+	return obj
+}
+
+// BuildRedisService returns the service in front of the cache pod.
+func BuildRedisService(opts Options) *corev1.Service {
+	opts = opts.withDefaults()
+	return &corev1.Service{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Service"},
+		ObjectMeta: metav1.ObjectMeta{Name: opts.redisName(), Namespace: opts.Namespace},
+		Spec: corev1.ServiceSpec{
+			Selector: opts.selector(componentCache),
+			Ports: []corev1.ServicePort{{
+				Name:       redisContainer,
+				Protocol:   corev1.ProtocolTCP,
+				Port:       redisPort,
+				TargetPort: intstr.FromInt(redisPort),
+			}},
+		},
+	}
+}
+
+// newRedisDeployment renders the embedded redis Deployment.
+func newRedisDeployment(opts Options) *unstructured.Unstructured {
+	var dep appsv1.Deployment
+	decodeDefaultManifest("redis-deployment.yaml", &dep)
+	customizeRedis(&dep, opts)
+	return mustToUnstructured(&dep)
+}
+
+// BuildRedisDeployment returns the single-replica redis Deployment. The
+// cache keeps nothing on disk, so it is recreated rather than rolled.
+func BuildRedisDeployment(opts Options) *appsv1.Deployment {
+	opts = opts.withDefaults()
+	replicas := int32(1)
+	dep := &appsv1.Deployment{
+		TypeMeta: metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Strategy: appsv1.DeploymentStrategy{Type: appsv1.RecreateDeploymentStrategyType},
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{
+						Name: redisContainer,
+						Ports: []corev1.ContainerPort{{
+							Name:          redisContainer,
+							Protocol:      corev1.ProtocolTCP,
+							ContainerPort: redisPort,
+						}},
+						ReadinessProbe: &corev1.Probe{
+							Handler: corev1.Handler{
+								Exec: &corev1.ExecAction{Command: []string{"sh", "-c", "redis-cli ping | grep -q PONG"}},
+							},
+							PeriodSeconds: 5,
+						},
+					}},
+				},
+			},
+		},
+	}
+	customizeRedis(dep, opts)
+	return dep
+}
+
+// customizeRedis applies opts to the redis Deployment. With a password,
+// redis-cli in the readiness probe reads it from REDISCLI_AUTH.
+func customizeRedis(dep *appsv1.Deployment, opts Options) {
+	dep.Name = opts.redisName()
+	dep.Namespace = opts.Namespace
+	dep.Spec.Selector = &metav1.LabelSelector{MatchLabels: opts.selector(componentCache)}
+	dep.Spec.Template.Labels = opts.selector(componentCache)
+	hardenDataPod(&dep.Spec.Template.Spec, opts)
+	placePod(&dep.Spec.Template.Spec, opts)
+	c := &dep.Spec.Template.Spec.Containers[0]
+	c.Image = opts.redisImage()
+	c.Args = []string{"redis-server", "--maxmemory", opts.RedisMaxMemory, "--maxmemory-policy", "allkeys-lru"}
+	if !opts.RedisAuth {
+		return
+	}
+	c.Args = append(c.Args, "--requirepass", "$("+redisPasswordKey+")")
+	for _, name := range []string{redisPasswordKey, "REDISCLI_AUTH"} {
+		c.Env = append(c.Env, opts.redisPasswordEnv(name))
+	}
+}
+
+// redisPasswordEnv returns the variable name set to the cache password.
+func (o Options) redisPasswordEnv(name string) corev1.EnvVar {
+	return corev1.EnvVar{
+		Name: name,
+		ValueFrom: &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{
+			LocalObjectReference: corev1.LocalObjectReference{Name: o.redisSecretName()},
+			Key:                  redisPasswordKey,
+		}},
+	}
+}
+
+// wireRedis points the API container at the cache.
+func wireRedis(dep *appsv1.Deployment, opts Options) {
+	if !opts.Redis {
+		return
+	}
+	c := apiContainer(&dep.Spec.Template.Spec)
+	c.Env = append(c.Env,
+		corev1.EnvVar{Name: "REDIS_HOST", Value: opts.redisName()},
+		corev1.EnvVar{Name: "REDIS_PORT", Value: strconv.Itoa(redisPort)})
+	if opts.RedisAuth {
+		c.Env = append(c.Env, opts.redisPasswordEnv(redisPasswordKey))
+	}
+}
+
+// desiredRedisDeployment clears the rollingUpdate the apiserver defaults
+// for a Deployment applied without a strategy, which Recreate doesn't
+// allow.
+func desiredRedisDeployment(obj *unstructured.Unstructured) *unstructured.Unstructured {
+	_ = unstructured.SetNestedField(obj.Object, nil, "spec", "strategy", "rollingUpdate")
+	return obj
+}
+
+// waitForRedis polls the redis Deployment until its pod is updated and
+// Ready. It returns nil right away when Options.Redis is off.
+func (d *Deployer) waitForRedis(ctx context.Context) error {
+	if !d.opts.Redis || len(d.opts.Manifests) > 0 {
+		return nil
+	}
+	name := d.opts.redisName()
+	d.log.Info("Waiting for the cache", "namespace", d.opts.Namespace, "deployment", name)
+	reason := "not created yet"
+	err := wait.PollImmediateUntilWithContext(ctx, rolloutPollInterval, func(ctx context.Context) (bool, error) {
+		dep, err := d.resource(DeploymentResource).Get(ctx, name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return false, nil
+		}
+		if err != nil {
+			return false, d.opError(OpGet, DeploymentResource, name, err)
+		}
+		status, done := rolloutProgress(dep)
+		reason = fmt.Sprintf("%d/%d replicas available, %d updated", status.Available, status.Desired, status.Updated)
+		d.log.V(1).Info("Cache progress", "namespace", d.opts.Namespace, "deployment", name, "ready", done, "reason", reason)
+		return done, nil
+	})
+	if err != nil {
+		return d.opError(OpWait, DeploymentResource, name, fmt.Errorf("readiness (%s): %w", reason, err))
+	}
+	return nil
+}
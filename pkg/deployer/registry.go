@@ -0,0 +1,128 @@
+package deployer
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"strings"
+)
+
+// RegistryAuth is a login to a private image registry.
+type RegistryAuth struct {
+	Server   string
+	Username string
+	Password string
+}
+
+// String keeps the password out of logs and error messages.
+func (a RegistryAuth) String() string {
+	return a.Username + ":" + Redacted + "@" + a.Server
+}
+
+// ParseRegistryAuth parses the -registry-auth flag format,
+// "user:password@registry". The password may contain colons and at signs;
+// the registry follows the last at sign. Errors never quote s, which holds
+// the password.
+func ParseRegistryAuth(s string) (RegistryAuth, error) {
+	at := strings.LastIndex(s, "@")
+	colon := strings.Index(s, ":")
+	if at < 0 || colon < 0 || colon > at {
+		return RegistryAuth{}, errors.New("must be in user:password@registry form")
+	}
+	a := RegistryAuth{Username: s[:colon], Password: s[colon+1 : at], Server: s[at+1:]}
+	if a.Username == "" || a.Password == "" || a.Server == "" {
+		return RegistryAuth{}, errors.New("user, password and registry must not be empty")
+	}
+	return a, nil
+}
+
+// validateImagePullSecrets checks the pull secret names are valid secret
+// names. RegistryAuth is checked by ParseRegistryAuth.
+func (o Options) validateImagePullSecrets() error {
+	for _, name := range o.ImagePullSecrets {
+		if errs := validation.IsDNS1123Subdomain(name); len(errs) > 0 {
+			return &ValidationError{Field: "image-pull-secret", Err: fmt.Errorf("%q: %s", name, strings.Join(errs, "; "))}
+		}
+	}
+	return nil
+}
+
+// dockerConfigJSON returns the .dockerconfigjson of the registry login, as
+// docker login writes it.
+func (a RegistryAuth) dockerConfigJSON() []byte {
+	type entry struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+		Auth     string `json:"auth"`
+	}
+	config := map[string]map[string]entry{"auths": {a.Server: {
+		Username: a.Username,
+		Password: a.Password,
+		Auth:     base64.StdEncoding.EncodeToString([]byte(a.Username + ":" + a.Password)),
+	}}}
+	data, err := json.Marshal(config)
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
+
+// newRegistrySecret returns the pull secret holding Options.RegistryAuth, or
+// nil when it isn't set.
+func newRegistrySecret(opts Options) *unstructured.Unstructured {
+	if opts.RegistryAuth == nil {
+		return nil
+	}
+	return &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "Secret",
+			"metadata": map[string]interface{}{
+				"name":      opts.registrySecretName(),
+				"namespace": opts.Namespace,
+				"labels":    map[string]interface{}{ManagedByLabel: ManagedBy},
+			},
+			"type": string(corev1.SecretTypeDockerConfigJson),
+			"data": map[string]interface{}{
+				corev1.DockerConfigJsonKey: base64.StdEncoding.EncodeToString(opts.RegistryAuth.dockerConfigJSON()),
+			},
+		},
+	}
+}
+
+// BuildRegistrySecret returns the pull secret holding Options.RegistryAuth,
+// or nil when it isn't set.
+func BuildRegistrySecret(opts Options) *corev1.Secret {
+	opts = opts.withDefaults()
+	if opts.RegistryAuth == nil {
+		return nil
+	}
+	return &corev1.Secret{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      opts.registrySecretName(),
+			Namespace: opts.Namespace,
+			Labels:    map[string]string{ManagedByLabel: ManagedBy},
+		},
+		Type: corev1.SecretTypeDockerConfigJson,
+		Data: map[string][]byte{corev1.DockerConfigJsonKey: opts.RegistryAuth.dockerConfigJSON()},
+	}
+}
+
+// wireImagePullSecrets makes the API pods pull with Options.ImagePullSecrets
+// and the Options.RegistryAuth secret.
+func wireImagePullSecrets(dep *appsv1.Deployment, opts Options) {
+	spec := &dep.Spec.Template.Spec
+	for _, name := range opts.ImagePullSecrets {
+		spec.ImagePullSecrets = append(spec.ImagePullSecrets, corev1.LocalObjectReference{Name: name})
+	}
+	if opts.RegistryAuth != nil {
+		spec.ImagePullSecrets = append(spec.ImagePullSecrets, corev1.LocalObjectReference{Name: opts.registrySecretName()})
+	}
+}
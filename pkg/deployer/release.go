@@ -0,0 +1,140 @@
+package deployer
+
+import (
+	"fmt"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"strings"
+)
+
+// DefaultRelease is the release of Options.Release when it is empty. Its
+// objects keep the names and selectors they had before releases existed,
+// apiserver, server-svc and so on, so existing installs upgrade in place.
+const DefaultRelease = "ecommerce"
+
+// legacyNames reports whether the objects keep their pre-release names.
+func (o Options) legacyNames() bool {
+	return o.Release == "" || o.Release == DefaultRelease
+}
+
+// release returns Options.Release, or DefaultRelease when it is empty.
+func (o Options) release() string {
+	if o.Release == "" {
+		return DefaultRelease
+	}
+	return o.Release
+}
+
+// objectName returns <release>-<suffix>, or legacy for the default release.
+func (o Options) objectName(suffix, legacy string) string {
+	if o.legacyNames() {
+		return legacy
+	}
+	return o.Release + "-" + suffix
+}
+
+// The names of the built-in objects. The HPA, PDB and NetworkPolicy share
+// the deployment's name, and the Role and RoleBinding the service account's.
+func (o Options) deploymentName() string {
+	return o.objectName("api", "apiserver")
+}
+
+func (o Options) serviceName() string {
+	return o.objectName("svc", "server-svc")
+}
+
+func (o Options) nodePortName() string {
+	return o.objectName("nodeport-svc", "nodeport-svc")
+}
+
+func (o Options) headlessName() string {
+	return o.objectName("headless", "server-headless")
+}
+
+func (o Options) ingressName() string {
+	return o.objectName("ingress", "server-ingress")
+}
+
+func (o Options) configMapName() string {
+	return o.objectName("config", "server-config")
+}
+
+func (o Options) dbSecretName() string {
+	return o.objectName("db-credentials", "server-db-credentials")
+}
+
+func (o Options) registrySecretName() string {
+	return o.objectName("registry-auth", "server-registry-auth")
+}
+
+func (o Options) serviceAccountName() string {
+	return o.objectName("sa", "server-sa")
+}
+
+func (o Options) defaultTLSSecret() string {
+	return o.objectName("tls", DefaultTLSSecret)
+}
+
+func (o Options) postgresName() string {
+	return o.objectName("postgres", "postgres")
+}
+
+func (o Options) postgresSecretName() string {
+	return o.objectName("postgres", "server-postgres")
+}
+
+func (o Options) redisName() string {
+	return o.objectName("redis", "redis")
+}
+
+func (o Options) redisSecretName() string {
+	return o.objectName("redis", "server-redis")
+}
+
+func (o Options) claimName(p PVC) string {
+	return o.objectName(p.Name, "server-"+p.Name)
+}
+
+// selector returns the labels selecting the pods of component. Those of the
+// default release keep their pre-release app label. Other releases select
+// on the recommended labels, which pods of the default release, labelled
+// with its instance, never match, so no release selects another's pods.
+func (o Options) selector(component string) map[string]string {
+	if o.legacyNames() {
+		switch component {
+		case componentDatabase:
+			return map[string]string{"app": "postgres"}
+		case componentCache:
+			return map[string]string{"app": "redis"}
+		}
+		return map[string]string{"app": "server"}
+	}
+	return map[string]string{NameLabel: appName, InstanceLabel: o.Release, ComponentLabel: component}
+}
+
+// validateRelease checks the release is a DNS-1123 label and every object
+// name composed from it is valid too; services need a DNS-1035 label, which
+// starts with a letter.
+func (o Options) validateRelease() error {
+	if o.legacyNames() {
+		return nil
+	}
+	if errs := validation.IsDNS1123Label(o.Release); len(errs) > 0 {
+		return &ValidationError{Field: "name", Err: fmt.Errorf("%q: %s", o.Release, strings.Join(errs, "; "))}
+	}
+	names := []string{o.deploymentName(), o.ingressName(), o.routeName(), o.configMapName(), o.dbSecretName(), o.registrySecretName(),
+		o.serviceAccountName(), o.defaultTLSSecret(), o.releaseRecordName()}
+	for _, p := range o.PVCs {
+		names = append(names, o.claimName(p))
+	}
+	for _, name := range names {
+		if errs := validation.IsDNS1123Label(name); len(errs) > 0 {
+			return &ValidationError{Field: "name", Err: fmt.Errorf("%q makes the name %q: %s", o.Release, name, strings.Join(errs, "; "))}
+		}
+	}
+	for _, name := range []string{o.serviceName(), o.nodePortName(), o.headlessName(), o.postgresName(), o.redisName()} {
+		if errs := validation.IsDNS1035Label(name); len(errs) > 0 {
+			return &ValidationError{Field: "name", Err: fmt.Errorf("%q makes the service name %q: %s", o.Release, name, strings.Join(errs, "; "))}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package deployer
+
+import (
+	"errors"
+	"fmt"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+const (
+	// DefaultCPURequest and DefaultMemoryRequest make the API pods
+	// Burstable rather than BestEffort, which are evicted first.
+	DefaultCPURequest    = "100m"
+	DefaultMemoryRequest = "128Mi"
+)
+
+// containerResources returns the requests and limits of the API container.
+// A request left empty takes its default unless a limit is set for the
+// resource, in which case the cluster sets the request to the limit. The
+// quantities must have passed validateResources.
+func (o Options) containerResources() corev1.ResourceRequirements {
+	var r corev1.ResourceRequirements
+	if o.NoResources {
+		return r
+	}
+	set := func(list *corev1.ResourceList, name corev1.ResourceName, quantity string) {
+		if quantity == "" {
+			return
+		}
+		if *list == nil {
+			*list = corev1.ResourceList{}
+		}
+		(*list)[name] = resource.MustParse(quantity)
+	}
+	cpuRequest, memoryRequest := o.CPURequest, o.MemoryRequest
+	if cpuRequest == "" && o.CPULimit == "" {
+		cpuRequest = DefaultCPURequest
+	}
+	if memoryRequest == "" && o.MemoryLimit == "" {
+		memoryRequest = DefaultMemoryRequest
+	}
+	set(&r.Requests, corev1.ResourceCPU, cpuRequest)
+	set(&r.Requests, corev1.ResourceMemory, memoryRequest)
+	set(&r.Limits, corev1.ResourceCPU, o.CPULimit)
+	set(&r.Limits, corev1.ResourceMemory, o.MemoryLimit)
+	return r
+}
+
+// validateResources checks each quantity parses and no request exceeds its
+// limit.
+func (o Options) validateResources() error {
+	if o.NoResources && o.CPURequest+o.CPULimit+o.MemoryRequest+o.MemoryLimit != "" {
+		return &ValidationError{Field: "no-resources", Err: errors.New("can't be combined with requests or limits")}
+	}
+	pairs := []struct {
+		requestField, limitField string
+		request, limit           string
+	}{
+		{"cpu-request", "cpu-limit", o.CPURequest, o.CPULimit},
+		{"memory-request", "memory-limit", o.MemoryRequest, o.MemoryLimit},
+	}
+	for _, p := range pairs {
+		var request, limit resource.Quantity
+		var err error
+		if p.request != "" {
+			if request, err = resource.ParseQuantity(p.request); err != nil {
+				return &ValidationError{Field: p.requestField, Err: fmt.Errorf("%q: %w", p.request, err)}
+			}
+		}
+		if p.limit != "" {
+			if limit, err = resource.ParseQuantity(p.limit); err != nil {
+				return &ValidationError{Field: p.limitField, Err: fmt.Errorf("%q: %w", p.limit, err)}
+			}
+		}
+		if p.request != "" && p.limit != "" && request.Cmp(limit) > 0 {
+			return &ValidationError{Field: p.requestField, Err: fmt.Errorf("%s is more than the %s limit", p.request, p.limit)}
+		}
+	}
+	return nil
+}
+
+// wireResources sets the requests and limits of the API container.
+func wireResources(dep *appsv1.Deployment, opts Options) {
+	apiContainer(&dep.Spec.Template.Spec).Resources = opts.containerResources()
+}
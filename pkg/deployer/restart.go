@@ -0,0 +1,48 @@
+package deployer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"time"
+)
+
+// restartedAtAnnotation is the pod template annotation kubectl rollout
+// restart sets.
+const restartedAtAnnotation = "kubectl.kubernetes.io/restartedAt"
+
+// Restart rolls the API pods, for example to pick up a changed secret, by
+// stamping the current time on the pod template like kubectl rollout
+// restart. WaitForRollout then waits for the new pods.
+func (d *Deployer) Restart(ctx context.Context) error {
+	dep := apiDeployment(d.opts)
+	if dep == nil {
+		return errors.New("the manifests hold no Deployment")
+	}
+	name := dep.GetName()
+	patch, err := json.Marshal(map[string]interface{}{
+		"spec": map[string]interface{}{
+			"template": map[string]interface{}{
+				"metadata": map[string]interface{}{
+					"annotations": map[string]interface{}{restartedAtAnnotation: time.Now().Format(time.RFC3339)},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	d.log.Info("Restarting", "namespace", d.opts.Namespace, "deployment", name)
+	_, err = d.resource(DeploymentResource).Patch(ctx, name, types.StrategicMergePatchType, patch, metav1.PatchOptions{DryRun: d.dryRun()})
+	if apierrors.IsNotFound(err) {
+		return d.opError(OpPatch, DeploymentResource, name, fmt.Errorf("not deployed yet: %w", err))
+	}
+	if err != nil {
+		return d.opError(OpPatch, DeploymentResource, name, err)
+	}
+	return nil
+}
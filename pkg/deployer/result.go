@@ -0,0 +1,395 @@
+package deployer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	utilnet "k8s.io/apimachinery/pkg/util/net"
+	"net/http"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// The actions applying an object took, as AppliedResource.Action.
+const (
+	ActionCreated   = "created"
+	ActionUpdated   = "updated"
+	ActionUnchanged = "unchanged"
+)
+
+// DeployOptions configures Deploy.
+type DeployOptions struct {
+	// Prune deletes the objects of the release no longer desired once the
+	// others are applied, looking through PruneResources; see Prune.
+	Prune          bool
+	PruneResources []schema.GroupResource
+	// Wait waits, after applying and pruning, for the rollout, the address
+	// of the load balancer and that of the ingress, each within Timeout.
+	// Dry runs don't wait.
+	Wait    bool
+	Timeout time.Duration
+	// Progress, when set, is called as each phase starts, e.g. "waiting
+	// for the rollout".
+	Progress func(phase string)
+}
+
+// Result is the outcome of Deploy. The CLI renders its output from it, so
+// its JSON encoding is what -o json prints.
+type Result struct {
+	Namespace string `json:"namespace"`
+	DryRun    bool   `json:"dryRun"`
+	// Applied are the objects applied, in order, which on failure are
+	// those applied before it.
+	Applied []AppliedResource `json:"applied"`
+	// Pruned are the objects DeployOptions.Prune deleted, or would delete
+	// on dry runs, and those it failed to.
+	Pruned []PrunedResource `json:"pruned,omitempty"`
+	// Skipped are the built-in objects left out as the cluster doesn't
+	// serve their API.
+	Skipped []SkippedFeature `json:"skipped,omitempty"`
+	// Rollout is the outcome of waiting for the rollout, nil when there
+	// was no wait.
+	Rollout *RolloutOutcome `json:"rollout,omitempty"`
+	Access  Access          `json:"access"`
+	// Compatibility is what CheckCompatibility found, when the caller
+	// checked the cluster first.
+	Compatibility *Compatibility `json:"compatibility,omitempty"`
+	// Error is the failure of the deploy, empty when it succeeded.
+	Error string `json:"error,omitempty"`
+	// NotApplied are the objects, as kind/name, left unapplied after a
+	// failure to apply another; see ApplyError.
+	NotApplied []string `json:"notApplied,omitempty"`
+}
+
+// AppliedResource is an object Deploy applied.
+type AppliedResource struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	// Namespace is empty for cluster-scoped objects.
+	Namespace string    `json:"namespace,omitempty"`
+	Name      string    `json:"name"`
+	UID       types.UID `json:"uid,omitempty"`
+	// Action is ActionCreated, ActionUpdated or ActionUnchanged. It is
+	// empty for objects of AppliedResources, applied other than by Deploy.
+	Action string `json:"action,omitempty"`
+	// Warnings are those the apiserver answered applying the object with,
+	// such as deprecated APIs. Only Deployers of NewForConfig see them.
+	Warnings []string `json:"warnings,omitempty"`
+	// Object is the object as the apiserver returned it, with its
+	// defaulted and allocated fields.
+	Object *unstructured.Unstructured `json:"object"`
+}
+
+// GroupVersionKind returns the kind of the object.
+func (r AppliedResource) GroupVersionKind() schema.GroupVersionKind {
+	return schema.FromAPIVersionAndKind(r.APIVersion, r.Kind)
+}
+
+// PrunedResource is an object Deploy pruned, or failed to.
+type PrunedResource struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// SkippedFeature is a built-in object Deploy left out, and why.
+type SkippedFeature struct {
+	Kind   string `json:"kind"`
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+// RolloutOutcome is how waiting for the API deployment to roll out ended.
+type RolloutOutcome struct {
+	Deployment string          `json:"deployment"`
+	RolledOut  bool            `json:"rolledOut"`
+	Duration   metav1.Duration `json:"duration"`
+	Error      string          `json:"error,omitempty"`
+}
+
+// Access is where the API is reached once deployed.
+type Access struct {
+	// Ingress are the URLs through the ingress, once its address is
+	// published.
+	Ingress []Endpoint `json:"ingress,omitempty"`
+	// NodePorts are the applied services with node ports.
+	NodePorts []NodePortAccess `json:"nodePorts,omitempty"`
+	// LoadBalancer is the LoadBalancer service, once it is assigned its
+	// external addresses.
+	LoadBalancer *LoadBalancerAccess `json:"loadBalancer,omitempty"`
+}
+
+// LoadBalancerAccess is a service reached through a cloud load balancer.
+type LoadBalancerAccess struct {
+	Service   string   `json:"service"`
+	Addresses []string `json:"addresses"`
+}
+
+// NodePortAccess is a service reached through node ports.
+type NodePortAccess struct {
+	Service string  `json:"service"`
+	Ports   []int64 `json:"ports"`
+	// URLs are those of NodePortURLs; Error says why there are none when
+	// the nodes couldn't be listed.
+	URLs  []string `json:"urls,omitempty"`
+	Error string   `json:"error,omitempty"`
+}
+
+// Deploy applies the objects as DeployAll does, prunes and waits as opts
+// says, and returns what it did. On failure the result holds what was done
+// before it, and its Error the failure that is also returned.
+func (d *Deployer) Deploy(ctx context.Context, opts DeployOptions) (*Result, error) {
+	result := &Result{Namespace: d.opts.Namespace, DryRun: d.opts.DryRun}
+	failed := func(err error) (*Result, error) {
+		result.Error = err.Error()
+		var applyErr *ApplyError
+		if errors.As(err, &applyErr) {
+			result.NotApplied = applyErr.NotApplied
+		}
+		return result, err
+	}
+	progress := opts.Progress
+	if progress == nil {
+		progress = func(string) {}
+	}
+
+	progress("applying the objects")
+	applyCtx, log := withApplyLog(ctx)
+	applied, err := d.DeployAll(applyCtx)
+	result.Applied = log.resources(applied)
+	if err != nil {
+		return failed(err)
+	}
+	skipped, err := d.Skipped()
+	if err != nil {
+		return failed(err)
+	}
+	for _, s := range skipped {
+		result.Skipped = append(result.Skipped, SkippedFeature{Kind: s.Kind, Name: s.Name, Reason: s.Reason.Error()})
+	}
+	if !d.opts.DryRun {
+		result.Access.NodePorts = d.nodePortAccess(ctx, applied)
+	}
+
+	if opts.Prune {
+		progress("pruning")
+		var first error
+		for _, r := range d.Prune(ctx, opts.PruneResources) {
+			switch r.Outcome {
+			case Deleted:
+				result.Pruned = append(result.Pruned, PrunedResource{Kind: r.Kind, Namespace: d.opts.Namespace, Name: r.Name})
+			case Failed:
+				result.Pruned = append(result.Pruned, PrunedResource{Kind: r.Kind, Namespace: d.opts.Namespace, Name: r.Name, Error: r.Err.Error()})
+				if first == nil {
+					first = r.Err
+				}
+			}
+		}
+		if first != nil {
+			return failed(fmt.Errorf("pruning failed: %w", first))
+		}
+	}
+
+	if !opts.Wait || d.opts.DryRun {
+		return result, nil
+	}
+	progress("waiting for the rollout")
+	start := time.Now()
+	err = d.WaitForRollout(ctx, opts.Timeout)
+	result.Rollout = &RolloutOutcome{Deployment: d.DeploymentName(), RolledOut: err == nil, Duration: metav1.Duration{Duration: time.Since(start).Round(time.Millisecond)}}
+	if err != nil {
+		result.Rollout.Error = err.Error()
+		return failed(err)
+	}
+	progress("waiting for the load balancer")
+	lb, err := d.WaitForLoadBalancer(ctx, opts.Timeout)
+	if err != nil {
+		return failed(err)
+	}
+	if len(lb) > 0 {
+		result.Access.LoadBalancer = &LoadBalancerAccess{Service: d.ServiceName(), Addresses: lb}
+	}
+	progress("waiting for the ingress address")
+	addresses, err := d.WaitForIngress(ctx, opts.Timeout)
+	if err != nil {
+		return failed(err)
+	}
+	if len(addresses) > 0 {
+		result.Access.Ingress = d.IngressEndpoints(addresses)
+	}
+	return result, nil
+}
+
+// AppliedResources describes objects applied other than by Deploy, such as
+// those of DeployBlueGreen, for a Result.
+func AppliedResources(objects []*unstructured.Unstructured) []AppliedResource {
+	resources := make([]AppliedResource, 0, len(objects))
+	for _, obj := range objects {
+		resources = append(resources, appliedResource(obj))
+	}
+	return resources
+}
+
+func appliedResource(obj *unstructured.Unstructured) AppliedResource {
+	return AppliedResource{
+		APIVersion: obj.GetAPIVersion(),
+		Kind:       obj.GetKind(),
+		Namespace:  obj.GetNamespace(),
+		Name:       obj.GetName(),
+		UID:        obj.GetUID(),
+		Object:     obj,
+	}
+}
+
+// nodePortAccess returns the node ports of every applied service having
+// some, with their URLs.
+func (d *Deployer) nodePortAccess(ctx context.Context, applied []*unstructured.Unstructured) []NodePortAccess {
+	var access []NodePortAccess
+	for _, obj := range applied {
+		ports := NodePorts(obj)
+		if obj.GetKind() != "Service" || len(ports) == 0 {
+			continue
+		}
+		a := NodePortAccess{Service: obj.GetName(), Ports: ports}
+		urls, err := d.NodePortURLs(ctx, obj)
+		if err != nil {
+			a.Error = err.Error()
+		}
+		a.URLs = urls
+		access = append(access, a)
+	}
+	return access
+}
+
+// applyLog records the action and warnings of each object applied under a
+// context carrying it, for Deploy.
+type applyLog struct {
+	mu      sync.Mutex
+	applied map[string]AppliedResource
+}
+
+type applyLogKey struct{}
+
+// withApplyLog returns ctx carrying a new applyLog.
+func withApplyLog(ctx context.Context) (context.Context, *applyLog) {
+	log := &applyLog{applied: map[string]AppliedResource{}}
+	return context.WithValue(ctx, applyLogKey{}, log), log
+}
+
+// applyLogFrom returns the applyLog ctx carries, or nil.
+func applyLogFrom(ctx context.Context) *applyLog {
+	log, _ := ctx.Value(applyLogKey{}).(*applyLog)
+	return log
+}
+
+// add records applying obj. It does nothing on a nil log.
+func (l *applyLog) add(obj *unstructured.Unstructured, action string, warnings []string) {
+	if l == nil {
+		return
+	}
+	r := appliedResource(obj)
+	r.Action, r.Warnings = action, warnings
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.applied[appliedKey(obj)] = r
+}
+
+// resources returns the records of objects, in their order.
+func (l *applyLog) resources(objects []*unstructured.Unstructured) []AppliedResource {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	resources := make([]AppliedResource, 0, len(objects))
+	for _, obj := range objects {
+		r, ok := l.applied[appliedKey(obj)]
+		if !ok {
+			r = appliedResource(obj)
+		}
+		r.Object = obj
+		resources = append(resources, r)
+	}
+	return resources
+}
+
+func appliedKey(obj *unstructured.Unstructured) string {
+	return obj.GetAPIVersion() + "/" + obj.GetKind() + "/" + obj.GetNamespace() + "/" + obj.GetName()
+}
+
+// applyAction returns what applying took live, nil when it didn't exist, to
+// applied. Status and the fields every write changes are left out of the
+// comparison, so a no-op apply, dry run or not, is unchanged.
+func applyAction(live, applied *unstructured.Unstructured) string {
+	if live == nil {
+		return ActionCreated
+	}
+	if reflect.DeepEqual(comparedFields(live), comparedFields(applied)) {
+		return ActionUnchanged
+	}
+	return ActionUpdated
+}
+
+func comparedFields(obj *unstructured.Unstructured) map[string]interface{} {
+	obj = obj.DeepCopy()
+	unstructured.RemoveNestedField(obj.Object, "metadata", "managedFields")
+	unstructured.RemoveNestedField(obj.Object, "metadata", "resourceVersion")
+	unstructured.RemoveNestedField(obj.Object, "status")
+	return obj.Object
+}
+
+// warningCollector gathers the warnings the apiserver answers the requests
+// of a context carrying it with.
+type warningCollector struct {
+	mu       sync.Mutex
+	warnings []string
+}
+
+type warningsKey struct{}
+
+// withWarnings returns ctx carrying a new warningCollector.
+func withWarnings(ctx context.Context) (context.Context, *warningCollector) {
+	c := &warningCollector{}
+	return context.WithValue(ctx, warningsKey{}, c), c
+}
+
+// list returns the warnings gathered, each once.
+func (c *warningCollector) list() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.warnings
+}
+
+func (c *warningCollector) add(warning string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, w := range c.warnings {
+		if w == warning {
+			return
+		}
+	}
+	c.warnings = append(c.warnings, warning)
+}
+
+// warningTransport hands the Warning headers of each response to the
+// warningCollector of its request's context.
+type warningTransport struct {
+	rt http.RoundTripper
+}
+
+func (t warningTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.rt.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+	if c, ok := req.Context().Value(warningsKey{}).(*warningCollector); ok {
+		headers, _ := utilnet.ParseWarningHeaders(resp.Header.Values("Warning"))
+		for _, h := range headers {
+			c.add(h.Text)
+		}
+	}
+	return resp, nil
+}
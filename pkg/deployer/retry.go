@@ -0,0 +1,229 @@
+package deployer
+
+import (
+	"context"
+	"errors"
+	"github.com/go-logr/logr"
+	"io"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
+	"net"
+	"syscall"
+	"time"
+)
+
+const (
+	// DefaultRetryBackoff is the delay before the first retry when
+	// Options.RetryBackoff is zero. It doubles with every attempt.
+	DefaultRetryBackoff = 500 * time.Millisecond
+	// maxRetryDelay caps the backoff and any Retry-After of the apiserver.
+	maxRetryDelay = 30 * time.Second
+)
+
+// transient reports whether err is worth retrying: the apiserver throttled
+// or couldn't serve the request, or the connection timed out or broke.
+// Rejections such as Forbidden, Invalid or AlreadyExists never are.
+func transient(err error) bool {
+	return throttled(err) || responseLost(err)
+}
+
+// throttled reports whether the apiserver turned the request away without
+// acting on it, so that it is safe to send again whatever it does.
+func throttled(err error) bool {
+	return apierrors.IsTooManyRequests(err) || apierrors.IsServiceUnavailable(err)
+}
+
+// responseLost reports whether the request failed without an answer: it
+// timed out or the connection broke, so it may have been carried out.
+func responseLost(err error) bool {
+	if apierrors.IsServerTimeout(err) {
+		return true
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET) {
+		return true
+	}
+	var nerr net.Error
+	return errors.As(err, &nerr) && nerr.Timeout()
+}
+
+// retrier runs requests again after transient failures.
+type retrier struct {
+	max     int
+	backoff time.Duration
+	log     logr.Logger
+}
+
+// do calls fn until it succeeds, fails with an error that isn't transient,
+// or has been retried r.max times. The delay between attempts grows
+// exponentially, and is at least what a Retry-After header asks for.
+func (r *retrier) do(ctx context.Context, op Op, gvr schema.GroupVersionResource, name string, fn func() error) error {
+	return r.retry(ctx, op, gvr, name, transient, fn)
+}
+
+// retry is do retrying the errors retryable reports, rather than those
+// transient does.
+func (r *retrier) retry(ctx context.Context, op Op, gvr schema.GroupVersionResource, name string, retryable func(error) bool, fn func() error) error {
+	backoff := wait.Backoff{Duration: r.backoff, Factor: 2, Jitter: 0.1, Steps: r.max, Cap: maxRetryDelay}
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt > r.max || !retryable(err) {
+			return err
+		}
+		delay := backoff.Step()
+		if seconds, ok := apierrors.SuggestsClientDelay(err); ok {
+			if after := time.Duration(seconds) * time.Second; after > delay {
+				delay = after
+			}
+		}
+		if delay > maxRetryDelay {
+			delay = maxRetryDelay
+		}
+		r.log.V(1).Info("Retrying", "op", string(op), "resource", gvr.String(), "name", name,
+			"attempt", attempt, "of", r.max, "delay", delay.String(), "error", err.Error())
+		t := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		case <-t.C:
+		}
+	}
+}
+
+// newRetrier returns the retrier of opts, which retries up to
+// opts.MaxRetries times and, with none, runs each request once.
+func newRetrier(opts Options) *retrier {
+	backoff := opts.RetryBackoff
+	if backoff == 0 {
+		backoff = DefaultRetryBackoff
+	}
+	return &retrier{max: opts.MaxRetries, backoff: backoff, log: opts.Logger}
+}
+
+// withRetries wraps client so that every request through it is retried
+// by r on transient failures. The requests of the typed clientset go
+// through r.do at each call instead.
+func withRetries(client dynamic.Interface, r *retrier) dynamic.Interface {
+	if r.max <= 0 {
+		return client
+	}
+	return &retryingClient{client: client, r: r}
+}
+
+type retryingClient struct {
+	client dynamic.Interface
+	r      *retrier
+}
+
+func (c *retryingClient) Resource(gvr schema.GroupVersionResource) dynamic.NamespaceableResourceInterface {
+	res := c.client.Resource(gvr)
+	return &retryingResource{ResourceInterface: res, namespaceable: res, gvr: gvr, r: c.r}
+}
+
+// retryingResource retries the calls of a dynamic.ResourceInterface.
+// namespaceable is only set at the cluster level, before Namespace.
+type retryingResource struct {
+	dynamic.ResourceInterface
+	namespaceable dynamic.NamespaceableResourceInterface
+	gvr           schema.GroupVersionResource
+	r             *retrier
+}
+
+func (c *retryingResource) Namespace(ns string) dynamic.ResourceInterface {
+	return &retryingResource{ResourceInterface: c.namespaceable.Namespace(ns), gvr: c.gvr, r: c.r}
+}
+
+// Create isn't idempotent: when the response to a create is lost, the object
+// may have been created, and the retry then fails with AlreadyExists. That
+// is taken as success, returning the object as it is. Objects named by
+// generateName, such as the migration Job, would be created twice, so they
+// are only retried when the apiserver throttled the create.
+func (c *retryingResource) Create(ctx context.Context, obj *unstructured.Unstructured, options metav1.CreateOptions, subresources ...string) (out *unstructured.Unstructured, err error) {
+	name := obj.GetName()
+	retryable := transient
+	if name == "" {
+		retryable = throttled
+	}
+	lost := false
+	err = c.r.retry(ctx, OpCreate, c.gvr, name, retryable, func() error {
+		out, err = c.ResourceInterface.Create(ctx, obj, options, subresources...)
+		if lost && apierrors.IsAlreadyExists(err) && len(subresources) == 0 {
+			out, err = c.ResourceInterface.Get(ctx, name, metav1.GetOptions{})
+		}
+		if responseLost(err) {
+			lost = true
+		}
+		return err
+	})
+	return out, err
+}
+
+func (c *retryingResource) Update(ctx context.Context, obj *unstructured.Unstructured, options metav1.UpdateOptions, subresources ...string) (out *unstructured.Unstructured, err error) {
+	err = c.r.do(ctx, OpPatch, c.gvr, obj.GetName(), func() error {
+		out, err = c.ResourceInterface.Update(ctx, obj, options, subresources...)
+		return err
+	})
+	return out, err
+}
+
+func (c *retryingResource) UpdateStatus(ctx context.Context, obj *unstructured.Unstructured, options metav1.UpdateOptions) (out *unstructured.Unstructured, err error) {
+	err = c.r.do(ctx, OpPatch, c.gvr, obj.GetName(), func() error {
+		out, err = c.ResourceInterface.UpdateStatus(ctx, obj, options)
+		return err
+	})
+	return out, err
+}
+
+func (c *retryingResource) Delete(ctx context.Context, name string, options metav1.DeleteOptions, subresources ...string) error {
+	return c.r.do(ctx, OpDelete, c.gvr, name, func() error {
+		return c.ResourceInterface.Delete(ctx, name, options, subresources...)
+	})
+}
+
+func (c *retryingResource) DeleteCollection(ctx context.Context, options metav1.DeleteOptions, listOptions metav1.ListOptions) error {
+	return c.r.do(ctx, OpDelete, c.gvr, "", func() error {
+		return c.ResourceInterface.DeleteCollection(ctx, options, listOptions)
+	})
+}
+
+func (c *retryingResource) Get(ctx context.Context, name string, options metav1.GetOptions, subresources ...string) (out *unstructured.Unstructured, err error) {
+	err = c.r.do(ctx, OpGet, c.gvr, name, func() error {
+		out, err = c.ResourceInterface.Get(ctx, name, options, subresources...)
+		return err
+	})
+	return out, err
+}
+
+func (c *retryingResource) List(ctx context.Context, opts metav1.ListOptions) (out *unstructured.UnstructuredList, err error) {
+	err = c.r.do(ctx, OpList, c.gvr, "", func() error {
+		out, err = c.ResourceInterface.List(ctx, opts)
+		return err
+	})
+	return out, err
+}
+
+func (c *retryingResource) Watch(ctx context.Context, opts metav1.ListOptions) (out watch.Interface, err error) {
+	err = c.r.do(ctx, OpWait, c.gvr, "", func() error {
+		out, err = c.ResourceInterface.Watch(ctx, opts)
+		return err
+	})
+	return out, err
+}
+
+func (c *retryingResource) Patch(ctx context.Context, name string, pt types.PatchType, data []byte, options metav1.PatchOptions, subresources ...string) (out *unstructured.Unstructured, err error) {
+	op := OpPatch
+	if pt == types.ApplyPatchType {
+		op = OpApply
+	}
+	err = c.r.do(ctx, op, c.gvr, name, func() error {
+		out, err = c.ResourceInterface.Patch(ctx, name, pt, data, options, subresources...)
+		return err
+	})
+	return out, err
+}
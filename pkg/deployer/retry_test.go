@@ -0,0 +1,143 @@
+package deployer
+
+import (
+	"context"
+	"errors"
+	"github.com/go-logr/logr"
+	"io"
+	appsv1 "k8s.io/api/apps/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+	"testing"
+	"time"
+)
+
+func TestTypedPatchRetries(t *testing.T) {
+	tests := []struct {
+		name      string
+		failures  []error
+		wantCalls int
+		wantErr   bool
+	}{
+		{name: "no failure", wantCalls: 1},
+		{name: "throttled", failures: []error{apierrors.NewTooManyRequests("slow down", 0)}, wantCalls: 2},
+		{name: "connection reset", failures: []error{io.ErrUnexpectedEOF, apierrors.NewServiceUnavailable("restarting")}, wantCalls: 3},
+		{name: "out of retries", failures: []error{io.EOF, io.EOF, io.EOF}, wantCalls: 3, wantErr: true},
+		{name: "forbidden", failures: []error{apierrors.NewForbidden(schema.GroupResource{Group: "apps", Resource: "deployments"}, "apiserver", errors.New("denied"))}, wantCalls: 1, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kube := kubefake.NewSimpleClientset()
+			calls := 0
+			kube.PrependReactor("patch", "deployments", func(action clienttesting.Action) (bool, runtime.Object, error) {
+				calls++
+				if calls <= len(tt.failures) {
+					return true, nil, tt.failures[calls-1]
+				}
+				name := action.(clienttesting.PatchAction).GetName()
+				return true, &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: action.GetNamespace()}}, nil
+			})
+			d := NewWithClientset(dynamicfake.NewSimpleDynamicClient(runtime.NewScheme()), kube, Options{
+				Namespace:    "shop",
+				Typed:        true,
+				MaxRetries:   2,
+				RetryBackoff: time.Millisecond,
+			})
+
+			obj, handled, err := d.typedPatch(context.Background(), DeploymentResource, "apiserver", []byte("{}"), metav1.PatchOptions{})
+			if !handled {
+				t.Fatal("deployments not handled by the typed clients")
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("got %d patches, want %d", calls, tt.wantCalls)
+			}
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("got no error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("typedPatch: %v", err)
+			}
+			if obj.GetName() != "apiserver" || obj.GetKind() != "Deployment" {
+				t.Errorf("got %s %s, want Deployment apiserver", obj.GetKind(), obj.GetName())
+			}
+		})
+	}
+}
+
+func TestCreateRetries(t *testing.T) {
+	tests := []struct {
+		name         string
+		generateName bool
+		failures     []error
+		// persist has the failed creates create the object anyway, as
+		// when only the response is lost.
+		persist   bool
+		wantCalls int
+		wantErr   bool
+	}{
+		{name: "no failure", wantCalls: 1},
+		{name: "throttled", failures: []error{apierrors.NewTooManyRequests("slow down", 0)}, wantCalls: 2},
+		{name: "response lost", failures: []error{io.EOF}, persist: true, wantCalls: 2},
+		{name: "request lost", failures: []error{io.ErrUnexpectedEOF}, wantCalls: 2},
+		{name: "already exists", failures: []error{apierrors.NewAlreadyExists(ConfigMapResource.GroupResource(), "settings")}, wantCalls: 1, wantErr: true},
+		{name: "generateName throttled", generateName: true, failures: []error{apierrors.NewServiceUnavailable("restarting")}, wantCalls: 2},
+		{name: "generateName response lost", generateName: true, failures: []error{io.EOF}, persist: true, wantCalls: 1, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newFakeClient()
+			calls := 0
+			client.PrependReactor("create", "configmaps", func(action clienttesting.Action) (bool, runtime.Object, error) {
+				calls++
+				obj := action.(clienttesting.CreateAction).GetObject().(*unstructured.Unstructured).DeepCopy()
+				if obj.GetName() == "" {
+					obj.SetName(obj.GetGenerateName() + "x")
+				}
+				if calls > len(tt.failures) {
+					if err := client.Tracker().Create(ConfigMapResource, obj, "shop"); err != nil {
+						return true, nil, err
+					}
+					return true, obj, nil
+				}
+				if tt.persist {
+					if err := client.Tracker().Create(ConfigMapResource, obj, "shop"); err != nil {
+						return true, nil, err
+					}
+				}
+				return true, nil, tt.failures[calls-1]
+			})
+			r := newRetrier(Options{MaxRetries: 2, RetryBackoff: time.Millisecond, Logger: logr.Discard()})
+			obj := unstructuredObject("v1", "ConfigMap", "shop", "settings")
+			if tt.generateName {
+				obj.SetName("")
+				obj.SetGenerateName("settings-")
+			}
+
+			got, err := withRetries(client, r).Resource(ConfigMapResource).Namespace("shop").Create(context.Background(), obj, metav1.CreateOptions{})
+			if calls != tt.wantCalls {
+				t.Errorf("got %d creates, want %d", calls, tt.wantCalls)
+			}
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("got no error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Create: %v", err)
+			}
+			if got == nil || got.GetKind() != "ConfigMap" || got.GetNamespace() != "shop" {
+				t.Errorf("got %v, want the ConfigMap created", got)
+			}
+		})
+	}
+}
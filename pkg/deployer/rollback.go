@@ -0,0 +1,158 @@
+package deployer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	appsv1 "k8s.io/api/apps/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"reflect"
+	"sort"
+	"strconv"
+)
+
+// ReplicaSetResource is the GroupVersionResource of replica sets.
+var ReplicaSetResource = schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "replicasets"}
+
+// revisionAnnotation is the revision the deployment controller stamps on a
+// deployment and on each of its replica sets.
+const revisionAnnotation = "deployment.kubernetes.io/revision"
+
+// Revision is a pod template recorded in a replica set of the API
+// deployment.
+type Revision struct {
+	Number     int64
+	ReplicaSet string
+	template   map[string]interface{}
+}
+
+// validateRevisionHistoryLimit checks the limit isn't negative.
+func (o Options) validateRevisionHistoryLimit() error {
+	if o.RevisionHistoryLimit != nil && *o.RevisionHistoryLimit < 0 {
+		return &ValidationError{Field: "revision-history-limit", Err: fmt.Errorf("must not be negative, got %d", *o.RevisionHistoryLimit)}
+	}
+	return nil
+}
+
+// History returns the revisions of the API deployment, oldest first, read
+// from the replica sets it owns. The deployment keeps
+// Options.RevisionHistoryLimit old ones.
+func (d *Deployer) History(ctx context.Context) ([]Revision, error) {
+	dep, err := d.liveAPIDeployment(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return d.history(ctx, dep)
+}
+
+func (d *Deployer) history(ctx context.Context, dep *unstructured.Unstructured) ([]Revision, error) {
+	selector, _, _ := unstructured.NestedStringMap(dep.Object, "spec", "selector", "matchLabels")
+	list, err := d.resource(ReplicaSetResource).List(ctx, metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(selector).String(),
+	})
+	if err != nil {
+		return nil, d.opError(OpList, ReplicaSetResource, "", err)
+	}
+	var revisions []Revision
+	for _, rs := range list.Items {
+		if !ownedBy(&rs, dep) {
+			continue
+		}
+		n, err := strconv.ParseInt(rs.GetAnnotations()[revisionAnnotation], 10, 64)
+		if err != nil {
+			continue
+		}
+		template, _, _ := unstructured.NestedMap(rs.Object, "spec", "template")
+		unstructured.RemoveNestedField(template, "metadata", "labels", appsv1.DefaultDeploymentUniqueLabelKey)
+		revisions = append(revisions, Revision{Number: n, ReplicaSet: rs.GetName(), template: template})
+	}
+	sort.Slice(revisions, func(i, j int) bool { return revisions[i].Number < revisions[j].Number })
+	return revisions, nil
+}
+
+// Rollback restores the pod template of revision toRevision of the API
+// deployment, or of the one before the current revision when toRevision is
+// 0, like kubectl rollout undo. It returns the revision rolled back to;
+// WaitForRollout then waits for it to roll out. Deploying again rolls
+// forward to the template built from Options.
+func (d *Deployer) Rollback(ctx context.Context, toRevision int64) (int64, error) {
+	if toRevision < 0 {
+		return 0, &ValidationError{Field: "to-revision", Err: fmt.Errorf("must not be negative, got %d", toRevision)}
+	}
+	dep, err := d.liveAPIDeployment(ctx)
+	if err != nil {
+		return 0, err
+	}
+	revisions, err := d.history(ctx, dep)
+	if err != nil {
+		return 0, err
+	}
+	current, _ := strconv.ParseInt(dep.GetAnnotations()[revisionAnnotation], 10, 64)
+	var target *Revision
+	for i := len(revisions) - 1; i >= 0; i-- {
+		r := &revisions[i]
+		if toRevision == 0 && r.Number < current || r.Number == toRevision {
+			target = r
+			break
+		}
+	}
+	if target == nil {
+		if toRevision == 0 {
+			return 0, &ValidationError{Field: "to-revision", Err: fmt.Errorf("deployment %s has no revision before %d; raise -revision-history-limit to keep more", dep.GetName(), current)}
+		}
+		return 0, &ValidationError{Field: "to-revision", Err: fmt.Errorf("deployment %s has no revision %d", dep.GetName(), toRevision)}
+	}
+
+	live, _, _ := unstructured.NestedMap(dep.Object, "spec", "template")
+	if reflect.DeepEqual(live, target.template) {
+		d.log.Info("The deployment already runs the revision's template", "namespace", d.opts.Namespace, "deployment", dep.GetName(), "revision", target.Number)
+		return target.Number, nil
+	}
+	// The resourceVersion test makes the patch fail rather than overwrite a
+	// template changed since it was read.
+	patch, err := json.Marshal([]map[string]interface{}{
+		{"op": "test", "path": "/metadata/resourceVersion", "value": dep.GetResourceVersion()},
+		{"op": "replace", "path": "/spec/template", "value": target.template},
+	})
+	if err != nil {
+		return 0, err
+	}
+	d.log.Info("Rolling back", "namespace", d.opts.Namespace, "deployment", dep.GetName(), "from", current, "to", target.Number, "replicaSet", target.ReplicaSet)
+	_, err = d.resource(DeploymentResource).Patch(ctx, dep.GetName(), types.JSONPatchType, patch, metav1.PatchOptions{DryRun: d.dryRun()})
+	if err != nil {
+		return 0, d.opError(OpPatch, DeploymentResource, dep.GetName(), err)
+	}
+	return target.Number, nil
+}
+
+// liveAPIDeployment gets the API deployment from the cluster.
+func (d *Deployer) liveAPIDeployment(ctx context.Context) (*unstructured.Unstructured, error) {
+	desired := apiDeployment(d.opts)
+	if desired == nil {
+		return nil, errors.New("the manifests hold no Deployment")
+	}
+	dep, err := d.resource(DeploymentResource).Get(ctx, desired.GetName(), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, d.opError(OpGet, DeploymentResource, desired.GetName(), fmt.Errorf("not deployed yet: %w", err))
+	}
+	if err != nil {
+		return nil, d.opError(OpGet, DeploymentResource, desired.GetName(), err)
+	}
+	return dep, nil
+}
+
+// ownedBy reports whether obj has owner as its controller.
+func ownedBy(obj, owner *unstructured.Unstructured) bool {
+	for _, ref := range obj.GetOwnerReferences() {
+		if ref.UID == owner.GetUID() && ref.Controller != nil && *ref.Controller {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,202 @@
+package deployer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"strings"
+	"time"
+)
+
+const (
+	rolloutPollInterval = 2 * time.Second
+
+	// podFailureGrace is how long pods may sit in a failing state before the
+	// rollout is declared failed, so a transient registry hiccup doesn't
+	// abort the wait.
+	podFailureGrace = 30 * time.Second
+)
+
+// RolloutStatus is a snapshot of a deployment's progress.
+type RolloutStatus struct {
+	Desired    int64                 `json:"desired"`
+	Updated    int64                 `json:"updated"`
+	Ready      int64                 `json:"ready"`
+	Available  int64                 `json:"available"`
+	Conditions []DeploymentCondition `json:"conditions,omitempty"`
+}
+
+// DeploymentCondition is a condition reported in a deployment's status.
+type DeploymentCondition struct {
+	Type    string `json:"type"`
+	Status  string `json:"status"`
+	Reason  string `json:"reason,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+func (s RolloutStatus) String() string {
+	conditions := make([]string, 0, len(s.Conditions))
+	for _, c := range s.Conditions {
+		conditions = append(conditions, fmt.Sprintf("%s=%s (%s: %s)", c.Type, c.Status, c.Reason, c.Message))
+	}
+	return fmt.Sprintf("%d/%d replicas ready, %d updated, %d available; conditions: %s",
+		s.Ready, s.Desired, s.Updated, s.Available, strings.Join(conditions, "; "))
+}
+
+// RolloutTimeoutError is returned when a rollout doesn't finish in time.
+type RolloutTimeoutError struct {
+	Name   string
+	Status RolloutStatus
+	// NotReady are the pod/container pairs of the API pods that weren't
+	// ready, sidecars and unfinished init containers included.
+	NotReady []string
+}
+
+func (e *RolloutTimeoutError) Error() string {
+	msg := fmt.Sprintf("timed out waiting for deployment %s to roll out: %s", e.Name, e.Status)
+	if len(e.NotReady) > 0 {
+		msg += "; not ready: " + strings.Join(e.NotReady, ", ")
+	}
+	return msg
+}
+
+// WaitForRollout waits for the database when Options.Postgres is set and for
+// the cache with Options.Redis, then polls the API deployment until its
+// latest generation is fully rolled out and available, mirroring kubectl
+// rollout status. A pod is only available once every container is ready,
+// the sidecars included. With
+// Options.Manifests the first Deployment in them is the API deployment, and
+// there is nothing to wait for if they hold none.
+//
+// With StrategyRecreate no pod is available between the old ones stopping
+// and the new ones becoming ready, which isn't a failure.
+//
+// It returns a *RolloutFailedError when the deployment exceeds its progress
+// deadline or its pods stay stuck pulling images or crash looping, and a
+// *RolloutTimeoutError when timeout elapses without either outcome.
+func (d *Deployer) WaitForRollout(ctx context.Context, timeout time.Duration) error {
+	waitCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	if err := d.waitForPostgres(waitCtx); err != nil {
+		return err
+	}
+	if err := d.waitForRedis(waitCtx); err != nil {
+		return err
+	}
+
+	dep := apiDeployment(d.opts)
+	if dep == nil {
+		return nil
+	}
+	name := dep.GetName()
+	d.log.Info("Waiting for rollout", "namespace", d.opts.Namespace, "deployment", name, "timeout", timeout.String())
+
+	var (
+		status        RolloutStatus
+		failingSince  time.Time
+		stalledReason string
+	)
+	err := wait.PollImmediateUntilWithContext(waitCtx, rolloutPollInterval, func(ctx context.Context) (bool, error) {
+		dep, err := d.resource(DeploymentResource).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return false, d.opError(OpGet, DeploymentResource, name, err)
+		}
+		var done bool
+		status, done = rolloutProgress(dep)
+		d.log.V(1).Info("Rollout progress", "namespace", d.opts.Namespace, "deployment", name,
+			"ready", status.Ready, "updated", status.Updated, "available", status.Available, "desired", status.Desired)
+		if done {
+			return true, nil
+		}
+		if status.progressDeadlineExceeded() {
+			stalledReason = "ProgressDeadlineExceeded"
+			return false, errRolloutStalled
+		}
+
+		failures, err := d.podFailures(ctx)
+		if err != nil {
+			return false, err
+		}
+		switch {
+		case len(failures) == 0:
+			failingSince = time.Time{}
+		case failingSince.IsZero():
+			failingSince = time.Now()
+		case time.Since(failingSince) >= podFailureGrace:
+			stalledReason = failures[0].Reason
+			return false, errRolloutStalled
+		}
+		return false, nil
+	})
+
+	switch {
+	case errors.Is(err, errRolloutStalled):
+		return d.rolloutFailed(ctx, name, stalledReason)
+	case errors.Is(err, wait.ErrWaitTimeout):
+		// Pods that only started failing near the deadline are still the
+		// most useful explanation for the timeout.
+		if failures, ferr := d.podFailures(ctx); ferr == nil && len(failures) > 0 {
+			return &RolloutFailedError{Name: name, Reason: failures[0].Reason, Pods: failures}
+		}
+		return &RolloutTimeoutError{Name: name, Status: status, NotReady: d.notReadyContainers(ctx)}
+	}
+	return err
+}
+
+// errRolloutStalled stops the rollout poll once it is known to have failed.
+var errRolloutStalled = errors.New("rollout stalled")
+
+func (d *Deployer) rolloutFailed(ctx context.Context, name, reason string) error {
+	failures, err := d.podFailures(ctx)
+	if err != nil {
+		return err
+	}
+	return &RolloutFailedError{Name: name, Reason: reason, Pods: failures}
+}
+
+func (s RolloutStatus) progressDeadlineExceeded() bool {
+	for _, c := range s.Conditions {
+		if c.Type == "Progressing" && c.Reason == "ProgressDeadlineExceeded" {
+			return true
+		}
+	}
+	return false
+}
+
+// rolloutProgress reports the deployment's status and whether its rollout
+// has completed, following the checks kubectl rollout status makes.
+func rolloutProgress(dep *unstructured.Unstructured) (RolloutStatus, bool) {
+	desired, found, _ := unstructured.NestedInt64(dep.Object, "spec", "replicas")
+	if !found {
+		desired = 1
+	}
+	status := RolloutStatus{Desired: desired}
+	status.Updated, _, _ = unstructured.NestedInt64(dep.Object, "status", "updatedReplicas")
+	status.Ready, _, _ = unstructured.NestedInt64(dep.Object, "status", "readyReplicas")
+	status.Available, _, _ = unstructured.NestedInt64(dep.Object, "status", "availableReplicas")
+	replicas, _, _ := unstructured.NestedInt64(dep.Object, "status", "replicas")
+	observed, _, _ := unstructured.NestedInt64(dep.Object, "status", "observedGeneration")
+
+	conditions, _, _ := unstructured.NestedSlice(dep.Object, "status", "conditions")
+	for _, c := range conditions {
+		m, ok := c.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		cond := DeploymentCondition{}
+		cond.Type, _, _ = unstructured.NestedString(m, "type")
+		cond.Status, _, _ = unstructured.NestedString(m, "status")
+		cond.Reason, _, _ = unstructured.NestedString(m, "reason")
+		cond.Message, _, _ = unstructured.NestedString(m, "message")
+		status.Conditions = append(status.Conditions, cond)
+	}
+
+	if dep.GetGeneration() > observed {
+		return status, false
+	}
+	done := status.Updated >= desired && replicas <= status.Updated && status.Available >= status.Updated
+	return status, done
+}
@@ -0,0 +1,52 @@
+package deployer
+
+import (
+	"context"
+	"fmt"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// ScaledByHPAError is returned by Scale when a HorizontalPodAutoscaler scales
+// the deployment, which would undo the change.
+type ScaledByHPAError struct {
+	Deployment string
+	HPA        string
+}
+
+func (e *ScaledByHPAError) Error() string {
+	return fmt.Sprintf("deployment %s is scaled by the HorizontalPodAutoscaler %s, which would undo the change; rerun with -force to scale anyway",
+		e.Deployment, e.HPA)
+}
+
+// Scale sets spec.replicas of the API deployment with a JSON merge patch,
+// leaving the rest of the spec alone; WaitForRollout then waits for the
+// replicas to become available. Unless force is set, it refuses with a
+// *ScaledByHPAError when an HPA scales the deployment. The next deploy sets
+// Options.Replicas again.
+func (d *Deployer) Scale(ctx context.Context, replicas int32, force bool) error {
+	if replicas < 0 || replicas > d.opts.MaxReplicas {
+		return &ValidationError{Field: "replicas", Err: fmt.Errorf("must be between 0 and %d, got %d", d.opts.MaxReplicas, replicas)}
+	}
+	dep, err := d.liveAPIDeployment(ctx)
+	if err != nil {
+		return err
+	}
+	name := dep.GetName()
+	if !force {
+		hpa, err := d.scalingHPA(ctx, name)
+		if err != nil {
+			return err
+		}
+		if hpa != "" {
+			return &ScaledByHPAError{Deployment: name, HPA: hpa}
+		}
+	}
+	patch := []byte(fmt.Sprintf(`{"spec":{"replicas":%d}}`, replicas))
+	d.log.Info("Scaling", "namespace", d.opts.Namespace, "deployment", name, "replicas", replicas)
+	_, err = d.resource(DeploymentResource).Patch(ctx, name, types.MergePatchType, patch, metav1.PatchOptions{DryRun: d.dryRun()})
+	if err != nil {
+		return d.opError(OpPatch, DeploymentResource, name, err)
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package deployer
+
+import (
+	"errors"
+	"fmt"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"strings"
+)
+
+const (
+	// AntiAffinitySoft and AntiAffinityHard are the values of
+	// Options.PodAntiAffinity: the scheduler prefers, or requires, nodes
+	// without another API pod of the release.
+	AntiAffinitySoft = "soft"
+	AntiAffinityHard = "hard"
+
+	// hostnameTopologyKey is the node label the anti-affinity spreads the
+	// API pods over, one value per node.
+	hostnameTopologyKey = "kubernetes.io/hostname"
+)
+
+// ParseToleration parses the -toleration shorthand, the taint it
+// tolerates as kubectl taint writes it: key=value:Effect matches the value,
+// key:Effect tolerates any value with the Exists operator, and leaving out
+// the effect, or writing key:Exists, tolerates every effect. An empty key
+// with Exists, as in :NoSchedule, tolerates every taint of the effect, and
+// :Exists every taint.
+func ParseToleration(s string) (corev1.Toleration, error) {
+	t := corev1.Toleration{Operator: corev1.TolerationOpExists}
+	spec := s
+	exists := false
+	if colon := strings.LastIndex(spec, ":"); colon >= 0 {
+		t.Effect = corev1.TaintEffect(spec[colon+1:])
+		spec = spec[:colon]
+		switch t.Effect {
+		case corev1.TaintEffectNoSchedule, corev1.TaintEffectPreferNoSchedule, corev1.TaintEffectNoExecute:
+		case corev1.TaintEffect(corev1.TolerationOpExists):
+			t.Effect, exists = "", true
+		default:
+			return corev1.Toleration{}, fmt.Errorf("%q: the effect must be NoSchedule, PreferNoSchedule or NoExecute, got %q", s, t.Effect)
+		}
+	}
+	t.Key = spec
+	if eq := strings.Index(spec, "="); eq >= 0 {
+		if exists {
+			return corev1.Toleration{}, fmt.Errorf("%q: Exists tolerates any value; drop =%s", s, spec[eq+1:])
+		}
+		t.Key, t.Value, t.Operator = spec[:eq], spec[eq+1:], corev1.TolerationOpEqual
+	}
+	if t.Key == "" {
+		if t.Operator == corev1.TolerationOpEqual {
+			return corev1.Toleration{}, fmt.Errorf("%q: a value needs a key", s)
+		}
+		if t.Effect == "" && !exists {
+			return corev1.Toleration{}, fmt.Errorf("%q: expected key=value:Effect, key:Effect, key:Exists or key", s)
+		}
+		return t, nil
+	}
+	if errs := validation.IsQualifiedName(t.Key); len(errs) > 0 {
+		return corev1.Toleration{}, fmt.Errorf("%q: key %q: %s", s, t.Key, strings.Join(errs, "; "))
+	}
+	if errs := validation.IsValidLabelValue(t.Value); len(errs) > 0 {
+		return corev1.Toleration{}, fmt.Errorf("%q: value %q: %s", s, t.Value, strings.Join(errs, "; "))
+	}
+	return t, nil
+}
+
+// validateScheduling checks the node selector, the anti-affinity preset,
+// and that the preset and Options.Affinity don't both set the pod
+// anti-affinity.
+func (o Options) validateScheduling() error {
+	for _, k := range sortedKeys(o.NodeSelector) {
+		errs := append(validation.IsQualifiedName(k), validation.IsValidLabelValue(o.NodeSelector[k])...)
+		if len(errs) > 0 {
+			return &ValidationError{Field: "node-selector", Err: fmt.Errorf("%s=%s: %s", k, o.NodeSelector[k], strings.Join(errs, "; "))}
+		}
+	}
+	switch o.PodAntiAffinity {
+	case "":
+		return nil
+	case AntiAffinitySoft, AntiAffinityHard:
+	default:
+		return &ValidationError{Field: "pod-anti-affinity", Err: fmt.Errorf("must be %s or %s, got %q", AntiAffinitySoft, AntiAffinityHard, o.PodAntiAffinity)}
+	}
+	if o.Affinity != nil && o.Affinity.PodAntiAffinity != nil {
+		return &ValidationError{Field: "pod-anti-affinity", Err: errors.New("the affinity given already sets podAntiAffinity")}
+	}
+	return nil
+}
+
+// affinity returns Options.Affinity with the pod anti-affinity of
+// Options.PodAntiAffinity, nil when neither is set.
+func (o Options) affinity() *corev1.Affinity {
+	affinity := o.Affinity.DeepCopy()
+	if o.PodAntiAffinity == "" {
+		return affinity
+	}
+	if affinity == nil {
+		affinity = &corev1.Affinity{}
+	}
+	term := corev1.PodAffinityTerm{
+		LabelSelector: &metav1.LabelSelector{MatchLabels: o.selector(componentAPI)},
+		TopologyKey:   hostnameTopologyKey,
+	}
+	affinity.PodAntiAffinity = &corev1.PodAntiAffinity{}
+	if o.PodAntiAffinity == AntiAffinityHard {
+		affinity.PodAntiAffinity.RequiredDuringSchedulingIgnoredDuringExecution = []corev1.PodAffinityTerm{term}
+	} else {
+		affinity.PodAntiAffinity.PreferredDuringSchedulingIgnoredDuringExecution = []corev1.WeightedPodAffinityTerm{{Weight: 100, PodAffinityTerm: term}}
+	}
+	return affinity
+}
+
+// wireScheduling places the API pods: their node selector and tolerations,
+// and the affinity with its anti-affinity preset.
+func wireScheduling(dep *appsv1.Deployment, opts Options) {
+	spec := &dep.Spec.Template.Spec
+	placePod(spec, opts)
+	spec.Affinity = opts.affinity()
+}
+
+// placePod gives the pod spec the node selector and tolerations. The
+// database and cache pods get them too, so they run on the same node pool,
+// but not the affinity, which is the API's own.
+func placePod(spec *corev1.PodSpec, opts Options) {
+	if len(opts.NodeSelector) > 0 {
+		spec.NodeSelector = opts.NodeSelector
+	}
+	spec.Tolerations = opts.Tolerations
+}
@@ -0,0 +1,53 @@
+package deployer
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"reflect"
+	"testing"
+)
+
+func TestParseToleration(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    corev1.Toleration
+		wantErr bool
+	}{
+		{in: "dedicated=api:NoSchedule", want: corev1.Toleration{Key: "dedicated", Operator: corev1.TolerationOpEqual, Value: "api", Effect: corev1.TaintEffectNoSchedule}},
+		{in: "dedicated=api:PreferNoSchedule", want: corev1.Toleration{Key: "dedicated", Operator: corev1.TolerationOpEqual, Value: "api", Effect: corev1.TaintEffectPreferNoSchedule}},
+		{in: "example.com/pool=api:NoExecute", want: corev1.Toleration{Key: "example.com/pool", Operator: corev1.TolerationOpEqual, Value: "api", Effect: corev1.TaintEffectNoExecute}},
+		{in: "dedicated=:NoSchedule", want: corev1.Toleration{Key: "dedicated", Operator: corev1.TolerationOpEqual, Effect: corev1.TaintEffectNoSchedule}},
+		{in: "dedicated=api", want: corev1.Toleration{Key: "dedicated", Operator: corev1.TolerationOpEqual, Value: "api"}},
+		{in: "dedicated:NoSchedule", want: corev1.Toleration{Key: "dedicated", Operator: corev1.TolerationOpExists, Effect: corev1.TaintEffectNoSchedule}},
+		{in: "dedicated:Exists", want: corev1.Toleration{Key: "dedicated", Operator: corev1.TolerationOpExists}},
+		{in: "dedicated", want: corev1.Toleration{Key: "dedicated", Operator: corev1.TolerationOpExists}},
+		{in: ":NoSchedule", want: corev1.Toleration{Operator: corev1.TolerationOpExists, Effect: corev1.TaintEffectNoSchedule}},
+		{in: ":Exists", want: corev1.Toleration{Operator: corev1.TolerationOpExists}},
+
+		{in: "dedicated=api:NoScheduled", wantErr: true},
+		{in: "dedicated=api:noschedule", wantErr: true},
+		{in: "dedicated:", wantErr: true},
+		{in: "dedicated=api:Exists", wantErr: true},
+		{in: "=api:NoSchedule", wantErr: true},
+		{in: "", wantErr: true},
+		{in: "dedicated pool:NoSchedule", wantErr: true},
+		{in: "dedicated=api pool:NoSchedule", wantErr: true},
+		{in: "dedicated=api:NoSchedule:NoExecute", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseToleration(tt.in)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("got %+v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseToleration: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,222 @@
+package deployer
+
+import (
+	"errors"
+	"fmt"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"regexp"
+	"strings"
+)
+
+const (
+	// PodSecurityRestricted is the Security.Preset meeting the restricted
+	// Pod Security Standard.
+	PodSecurityRestricted = "restricted"
+	// DefaultRunAsUser is the uid, gid and fsGroup the restricted preset
+	// runs the API pods as unless others are given.
+	DefaultRunAsUser = 1000
+	// SeccompRuntimeDefault and SeccompUnconfined are the values of
+	// Security.SeccompProfile.
+	SeccompRuntimeDefault = "RuntimeDefault"
+	SeccompUnconfined     = "Unconfined"
+
+	// dataUser is the uid and gid of the postgres and redis users of the
+	// database and cache images, which they run as when RunAsNonRoot is on.
+	dataUser = 999
+	// tmpVolumeName is the emptyDir mounted at /tmp in the API container
+	// when its root filesystem is read-only.
+	tmpVolumeName = "tmp"
+	tmpMountPath  = "/tmp"
+)
+
+// capabilityName matches a Linux capability as the container runtime
+// takes it, e.g. NET_RAW, or ALL.
+var capabilityName = regexp.MustCompile(`^[A-Z][A-Z0-9_]*$`)
+
+// Security is the securityContext of the API pods and, as far as their
+// images allow, of the database and cache pods. Nil fields and empty
+// values are left to the image and the cluster, unless Preset fills them.
+type Security struct {
+	// Preset is PodSecurityRestricted to fill the fields not given with
+	// values passing the restricted Pod Security Standard: a non-root
+	// DefaultRunAsUser, a read-only root filesystem with an emptyDir at
+	// /tmp, no privilege escalation, every capability dropped and the
+	// RuntimeDefault seccomp profile.
+	Preset string
+	// RunAsNonRoot makes the kubelet refuse to start containers as root.
+	RunAsNonRoot *bool
+	// RunAsUser, RunAsGroup and FSGroup are the uid and gid of the API
+	// container's processes and the group owning its volumes.
+	RunAsUser  *int64
+	RunAsGroup *int64
+	FSGroup    *int64
+	// ReadOnlyRootFilesystem mounts the API container's image read-only,
+	// with an emptyDir at /tmp for scratch files.
+	ReadOnlyRootFilesystem *bool
+	// AllowPrivilegeEscalation false keeps the processes from gaining more
+	// privileges than they started with, e.g. through setuid binaries.
+	AllowPrivilegeEscalation *bool
+	// DropCapabilities are the capabilities removed from the containers,
+	// e.g. ALL.
+	DropCapabilities []string
+	// SeccompProfile is SeccompRuntimeDefault or SeccompUnconfined.
+	SeccompProfile string
+}
+
+// withPreset returns s with the fields Preset fills set.
+func (s Security) withPreset() Security {
+	if s.Preset != PodSecurityRestricted {
+		return s
+	}
+	yes, no, user := true, false, int64(DefaultRunAsUser)
+	if s.RunAsNonRoot == nil {
+		s.RunAsNonRoot = &yes
+	}
+	for _, id := range []**int64{&s.RunAsUser, &s.RunAsGroup, &s.FSGroup} {
+		if *id == nil {
+			*id = &user
+		}
+	}
+	if s.ReadOnlyRootFilesystem == nil {
+		s.ReadOnlyRootFilesystem = &yes
+	}
+	if s.AllowPrivilegeEscalation == nil {
+		s.AllowPrivilegeEscalation = &no
+	}
+	if s.DropCapabilities == nil {
+		s.DropCapabilities = []string{"ALL"}
+	}
+	if s.SeccompProfile == "" {
+		s.SeccompProfile = SeccompRuntimeDefault
+	}
+	return s
+}
+
+// readOnlyRoot reports whether the API container's root filesystem is
+// read-only, and so has /tmp mounted.
+func (o Options) readOnlyRoot() bool {
+	s := o.Security.withPreset()
+	return s.ReadOnlyRootFilesystem != nil && *s.ReadOnlyRootFilesystem
+}
+
+// validateSecurity checks the preset, ids, capabilities and seccomp
+// profile, and that the fields given don't contradict each other or the
+// preset.
+func (o Options) validateSecurity() error {
+	s := o.Security
+	switch s.Preset {
+	case "", PodSecurityRestricted:
+	default:
+		return &ValidationError{Field: "pod-security", Err: fmt.Errorf("must be %s, got %q", PodSecurityRestricted, s.Preset)}
+	}
+	ids := []struct {
+		field string
+		id    *int64
+	}{{"run-as-user", s.RunAsUser}, {"run-as-group", s.RunAsGroup}, {"fs-group", s.FSGroup}}
+	for _, id := range ids {
+		if id.id != nil && *id.id < 0 {
+			return &ValidationError{Field: id.field, Err: fmt.Errorf("must not be negative, got %d", *id.id)}
+		}
+	}
+	for _, c := range s.DropCapabilities {
+		if !capabilityName.MatchString(c) || strings.HasPrefix(c, "CAP_") {
+			return &ValidationError{Field: "drop-capability", Err: fmt.Errorf("%q is not a capability name such as NET_RAW or ALL", c)}
+		}
+	}
+	switch s.SeccompProfile {
+	case "", SeccompRuntimeDefault, SeccompUnconfined:
+	default:
+		return &ValidationError{Field: "seccomp-profile", Err: fmt.Errorf("must be %s or %s, got %q", SeccompRuntimeDefault, SeccompUnconfined, s.SeccompProfile)}
+	}
+
+	r := s.withPreset()
+	if r.RunAsNonRoot != nil && *r.RunAsNonRoot && r.RunAsUser != nil && *r.RunAsUser == 0 {
+		return &ValidationError{Field: "run-as-user", Err: errors.New("0 is root, which -run-as-non-root forbids")}
+	}
+	if s.Preset != PodSecurityRestricted {
+		return nil
+	}
+	switch {
+	case !*r.RunAsNonRoot:
+		return &ValidationError{Field: "run-as-non-root", Err: fmt.Errorf("must be on with -pod-security=%s", PodSecurityRestricted)}
+	case *r.AllowPrivilegeEscalation:
+		return &ValidationError{Field: "allow-privilege-escalation", Err: fmt.Errorf("must be off with -pod-security=%s", PodSecurityRestricted)}
+	case r.SeccompProfile == SeccompUnconfined:
+		return &ValidationError{Field: "seccomp-profile", Err: fmt.Errorf("must be %s with -pod-security=%s", SeccompRuntimeDefault, PodSecurityRestricted)}
+	}
+	for _, c := range r.DropCapabilities {
+		if c == "ALL" {
+			return nil
+		}
+	}
+	return &ValidationError{Field: "drop-capability", Err: fmt.Errorf("must include ALL with -pod-security=%s", PodSecurityRestricted)}
+}
+
+// podSecurityContext returns the pod-level part of s, nil when it sets
+// nothing.
+func (s Security) podSecurityContext(user, group, fsGroup *int64) *corev1.PodSecurityContext {
+	sc := &corev1.PodSecurityContext{RunAsNonRoot: s.RunAsNonRoot, RunAsUser: user, RunAsGroup: group, FSGroup: fsGroup}
+	if s.SeccompProfile != "" {
+		sc.SeccompProfile = &corev1.SeccompProfile{Type: corev1.SeccompProfileType(s.SeccompProfile)}
+	}
+	if sc.RunAsNonRoot == nil && user == nil && group == nil && fsGroup == nil && sc.SeccompProfile == nil {
+		return nil
+	}
+	return sc
+}
+
+// containerSecurityContext returns the container-level part of s, nil when
+// it sets nothing.
+func (s Security) containerSecurityContext(readOnlyRoot *bool) *corev1.SecurityContext {
+	sc := &corev1.SecurityContext{AllowPrivilegeEscalation: s.AllowPrivilegeEscalation, ReadOnlyRootFilesystem: readOnlyRoot}
+	for _, c := range s.DropCapabilities {
+		if sc.Capabilities == nil {
+			sc.Capabilities = &corev1.Capabilities{}
+		}
+		sc.Capabilities.Drop = append(sc.Capabilities.Drop, corev1.Capability(c))
+	}
+	if sc.AllowPrivilegeEscalation == nil && sc.ReadOnlyRootFilesystem == nil && sc.Capabilities == nil {
+		return nil
+	}
+	return sc
+}
+
+// wireSecurity sets the securityContext of the API pods and their
+// containers, mounting an emptyDir at /tmp when the root filesystem is
+// read-only. It runs last, so it covers every container of the pod but
+// the extra ones given their own.
+func wireSecurity(dep *appsv1.Deployment, opts Options) {
+	s := opts.Security.withPreset()
+	spec := &dep.Spec.Template.Spec
+	spec.SecurityContext = s.podSecurityContext(s.RunAsUser, s.RunAsGroup, s.FSGroup)
+	for _, containers := range [][]corev1.Container{spec.InitContainers, spec.Containers} {
+		for i := range containers {
+			if containers[i].SecurityContext == nil {
+				containers[i].SecurityContext = s.containerSecurityContext(s.ReadOnlyRootFilesystem)
+			}
+		}
+	}
+	if !opts.readOnlyRoot() {
+		return
+	}
+	spec.Volumes = append(spec.Volumes, corev1.Volume{Name: tmpVolumeName, VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}})
+	c := apiContainer(spec)
+	c.VolumeMounts = append(c.VolumeMounts, corev1.VolumeMount{Name: tmpVolumeName, MountPath: tmpMountPath})
+}
+
+// hardenDataPod sets the securityContext of the database or cache pod
+// spec. Their images need a writable root filesystem, and drop to their
+// own user rather than the API's when RunAsNonRoot is on.
+func hardenDataPod(spec *corev1.PodSpec, opts Options) {
+	s := opts.Security.withPreset()
+	var user *int64
+	if s.RunAsNonRoot != nil && *s.RunAsNonRoot {
+		id := int64(dataUser)
+		user = &id
+	}
+	spec.SecurityContext = s.podSecurityContext(user, user, user)
+	for i := range spec.Containers {
+		spec.Containers[i].SecurityContext = s.containerSecurityContext(nil)
+	}
+}
@@ -0,0 +1,173 @@
+package deployer
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"testing"
+)
+
+// checkRestricted checks spec passes the restricted Pod Security Standard,
+// as the apiserver's admission checks it, for every container of the pod.
+func checkRestricted(t *testing.T, ref string, spec corev1.PodSpec) {
+	t.Helper()
+	if spec.HostNetwork || spec.HostPID || spec.HostIPC {
+		t.Errorf("%s: shares a host namespace", ref)
+	}
+	for _, v := range spec.Volumes {
+		switch {
+		case v.ConfigMap != nil, v.CSI != nil, v.DownwardAPI != nil, v.EmptyDir != nil, v.Ephemeral != nil,
+			v.PersistentVolumeClaim != nil, v.Projected != nil, v.Secret != nil:
+		default:
+			t.Errorf("%s: volume %s is of a type the restricted profile forbids", ref, v.Name)
+		}
+	}
+	pod := spec.SecurityContext
+	if pod == nil {
+		pod = &corev1.PodSecurityContext{}
+	}
+	if pod.RunAsUser != nil && *pod.RunAsUser == 0 {
+		t.Errorf("%s: the pod runs as root", ref)
+	}
+	containers := append(append([]corev1.Container{}, spec.InitContainers...), spec.Containers...)
+	if len(containers) == 0 {
+		t.Fatalf("%s: no containers", ref)
+	}
+	for _, c := range containers {
+		cref := ref + " container " + c.Name
+		sc := c.SecurityContext
+		if sc == nil {
+			t.Errorf("%s: no securityContext", cref)
+			continue
+		}
+		if nonRoot := sc.RunAsNonRoot; !(nonRoot != nil && *nonRoot) && !(nonRoot == nil && pod.RunAsNonRoot != nil && *pod.RunAsNonRoot) {
+			t.Errorf("%s: runAsNonRoot isn't on", cref)
+		}
+		if sc.RunAsUser != nil && *sc.RunAsUser == 0 {
+			t.Errorf("%s: runs as root", cref)
+		}
+		if sc.AllowPrivilegeEscalation == nil || *sc.AllowPrivilegeEscalation {
+			t.Errorf("%s: allowPrivilegeEscalation isn't false", cref)
+		}
+		if sc.Privileged != nil && *sc.Privileged {
+			t.Errorf("%s: privileged", cref)
+		}
+		dropsAll := false
+		if sc.Capabilities != nil {
+			for _, c := range sc.Capabilities.Drop {
+				dropsAll = dropsAll || c == "ALL"
+			}
+			for _, c := range sc.Capabilities.Add {
+				if c != "NET_BIND_SERVICE" {
+					t.Errorf("%s: adds capability %s", cref, c)
+				}
+			}
+		}
+		if !dropsAll {
+			t.Errorf("%s: doesn't drop ALL capabilities", cref)
+		}
+		seccomp := pod.SeccompProfile
+		if sc.SeccompProfile != nil {
+			seccomp = sc.SeccompProfile
+		}
+		if seccomp == nil || (seccomp.Type != corev1.SeccompProfileTypeRuntimeDefault && seccomp.Type != corev1.SeccompProfileTypeLocalhost) {
+			t.Errorf("%s: has seccomp profile %v, want RuntimeDefault", cref, seccomp)
+		}
+		for _, p := range c.Ports {
+			if p.HostPort != 0 {
+				t.Errorf("%s: uses host port %d", cref, p.HostPort)
+			}
+		}
+	}
+}
+
+// restrictedOptions are options with the restricted preset and every pod
+// the deployer builds.
+func restrictedOptions() Options {
+	return Options{
+		Namespace:      "shop",
+		Security:       Security{Preset: PodSecurityRestricted},
+		InitContainers: []corev1.Container{{Name: "wait-for-db", Image: "busybox:1.36", Command: []string{"sh", "-c", "until nc -z postgres 5432; do sleep 1; done"}}},
+		Sidecars:       []corev1.Container{{Name: "log-shipper", Image: "fluent/fluent-bit:2.1"}},
+		Config:         map[string]string{"LOG_LEVEL": "debug"},
+		PVCs:           []PVC{{Name: "uploads", Size: "1Gi", MountPath: "/uploads"}},
+		Postgres:       true,
+		Redis:          true,
+		CronJobs:       []CronJob{{Name: "cleanup", Schedule: "0 * * * *", Command: "cleanup"}},
+		Migration:      &Migration{Command: "migrate up"},
+	}
+}
+
+func TestRestrictedPodSecurity(t *testing.T) {
+	opts := restrictedOptions()
+	objects, err := Render(opts)
+	if err != nil {
+		t.Fatalf("Render: %v", err)
+	}
+	pods := 0
+	for _, obj := range objects {
+		for _, path := range [][]string{{"spec", "template", "spec"}, {"spec", "jobTemplate", "spec", "template", "spec"}} {
+			podSpec, ok, _ := unstructured.NestedMap(obj.Object, path...)
+			if !ok {
+				continue
+			}
+			var spec corev1.PodSpec
+			if err := runtime.DefaultUnstructuredConverter.FromUnstructured(podSpec, &spec); err != nil {
+				t.Fatal(err)
+			}
+			checkRestricted(t, obj.GetKind()+"/"+obj.GetName(), spec)
+			pods++
+		}
+	}
+	// The API, database and cache workloads and the cron job.
+	if pods != 4 {
+		t.Errorf("checked %d pod specs, want 4", pods)
+	}
+
+	dep := BuildDeployment(opts)
+	if len(dep.Spec.Template.Spec.InitContainers) != 1 || len(dep.Spec.Template.Spec.Containers) != 2 {
+		t.Fatalf("got %d init containers and %d containers, want 1 and 2", len(dep.Spec.Template.Spec.InitContainers), len(dep.Spec.Template.Spec.Containers))
+	}
+	checkRestricted(t, "typed Deployment", dep.Spec.Template.Spec)
+	d, _ := newFakeDeployer(opts)
+	checkRestricted(t, "migration Job", d.migrationJob(dep, 1).Spec.Template.Spec)
+}
+
+func TestValidateSecurity(t *testing.T) {
+	root, user, negative, yes, no := int64(0), int64(1000), int64(-1), true, false
+	tests := []struct {
+		name      string
+		security  Security
+		wantField string
+	}{
+		{name: "none", security: Security{}},
+		{name: "restricted", security: Security{Preset: PodSecurityRestricted}},
+		{name: "restricted with a user", security: Security{Preset: PodSecurityRestricted, RunAsUser: &user}},
+		{name: "unknown preset", security: Security{Preset: "baseline"}, wantField: "pod-security"},
+		{name: "root with non-root", security: Security{RunAsNonRoot: &yes, RunAsUser: &root}, wantField: "run-as-user"},
+		{name: "root with restricted", security: Security{Preset: PodSecurityRestricted, RunAsUser: &root}, wantField: "run-as-user"},
+		{name: "root alone", security: Security{RunAsUser: &root}},
+		{name: "negative group", security: Security{RunAsGroup: &negative}, wantField: "run-as-group"},
+		{name: "restricted as root", security: Security{Preset: PodSecurityRestricted, RunAsNonRoot: &no}, wantField: "run-as-non-root"},
+		{name: "restricted with escalation", security: Security{Preset: PodSecurityRestricted, AllowPrivilegeEscalation: &yes}, wantField: "allow-privilege-escalation"},
+		{name: "restricted unconfined", security: Security{Preset: PodSecurityRestricted, SeccompProfile: SeccompUnconfined}, wantField: "seccomp-profile"},
+		{name: "restricted keeping capabilities", security: Security{Preset: PodSecurityRestricted, DropCapabilities: []string{"NET_RAW"}}, wantField: "drop-capability"},
+		{name: "capability with prefix", security: Security{DropCapabilities: []string{"CAP_NET_RAW"}}, wantField: "drop-capability"},
+		{name: "unknown seccomp profile", security: Security{SeccompProfile: "Localhost"}, wantField: "seccomp-profile"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Options{Security: tt.security}.validateSecurity()
+			if tt.wantField == "" {
+				if err != nil {
+					t.Fatalf("validateSecurity: %v", err)
+				}
+				return
+			}
+			verr, ok := err.(*ValidationError)
+			if !ok || verr.Field != tt.wantField {
+				t.Errorf("got %v, want a ValidationError of %s", err, tt.wantField)
+			}
+		})
+	}
+}
@@ -0,0 +1,220 @@
+package deployer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"time"
+)
+
+// DefaultServiceType is the type of server-svc when Options.ServiceType is
+// empty.
+const DefaultServiceType = "ClusterIP"
+
+// DefaultSessionAffinityTimeout is how long, in seconds, a client sticks to
+// its API pod with Options.SessionAffinity ClientIP when
+// Options.SessionAffinityTimeout is zero. It is the apiserver's default,
+// set explicitly so the applied spec matches the live one.
+const DefaultSessionAffinityTimeout = 10800
+
+// maxSessionAffinityTimeout is the longest timeout the apiserver accepts,
+// a day.
+const maxSessionAffinityTimeout = 86400
+
+// loadBalancerPollInterval is how often WaitForLoadBalancer checks the
+// service status.
+const loadBalancerPollInterval = 2 * time.Second
+
+// validateServiceType accepts the service types that make sense for the API.
+// ExternalName services have no pods behind them.
+func validateServiceType(t string) error {
+	switch t {
+	case "", "ClusterIP", "NodePort", "LoadBalancer":
+		return nil
+	}
+	return &ValidationError{Field: "service-type", Err: fmt.Errorf("must be ClusterIP, NodePort or LoadBalancer, got %q", t)}
+}
+
+// validateTrafficPolicy checks the session affinity and its timeout, and
+// that the external traffic policy has a NodePort or LoadBalancer service
+// to apply to.
+func (o Options) validateTrafficPolicy() error {
+	switch corev1.ServiceAffinity(o.SessionAffinity) {
+	case "", corev1.ServiceAffinityNone, corev1.ServiceAffinityClientIP:
+	default:
+		return &ValidationError{Field: "session-affinity", Err: fmt.Errorf("must be None or ClientIP, got %q", o.SessionAffinity)}
+	}
+	if o.SessionAffinityTimeout != 0 {
+		if o.SessionAffinity != string(corev1.ServiceAffinityClientIP) {
+			return &ValidationError{Field: "session-affinity-timeout", Err: errors.New("only applies with -session-affinity=ClientIP")}
+		}
+		if o.SessionAffinityTimeout < 1 || o.SessionAffinityTimeout > maxSessionAffinityTimeout {
+			return &ValidationError{Field: "session-affinity-timeout", Err: fmt.Errorf("must be between 1 and %d seconds, got %d", maxSessionAffinityTimeout, o.SessionAffinityTimeout)}
+		}
+	}
+	switch corev1.ServiceExternalTrafficPolicyType(o.ExternalTrafficPolicy) {
+	case "":
+		return nil
+	case corev1.ServiceExternalTrafficPolicyTypeCluster, corev1.ServiceExternalTrafficPolicyTypeLocal:
+	default:
+		return &ValidationError{Field: "external-traffic-policy", Err: fmt.Errorf("must be Cluster or Local, got %q", o.ExternalTrafficPolicy)}
+	}
+	if (o.ServiceType == "" || o.ServiceType == "ClusterIP") && !o.ExposeNodePort {
+		return &ValidationError{Field: "external-traffic-policy", Err: errors.New("is invalid on a ClusterIP service; pass -service-type=NodePort or LoadBalancer, or -expose-nodeport")}
+	}
+	return nil
+}
+
+// sessionAffinityTimeout returns the timeout of the ClientIP session
+// affinity.
+func (o Options) sessionAffinityTimeout() int32 {
+	if o.SessionAffinityTimeout == 0 {
+		return DefaultSessionAffinityTimeout
+	}
+	return o.SessionAffinityTimeout
+}
+
+// externalTrafficPolicy returns the external traffic policy of a service of
+// type t, empty for ClusterIP services, which have none.
+func (o Options) externalTrafficPolicy(t string) string {
+	if t == "" || t == "ClusterIP" {
+		return ""
+	}
+	return o.ExternalTrafficPolicy
+}
+
+// trafficPolicy sets the session affinity and external traffic policy of
+// the typed service spec.
+func trafficPolicy(spec *corev1.ServiceSpec, opts Options) {
+	spec.SessionAffinity = corev1.ServiceAffinity(opts.SessionAffinity)
+	if spec.SessionAffinity == corev1.ServiceAffinityClientIP {
+		timeout := opts.sessionAffinityTimeout()
+		spec.SessionAffinityConfig = &corev1.SessionAffinityConfig{ClientIP: &corev1.ClientIPConfig{TimeoutSeconds: &timeout}}
+	}
+	spec.ExternalTrafficPolicy = corev1.ServiceExternalTrafficPolicyType(opts.externalTrafficPolicy(string(spec.Type)))
+}
+
+// setTrafficPolicy sets the session affinity and external traffic policy of
+// the service obj rendered from an embedded manifest.
+func setTrafficPolicy(obj *unstructured.Unstructured, opts Options) {
+	if opts.SessionAffinity != "" {
+		_ = unstructured.SetNestedField(obj.Object, opts.SessionAffinity, "spec", "sessionAffinity")
+	}
+	if opts.SessionAffinity == string(corev1.ServiceAffinityClientIP) {
+		_ = unstructured.SetNestedField(obj.Object, int64(opts.sessionAffinityTimeout()), "spec", "sessionAffinityConfig", "clientIP", "timeoutSeconds")
+	}
+	t, _, _ := unstructured.NestedString(obj.Object, "spec", "type")
+	if policy := opts.externalTrafficPolicy(t); policy != "" {
+		_ = unstructured.SetNestedField(obj.Object, policy, "spec", "externalTrafficPolicy")
+	}
+}
+
+// CreateService creates or updates the server-svc service in front of the API
+// pods, of Options.ServiceType.
+func (d *Deployer) CreateService(ctx context.Context) (*unstructured.Unstructured, error) {
+	return d.apply(ctx, ServiceResource, newService(d.opts))
+}
+
+// CreateNodePortService creates or updates the nodeport-svc service exposing the
+// API on a node port.
+func (d *Deployer) CreateNodePortService(ctx context.Context) (*unstructured.Unstructured, error) {
+	return d.apply(ctx, ServiceResource, newNodePortService(d.opts))
+}
+
+func newService(opts Options) *unstructured.Unstructured {
+	obj := defaultManifest("service.yaml")
+	obj.SetName(opts.serviceName())
+	obj.SetNamespace(opts.Namespace)
+	_ = unstructured.SetNestedStringMap(obj.Object, opts.selector(componentAPI), "spec", "selector")
+	_ = unstructured.SetNestedField(obj.Object, opts.ServiceType, "spec", "type")
+	setServicePorts(obj, opts)
+	setNodePort(obj, opts)
+	setMetricsPort(obj, opts)
+	setTrafficPolicy(obj, opts)
+	if annotations := loadBalancerAnnotations(opts); annotations != nil {
+		obj.SetAnnotations(annotations)
+	}
+	return obj
+}
+
+func newNodePortService(opts Options) *unstructured.Unstructured {
+	obj := defaultManifest("nodeport-service.yaml")
+	obj.SetName(opts.nodePortName())
+	obj.SetNamespace(opts.Namespace)
+	_ = unstructured.SetNestedStringMap(obj.Object, opts.selector(componentAPI), "spec", "selector")
+	setServicePorts(obj, opts)
+	setNodePort(obj, opts)
+	setTrafficPolicy(obj, opts)
+	return obj
+}
+
+// newHeadlessService renders server-svc as the headless service, which
+// resolves to the addresses of the ready API pods rather than a cluster IP.
+func newHeadlessService(opts Options) *unstructured.Unstructured {
+	obj := defaultManifest("service.yaml")
+	obj.SetName(opts.headlessName())
+	obj.SetNamespace(opts.Namespace)
+	_ = unstructured.SetNestedStringMap(obj.Object, opts.selector(componentAPI), "spec", "selector")
+	_ = unstructured.SetNestedField(obj.Object, corev1.ClusterIPNone, "spec", "clusterIP")
+	setServicePorts(obj, opts)
+	return obj
+}
+
+func serviceType(svc *unstructured.Unstructured) string {
+	t, _, _ := unstructured.NestedString(svc.Object, "spec", "type")
+	if t == "" {
+		return "ClusterIP"
+	}
+	return t
+}
+
+// WaitForLoadBalancer polls server-svc until the cloud provider has assigned
+// it an external address and returns its IPs and hostnames. It returns nil
+// right away unless Options.ServiceType is LoadBalancer.
+func (d *Deployer) WaitForLoadBalancer(ctx context.Context, timeout time.Duration) ([]string, error) {
+	if d.opts.ServiceType != "LoadBalancer" {
+		return nil, nil
+	}
+	name := newService(d.opts).GetName()
+	d.log.Info("Waiting for load balancer", "namespace", d.opts.Namespace, "service", name, "timeout", timeout.String())
+
+	waitCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	var addresses []string
+	err := wait.PollImmediateUntilWithContext(waitCtx, loadBalancerPollInterval, func(ctx context.Context) (bool, error) {
+		svc, err := d.resource(ServiceResource).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return false, d.opError(OpGet, ServiceResource, name, err)
+		}
+		addresses = loadBalancerAddresses(svc)
+		return len(addresses) > 0, nil
+	})
+	if err != nil {
+		return nil, d.opError(OpWait, ServiceResource, name, fmt.Errorf("load balancer address: %w", err))
+	}
+	return addresses, nil
+}
+
+// loadBalancerAddresses returns the IPs and hostnames in a service's
+// status.loadBalancer.ingress.
+func loadBalancerAddresses(svc *unstructured.Unstructured) []string {
+	ingress, _, _ := unstructured.NestedSlice(svc.Object, "status", "loadBalancer", "ingress")
+	var addresses []string
+	for _, i := range ingress {
+		m, ok := i.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if ip, _, _ := unstructured.NestedString(m, "ip"); ip != "" {
+			addresses = append(addresses, ip)
+		}
+		if host, _, _ := unstructured.NestedString(m, "hostname"); host != "" {
+			addresses = append(addresses, host)
+		}
+	}
+	return addresses
+}
@@ -0,0 +1,222 @@
+package deployer
+
+import (
+	"errors"
+	"fmt"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"strings"
+)
+
+var (
+	// ServiceAccountResource is the GroupVersionResource of service accounts.
+	ServiceAccountResource = schema.GroupVersionResource{Version: "v1", Resource: "serviceaccounts"}
+	// RoleResource is the GroupVersionResource of roles.
+	RoleResource = schema.GroupVersionResource{Group: "rbac.authorization.k8s.io", Version: "v1", Resource: "roles"}
+	// RoleBindingResource is the GroupVersionResource of role bindings.
+	RoleBindingResource = schema.GroupVersionResource{Group: "rbac.authorization.k8s.io", Version: "v1", Resource: "rolebindings"}
+)
+
+// DefaultRBACRules lets the API read the config maps of its namespace. They
+// apply when Options.RBAC is set without Options.RBACRules.
+var DefaultRBACRules = []rbacv1.PolicyRule{{
+	APIGroups: []string{""},
+	Resources: []string{"configmaps"},
+	Verbs:     []string{"get", "list", "watch"},
+}}
+
+// ParseRBACRule parses the -rbac-rule flag format, verbs and resources as in
+// kubectl create role: "get,list:configmaps,deployments.apps". A resource's
+// API group follows its first dot. Like kubectl, it returns one rule per API
+// group, so no verb is granted on a resource in another group.
+func ParseRBACRule(s string) ([]rbacv1.PolicyRule, error) {
+	colon := strings.Index(s, ":")
+	if colon < 0 {
+		return nil, fmt.Errorf("%q must be in verbs:resources form", s)
+	}
+	verbs := strings.Split(s[:colon], ",")
+	var rules []rbacv1.PolicyRule
+	byGroup := map[string]int{}
+	for _, r := range strings.Split(s[colon+1:], ",") {
+		group := ""
+		if dot := strings.Index(r, "."); dot >= 0 {
+			r, group = r[:dot], r[dot+1:]
+		}
+		i, ok := byGroup[group]
+		if !ok {
+			i = len(rules)
+			byGroup[group] = i
+			rules = append(rules, rbacv1.PolicyRule{APIGroups: []string{group}, Verbs: verbs})
+		}
+		rules[i].Resources = append(rules[i].Resources, r)
+	}
+	return rules, nil
+}
+
+// validateRBAC checks that rules are only given with Options.RBAC and name
+// at least one verb and resource each.
+func (o Options) validateRBAC() error {
+	if len(o.RBACRules) > 0 && !o.RBAC {
+		return &ValidationError{Field: "rbac-rule", Err: errors.New("only applies with -rbac")}
+	}
+	for _, r := range o.RBACRules {
+		for _, v := range append(append([]string{}, r.Verbs...), r.Resources...) {
+			if v == "" {
+				return &ValidationError{Field: "rbac-rule", Err: fmt.Errorf("verbs %v on %v: empty verb or resource", r.Verbs, r.Resources)}
+			}
+		}
+	}
+	return nil
+}
+
+// rbacRules returns Options.RBACRules, or DefaultRBACRules when none are set.
+func (o Options) rbacRules() []rbacv1.PolicyRule {
+	if len(o.RBACRules) > 0 {
+		return o.RBACRules
+	}
+	return DefaultRBACRules
+}
+
+// newServiceAccount returns the ServiceAccount the API pods run as.
+func newServiceAccount(opts Options) *unstructured.Unstructured {
+	return &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "ServiceAccount",
+			"metadata": map[string]interface{}{
+				"name":      opts.serviceAccountName(),
+				"namespace": opts.Namespace,
+			},
+			"automountServiceAccountToken": opts.RBAC,
+		},
+	}
+}
+
+// BuildServiceAccount returns the ServiceAccount the API pods run as. Its
+// token is only mounted when Options.RBAC grants it permissions.
+func BuildServiceAccount(opts Options) *corev1.ServiceAccount {
+	opts = opts.withDefaults()
+	automount := opts.RBAC
+	return &corev1.ServiceAccount{
+		TypeMeta:                     metav1.TypeMeta{APIVersion: "v1", Kind: "ServiceAccount"},
+		ObjectMeta:                   metav1.ObjectMeta{Name: opts.serviceAccountName(), Namespace: opts.Namespace},
+		AutomountServiceAccountToken: &automount,
+	}
+}
+
+// newRole returns the Role granting the service account Options.RBACRules,
+// or nil when Options.RBAC is off.
+func newRole(opts Options) *unstructured.Unstructured {
+	if !opts.RBAC {
+		return nil
+	}
+	rules := make([]interface{}, 0, len(opts.rbacRules()))
+	for _, r := range opts.rbacRules() {
+		rule := map[string]interface{}{
+			"apiGroups": stringSlice(r.APIGroups),
+			"resources": stringSlice(r.Resources),
+			"verbs":     stringSlice(r.Verbs),
+		}
+		if len(r.ResourceNames) > 0 {
+			rule["resourceNames"] = stringSlice(r.ResourceNames)
+		}
+		rules = append(rules, rule)
+	}
+	return &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "rbac.authorization.k8s.io/v1",
+			"kind":       "Role",
+			"metadata": map[string]interface{}{
+				"name":      opts.serviceAccountName(),
+				"namespace": opts.Namespace,
+			},
+			"rules": rules,
+		},
+	}
+}
+
+// BuildRole returns the Role granting the service account
+// Options.RBACRules, or nil when Options.RBAC is off.
+func BuildRole(opts Options) *rbacv1.Role {
+	opts = opts.withDefaults()
+	if !opts.RBAC {
+		return nil
+	}
+	return &rbacv1.Role{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "Role"},
+		ObjectMeta: metav1.ObjectMeta{Name: opts.serviceAccountName(), Namespace: opts.Namespace},
+		Rules:      opts.rbacRules(),
+	}
+}
+
+// newRoleBinding returns the RoleBinding of the Role to the service account,
+// or nil when Options.RBAC is off.
+func newRoleBinding(opts Options) *unstructured.Unstructured {
+	if !opts.RBAC {
+		return nil
+	}
+	return &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "rbac.authorization.k8s.io/v1",
+			"kind":       "RoleBinding",
+			"metadata": map[string]interface{}{
+				"name":      opts.serviceAccountName(),
+				"namespace": opts.Namespace,
+			},
+			"roleRef": map[string]interface{}{
+				"apiGroup": rbacv1.GroupName,
+				"kind":     "Role",
+				"name":     opts.serviceAccountName(),
+			},
+			"subjects": []interface{}{
+				map[string]interface{}{
+					"kind":      rbacv1.ServiceAccountKind,
+					"name":      opts.serviceAccountName(),
+					"namespace": opts.Namespace,
+				},
+			},
+		},
+	}
+}
+
+// BuildRoleBinding returns the RoleBinding of the Role to the service
+// account, or nil when Options.RBAC is off.
+func BuildRoleBinding(opts Options) *rbacv1.RoleBinding {
+	opts = opts.withDefaults()
+	if !opts.RBAC {
+		return nil
+	}
+	return &rbacv1.RoleBinding{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "RoleBinding"},
+		ObjectMeta: metav1.ObjectMeta{Name: opts.serviceAccountName(), Namespace: opts.Namespace},
+		RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "Role", Name: opts.serviceAccountName()},
+		Subjects:   []rbacv1.Subject{{Kind: rbacv1.ServiceAccountKind, Name: opts.serviceAccountName(), Namespace: opts.Namespace}},
+	}
+}
+
+// wireServiceAccount runs the API pods as the service account.
+func wireServiceAccount(dep *appsv1.Deployment, opts Options) {
+	automount := opts.RBAC
+	dep.Spec.Template.Spec.ServiceAccountName = opts.serviceAccountName()
+	dep.Spec.Template.Spec.AutomountServiceAccountToken = &automount
+}
+
+func stringMap(m map[string]string) map[string]interface{} {
+	out := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		out[k] = v
+	}
+	return out
+}
+
+func stringSlice(s []string) []interface{} {
+	out := make([]interface{}, 0, len(s))
+	for _, v := range s {
+		out = append(out, v)
+	}
+	return out
+}
@@ -0,0 +1,478 @@
+package deployer
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DefaultRekorURL is the transparency log signatures are checked against
+// when SignatureVerification.RekorURL is empty.
+const DefaultRekorURL = "https://rekor.sigstore.dev"
+
+// The annotations recording on the API deployment the signature
+// VerifyImageSignature verified.
+const (
+	SignatureDigestAnnotation = "ecommerce-deployer/signature-digest"
+	SignatureSignerAnnotation = "ecommerce-deployer/signature-signer"
+)
+
+// The media types and annotations of a cosign signature manifest.
+const (
+	cosignPayloadMediaType      = "application/vnd.dev.cosign.simplesigning.v1+json"
+	cosignSignatureAnnotation   = "dev.cosignproject.cosign/signature"
+	cosignCertificateAnnotation = "dev.sigstore.cosign/certificate"
+	cosignChainAnnotation       = "dev.sigstore.cosign/chain"
+	cosignBundleAnnotation      = "dev.sigstore.cosign/bundle"
+)
+
+// The extensions of a Fulcio certificate naming the OIDC issuer of its
+// identity, the original one holding the raw string and its successor a
+// DER UTF8String.
+var (
+	fulcioIssuerV1 = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 1}
+	fulcioIssuerV2 = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 8}
+)
+
+// SignatureVerification configures VerifyImageSignature. Either PublicKey
+// or CertificateIdentity is set: the signature is checked against a cosign
+// key pair, or is a keyless one whose Fulcio certificate names the signer.
+type SignatureVerification struct {
+	// PublicKey is the PEM public key of cosign generate-key-pair, cosign.pub.
+	PublicKey []byte
+	// CertificateIdentity is the email or URI of the keyless signer, and
+	// CertificateOIDCIssuer the issuer that vouched for it, e.g.
+	// https://token.actions.githubusercontent.com.
+	CertificateIdentity   string
+	CertificateOIDCIssuer string
+	// FulcioRoots are the PEM certificates keyless certificates chain to.
+	FulcioRoots []byte
+	// RekorURL is the transparency log, or a mirror of it, whose public
+	// key checks the log entry of the signature; DefaultRekorURL when
+	// empty.
+	RekorURL string
+	// IgnoreTlog skips the transparency log, for air-gapped clusters
+	// without a mirror. Keyless certificates, valid for minutes, are then
+	// checked at the current time.
+	IgnoreTlog bool
+}
+
+// VerifiedSignature is the signature VerifyImageSignature verified, which
+// the API deployment records in SignatureDigestAnnotation and
+// SignatureSignerAnnotation.
+type VerifiedSignature struct {
+	// Digest is the digest of the signed image.
+	Digest string `json:"digest"`
+	// Signer is the identity of a keyless certificate, or sha256: and the
+	// fingerprint of the public key.
+	Signer string `json:"signer"`
+}
+
+// SignatureError is returned when an image has no signature that verifies.
+type SignatureError struct {
+	Image string
+	Err   error
+}
+
+func (e *SignatureError) Error() string {
+	return fmt.Sprintf("signature of %s not verified: %v", e.Image, e.Err)
+}
+
+func (e *SignatureError) Unwrap() error { return e.Err }
+
+// validate checks one way of verifying is given, with what it needs.
+func (v SignatureVerification) validate() error {
+	switch {
+	case len(v.PublicKey) > 0 && v.CertificateIdentity != "":
+		return &ValidationError{Field: "cosign-key", Err: errors.New("can't be combined with -certificate-identity")}
+	case len(v.PublicKey) == 0 && v.CertificateIdentity == "":
+		return &ValidationError{Field: "verify-signature", Err: errors.New("needs -cosign-key, or -certificate-identity for keyless signatures")}
+	case v.CertificateIdentity != "" && v.CertificateOIDCIssuer == "":
+		return &ValidationError{Field: "certificate-oidc-issuer", Err: errors.New("is needed with -certificate-identity")}
+	case v.CertificateIdentity != "" && len(v.FulcioRoots) == 0:
+		return &ValidationError{Field: "fulcio-root", Err: errors.New("is needed with -certificate-identity")}
+	case v.IgnoreTlog && v.RekorURL != "":
+		return &ValidationError{Field: "rekor-url", Err: errors.New("can't be combined with -insecure-ignore-tlog")}
+	}
+	return nil
+}
+
+// VerifyImageSignature checks the cosign signature of Options.Image in its
+// registry, pinning the image to its digest first as PinImageDigest does,
+// so the image deployed is the one verified. The signature must verify
+// against the key or certificate identity of v and, unless
+// SignatureVerification.IgnoreTlog, carry a transparency log entry the
+// log's key signed. The result is returned in Options.VerifiedSignature
+// for the API deployment to record.
+//
+// An image without a signature that verifies is returned as a
+// *SignatureError.
+func VerifyImageSignature(ctx context.Context, opts Options, v SignatureVerification) (Options, error) {
+	if err := v.validate(); err != nil {
+		return opts, err
+	}
+	opts, err := PinImageDigest(ctx, opts)
+	if err != nil {
+		return opts, err
+	}
+	image := opts.Image
+	if image == "" {
+		image = DefaultImage
+	}
+	ref, err := ParseImageReference(image)
+	if err != nil {
+		return opts, &ValidationError{Field: "image", Err: err}
+	}
+	c, err := newRegistryClient(opts, ref.Name)
+	if err != nil {
+		return opts, err
+	}
+
+	var rekorKey crypto.PublicKey
+	if !v.IgnoreTlog {
+		if rekorKey, err = fetchRekorKey(ctx, v.RekorURL); err != nil {
+			return opts, err
+		}
+	}
+	layers, err := c.signatureLayers(ctx, ref.Digest)
+	if err != nil {
+		return opts, &SignatureError{Image: image, Err: err}
+	}
+	var failures []string
+	for _, l := range layers {
+		signer, err := v.verify(ctx, c, ref.Digest, l, rekorKey)
+		if err == nil {
+			opts.VerifiedSignature = &VerifiedSignature{Digest: ref.Digest, Signer: signer}
+			opts.withDefaults().Logger.Info("Verified the image signature", "image", image, "signer", signer)
+			return opts, nil
+		}
+		failures = append(failures, err.Error())
+	}
+	if len(failures) == 0 {
+		return opts, &SignatureError{Image: image, Err: errors.New("the signature manifest has no cosign signature")}
+	}
+	return opts, &SignatureError{Image: image, Err: errors.New(strings.Join(failures, "; "))}
+}
+
+// signatureLayer is a layer of a cosign signature manifest.
+type signatureLayer struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Annotations map[string]string `json:"annotations"`
+}
+
+// signatureLayers returns the signatures of the image of digest, the
+// layers of the manifest cosign pushes to the sha256-<hex>.sig tag.
+func (c *registryClient) signatureLayers(ctx context.Context, digest string) ([]signatureLayer, error) {
+	tag := strings.Replace(digest, ":", "-", 1) + ".sig"
+	resp, body, err := c.do(ctx, http.MethodGet, "/manifests/"+tag, manifestMediaTypes[2:])
+	switch {
+	case err != nil:
+		return nil, fmt.Errorf("failed to get the signatures: %w", err)
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, errors.New("the image isn't signed, the registry has no " + tag)
+	case resp.StatusCode != http.StatusOK:
+		return nil, c.refused("the signatures", resp)
+	}
+	var manifest struct {
+		Layers []signatureLayer `json:"layers"`
+	}
+	if err := json.Unmarshal(body, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to decode the signature manifest %s: %w", tag, err)
+	}
+	var layers []signatureLayer
+	for _, l := range manifest.Layers {
+		if l.MediaType == cosignPayloadMediaType && l.Annotations[cosignSignatureAnnotation] != "" {
+			layers = append(layers, l)
+		}
+	}
+	return layers, nil
+}
+
+// verify checks the signature of layer l is one of v over a payload naming
+// the image of digest, and returns its signer.
+func (v SignatureVerification) verify(ctx context.Context, c *registryClient, digest string, l signatureLayer, rekorKey crypto.PublicKey) (string, error) {
+	resp, payload, err := c.do(ctx, http.MethodGet, "/blobs/"+l.Digest, nil)
+	switch {
+	case err != nil:
+		return "", fmt.Errorf("failed to get the signed payload: %w", err)
+	case resp.StatusCode != http.StatusOK:
+		return "", c.refused("the signed payload", resp)
+	}
+	if sum := sha256.Sum256(payload); "sha256:"+hex.EncodeToString(sum[:]) != l.Digest {
+		return "", fmt.Errorf("the signed payload doesn't match its digest %s", l.Digest)
+	}
+	var simpleSigning struct {
+		Critical struct {
+			Image struct {
+				DockerManifestDigest string `json:"docker-manifest-digest"`
+			} `json:"image"`
+		} `json:"critical"`
+	}
+	if err := json.Unmarshal(payload, &simpleSigning); err != nil {
+		return "", fmt.Errorf("failed to decode the signed payload: %w", err)
+	}
+	if signed := simpleSigning.Critical.Image.DockerManifestDigest; signed != digest {
+		return "", fmt.Errorf("the signature is of %s, not %s", signed, digest)
+	}
+	signature, err := base64.StdEncoding.DecodeString(l.Annotations[cosignSignatureAnnotation])
+	if err != nil {
+		return "", fmt.Errorf("malformed signature: %w", err)
+	}
+
+	signedAt := time.Now()
+	if rekorKey != nil {
+		if signedAt, err = verifyBundle(l.Annotations[cosignBundleAnnotation], rekorKey, payload, signature); err != nil {
+			return "", err
+		}
+	}
+	key, signer, err := v.signerKey(l, signedAt)
+	if err != nil {
+		return "", err
+	}
+	if err := verifySignature(key, payload, signature); err != nil {
+		return "", err
+	}
+	return signer, nil
+}
+
+// signerKey returns the key the signature must verify against and the
+// signer it identifies: the public key, or that of the layer's Fulcio
+// certificate once it chains to the roots, was valid at signedAt and names
+// the identity and issuer.
+func (v SignatureVerification) signerKey(l signatureLayer, signedAt time.Time) (crypto.PublicKey, string, error) {
+	if len(v.PublicKey) > 0 {
+		key, err := parsePublicKey(v.PublicKey)
+		if err != nil {
+			return nil, "", &ValidationError{Field: "cosign-key", Err: err}
+		}
+		der, err := x509.MarshalPKIXPublicKey(key)
+		if err != nil {
+			return nil, "", err
+		}
+		sum := sha256.Sum256(der)
+		return key, "sha256:" + hex.EncodeToString(sum[:]), nil
+	}
+
+	certs, err := parseCertificates([]byte(l.Annotations[cosignCertificateAnnotation]))
+	if err != nil || len(certs) == 0 {
+		return nil, "", errors.New("the signature has no certificate; is it a keyless one?")
+	}
+	cert := certs[0]
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(v.FulcioRoots) {
+		return nil, "", &ValidationError{Field: "fulcio-root", Err: errors.New("holds no PEM certificate")}
+	}
+	intermediates := x509.NewCertPool()
+	chain, _ := parseCertificates([]byte(l.Annotations[cosignChainAnnotation]))
+	for _, c := range chain {
+		intermediates.AddCert(c)
+	}
+	if _, err := cert.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   signedAt,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	}); err != nil {
+		return nil, "", fmt.Errorf("the certificate isn't trusted: %w", err)
+	}
+	identities := append([]string(nil), cert.EmailAddresses...)
+	for _, u := range cert.URIs {
+		identities = append(identities, u.String())
+	}
+	matched := false
+	for _, id := range identities {
+		matched = matched || id == v.CertificateIdentity
+	}
+	if !matched {
+		return nil, "", fmt.Errorf("the certificate is of %s, not %s", strings.Join(identities, ", "), v.CertificateIdentity)
+	}
+	if issuer := certificateIssuer(cert); issuer != v.CertificateOIDCIssuer {
+		return nil, "", fmt.Errorf("the certificate identity was issued by %q, not %s", issuer, v.CertificateOIDCIssuer)
+	}
+	return cert.PublicKey, v.CertificateIdentity, nil
+}
+
+// certificateIssuer returns the OIDC issuer a Fulcio certificate names.
+func certificateIssuer(cert *x509.Certificate) string {
+	for _, ext := range cert.Extensions {
+		switch {
+		case ext.Id.Equal(fulcioIssuerV2):
+			var issuer string
+			if _, err := asn1.Unmarshal(ext.Value, &issuer); err == nil {
+				return issuer
+			}
+		case ext.Id.Equal(fulcioIssuerV1):
+			return string(ext.Value)
+		}
+	}
+	return ""
+}
+
+// verifySignature checks signature is that of key over the SHA-256 of
+// payload, as cosign signs.
+func verifySignature(key crypto.PublicKey, payload, signature []byte) error {
+	sum := sha256.Sum256(payload)
+	ok := false
+	switch key := key.(type) {
+	case *ecdsa.PublicKey:
+		ok = ecdsa.VerifyASN1(key, sum[:], signature)
+	case *rsa.PublicKey:
+		ok = rsa.VerifyPKCS1v15(key, crypto.SHA256, sum[:], signature) == nil
+	case ed25519.PublicKey:
+		ok = ed25519.Verify(key, payload, signature)
+	default:
+		return fmt.Errorf("unsupported key type %T", key)
+	}
+	if !ok {
+		return errors.New("the signature doesn't verify against the key")
+	}
+	return nil
+}
+
+// rekorBundle is the transparency log entry cosign attaches to a
+// signature, signed by the log.
+type rekorBundle struct {
+	SignedEntryTimestamp []byte `json:"SignedEntryTimestamp"`
+	Payload              struct {
+		Body           string `json:"body"`
+		IntegratedTime int64  `json:"integratedTime"`
+		LogIndex       int64  `json:"logIndex"`
+		LogID          string `json:"logID"`
+	} `json:"Payload"`
+}
+
+// verifyBundle checks the transparency log signed the entry of bundle, and
+// that it logs signature over payload. It returns when the entry was
+// logged, the time keyless certificates are checked at.
+func verifyBundle(bundle string, rekorKey crypto.PublicKey, payload, signature []byte) (time.Time, error) {
+	if bundle == "" {
+		return time.Time{}, errors.New("the signature has no transparency log entry; pass -insecure-ignore-tlog to accept it")
+	}
+	var b rekorBundle
+	if err := json.Unmarshal([]byte(bundle), &b); err != nil {
+		return time.Time{}, fmt.Errorf("malformed transparency log bundle: %w", err)
+	}
+	// The log signs the canonical JSON of the entry: keys sorted, no
+	// spaces, as the struct encodes it.
+	canonical, err := json.Marshal(struct {
+		Body           string `json:"body"`
+		IntegratedTime int64  `json:"integratedTime"`
+		LogID          string `json:"logID"`
+		LogIndex       int64  `json:"logIndex"`
+	}{b.Payload.Body, b.Payload.IntegratedTime, b.Payload.LogID, b.Payload.LogIndex})
+	if err != nil {
+		return time.Time{}, err
+	}
+	der, err := x509.MarshalPKIXPublicKey(rekorKey)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if logID := sha256.Sum256(der); b.Payload.LogID != hex.EncodeToString(logID[:]) {
+		return time.Time{}, errors.New("the transparency log entry is of another log")
+	}
+	if err := verifySignature(rekorKey, canonical, b.SignedEntryTimestamp); err != nil {
+		return time.Time{}, errors.New("the transparency log entry isn't signed by the log")
+	}
+
+	body, err := base64.StdEncoding.DecodeString(b.Payload.Body)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("malformed transparency log entry: %w", err)
+	}
+	var entry struct {
+		Spec struct {
+			Data struct {
+				Hash struct {
+					Algorithm string `json:"algorithm"`
+					Value     string `json:"value"`
+				} `json:"hash"`
+			} `json:"data"`
+			Signature struct {
+				Content string `json:"content"`
+			} `json:"signature"`
+		} `json:"spec"`
+	}
+	if err := json.Unmarshal(body, &entry); err != nil {
+		return time.Time{}, fmt.Errorf("malformed transparency log entry: %w", err)
+	}
+	sum := sha256.Sum256(payload)
+	if h := entry.Spec.Data.Hash; h.Algorithm != "sha256" || h.Value != hex.EncodeToString(sum[:]) {
+		return time.Time{}, errors.New("the transparency log entry is of another payload")
+	}
+	if entry.Spec.Signature.Content != base64.StdEncoding.EncodeToString(signature) {
+		return time.Time{}, errors.New("the transparency log entry is of another signature")
+	}
+	return time.Unix(b.Payload.IntegratedTime, 0), nil
+}
+
+// fetchRekorKey returns the public key of the transparency log at
+// rekorURL, DefaultRekorURL when empty.
+func fetchRekorKey(ctx context.Context, rekorURL string) (crypto.PublicKey, error) {
+	if rekorURL == "" {
+		rekorURL = DefaultRekorURL
+	}
+	keyURL := strings.TrimSuffix(rekorURL, "/") + "/api/v1/log/publicKey"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, keyURL, nil)
+	if err != nil {
+		return nil, &ValidationError{Field: "rekor-url", Err: err}
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the transparency log key: %w; point -rekor-url at a mirror or pass -insecure-ignore-tlog", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the transparency log key: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to get the transparency log key: %s answered %s", keyURL, resp.Status)
+	}
+	key, err := parsePublicKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode the transparency log key of %s: %w", keyURL, err)
+	}
+	return key, nil
+}
+
+// parsePublicKey decodes a PEM PKIX public key.
+func parsePublicKey(data []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM public key found")
+	}
+	return x509.ParsePKIXPublicKey(block.Bytes)
+}
+
+// parseCertificates decodes the PEM certificates of data.
+func parseCertificates(data []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(bytes.TrimSpace(data))
+		if block == nil {
+			return certs, nil
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+}
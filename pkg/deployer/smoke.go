@@ -0,0 +1,233 @@
+package deployer
+
+import (
+	"context"
+	"fmt"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultSmokeTestTimeout bounds each smoke test request when
+	// SmokeTestOptions.Timeout is zero.
+	DefaultSmokeTestTimeout = 5 * time.Second
+	// DefaultSmokeTestWindow is how long failing smoke tests are retried
+	// when SmokeTestOptions.Window is zero.
+	DefaultSmokeTestWindow = time.Minute
+
+	// smokeTestInterval is how often a failing smoke test is retried.
+	smokeTestInterval = 2 * time.Second
+)
+
+// SmokeTest is a GET of Path the API must answer with the status Expect
+// once it is rolled out.
+type SmokeTest struct {
+	Path   string
+	Expect int
+}
+
+// ParseSmokeTest parses the -smoke-test format, path=/healthz,expect=200.
+// The expected status defaults to 200.
+func ParseSmokeTest(s string) (SmokeTest, error) {
+	fields, err := splitFields(s)
+	if err != nil {
+		return SmokeTest{}, err
+	}
+	t := SmokeTest{Expect: http.StatusOK}
+	for _, f := range fields {
+		switch f.key {
+		case "path":
+			t.Path = f.value
+		case "expect":
+			if t.Expect, err = strconv.Atoi(f.value); err != nil {
+				return SmokeTest{}, fmt.Errorf("%q: expect must be an HTTP status, got %q", s, f.value)
+			}
+		default:
+			return SmokeTest{}, fmt.Errorf("%q: unknown key %q, expected path or expect", s, f.key)
+		}
+	}
+	return t, nil
+}
+
+// SmokeTestOptions configures Deployer.SmokeTest.
+type SmokeTestOptions struct {
+	Tests []SmokeTest
+	// Timeout bounds each request; zero means DefaultSmokeTestTimeout.
+	Timeout time.Duration
+	// Window is how long failing tests are retried, as DNS and load
+	// balancers lag behind the rollout; zero means DefaultSmokeTestWindow.
+	Window time.Duration
+}
+
+// Validate checks each test has an absolute path and a valid status, and
+// that the durations aren't negative.
+func (o SmokeTestOptions) Validate() error {
+	for _, t := range o.Tests {
+		if !strings.HasPrefix(t.Path, "/") {
+			return &ValidationError{Field: "smoke-test", Err: fmt.Errorf("path must start with /, got %q", t.Path)}
+		}
+		if t.Expect < 100 || t.Expect > 599 {
+			return &ValidationError{Field: "smoke-test", Err: fmt.Errorf("%s: expect must be an HTTP status between 100 and 599, got %d", t.Path, t.Expect)}
+		}
+	}
+	switch {
+	case o.Timeout < 0:
+		return &ValidationError{Field: "smoke-test-timeout", Err: fmt.Errorf("must not be negative, got %s", o.Timeout)}
+	case o.Window < 0:
+		return &ValidationError{Field: "smoke-test-window", Err: fmt.Errorf("must not be negative, got %s", o.Window)}
+	}
+	return nil
+}
+
+// withDefaults returns o with the zero durations set to their defaults.
+func (o SmokeTestOptions) withDefaults() SmokeTestOptions {
+	if o.Timeout == 0 {
+		o.Timeout = DefaultSmokeTestTimeout
+	}
+	if o.Window == 0 {
+		o.Window = DefaultSmokeTestWindow
+	}
+	return o
+}
+
+// SmokeTestError is returned when a smoke test didn't pass within the
+// window.
+type SmokeTestError struct {
+	Test SmokeTest
+	URL  string
+	// Err is the last failure: the status answered or why there was no
+	// answer.
+	Err error
+}
+
+func (e *SmokeTestError) Error() string {
+	return fmt.Sprintf("smoke test of %s failed: %v", e.URL, e.Err)
+}
+
+func (e *SmokeTestError) Unwrap() error { return e.Err }
+
+// SmokeTest sends the tests to the rolled out API, through the ingress when
+// its host resolves, or else through a port forward to a ready API pod. A
+// failing test is retried until it passes or the window runs out, when it
+// is returned as a *SmokeTestError.
+func (d *Deployer) SmokeTest(ctx context.Context, opts SmokeTestOptions) error {
+	if len(opts.Tests) == 0 {
+		return nil
+	}
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+	opts = opts.withDefaults()
+	if base := d.ingressURL(ctx); base != "" {
+		return d.runSmokeTests(ctx, base, opts)
+	}
+	return d.smokeTestPods(ctx, opts, d.apiPods)
+}
+
+// ingressURL returns the scheme and first host of the ingress, or "" when
+// the release has no ingress or the host doesn't resolve.
+func (d *Deployer) ingressURL(ctx context.Context) string {
+	if d.opts.routing() != RoutingIngress || len(d.opts.Manifests) > 0 {
+		return ""
+	}
+	if version, err := d.servedIngressVersion(); err != nil || version == "" {
+		return ""
+	}
+	for _, h := range d.opts.Hosts {
+		if strings.HasPrefix(h, "*.") {
+			continue
+		}
+		if _, err := net.DefaultResolver.LookupHost(ctx, h); err != nil {
+			d.log.V(1).Info("The ingress host doesn't resolve, smoke testing through a port forward", "host", h, "reason", err.Error())
+			return ""
+		}
+		if d.opts.tlsSecretName() != "" {
+			return "https://" + h
+		}
+		return "http://" + h
+	}
+	return ""
+}
+
+// smokeTestPods runs the tests through a port forward to a ready pod of
+// those list returns, waiting for one within the window.
+func (d *Deployer) smokeTestPods(ctx context.Context, opts SmokeTestOptions, list func(context.Context) ([]corev1.Pod, error)) error {
+	forwardCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	urls := make(chan string, 1)
+	done := make(chan error, 1)
+	go func() {
+		done <- d.portForward(forwardCtx, PortForwardOptions{Ready: func(url, pod string) {
+			d.log.V(1).Info("Smoke testing through a port forward", "pod", pod, "url", url)
+			select {
+			case urls <- url:
+			default:
+			}
+		}}, list)
+	}()
+	timer := time.NewTimer(opts.Window)
+	defer timer.Stop()
+	select {
+	case url := <-urls:
+		err := d.runSmokeTests(ctx, url, opts)
+		cancel()
+		<-done
+		return err
+	case err := <-done:
+		if err == nil {
+			err = ctx.Err()
+		}
+		return err
+	case <-timer.C:
+		return fmt.Errorf("no API pod became ready to smoke test within %s", opts.Window)
+	}
+}
+
+// runSmokeTests sends each test to base until it passes, within one window
+// for them all.
+func (d *Deployer) runSmokeTests(ctx context.Context, base string, opts SmokeTestOptions) error {
+	windowCtx, cancel := context.WithTimeout(ctx, opts.Window)
+	defer cancel()
+	client := &http.Client{Timeout: opts.Timeout}
+	for _, t := range opts.Tests {
+		url := base + t.Path
+		var last error
+		err := wait.PollImmediateUntilWithContext(windowCtx, smokeTestInterval, func(ctx context.Context) (bool, error) {
+			last = smokeRequest(ctx, client, url, t.Expect)
+			if last != nil {
+				d.log.V(1).Info("Smoke test failed, retrying", "url", url, "reason", last.Error())
+			}
+			return last == nil, nil
+		})
+		if err != nil {
+			if last == nil {
+				last = err
+			}
+			return &SmokeTestError{Test: t, URL: url, Err: last}
+		}
+		d.log.Info("Smoke test passed", "url", url, "status", t.Expect)
+	}
+	return nil
+}
+
+// smokeRequest sends a GET of url and checks its status is expect.
+func smokeRequest(ctx context.Context, client *http.Client, url string, expect int) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != expect {
+		return fmt.Errorf("got status %d, expected %d", resp.StatusCode, expect)
+	}
+	return nil
+}
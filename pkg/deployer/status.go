@@ -0,0 +1,222 @@
+package deployer
+
+import (
+	"context"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// Status is the health of an install, as gathered by Deployer.Status.
+// Objects that don't exist are reported with Present false.
+type Status struct {
+	Namespace  string           `json:"namespace"`
+	Deployment DeploymentStatus `json:"deployment"`
+	// Canary is the canary deployment of DeployCanary, nil when there is
+	// none.
+	Canary *DeploymentStatus `json:"canary,omitempty"`
+	// Cache is the redis deployment of Options.Redis, nil without it.
+	Cache     *DeploymentStatus `json:"cache,omitempty"`
+	Services  []ServiceStatus   `json:"services"`
+	Ingresses []IngressStatus   `json:"ingresses"`
+	Routes    []RouteStatus     `json:"routes,omitempty"`
+	// OpenShiftRoutes are the Routes of Options.Routing route.
+	OpenShiftRoutes []OpenShiftRouteStatus `json:"openshiftRoutes,omitempty"`
+	CronJobs        []CronJobStatus        `json:"cronJobs,omitempty"`
+	// Extras are the objects of Options.ExtraManifests.
+	Extras []ObjectStatus `json:"extras,omitempty"`
+	Pods   []PodStatus    `json:"pods"`
+}
+
+// ObjectStatus is the presence of an object of Options.ExtraManifests.
+type ObjectStatus struct {
+	Kind string `json:"kind"`
+	// Namespace is empty for cluster-scoped objects.
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+	Present   bool   `json:"present"`
+}
+
+// DeploymentStatus is the rollout state of the API deployment.
+type DeploymentStatus struct {
+	Name          string `json:"name"`
+	Present       bool   `json:"present"`
+	RolloutStatus `json:",inline"`
+	// RolledOut reports whether the latest generation is fully rolled out.
+	RolledOut bool `json:"rolledOut"`
+}
+
+// ServiceStatus is the addressing of a service.
+type ServiceStatus struct {
+	Name      string  `json:"name"`
+	Present   bool    `json:"present"`
+	Type      string  `json:"type,omitempty"`
+	ClusterIP string  `json:"clusterIP,omitempty"`
+	NodePorts []int64 `json:"nodePorts,omitempty"`
+	// ExternalName is the host an ExternalName service resolves to.
+	ExternalName string `json:"externalName,omitempty"`
+	// LoadBalancer holds the addresses in status.loadBalancer.
+	LoadBalancer []string `json:"loadBalancer,omitempty"`
+}
+
+// IngressStatus is the routing of an ingress.
+type IngressStatus struct {
+	Name    string   `json:"name"`
+	Present bool     `json:"present"`
+	Hosts   []string `json:"hosts,omitempty"`
+	// LoadBalancer holds the addresses in status.loadBalancer, which the
+	// ingress controller fills once it serves the ingress.
+	LoadBalancer []string `json:"loadBalancer,omitempty"`
+}
+
+// CronJobStatus is the schedule and last run of a cron job.
+type CronJobStatus struct {
+	Name     string `json:"name"`
+	Present  bool   `json:"present"`
+	Schedule string `json:"schedule"`
+	Suspend  bool   `json:"suspend,omitempty"`
+	// Active is the number of jobs of the cron job still running.
+	Active int `json:"active"`
+	// LastScheduleTime is when a job was last started, nil before the
+	// first.
+	LastScheduleTime *metav1.Time `json:"lastScheduleTime,omitempty"`
+}
+
+// PodStatus is the phase and readiness of an API pod.
+type PodStatus struct {
+	Name     string `json:"name"`
+	Phase    string `json:"phase"`
+	Ready    bool   `json:"ready"`
+	Restarts int32  `json:"restarts"`
+	// Track is TrackStable or TrackCanary while a canary is deployed.
+	Track string `json:"track,omitempty"`
+}
+
+// Status gets the API deployment and its canary, the cache deployment, the
+// services, ingresses, HTTPRoutes, Routes and cron jobs, the objects of
+// Options.ExtraManifests, and the API pods of both tracks. A missing object is reported absent rather than failing the call;
+// other request errors are returned.
+func (d *Deployer) Status(ctx context.Context) (*Status, error) {
+	objects, err := d.objects()
+	if err != nil {
+		return nil, err
+	}
+	status := &Status{Namespace: d.opts.Namespace}
+	apiDep := apiDeployment(d.opts)
+	for _, o := range objects {
+		live, err := d.resourceFor(o.gvr, o.obj).Get(ctx, o.obj.GetName(), metav1.GetOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return nil, d.opError(OpGet, o.gvr, o.obj.GetName(), err)
+		}
+		present := err == nil
+		if o.extra {
+			status.Extras = append(status.Extras, ObjectStatus{Kind: o.obj.GetKind(), Namespace: o.obj.GetNamespace(), Name: o.obj.GetName(), Present: present})
+			continue
+		}
+		switch o.obj.GetKind() {
+		case "Deployment":
+			if d.opts.Redis && len(d.opts.Manifests) == 0 && o.obj.GetName() == d.opts.redisName() {
+				status.Cache = &DeploymentStatus{Name: o.obj.GetName(), Present: present}
+				if present {
+					status.Cache.RolloutStatus, status.Cache.RolledOut = rolloutProgress(live)
+				}
+				continue
+			}
+			if apiDep == nil || o.obj.GetName() != apiDep.GetName() {
+				continue
+			}
+			status.Deployment = DeploymentStatus{Name: o.obj.GetName(), Present: present}
+			if present {
+				status.Deployment.RolloutStatus, status.Deployment.RolledOut = rolloutProgress(live)
+			}
+		case "Service":
+			s := ServiceStatus{Name: o.obj.GetName(), Present: present}
+			if present {
+				s.Type = serviceType(live)
+				s.ClusterIP, _, _ = unstructured.NestedString(live.Object, "spec", "clusterIP")
+				s.NodePorts = NodePorts(live)
+				s.LoadBalancer = loadBalancerAddresses(live)
+				s.ExternalName, _, _ = unstructured.NestedString(live.Object, "spec", "externalName")
+			}
+			status.Services = append(status.Services, s)
+		case "Ingress":
+			i := IngressStatus{Name: o.obj.GetName(), Present: present, Hosts: ingressHosts(o.obj)}
+			if present {
+				i.Hosts = ingressHosts(live)
+				i.LoadBalancer = loadBalancerAddresses(live)
+			}
+			status.Ingresses = append(status.Ingresses, i)
+		case httpRouteGroupKind.Kind:
+			r := RouteStatus{Name: o.obj.GetName(), Present: present}
+			r.Hostnames, _, _ = unstructured.NestedStringSlice(o.obj.Object, "spec", "hostnames")
+			if present {
+				r.Parents = routeParents(live, d.opts.Namespace)
+			}
+			status.Routes = append(status.Routes, r)
+		case openShiftRouteGroupKind.Kind:
+			r := OpenShiftRouteStatus{Name: o.obj.GetName(), Present: present}
+			r.Host, _, _ = unstructured.NestedString(o.obj.Object, "spec", "host")
+			r.Path, _, _ = unstructured.NestedString(o.obj.Object, "spec", "path")
+			if present {
+				r.Routers, r.Message = routeRouters(live)
+			}
+			status.OpenShiftRoutes = append(status.OpenShiftRoutes, r)
+		case "CronJob":
+			c := CronJobStatus{Name: o.obj.GetName(), Present: present}
+			c.Schedule, _, _ = unstructured.NestedString(o.obj.Object, "spec", "schedule")
+			if present {
+				c.Suspend, _, _ = unstructured.NestedBool(live.Object, "spec", "suspend")
+				active, _, _ := unstructured.NestedSlice(live.Object, "status", "active")
+				c.Active = len(active)
+				if last, ok, _ := unstructured.NestedString(live.Object, "status", "lastScheduleTime"); ok {
+					var t metav1.Time
+					if t.UnmarshalQueryParameter(last) == nil {
+						c.LastScheduleTime = &t
+					}
+				}
+			}
+			status.CronJobs = append(status.CronJobs, c)
+		}
+	}
+	if !status.Deployment.Present {
+		return status, nil
+	}
+
+	pods, err := d.apiPods(ctx)
+	if err != nil {
+		return nil, err
+	}
+	canary, err := d.liveCanary(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if canary != nil {
+		status.Canary = &DeploymentStatus{Name: canary.GetName(), Present: true}
+		status.Canary.RolloutStatus, status.Canary.RolledOut = rolloutProgress(canary)
+		// Canary pods behind their own service aren't selected by the
+		// stable deployment.
+		canaryPods, err := d.selectedPods(ctx, canary)
+		if err != nil {
+			return nil, err
+		}
+		for _, pod := range canaryPods {
+			if pod.Labels[ComponentLabel] == componentCanary {
+				pods = append(pods, pod)
+			}
+		}
+	}
+	for _, pod := range pods {
+		p := PodStatus{Name: pod.Name, Phase: string(pod.Status.Phase), Ready: podReady(pod)}
+		for _, cs := range pod.Status.ContainerStatuses {
+			p.Restarts += cs.RestartCount
+		}
+		if canary != nil {
+			p.Track = TrackStable
+			if pod.Labels[TrackLabel] == TrackCanary {
+				p.Track = TrackCanary
+			}
+		}
+		status.Pods = append(status.Pods, p)
+	}
+	return status, nil
+}
@@ -0,0 +1,253 @@
+package deployer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"golang.org/x/sync/errgroup"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// DefaultMaxConcurrency is how many objects are applied at once when
+// Options.MaxConcurrency is zero.
+const DefaultMaxConcurrency = 4
+
+// The steps of applying the built-in objects. Each step only depends on the
+// ones before it, so the objects within a step are applied concurrently.
+const (
+	// stepOwner is the release record owning every other object.
+	stepOwner = iota
+	// stepConfig holds what the workloads use: secrets, config maps,
+	// RBAC, claims and network policies.
+	stepConfig
+	// stepWorkload holds the deployments, the StatefulSet, the cron jobs
+	// and the services in front of them, with their HPA and PDB.
+	stepWorkload
+	// stepMigrated holds the API deployment on its own when a migration Job
+	// runs before it.
+	stepMigrated
+	// stepRouting holds the ingress, HTTPRoute or Routes, and the services
+	// too when the API deployment owns them.
+	stepRouting
+	numSteps
+)
+
+// step returns the step o is applied in.
+func (d *Deployer) step(o object) int {
+	switch o.obj.GetKind() {
+	case "Deployment", "StatefulSet", "CronJob", "HorizontalPodAutoscaler", "PodDisruptionBudget":
+		if d.isMigrated(o) {
+			return stepMigrated
+		}
+		return stepWorkload
+	case "Service":
+		if d.opts.Owner == OwnerDeployment {
+			return stepRouting
+		}
+		return stepWorkload
+	case "Ingress", httpRouteGroupKind.Kind, openShiftRouteGroupKind.Kind, serviceMonitorGroupKind.Kind:
+		return stepRouting
+	case "ConfigMap":
+		if d.opts.Owner == OwnerRelease && o.obj.GetName() == d.opts.releaseRecordName() {
+			return stepOwner
+		}
+	}
+	return stepConfig
+}
+
+// steps groups the indexes of objects into the steps they are applied in.
+// Options.Manifests are applied one by one in the order of objects, as are
+// the built-in objects with a MaxConcurrency of 1. Options.ExtraManifests
+// come one by one after the built-in objects.
+func (d *Deployer) steps(objects []object) [][]int {
+	var (
+		steps  [][]int
+		extras []int
+		byStep [numSteps][]int
+	)
+	for i, o := range objects {
+		switch {
+		case len(d.opts.Manifests) > 0 || d.opts.MaxConcurrency == 1:
+			steps = append(steps, []int{i})
+		case o.extra:
+			extras = append(extras, i)
+		default:
+			s := d.step(o)
+			byStep[s] = append(byStep[s], i)
+		}
+	}
+	for _, step := range byStep {
+		if len(step) > 0 {
+			steps = append(steps, step)
+		}
+	}
+	for _, i := range extras {
+		steps = append(steps, []int{i})
+	}
+	return steps
+}
+
+// applySteps applies the objects deploy does, step by step. Within a step up
+// to Options.MaxConcurrency objects are applied at once, and the first
+// failure cancels the others; the steps after it aren't started. The
+// objects applied are returned in the order of objects, along with an
+// *ApplyError listing every failure and the objects left unapplied. The
+// migration Job of Options.Migration runs before the step applying the API
+// deployment, and its failure fails the step.
+func (d *Deployer) applySteps(ctx context.Context, objects []object, include func(object) bool, adjust func(*unstructured.Unstructured)) ([]*unstructured.Unstructured, error) {
+	var (
+		results = make([]*unstructured.Unstructured, len(objects))
+		errs    = make([]error, len(objects))
+		owner   = d.opts.appOwner
+		steps   = d.steps(objects)
+		failed  *ApplyError
+	)
+	limit := d.opts.MaxConcurrency
+	if limit == 0 {
+		limit = DefaultMaxConcurrency
+	}
+	for n, step := range steps {
+		g, gctx := errgroup.WithContext(ctx)
+		g.SetLimit(limit)
+		refs := make([]*metav1.OwnerReference, len(step))
+		var migrationErr error
+		for _, i := range step {
+			if d.isMigrated(objects[i]) && (include == nil || include(objects[i])) {
+				migrationErr = d.migrate(ctx, objects[i].obj)
+			}
+		}
+		for j, i := range step {
+			if migrationErr != nil {
+				// The API deployment stays as it was.
+				break
+			}
+			j, i := j, i
+			own := owner
+			g.Go(func() error {
+				results[i], refs[j], errs[i] = d.applyObject(gctx, objects[i], include, adjust, own)
+				return errs[i]
+			})
+		}
+		if g.Wait() == nil && migrationErr == nil {
+			for _, ref := range refs {
+				if ref != nil {
+					owner = ref
+				}
+			}
+			continue
+		}
+
+		failed = &ApplyError{}
+		if migrationErr != nil {
+			failed.Errs = append(failed.Errs, migrationErr)
+		}
+		for _, i := range step {
+			switch err := errs[i]; {
+			case migrationErr != nil:
+				if include == nil || include(objects[i]) {
+					failed.NotApplied = append(failed.NotApplied, objects[i].obj.GetKind()+"/"+objects[i].obj.GetName())
+				}
+			case err == nil:
+			case errors.Is(err, context.Canceled) && ctx.Err() == nil:
+				// Cancelled because a sibling failed.
+				failed.NotApplied = append(failed.NotApplied, objects[i].obj.GetKind()+"/"+objects[i].obj.GetName())
+			default:
+				failed.Errs = append(failed.Errs, err)
+			}
+		}
+		for _, later := range steps[n+1:] {
+			for _, i := range later {
+				if include == nil || include(objects[i]) {
+					failed.NotApplied = append(failed.NotApplied, objects[i].obj.GetKind()+"/"+objects[i].obj.GetName())
+				}
+			}
+		}
+		break
+	}
+
+	var applied []*unstructured.Unstructured
+	for _, obj := range results {
+		if obj != nil {
+			applied = append(applied, obj)
+		}
+	}
+	if failed != nil {
+		return applied, failed
+	}
+	return applied, nil
+}
+
+// applyObject applies o as deploy does, owned by owner, and returns it along
+// with the reference to it when it owns the others. An object include leaves
+// out is only looked up, for that reference. A CustomResourceDefinition is
+// waited for until it is established, so the custom resources after it
+// apply.
+func (d *Deployer) applyObject(ctx context.Context, o object, include func(object) bool, adjust func(*unstructured.Unstructured), owner *metav1.OwnerReference) (*unstructured.Unstructured, *metav1.OwnerReference, error) {
+	if include != nil && !include(o) {
+		live, err := d.resourceFor(o.gvr, o.obj).Get(ctx, o.obj.GetName(), metav1.GetOptions{})
+		switch {
+		case apierrors.IsNotFound(err):
+			return nil, nil, nil
+		case err != nil:
+			return nil, nil, d.opError(OpGet, o.gvr, o.obj.GetName(), err)
+		}
+		return nil, d.ownerRef(live), nil
+	}
+	if o.definedBy != "" && d.opts.DryRun {
+		d.log.Info("Not applying in a dry run, its CustomResourceDefinition isn't established", "kind", o.obj.GetKind(),
+			"name", o.obj.GetName(), "crd", o.definedBy)
+		return nil, nil, nil
+	}
+	obj, err := d.desired(ctx, o)
+	if err != nil {
+		return nil, nil, err
+	}
+	if adjust != nil {
+		adjust(obj)
+	}
+	d.setOwner(obj, owner)
+	obj, err = d.apply(ctx, o.gvr, obj)
+	if err != nil {
+		return nil, nil, err
+	}
+	if o.gvr.GroupResource() == CRDResource.GroupResource() && !d.opts.DryRun {
+		if obj, err = d.waitEstablished(ctx, obj); err != nil {
+			return nil, nil, err
+		}
+	}
+	return obj, d.ownerRef(obj), nil
+}
+
+// ApplyError is returned by DeployAll when objects failed to apply. It
+// unwraps to the first failure, so errors.As and the apierrors helpers see
+// it.
+type ApplyError struct {
+	// Errs are the failures, one per object.
+	Errs []error
+	// NotApplied lists the objects, as kind/name, that weren't applied
+	// because of the failures.
+	NotApplied []string
+}
+
+func (e *ApplyError) Error() string {
+	msg := ""
+	for i, err := range e.Errs {
+		if i > 0 {
+			msg += "; "
+		}
+		msg += err.Error()
+	}
+	if len(e.NotApplied) > 0 {
+		msg += fmt.Sprintf("; not applied: %v", e.NotApplied)
+	}
+	return msg
+}
+
+func (e *ApplyError) Unwrap() error {
+	if len(e.Errs) == 0 {
+		return nil
+	}
+	return e.Errs[0]
+}
@@ -0,0 +1,93 @@
+package deployer
+
+import (
+	"errors"
+	"fmt"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"strconv"
+	"strings"
+)
+
+// The values of Options.Strategy.
+const (
+	StrategyRollingUpdate = string(appsv1.RollingUpdateDeploymentStrategyType)
+	StrategyRecreate      = string(appsv1.RecreateDeploymentStrategyType)
+)
+
+// ParseIntOrPercent parses a pod count such as 1 or a percentage such as
+// 25%.
+func ParseIntOrPercent(s string) (*intstr.IntOrString, error) {
+	if strings.HasSuffix(s, "%") {
+		n, err := strconv.Atoi(strings.TrimSuffix(s, "%"))
+		if err != nil || n < 0 || n > 100 {
+			return nil, fmt.Errorf("%q must be a percentage between 0%% and 100%%", s)
+		}
+		v := intstr.FromString(s)
+		return &v, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("%q must be a pod count or a percentage", s)
+	}
+	v := intstr.FromInt(n)
+	return &v, nil
+}
+
+// validateStrategy checks Options.Strategy is known, that MaxSurge and
+// MaxUnavailable only come with rolling updates, and that they don't stop a
+// rolling update from making progress by both being zero. An unset one
+// takes the Kubernetes default of 25%.
+func (o Options) validateStrategy() error {
+	switch o.Strategy {
+	case "", StrategyRollingUpdate:
+	case StrategyRecreate:
+		if o.MaxSurge != nil || o.MaxUnavailable != nil {
+			return &ValidationError{Field: "strategy", Err: errors.New("max-surge and max-unavailable only apply to RollingUpdate")}
+		}
+		return nil
+	default:
+		return &ValidationError{Field: "strategy", Err: fmt.Errorf("must be %s or %s, got %q", StrategyRollingUpdate, StrategyRecreate, o.Strategy)}
+	}
+	for field, v := range map[string]*intstr.IntOrString{"max-surge": o.MaxSurge, "max-unavailable": o.MaxUnavailable} {
+		if v == nil {
+			continue
+		}
+		if _, err := intstr.GetScaledValueFromIntOrPercent(v, 100, true); err != nil {
+			return &ValidationError{Field: field, Err: err}
+		}
+	}
+	if isZero(o.MaxSurge) && isZero(o.MaxUnavailable) {
+		return &ValidationError{Field: "max-surge", Err: errors.New("max-surge and max-unavailable can't both be 0")}
+	}
+	return nil
+}
+
+// isZero reports whether v is set to 0 or 0%.
+func isZero(v *intstr.IntOrString) bool {
+	if v == nil {
+		return false
+	}
+	n, err := intstr.GetScaledValueFromIntOrPercent(v, 100, true)
+	return err == nil && n == 0
+}
+
+// wireStrategy sets the update strategy of the API deployment from
+// Options.Strategy, MaxSurge and MaxUnavailable. Without them the cluster
+// default, a rolling update of 25% each, applies.
+func wireStrategy(dep *appsv1.Deployment, opts Options) {
+	switch {
+	case opts.Strategy == StrategyRecreate:
+		dep.Spec.Strategy = appsv1.DeploymentStrategy{Type: appsv1.RecreateDeploymentStrategyType}
+	case opts.MaxSurge != nil || opts.MaxUnavailable != nil:
+		dep.Spec.Strategy = appsv1.DeploymentStrategy{
+			Type: appsv1.RollingUpdateDeploymentStrategyType,
+			RollingUpdate: &appsv1.RollingUpdateDeployment{
+				MaxSurge:       opts.MaxSurge,
+				MaxUnavailable: opts.MaxUnavailable,
+			},
+		}
+	case opts.Strategy == StrategyRollingUpdate:
+		dep.Spec.Strategy = appsv1.DeploymentStrategy{Type: appsv1.RollingUpdateDeploymentStrategyType}
+	}
+}
@@ -0,0 +1,162 @@
+package deployer
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// DefaultTLSSecret is the name of the TLS secret created from Options.TLSCert
+// and Options.TLSKey when Options.TLSSecret is empty, <release>-tls for
+// releases other than DefaultRelease.
+const DefaultTLSSecret = "server-tls"
+
+// SecretResource is the GroupVersionResource of secrets.
+var SecretResource = schema.GroupVersionResource{Version: "v1", Resource: "secrets"}
+
+// tlsSecretName returns the secret the ingress terminates TLS with, or "" when
+// TLS is off.
+func (o Options) tlsSecretName() string {
+	switch {
+	case o.TLSSecret != "":
+		return o.TLSSecret
+	case len(o.TLSCert) > 0, o.CertManagerIssuer != "":
+		return o.defaultTLSSecret()
+	}
+	return ""
+}
+
+// validateTLS checks that a certificate and key are given together and form
+// a valid pair.
+func (o Options) validateTLS() error {
+	if o.CertManagerIssuer != "" && len(o.TLSCert) > 0 {
+		return &ValidationError{Field: "cert-manager-issuer", Err: errors.New("cert-manager issues the certificate itself; drop the certificate and key")}
+	}
+	if (len(o.TLSCert) > 0) != (len(o.TLSKey) > 0) {
+		return &ValidationError{Field: "tls-cert", Err: errors.New("a certificate and a key must be given together")}
+	}
+	if len(o.TLSCert) == 0 {
+		return nil
+	}
+	if _, err := tls.X509KeyPair(o.TLSCert, o.TLSKey); err != nil {
+		return &ValidationError{Field: "tls-cert", Err: err}
+	}
+	return nil
+}
+
+// newTLSSecret returns the kubernetes.io/tls secret holding Options.TLSCert
+// and Options.TLSKey, or nil when they aren't set.
+func newTLSSecret(opts Options) *unstructured.Unstructured {
+	if len(opts.TLSCert) == 0 {
+		return nil
+	}
+	return &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "Secret",
+			"metadata": map[string]interface{}{
+				"name":      opts.tlsSecretName(),
+				"namespace": opts.Namespace,
+			},
+			"type": string(corev1.SecretTypeTLS),
+			"data": map[string]interface{}{
+				corev1.TLSCertKey:       base64.StdEncoding.EncodeToString(opts.TLSCert),
+				corev1.TLSPrivateKeyKey: base64.StdEncoding.EncodeToString(opts.TLSKey),
+			},
+		},
+	}
+}
+
+// BuildTLSSecret returns the kubernetes.io/tls secret created from
+// Options.TLSCert and Options.TLSKey, or nil when they aren't set.
+func BuildTLSSecret(opts Options) *corev1.Secret {
+	opts = opts.withDefaults()
+	if len(opts.TLSCert) == 0 {
+		return nil
+	}
+	return &corev1.Secret{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+		ObjectMeta: metav1.ObjectMeta{Name: opts.tlsSecretName(), Namespace: opts.Namespace},
+		Type:       corev1.SecretTypeTLS,
+		Data: map[string][]byte{
+			corev1.TLSCertKey:       opts.TLSCert,
+			corev1.TLSPrivateKeyKey: opts.TLSKey,
+		},
+	}
+}
+
+// ingressTLS returns the spec.tls block of the ingress for hosts, or nil when
+// TLS is off.
+func ingressTLS(opts Options, hosts []string) []interface{} {
+	secret := opts.tlsSecretName()
+	if secret == "" {
+		return nil
+	}
+	tlsHosts := make([]interface{}, 0, len(hosts))
+	for _, h := range hosts {
+		tlsHosts = append(tlsHosts, h)
+	}
+	return []interface{}{
+		map[string]interface{}{
+			"hosts":      tlsHosts,
+			"secretName": secret,
+		},
+	}
+}
+
+// ingressHosts returns the hosts the rules of an ingress match.
+func ingressHosts(obj *unstructured.Unstructured) []string {
+	rules, _, _ := unstructured.NestedSlice(obj.Object, "spec", "rules")
+	var hosts []string
+	for _, r := range rules {
+		if host, _, _ := unstructured.NestedString(r.(map[string]interface{}), "host"); host != "" {
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
+}
+
+// uncoveredHosts returns the hosts the leaf certificate in certPEM isn't
+// valid for.
+func uncoveredHosts(certPEM []byte, hosts []string) ([]string, error) {
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		return nil, errors.New("no PEM certificate found")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate: %w", err)
+	}
+	var uncovered []string
+	for _, h := range hosts {
+		if cert.VerifyHostname(h) != nil {
+			uncovered = append(uncovered, h)
+		}
+	}
+	return uncovered, nil
+}
+
+// warnUncoveredHosts logs the ingress hosts the configured certificate
+// doesn't cover. Browsers reject those hosts, but the certificate may be
+// about to be replaced, so it isn't an error.
+func (d *Deployer) warnUncoveredHosts() {
+	if len(d.opts.TLSCert) == 0 {
+		return
+	}
+	hosts := ingressHosts(newIngress(d.opts))
+	uncovered, err := uncoveredHosts(d.opts.TLSCert, hosts)
+	if err != nil {
+		d.log.Error(err, "Couldn't check the hosts of the TLS certificate")
+		return
+	}
+	if len(uncovered) > 0 {
+		d.log.Info("Warning: the TLS certificate doesn't cover every ingress host", "uncovered", uncovered)
+	}
+}
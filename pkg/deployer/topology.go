@@ -0,0 +1,129 @@
+package deployer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"strconv"
+	"strings"
+)
+
+// zoneTopologyKey is the node label of the zone -topology-spread zone=N
+// spreads over; hostname=N uses hostnameTopologyKey.
+const zoneTopologyKey = "topology.kubernetes.io/zone"
+
+// topologyKeys are the short names ParseTopologySpread takes for the
+// well-known node labels.
+var topologyKeys = map[string]string{"zone": zoneTopologyKey, "hostname": hostnameTopologyKey}
+
+// ParseTopologySpread parses the -topology-spread format, key=maxSkew with an
+// optional :DoNotSchedule or :ScheduleAnyway, the default. The key is zone,
+// hostname or a node label. The constraint's label selector is left for
+// the builders, which select the API pods of the release.
+func ParseTopologySpread(s string) (corev1.TopologySpreadConstraint, error) {
+	c := corev1.TopologySpreadConstraint{WhenUnsatisfiable: corev1.ScheduleAnyway}
+	eq := strings.Index(s, "=")
+	if eq < 0 {
+		return corev1.TopologySpreadConstraint{}, fmt.Errorf("%q must be in key=maxSkew[:DoNotSchedule] form", s)
+	}
+	key, skew := s[:eq], s[eq+1:]
+	if colon := strings.Index(skew, ":"); colon >= 0 {
+		skew, c.WhenUnsatisfiable = skew[:colon], corev1.UnsatisfiableConstraintAction(skew[colon+1:])
+	}
+	c.TopologyKey = key
+	if full, ok := topologyKeys[key]; ok {
+		c.TopologyKey = full
+	}
+	n, err := strconv.ParseInt(skew, 10, 32)
+	if err != nil {
+		return corev1.TopologySpreadConstraint{}, fmt.Errorf("%q: maxSkew must be a number, got %q", s, skew)
+	}
+	c.MaxSkew = int32(n)
+	return c, nil
+}
+
+// validateTopologySpread checks each constraint has a maxSkew of at least 1,
+// a node label key used once, and a known action. A hostname constraint is
+// rejected with Options.PodAntiAffinity, which already spreads over the
+// nodes.
+func (o Options) validateTopologySpread() error {
+	keys := map[string]bool{}
+	for _, c := range o.TopologySpread {
+		if errs := validation.IsQualifiedName(c.TopologyKey); len(errs) > 0 {
+			return &ValidationError{Field: "topology-spread", Err: fmt.Errorf("key %q: %s", c.TopologyKey, strings.Join(errs, "; "))}
+		}
+		if keys[c.TopologyKey] {
+			return &ValidationError{Field: "topology-spread", Err: fmt.Errorf("%s is spread over twice", c.TopologyKey)}
+		}
+		keys[c.TopologyKey] = true
+		if c.MaxSkew < 1 {
+			return &ValidationError{Field: "topology-spread", Err: fmt.Errorf("%s: maxSkew must be at least 1, got %d", c.TopologyKey, c.MaxSkew)}
+		}
+		switch c.WhenUnsatisfiable {
+		case corev1.DoNotSchedule, corev1.ScheduleAnyway:
+		default:
+			return &ValidationError{Field: "topology-spread", Err: fmt.Errorf("%s: must be DoNotSchedule or ScheduleAnyway, got %q", c.TopologyKey, c.WhenUnsatisfiable)}
+		}
+		if c.TopologyKey == hostnameTopologyKey && o.PodAntiAffinity != "" {
+			return &ValidationError{Field: "topology-spread", Err: errors.New("-pod-anti-affinity already spreads the pods over the nodes; keep one of them")}
+		}
+	}
+	return nil
+}
+
+// wireTopologySpread adds the spread constraints to the API pods, each
+// selecting the API pods of the release as the anti-affinity does.
+func wireTopologySpread(dep *appsv1.Deployment, opts Options) {
+	spec := &dep.Spec.Template.Spec
+	spec.TopologySpreadConstraints = nil
+	for _, c := range opts.TopologySpread {
+		if c.LabelSelector == nil {
+			c.LabelSelector = &metav1.LabelSelector{MatchLabels: opts.selector(componentAPI)}
+		}
+		spec.TopologySpreadConstraints = append(spec.TopologySpreadConstraints, c)
+	}
+}
+
+// warnTopologySpread logs, for each DoNotSchedule constraint, when no node
+// has its label, so the pods stay Pending, or when there are fewer replicas
+// than domains, so some zones are left without an API pod. Nodes it can't
+// list are left unchecked.
+func (d *Deployer) warnTopologySpread(ctx context.Context) {
+	if len(d.opts.Manifests) > 0 {
+		return
+	}
+	var strict []corev1.TopologySpreadConstraint
+	for _, c := range d.opts.TopologySpread {
+		if c.WhenUnsatisfiable == corev1.DoNotSchedule {
+			strict = append(strict, c)
+		}
+	}
+	if len(strict) == 0 {
+		return
+	}
+	nodes, err := d.client.Resource(NodeResource).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		d.log.V(1).Info("Couldn't list the nodes to check the topology spread", "reason", err.Error())
+		return
+	}
+	replicas := d.opts.pdbReplicas()
+	for _, c := range strict {
+		domains := map[string]bool{}
+		for _, node := range nodes.Items {
+			if value, ok := node.GetLabels()[c.TopologyKey]; ok {
+				domains[value] = true
+			}
+		}
+		switch {
+		case len(domains) == 0:
+			d.log.Info("Warning: no node has the topology spread label, so the API pods can't be scheduled", "key", c.TopologyKey)
+		case int(replicas) < len(domains):
+			d.log.Info("Warning: there are fewer replicas than topology domains, so some get no API pod",
+				"key", c.TopologyKey, "replicas", replicas, "domains", len(domains))
+		}
+	}
+}
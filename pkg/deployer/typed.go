@@ -0,0 +1,410 @@
+package deployer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// errNoClientset is returned when Options.Typed is set on a Deployer built
+// without a clientset.
+var errNoClientset = errors.New("typed clients need a clientset; build the Deployer with NewWithClientset or NewForConfig")
+
+// builder produces the built-in objects. yamlBuilder renders the embedded
+// manifests and typedBuilder the Build* structs; both yield the same specs.
+type builder interface {
+	build(opts Options) []*unstructured.Unstructured
+}
+
+type yamlBuilder struct{}
+
+func (yamlBuilder) build(opts Options) []*unstructured.Unstructured {
+	var objects []*unstructured.Unstructured
+	if record := newReleaseRecord(opts); record != nil {
+		objects = append(objects, record)
+	}
+	if secret := newTLSSecret(opts); secret != nil {
+		objects = append(objects, secret)
+	}
+	if secret := newDBSecret(opts); secret != nil {
+		objects = append(objects, secret)
+	}
+	if secret := newRegistrySecret(opts); secret != nil {
+		objects = append(objects, secret)
+	}
+	if cm := newConfigMap(opts); cm != nil {
+		objects = append(objects, cm)
+	}
+	for _, p := range opts.PVCs {
+		objects = append(objects, newPVC(opts, p))
+	}
+	objects = append(objects, newServiceAccount(opts))
+	if opts.RBAC {
+		objects = append(objects, newRole(opts), newRoleBinding(opts))
+	}
+	if opts.Postgres {
+		objects = append(objects, newPostgresSecret(opts), newPostgresService(opts), newPostgresStatefulSet(opts))
+	}
+	if opts.Redis {
+		if secret := newRedisSecret(opts); secret != nil {
+			objects = append(objects, secret)
+		}
+		objects = append(objects, newRedisService(opts), newRedisDeployment(opts))
+	}
+	objects = append(objects, newDeployment(opts), newService(opts))
+	for _, c := range opts.CronJobs {
+		objects = append(objects, newCronJob(opts, c))
+	}
+	if hpa := newHPA(opts); hpa != nil {
+		objects = append(objects, hpa)
+	}
+	if pdb := newPDB(opts); pdb != nil {
+		objects = append(objects, pdb)
+	}
+	if policy := newNetworkPolicy(opts); policy != nil {
+		objects = append(objects, policy)
+	}
+	if opts.ExposeNodePort {
+		objects = append(objects, newNodePortService(opts))
+	}
+	if opts.HeadlessService {
+		objects = append(objects, newHeadlessService(opts))
+	}
+	for _, s := range opts.ExternalNameServices {
+		objects = append(objects, newExternalNameService(opts, s))
+	}
+	if sm := newServiceMonitor(opts); sm != nil {
+		objects = append(objects, sm)
+	}
+	if route := newHTTPRoute(opts); route != nil {
+		return append(objects, route)
+	}
+	if routes := newOpenShiftRoutes(opts); routes != nil {
+		return append(objects, routes...)
+	}
+	return append(objects, newIngress(opts))
+}
+
+type typedBuilder struct{}
+
+func (typedBuilder) build(opts Options) []*unstructured.Unstructured {
+	var objects []*unstructured.Unstructured
+	if record := BuildReleaseRecord(opts); record != nil {
+		objects = append(objects, mustToUnstructured(record))
+	}
+	if secret := BuildTLSSecret(opts); secret != nil {
+		objects = append(objects, mustToUnstructured(secret))
+	}
+	if secret := BuildDBSecret(opts); secret != nil {
+		objects = append(objects, mustToUnstructured(secret))
+	}
+	if secret := BuildRegistrySecret(opts); secret != nil {
+		objects = append(objects, mustToUnstructured(secret))
+	}
+	if cm := BuildConfigMap(opts); cm != nil {
+		objects = append(objects, mustToUnstructured(cm))
+	}
+	for _, p := range opts.PVCs {
+		objects = append(objects, mustToUnstructured(BuildPVC(opts, p)))
+	}
+	objects = append(objects, mustToUnstructured(BuildServiceAccount(opts)))
+	if opts.RBAC {
+		objects = append(objects, mustToUnstructured(BuildRole(opts)), mustToUnstructured(BuildRoleBinding(opts)))
+	}
+	if opts.Postgres {
+		objects = append(objects, mustToUnstructured(BuildPostgresSecret(opts)), mustToUnstructured(BuildPostgresService(opts)),
+			mustToUnstructured(BuildPostgresStatefulSet(opts)))
+	}
+	if opts.Redis {
+		if secret := BuildRedisSecret(opts); secret != nil {
+			objects = append(objects, mustToUnstructured(secret))
+		}
+		objects = append(objects, mustToUnstructured(BuildRedisService(opts)), mustToUnstructured(BuildRedisDeployment(opts)))
+	}
+	objects = append(objects, mustToUnstructured(BuildDeployment(opts)), mustToUnstructured(BuildService(opts)))
+	for _, c := range opts.CronJobs {
+		objects = append(objects, mustToUnstructured(BuildCronJob(opts, c)))
+	}
+	if hpa := BuildHPA(opts); hpa != nil {
+		objects = append(objects, mustToUnstructured(hpa))
+	}
+	if pdb := BuildPDB(opts); pdb != nil {
+		objects = append(objects, mustToUnstructured(pdb))
+	}
+	if policy := BuildNetworkPolicy(opts); policy != nil {
+		objects = append(objects, mustToUnstructured(policy))
+	}
+	if opts.ExposeNodePort {
+		objects = append(objects, mustToUnstructured(BuildNodePortService(opts)))
+	}
+	if opts.HeadlessService {
+		objects = append(objects, mustToUnstructured(BuildHeadlessService(opts)))
+	}
+	for _, s := range opts.ExternalNameServices {
+		objects = append(objects, mustToUnstructured(BuildExternalNameService(opts, s)))
+	}
+	if sm := newServiceMonitor(opts); sm != nil {
+		objects = append(objects, sm)
+	}
+	if route := newHTTPRoute(opts); route != nil {
+		return append(objects, route)
+	}
+	if routes := newOpenShiftRoutes(opts); routes != nil {
+		return append(objects, routes...)
+	}
+	return append(objects, mustToUnstructured(BuildIngress(opts)))
+}
+
+// builderFor picks the builder selected by opts.
+func builderFor(opts Options) builder {
+	if opts.Typed {
+		return typedBuilder{}
+	}
+	return yamlBuilder{}
+}
+
+// BuildDeployment returns the apiserver deployment running the ecommerce API.
+// Empty fields of opts take their defaults.
+func BuildDeployment(opts Options) *appsv1.Deployment {
+	opts = opts.withDefaults()
+	dep := &appsv1.Deployment{
+		TypeMeta: metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+		Spec: appsv1.DeploymentSpec{
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{Name: apiContainerName}},
+				},
+			},
+		},
+	}
+	customizeDeployment(dep, opts)
+	return dep
+}
+
+// BuildService returns the server-svc service of Options.ServiceType in
+// front of the API pods.
+func BuildService(opts Options) *corev1.Service {
+	opts = opts.withDefaults()
+	var nodePort int32
+	if opts.nodePortService() == opts.serviceName() {
+		nodePort = opts.NodePort
+	}
+	svc := &corev1.Service{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Service"},
+		ObjectMeta: metav1.ObjectMeta{Name: opts.serviceName(), Namespace: opts.Namespace, Annotations: loadBalancerAnnotations(opts)},
+		Spec: corev1.ServiceSpec{
+			Selector: opts.selector(componentAPI),
+			Type:     corev1.ServiceType(opts.ServiceType),
+			Ports:    metricsServicePorts(opts, servicePorts(opts, nodePort)),
+		},
+	}
+	trafficPolicy(&svc.Spec, opts)
+	return svc
+}
+
+// BuildNodePortService returns the nodeport-svc service exposing the API on a
+// node port, Options.NodePort or one the apiserver allocates.
+func BuildNodePortService(opts Options) *corev1.Service {
+	opts = opts.withDefaults()
+	var nodePort int32
+	if opts.nodePortService() == opts.nodePortName() {
+		nodePort = opts.NodePort
+	}
+	svc := &corev1.Service{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Service"},
+		ObjectMeta: metav1.ObjectMeta{Name: opts.nodePortName(), Namespace: opts.Namespace},
+		Spec: corev1.ServiceSpec{
+			Selector: opts.selector(componentAPI),
+			Type:     corev1.ServiceTypeNodePort,
+			Ports:    servicePorts(opts, nodePort),
+		},
+	}
+	trafficPolicy(&svc.Spec, opts)
+	return svc
+}
+
+// BuildHeadlessService returns the headless service of the API pods, with
+// clusterIP None, for clients balancing over the pod addresses themselves.
+func BuildHeadlessService(opts Options) *corev1.Service {
+	opts = opts.withDefaults()
+	return &corev1.Service{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Service"},
+		ObjectMeta: metav1.ObjectMeta{Name: opts.headlessName(), Namespace: opts.Namespace},
+		Spec: corev1.ServiceSpec{
+			Selector:  opts.selector(componentAPI),
+			ClusterIP: corev1.ClusterIPNone,
+			Ports:     servicePorts(opts, 0),
+		},
+	}
+}
+
+// BuildIngress returns the server-ingress routing Options.Paths on each of
+// Options.Hosts to server-svc.
+func BuildIngress(opts Options) *networkingv1.Ingress {
+	opts = opts.withDefaults()
+	var ingressClassName *string
+	if opts.IngressClass != "" {
+		ingressClassName = &opts.IngressClass
+	}
+	var tls []networkingv1.IngressTLS
+	if secret := opts.tlsSecretName(); secret != "" {
+		tls = []networkingv1.IngressTLS{{Hosts: opts.Hosts, SecretName: secret}}
+	}
+	rules := make([]networkingv1.IngressRule, 0, len(opts.Hosts))
+	for _, host := range opts.Hosts {
+		paths := make([]networkingv1.HTTPIngressPath, 0, len(opts.Paths))
+		for _, p := range opts.Paths {
+			paths = append(paths, ingressBackendPath(p, opts.serviceName(), opts.pathPort(p)))
+		}
+		rules = append(rules, networkingv1.IngressRule{
+			Host: host,
+			IngressRuleValue: networkingv1.IngressRuleValue{
+				HTTP: &networkingv1.HTTPIngressRuleValue{Paths: paths},
+			},
+		})
+	}
+	return &networkingv1.Ingress{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "networking.k8s.io/v1", Kind: "Ingress"},
+		ObjectMeta: metav1.ObjectMeta{Name: opts.ingressName(), Namespace: opts.Namespace, Annotations: ingressAnnotations(opts)},
+		Spec: networkingv1.IngressSpec{
+			IngressClassName: ingressClassName,
+			TLS:              tls,
+			Rules:            rules,
+		},
+	}
+}
+
+func ingressBackendPath(p IngressPath, service, port string) networkingv1.HTTPIngressPath {
+	pathType := networkingv1.PathType(p.PathType)
+	return networkingv1.HTTPIngressPath{
+		Path:     p.Path,
+		PathType: &pathType,
+		Backend: networkingv1.IngressBackend{
+			Service: &networkingv1.IngressServiceBackend{
+				Name: service,
+				Port: networkingv1.ServiceBackendPort{Name: port},
+			},
+		},
+	}
+}
+
+// mustToUnstructured converts a typed built-in object. The Build* structs
+// always convert, so a failure is a bug.
+func mustToUnstructured(obj runtime.Object) *unstructured.Unstructured {
+	u, err := toUnstructured(obj)
+	if err != nil {
+		panic(err)
+	}
+	return u
+}
+
+// toUnstructured converts a typed object, dropping the empty statuses,
+// strategy and resources and the null creation timestamps the conversion adds
+// for non-pointer struct fields, down to a cron job's pod template, so they
+// aren't applied.
+func toUnstructured(obj runtime.Object) (*unstructured.Unstructured, error) {
+	m, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return nil, err
+	}
+	u := &unstructured.Unstructured{Object: m}
+	unstructured.RemoveNestedField(u.Object, "status")
+	unstructured.RemoveNestedField(u.Object, "metadata", "creationTimestamp")
+	unstructured.RemoveNestedField(u.Object, "spec", "template", "metadata", "creationTimestamp")
+	unstructured.RemoveNestedField(u.Object, "spec", "jobTemplate", "metadata", "creationTimestamp")
+	unstructured.RemoveNestedField(u.Object, "spec", "jobTemplate", "spec", "template", "metadata", "creationTimestamp")
+	for _, field := range []string{"strategy", "updateStrategy"} {
+		if strategy, _, _ := unstructured.NestedMap(u.Object, "spec", field); len(strategy) == 0 {
+			unstructured.RemoveNestedField(u.Object, "spec", field)
+		}
+	}
+	for _, field := range []string{"initContainers", "containers"} {
+		containers, found, _ := unstructured.NestedSlice(u.Object, "spec", "template", "spec", field)
+		for _, c := range containers {
+			if m, ok := c.(map[string]interface{}); ok {
+				if r, ok := m["resources"].(map[string]interface{}); ok && len(r) == 0 {
+					delete(m, "resources")
+				}
+			}
+		}
+		if found {
+			_ = unstructured.SetNestedSlice(u.Object, containers, "spec", "template", "spec", field)
+		}
+	}
+	claims, found, _ := unstructured.NestedSlice(u.Object, "spec", "volumeClaimTemplates")
+	for _, c := range claims {
+		if m, ok := c.(map[string]interface{}); ok {
+			delete(m, "status")
+			unstructured.RemoveNestedField(m, "metadata", "creationTimestamp")
+		}
+	}
+	if found {
+		_ = unstructured.SetNestedSlice(u.Object, claims, "spec", "volumeClaimTemplates")
+	}
+	return u, nil
+}
+
+// typedPatch sends an apply patch through the typed clientset for the
+// resources it has clients for. handled is false for any other resource,
+// which goes through the dynamic client instead.
+func (d *Deployer) typedPatch(ctx context.Context, gvr schema.GroupVersionResource, name string, data []byte, opts metav1.PatchOptions) (obj *unstructured.Unstructured, handled bool, err error) {
+	switch gvr {
+	case DeploymentResource, StatefulSetResource, ServiceResource, IngressResource, SecretResource, ConfigMapResource, PVCResource:
+	default:
+		return nil, false, nil
+	}
+	if d.kube == nil {
+		return nil, true, errNoClientset
+	}
+
+	var (
+		result runtime.Object
+		gvk    schema.GroupVersionKind
+	)
+	err = d.retry.do(ctx, OpApply, gvr, name, func() (err error) {
+		switch gvr {
+		case DeploymentResource:
+			result, err = d.kube.AppsV1().Deployments(d.opts.Namespace).Patch(ctx, name, types.ApplyPatchType, data, opts)
+			gvk = appsv1.SchemeGroupVersion.WithKind("Deployment")
+		case StatefulSetResource:
+			result, err = d.kube.AppsV1().StatefulSets(d.opts.Namespace).Patch(ctx, name, types.ApplyPatchType, data, opts)
+			gvk = appsv1.SchemeGroupVersion.WithKind("StatefulSet")
+		case ServiceResource:
+			result, err = d.kube.CoreV1().Services(d.opts.Namespace).Patch(ctx, name, types.ApplyPatchType, data, opts)
+			gvk = corev1.SchemeGroupVersion.WithKind("Service")
+		case ConfigMapResource:
+			result, err = d.kube.CoreV1().ConfigMaps(d.opts.Namespace).Patch(ctx, name, types.ApplyPatchType, data, opts)
+			gvk = corev1.SchemeGroupVersion.WithKind("ConfigMap")
+		case PVCResource:
+			result, err = d.kube.CoreV1().PersistentVolumeClaims(d.opts.Namespace).Patch(ctx, name, types.ApplyPatchType, data, opts)
+			gvk = corev1.SchemeGroupVersion.WithKind("PersistentVolumeClaim")
+		case SecretResource:
+			result, err = d.kube.CoreV1().Secrets(d.opts.Namespace).Patch(ctx, name, types.ApplyPatchType, data, opts)
+			gvk = corev1.SchemeGroupVersion.WithKind("Secret")
+		case IngressResource:
+			result, err = d.kube.NetworkingV1().Ingresses(d.opts.Namespace).Patch(ctx, name, types.ApplyPatchType, data, opts)
+			gvk = networkingv1.SchemeGroupVersion.WithKind("Ingress")
+		}
+		return err
+	})
+	if err != nil {
+		return nil, true, err
+	}
+
+	// Objects decoded by the typed clients carry no type meta.
+	m, err := runtime.DefaultUnstructuredConverter.ToUnstructured(result)
+	if err != nil {
+		return nil, true, fmt.Errorf("failed to convert %s %s: %w", gvk.Kind, name, err)
+	}
+	obj = &unstructured.Unstructured{Object: m}
+	obj.SetGroupVersionKind(gvk)
+	return obj, true, nil
+}
@@ -0,0 +1,189 @@
+package deployer
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"reflect"
+	"testing"
+)
+
+func TestBuildDeployment(t *testing.T) {
+	replicas := int32(3)
+	tests := []struct {
+		name         string
+		opts         Options
+		wantName     string
+		wantNS       string
+		wantReplicas int32
+		wantImage    string
+		wantSelector map[string]string
+		wantPorts    []corev1.ContainerPort
+	}{
+		{
+			name:         "defaults",
+			wantName:     "apiserver",
+			wantNS:       DefaultNamespace,
+			wantReplicas: DefaultReplicas,
+			wantImage:    DefaultImage,
+			wantSelector: map[string]string{"app": "server"},
+			wantPorts:    []corev1.ContainerPort{{Name: "http", ContainerPort: 8080, Protocol: corev1.ProtocolTCP}},
+		},
+		{
+			name: "release",
+			opts: Options{
+				Namespace: "shop",
+				Release:   "shop",
+				Image:     "ghcr.io/acme/ecommerce-api:v2.0",
+				Replicas:  &replicas,
+				Ports:     []Port{{Name: "http", ContainerPort: 8080}, {Name: "grpc", ContainerPort: 9090}},
+			},
+			wantName:     "shop-api",
+			wantNS:       "shop",
+			wantReplicas: 3,
+			wantImage:    "ghcr.io/acme/ecommerce-api:v2.0",
+			wantSelector: map[string]string{NameLabel: appName, InstanceLabel: "shop", ComponentLabel: componentAPI},
+			wantPorts: []corev1.ContainerPort{
+				{Name: "http", ContainerPort: 8080, Protocol: corev1.ProtocolTCP},
+				{Name: "grpc", ContainerPort: 9090, Protocol: corev1.ProtocolTCP},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dep := BuildDeployment(tt.opts)
+			if dep.APIVersion != "apps/v1" || dep.Kind != "Deployment" {
+				t.Errorf("got %s %s", dep.APIVersion, dep.Kind)
+			}
+			if dep.Name != tt.wantName || dep.Namespace != tt.wantNS {
+				t.Errorf("got %s/%s, want %s/%s", dep.Namespace, dep.Name, tt.wantNS, tt.wantName)
+			}
+			if dep.Spec.Replicas == nil || *dep.Spec.Replicas != tt.wantReplicas {
+				t.Errorf("got replicas %v, want %d", dep.Spec.Replicas, tt.wantReplicas)
+			}
+			if !reflect.DeepEqual(dep.Spec.Selector.MatchLabels, tt.wantSelector) {
+				t.Errorf("got selector %v, want %v", dep.Spec.Selector.MatchLabels, tt.wantSelector)
+			}
+			for k, v := range dep.Spec.Selector.MatchLabels {
+				if dep.Spec.Template.Labels[k] != v {
+					t.Errorf("the selector %v doesn't select the pod labels %v", dep.Spec.Selector.MatchLabels, dep.Spec.Template.Labels)
+				}
+			}
+			c := findAPIContainer(&dep.Spec.Template.Spec)
+			if c == nil {
+				t.Fatalf("no %s container", apiContainerName)
+			}
+			if c.Image != tt.wantImage {
+				t.Errorf("got image %s, want %s", c.Image, tt.wantImage)
+			}
+			if !reflect.DeepEqual(c.Ports, tt.wantPorts) {
+				t.Errorf("got ports %+v, want %+v", c.Ports, tt.wantPorts)
+			}
+			if c.ReadinessProbe == nil || c.LivenessProbe == nil {
+				t.Error("the API container has no probes")
+			}
+		})
+	}
+}
+
+func TestBuildServices(t *testing.T) {
+	http := corev1.ServicePort{Name: "http", Protocol: corev1.ProtocolTCP, Port: 8080, TargetPort: intstr.FromString("http")}
+	withNodePort := http
+	withNodePort.NodePort = 30080
+	tests := []struct {
+		name      string
+		svc       *corev1.Service
+		wantName  string
+		wantType  corev1.ServiceType
+		wantIP    string
+		wantPorts []corev1.ServicePort
+	}{
+		{"service", BuildService(Options{}), "server-svc", corev1.ServiceTypeClusterIP, "", []corev1.ServicePort{http}},
+		{"node port service type", BuildService(Options{ServiceType: "NodePort", NodePort: 30080}), "server-svc", corev1.ServiceTypeNodePort, "", []corev1.ServicePort{withNodePort}},
+		{"load balancer", BuildService(Options{ServiceType: "LoadBalancer"}), "server-svc", corev1.ServiceTypeLoadBalancer, "", []corev1.ServicePort{http}},
+		{"exposed node port", BuildNodePortService(Options{ExposeNodePort: true, NodePort: 30080}), "nodeport-svc", corev1.ServiceTypeNodePort, "", []corev1.ServicePort{withNodePort}},
+		{"allocated node port", BuildNodePortService(Options{ExposeNodePort: true}), "nodeport-svc", corev1.ServiceTypeNodePort, "", []corev1.ServicePort{http}},
+		{"headless", BuildHeadlessService(Options{HeadlessService: true}), "server-headless", "", corev1.ClusterIPNone, []corev1.ServicePort{http}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.svc.APIVersion != "v1" || tt.svc.Kind != "Service" {
+				t.Errorf("got %s %s", tt.svc.APIVersion, tt.svc.Kind)
+			}
+			if tt.svc.Name != tt.wantName || tt.svc.Namespace != DefaultNamespace {
+				t.Errorf("got %s/%s, want %s/%s", tt.svc.Namespace, tt.svc.Name, DefaultNamespace, tt.wantName)
+			}
+			if tt.svc.Spec.Type != tt.wantType || tt.svc.Spec.ClusterIP != tt.wantIP {
+				t.Errorf("got type %q and clusterIP %q, want %q and %q", tt.svc.Spec.Type, tt.svc.Spec.ClusterIP, tt.wantType, tt.wantIP)
+			}
+			if want := map[string]string{"app": "server"}; !reflect.DeepEqual(tt.svc.Spec.Selector, want) {
+				t.Errorf("got selector %v, want %v", tt.svc.Spec.Selector, want)
+			}
+			if !reflect.DeepEqual(tt.svc.Spec.Ports, tt.wantPorts) {
+				t.Errorf("got ports %+v, want %+v", tt.svc.Spec.Ports, tt.wantPorts)
+			}
+		})
+	}
+}
+
+func TestBuildIngress(t *testing.T) {
+	prefix, exact := networkingv1.PathTypePrefix, networkingv1.PathTypeExact
+	backend := networkingv1.IngressBackend{Service: &networkingv1.IngressServiceBackend{Name: "server-svc", Port: networkingv1.ServiceBackendPort{Name: "http"}}}
+	ing := BuildIngress(Options{
+		Hosts:        []string{"shop.example.com", "*.shop.example.com"},
+		Paths:        []IngressPath{{Path: "/api", PathType: "Prefix"}, {Path: "/healthz", PathType: "Exact"}},
+		IngressClass: "nginx",
+		TLSSecret:    "shop-tls",
+	})
+	paths := []networkingv1.HTTPIngressPath{
+		{Path: "/api", PathType: &prefix, Backend: backend},
+		{Path: "/healthz", PathType: &exact, Backend: backend},
+	}
+	class := "nginx"
+	want := networkingv1.IngressSpec{
+		IngressClassName: &class,
+		TLS:              []networkingv1.IngressTLS{{Hosts: []string{"shop.example.com", "*.shop.example.com"}, SecretName: "shop-tls"}},
+		Rules: []networkingv1.IngressRule{
+			{Host: "shop.example.com", IngressRuleValue: networkingv1.IngressRuleValue{HTTP: &networkingv1.HTTPIngressRuleValue{Paths: paths}}},
+			{Host: "*.shop.example.com", IngressRuleValue: networkingv1.IngressRuleValue{HTTP: &networkingv1.HTTPIngressRuleValue{Paths: paths}}},
+		},
+	}
+	if ing.Name != "server-ingress" || ing.Namespace != DefaultNamespace || ing.Kind != "Ingress" {
+		t.Errorf("got %s %s/%s", ing.Kind, ing.Namespace, ing.Name)
+	}
+	if !reflect.DeepEqual(ing.Spec, want) {
+		t.Errorf("got spec\n%+v\nwant\n%+v", ing.Spec, want)
+	}
+
+	defaults := BuildIngress(Options{})
+	if defaults.Spec.IngressClassName != nil || defaults.Spec.TLS != nil {
+		t.Errorf("got class %v and TLS %v without them", defaults.Spec.IngressClassName, defaults.Spec.TLS)
+	}
+	if len(defaults.Spec.Rules) != 1 || defaults.Spec.Rules[0].Host != DefaultHost {
+		t.Errorf("got rules %+v, want one for %s", defaults.Spec.Rules, DefaultHost)
+	}
+}
+
+// TestBuildersAgree checks the typed constructors build the objects the
+// embedded manifests render to.
+func TestBuildersAgree(t *testing.T) {
+	for name, opts := range map[string]Options{
+		"defaults":   {},
+		"labelled":   labelledOptions("shop", "ghcr.io/acme/ecommerce-api:v2.0"),
+		"restricted": restrictedOptions(),
+		"services":   {ServiceType: "NodePort", NodePort: 30080, ExposeNodePort: true, HeadlessService: true, Hosts: []string{"shop.example.com"}, TLSSecret: "shop-tls"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			opts = opts.withDefaults()
+			typed, rendered := typedBuilder{}.build(opts), yamlBuilder{}.build(opts)
+			if len(typed) != len(rendered) {
+				t.Fatalf("got %d typed objects, %d rendered", len(typed), len(rendered))
+			}
+			for i := range typed {
+				if !reflect.DeepEqual(typed[i].Object, rendered[i].Object) {
+					t.Errorf("%s %s:\ntyped    %v\nrendered %v", typed[i].GetKind(), typed[i].GetName(), typed[i].Object, rendered[i].Object)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,195 @@
+package deployer
+
+import (
+	"context"
+	"fmt"
+	"io"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	"strings"
+	"sync"
+	"time"
+)
+
+// watchRetryInterval is how long Watch waits before reopening a watch the
+// apiserver refused.
+const watchRetryInterval = 2 * time.Second
+
+// watchTarget is a watch of one object, or of the API pods.
+type watchTarget struct {
+	gvr     schema.GroupVersionResource
+	options metav1.ListOptions
+}
+
+// Watch writes a line to out whenever the API deployment, the services,
+// the ingresses or the API pods change, such as
+// "deployment apiserver: 1/2 replicas available" or "pod apiserver-x: Running",
+// until ctx is done. The first lines give the current state. Watches the
+// apiserver closes are reopened from the last resourceVersion seen.
+func (d *Deployer) Watch(ctx context.Context, out io.Writer) error {
+	objects, err := d.objects()
+	if err != nil {
+		return err
+	}
+	apiDep := apiDeployment(d.opts)
+	var targets []watchTarget
+	for _, o := range objects {
+		switch o.obj.GetKind() {
+		case "Deployment":
+			if apiDep == nil || o.obj.GetName() != apiDep.GetName() {
+				continue
+			}
+		case "Service", "Ingress":
+		default:
+			continue
+		}
+		targets = append(targets, watchTarget{gvr: o.gvr, options: metav1.ListOptions{
+			FieldSelector: fields.OneTermEqualSelector("metadata.name", o.obj.GetName()).String(),
+		}})
+	}
+	if apiDep != nil {
+		selector, _, _ := unstructured.NestedStringMap(apiDep.Object, "spec", "selector", "matchLabels")
+		targets = append(targets, watchTarget{gvr: PodResource, options: metav1.ListOptions{
+			LabelSelector: labels.SelectorFromSet(selector).String(),
+		}})
+	}
+
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		last = map[string]string{}
+	)
+	// emit writes line unless it repeats the last one about the object, as
+	// most updates, like a renewed status timestamp, change nothing shown.
+	emit := func(key, line string) {
+		mu.Lock()
+		defer mu.Unlock()
+		if last[key] == line {
+			return
+		}
+		last[key] = line
+		fmt.Fprintln(out, line)
+	}
+	for _, t := range targets {
+		wg.Add(1)
+		go func(t watchTarget) {
+			defer wg.Done()
+			d.watch(ctx, t, emit)
+		}(t)
+	}
+	wg.Wait()
+	return nil
+}
+
+// watch follows t until ctx is done, reopening the watch whenever it ends.
+func (d *Deployer) watch(ctx context.Context, t watchTarget, emit func(key, line string)) {
+	resourceVersion := ""
+	for ctx.Err() == nil {
+		opts := t.options
+		opts.ResourceVersion = resourceVersion
+		opts.AllowWatchBookmarks = true
+		w, err := d.resource(t.gvr).Watch(ctx, opts)
+		if err != nil {
+			if ctx.Err() == nil {
+				d.log.Error(err, "Failed to watch", "resource", t.gvr.String())
+				sleepCtx(ctx, watchRetryInterval)
+			}
+			continue
+		}
+		for ev := range w.ResultChan() {
+			if ev.Type == watch.Error {
+				// An expired resourceVersion can't be resumed from; start
+				// over from the current state.
+				if err := apierrors.FromObject(ev.Object); apierrors.IsGone(err) || apierrors.IsResourceExpired(err) {
+					resourceVersion = ""
+				} else {
+					d.log.Error(err, "Watch failed", "resource", t.gvr.String())
+				}
+				continue
+			}
+			obj, ok := ev.Object.(*unstructured.Unstructured)
+			if !ok {
+				continue
+			}
+			resourceVersion = obj.GetResourceVersion()
+			if ev.Type == watch.Bookmark {
+				continue
+			}
+			key := t.gvr.Resource + "/" + obj.GetName()
+			emit(key, describeChange(ev.Type, obj))
+		}
+		w.Stop()
+	}
+}
+
+// describeChange returns the line Watch prints for a change of obj.
+func describeChange(t watch.EventType, obj *unstructured.Unstructured) string {
+	prefix := strings.ToLower(obj.GetKind()) + " " + obj.GetName() + ": "
+	if t == watch.Deleted {
+		return prefix + "deleted"
+	}
+	switch obj.GetKind() {
+	case "Deployment":
+		status, done := rolloutProgress(obj)
+		line := fmt.Sprintf("%d/%d replicas available, %d updated", status.Available, status.Desired, status.Updated)
+		if done {
+			line += ", rolled out"
+		}
+		return prefix + line
+	case "Service":
+		line := serviceType(obj)
+		if addresses := loadBalancerAddresses(obj); len(addresses) > 0 {
+			line += ", external address " + strings.Join(addresses, ",")
+		} else if line == "LoadBalancer" {
+			line += ", external address pending"
+		}
+		return prefix + line
+	case "Ingress":
+		if addresses := loadBalancerAddresses(obj); len(addresses) > 0 {
+			return prefix + "address " + strings.Join(addresses, ",")
+		}
+		return prefix + "no address yet"
+	case "Pod":
+		var pod corev1.Pod
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, &pod); err != nil {
+			return prefix + "unreadable: " + err.Error()
+		}
+		return prefix + podSummary(pod)
+	}
+	return prefix + string(t)
+}
+
+// podSummary describes the phase of pod, with the reason its containers
+// are waiting or why it is terminating.
+func podSummary(pod corev1.Pod) string {
+	switch {
+	case pod.DeletionTimestamp != nil:
+		return "Terminating"
+	case podReady(pod):
+		return string(pod.Status.Phase) + ", ready"
+	}
+	line := string(pod.Status.Phase)
+	for _, cs := range pod.Status.ContainerStatuses {
+		if cs.State.Waiting != nil && cs.State.Waiting.Reason != "" {
+			line += ", " + cs.Name + " " + cs.State.Waiting.Reason
+		}
+	}
+	return line
+}
+
+// sleepCtx sleeps for d, or until ctx is done.
+func sleepCtx(ctx context.Context, d time.Duration) {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+	case <-t.C:
+	}
+}
@@ -0,0 +1,161 @@
+// Package diff produces unified diffs of small text documents such as
+// rendered manifests.
+package diff
+
+import (
+	"fmt"
+	"strings"
+)
+
+// context is the number of unchanged lines shown around each change.
+const context = 3
+
+type op struct {
+	kind byte // ' ', '-' or '+'
+	line string
+}
+
+// Unified returns the unified diff turning a into b, with fromName and toName
+// in the file headers. It returns "" when a and b are equal.
+func Unified(fromName, toName, a, b string) string {
+	if a == b {
+		return ""
+	}
+	ops := lineOps(splitLines(a), splitLines(b))
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "--- %s\n+++ %s\n", fromName, toName)
+	for _, h := range hunks(ops) {
+		sb.WriteString(h)
+	}
+	return sb.String()
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.SplitAfter(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// lineOps computes an edit script from the longest common subsequence of the
+// two line slices. Manifests are a few hundred lines at most, so the
+// quadratic table is fine.
+func lineOps(a, b []string) []op {
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			switch {
+			case trim(a[i]) == trim(b[j]):
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				lcs[i][j] = lcs[i+1][j]
+			default:
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var ops []op
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case trim(a[i]) == trim(b[j]):
+			ops = append(ops, op{' ', trim(a[i])})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			ops = append(ops, op{'-', trim(a[i])})
+			i++
+		default:
+			ops = append(ops, op{'+', trim(b[j])})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		ops = append(ops, op{'-', trim(a[i])})
+	}
+	for ; j < len(b); j++ {
+		ops = append(ops, op{'+', trim(b[j])})
+	}
+	return ops
+}
+
+func trim(line string) string {
+	return strings.TrimSuffix(line, "\n")
+}
+
+// hunks groups ops into unified diff hunks with their @@ headers.
+func hunks(ops []op) []string {
+	var out []string
+	for start := 0; start < len(ops); {
+		// Find the next change.
+		for start < len(ops) && ops[start].kind == ' ' {
+			start++
+		}
+		if start == len(ops) {
+			break
+		}
+		from := start - context
+		if from < 0 {
+			from = 0
+		}
+		// Extend the hunk while changes are closer than two contexts apart.
+		end := start
+		for end < len(ops) {
+			if ops[end].kind != ' ' {
+				end++
+				continue
+			}
+			run := end
+			for run < len(ops) && ops[run].kind == ' ' {
+				run++
+			}
+			if run == len(ops) || run-end > 2*context {
+				break
+			}
+			end = run
+		}
+		to := end + context
+		if to > len(ops) {
+			to = len(ops)
+		}
+		out = append(out, formatHunk(ops, from, to))
+		start = to
+	}
+	return out
+}
+
+func formatHunk(ops []op, from, to int) string {
+	// Line numbers are 1-based positions in the old and new documents.
+	oldStart, newStart := 1, 1
+	for _, o := range ops[:from] {
+		if o.kind != '+' {
+			oldStart++
+		}
+		if o.kind != '-' {
+			newStart++
+		}
+	}
+	var oldLen, newLen int
+	var body strings.Builder
+	for _, o := range ops[from:to] {
+		if o.kind != '+' {
+			oldLen++
+		}
+		if o.kind != '-' {
+			newLen++
+		}
+		fmt.Fprintf(&body, "%c%s\n", o.kind, o.line)
+	}
+	if oldLen == 0 {
+		oldStart--
+	}
+	if newLen == 0 {
+		newStart--
+	}
+	return fmt.Sprintf("@@ -%d,%d +%d,%d @@\n%s", oldStart, oldLen, newStart, newLen, body.String())
+}
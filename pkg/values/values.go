@@ -0,0 +1,91 @@
+// Package values builds the values map manifest templates are rendered with,
+// from YAML files and key=value overrides in dotted-path notation.
+package values
+
+import (
+	"fmt"
+	"os"
+	"sigs.k8s.io/yaml"
+	"strconv"
+	"strings"
+)
+
+// ReadFile parses a YAML values file. An empty file yields an empty map.
+func ReadFile(path string) (map[string]interface{}, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read values: %w", err)
+	}
+	values := map[string]interface{}{}
+	if err := yaml.Unmarshal(data, &values); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if values == nil {
+		values = map[string]interface{}{}
+	}
+	return values, nil
+}
+
+// Merge copies src into dst, descending into maps present in both so nested
+// keys are merged rather than replaced, and returns dst.
+func Merge(dst, src map[string]interface{}) map[string]interface{} {
+	for k, v := range src {
+		srcMap, srcOK := v.(map[string]interface{})
+		dstMap, dstOK := dst[k].(map[string]interface{})
+		if srcOK && dstOK {
+			dst[k] = Merge(dstMap, srcMap)
+			continue
+		}
+		dst[k] = v
+	}
+	return dst
+}
+
+// Set applies an override such as "ingress.host=shop.example.com" to values,
+// creating intermediate maps as needed. The value is parsed as a bool,
+// integer or null when it looks like one and kept as a string otherwise.
+func Set(values map[string]interface{}, override string) error {
+	eq := strings.Index(override, "=")
+	if eq <= 0 {
+		return fmt.Errorf("%q must be in key=value form", override)
+	}
+	key, raw := override[:eq], override[eq+1:]
+
+	path := strings.Split(key, ".")
+	m := values
+	for i, part := range path[:len(path)-1] {
+		if part == "" {
+			return fmt.Errorf("%q has an empty key segment", override)
+		}
+		next, ok := m[part].(map[string]interface{})
+		if !ok {
+			if _, exists := m[part]; exists {
+				return fmt.Errorf("%q: %s is not a map", override, strings.Join(path[:i+1], "."))
+			}
+			next = map[string]interface{}{}
+			m[part] = next
+		}
+		m = next
+	}
+	last := path[len(path)-1]
+	if last == "" {
+		return fmt.Errorf("%q has an empty key segment", override)
+	}
+	m[last] = parseValue(raw)
+	return nil
+}
+
+func parseValue(raw string) interface{} {
+	switch raw {
+	case "true":
+		return true
+	case "false":
+		return false
+	case "null":
+		return nil
+	}
+	if n, err := strconv.ParseInt(raw, 10, 64); err == nil {
+		return n
+	}
+	return raw
+}
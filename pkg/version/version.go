@@ -0,0 +1,47 @@
+// Package version holds the build metadata of the tool, set at build time
+// with -ldflags, e.g.
+//
+//	go build -ldflags "-X github.com/raihankhan/ecommerceApi-client-go/pkg/version.Version=v1.2.3
+//		-X github.com/raihankhan/ecommerceApi-client-go/pkg/version.Commit=$(git rev-parse HEAD)
+//		-X github.com/raihankhan/ecommerceApi-client-go/pkg/version.Date=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+package version
+
+import (
+	"runtime"
+)
+
+var (
+	// Version is the semantic version of the release, "dev" for builds
+	// outside of one.
+	Version = "dev"
+	// Commit is the git commit the tool was built from.
+	Commit = ""
+	// Date is when the tool was built, in RFC 3339.
+	Date = ""
+)
+
+// Info is the build metadata of the running binary.
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	Date      string `json:"date,omitempty"`
+	GoVersion string `json:"goVersion"`
+	Platform  string `json:"platform"`
+}
+
+// Get returns the build metadata of the running binary.
+func Get() Info {
+	return Info{
+		Version:   Version,
+		Commit:    Commit,
+		Date:      Date,
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+	}
+}
+
+// UserAgent is the user agent the tool's clients identify themselves with
+// in the apiserver audit logs.
+func UserAgent() string {
+	return "ecommerce-deployer/" + Version
+}